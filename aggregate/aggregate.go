@@ -0,0 +1,385 @@
+// Package aggregate computes windowed statistics over the events stream to
+// reduce data volume before shipping: events count per process, top image
+// names, number of distinct remote addresses, etc.
+//
+// Events are passed to the Aggregator as flat or nested property maps and
+// windows are driven by event timestamps, so the same code works for both
+// real-time sessions and .etl files replay:
+//
+//		a, err := aggregate.New([]aggregate.Spec{
+//			{Name: "events_per_pid", Kind: aggregate.Count, GroupBy: "Header.ProcessID"},
+//			{Name: "top_images", Kind: aggregate.TopK, Field: "Properties.ImageName", K: 10},
+//		}, func(s aggregate.Summary) {
+//			log.Printf("%s [%s, %s): %v %v", s.Name, s.Start, s.End, s.Groups, s.Top)
+//		}, aggregate.WithWindow(time.Minute))
+//
+package aggregate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is a kind of the computed statistics.
+type Kind int
+
+const (
+	// Count counts events in every GroupBy group.
+	Count Kind = iota + 1
+	// Distinct counts distinct Field values in every GroupBy group.
+	Distinct
+	// TopK finds K most frequent Field values.
+	TopK
+)
+
+// Spec describes a single statistics computed over every window.
+type Spec struct {
+	// Name identifies the statistics in Summary.
+	Name string
+
+	Kind Kind
+
+	// GroupBy is a field to group events by for Count and Distinct. Empty
+	// GroupBy puts all events into a single "" group. Fields of nested maps
+	// are addressed with dots, e.g. `Properties.struct.field`.
+	GroupBy string
+
+	// Field is a field whose values are counted by Distinct and TopK.
+	Field string
+
+	// K is a number of entries reported by TopK.
+	K int
+}
+
+func (s Spec) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("empty name")
+	}
+	switch s.Kind {
+	case Count:
+	case Distinct:
+		if s.Field == "" {
+			return fmt.Errorf("%q: Distinct requires Field", s.Name)
+		}
+	case TopK:
+		if s.Field == "" || s.K <= 0 {
+			return fmt.Errorf("%q: TopK requires Field and positive K", s.Name)
+		}
+		if s.GroupBy != "" {
+			return fmt.Errorf("%q: TopK doesn't support GroupBy", s.Name)
+		}
+	default:
+		return fmt.Errorf("%q: unknown kind %d", s.Name, s.Kind)
+	}
+	return nil
+}
+
+// Summary is a statistics computed over a single window.
+type Summary struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+
+	// Groups is set for Count and Distinct.
+	Groups map[string]uint64 `json:",omitempty"`
+
+	// Top is set for TopK in the descending order of counts.
+	Top []Entry `json:",omitempty"`
+}
+
+// Entry is a single TopK value.
+type Entry struct {
+	Value string
+	Count uint64
+}
+
+// Options configure an Aggregator.
+type Options struct {
+	// Window is a window length. Default is 1 minute.
+	Window time.Duration
+
+	// Slide is a distance between starts of subsequent windows. Window
+	// should be a multiple of Slide. Zero Slide means tumbling
+	// (non-overlapping) windows.
+	Slide time.Duration
+}
+
+// Option is any function that modifies Options.
+type Option func(cfg *Options)
+
+// WithWindow sets the window length.
+func WithWindow(window time.Duration) Option {
+	return func(cfg *Options) {
+		cfg.Window = window
+	}
+}
+
+// WithSlide makes windows overlap: a new window starts every @slide and
+// contains events of the last Window.
+func WithSlide(slide time.Duration) Option {
+	return func(cfg *Options) {
+		cfg.Slide = slide
+	}
+}
+
+// Aggregator computes statistics over windows of the events stream. It's
+// safe for concurrent use.
+type Aggregator struct {
+	specs   []Spec
+	cb      func(Summary)
+	step    int64 // Bucket length in nanoseconds.
+	buckets int64 // Number of buckets in a window.
+
+	mu      sync.Mutex
+	started bool
+	cur     int64 // Index of the newest bucket.
+	data    map[int64][]counters
+	late    uint64
+}
+
+// counters of a single spec in a single bucket: group -> value -> count.
+type counters map[string]map[string]uint64
+
+// New creates an Aggregator that computes @specs over the windows and passes
+// every non-empty window summary to @cb. @cb is called synchronously from
+// Observe, Tick or Flush.
+func New(specs []Spec, cb func(Summary), options ...Option) (*Aggregator, error) {
+	cfg := Options{Window: time.Minute}
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	if cfg.Slide == 0 {
+		cfg.Slide = cfg.Window
+	}
+	if cfg.Window <= 0 || cfg.Slide <= 0 || cfg.Window%cfg.Slide != 0 {
+		return nil, fmt.Errorf("window %s should be a positive multiple of slide %s", cfg.Window, cfg.Slide)
+	}
+
+	names := make(map[string]bool, len(specs))
+	for _, s := range specs {
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("invalid spec; %w", err)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate spec name %q", s.Name)
+		}
+		names[s.Name] = true
+	}
+
+	return &Aggregator{
+		specs:   specs,
+		cb:      cb,
+		step:    int64(cfg.Slide),
+		buckets: int64(cfg.Window / cfg.Slide),
+		data:    make(map[int64][]counters),
+	}, nil
+}
+
+// Observe accounts an event with timestamp @ts and @fields. Windows ended
+// before @ts are reported. Events older than the oldest open window are
+// dropped and counted by Late.
+func (a *Aggregator) Observe(ts time.Time, fields map[string]interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	b := a.bucket(ts)
+	a.advance(b)
+	if b <= a.cur-a.buckets {
+		a.late++
+		return
+	}
+
+	bucket, ok := a.data[b]
+	if !ok {
+		bucket = make([]counters, len(a.specs))
+		for i := range bucket {
+			bucket[i] = make(counters)
+		}
+		a.data[b] = bucket
+	}
+	for i, s := range a.specs {
+		group := ""
+		if s.GroupBy != "" {
+			if group, ok = lookup(fields, s.GroupBy); !ok {
+				continue
+			}
+		}
+		value := ""
+		if s.Kind != Count {
+			if value, ok = lookup(fields, s.Field); !ok {
+				continue
+			}
+		}
+		values, ok := bucket[i][group]
+		if !ok {
+			values = make(map[string]uint64)
+			bucket[i][group] = values
+		}
+		values[value]++
+	}
+}
+
+// Tick reports windows ended before @now. Use it with a wall-clock ticker to
+// get summaries of quiet real-time streams in time.
+func (a *Aggregator) Tick(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.advance(a.bucket(now))
+}
+
+// Flush reports all open windows (even if they are not ended yet) and resets
+// the Aggregator.
+func (a *Aggregator) Flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.started {
+		return
+	}
+	a.advance(a.cur + a.buckets)
+	a.started = false
+	a.data = make(map[int64][]counters)
+}
+
+// Late returns a number of events dropped because their windows were already
+// reported.
+func (a *Aggregator) Late() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.late
+}
+
+func (a *Aggregator) bucket(ts time.Time) int64 {
+	ns := ts.UnixNano()
+	b := ns / a.step
+	if ns < 0 && ns%a.step != 0 {
+		b-- // Floor division.
+	}
+	return b
+}
+
+// advance moves the newest bucket to @b reporting windows that end not later
+// than its start.
+func (a *Aggregator) advance(b int64) {
+	if !a.started {
+		a.started = true
+		a.cur = b
+		return
+	}
+	if b <= a.cur {
+		return
+	}
+
+	// Windows ending later than cur+buckets contain no buckets seen so far.
+	last := b
+	if last > a.cur+a.buckets {
+		last = a.cur + a.buckets
+	}
+	for end := a.cur + 1; end <= last; end++ {
+		a.report(end)
+	}
+
+	a.cur = b
+	for idx := range a.data {
+		if idx <= a.cur-a.buckets {
+			delete(a.data, idx)
+		}
+	}
+}
+
+// report passes summaries of the window ending at the start of the bucket
+// @end to the callback.
+func (a *Aggregator) report(end int64) {
+	var window [][]counters
+	for idx := end - a.buckets; idx < end; idx++ {
+		if bucket, ok := a.data[idx]; ok {
+			window = append(window, bucket)
+		}
+	}
+	if len(window) == 0 {
+		return
+	}
+
+	start := time.Unix(0, (end-a.buckets)*a.step).UTC()
+	finish := time.Unix(0, end*a.step).UTC()
+	for i, s := range a.specs {
+		merged := make(counters)
+		for _, bucket := range window {
+			for group, values := range bucket[i] {
+				m, ok := merged[group]
+				if !ok {
+					m = make(map[string]uint64)
+					merged[group] = m
+				}
+				for v, n := range values {
+					m[v] += n
+				}
+			}
+		}
+		if len(merged) == 0 {
+			continue
+		}
+		summary := Summary{Name: s.Name, Start: start, End: finish}
+		switch s.Kind {
+		case Count:
+			summary.Groups = make(map[string]uint64, len(merged))
+			for group, values := range merged {
+				summary.Groups[group] = values[""]
+			}
+		case Distinct:
+			summary.Groups = make(map[string]uint64, len(merged))
+			for group, values := range merged {
+				summary.Groups[group] = uint64(len(values))
+			}
+		case TopK:
+			summary.Top = top(merged[""], s.K)
+		}
+		a.cb(summary)
+	}
+}
+
+// top returns @k most frequent values. Ties are ordered by value.
+func top(values map[string]uint64, k int) []Entry {
+	entries := make([]Entry, 0, len(values))
+	for v, n := range values {
+		entries = append(entries, Entry{Value: v, Count: n})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Value < entries[j].Value
+	})
+	if len(entries) > k {
+		entries = entries[:k]
+	}
+	return entries
+}
+
+// needsProperties returns true if any spec refers to event properties.
+func (a *Aggregator) needsProperties() bool {
+	for _, s := range a.specs {
+		if strings.HasPrefix(s.GroupBy, "Properties.") || strings.HasPrefix(s.Field, "Properties.") {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns a string representation of the (possibly nested) field
+// @name of @fields.
+func lookup(fields map[string]interface{}, name string) (string, bool) {
+	var cur interface{} = fields
+	for _, part := range strings.Split(name, ".") {
+		container, ok := cur.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if cur, ok = container[part]; !ok {
+			return "", false
+		}
+	}
+	return fmt.Sprint(cur), true
+}
@@ -0,0 +1,110 @@
+package aggregate_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/aggregate"
+)
+
+var base = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func event(pid uint32, image, ip string) map[string]interface{} {
+	return map[string]interface{}{
+		"Header":     map[string]interface{}{"ProcessID": pid},
+		"Properties": map[string]interface{}{"ImageName": image, "RemoteIP": ip},
+	}
+}
+
+func TestTumbling(t *testing.T) {
+	var summaries []aggregate.Summary
+	a, err := aggregate.New([]aggregate.Spec{
+		{Name: "per_pid", Kind: aggregate.Count, GroupBy: "Header.ProcessID"},
+		{Name: "distinct_ips", Kind: aggregate.Distinct, Field: "Properties.RemoteIP"},
+		{Name: "top_images", Kind: aggregate.TopK, Field: "Properties.ImageName", K: 2},
+	}, func(s aggregate.Summary) {
+		summaries = append(summaries, s)
+	}, aggregate.WithWindow(time.Minute))
+	require.NoError(t, err, "Failed to create aggregator")
+
+	a.Observe(base, event(1, "a.exe", "10.0.0.1"))
+	a.Observe(base.Add(10*time.Second), event(1, "b.exe", "10.0.0.1"))
+	a.Observe(base.Add(20*time.Second), event(2, "b.exe", "10.0.0.2"))
+	a.Observe(base.Add(30*time.Second), event(3, "c.exe", "10.0.0.3"))
+	require.Empty(t, summaries, "Unexpected summaries of an open window")
+
+	// The next window closes the first one.
+	a.Observe(base.Add(90*time.Second), event(1, "a.exe", "10.0.0.1"))
+	require.Len(t, summaries, 3)
+	for _, s := range summaries {
+		assert.Equal(t, base, s.Start)
+		assert.Equal(t, base.Add(time.Minute), s.End)
+	}
+	assert.Equal(t, map[string]uint64{"1": 2, "2": 1, "3": 1}, summaries[0].Groups)
+	assert.Equal(t, map[string]uint64{"": 3}, summaries[1].Groups)
+	assert.Equal(t, []aggregate.Entry{{"b.exe", 2}, {"a.exe", 1}}, summaries[2].Top)
+
+	// Late events are dropped.
+	a.Observe(base.Add(time.Second), event(1, "a.exe", "10.0.0.1"))
+	assert.Equal(t, uint64(1), a.Late())
+
+	summaries = nil
+	a.Flush()
+	require.Len(t, summaries, 3)
+	assert.Equal(t, base.Add(time.Minute), summaries[0].Start)
+	assert.Equal(t, map[string]uint64{"1": 1}, summaries[0].Groups)
+}
+
+func TestSliding(t *testing.T) {
+	var summaries []aggregate.Summary
+	a, err := aggregate.New([]aggregate.Spec{
+		{Name: "total", Kind: aggregate.Count},
+	}, func(s aggregate.Summary) {
+		summaries = append(summaries, s)
+	}, aggregate.WithWindow(time.Minute), aggregate.WithSlide(30*time.Second))
+	require.NoError(t, err, "Failed to create aggregator")
+
+	a.Observe(base, event(1, "a.exe", ""))
+	a.Observe(base.Add(40*time.Second), event(1, "a.exe", ""))
+	a.Tick(base.Add(2 * time.Minute))
+
+	require.Len(t, summaries, 3)
+	expected := []struct {
+		start time.Duration
+		count uint64
+	}{
+		{-30 * time.Second, 1},
+		{0, 2},
+		{30 * time.Second, 1},
+	}
+	for i, e := range expected {
+		assert.Equal(t, base.Add(e.start), summaries[i].Start, "Unexpected start of window %d", i)
+		assert.Equal(t, map[string]uint64{"": e.count}, summaries[i].Groups, "Unexpected count of window %d", i)
+	}
+
+	// Nothing is left after Tick.
+	summaries = nil
+	a.Flush()
+	assert.Empty(t, summaries)
+}
+
+func TestInvalidSpecs(t *testing.T) {
+	cb := func(aggregate.Summary) {}
+	for _, specs := range [][]aggregate.Spec{
+		{{Kind: aggregate.Count}},
+		{{Name: "a", Kind: aggregate.Distinct}},
+		{{Name: "a", Kind: aggregate.TopK, Field: "f"}},
+		{{Name: "a", Kind: aggregate.TopK, Field: "f", K: 1, GroupBy: "g"}},
+		{{Name: "a", Kind: aggregate.Count}, {Name: "a", Kind: aggregate.Count}},
+		{{Name: "a"}},
+	} {
+		_, err := aggregate.New(specs, cb)
+		assert.Error(t, err, "Expected an error for specs %v", specs)
+	}
+
+	_, err := aggregate.New(nil, cb, aggregate.WithWindow(time.Minute), aggregate.WithSlide(time.Hour))
+	assert.Error(t, err, "Expected an error for slide longer than window")
+}
@@ -0,0 +1,49 @@
+//+build windows
+
+package aggregate
+
+import (
+	"github.com/bi-zone/etw"
+)
+
+// ObserveEvent accounts @e. Header fields are available as `Header.<name>`
+// (e.g. `Header.ProcessID`), event properties as `Properties.<name>`.
+// Properties are parsed only if some spec refers to them. Like
+// etw.Event.EventProperties it's valid only inside etw.EventCallback.
+func (a *Aggregator) ObserveEvent(e *etw.Event, options ...etw.ParseOption) error {
+	h := e.Header
+	fields := map[string]interface{}{
+		"Header": map[string]interface{}{
+			"ID":         h.ID,
+			"Version":    h.Version,
+			"Channel":    h.Channel,
+			"Level":      h.Level,
+			"OpCode":     h.OpCode,
+			"Task":       h.Task,
+			"Keyword":    h.Keyword,
+			"ProcessID":  h.ProcessID,
+			"ThreadID":   h.ThreadID,
+			"ProviderID": h.ProviderID.String(),
+		},
+	}
+	if a.needsProperties() {
+		props, err := e.EventProperties(options...)
+		if err != nil {
+			return err
+		}
+		fields["Properties"] = props
+	}
+	a.Observe(h.TimeStamp, fields)
+	return nil
+}
+
+// EventCallback returns an etw.EventCallback that passes every event to
+// ObserveEvent. Event properties parsing errors are passed to @onError if
+// it's not nil.
+func (a *Aggregator) EventCallback(onError func(err error)) etw.EventCallback {
+	return func(e *etw.Event) {
+		if err := a.ObserveEvent(e); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+//+build windows
+
+package etw
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// Types of binary properties.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/tdh/ne-tdh-_tdh_in_type
+const (
+	tdhInTypeBinary     = 14 // TDH_INTYPE_BINARY
+	tdhOutTypeNull      = 0  // TDH_OUTTYPE_NULL
+	tdhOutTypeHexBinary = 15 // TDH_OUTTYPE_HEXBINARY
+)
+
+// binaryTDHFormatPrefix prefixes binary values formatted by TDH.
+const binaryTDHFormatPrefix = "0x"
+
+// BinaryEncoding defines how EventProperties returns binary properties.
+type BinaryEncoding int
+
+const (
+	// BinaryTDH is a hex string prefixed with "0x" as TDH formats it, e.g.
+	// "0x0102". That's the default.
+	BinaryTDH BinaryEncoding = iota
+
+	// BinaryBytes is a []byte value.
+	BinaryBytes
+
+	// BinaryBase64 is a string in the standard base64 encoding, e.g. "AQI=".
+	BinaryBase64
+
+	// BinaryHex is a lowercase hex string with no prefix, e.g. "0102".
+	BinaryHex
+)
+
+// BinaryFormat describes how EventProperties returns binary properties.
+// Binaries typed as IP addresses, socket addresses and so on are formatted
+// by TDH as usual.
+type BinaryFormat struct {
+	Encoding BinaryEncoding
+
+	// MaxSize truncates values to MaxSize bytes before the encoding. The
+	// truncation is silent, so don't use it for values that are decoded
+	// back. Zero means no limit.
+	MaxSize int
+}
+
+// WithBinaryFormat makes EventProperties return binary properties in the
+// given @format unless WithPropertyBinaryFormat overrides it.
+func WithBinaryFormat(format BinaryFormat) ParseOption {
+	return func(cfg *ParseOptions) {
+		cfg.Binary = format
+	}
+}
+
+// WithPropertyBinaryFormat makes EventProperties return binary properties
+// named @property (at any structure nesting level) in the given @format.
+func WithPropertyBinaryFormat(property string, format BinaryFormat) ParseOption {
+	return func(cfg *ParseOptions) {
+		if cfg.BinaryProperties == nil {
+			cfg.BinaryProperties = make(map[string]BinaryFormat)
+		}
+		cfg.BinaryProperties[property] = format
+	}
+}
+
+// isBinaryBlob returns true if the @i-th property is a blob TDH formats as
+// a hex string.
+func (p *propertyParser) isBinaryBlob(i int) bool {
+	property := &p.plan.properties[i]
+	if property.inType != tdhInTypeBinary && property.inType != tdhInTypeCountedBinary {
+		return false
+	}
+	return property.outType == tdhOutTypeNull || property.outType == tdhOutTypeHexBinary
+}
+
+// binaryFormat returns a format of the @i-th property if it's a binary blob
+// that should be returned not the way TDH formats it.
+func (p *propertyParser) binaryFormat(i int) (BinaryFormat, bool) {
+	if p.binary == (BinaryFormat{}) && len(p.binaryProperties) == 0 {
+		return BinaryFormat{}, false
+	}
+	if !p.isBinaryBlob(i) {
+		return BinaryFormat{}, false
+	}
+	format, ok := p.binaryProperties[p.plan.properties[i].name]
+	if !ok {
+		format = p.binary
+	}
+	return format, format != (BinaryFormat{})
+}
+
+// parseBinary parses the @i-th binary property and encodes it with @format.
+func (p *propertyParser) parseBinary(i int, format BinaryFormat) (interface{}, error) {
+	formatted, err := p.parseSimpleType(i)
+	if err != nil {
+		return nil, err
+	}
+	return encodeBinary(formatted, format), nil
+}
+
+// encodeBinary converts a binary value @formatted by TDH to the given
+// @format. Values TDH failed to format as hex are returned as is.
+func encodeBinary(formatted string, format BinaryFormat) interface{} {
+	value, err := hex.DecodeString(strings.TrimPrefix(formatted, binaryTDHFormatPrefix))
+	if err != nil {
+		return formatted
+	}
+	if format.MaxSize > 0 && len(value) > format.MaxSize {
+		value = value[:format.MaxSize]
+	}
+	switch format.Encoding {
+	case BinaryBytes:
+		return value
+	case BinaryBase64:
+		return base64.StdEncoding.EncodeToString(value)
+	case BinaryHex:
+		return hex.EncodeToString(value)
+	default:
+		return binaryTDHFormatPrefix + strings.ToUpper(hex.EncodeToString(value))
+	}
+}
@@ -0,0 +1,237 @@
+//+build windows
+
+package etw
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultSubscriberQueueSize is a default SubscriberOptions.QueueSize.
+const defaultSubscriberQueueSize = 1024
+
+// Broker owns a Session and fans its events out to in-process subscribers.
+// Every subscriber has its own filter, parse options, queue and goroutine,
+// so a slow subscriber only loses its own events and never delays others
+// or the session:
+//
+//		broker := etw.NewBroker(session)
+//		defer broker.Close()
+//		broker.Subscribe(handleProcesses, etw.WithSubscriberFilter(processes.Matches))
+//		broker.Subscribe(handleAll, etw.WithQueueSize(10000))
+//		err := broker.Process()
+//
+// Subscribers could be added and removed while the broker is processing.
+type Broker struct {
+	session *Session
+
+	// mu guards subscribers: dispatching holds it for reading to send to
+	// the queues that are closed under the write lock.
+	mu          sync.RWMutex
+	subscribers map[*Subscriber]struct{}
+	closed      bool
+}
+
+// SubscriberOptions describes a Broker subscriber.
+type SubscriberOptions struct {
+	// Filter selects events passed to the subscriber. Nil Filter passes
+	// all the session events. Filter is called in the session processing
+	// goroutine, so it should be fast.
+	Filter func(h *EventHeader) bool
+
+	// QueueSize is a number of events queued for the subscriber. Events
+	// that don't fit the queue are dropped. 1024 by default.
+	QueueSize int
+
+	// ParseOptions are options events are parsed with for the subscriber.
+	ParseOptions []ParseOption
+}
+
+// SubscriberOption is any function that modifies SubscriberOptions.
+type SubscriberOption func(cfg *SubscriberOptions)
+
+// WithSubscriberFilter passes to the subscriber only events @filter returns
+// true for, e.g. Subscription.Matches.
+func WithSubscriberFilter(filter func(h *EventHeader) bool) SubscriberOption {
+	return func(cfg *SubscriberOptions) {
+		cfg.Filter = filter
+	}
+}
+
+// WithQueueSize queues at most @n events for the subscriber.
+func WithQueueSize(n int) SubscriberOption {
+	return func(cfg *SubscriberOptions) {
+		cfg.QueueSize = n
+	}
+}
+
+// WithSubscriberParseOptions parses events for the subscriber with @options.
+func WithSubscriberParseOptions(options ...ParseOption) SubscriberOption {
+	return func(cfg *SubscriberOptions) {
+		cfg.ParseOptions = append(cfg.ParseOptions, options...)
+	}
+}
+
+// SubscriberStats are counters of a Broker subscriber.
+type SubscriberStats struct {
+	Matched   uint64 // Events passed the subscriber filter.
+	Dropped   uint64 // Matched events dropped because the queue was full.
+	Delivered uint64 // Events the subscriber callback has returned for.
+	Queued    int    // Events waiting in the queue now.
+}
+
+// Subscriber is a consumer of Broker events.
+type Subscriber struct {
+	broker   *Broker
+	filter   func(h *EventHeader) bool
+	parseCfg ParseOptions
+	cb       func(e *ParsedEvent)
+	queue    chan *ParsedEvent
+	done     chan struct{}
+
+	matched   uint64
+	dropped   uint64
+	delivered uint64
+}
+
+// NewBroker creates a Broker of @session. The broker owns the session from
+// now on: `.Close` closes it.
+func NewBroker(session *Session) *Broker {
+	return &Broker{
+		session:     session,
+		subscribers: make(map[*Subscriber]struct{}),
+	}
+}
+
+// Session returns the broker session, e.g. to add providers.
+func (b *Broker) Session() *Session {
+	return b.session
+}
+
+// Subscribe registers @cb to receive the session events selected by
+// @options. @cb is called sequentially from the subscriber own goroutine.
+// The event is released once @cb returns, so don't keep it.
+func (b *Broker) Subscribe(cb func(e *ParsedEvent), options ...SubscriberOption) (*Subscriber, error) {
+	cfg := SubscriberOptions{QueueSize: defaultSubscriberQueueSize}
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	if cfg.QueueSize <= 0 {
+		return nil, fmt.Errorf("invalid subscriber queue size %d", cfg.QueueSize)
+	}
+	s := &Subscriber{
+		broker: b,
+		filter: cfg.Filter,
+		cb:     cb,
+		queue:  make(chan *ParsedEvent, cfg.QueueSize),
+		done:   make(chan struct{}),
+	}
+	for _, opt := range cfg.ParseOptions {
+		opt(&s.parseCfg)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, fmt.Errorf("broker is closed")
+	}
+	b.subscribers[s] = struct{}{}
+	go s.run()
+	return s, nil
+}
+
+// Process processes the session events dispatching them to subscribers.
+// Like Session.Process it blocks until `.Close` being called.
+func (b *Broker) Process() error {
+	return b.session.Process(b.dispatch)
+}
+
+// Close closes the session and stops all the subscribers after they handle
+// already queued events.
+func (b *Broker) Close() error {
+	err := b.session.Close()
+
+	b.mu.Lock()
+	b.closed = true
+	subscribers := b.subscribers
+	b.subscribers = make(map[*Subscriber]struct{})
+	for s := range subscribers {
+		close(s.queue)
+	}
+	b.mu.Unlock()
+
+	for s := range subscribers {
+		<-s.done
+	}
+	if err != nil {
+		return fmt.Errorf("failed to close broker session; %w", err)
+	}
+	return nil
+}
+
+// dispatch is an EventCallback queueing @e to the matching subscribers.
+func (b *Broker) dispatch(e *Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subscribers {
+		s.offer(e)
+	}
+}
+
+// offer queues a copy of @e if it matches the subscriber filter and the
+// queue has room for it.
+func (s *Subscriber) offer(e *Event) {
+	if s.filter != nil && !s.filter(&e.Header) {
+		return
+	}
+	atomic.AddUint64(&s.matched, 1)
+	// Don't parse events that would be dropped anyway.
+	if len(s.queue) == cap(s.queue) {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+	pe := parsedEventPool.Get().(*ParsedEvent)
+	pe.fill(e, s.parseCfg, "Broker")
+	select {
+	case s.queue <- pe:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		pe.Release()
+	}
+}
+
+// run passes queued events to the subscriber callback until the queue is
+// closed.
+func (s *Subscriber) run() {
+	defer close(s.done)
+	for pe := range s.queue {
+		s.cb(pe)
+		pe.Release()
+		atomic.AddUint64(&s.delivered, 1)
+	}
+}
+
+// Stats returns the subscriber counters.
+func (s *Subscriber) Stats() SubscriberStats {
+	return SubscriberStats{
+		Matched:   atomic.LoadUint64(&s.matched),
+		Dropped:   atomic.LoadUint64(&s.dropped),
+		Delivered: atomic.LoadUint64(&s.delivered),
+		Queued:    len(s.queue),
+	}
+}
+
+// Unsubscribe stops passing events to the subscriber and waits for it to
+// handle already queued ones. Don't call it from the subscriber callback.
+func (s *Subscriber) Unsubscribe() {
+	b := s.broker
+	b.mu.Lock()
+	_, ok := b.subscribers[s]
+	if ok {
+		delete(b.subscribers, s)
+		close(s.queue)
+	}
+	b.mu.Unlock()
+	<-s.done
+}
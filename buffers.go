@@ -0,0 +1,126 @@
+//+build windows
+
+package etw
+
+import (
+	"math"
+	"runtime"
+	"time"
+)
+
+// eventTraceUseMSFlushTimer makes EVENT_TRACE_PROPERTIES.FlushTimer be in
+// milliseconds (EVENT_TRACE_USE_MS_FLUSH_TIMER). Windows 8 and later.
+const eventTraceUseMSFlushTimer = 0x00000010
+
+// EstimateBuffers tuning. The values follow the usual ETW sizing advice:
+// buffers should hold a dozen of events at least, 64KB ones suit high event
+// rates, every processor needs a couple of buffers and full buffers should
+// survive a short consumer stall.
+const (
+	eventOverhead         = 80   // Approximate in-buffer event header size, bytes.
+	minEstimateBufferSize = 8    // KB.
+	maxEstimateBufferSize = 1024 // KB, the ETW maximum.
+	highRateBufferSize    = 64   // KB, for rates over highRateThroughput.
+	highRateThroughput    = 1 << 20
+	eventsPerBuffer       = 16
+	buffersPerProcessor   = 2
+	estimateStallTime     = 2 * time.Second
+	maxEstimateMemory     = 512 << 20 // Bytes of all the session buffers.
+	estimateFlushTimer    = time.Second
+)
+
+// BufferEstimate is a recommended session buffers configuration returned by
+// EstimateBuffers.
+type BufferEstimate struct {
+	BufferSize     uint32 // In kilobytes.
+	MinimumBuffers uint32
+	MaximumBuffers uint32
+	FlushTimer     time.Duration
+
+	// Throughput is the expected event data rate in bytes per second and
+	// StallTolerance is how long MaximumBuffers could absorb it while the
+	// consumer is stalled. Events are lost after that.
+	Throughput     float64
+	StallTolerance time.Duration
+}
+
+// EstimateBuffers recommends buffer settings of a session receiving
+// @eventsPerSec events of @avgEventSize bytes of payload on average. The
+// recommendation accounts the number of processors of the running system.
+//
+// Apply the result with `.Option()`:
+//
+//		estimate := etw.EstimateBuffers(5000, 300)
+//		session, err := etw.NewSession(guid, estimate.Option())
+func EstimateBuffers(eventsPerSec float64, avgEventSize uint32) BufferEstimate {
+	return estimateBuffers(eventsPerSec, avgEventSize, runtime.NumCPU())
+}
+
+// estimateBuffers implements EstimateBuffers for @cpus processors.
+func estimateBuffers(eventsPerSec float64, avgEventSize uint32, cpus int) BufferEstimate {
+	if eventsPerSec < 0 {
+		eventsPerSec = 0
+	}
+	eventSize := float64((uint64(avgEventSize) + eventOverhead + 7) &^ 7)
+	throughput := eventsPerSec * eventSize
+
+	// The smallest power of two holding enough events.
+	bufferSize := uint32(minEstimateBufferSize)
+	for float64(bufferSize)*1024 < eventSize*eventsPerBuffer && bufferSize < maxEstimateBufferSize {
+		bufferSize *= 2
+	}
+	if throughput >= highRateThroughput && bufferSize < highRateBufferSize {
+		bufferSize = highRateBufferSize
+	}
+	bufferBytes := float64(bufferSize) * 1024
+
+	minBuffers := uint32(buffersPerProcessor * cpus)
+	stallBuffers := uint32(math.Ceil(throughput * estimateStallTime.Seconds() / bufferBytes))
+	maxBuffers := minBuffers + stallBuffers
+	if maxBuffers < 2*minBuffers {
+		maxBuffers = 2 * minBuffers
+	}
+	if limit := uint32(maxEstimateMemory / bufferBytes); maxBuffers > limit {
+		maxBuffers = limit
+	}
+	if maxBuffers < minBuffers {
+		maxBuffers = minBuffers
+	}
+
+	estimate := BufferEstimate{
+		BufferSize:     bufferSize,
+		MinimumBuffers: minBuffers,
+		MaximumBuffers: maxBuffers,
+		FlushTimer:     estimateFlushTimer,
+		Throughput:     throughput,
+		StallTolerance: time.Duration(math.MaxInt64),
+	}
+	if throughput > 0 {
+		// Per-processor buffers can't take the load of others.
+		spare := float64(maxBuffers - minBuffers + uint32(cpus))
+		estimate.StallTolerance = time.Duration(spare * bufferBytes / throughput * float64(time.Second))
+	}
+	return estimate
+}
+
+// Option returns an Option applying the estimate to SessionOptions.
+func (e BufferEstimate) Option() Option {
+	return func(cfg *SessionOptions) {
+		cfg.BufferSize = e.BufferSize
+		cfg.MinimumBuffers = e.MinimumBuffers
+		cfg.MaximumBuffers = e.MaximumBuffers
+		cfg.FlushTimer = e.FlushTimer
+	}
+}
+
+// flushTimerValue converts @period to EVENT_TRACE_PROPERTIES.FlushTimer.
+// @ms is true if the value is in milliseconds.
+func flushTimerValue(period time.Duration) (value uint32, ms bool) {
+	if period <= 0 {
+		return 0, false
+	}
+	if period%time.Second != 0 && Capabilities().OSVersion.AtLeast(windows8) {
+		return uint32((period + time.Millisecond - 1) / time.Millisecond), true
+	}
+	return uint32((period + time.Second - 1) / time.Second), false
+}
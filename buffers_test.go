@@ -0,0 +1,36 @@
+// +build windows
+
+package etw
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateBuffers(t *testing.T) {
+	// A quiet provider gets small buffers flushed every second.
+	quiet := estimateBuffers(10, 200, 4)
+	assert.Equal(t, uint32(8), quiet.BufferSize)
+	assert.Equal(t, uint32(8), quiet.MinimumBuffers)
+	assert.Equal(t, uint32(16), quiet.MaximumBuffers)
+	assert.Equal(t, time.Second, quiet.FlushTimer)
+
+	// A chatty one gets 64KB buffers enough to survive a consumer stall.
+	chatty := estimateBuffers(50000, 300, 4)
+	assert.Equal(t, uint32(64), chatty.BufferSize)
+	assert.Equal(t, uint32(8), chatty.MinimumBuffers)
+	assert.True(t, chatty.MaximumBuffers > 2*chatty.MinimumBuffers, "No buffers for stalls")
+	assert.True(t, chatty.StallTolerance >= estimateStallTime, "Stall tolerance %s is too short", chatty.StallTolerance)
+
+	// Large events need large buffers, total memory is limited.
+	huge := estimateBuffers(1e6, 60000, 4)
+	assert.Equal(t, uint32(1024), huge.BufferSize)
+	assert.Equal(t, uint32(maxEstimateMemory/(1024*1024)), huge.MaximumBuffers)
+
+	var cfg SessionOptions
+	chatty.Option()(&cfg)
+	assert.Equal(t, chatty.BufferSize, cfg.BufferSize)
+	assert.Equal(t, chatty.MaximumBuffers, cfg.MaximumBuffers)
+}
@@ -0,0 +1,91 @@
+//+build windows
+
+package etw
+
+/*
+	#include <stdlib.h>
+	#include "session.h"
+*/
+import "C"
+import (
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// CallbackMode defines how the session invokes the EventCallback.
+type CallbackMode int
+
+const (
+	// CallbackSequential invokes the callback synchronously, one event at a
+	// time, in the order ETW delivers events. Events are ordered by time
+	// within a session buffer, but buffers are per-processor, so events
+	// logged on different processors could come slightly out of order.
+	// That's the default mode.
+	CallbackSequential CallbackMode = iota
+
+	// CallbackStrictOrder is the same as CallbackSequential, but events
+	// are delivered strictly in the timestamp order across processors. The
+	// session is created with buffers shared by all processors, see
+	// SessionOptions.NoPerProcessorBuffering for the costs.
+	CallbackStrictOrder
+
+	// CallbackParallel invokes the callback concurrently from
+	// CallbackWorkers goroutines (GOMAXPROCS if zero) with no ordering
+	// guarantees. Every event is copied to be valid in a worker, so it pays
+	// off for callbacks doing substantial work per event, e.g. parsing
+	// properties. Like in other modes the Event is valid only inside the
+	// callback.
+	CallbackParallel
+)
+
+// parallelDispatcher passes events copies to worker goroutines.
+type parallelDispatcher struct {
+	cb     EventCallback
+	events chan *Event
+	wg     sync.WaitGroup
+}
+
+// newParallelDispatcher starts @workers goroutines invoking @cb. Up to
+// @queueSize events (@workers if zero) wait for a free worker.
+func newParallelDispatcher(cb EventCallback, workers, queueSize int) *parallelDispatcher {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if queueSize <= 0 {
+		queueSize = workers
+	}
+	d := &parallelDispatcher{cb: cb, events: make(chan *Event, queueSize)}
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer d.wg.Done()
+			for e := range d.events {
+				d.cb(e)
+				C.free(unsafe.Pointer(e.eventRecord))
+				e.eventRecord = nil
+			}
+		}()
+	}
+	return d
+}
+
+// dispatch copies @e and passes the copy to a worker. It blocks while all
+// workers are busy. If there is no memory for the copy @e is processed
+// synchronously.
+func (d *parallelDispatcher) dispatch(e *Event) {
+	record := C.CopyEventRecord(e.eventRecord)
+	if record == nil {
+		d.cb(e)
+		return
+	}
+	event := *e
+	event.eventRecord = record
+	d.events <- &event
+}
+
+// wait stops workers after they process all dispatched events.
+func (d *parallelDispatcher) wait() {
+	close(d.events)
+	d.wg.Wait()
+}
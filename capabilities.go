@@ -0,0 +1,174 @@
+//+build windows
+
+package etw
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/windows"
+)
+
+// ErrUnsupportedOSVersion is returned (wrapped) by NewSession and other
+// session methods if requested options need a newer Windows than the running
+// one. Check Capabilities to decide what to request in advance.
+var ErrUnsupportedOSVersion = errors.New("unsupported OS version")
+
+// OSVersion is a Windows version as reported by RtlGetVersion, i.e. not
+// affected by the application compatibility manifest.
+type OSVersion struct {
+	Major uint32
+	Minor uint32
+	Build uint32
+}
+
+// eventTraceIndependentSessionMode isolates the session from event loss in
+// other sessions (EVENT_TRACE_INDEPENDENT_SESSION_MODE).
+const eventTraceIndependentSessionMode = 0x08000000
+
+// Windows versions ETW features appeared in.
+//
+//nolint:gochecknoglobals
+var (
+	windows8     = OSVersion{Major: 6, Minor: 2, Build: 9200}
+	windows81    = OSVersion{Major: 6, Minor: 3, Build: 9600}
+	windows10    = OSVersion{Major: 10, Minor: 0, Build: 10240}
+	windows20348 = OSVersion{Major: 10, Minor: 0, Build: 20348} // Windows Server 2022.
+)
+
+// AtLeast returns true if @v is the same or newer than @other.
+func (v OSVersion) AtLeast(other OSVersion) bool {
+	if v.Major != other.Major {
+		return v.Major > other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor > other.Minor
+	}
+	return v.Build >= other.Build
+}
+
+// String returns the version in the "10.0.19041" form.
+func (v OSVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Build)
+}
+
+// SystemCapabilities describes ETW features supported by the running system.
+type SystemCapabilities struct {
+	OSVersion OSVersion
+
+	// SystemLoggers is true if sessions could be started in the system
+	// logger mode (EVENT_TRACE_SYSTEM_LOGGER_MODE) to receive kernel events
+	// along with the regular providers ones. Windows 8 and later.
+	SystemLoggers bool
+
+	// SystemProviders is true if kernel events could be enabled on system
+	// loggers per provider with EnableTraceEx2 instead of the kernel flags.
+	// Windows 10 build 20348 and later.
+	SystemProviders bool
+
+	// EventIDFilters and PayloadFilters are true if providers could be
+	// enabled with EVENT_FILTER_TYPE_EVENT_ID (see WithEventIDs) and
+	// EVENT_FILTER_TYPE_PAYLOAD filter descriptors. Windows 8.1 and later.
+	EventIDFilters bool
+	PayloadFilters bool
+
+	// IndependentSessions is true if sessions could be isolated from event
+	// loss in other sessions with WithIndependentSession. Windows 8.1 and
+	// later.
+	IndependentSessions bool
+
+	// CountedInTypes is true if TDH formats counted strings and binaries.
+	// Such properties are decoded by the library itself otherwise.
+	CountedInTypes bool
+
+	// EnableProperties are EnableProperty values accepted by the system.
+	// Options with other ones fail with ErrUnsupportedOSVersion.
+	EnableProperties []EnableProperty
+}
+
+// enablePropertyVersions holds minimal OS versions of EnableProperty values
+// that appeared after Windows 7.
+//
+//nolint:gochecknoglobals
+var enablePropertyVersions = map[EnableProperty]OSVersion{
+	EVENT_ENABLE_PROPERTY_IGNORE_KEYWORD_0:  windows8,
+	EVENT_ENABLE_PROPERTY_EXCLUDE_INPRIVATE: windows10,
+}
+
+//nolint:gochecknoglobals
+var (
+	capabilitiesOnce sync.Once
+	capabilities     SystemCapabilities
+)
+
+// Capabilities returns ETW features supported by the running system. The
+// detection is done once, so it's cheap to call Capabilities repeatedly.
+func Capabilities() SystemCapabilities {
+	capabilitiesOnce.Do(func() {
+		info := windows.RtlGetVersion()
+		v := OSVersion{
+			Major: info.MajorVersion,
+			Minor: info.MinorVersion,
+			Build: info.BuildNumber,
+		}
+		capabilities = SystemCapabilities{
+			OSVersion:           v,
+			SystemLoggers:       v.AtLeast(windows8),
+			SystemProviders:     v.AtLeast(windows20348),
+			EventIDFilters:      v.AtLeast(windows81),
+			PayloadFilters:      v.AtLeast(windows81),
+			IndependentSessions: v.AtLeast(windows81),
+			CountedInTypes:      tdhSupportsCountedTypes(),
+		}
+		for _, p := range []EnableProperty{
+			EVENT_ENABLE_PROPERTY_SID,
+			EVENT_ENABLE_PROPERTY_TS_ID,
+			EVENT_ENABLE_PROPERTY_STACK_TRACE,
+			EVENT_ENABLE_PROPERTY_IGNORE_KEYWORD_0,
+			EVENT_ENABLE_PROPERTY_EXCLUDE_INPRIVATE,
+		} {
+			if min, ok := enablePropertyVersions[p]; !ok || v.AtLeast(min) {
+				capabilities.EnableProperties = append(capabilities.EnableProperties, p)
+			}
+		}
+	})
+	caps := capabilities
+	caps.EnableProperties = append([]EnableProperty(nil), capabilities.EnableProperties...)
+	return caps
+}
+
+// requireOSVersion fails with ErrUnsupportedOSVersion if the running system
+// is older than @min. @feature names the feature in the error.
+func requireOSVersion(feature string, min OSVersion) error {
+	if v := Capabilities().OSVersion; !v.AtLeast(min) {
+		return fmt.Errorf("%w: %s requires Windows %s or later, running %s",
+			ErrUnsupportedOSVersion, feature, min, v)
+	}
+	return nil
+}
+
+// validate checks all options are supported by the running system.
+func (o SessionOptions) validate() error {
+	if o.IndependentSession {
+		if err := requireOSVersion("independent session mode", windows81); err != nil {
+			return err
+		}
+	}
+	if len(o.EventIDs) != 0 {
+		if err := requireOSVersion("event ID filter", windows81); err != nil {
+			return err
+		}
+		if len(o.EventIDs) > maxEventIDFilterCount {
+			return fmt.Errorf("too many event IDs %d, at most %d are allowed", len(o.EventIDs), maxEventIDFilterCount)
+		}
+	}
+	for _, p := range o.EnableProperties {
+		if min, ok := enablePropertyVersions[p]; ok {
+			if err := requireOSVersion(fmt.Sprintf("enable property 0x%x", uint32(p)), min); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
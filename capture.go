@@ -0,0 +1,107 @@
+//+build windows
+
+package etw
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// CaptureOptions describes a Capture run.
+type CaptureOptions struct {
+	// SessionOptions are options of the temporary capture session.
+	SessionOptions []Option
+
+	// ParseOptions are options captured events are parsed with.
+	ParseOptions []ParseOption
+
+	// MaxEvents stops the capture as soon as MaxEvents events are captured.
+	// Zero means no limit.
+	MaxEvents int
+}
+
+// CaptureOption is any function that modifies CaptureOptions.
+type CaptureOption func(cfg *CaptureOptions)
+
+// WithCaptureSessionOptions sets @options of the temporary capture session,
+// e.g. a level or keywords of the captured events.
+func WithCaptureSessionOptions(options ...Option) CaptureOption {
+	return func(cfg *CaptureOptions) {
+		cfg.SessionOptions = append(cfg.SessionOptions, options...)
+	}
+}
+
+// WithCaptureParseOptions sets @options captured events are parsed with.
+func WithCaptureParseOptions(options ...ParseOption) CaptureOption {
+	return func(cfg *CaptureOptions) {
+		cfg.ParseOptions = append(cfg.ParseOptions, options...)
+	}
+}
+
+// WithMaxEvents stops the capture once @n events are captured.
+func WithMaxEvents(n int) CaptureOption {
+	return func(cfg *CaptureOptions) {
+		cfg.MaxEvents = n
+	}
+}
+
+// Capture collects events of the provider @providerGUID for @duration (or
+// until WithMaxEvents events are captured) with a temporary session that is
+// closed before Capture returns. It's handy for diagnostics endpoints, e.g.
+// "capture 10 seconds of DNS events". Zero @duration means no time limit.
+//
+// Captured events are kept in memory, so limit either @duration or the
+// number of events (or both) for chatty providers. Unlike ChannelCallback
+// ones returned events are not pooled and need no `.Release`.
+//
+// If @ctx is done before the capture is complete the events captured so far
+// are returned along with the @ctx error.
+func Capture(ctx context.Context, providerGUID windows.GUID, duration time.Duration, options ...CaptureOption) ([]ParsedEvent, error) {
+	var cfg CaptureOptions
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	var parseCfg ParseOptions
+	for _, opt := range cfg.ParseOptions {
+		opt(&parseCfg)
+	}
+
+	session, err := NewSession(providerGUID, cfg.SessionOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture session; %w", err)
+	}
+
+	var (
+		captureCtx context.Context
+		cancel     context.CancelFunc
+	)
+	if duration > 0 {
+		captureCtx, cancel = context.WithTimeout(ctx, duration)
+	} else {
+		captureCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	var events []ParsedEvent
+	processErr := session.ProcessContext(captureCtx, func(ctx context.Context, e *Event) {
+		if ctx.Err() != nil {
+			return // Events delivered while the trace is being closed.
+		}
+		pe := ParsedEvent{Properties: make(map[string]interface{})}
+		pe.fill(e, parseCfg, "Capture")
+		events = append(events, pe)
+		if cfg.MaxEvents > 0 && len(events) >= cfg.MaxEvents {
+			cancel()
+		}
+	})
+	if err := session.Close(); err != nil && processErr == nil {
+		processErr = fmt.Errorf("failed to close capture session; %w", err)
+	}
+	if processErr != nil {
+		return events, processErr
+	}
+	return events, ctx.Err()
+}
@@ -0,0 +1,281 @@
+//+build windows
+
+// Command etwselftest validates ETW functionality on a host: it registers a
+// TraceLogging provider and starts a session consuming it, round-trips
+// fields of every supported kind, checks privileges and measures
+// throughput. The report is printed to stdout as JSON, the exit code is 1 if
+// any check failed. It's meant to triage "no events" issues quickly:
+//
+//		etwselftest -duration 5s > report.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw"
+	"github.com/bi-zone/etw/testutil"
+)
+
+// providerName is a name of the self-test provider; its GUID is derived
+// from the name.
+const providerName = "EtwSelfTest"
+
+// Report is the machine-readable self-test result.
+type Report struct {
+	Host         string                 `json:"host"`
+	Time         time.Time              `json:"time"`
+	Capabilities etw.SystemCapabilities `json:"capabilities"`
+	Checks       []Check                `json:"checks"`
+	Throughput   *Throughput            `json:"throughput,omitempty"`
+	OK           bool                   `json:"ok"`
+}
+
+// Check is a result of a single self-test step.
+type Check struct {
+	Name     string            `json:"name"`
+	OK       bool              `json:"ok"`
+	Error    string            `json:"error,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+	Duration time.Duration     `json:"duration_ns"`
+}
+
+// Throughput is a result of the throughput measurement.
+type Throughput struct {
+	Duration        time.Duration `json:"duration_ns"`
+	Written         uint64        `json:"written"`
+	Received        uint64        `json:"received"`
+	EventsPerSecond float64       `json:"events_per_second"`
+	EventsLost      uint32        `json:"events_lost"`
+	BuffersLost     uint32        `json:"buffers_lost"`
+}
+
+func main() {
+	var (
+		optDuration = flag.Duration("duration", 3*time.Second, "Throughput measurement duration")
+		optTimeout  = flag.Duration("timeout", 10*time.Second, "Time to wait for the first event")
+		optIndent   = flag.Bool("indent", false, "Indent the JSON report")
+	)
+	flag.Parse()
+
+	report := run(*optDuration, *optTimeout)
+	enc := json.NewEncoder(os.Stdout)
+	if *optIndent {
+		enc.SetIndent("", "  ")
+	}
+	_ = enc.Encode(report)
+	if !report.OK {
+		os.Exit(1)
+	}
+}
+
+// run performs all the checks. Steps depending on a failed one are skipped.
+func run(duration, timeout time.Duration) Report {
+	host, _ := os.Hostname()
+	r := Report{Host: host, Time: time.Now().UTC(), Capabilities: etw.Capabilities(), OK: true}
+	add := func(c Check) bool {
+		r.Checks = append(r.Checks, c)
+		r.OK = r.OK && c.OK
+		return c.OK
+	}
+
+	add(checkPrivileges())
+
+	started := time.Now()
+	provider, err := testutil.NewProvider(providerName)
+	if !add(newCheck("provider", started, err, nil)) {
+		return r
+	}
+	defer provider.Close()
+
+	started = time.Now()
+	session, err := etw.NewSession(provider.GUID())
+	if !add(newCheck("session", started, err, nil)) {
+		return r
+	}
+	defer session.Close()
+
+	// The first event is parsed for the round-trip, others are just counted.
+	var received uint64
+	parsed := make(chan Check, 1)
+	cb := func(e *etw.Event) {
+		if atomic.AddUint64(&received, 1) == 1 {
+			parsed <- compareFields(e)
+		}
+	}
+	processed := make(chan error, 1)
+	go func() {
+		processed <- session.Process(cb)
+	}()
+
+	started = time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	go provider.Generate(ctx, "RoundTrip", etw.TRACE_LEVEL_INFORMATION, roundTripFields()...)
+	var roundTrip Check
+	select {
+	case roundTrip = <-parsed:
+	case err := <-processed:
+		roundTrip = newCheck("round_trip", started, fmt.Errorf("processing stopped; %w", err), nil)
+	case <-time.After(timeout):
+		roundTrip = newCheck("round_trip", started, fmt.Errorf("no events received in %s", timeout), nil)
+	}
+	cancel()
+	roundTrip.Duration = time.Since(started)
+	if !add(roundTrip) {
+		return r
+	}
+
+	r.Throughput = measureThroughput(session, provider, &received, duration)
+	return r
+}
+
+// newCheck makes a Check named @name started at @started failed with @err
+// unless it's nil.
+func newCheck(name string, started time.Time, err error, details map[string]string) Check {
+	c := Check{Name: name, OK: err == nil, Details: details, Duration: time.Since(started)}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// checkPrivileges checks the process is allowed to control sessions: it
+// should be elevated or a member of the Performance Log Users group.
+func checkPrivileges() Check {
+	started := time.Now()
+	token := windows.GetCurrentProcessToken()
+	elevated := token.IsElevated()
+	var perfLogUser bool
+	sid, err := windows.CreateWellKnownSid(windows.WinBuiltinPerfLoggingUsersSid)
+	if err == nil {
+		// IsMember needs an impersonation token, nil is the calling thread
+		// one, i.e. the process token.
+		perfLogUser, err = windows.Token(0).IsMember(sid)
+	}
+	details := map[string]string{
+		"elevated":              fmt.Sprint(elevated),
+		"performance_log_users": fmt.Sprint(perfLogUser),
+	}
+	if err == nil && !elevated && !perfLogUser {
+		err = fmt.Errorf("process is neither elevated nor a member of Performance Log Users")
+	}
+	return newCheck("privileges", started, err, details)
+}
+
+//nolint:gochecknoglobals
+var (
+	roundTripGUID = windows.GUID{Data1: 0x1C95126E, Data2: 0x7EEA, Data3: 0x49A9, Data4: [8]byte{0xA3, 0xFE, 0xA3, 0x78, 0xB0, 0x3D, 0xDB, 0x4D}}
+	roundTripTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// roundTripFields returns fields of every kind testutil supports.
+func roundTripFields() []testutil.Field {
+	sid, _ := windows.StringToSid("S-1-5-18") // Never fails for well-known SIDs.
+	return []testutil.Field{
+		testutil.String("string", "value"),
+		testutil.AnsiString("ansi", "ansi value"),
+		testutil.Int32("int32", -7),
+		testutil.Uint32("uint32", 42),
+		testutil.Int64("int64", -42),
+		testutil.Uint64("uint64", 1<<40),
+		testutil.HexInt32("hexint32", 0x2A),
+		testutil.HexInt64("hexint64", 0x2A),
+		testutil.Double("double", 1.5),
+		testutil.Bool("bool", true),
+		testutil.Binary("binary", []byte{1, 2}),
+		testutil.GUID("guid", roundTripGUID),
+		testutil.FileTime("filetime", roundTripTime),
+		testutil.SID("sid", sid),
+		testutil.IPv4("ipv4", net.ParseIP("127.0.0.1")),
+		testutil.IPv6("ipv6", net.ParseIP("::1")),
+		testutil.Port("port", 443),
+	}
+}
+
+// roundTripExpected holds values roundTripFields are expected to be parsed
+// to with invariant formatting.
+//
+//nolint:gochecknoglobals
+var roundTripExpected = map[string]string{
+	"string":   "value",
+	"ansi":     "ansi value",
+	"int32":    "-7",
+	"uint32":   "42",
+	"int64":    "-42",
+	"uint64":   "1099511627776",
+	"hexint32": "0x2A",
+	"hexint64": "0x2A",
+	"double":   "1.5",
+	"bool":     "true",
+	"binary":   "0x0102",
+	"guid":     roundTripGUID.String(),
+	"filetime": roundTripTime.Format(time.RFC3339Nano),
+	"sid":      "S-1-5-18",
+	"ipv4":     "127.0.0.1",
+	"ipv6":     "::1",
+	"port":     "443",
+}
+
+// compareFields parses @e and reports fields differing from the expected
+// ones in the check details.
+func compareFields(e *etw.Event) Check {
+	started := time.Now()
+	properties, err := e.EventProperties(etw.WithInvariantFormatting())
+	if err != nil {
+		return newCheck("round_trip", started, fmt.Errorf("failed to parse event; %w", err), nil)
+	}
+	mismatches := make(map[string]string)
+	for name, expected := range roundTripExpected {
+		if got := fmt.Sprint(properties[name]); got != expected {
+			mismatches[name] = fmt.Sprintf("expected %q, got %q", expected, got)
+		}
+	}
+	if len(mismatches) != 0 {
+		err = fmt.Errorf("%d of %d fields differ", len(mismatches), len(roundTripExpected))
+		return newCheck("round_trip", started, err, mismatches)
+	}
+	return newCheck("round_trip", started, nil, nil)
+}
+
+// measureThroughput floods events for @duration and counts ones received
+// by @session. Events are delivered in batches on buffer flushes, so
+// receiving is awaited for a while after writing stops.
+func measureThroughput(session *etw.Session, provider *testutil.Provider, received *uint64, duration time.Duration) *Throughput {
+	const flushWait = 2 * time.Second
+	before := atomic.LoadUint64(received)
+
+	var written uint64
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	started := time.Now()
+	testutil.Generate(ctx, func() {
+		if provider.WriteEvent("Throughput", etw.TRACE_LEVEL_INFORMATION, testutil.Uint64("n", written)) == nil {
+			written++
+		}
+	})
+	elapsed := time.Since(started)
+
+	deadline := time.Now().Add(flushWait)
+	for atomic.LoadUint64(received)-before < written && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	t := &Throughput{
+		Duration: elapsed,
+		Written:  written,
+		Received: atomic.LoadUint64(received) - before,
+	}
+	t.EventsPerSecond = float64(t.Received) / elapsed.Seconds()
+	if props, err := session.TraceProperties(); err == nil {
+		t.EventsLost = props.EventsLost
+		t.BuffersLost = props.RealTimeBuffersLost
+	}
+	return t
+}
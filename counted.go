@@ -0,0 +1,160 @@
+//+build windows
+
+package etw
+
+/*
+	#include <stdlib.h>
+	#include "session.h"
+*/
+import "C"
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Counted in-types are used by TraceLogging and manifest providers since
+// Windows 10. TDH of older systems fails to format them, so we decode them
+// ourselves there.
+//
+// N.B. TdhGetDecodingParameter and its siblings only read and set TDH_CONTEXT
+// values (WPP decoding parameters) and don't change how TdhFormatProperty
+// formats payloads, so there is no better decoding path to switch to on
+// Windows 10. Instead TdhFormatProperty is probed for the counted in-types
+// support once and used if it has it, otherwise they are decoded in Go the
+// same way.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/tdh/ne-tdh-_tdh_in_type
+const (
+	tdhInTypeCountedString     = 22 // TDH_INTYPE_MANIFEST_COUNTEDSTRING
+	tdhInTypeCountedAnsiString = 23 // TDH_INTYPE_MANIFEST_COUNTEDANSISTRING
+	tdhInTypeCountedBinary     = 25 // TDH_INTYPE_MANIFEST_COUNTEDBINARY
+)
+
+//nolint:gochecknoglobals
+var (
+	countedTypesOnce      sync.Once
+	countedTypesSupported bool
+)
+
+// isCountedInType returns true if @inType is one of the counted in-types.
+func isCountedInType(inType uintptr) bool {
+	switch inType {
+	case tdhInTypeCountedString, tdhInTypeCountedAnsiString, tdhInTypeCountedBinary:
+		return true
+	default:
+		return false
+	}
+}
+
+// tdhSupportsCountedTypes returns true if TdhFormatProperty of the running
+// system is able to format counted in-types. The support is probed once by
+// formatting a sample counted string.
+func tdhSupportsCountedTypes() bool {
+	countedTypesOnce.Do(func() {
+		value, consumed, err := formatCountedTDH(tdhInTypeCountedString, []byte{2, 0, 'a', 0}) // Length in bytes, then "a".
+		countedTypesSupported = err == nil && consumed == 4 && value == "a"
+	})
+	return countedTypesSupported
+}
+
+// formatCountedTDH formats a value of the counted @inType located at the
+// start of @data with TdhFormatProperty. Returns the value and the number of
+// bytes consumed.
+func formatCountedTDH(inType uintptr, data []byte) (string, int, error) {
+	// TdhFormatProperty needs the event only for map lookups and
+	// pointer-sized types, so an empty record is enough.
+	record := C.calloc(1, C.size_t(unsafe.Sizeof(C.EVENT_RECORD{})))
+	defer C.free(record)
+	userData := C.CBytes(data)
+	defer C.free(userData)
+
+	var (
+		consumed C.int
+		size     C.int = 256
+	)
+	for {
+		buf := make([]uint16, int(size)/2)
+		status := formatProperty(&formatPropertyArgs{
+			eventInfo:      record,
+			pointerSize:    8,
+			inType:         inType,
+			userDataLength: uintptr(len(data)),
+			userData:       userData,
+			bufferSize:     &size,
+			buffer:         unsafe.Pointer(&buf[0]),
+			consumed:       &consumed,
+		})
+		switch status {
+		case windows.ERROR_SUCCESS:
+			return windows.UTF16ToString(buf), int(consumed), nil
+		case windows.ERROR_INSUFFICIENT_BUFFER:
+			continue // size is updated by TdhFormatProperty.
+		default:
+			return "", 0, fmt.Errorf("TdhFormatProperty failed; %w", status)
+		}
+	}
+}
+
+// decodeCounted decodes a value of the counted @inType located at the start
+// of @data the way TDH of Windows 10 formats it. Returns the value and the
+// number of bytes consumed.
+func decodeCounted(inType uintptr, data []byte) (string, int, error) {
+	if len(data) < 2 {
+		return "", 0, fmt.Errorf("%w: no length of counted value", ErrMalformedEvent)
+	}
+	length := int(binary.LittleEndian.Uint16(data))
+	if length > len(data)-2 {
+		return "", 0, fmt.Errorf("%w: counted value of %d bytes exceeds remaining %d bytes of data",
+			ErrMalformedEvent, length, len(data)-2)
+	}
+	value := data[2 : 2+length]
+
+	switch inType {
+	case tdhInTypeCountedString:
+		chars := make([]uint16, len(value)/2)
+		for i := range chars {
+			chars[i] = binary.LittleEndian.Uint16(value[2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(chars)), "\x00"), 2 + length, nil
+	case tdhInTypeCountedAnsiString:
+		return strings.TrimRight(string(value), "\x00"), 2 + length, nil
+	case tdhInTypeCountedBinary:
+		return fmt.Sprintf("0x%X", value), 2 + length, nil
+	default:
+		return "", 0, fmt.Errorf("in-type %d is not counted", inType)
+	}
+}
+
+// parseCounted decodes the counted @inType property at the current data
+// offset advancing it.
+func (p *propertyParser) parseCounted(inType uintptr) (string, error) {
+	if p.data > p.endData {
+		return "", fmt.Errorf("%w: data offset is out of the payload", ErrMalformedEvent)
+	}
+	// Copy only the value instead of the whole remaining payload.
+	size := int(p.endData - p.data)
+	if size >= 2 {
+		length := C.GoBytes(unsafe.Pointer(p.data), 2)
+		if n := 2 + int(binary.LittleEndian.Uint16(length)); n < size {
+			size = n
+		}
+	}
+	data := C.GoBytes(unsafe.Pointer(p.data), C.int(size))
+	value, consumed, err := decodeCounted(inType, data)
+	if err != nil {
+		return "", err
+	}
+	if p.limits.MaxStringLength > 0 {
+		if err := checkLimit("MaxStringLength", utf16Length(value), p.limits.MaxStringLength); err != nil {
+			return "", err
+		}
+	}
+	p.data += uintptr(consumed)
+	return value, nil
+}
@@ -0,0 +1,85 @@
+//+build windows
+
+package etw
+
+import (
+	"sort"
+
+	"golang.org/x/sys/windows"
+)
+
+// ProviderSession describes a session having a provider enabled as it's seen
+// by the OS.
+type ProviderSession struct {
+	// LoggerID identifies the session. SessionName is empty if the session
+	// can't be queried, e.g. it's a private session or we lack access.
+	LoggerID    uint16
+	SessionName string
+
+	// Enable is the way the session enabled the provider.
+	Enable ProviderEnableInfo
+
+	// ProcessIDs are processes hosting the provider instances the session
+	// has enabled. Empty if the provider is not registered yet.
+	ProcessIDs []uint32
+}
+
+// ProviderSessions returns the sessions that have the provider identified
+// by @providerGUID enabled, with the level and keywords they enabled it
+// with. It helps to debug the provider behaving unexpectedly because of
+// other sessions, e.g. logging more events as another session enabled more
+// keywords.
+//
+// Sessions are sorted by LoggerID. Unknown provider has no sessions.
+func ProviderSessions(providerGUID windows.GUID) ([]ProviderSession, error) {
+	instances, err := queryProviderInstances(providerGUID)
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		return nil, nil
+	}
+
+	// Names are the best effort: sessions we can't query are still listed.
+	names := make(map[uint16]string)
+	if sessions, err := ListSessions(); err == nil {
+		for _, s := range sessions {
+			names[s.LoggerID] = s.SessionName
+		}
+	}
+	return groupProviderSessions(instances, names), nil
+}
+
+// groupProviderSessions groups enable infos of the provider @instances by
+// sessions naming them with @names.
+func groupProviderSessions(instances []providerInstance, names map[uint16]string) []ProviderSession {
+	bySession := make(map[uint16]*ProviderSession)
+	for _, instance := range instances {
+		for _, info := range instance.enable {
+			if !info.IsEnabled {
+				continue
+			}
+			s, ok := bySession[info.LoggerID]
+			if !ok {
+				s = &ProviderSession{
+					LoggerID:    info.LoggerID,
+					SessionName: names[info.LoggerID],
+					Enable:      info,
+				}
+				bySession[info.LoggerID] = s
+			}
+			if instance.pid != 0 {
+				s.ProcessIDs = append(s.ProcessIDs, instance.pid)
+			}
+		}
+	}
+
+	sessions := make([]ProviderSession, 0, len(bySession))
+	for _, s := range bySession {
+		sessions = append(sessions, *s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LoggerID < sessions[j].LoggerID
+	})
+	return sessions
+}
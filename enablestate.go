@@ -0,0 +1,257 @@
+//+build windows
+
+package etw
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// enableStateVersion is a version of the EnableState encoding. Blobs of
+// other versions are rejected by UnmarshalEnableState.
+const enableStateVersion = 1
+
+// EnableState is the complete enable state of a session: its providers with
+// levels, keywords, enable properties and event filters. It could be
+// exported from one session with `.EnableState`, encoded to a portable blob
+// with `.Marshal` and applied to another session, possibly on another
+// machine, to replicate the exact tracing configuration.
+type EnableState struct {
+	// Providers are the session providers, the primary one goes first.
+	Providers []ProviderState
+}
+
+// ProviderState is the enable state of a single session provider.
+type ProviderState struct {
+	GUID            windows.GUID
+	Level           TraceLevel
+	MatchAnyKeyword uint64
+	MatchAllKeyword uint64
+	Properties      []EnableProperty
+	EventIDs        []uint16
+	Channels        []uint8
+	Opcodes         []uint8
+	SourceGUID      windows.GUID
+
+	// Paused is true if the provider is paused with `.PauseProvider`.
+	Paused bool
+}
+
+// options returns Options reproducing the provider state.
+func (p ProviderState) options() []Option {
+	return []Option{
+		WithLevel(p.Level),
+		WithMatchKeywords(p.MatchAnyKeyword, p.MatchAllKeyword),
+		func(cfg *SessionOptions) {
+			cfg.EnableProperties = append([]EnableProperty(nil), p.Properties...)
+		},
+		WithEventIDs(p.EventIDs...),
+		WithChannels(p.Channels...),
+		WithOpcodes(p.Opcodes...),
+		WithSourceGUID(p.SourceGUID),
+	}
+}
+
+// enableStateOf returns a state of the provider @guid subscribed with
+// @cfg.
+func enableStateOf(guid windows.GUID, cfg SessionOptions, paused bool) ProviderState {
+	return ProviderState{
+		GUID:            guid,
+		Level:           cfg.Level,
+		MatchAnyKeyword: cfg.MatchAnyKeyword,
+		MatchAllKeyword: cfg.MatchAllKeyword,
+		Properties:      cfg.EnableProperties,
+		EventIDs:        cfg.EventIDs,
+		Channels:        cfg.Channels,
+		Opcodes:         cfg.Opcodes,
+		SourceGUID:      cfg.SourceGUID,
+		Paused:          paused,
+	}
+}
+
+// EnableState returns a copy of the effective enable state of all session
+// providers.
+func (s *Session) EnableState() EnableState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := EnableState{Providers: make([]ProviderState, 0, len(s.providers)+1)}
+	state.Providers = append(state.Providers, enableStateOf(s.guid, s.config.clone(), s.paused[s.guid]))
+	for guid, cfg := range s.providers {
+		state.Providers = append(state.Providers, enableStateOf(guid, cfg.clone(), s.paused[guid]))
+	}
+	return state
+}
+
+// ApplyEnableState enables providers of @state on the session with their
+// levels, keywords, properties and filters. Providers already enabled are
+// updated, providers the session has but @state doesn't are left intact.
+//
+// Session-wide options like the name, buffers or the log file aren't part
+// of EnableState and aren't changed.
+func (s *Session) ApplyEnableState(state EnableState) error {
+	for _, p := range state.Providers {
+		if err := s.AddProvider(p.GUID, p.options()...); err != nil {
+			return fmt.Errorf("failed to apply state of provider %s; %w", p.GUID, err)
+		}
+		var err error
+		if p.Paused {
+			err = s.PauseProvider(p.GUID)
+		} else {
+			err = s.ResumeProvider(p.GUID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply state of provider %s; %w", p.GUID, err)
+		}
+	}
+	return nil
+}
+
+// NewSessionFromEnableState creates a new session replicating @state: the
+// first @state provider becomes the primary one, others are added with
+// `.AddProvider`. @options set session-wide parameters (name, buffers, log
+// file, etc.) the same way as for NewSession.
+func NewSessionFromEnableState(state EnableState, options ...Option) (*Session, error) {
+	if len(state.Providers) == 0 {
+		return nil, fmt.Errorf("enable state has no providers")
+	}
+	primary := state.Providers[0]
+	s, err := NewSession(primary.GUID, append(append([]Option(nil), options...), primary.options()...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ApplyEnableState(state); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// enableStateJSON is the portable encoding of EnableState.
+type enableStateJSON struct {
+	Version   int                 `json:"version"`
+	Providers []providerStateJSON `json:"providers"`
+}
+
+type providerStateJSON struct {
+	GUID            string           `json:"guid"`
+	Level           TraceLevel       `json:"level"`
+	MatchAnyKeyword uint64           `json:"match_any_keyword,omitempty"`
+	MatchAllKeyword uint64           `json:"match_all_keyword,omitempty"`
+	Properties      []EnableProperty `json:"properties,omitempty"`
+	EventIDs        []uint16         `json:"event_ids,omitempty"`
+	Channels        []int            `json:"channels,omitempty"`
+	Opcodes         []int            `json:"opcodes,omitempty"`
+	SourceGUID      string           `json:"source_guid,omitempty"`
+	Paused          bool             `json:"paused,omitempty"`
+}
+
+// Marshal encodes the state to a portable JSON blob that could be decoded
+// with UnmarshalEnableState.
+func (st EnableState) Marshal() ([]byte, error) {
+	blob := enableStateJSON{
+		Version:   enableStateVersion,
+		Providers: make([]providerStateJSON, 0, len(st.Providers)),
+	}
+	for _, p := range st.Providers {
+		blob.Providers = append(blob.Providers, providerStateJSON{
+			GUID:            p.GUID.String(),
+			Level:           p.Level,
+			MatchAnyKeyword: p.MatchAnyKeyword,
+			MatchAllKeyword: p.MatchAllKeyword,
+			Properties:      p.Properties,
+			EventIDs:        p.EventIDs,
+			Channels:        bytesToInts(p.Channels),
+			Opcodes:         bytesToInts(p.Opcodes),
+			SourceGUID:      sourceGUIDToString(p.SourceGUID),
+			Paused:          p.Paused,
+		})
+	}
+	data, err := json.Marshal(blob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode enable state; %w", err)
+	}
+	return data, nil
+}
+
+// UnmarshalEnableState decodes EnableState encoded with `.Marshal`.
+func UnmarshalEnableState(data []byte) (EnableState, error) {
+	var blob enableStateJSON
+	if err := json.Unmarshal(data, &blob); err != nil {
+		return EnableState{}, fmt.Errorf("failed to decode enable state; %w", err)
+	}
+	if blob.Version != enableStateVersion {
+		return EnableState{}, fmt.Errorf("unsupported enable state version %d", blob.Version)
+	}
+	state := EnableState{Providers: make([]ProviderState, 0, len(blob.Providers))}
+	for _, p := range blob.Providers {
+		guid, err := ParseGUID(p.GUID)
+		if err != nil {
+			return EnableState{}, fmt.Errorf("failed to decode enable state; %w", err)
+		}
+		channels, err := intsToBytes(p.Channels)
+		if err != nil {
+			return EnableState{}, fmt.Errorf("invalid channels of provider %s; %w", guid, err)
+		}
+		opcodes, err := intsToBytes(p.Opcodes)
+		if err != nil {
+			return EnableState{}, fmt.Errorf("invalid opcodes of provider %s; %w", guid, err)
+		}
+		var sourceGUID windows.GUID
+		if p.SourceGUID != "" {
+			if sourceGUID, err = ParseGUID(p.SourceGUID); err != nil {
+				return EnableState{}, fmt.Errorf("invalid source GUID of provider %s; %w", guid, err)
+			}
+		}
+		state.Providers = append(state.Providers, ProviderState{
+			GUID:            guid,
+			Level:           p.Level,
+			MatchAnyKeyword: p.MatchAnyKeyword,
+			MatchAllKeyword: p.MatchAllKeyword,
+			Properties:      p.Properties,
+			EventIDs:        p.EventIDs,
+			Channels:        channels,
+			Opcodes:         opcodes,
+			SourceGUID:      sourceGUID,
+			Paused:          p.Paused,
+		})
+	}
+	return state, nil
+}
+
+// sourceGUIDToString formats @guid omitting the default zero one.
+func sourceGUIDToString(guid windows.GUID) string {
+	if guid == (windows.GUID{}) {
+		return ""
+	}
+	return guid.String()
+}
+
+// bytesToInts converts @values to ints, so they are encoded to JSON as
+// numbers rather than a base64 string.
+func bytesToInts(values []uint8) []int {
+	if len(values) == 0 {
+		return nil
+	}
+	ints := make([]int, len(values))
+	for i, v := range values {
+		ints[i] = int(v)
+	}
+	return ints
+}
+
+// intsToBytes is the reverse of bytesToInts.
+func intsToBytes(values []int) ([]uint8, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	bytes := make([]uint8, len(values))
+	for i, v := range values {
+		if v < 0 || v > 0xFF {
+			return nil, fmt.Errorf("value %d is out of byte range", v)
+		}
+		bytes[i] = uint8(v)
+	}
+	return bytes, nil
+}
@@ -0,0 +1,47 @@
+// +build windows
+
+package etw_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw"
+)
+
+func TestEnableStateMarshal(t *testing.T) {
+	state := etw.EnableState{Providers: []etw.ProviderState{
+		{
+			GUID:            windows.GUID{Data1: 0x1C95126E, Data2: 0x7EEA, Data3: 0x49A9},
+			Level:           etw.TRACE_LEVEL_INFORMATION,
+			MatchAnyKeyword: 0x8000000000000013,
+			Properties:      []etw.EnableProperty{etw.EVENT_ENABLE_PROPERTY_SID},
+			Channels:        []uint8{16},
+			Opcodes:         []uint8{1, 2},
+			SourceGUID:      windows.GUID{Data1: 0x5},
+		},
+		{
+			GUID:   windows.GUID{Data1: 0x2},
+			Level:  etw.TRACE_LEVEL_VERBOSE,
+			Paused: true,
+		},
+	}}
+
+	blob, err := state.Marshal()
+	require.NoError(t, err, "Failed to marshal enable state")
+	decoded, err := etw.UnmarshalEnableState(blob)
+	require.NoError(t, err, "Failed to unmarshal enable state")
+	assert.Equal(t, state, decoded, "Enable state changed after round-trip")
+
+	for _, blob := range []string{
+		`{"version":2,"providers":[]}`,
+		`{"version":1,"providers":[{"guid":"not a guid"}]}`,
+		`{"version":1,"providers":[{"guid":"{00000002-0000-0000-0000-000000000000}","opcodes":[256]}]}`,
+	} {
+		_, err := etw.UnmarshalEnableState([]byte(blob))
+		assert.Error(t, err, "Invalid blob %s is accepted", blob)
+	}
+}
@@ -0,0 +1,76 @@
+//+build windows
+
+package enrich
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/windows"
+)
+
+//nolint:gochecknoglobals
+var (
+	devicesMu sync.Mutex
+	devices   map[string]string // NT device path -> drive, e.g. `\Device\HarddiskVolume3` -> `C:`.
+)
+
+// ResolveDevicePath converts an NT device path kernel events refer files
+// with, e.g. `\Device\HarddiskVolume3\Windows\System32\cmd.exe`, to the DOS
+// one: `C:\Windows\System32\cmd.exe`. Other paths are returned as is.
+func ResolveDevicePath(path string) (string, error) {
+	if !strings.HasPrefix(path, `\Device\`) {
+		return path, nil
+	}
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+	if resolved, ok := resolveDevice(path); ok {
+		return resolved, nil
+	}
+	// Drives could be mounted since the last query.
+	if err := queryDevices(); err != nil {
+		return "", err
+	}
+	if resolved, ok := resolveDevice(path); ok {
+		return resolved, nil
+	}
+	return "", fmt.Errorf("no drive is mapped to %q", path)
+}
+
+// defaultResolvePath is the default of ImageHashOptions.ResolvePath.
+func defaultResolvePath(path string) (string, error) {
+	return ResolveDevicePath(path)
+}
+
+// resolveDevice replaces the known device prefix of @path with its drive.
+func resolveDevice(path string) (string, bool) {
+	for device, drive := range devices {
+		if len(path) > len(device) && path[len(device)] == '\\' && strings.EqualFold(path[:len(device)], device) {
+			return drive + path[len(device):], true
+		}
+	}
+	return "", false
+}
+
+// queryDevices refreshes the devices of the mounted drives.
+func queryDevices() error {
+	mask, err := windows.GetLogicalDrives()
+	if err != nil {
+		return fmt.Errorf("GetLogicalDrives failed; %w", err)
+	}
+	devices = make(map[string]string)
+	buf := make([]uint16, windows.MAX_PATH)
+	for i := 0; i < 26; i++ {
+		if mask&(1<<uint(i)) == 0 {
+			continue
+		}
+		drive := string(rune('A'+i)) + ":"
+		n, err := windows.QueryDosDevice(windows.StringToUTF16Ptr(drive), &buf[0], uint32(len(buf)))
+		if err != nil || n == 0 {
+			continue // E.g. a disconnected network drive.
+		}
+		devices[windows.UTF16ToString(buf[:n])] = drive
+	}
+	return nil
+}
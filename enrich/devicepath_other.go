@@ -0,0 +1,9 @@
+//+build !windows
+
+package enrich
+
+// defaultResolvePath is the default of ImageHashOptions.ResolvePath. Device
+// paths are Windows-specific, so paths are opened as is.
+func defaultResolvePath(path string) (string, error) {
+	return path, nil
+}
@@ -0,0 +1,157 @@
+package enrich
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of ReverseDNSOptions.
+const (
+	defaultDNSTimeout   = time.Second
+	defaultDNSTTL       = 10 * time.Minute
+	defaultDNSCacheSize = 4096
+)
+
+// Resolver resolves addresses to host names. net.Resolver implements it.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// ReverseDNSOptions describes a ReverseDNS lookup. Zero fields mean
+// defaults.
+type ReverseDNSOptions struct {
+	// Timeout limits a single lookup. A second by default.
+	Timeout time.Duration
+
+	// TTL is a time resolved names (and failures) are cached for. Ten
+	// minutes by default.
+	TTL time.Duration
+
+	// CacheSize is a maximum number of cached addresses. 4096 by default.
+	CacheSize int
+}
+
+// dnsEntry is a cached lookup result. Empty host means the lookup failed.
+type dnsEntry struct {
+	host    string
+	expires time.Time
+}
+
+// dnsCall is a lookup in progress. done is closed when host is set.
+type dnsCall struct {
+	done chan struct{}
+	host string
+}
+
+// ReverseDNS is an IPLookup adding the `Hostname` field with the host name
+// of the address. Results, including failures, are cached, so every address
+// is resolved at most once per TTL. Concurrent lookups of the same address
+// wait for a single query. ReverseDNS is safe for concurrent use.
+type ReverseDNS struct {
+	resolver Resolver
+	cfg      ReverseDNSOptions
+
+	mu       sync.Mutex
+	cache    map[string]dnsEntry
+	inflight map[string]*dnsCall
+}
+
+// NewReverseDNS creates a ReverseDNS lookup using @resolver
+// (net.DefaultResolver if nil).
+func NewReverseDNS(resolver Resolver, cfg ReverseDNSOptions) *ReverseDNS {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultDNSTimeout
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultDNSTTL
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = defaultDNSCacheSize
+	}
+	return &ReverseDNS{
+		resolver: resolver,
+		cfg:      cfg,
+		cache:    make(map[string]dnsEntry),
+		inflight: make(map[string]*dnsCall),
+	}
+}
+
+// LookupIP returns the `Hostname` of @ip or nil if it can't be resolved.
+func (r *ReverseDNS) LookupIP(ctx context.Context, ip net.IP) map[string]interface{} {
+	host := r.lookup(ctx, ip.String())
+	if host == "" {
+		return nil
+	}
+	return map[string]interface{}{"Hostname": host}
+}
+
+// lookup returns a cached host name of @addr resolving it if needed. If
+// @addr is already being resolved, the result of that lookup is awaited
+// instead of querying it once more.
+func (r *ReverseDNS) lookup(ctx context.Context, addr string) string {
+	now := time.Now()
+	r.mu.Lock()
+	if entry, ok := r.cache[addr]; ok && now.Before(entry.expires) {
+		r.mu.Unlock()
+		return entry.host
+	}
+	if call, ok := r.inflight[addr]; ok {
+		r.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.host
+		case <-ctx.Done():
+			return ""
+		}
+	}
+	call := &dnsCall{done: make(chan struct{})}
+	r.inflight[addr] = call
+	r.mu.Unlock()
+
+	call.host = r.resolve(ctx, addr)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.inflight, addr)
+	close(call.done)
+	if _, ok := r.cache[addr]; !ok && len(r.cache) >= r.cfg.CacheSize {
+		r.evict(now)
+	}
+	r.cache[addr] = dnsEntry{host: call.host, expires: now.Add(r.cfg.TTL)}
+	return call.host
+}
+
+// resolve queries the host name of @addr. Returns an empty string if the
+// lookup fails.
+func (r *ReverseDNS) resolve(ctx context.Context, addr string) string {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	names, err := r.resolver.LookupAddr(ctx, addr)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// evict frees a place in the cache dropping expired entries or, if there are
+// none, an arbitrary one.
+func (r *ReverseDNS) evict(now time.Time) {
+	for addr, entry := range r.cache {
+		if !now.Before(entry.expires) {
+			delete(r.cache, addr)
+		}
+	}
+	if len(r.cache) < r.cfg.CacheSize {
+		return
+	}
+	for addr := range r.cache {
+		delete(r.cache, addr)
+		return
+	}
+}
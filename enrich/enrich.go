@@ -0,0 +1,197 @@
+// Package enrich adds derived fields to parsed event properties before the
+// events are forwarded, e.g. host names and geolocation of IP addresses or
+// hashes of process images. Joining them later on the SIEM side is much
+// more expensive:
+//
+//		enricher := enrich.NewIPEnricher([]enrich.IPLookup{
+//			enrich.NewReverseDNS(nil, enrich.ReverseDNSOptions{}),
+//			enrich.GeoLookup(myGeoIPDatabase),
+//		}, enrich.WithFields("daddr", "saddr"), enrich.WithSkipPrivate())
+//		...
+//		props, err := enrich.EventProperties(ctx, e, enricher)
+//
+// Lookups are done synchronously, so use caching ones (as ReverseDNS is) to
+// keep the EventCallback fast.
+package enrich
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Enricher adds derived fields to event properties in place.
+type Enricher interface {
+	Enrich(ctx context.Context, props map[string]interface{})
+}
+
+// EnricherFunc is an adapter to use ordinary functions as Enricher.
+type EnricherFunc func(ctx context.Context, props map[string]interface{})
+
+// Enrich calls f(ctx, props).
+func (f EnricherFunc) Enrich(ctx context.Context, props map[string]interface{}) {
+	f(ctx, props)
+}
+
+// Chain is an Enricher applying enrichers in the given order.
+type Chain []Enricher
+
+// Enrich applies all the chain enrichers to @props.
+func (c Chain) Enrich(ctx context.Context, props map[string]interface{}) {
+	for _, e := range c {
+		e.Enrich(ctx, props)
+	}
+}
+
+// IPLookup finds extra information about an IP address. Returned fields are
+// added to the properties next to the address property.
+type IPLookup interface {
+	LookupIP(ctx context.Context, ip net.IP) map[string]interface{}
+}
+
+// IPLookupFunc is an adapter to use ordinary functions as IPLookup.
+type IPLookupFunc func(ctx context.Context, ip net.IP) map[string]interface{}
+
+// LookupIP calls f(ctx, ip).
+func (f IPLookupFunc) LookupIP(ctx context.Context, ip net.IP) map[string]interface{} {
+	return f(ctx, ip)
+}
+
+// Options describes an IPEnricher.
+type Options struct {
+	// Fields are paths of the IP address properties. Fields of nested
+	// structures are addressed with dots, e.g. `struct.field`. Empty Fields
+	// means any string property holding an IP address.
+	Fields []string
+
+	// SkipPrivate makes loopback, link-local and private network addresses
+	// be not looked up.
+	SkipPrivate bool
+}
+
+// Option is any function that modifies Options.
+type Option func(cfg *Options)
+
+// WithFields limits enriched properties with the given @paths.
+func WithFields(paths ...string) Option {
+	return func(cfg *Options) {
+		cfg.Fields = append(cfg.Fields, paths...)
+	}
+}
+
+// WithSkipPrivate makes addresses of local and private networks be not
+// looked up.
+func WithSkipPrivate() Option {
+	return func(cfg *Options) {
+		cfg.SkipPrivate = true
+	}
+}
+
+// IPEnricher is an Enricher adding results of IPLookups to properties
+// holding IP addresses: a field F returned by the lookup of the property P
+// is added as `P.F` to the same structure. IPEnricher is safe for concurrent
+// use if its lookups are.
+type IPEnricher struct {
+	lookups []IPLookup
+	cfg     Options
+}
+
+// NewIPEnricher creates an IPEnricher applying @lookups in the given order.
+// Fields of the latter lookups override the former ones.
+func NewIPEnricher(lookups []IPLookup, options ...Option) *IPEnricher {
+	var cfg Options
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	return &IPEnricher{lookups: lookups, cfg: cfg}
+}
+
+// Enrich adds lookup results of the IP address properties to @props.
+func (e *IPEnricher) Enrich(ctx context.Context, props map[string]interface{}) {
+	if len(e.cfg.Fields) == 0 {
+		e.enrichAll(ctx, props)
+		return
+	}
+	for _, path := range e.cfg.Fields {
+		container, name := lookup(props, path)
+		if container == nil {
+			continue
+		}
+		e.enrichField(ctx, container, name)
+	}
+}
+
+// enrichAll enriches every IP address property of @props and its nested
+// structures.
+func (e *IPEnricher) enrichAll(ctx context.Context, props map[string]interface{}) {
+	// Properties are added while enriching, so collect names beforehand.
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if nested, ok := props[name].(map[string]interface{}); ok {
+			e.enrichAll(ctx, nested)
+			continue
+		}
+		e.enrichField(ctx, props, name)
+	}
+}
+
+// enrichField adds lookup results of the property @name of @container if
+// it's an IP address.
+func (e *IPEnricher) enrichField(ctx context.Context, container map[string]interface{}, name string) {
+	s, ok := container[name].(string)
+	if !ok {
+		return
+	}
+	ip := net.ParseIP(s)
+	if ip == nil || (e.cfg.SkipPrivate && isPrivate(ip)) {
+		return
+	}
+	for _, l := range e.lookups {
+		for field, value := range l.LookupIP(ctx, ip) {
+			container[name+"."+field] = value
+		}
+	}
+}
+
+//nolint:gochecknoglobals
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// isPrivate returns true if @ip belongs to a local or private network.
+func isPrivate(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns a map holding the field addressed by @path and the field
+// name inside it. Returns nil if any of intermediate structures is missing.
+func lookup(props map[string]interface{}, path string) (map[string]interface{}, string) {
+	parts := strings.Split(path, ".")
+	container := props
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := container[p].(map[string]interface{})
+		if !ok {
+			return nil, ""
+		}
+		container = next
+	}
+	return container, parts[len(parts)-1]
+}
@@ -0,0 +1,152 @@
+package enrich_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bi-zone/etw/enrich"
+)
+
+// resolver is a Resolver counting lookups of preset names.
+type resolver struct {
+	names   map[string]string
+	lookups int
+}
+
+func (r *resolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	r.lookups++
+	if name, ok := r.names[addr]; ok {
+		return []string{name + "."}, nil
+	}
+	return nil, errors.New("not found")
+}
+
+// slowResolver is a Resolver answering every lookup once it's released.
+type slowResolver struct {
+	release chan struct{}
+	lookups int32
+}
+
+func (r *slowResolver) LookupAddr(ctx context.Context, _ string) ([]string, error) {
+	atomic.AddInt32(&r.lookups, 1)
+	select {
+	case <-r.release:
+		return []string{"host.example."}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// geoDB is a GeoIP of preset infos.
+type geoDB map[string]enrich.GeoInfo
+
+func (db geoDB) LookupGeo(ip net.IP) (enrich.GeoInfo, bool) {
+	info, ok := db[ip.String()]
+	return info, ok
+}
+
+func TestIPEnricher(t *testing.T) {
+	r := &resolver{names: map[string]string{"8.8.8.8": "dns.google", "10.0.0.1": "router"}}
+	db := geoDB{"8.8.8.8": {Country: "US", ASN: 15169, Organization: "Google LLC"}}
+	enricher := enrich.NewIPEnricher([]enrich.IPLookup{
+		enrich.NewReverseDNS(r, enrich.ReverseDNSOptions{}),
+		enrich.GeoLookup(db),
+	}, enrich.WithSkipPrivate())
+
+	props := map[string]interface{}{
+		"daddr": "8.8.8.8",
+		"saddr": "10.0.0.1",
+		"name":  "not an address",
+		"conn": map[string]interface{}{
+			"remote": "8.8.8.8",
+		},
+	}
+	enricher.Enrich(context.Background(), props)
+	assert.Equal(t, map[string]interface{}{
+		"daddr":              "8.8.8.8",
+		"daddr.Hostname":     "dns.google",
+		"daddr.Country":      "US",
+		"daddr.ASN":          uint32(15169),
+		"daddr.Organization": "Google LLC",
+		"saddr":              "10.0.0.1",
+		"name":               "not an address",
+		"conn": map[string]interface{}{
+			"remote":              "8.8.8.8",
+			"remote.Hostname":     "dns.google",
+			"remote.Country":      "US",
+			"remote.ASN":          uint32(15169),
+			"remote.Organization": "Google LLC",
+		},
+	}, props, "Unexpected enriched properties")
+	assert.Equal(t, 1, r.lookups, "Resolved names are not cached")
+}
+
+func TestIPEnricherFields(t *testing.T) {
+	lookups := 0
+	enricher := enrich.NewIPEnricher([]enrich.IPLookup{
+		enrich.IPLookupFunc(func(_ context.Context, ip net.IP) map[string]interface{} {
+			lookups++
+			return map[string]interface{}{"Seen": true}
+		}),
+	}, enrich.WithFields("conn.remote", "missing.field"))
+
+	props := map[string]interface{}{
+		"local": "127.0.0.1",
+		"conn":  map[string]interface{}{"remote": "::1"},
+	}
+	enrich.Chain{enricher}.Enrich(context.Background(), props)
+	assert.Equal(t, 1, lookups, "Unexpected number of lookups")
+	assert.Equal(t, true, props["conn"].(map[string]interface{})["remote.Seen"], "Field is not enriched")
+	assert.NotContains(t, props, "local.Seen", "Unlisted field is enriched")
+}
+
+func TestReverseDNS(t *testing.T) {
+	r := &resolver{names: map[string]string{"192.0.2.1": "host.example"}}
+	dns := enrich.NewReverseDNS(r, enrich.ReverseDNSOptions{TTL: 50 * time.Millisecond, CacheSize: 1})
+	ctx := context.Background()
+
+	assert.Equal(t, map[string]interface{}{"Hostname": "host.example"}, dns.LookupIP(ctx, net.ParseIP("192.0.2.1")))
+	assert.Nil(t, dns.LookupIP(ctx, net.ParseIP("192.0.2.2")), "Unresolved address has a host name")
+	assert.Nil(t, dns.LookupIP(ctx, net.ParseIP("192.0.2.2")), "Unresolved address has a host name")
+	assert.Equal(t, 2, r.lookups, "Failures are not cached")
+
+	// The cache holds a single address, so the first one is evicted.
+	dns.LookupIP(ctx, net.ParseIP("192.0.2.1"))
+	assert.Equal(t, 3, r.lookups, "Cache size is not limited")
+
+	time.Sleep(100 * time.Millisecond)
+	dns.LookupIP(ctx, net.ParseIP("192.0.2.1"))
+	assert.Equal(t, 4, r.lookups, "Expired name is not resolved again")
+}
+
+func TestReverseDNSConcurrent(t *testing.T) {
+	r := &slowResolver{release: make(chan struct{})}
+	dns := enrich.NewReverseDNS(r, enrich.ReverseDNSOptions{Timeout: time.Minute})
+
+	const lookups = 10
+	var wg sync.WaitGroup
+	results := make(chan map[string]interface{}, lookups)
+	for i := 0; i < lookups; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- dns.LookupIP(context.Background(), net.ParseIP("192.0.2.1"))
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(r.release)
+	wg.Wait()
+	close(results)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&r.lookups), "Concurrent lookups are not collapsed")
+	for host := range results {
+		assert.Equal(t, map[string]interface{}{"Hostname": "host.example"}, host, "Unexpected waiting lookup result")
+	}
+}
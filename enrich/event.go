@@ -0,0 +1,39 @@
+//+build windows
+
+package enrich
+
+import (
+	"context"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw"
+)
+
+// EventProperties parses properties of @e and applies @enricher to them.
+// Like etw.Event.EventProperties it's valid only inside etw.EventCallback.
+func EventProperties(ctx context.Context, e *etw.Event, enricher Enricher, options ...etw.ParseOption) (map[string]interface{}, error) {
+	props, err := e.EventProperties(options...)
+	if err != nil {
+		return nil, err
+	}
+	enricher.Enrich(ctx, props)
+	return props, nil
+}
+
+// kernelProcessGUID identifies Microsoft-Windows-Kernel-Process provider.
+//
+//nolint:gochecknoglobals
+var kernelProcessGUID = windows.GUID{
+	Data1: 0x22fb2cd6,
+	Data2: 0x0e7b,
+	Data3: 0x422b,
+	Data4: [8]byte{0xa0, 0xc7, 0x2f, 0xad, 0x1f, 0xd0, 0xe7, 0x16},
+}
+
+// IsProcessStart returns true if @h is a header of a process start event of
+// Microsoft-Windows-Kernel-Process provider, the one ImageHasher is meant
+// for.
+func IsProcessStart(h *etw.EventHeader) bool {
+	return h.ProviderID == kernelProcessGUID && h.ID == 1
+}
@@ -0,0 +1,52 @@
+package enrich
+
+import (
+	"context"
+	"net"
+)
+
+// GeoInfo is a geolocation of an IP address. Empty fields are unknown.
+type GeoInfo struct {
+	Country      string // ISO 3166-1 alpha-2 code, e.g. "DE".
+	City         string
+	Latitude     float64
+	Longitude    float64
+	ASN          uint32
+	Organization string
+}
+
+// GeoIP is a GeoIP database, e.g. an adapter of a MaxMind database reader.
+// Returns false if @ip is not found.
+type GeoIP interface {
+	LookupGeo(ip net.IP) (GeoInfo, bool)
+}
+
+// GeoLookup returns an IPLookup adding known GeoInfo fields of addresses
+// found in @db: `Country`, `City`, `Latitude`, `Longitude`, `ASN` and
+// `Organization`.
+func GeoLookup(db GeoIP) IPLookup {
+	return IPLookupFunc(func(_ context.Context, ip net.IP) map[string]interface{} {
+		info, ok := db.LookupGeo(ip)
+		if !ok {
+			return nil
+		}
+		fields := make(map[string]interface{}, 6)
+		if info.Country != "" {
+			fields["Country"] = info.Country
+		}
+		if info.City != "" {
+			fields["City"] = info.City
+		}
+		if info.Latitude != 0 || info.Longitude != 0 {
+			fields["Latitude"] = info.Latitude
+			fields["Longitude"] = info.Longitude
+		}
+		if info.ASN != 0 {
+			fields["ASN"] = info.ASN
+		}
+		if info.Organization != "" {
+			fields["Organization"] = info.Organization
+		}
+		return fields
+	})
+}
@@ -0,0 +1,183 @@
+package enrich
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Defaults of ImageHashOptions.
+const (
+	defaultImageCacheSize  = 4096
+	defaultImageRetryAfter = time.Minute
+)
+
+// defaultImageFields are properties holding image paths of
+// Microsoft-Windows-Kernel-Process events.
+//
+//nolint:gochecknoglobals
+var defaultImageFields = []string{"ImageName"}
+
+// ImageHashOptions describes an ImageHasher. Zero fields mean defaults.
+type ImageHashOptions struct {
+	// Fields are paths of properties holding image paths. Fields of nested
+	// structures are addressed with dots. {"ImageName"} by default, that's
+	// the image of Microsoft-Windows-Kernel-Process events.
+	Fields []string
+
+	// ResolvePath converts paths found in properties to ones that could be
+	// opened. ResolveDevicePath by default on Windows, as kernel events refer
+	// images by device paths; paths are opened as is on other systems.
+	ResolvePath func(path string) (string, error)
+
+	// MaxFileSize is a size of the largest image to be hashed. Zero means
+	// no limit.
+	MaxFileSize int64
+
+	// CacheSize is a maximum number of cached hashes. 4096 by default.
+	CacheSize int
+
+	// RetryAfter is a time images failed to be hashed are not retried for.
+	// A minute by default.
+	RetryAfter time.Duration
+}
+
+// imageEntry is a cached hash of the image file of the given size and
+// modification time or an error hashing it.
+type imageEntry struct {
+	size    int64
+	modTime time.Time
+	hash    string
+	err     error
+	retry   time.Time
+}
+
+// ImageHasher is an Enricher adding the `SHA256` field with the hex-encoded
+// SHA-256 hash of the image file to properties holding image paths, e.g.
+// `ImageName.SHA256`.
+//
+// ImageHasher sees properties only, not the event they belong to, so it
+// hashes images of any event having the Fields. Callers must apply it to
+// process start events only, otherwise e.g. every image load is hashed:
+//
+//		if enrich.IsProcessStart(&e.Header) {
+//			hasher.Enrich(ctx, props)
+//		}
+//
+// Hashes are cached by the path and are recomputed only if the file size or
+// modification time change. Images that can't be hashed (deleted, locked,
+// too large, etc.) get the `SHA256Error` field instead. ImageHasher is safe
+// for concurrent use.
+type ImageHasher struct {
+	cfg ImageHashOptions
+
+	mu    sync.Mutex
+	cache map[string]imageEntry
+}
+
+// NewImageHasher creates an ImageHasher.
+func NewImageHasher(cfg ImageHashOptions) *ImageHasher {
+	if len(cfg.Fields) == 0 {
+		cfg.Fields = defaultImageFields
+	}
+	if cfg.ResolvePath == nil {
+		cfg.ResolvePath = defaultResolvePath
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = defaultImageCacheSize
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = defaultImageRetryAfter
+	}
+	return &ImageHasher{cfg: cfg, cache: make(map[string]imageEntry)}
+}
+
+// Enrich adds hashes of the images referred by @props.
+func (h *ImageHasher) Enrich(_ context.Context, props map[string]interface{}) {
+	for _, path := range h.cfg.Fields {
+		container, name := lookup(props, path)
+		if container == nil {
+			continue
+		}
+		image, ok := container[name].(string)
+		if !ok || image == "" {
+			continue
+		}
+		hash, err := h.Hash(image)
+		if err != nil {
+			container[name+".SHA256Error"] = err.Error()
+			continue
+		}
+		container[name+".SHA256"] = hash
+	}
+}
+
+// Hash returns the hex-encoded SHA-256 hash of the @image file using the
+// cache.
+func (h *ImageHasher) Hash(image string) (string, error) {
+	path, err := h.cfg.ResolvePath(image)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %q; %w", image, err)
+	}
+
+	now := time.Now()
+	h.mu.Lock()
+	entry, cached := h.cache[path]
+	h.mu.Unlock()
+	if cached && entry.err != nil && now.Before(entry.retry) {
+		return "", entry.err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", h.store(path, imageEntry{err: err, retry: now.Add(h.cfg.RetryAfter)})
+	}
+	if cached && entry.err == nil && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.hash, nil
+	}
+	entry = imageEntry{size: info.Size(), modTime: info.ModTime()}
+	if h.cfg.MaxFileSize > 0 && info.Size() > h.cfg.MaxFileSize {
+		entry.err = fmt.Errorf("image %q of %d bytes exceeds %d bytes", path, info.Size(), h.cfg.MaxFileSize)
+	} else {
+		entry.hash, entry.err = hashFile(path)
+	}
+	entry.retry = now.Add(h.cfg.RetryAfter)
+	if err := h.store(path, entry); err != nil {
+		return "", err
+	}
+	return entry.hash, nil
+}
+
+// store caches @entry of @path returning its error.
+func (h *ImageHasher) store(path string, entry imageEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.cache[path]; !ok && len(h.cache) >= h.cfg.CacheSize {
+		for p := range h.cache {
+			delete(h.cache, p)
+			break
+		}
+	}
+	h.cache[path] = entry
+	return entry.err
+}
+
+// hashFile returns the hex-encoded SHA-256 hash of the file at @path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to read %q; %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
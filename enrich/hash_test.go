@@ -0,0 +1,78 @@
+package enrich_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/enrich"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestImageHasher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "enrich")
+	require.NoError(t, err, "Failed to create temp dir")
+	defer os.RemoveAll(dir)
+	image := filepath.Join(dir, "app.exe")
+	require.NoError(t, ioutil.WriteFile(image, []byte("first"), 0600), "Failed to write image")
+
+	hasher := enrich.NewImageHasher(enrich.ImageHashOptions{
+		Fields:      []string{"ImageName", "Parent.ImageName"},
+		MaxFileSize: 10,
+	})
+	props := map[string]interface{}{
+		"ImageName": image,
+		"Parent":    map[string]interface{}{"ImageName": filepath.Join(dir, "missing.exe")},
+	}
+	hasher.Enrich(context.Background(), props)
+	assert.Equal(t, sha256Hex([]byte("first")), props["ImageName.SHA256"], "Unexpected image hash")
+	parent := props["Parent"].(map[string]interface{})
+	assert.NotContains(t, parent, "ImageName.SHA256", "Missing image is hashed")
+	assert.NotEmpty(t, parent["ImageName.SHA256Error"], "Missing image error is not reported")
+
+	// Changed images are hashed again.
+	require.NoError(t, ioutil.WriteFile(image, []byte("second"), 0600), "Failed to write image")
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(image, later, later), "Failed to touch image")
+	hash, err := hasher.Hash(image)
+	require.NoError(t, err, "Failed to hash image")
+	assert.Equal(t, sha256Hex([]byte("second")), hash, "Changed image hash is cached")
+
+	require.NoError(t, ioutil.WriteFile(image, []byte("too large image"), 0600), "Failed to write image")
+	_, err = hasher.Hash(image)
+	assert.Error(t, err, "Too large image is hashed")
+}
+
+func TestImageHasherResolvePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "enrich")
+	require.NoError(t, err, "Failed to create temp dir")
+	defer os.RemoveAll(dir)
+	image := filepath.Join(dir, "app.exe")
+	require.NoError(t, ioutil.WriteFile(image, []byte("app"), 0600), "Failed to write image")
+
+	const devicePath = `\Device\HarddiskVolume3\app.exe`
+	hasher := enrich.NewImageHasher(enrich.ImageHashOptions{
+		ResolvePath: func(path string) (string, error) {
+			if path != devicePath {
+				return "", fmt.Errorf("unknown path %q", path)
+			}
+			return image, nil
+		},
+	})
+	props := map[string]interface{}{"ImageName": devicePath}
+	hasher.Enrich(context.Background(), props)
+	assert.Equal(t, sha256Hex([]byte("app")), props["ImageName.SHA256"], "Resolved image is not hashed")
+}
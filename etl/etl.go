@@ -0,0 +1,179 @@
+//+build windows
+
+// Package etl allows you to manipulate .etl files produced by ETW sessions:
+// merge several files into one and split a large file into smaller ones.
+//
+// All functions are built on top of the ETW relogger, so events are streamed
+// from the source files to the destination one without loading them into
+// memory.
+package etl
+
+/*
+	#cgo LDFLAGS: -lole32 -loleaut32
+
+	#include "etl_relogger.h"
+*/
+import "C"
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Record describes a relogged event. Record is a subset of etw.EventHeader
+// that is enough to make a decision whether to keep an event or not.
+type Record struct {
+	ProviderID windows.GUID
+	ID         uint16
+	Level      uint8
+	TimeStamp  time.Time
+}
+
+// Filter is any function that decides whether the event described by @r
+// should be written to the destination file.
+//
+// Filter is called synchronously and sequentially on every event of the
+// source files.
+type Filter func(r Record) bool
+
+// Relog reads events from the @sources .etl files and writes ones accepted
+// by @filter to the @destination file. Having several sources events are
+// written in the timestamp order. Nil @filter accepts every event.
+func Relog(destination string, sources []string, filter Filter) error {
+	if len(sources) == 0 {
+		return fmt.Errorf("no source files given")
+	}
+	if filter == nil {
+		filter = func(Record) bool { return true }
+	}
+
+	dst, err := windows.UTF16FromString(destination)
+	if err != nil {
+		return fmt.Errorf("incorrect destination file name; %w", err)
+	}
+	// Sources are passed as a sequence of NULL-terminated strings terminated
+	// with an empty one to not pass Go pointers to the memory with Go pointers.
+	var src []uint16
+	for _, s := range sources {
+		name, err := windows.UTF16FromString(s)
+		if err != nil {
+			return fmt.Errorf("incorrect source file name %q; %w", s, err)
+		}
+		src = append(src, name...)
+	}
+	src = append(src, 0)
+
+	key := newFilterKey(filter)
+	defer freeFilterKey(key)
+
+	// COM is initialized per thread, so keep the whole processing on a single one.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	hr := C.Relog(
+		C.LPWSTR(unsafe.Pointer(&dst[0])),
+		C.LPWSTR(unsafe.Pointer(&src[0])),
+		C.PVOID(key),
+	)
+	if hr < 0 {
+		return fmt.Errorf("relogger failed; HRESULT 0x%08X", uint32(hr))
+	}
+	return nil
+}
+
+// Merge merges all @sources .etl files into the single time-ordered
+// @destination file.
+func Merge(destination string, sources ...string) error {
+	return Relog(destination, sources, nil)
+}
+
+// SplitByTime writes events of the @source file that happened in a
+// [@from, @to) time range to the @destination file. Zero @from or @to mean
+// no limit on the corresponding side.
+func SplitByTime(source, destination string, from, to time.Time) error {
+	return Relog(destination, []string{source}, func(r Record) bool {
+		if !from.IsZero() && r.TimeStamp.Before(from) {
+			return false
+		}
+		if !to.IsZero() && !r.TimeStamp.Before(to) {
+			return false
+		}
+		return true
+	})
+}
+
+// SplitByProvider writes events of the @source file produced by any of the
+// @providers to the @destination file.
+func SplitByProvider(source, destination string, providers ...windows.GUID) error {
+	return Relog(destination, []string{source}, func(r Record) bool {
+		for _, p := range providers {
+			if r.ProviderID == p {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// We can't pass Go-land pointers to the C-world so we use a classical trick
+// storing real pointers inside global map and passing to C "fake pointers"
+// which are actually map keys.
+//
+//nolint:gochecknoglobals
+var (
+	filters       sync.Map
+	filterCounter uintptr
+)
+
+// newFilterKey stores a @filter inside a global storage returning its' key.
+// After use the key should be freed using `freeFilterKey`.
+func newFilterKey(filter Filter) uintptr {
+	key := atomic.AddUintptr(&filterCounter, 1)
+	filters.Store(key, filter)
+
+	return key
+}
+
+func freeFilterKey(key uintptr) {
+	filters.Delete(key)
+}
+
+// relogFilter is exported to guarantee C calling convention (cdecl).
+//
+// The function should be defined here but would be linked and used inside
+// C code in `relogger.c`.
+//
+//export relogFilter
+func relogFilter(eventRecord C.PEVENT_RECORD, ctx C.PVOID) C.int {
+	filter, ok := filters.Load(uintptr(ctx))
+	if !ok {
+		return 0
+	}
+
+	header := eventRecord.EventHeader
+	r := Record{
+		ProviderID: *(*windows.GUID)(unsafe.Pointer(&header.ProviderId)),
+		ID:         uint16(header.EventDescriptor.Id),
+		Level:      uint8(header.EventDescriptor.Level),
+		TimeStamp:  stampToTime(C.RelogGetTimeStamp(header)),
+	}
+	if filter.(Filter)(r) {
+		return 1
+	}
+	return 0
+}
+
+// stampToTime translates FileTime to a golang time. Same as in standard packages.
+func stampToTime(quadPart C.LONGLONG) time.Time {
+	ft := windows.Filetime{
+		HighDateTime: uint32(quadPart >> 32),
+		LowDateTime:  uint32(quadPart & math.MaxUint32),
+	}
+	return time.Unix(0, ft.Nanoseconds())
+}
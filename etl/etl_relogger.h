@@ -0,0 +1,27 @@
+#ifndef ETL_RELOGGER_H
+#define ETL_RELOGGER_H
+
+// MinGW headers are always restricted to the lowest possible Windows version,
+// so specify Win7+ manually.
+#undef _WIN32_WINNT
+#define _WIN32_WINNT _WIN32_WINNT_WIN7
+
+// Use C-style macros to call COM interfaces methods.
+#define COBJMACROS
+
+#include <windows.h>
+#include <evntcons.h>
+#include <relogger.h>
+
+// Relog reads events from the @sources .etl files and writes ones accepted by
+// the Go-side filter identified by @ctx to the @destination file. @sources is
+// a sequence of NULL-terminated strings terminated with an empty string.
+//
+// Events from multiple sources are written in the timestamp order.
+HRESULT Relog(LPWSTR destination, LPWSTR sources, PVOID ctx);
+
+// Event header unions getters. Prefixed to not clash with the etw package
+// symbols being linked into the same binary.
+LONGLONG RelogGetTimeStamp(EVENT_HEADER header);
+
+#endif // ETL_RELOGGER_H
@@ -0,0 +1,85 @@
+// +build windows
+
+package etl_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw"
+	"github.com/bi-zone/etw/etl"
+	"github.com/bi-zone/etw/testutil"
+)
+
+func TestRelog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etl")
+	require.NoError(t, err, "Failed to create temp dir")
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source.etl")
+	provider := writeTrace(t, source)
+
+	merged := filepath.Join(dir, "merged.etl")
+	require.NoError(t, etl.Merge(merged, source), "Failed to relog file")
+	assert.NotZero(t, countEvents(t, merged, provider), "Relogged file has no provider events")
+
+	split := filepath.Join(dir, "split.etl")
+	require.NoError(t, etl.SplitByProvider(source, split, windows.GUID{Data1: 0x1}), "Failed to split file")
+	assert.Zero(t, countEvents(t, split, provider), "Filtered out events are relogged")
+}
+
+// writeTrace writes events of a test provider to the .etl file at @path and
+// returns the provider GUID.
+func writeTrace(t *testing.T, path string) windows.GUID {
+	const deadline = 10 * time.Second
+	provider, err := testutil.NewProvider("TestRelogProvider")
+	require.NoError(t, err, "Failed to register test provider")
+	defer provider.Close()
+
+	session, err := etw.NewSession(provider.GUID(), etw.WithLogFile(path))
+	require.NoError(t, err, "Failed to create session")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go provider.Generate(ctx, "Relog", etw.TRACE_LEVEL_INFORMATION, testutil.Uint32("value", 42))
+
+	gotEvent := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Process(func(e *etw.Event) {
+			select {
+			case gotEvent <- struct{}{}:
+			default:
+			}
+		})
+	}()
+	select {
+	case <-gotEvent:
+	case <-time.After(deadline):
+		t.Fatal("Failed to receive event from provider")
+	}
+	cancel()
+	require.NoError(t, session.Close(), "Failed to close session")
+	require.NoError(t, <-done, "Error processing events")
+	return provider.GUID()
+}
+
+// countEvents returns a number of @provider events in the file at @path.
+func countEvents(t *testing.T, path string, provider windows.GUID) int {
+	var count int
+	err := etw.ProcessFile(path, func(e *etw.Event) {
+		if e.Header.ProviderID == provider {
+			count++
+		}
+	})
+	require.NoError(t, err, "Failed to process file %s", path)
+	return count
+}
@@ -0,0 +1,123 @@
+#include "etl_relogger.h"
+
+// GUIDs are defined manually cos MinGW doesn't ship the corresponding libuuid
+// symbols for the relogger interfaces.
+static const CLSID relogCLSID = {0x7b40792d, 0x05ff, 0x44c4, {0x90, 0x58, 0xf4, 0x40, 0xc7, 0x1f, 0x17, 0xd4}};
+static const IID relogIID = {0xf754ad43, 0x3bcc, 0x4286, {0x80, 0x09, 0x9c, 0x5d, 0xa2, 0x14, 0xe8, 0x4e}};
+static const IID callbackIID = {0x3ed25501, 0x593f, 0x43e9, {0x8f, 0x38, 0x3a, 0xb4, 0x6f, 0x5a, 0x4a, 0x52}};
+
+// relogFilter is exported from Go. Returns non-zero if the event should be
+// written to the destination file.
+extern int relogFilter(PEVENT_RECORD e, PVOID ctx);
+
+// relogCallback is a minimal ITraceEventCallback implementation passing every
+// event to the Go-side filter. It lives on the stack of the Relog call, so
+// reference counting is a formality here.
+typedef struct {
+    ITraceEventCallbackVtbl* lpVtbl;
+    LONG refCount;
+    PVOID ctx;
+} relogCallback;
+
+static HRESULT STDMETHODCALLTYPE callbackQueryInterface(ITraceEventCallback* this, REFIID riid, void** object) {
+    if (IsEqualIID(riid, &callbackIID) || IsEqualIID(riid, &IID_IUnknown)) {
+        *object = this;
+        this->lpVtbl->AddRef(this);
+        return S_OK;
+    }
+    *object = NULL;
+    return E_NOINTERFACE;
+}
+
+static ULONG STDMETHODCALLTYPE callbackAddRef(ITraceEventCallback* this) {
+    return InterlockedIncrement(&((relogCallback*)this)->refCount);
+}
+
+static ULONG STDMETHODCALLTYPE callbackRelease(ITraceEventCallback* this) {
+    return InterlockedDecrement(&((relogCallback*)this)->refCount);
+}
+
+static HRESULT STDMETHODCALLTYPE callbackOnBeginProcessTrace(ITraceEventCallback* this, ITraceEvent* header, ITraceRelogger* relogger) {
+    return S_OK;
+}
+
+static HRESULT STDMETHODCALLTYPE callbackOnFinalizeProcessTrace(ITraceEventCallback* this, ITraceRelogger* relogger) {
+    return S_OK;
+}
+
+static HRESULT STDMETHODCALLTYPE callbackOnEvent(ITraceEventCallback* this, ITraceEvent* event, ITraceRelogger* relogger) {
+    PEVENT_RECORD record = NULL;
+    HRESULT hr = ITraceEvent_GetEventRecord(event, &record);
+    if (FAILED(hr)) {
+        return hr;
+    }
+    if (relogFilter(record, ((relogCallback*)this)->ctx)) {
+        return ITraceRelogger_Inject(relogger, event);
+    }
+    return S_OK;
+}
+
+static ITraceEventCallbackVtbl callbackVtbl = {
+    callbackQueryInterface,
+    callbackAddRef,
+    callbackRelease,
+    callbackOnBeginProcessTrace,
+    callbackOnFinalizeProcessTrace,
+    callbackOnEvent,
+};
+
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/relogger/nn-relogger-itracerelogger
+HRESULT Relog(LPWSTR destination, LPWSTR sources, PVOID ctx) {
+    ITraceRelogger* relogger = NULL;
+    relogCallback callback = {&callbackVtbl, 1, ctx};
+    BSTR output = NULL;
+    HRESULT hr = S_OK;
+
+    hr = CoInitializeEx(NULL, COINIT_MULTITHREADED);
+    if (FAILED(hr)) {
+        return hr;
+    }
+
+    hr = CoCreateInstance(&relogCLSID, NULL, CLSCTX_INPROC_SERVER, &relogIID, (void**)&relogger);
+    if (FAILED(hr)) {
+        goto cleanup;
+    }
+
+    for (LPWSTR source = sources; *source != L'\0'; source += wcslen(source) + 1) {
+        TRACEHANDLE handle = 0;
+        BSTR logFile = SysAllocString(source);
+        hr = ITraceRelogger_AddLogfileTraceStream(relogger, logFile, NULL, &handle);
+        SysFreeString(logFile);
+        if (FAILED(hr)) {
+            goto cleanup;
+        }
+    }
+
+    output = SysAllocString(destination);
+    hr = ITraceRelogger_SetOutputFilename(relogger, output);
+    if (FAILED(hr)) {
+        goto cleanup;
+    }
+
+    hr = ITraceRelogger_RegisterCallback(relogger, (ITraceEventCallback*)&callback);
+    if (FAILED(hr)) {
+        goto cleanup;
+    }
+
+    // Blocks until all the sources are processed.
+    hr = ITraceRelogger_ProcessTrace(relogger);
+
+cleanup:
+    if (output != NULL) {
+        SysFreeString(output);
+    }
+    if (relogger != NULL) {
+        ITraceRelogger_Release(relogger);
+    }
+    CoUninitialize();
+    return hr;
+}
+
+LONGLONG RelogGetTimeStamp(EVENT_HEADER header) {
+    return header.TimeStamp.QuadPart;
+}
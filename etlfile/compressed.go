@@ -0,0 +1,227 @@
+package etlfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Compression is a format of a compressed .etl file. Traces shipped from
+// endpoints are usually compressed, Open reads them transparently.
+type Compression int
+
+const (
+	// NoCompression is a plain .etl file.
+	NoCompression Compression = iota
+	// Gzip is a gzip stream of a single .etl file, e.g. `trace.etl.gz`.
+	Gzip
+	// Zip is a zip archive holding an .etl file, e.g. `trace.etl.zip`. The
+	// first entry having the .etl extension is read, or the only entry if
+	// none has it.
+	Zip
+)
+
+// String returns a name of the compression.
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zip:
+		return "zip"
+	default:
+		return fmt.Sprintf("Compression(%d)", int(c))
+	}
+}
+
+// DetectCompression returns a compression of the file starting with
+// @magic. At least 4 bytes are needed to detect all the formats.
+func DetectCompression(magic []byte) Compression {
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1F, 0x8B}):
+		return Gzip
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return Zip
+	default:
+		return NoCompression
+	}
+}
+
+// openFile opens the file at @path for reading decompressing it if needed.
+// The returned closer closes the decompressor and the file.
+func openFile(path string) (io.Reader, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file; %w", err)
+	}
+	r, closer, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return r, closer, nil
+}
+
+// decompress returns a reader of the uncompressed contents of @f.
+func decompress(f *os.File) (io.Reader, io.Closer, error) {
+	magic := make([]byte, 4)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, nil, fmt.Errorf("failed to read file; %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("failed to read file; %w", err)
+	}
+
+	switch DetectCompression(magic[:n]) {
+	case Gzip:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read gzip stream; %w", err)
+		}
+		return gz, closers{gz, f}, nil
+	case Zip:
+		info, err := f.Stat()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read zip archive; %w", err)
+		}
+		archive, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read zip archive; %w", err)
+		}
+		entry, err := findETLEntry(archive)
+		if err != nil {
+			return nil, nil, err
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read zip entry %q; %w", entry.Name, err)
+		}
+		return rc, closers{rc, f}, nil
+	default:
+		return f, f, nil
+	}
+}
+
+// findETLEntry returns the .etl file entry of @archive.
+func findETLEntry(archive *zip.Reader) (*zip.File, error) {
+	var files []*zip.File
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		if strings.EqualFold(filepath.Ext(entry.Name), ".etl") {
+			return entry, nil
+		}
+		files = append(files, entry)
+	}
+	if len(files) != 1 {
+		return nil, fmt.Errorf("no .etl file in the zip archive of %d files", len(files))
+	}
+	return files[0], nil
+}
+
+// closers closes all the closers in order returning the first error.
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var firstErr error
+	for _, closer := range c {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Decompress makes an uncompressed copy of the compressed .etl file at @path
+// in the @dir directory (the default temporary directory if @dir is empty),
+// so it could be consumed by tools requiring plain files, e.g.
+// etw.ProcessFile. Plain files are not copied: @path itself is returned.
+//
+// Remove the copy calling @cleanup after use; it's a no-op for plain files.
+func Decompress(path, dir string) (plain string, cleanup func() error, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open file; %w", err)
+	}
+	defer f.Close()
+	r, closer, err := decompress(f)
+	if err != nil {
+		return "", nil, err
+	}
+	defer closer.Close()
+	if r == io.Reader(f) {
+		return path, func() error { return nil }, nil
+	}
+
+	tmp, err := ioutil.TempFile(dir, "*.etl")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create file; %w", err)
+	}
+	cleanup = func() error { return os.Remove(tmp.Name()) }
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		_ = cleanup()
+		return "", nil, fmt.Errorf("failed to decompress file; %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = cleanup()
+		return "", nil, fmt.Errorf("failed to write file; %w", err)
+	}
+	return tmp.Name(), cleanup, nil
+}
+
+// Compress writes the .etl file at @src to @dst compressed with @c, e.g. to
+// ship a trace exported by the etl package. Zip archives hold a single
+// entry named after @src.
+func Compress(dst, src string, c Compression) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file; %w", err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create file; %w", err)
+	}
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to write file; %w", closeErr)
+		}
+	}()
+
+	var w io.WriteCloser
+	switch c {
+	case Gzip:
+		gz := gzip.NewWriter(out)
+		gz.Name = filepath.Base(src)
+		w = gz
+	case Zip:
+		archive := zip.NewWriter(out)
+		entry, err := archive.Create(filepath.Base(src))
+		if err != nil {
+			return fmt.Errorf("failed to create zip entry; %w", err)
+		}
+		w = struct {
+			io.Writer
+			io.Closer
+		}{entry, archive}
+	default:
+		return fmt.Errorf("unsupported compression %s", c)
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return fmt.Errorf("failed to compress file; %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to compress file; %w", err)
+	}
+	return nil
+}
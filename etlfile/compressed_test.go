@@ -0,0 +1,66 @@
+package etlfile_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/etlfile"
+)
+
+func TestCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etlfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := buffer(0, logfileHeaderEvent(), manifestEvent(0))
+	plain := filepath.Join(dir, "trace.etl")
+	require.NoError(t, ioutil.WriteFile(plain, file, 0600))
+
+	for _, c := range []etlfile.Compression{etlfile.Gzip, etlfile.Zip} {
+		compressed := filepath.Join(dir, "trace."+c.String())
+		require.NoError(t, etlfile.Compress(compressed, plain, c), "Failed to compress with %s", c)
+
+		head := make([]byte, 4)
+		f, err := os.Open(compressed)
+		require.NoError(t, err)
+		_, err = io.ReadFull(f, head)
+		f.Close()
+		require.NoError(t, err)
+		assert.Equal(t, c, etlfile.DetectCompression(head), "Unexpected compression detected")
+
+		r, err := etlfile.Open(compressed)
+		require.NoError(t, err, "Failed to open %s file", c)
+		assert.Equal(t, "test-logger", r.Header().LoggerName)
+		events := 0
+		for {
+			_, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			events++
+		}
+		assert.Equal(t, 2, events, "Unexpected number of %s file events", c)
+		require.NoError(t, r.Close())
+
+		decompressed, cleanup, err := etlfile.Decompress(compressed, dir)
+		require.NoError(t, err, "Failed to decompress %s file", c)
+		data, err := ioutil.ReadFile(decompressed)
+		require.NoError(t, err)
+		assert.Equal(t, file, data, "Unexpected %s file contents", c)
+		require.NoError(t, cleanup())
+		_, err = os.Stat(decompressed)
+		assert.True(t, os.IsNotExist(err), "Decompressed file is not removed")
+	}
+
+	same, cleanup, err := etlfile.Decompress(plain, dir)
+	require.NoError(t, err)
+	assert.Equal(t, plain, same, "Plain file is copied")
+	require.NoError(t, cleanup())
+}
@@ -0,0 +1,17 @@
+package etlfile
+
+import (
+	"fmt"
+
+	"github.com/bi-zone/etw/manifest"
+)
+
+// Decode decodes the event payload using the provider manifest @m. Only
+// manifest-based events (with EVENT_HEADER) could be decoded.
+func (e *Event) Decode(m *manifest.Manifest, options ...manifest.DecodeOption) (map[string]interface{}, error) {
+	if e.HeaderType != TRACE_HEADER_TYPE_EVENT_HEADER32 && e.HeaderType != TRACE_HEADER_TYPE_EVENT_HEADER64 {
+		return nil, fmt.Errorf("events with header type %d have no manifest", e.HeaderType)
+	}
+	options = append([]manifest.DecodeOption{manifest.WithPointerSize(e.Header.PointerSize)}, options...)
+	return m.Decode(e.Header.ProviderID.String(), e.Header.ID, e.Header.Version, e.UserData, options...)
+}
@@ -0,0 +1,501 @@
+// Package etlfile implements a pure Go reader of .etl files produced by ETW
+// sessions. Unlike etw.ProcessFile it doesn't need Windows, so analysis
+// pipelines could process collected traces on any platform.
+//
+// The reader parses buffers and event headers only. Event payloads are
+// returned as is; decode them using provider manifests (see the manifest
+// package and Event.Decode):
+//
+//		r, err := etlfile.Open("trace.etl")
+//		...
+//		defer r.Close()
+//		for {
+//			e, err := r.Next()
+//			if err == io.EOF {
+//				break
+//			}
+//			...
+//			props, err := e.Decode(m)
+//		}
+//
+// N.B. ETW writes events to per-CPU buffers, so events are ordered by time
+// within a buffer only. Sort them by Header.TimeStamp if you need a global
+// order.
+package etlfile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bi-zone/etw/internal/logheader"
+)
+
+// ErrMalformed is returned (wrapped) if the file structure is broken. Next
+// could be called again after it to continue with the next buffer.
+var ErrMalformed = errors.New("malformed etl file")
+
+// HeaderType is a type of event header. Besides modern EVENT_HEADER based
+// events files contain classic kernel (system and perfinfo) events and
+// MOF-based events.
+type HeaderType uint8
+
+//nolint:golint,stylecheck // We keep original names to underline that it's an external constants.
+const (
+	TRACE_HEADER_TYPE_SYSTEM32       HeaderType = 1
+	TRACE_HEADER_TYPE_SYSTEM64       HeaderType = 2
+	TRACE_HEADER_TYPE_COMPACT32      HeaderType = 3
+	TRACE_HEADER_TYPE_COMPACT64      HeaderType = 4
+	TRACE_HEADER_TYPE_FULL_HEADER32  HeaderType = 10
+	TRACE_HEADER_TYPE_INSTANCE32     HeaderType = 11
+	TRACE_HEADER_TYPE_PERFINFO32     HeaderType = 16
+	TRACE_HEADER_TYPE_PERFINFO64     HeaderType = 17
+	TRACE_HEADER_TYPE_EVENT_HEADER32 HeaderType = 18
+	TRACE_HEADER_TYPE_EVENT_HEADER64 HeaderType = 19
+	TRACE_HEADER_TYPE_FULL_HEADER64  HeaderType = 20
+	TRACE_HEADER_TYPE_INSTANCE64     HeaderType = 21
+)
+
+// Sizes of the fixed parts of headers.
+const (
+	bufferHeaderSize      = 72
+	systemHeaderSize      = 32
+	compactHeaderSize     = 24
+	perfinfoHeaderSize    = 16
+	fullHeaderSize        = 48
+	eventHeaderSize       = 80
+	maxBufferSize         = 64 << 20
+	eventHeaderFlagExtend = 0x0001
+)
+
+// GUID is a binary GUID in Windows layout.
+type GUID [16]byte
+
+// String renders @g in the registry format, the same way as windows.GUID.
+func (g GUID) String() string {
+	return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}",
+		binary.LittleEndian.Uint32(g[0:4]),
+		binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]),
+		g[8:10],
+		g[10:16],
+	)
+}
+
+// EventHeader contains information common for every event. Fields that
+// aren't defined by the event HeaderType are left zero.
+type EventHeader struct {
+	ID      uint16
+	Version uint8
+	Channel uint8
+	Level   uint8
+	OpCode  uint8
+	Task    uint16
+	Keyword uint64
+
+	ThreadID  uint32
+	ProcessID uint32
+	TimeStamp time.Time
+
+	ProviderID GUID
+	ActivityID GUID
+
+	Flags           uint16
+	KernelTime      uint32
+	UserTime        uint32
+	ProcessorNumber uint8
+
+	// PointerSize is a size of pointers in the payload.
+	PointerSize int
+}
+
+// ExtendedItem is a single item of the event extended data.
+type ExtendedItem struct {
+	Type uint16
+	Data []byte
+}
+
+// Event is a single event read from the file. Event is not reused by the
+// Reader and could be retained.
+type Event struct {
+	Header     EventHeader
+	HeaderType HeaderType
+
+	// HookID identifies classic kernel events (system, compact and perfinfo
+	// headers): event group in the high byte and event type in the low one.
+	HookID uint16
+
+	Extended []ExtendedItem
+	UserData []byte
+
+	rawStamp int64
+}
+
+// LogfileHeader holds the session parameters stored in the first event of
+// the file (TRACE_LOGFILE_HEADER).
+type LogfileHeader struct {
+	BufferSize         uint32
+	Version            uint32
+	ProviderVersion    uint32
+	NumberOfProcessors uint32
+	EndTime            time.Time
+	TimerResolution    uint32
+	MaximumFileSize    uint32
+	LogFileMode        uint32
+	BuffersWritten     uint32
+	PointerSize        uint32
+	EventsLost         uint32
+	CPUSpeedInMHz      uint32
+	BootTime           time.Time
+	PerfFreq           int64
+	StartTime          time.Time
+
+	// ClockType is the session clock: 1 -- QPC, 2 -- system time, 3 -- CPU
+	// cycle counter.
+	ClockType   uint32
+	BuffersLost uint32
+
+	LoggerName  string
+	LogFileName string
+}
+
+// Reader reads events from an .etl file.
+type Reader struct {
+	r      io.Reader
+	closer io.Closer
+
+	header LogfileHeader
+
+	// Timestamps conversion parameters.
+	syncStamp int64
+	syncTime  time.Time
+	freq      int64
+
+	buf       []byte // The current buffer.
+	pos, end  int
+	processor uint8
+
+	// pending is the header event read by NewReader.
+	pending *Event
+}
+
+// Open opens an .etl file at @path. Gzip and zip compressed files are
+// decompressed on the fly (take a look at Compression). Reader should be
+// closed via `.Close`.
+func Open(path string) (*Reader, error) {
+	f, closer, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		closer.Close()
+		return nil, err
+	}
+	r.closer = closer
+	return r, nil
+}
+
+// NewReader creates a Reader of the .etl file contents read from @r. The
+// first event of the file (holding the LogfileHeader) is read immediately.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: r}
+	if err := reader.nextBuffer(); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = fmt.Errorf("%w: empty file", ErrMalformed)
+		}
+		return nil, err
+	}
+	e, err := reader.readEvent()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logfile header; %w", err)
+	}
+	if e == nil {
+		return nil, fmt.Errorf("%w: no logfile header", ErrMalformed)
+	}
+	if e.HeaderType != TRACE_HEADER_TYPE_SYSTEM32 && e.HeaderType != TRACE_HEADER_TYPE_SYSTEM64 {
+		return nil, fmt.Errorf("%w: unexpected first event header type %d", ErrMalformed, e.HeaderType)
+	}
+	if err := reader.parseLogfileHeader(e); err != nil {
+		return nil, err
+	}
+	reader.pending = e
+	return reader, nil
+}
+
+// Header returns the session parameters stored in the file.
+func (r *Reader) Header() LogfileHeader {
+	return r.header
+}
+
+// Next returns the next event or io.EOF at the end of the file. The first
+// event is the system one holding the LogfileHeader.
+func (r *Reader) Next() (*Event, error) {
+	if e := r.pending; e != nil {
+		r.pending = nil
+		return e, nil
+	}
+	for {
+		if r.pos < r.end {
+			e, err := r.readEvent()
+			if err != nil {
+				r.pos = r.end // Skip the rest of the broken buffer.
+				return nil, err
+			}
+			if e != nil {
+				return e, nil
+			}
+			continue
+		}
+		if err := r.nextBuffer(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Close closes the underlying file if the Reader was created by Open.
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// nextBuffer reads the next WMI_BUFFER_HEADER prefixed buffer.
+func (r *Reader) nextBuffer() error {
+	var hdr [bufferHeaderSize]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: truncated buffer header", ErrMalformed)
+		}
+		return err
+	}
+	size := int(binary.LittleEndian.Uint32(hdr[0:]))
+	if size < bufferHeaderSize || size > maxBufferSize {
+		return fmt.Errorf("%w: invalid buffer size %d", ErrMalformed, size)
+	}
+	if cap(r.buf) < size {
+		r.buf = make([]byte, size)
+	}
+	r.buf = r.buf[:size]
+	copy(r.buf, hdr[:])
+	if _, err := io.ReadFull(r.r, r.buf[bufferHeaderSize:]); err != nil {
+		return fmt.Errorf("%w: truncated buffer; %v", ErrMalformed, err)
+	}
+
+	// Offset is the end of the filled part of the buffer, SavedOffset is
+	// used by older systems.
+	end := int(binary.LittleEndian.Uint32(hdr[48:]))
+	if end <= bufferHeaderSize || end > size {
+		end = int(binary.LittleEndian.Uint32(hdr[4:]))
+	}
+	if end <= bufferHeaderSize || end > size {
+		end = size
+	}
+	r.pos, r.end = bufferHeaderSize, end
+	r.processor = hdr[40]
+	return nil
+}
+
+// readEvent reads an event at the current position. Returns nil event at
+// the end of the buffer data.
+func (r *Reader) readEvent() (*Event, error) {
+	data := r.buf[r.pos:r.end]
+	if len(data) < 4 {
+		r.pos = r.end
+		return nil, nil
+	}
+	marker := binary.LittleEndian.Uint32(data)
+	if marker == 0 || marker == 0xFFFFFFFF {
+		r.pos = r.end // Padding till the end of the buffer.
+		return nil, nil
+	}
+	if marker&0xC0000000 != 0xC0000000 {
+		return nil, fmt.Errorf("%w: invalid event marker %#x", ErrMalformed, marker)
+	}
+
+	e := &Event{HeaderType: HeaderType(marker >> 16)}
+	e.Header.ProcessorNumber = r.processor
+
+	var size, headerSize int
+	switch e.HeaderType {
+	case TRACE_HEADER_TYPE_SYSTEM32, TRACE_HEADER_TYPE_SYSTEM64,
+		TRACE_HEADER_TYPE_COMPACT32, TRACE_HEADER_TYPE_COMPACT64,
+		TRACE_HEADER_TYPE_PERFINFO32, TRACE_HEADER_TYPE_PERFINFO64:
+		if len(data) < perfinfoHeaderSize {
+			return nil, fmt.Errorf("%w: truncated event header", ErrMalformed)
+		}
+		size = int(binary.LittleEndian.Uint16(data[4:]))
+	default:
+		size = int(marker & 0xFFFF)
+	}
+	if size > len(data) {
+		return nil, fmt.Errorf("%w: event size %d exceeds remaining %d bytes", ErrMalformed, size, len(data))
+	}
+	data = data[:size]
+
+	switch e.HeaderType {
+	case TRACE_HEADER_TYPE_SYSTEM32, TRACE_HEADER_TYPE_SYSTEM64:
+		headerSize = systemHeaderSize
+	case TRACE_HEADER_TYPE_COMPACT32, TRACE_HEADER_TYPE_COMPACT64:
+		headerSize = compactHeaderSize
+	case TRACE_HEADER_TYPE_PERFINFO32, TRACE_HEADER_TYPE_PERFINFO64:
+		headerSize = perfinfoHeaderSize
+	case TRACE_HEADER_TYPE_FULL_HEADER32, TRACE_HEADER_TYPE_FULL_HEADER64,
+		TRACE_HEADER_TYPE_INSTANCE32, TRACE_HEADER_TYPE_INSTANCE64:
+		headerSize = fullHeaderSize
+	case TRACE_HEADER_TYPE_EVENT_HEADER32, TRACE_HEADER_TYPE_EVENT_HEADER64:
+		headerSize = eventHeaderSize
+	default:
+		// Unknown headers are skipped as a whole.
+		headerSize = 4
+	}
+	if size < headerSize {
+		return nil, fmt.Errorf("%w: event size %d is less than header size %d", ErrMalformed, size, headerSize)
+	}
+	r.pos += align8(size)
+
+	h := &e.Header
+	h.PointerSize = 8
+	stampOffset := 16
+	if headerSize == perfinfoHeaderSize {
+		stampOffset = 8
+	}
+	if headerSize > 4 {
+		e.rawStamp = int64(binary.LittleEndian.Uint64(data[stampOffset:]))
+	}
+	switch e.HeaderType {
+	case TRACE_HEADER_TYPE_SYSTEM32, TRACE_HEADER_TYPE_COMPACT32, TRACE_HEADER_TYPE_PERFINFO32,
+		TRACE_HEADER_TYPE_FULL_HEADER32, TRACE_HEADER_TYPE_INSTANCE32, TRACE_HEADER_TYPE_EVENT_HEADER32:
+		h.PointerSize = 4
+	}
+
+	switch headerSize {
+	case systemHeaderSize, compactHeaderSize:
+		e.HookID = binary.LittleEndian.Uint16(data[6:])
+		h.ThreadID = binary.LittleEndian.Uint32(data[8:])
+		h.ProcessID = binary.LittleEndian.Uint32(data[12:])
+		h.TimeStamp = r.toTime(e.rawStamp)
+		if headerSize == systemHeaderSize {
+			h.KernelTime = binary.LittleEndian.Uint32(data[24:])
+			h.UserTime = binary.LittleEndian.Uint32(data[28:])
+		}
+
+	case perfinfoHeaderSize:
+		e.HookID = binary.LittleEndian.Uint16(data[6:])
+		h.TimeStamp = r.toTime(e.rawStamp)
+
+	case fullHeaderSize:
+		h.OpCode = data[4]
+		h.Level = data[5]
+		h.Version = uint8(binary.LittleEndian.Uint16(data[6:]))
+		h.ThreadID = binary.LittleEndian.Uint32(data[8:])
+		h.ProcessID = binary.LittleEndian.Uint32(data[12:])
+		h.TimeStamp = r.toTime(e.rawStamp)
+		if e.HeaderType == TRACE_HEADER_TYPE_FULL_HEADER32 || e.HeaderType == TRACE_HEADER_TYPE_FULL_HEADER64 {
+			copy(h.ProviderID[:], data[24:40])
+		}
+		h.KernelTime = binary.LittleEndian.Uint32(data[40:])
+		h.UserTime = binary.LittleEndian.Uint32(data[44:])
+
+	case eventHeaderSize:
+		h.Flags = binary.LittleEndian.Uint16(data[4:])
+		h.ThreadID = binary.LittleEndian.Uint32(data[8:])
+		h.ProcessID = binary.LittleEndian.Uint32(data[12:])
+		h.TimeStamp = r.toTime(e.rawStamp)
+		copy(h.ProviderID[:], data[24:40])
+		h.ID = binary.LittleEndian.Uint16(data[40:])
+		h.Version = data[42]
+		h.Channel = data[43]
+		h.Level = data[44]
+		h.OpCode = data[45]
+		h.Task = binary.LittleEndian.Uint16(data[46:])
+		h.Keyword = binary.LittleEndian.Uint64(data[48:])
+		h.KernelTime = binary.LittleEndian.Uint32(data[56:])
+		h.UserTime = binary.LittleEndian.Uint32(data[60:])
+		copy(h.ActivityID[:], data[64:80])
+	}
+
+	payload := data[headerSize:]
+	if headerSize == eventHeaderSize && h.Flags&eventHeaderFlagExtend != 0 {
+		var err error
+		if e.Extended, payload, err = parseExtended(payload); err != nil {
+			return nil, err
+		}
+	}
+	e.UserData = append([]byte(nil), payload...)
+	return e, nil
+}
+
+// parseExtended parses extended data items preceding the user data. Every
+// item has an 8 bytes header (reserved, type, linkage flag and data size)
+// and is aligned to 8 bytes; the linkage flag is set for all items but the
+// last one.
+func parseExtended(data []byte) ([]ExtendedItem, []byte, error) {
+	var items []ExtendedItem
+	for {
+		if len(data) < 8 {
+			return nil, nil, fmt.Errorf("%w: truncated extended data item", ErrMalformed)
+		}
+		itemType := binary.LittleEndian.Uint16(data[2:])
+		linkage := binary.LittleEndian.Uint16(data[4:])&1 != 0
+		size := int(binary.LittleEndian.Uint16(data[6:]))
+		if 8+size > len(data) {
+			return nil, nil, fmt.Errorf("%w: extended data item size %d exceeds remaining %d bytes",
+				ErrMalformed, size, len(data)-8)
+		}
+		items = append(items, ExtendedItem{
+			Type: itemType,
+			Data: append([]byte(nil), data[8:8+size]...),
+		})
+		next := 8 + align8(size)
+		if next > len(data) {
+			next = len(data)
+		}
+		data = data[next:]
+		if !linkage {
+			return items, data, nil
+		}
+	}
+}
+
+// parseLogfileHeader parses TRACE_LOGFILE_HEADER from the payload of the
+// first event and sets up timestamps conversion.
+func (r *Reader) parseLogfileHeader(e *Event) error {
+	h, err := logheader.Parse(e.UserData, e.Header.PointerSize)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrMalformed, err)
+	}
+	r.header = LogfileHeader(h)
+
+	r.syncTime = h.StartTime
+	r.syncStamp = e.rawStamp
+	switch h.ClockType {
+	case 2: // System time: timestamps are FILETIME.
+		r.freq = 0
+	case 3: // CPU cycle counter.
+		r.freq = int64(h.CPUSpeedInMHz) * 1000000
+	default: // QPC.
+		r.freq = h.PerfFreq
+	}
+	// The header event itself was read before the clock was known.
+	e.Header.TimeStamp = r.syncTime
+	return nil
+}
+
+// toTime converts a raw event timestamp to time.Time.
+func (r *Reader) toTime(stamp int64) time.Time {
+	if r.freq <= 0 {
+		return logheader.FiletimeToTime(stamp)
+	}
+	delta := stamp - r.syncStamp
+	sec, rem := delta/r.freq, delta%r.freq
+	ns := sec*int64(time.Second) + int64(float64(rem)*float64(time.Second)/float64(r.freq))
+	return r.syncTime.Add(time.Duration(ns))
+}
+
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
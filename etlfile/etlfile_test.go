@@ -0,0 +1,205 @@
+package etlfile_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/etlfile"
+	"github.com/bi-zone/etw/manifest"
+)
+
+const bufferSize = 1024
+
+var (
+	startTime    = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sampleGUID   = []byte{0x7E, 0x1C, 0x3A, 0x5B, 0x2F, 0x0D, 0x8A, 0x4E, 0x9C, 0x61, 0x2B, 0x7D, 0x4F, 0x0A, 0x8E, 0x13}
+	le           = binary.LittleEndian
+	headerStamp  = uint64(1000)
+	perfFreq     = uint64(10000000)
+	fileTimeDiff = uint64(116444736000000000)
+)
+
+func utf16z(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s + "\x00")) {
+		b = append(b, byte(c), byte(c>>8))
+	}
+	return b
+}
+
+// buffer builds a WMI_BUFFER_HEADER prefixed buffer with @events.
+func buffer(processor byte, events ...[]byte) []byte {
+	b := make([]byte, bufferSize)
+	offset := 72
+	for _, e := range events {
+		copy(b[offset:], e)
+		offset += (len(e) + 7) &^ 7
+	}
+	le.PutUint32(b[0:], bufferSize)
+	le.PutUint32(b[4:], uint32(offset))
+	b[40] = processor
+	le.PutUint32(b[48:], uint32(offset))
+	for i := offset; i < bufferSize; i++ {
+		b[i] = 0xFF
+	}
+	return b
+}
+
+func logfileHeaderEvent() []byte {
+	payload := make([]byte, 280)
+	le.PutUint32(payload[0:], bufferSize)
+	le.PutUint32(payload[12:], 4) // NumberOfProcessors
+	le.PutUint32(payload[44:], 8) // PointerSize
+	le.PutUint32(payload[48:], 3) // EventsLost
+	le.PutUint64(payload[256:], perfFreq)
+	le.PutUint64(payload[264:], uint64(startTime.UnixNano()/100)+fileTimeDiff)
+	le.PutUint32(payload[272:], 1) // QPC clock
+	payload = append(payload, utf16z("test-logger")...)
+	payload = append(payload, utf16z(`C:\trace.etl`)...)
+
+	e := make([]byte, 32, 32+len(payload))
+	le.PutUint32(e[0:], 0xC0000000|uint32(etlfile.TRACE_HEADER_TYPE_SYSTEM64)<<16|2)
+	le.PutUint16(e[4:], uint16(32+len(payload)))
+	le.PutUint32(e[8:], 11)  // ThreadID
+	le.PutUint32(e[12:], 22) // ProcessID
+	le.PutUint64(e[16:], headerStamp)
+	return append(e, payload...)
+}
+
+func manifestEvent(delay time.Duration) []byte {
+	ext := make([]byte, 16)
+	le.PutUint16(ext[2:], 5) // Type
+	le.PutUint16(ext[6:], 4) // Size
+	copy(ext[8:], []byte{1, 2, 3, 4})
+
+	payload := make([]byte, 8)
+	le.PutUint32(payload[0:], 7) // ProcessID
+	le.PutUint32(payload[4:], 1) // Enabled
+
+	e := make([]byte, 80, 80+len(ext)+len(payload))
+	size := uint32(80 + len(ext) + len(payload))
+	le.PutUint32(e[0:], 0xC0000000|uint32(etlfile.TRACE_HEADER_TYPE_EVENT_HEADER64)<<16|size)
+	le.PutUint16(e[4:], 0x40|0x01) // 64-bit header with extended info.
+	le.PutUint32(e[8:], 33)
+	le.PutUint32(e[12:], 44)
+	le.PutUint64(e[16:], headerStamp+uint64(delay.Seconds()*float64(perfFreq)))
+	copy(e[24:], sampleGUID)
+	le.PutUint16(e[40:], 1) // ID
+	e[42] = 1               // Version
+	e[44] = 4               // Level
+	le.PutUint64(e[48:], 0x10)
+	return append(append(e, ext...), payload...)
+}
+
+func perfinfoEvent() []byte {
+	e := make([]byte, 24)
+	le.PutUint32(e[0:], 0xC0000000|uint32(etlfile.TRACE_HEADER_TYPE_PERFINFO64)<<16|2)
+	le.PutUint16(e[4:], 24)
+	le.PutUint16(e[6:], 0x0A10)
+	le.PutUint64(e[8:], headerStamp+2*perfFreq)
+	le.PutUint64(e[16:], 0xDEADBEEF)
+	return e
+}
+
+func TestReader(t *testing.T) {
+	var file []byte
+	file = append(file, buffer(0, logfileHeaderEvent(), manifestEvent(time.Second))...)
+	file = append(file, buffer(1, perfinfoEvent())...)
+
+	r, err := etlfile.NewReader(bytes.NewReader(file))
+	require.NoError(t, err, "Failed to open reader")
+
+	h := r.Header()
+	assert.Equal(t, uint32(bufferSize), h.BufferSize)
+	assert.Equal(t, uint32(4), h.NumberOfProcessors)
+	assert.Equal(t, uint32(3), h.EventsLost)
+	assert.Equal(t, uint32(1), h.ClockType)
+	assert.Equal(t, "test-logger", h.LoggerName)
+	assert.Equal(t, `C:\trace.etl`, h.LogFileName)
+	assert.True(t, startTime.Equal(h.StartTime), "Unexpected start time %s", h.StartTime)
+
+	header, err := r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, etlfile.TRACE_HEADER_TYPE_SYSTEM64, header.HeaderType)
+	assert.Equal(t, uint32(22), header.Header.ProcessID)
+
+	e, err := r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, etlfile.TRACE_HEADER_TYPE_EVENT_HEADER64, e.HeaderType)
+	assert.Equal(t, "{5B3A1C7E-0D2F-4E8A-9C61-2B7D4F0A8E13}", e.Header.ProviderID.String())
+	assert.Equal(t, uint16(1), e.Header.ID)
+	assert.Equal(t, uint8(1), e.Header.Version)
+	assert.Equal(t, uint8(4), e.Header.Level)
+	assert.Equal(t, uint64(0x10), e.Header.Keyword)
+	assert.Equal(t, uint32(33), e.Header.ThreadID)
+	assert.Equal(t, 8, e.Header.PointerSize)
+	assert.True(t, startTime.Add(time.Second).Equal(e.Header.TimeStamp), "Unexpected timestamp %s", e.Header.TimeStamp)
+	assert.Equal(t, []etlfile.ExtendedItem{{Type: 5, Data: []byte{1, 2, 3, 4}}}, e.Extended)
+
+	m, err := manifest.ParseFile("../manifest/testdata/sample.man")
+	require.NoError(t, err, "Failed to parse manifest")
+	props, err := e.Decode(m)
+	require.NoError(t, err, "Failed to decode event")
+	assert.Equal(t, map[string]interface{}{"ProcessID": "7", "Enabled": "true"}, props)
+
+	perf, err := r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, etlfile.TRACE_HEADER_TYPE_PERFINFO64, perf.HeaderType)
+	assert.Equal(t, uint16(0x0A10), perf.HookID)
+	assert.Equal(t, uint8(1), perf.Header.ProcessorNumber)
+	assert.True(t, startTime.Add(2*time.Second).Equal(perf.Header.TimeStamp), "Unexpected timestamp %s", perf.Header.TimeStamp)
+	assert.Len(t, perf.UserData, 8)
+	_, err = perf.Decode(m)
+	assert.Error(t, err, "Expected an error decoding a kernel event")
+
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMalformed(t *testing.T) {
+	valid := buffer(0, logfileHeaderEvent(), manifestEvent(0))
+
+	_, err := etlfile.NewReader(bytes.NewReader(nil))
+	assert.True(t, errors.Is(err, etlfile.ErrMalformed), "Unexpected error for empty file: %v", err)
+
+	_, err = etlfile.NewReader(bytes.NewReader(valid[:bufferSize/2]))
+	assert.True(t, errors.Is(err, etlfile.ErrMalformed), "Unexpected error for truncated file: %v", err)
+
+	// The first event should be a logfile header.
+	_, err = etlfile.NewReader(bytes.NewReader(buffer(0, manifestEvent(0))))
+	assert.True(t, errors.Is(err, etlfile.ErrMalformed), "Unexpected error for missing header: %v", err)
+
+	// A broken buffer is skipped.
+	broken := buffer(0, manifestEvent(0))
+	le.PutUint32(broken[72:], 0xC0000000|uint32(etlfile.TRACE_HEADER_TYPE_EVENT_HEADER64)<<16|2000)
+	file := append(append(append([]byte(nil), valid...), broken...), buffer(0, perfinfoEvent())...)
+
+	r, err := etlfile.NewReader(bytes.NewReader(file))
+	require.NoError(t, err, "Failed to open reader")
+	var (
+		events int
+		errs   int
+	)
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			assert.True(t, errors.Is(err, etlfile.ErrMalformed), "Unexpected error: %v", err)
+			errs++
+			continue
+		}
+		events++
+	}
+	assert.Equal(t, 3, events, "Unexpected number of events")
+	assert.Equal(t, 1, errs, "Unexpected number of errors")
+}
@@ -7,6 +7,7 @@ package etw
 */
 import "C"
 import (
+	"errors"
 	"fmt"
 	"math"
 	"time"
@@ -15,15 +16,39 @@ import (
 	"golang.org/x/sys/windows"
 )
 
+// ErrMalformedEvent is returned (wrapped) by the Event methods if event data
+// doesn't match the event schema, e.g. property offsets point out of the
+// event payload. Use errors.Is to check for it.
+var ErrMalformedEvent = errors.New("malformed event")
+
 // Event is a single event record received from ETW provider. The only thing
 // that is parsed implicitly is an EventHeader (which just translated from C
 // structures mostly 1:1), all other data are parsed on-demand.
 //
 // Events will be passed to the user EventCallback. It's invalid to use Event
 // methods outside of an EventCallback.
+//
+// ETW has no continuation mechanism for large events: the whole event
+// payload should fit a single session buffer and is limited with 64KB
+// (EVENT_RECORD.UserDataLength is 16-bit wide). Larger events are dropped
+// by the provider, smaller ones could be dropped by the session if they
+// don't fit its buffers -- take a look at WithBufferSize.
 type Event struct {
 	Header      EventHeader
 	eventRecord C.PEVENT_RECORD
+	userContext interface{}
+	labels      map[string]string
+	stats       *providerCounters
+
+	// stackTrace is a kernel stack attached from a StackWalk event.
+	stackTrace *EventStackTrace
+}
+
+// Context returns a value attached to the session the event belongs to by
+// Session.SetContext or nil if nothing is attached. Unlike other Event
+// methods Context is valid outside of EventCallback too.
+func (e *Event) Context() interface{} {
+	return e.userContext
 }
 
 // EventHeader contains an information that is common for every ETW event
@@ -46,6 +71,12 @@ type EventHeader struct {
 	KernelTime    uint32
 	UserTime      uint32
 	ProcessorTime uint64
+
+	// HeaderSize is EVENT_HEADER.Size and UserDataLength is a size of the
+	// event payload in bytes (EVENT_RECORD.UserDataLength). They are enough
+	// for volume accounting without parsing properties.
+	HeaderSize     uint16
+	UserDataLength uint16
 }
 
 // HasCPUTime returns true if the event has separate UserTime and KernelTime
@@ -62,6 +93,42 @@ func (h EventHeader) HasCPUTime() bool {
 	}
 }
 
+// HasActivityID returns true if the event belongs to an activity, i.e. its
+// ActivityID is not zero.
+func (h EventHeader) HasActivityID() bool {
+	return h.ActivityID != windows.GUID{}
+}
+
+// PointerSize returns a size of pointers in the event payload: 4 for events
+// emitted by 32-bit processes and 8 for 64-bit ones.
+func (h EventHeader) PointerSize() int {
+	if h.Flags&C.EVENT_HEADER_FLAG_32_BIT_HEADER != 0 {
+		return 4
+	}
+	return 8
+}
+
+// IsClassic returns true if the event is written by a classic (MOF) provider
+// or the kernel logger. Classic events have zero EventDescriptor.ID, their
+// type is identified by EventDescriptor.OpCode and Version.
+func (h EventHeader) IsClassic() bool {
+	return h.Flags&C.EVENT_HEADER_FLAG_CLASSIC_HEADER != 0
+}
+
+// DecodingSource tells where the event schema comes from.
+//
+// For more info refer to DECODING_SOURCE docs:
+// https://docs.microsoft.com/en-us/windows/win32/api/tdh/ne-tdh-decoding_source
+type DecodingSource int
+
+//nolint:golint,stylecheck // We keep original names to underline that it's an external constants.
+const (
+	DecodingSourceXMLFile = DecodingSource(0) // Instrumentation manifest.
+	DecodingSourceWbem    = DecodingSource(1) // MOF class, e.g. kernel logger events.
+	DecodingSourceWPP     = DecodingSource(2) // WPP TMF file.
+	DecodingSourceTlg     = DecodingSource(3) // TraceLogging self-describing event.
+)
+
 // EventDescriptor contains low-level metadata that defines received event.
 // Most of fields could be used to refine events filtration.
 //
@@ -93,35 +160,309 @@ type EventDescriptor struct {
 //		- `string` for any other values.
 //
 // Take a look at `TestParsing` for possible EventProperties values.
-func (e *Event) EventProperties() (map[string]interface{}, error) {
-	if e.eventRecord == nil {
-		return nil, fmt.Errorf("usage of Event is invalid outside of EventCallback")
+//
+// Events written with EventWriteString (having EVENT_HEADER_FLAG_STRING_ONLY
+// flag set) have no schema, their payload is a single string returned under
+// the "_" key.
+//
+// By default a single unparsable property fails the whole event. Pass
+// WithBestEffortParsing to get ParseError values for broken properties
+// instead.
+func (e *Event) EventProperties(options ...ParseOption) (map[string]interface{}, error) {
+	if err := e.checkRecord("EventProperties"); err != nil {
+		return nil, err
+	}
+	var cfg ParseOptions
+	for _, opt := range options {
+		opt(&cfg)
 	}
+	return e.eventProperties(cfg, nil)
+}
 
-	if e.eventRecord.EventHeader.Flags == C.EVENT_HEADER_FLAG_STRING_ONLY {
-		return map[string]interface{}{
-			"_": C.GoString((*C.char)(e.eventRecord.UserData)),
-		}, nil
+// eventProperties implements EventProperties storing properties to @dst if
+// it's not nil.
+func (e *Event) eventProperties(cfg ParseOptions, dst map[string]interface{}) (map[string]interface{}, error) {
+	properties, partial, err := e.parseProperties(cfg, dst)
+	if e.stats != nil {
+		e.stats.recordParse(err != nil || partial)
 	}
+	return properties, err
+}
 
-	p, err := newPropertyParser(e.eventRecord)
+// DecodingSource returns a source of the event schema used by
+// EventProperties. Only WithSchemaCache of @options is taken into account.
+func (e *Event) DecodingSource(options ...ParseOption) (DecodingSource, error) {
+	plan, err := e.schemaPlan("DecodingSource", options)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse event properties; %w", err)
+		return 0, err
+	}
+	return plan.decodingSource, nil
+}
+
+// HasProperty returns true if the event schema defines a top-level property
+// named @name. It's much cheaper than EventProperties (especially with
+// WithSchemaCache in @options) and helps to handle properties added in new
+// event versions. Only WithSchemaCache of @options is taken into account.
+func (e *Event) HasProperty(name string, options ...ParseOption) (bool, error) {
+	if e.eventRecord != nil && e.eventRecord.EventHeader.Flags&C.EVENT_HEADER_FLAG_STRING_ONLY != 0 {
+		return name == "_", nil
+	}
+	plan, err := e.schemaPlan("HasProperty", options)
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < plan.topLevelCount && i < len(plan.properties); i++ {
+		if plan.properties[i].name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// schemaPlan returns a parse plan of the event schema for the Event
+// @method.
+func (e *Event) schemaPlan(method string, options []ParseOption) (*parsePlan, error) {
+	if err := e.checkRecord(method); err != nil {
+		return nil, err
+	}
+	if e.eventRecord.EventHeader.Flags&C.EVENT_HEADER_FLAG_STRING_ONLY != 0 {
+		return nil, fmt.Errorf("string-only event has no schema")
+	}
+	var cfg ParseOptions
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	p, err := newPropertyParser(e.eventRecord, cfg.SchemaCache)
+	if err != nil {
+		return nil, err
 	}
 	defer p.free()
+	return p.plan, nil
+}
 
-	properties := make(map[string]interface{}, int(p.info.TopLevelPropertyCount))
+// UserData returns a copy of the raw event payload. It's useful to decode
+// events with external schemas, e.g. instrumentation manifests.
+func (e *Event) UserData() ([]byte, error) {
+	if err := e.checkRecord("UserData"); err != nil {
+		return nil, err
+	}
+	return C.GoBytes(unsafe.Pointer(e.eventRecord.UserData), C.int(e.eventRecord.UserDataLength)), nil
+}
+
+// PayloadSize returns a size of the raw event payload in bytes. Unlike other
+// Event methods it's valid outside of EventCallback too.
+func (e *Event) PayloadSize() int {
+	return int(e.Header.UserDataLength)
+}
+
+// parseProperties implements EventProperties. Properties are stored to
+// @properties map if it's not nil. @partial is true if some properties were
+// replaced with ParseError values in best-effort mode.
+func (e *Event) parseProperties(cfg ParseOptions, properties map[string]interface{}) (_ map[string]interface{}, partial bool, err error) {
+	if e.eventRecord.EventHeader.Flags&C.EVENT_HEADER_FLAG_STRING_ONLY != 0 {
+		// The payload is a null-terminated UTF-16 string.
+		length := int(e.eventRecord.UserDataLength) / 2
+		if properties == nil {
+			properties = make(map[string]interface{}, 1)
+		}
+		properties["_"] = createUTF16String(unsafe.Pointer(e.eventRecord.UserData), length)
+		return properties, false, nil
+	}
+
+	p, err := newPropertyParser(e.eventRecord, cfg.SchemaCache)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse event properties; %w", err)
+	}
+	defer p.free()
+	if err := checkLimit("MaxProperties", int(p.info.PropertyCount), cfg.Limits.MaxProperties); err != nil {
+		return nil, false, fmt.Errorf("failed to parse event properties; %w", err)
+	}
+	p.limits = cfg.Limits
+	p.mapValues = cfg.MapValues
+	p.binary, p.binaryProperties = cfg.Binary, cfg.BinaryProperties
+	p.invariant = cfg.InvariantFormatting
+	p.schemaArtifacts = cfg.SchemaArtifacts
+
+	var lostOffset error
+	if properties == nil {
+		size := p.plan.visibleTopLevel
+		if cfg.SchemaArtifacts {
+			size = p.plan.topLevelCount
+		}
+		properties = make(map[string]interface{}, size)
+	}
 	for i := 0; i < int(p.info.TopLevelPropertyCount); i++ {
 		name := p.getPropertyName(i)
+		if lostOffset != nil {
+			if !p.isVisible(i) {
+				continue
+			}
+			properties[name] = ParseError{Property: name, Err: lostOffset}
+			partial = true
+			continue
+		}
+
+		start := p.data
 		value, err := p.getPropertyValue(i)
 		if err != nil {
 			// Parsing values we consume given event data buffer with var length chunks.
 			// If we skip any -- we'll lost offset, so fail early.
-			return nil, fmt.Errorf("failed to parse %q value; %w", name, err)
+			if !cfg.BestEffort {
+				return nil, false, fmt.Errorf("failed to parse %q value; %w", name, err)
+			}
+			// Unless the schema tells us the property size to skip it.
+			if p.isVisible(i) {
+				properties[name] = ParseError{Property: name, Err: err}
+				partial = true
+			}
+			if !p.skipProperty(i, start) {
+				lostOffset = fmt.Errorf("data offset is lost after %q parsing failure", name)
+			}
+			continue
+		}
+		if p.isVisible(i) {
+			properties[name] = value
+		}
+	}
+	return properties, partial, nil
+}
+
+// ParseOptions describes how Event.EventProperties parses event data.
+type ParseOptions struct {
+	// BestEffort makes EventProperties record parsing errors as ParseError
+	// map values and continue parsing subsequent properties instead of
+	// failing the whole event.
+	BestEffort bool
+
+	// SchemaCache is used to get event schemas instead of querying TDH for
+	// every event. Nil SchemaCache means no caching.
+	SchemaCache *SchemaCache
+
+	// Limits protect the parser from pathological events.
+	Limits ParseLimits
+
+	// MapValues makes integer properties having value maps or bitmaps be
+	// returned as MapValue holding both the raw value and the names.
+	MapValues bool
+
+	// Binary is a format of binary properties, BinaryProperties overrides
+	// it for properties with the given names.
+	Binary           BinaryFormat
+	BinaryProperties map[string]BinaryFormat
+
+	// InvariantFormatting makes locale-dependent values (floats, booleans
+	// and timestamps) be formatted the same way on every system. Take a
+	// look at WithInvariantFormatting.
+	InvariantFormatting bool
+
+	// SchemaArtifacts makes EventProperties return "<name>.Count" properties
+	// TDH synthesizes for sizes of variable-length arrays. They duplicate
+	// array lengths, so they are hidden by default. Take a look at
+	// HideSchemaArtifacts.
+	SchemaArtifacts bool
+}
+
+// ParseLimits restrict the shape of events EventProperties agrees to parse.
+// Malicious or buggy providers could emit events with huge arrays, strings
+// or deeply nested structures making the parser spend a lot of memory and
+// CPU. Exceeded limits are reported with LimitError. Zero value means no
+// limit.
+type ParseLimits struct {
+	MaxProperties   int // Number of properties in the event schema.
+	MaxArrayLength  int // Number of elements of a single array.
+	MaxStringLength int // Number of UTF-16 code units of a single formatted value.
+	MaxStructDepth  int // Nesting level of structures.
+}
+
+// LimitError is returned (wrapped) by EventProperties if the event exceeds
+// one of ParseLimits. Use errors.As to check for it.
+type LimitError struct {
+	Limit string // Name of the ParseLimits field, e.g. "MaxArrayLength".
+	Value int
+	Max   int
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded: %d > %d", e.Limit, e.Value, e.Max)
+}
+
+// checkLimit returns LimitError if @value exceeds non-zero limit @max.
+func checkLimit(limit string, value, max int) error {
+	if max > 0 && value > max {
+		return LimitError{Limit: limit, Value: value, Max: max}
+	}
+	return nil
+}
+
+// utf16Length returns a number of UTF-16 code units encoding @s. It's the
+// unit of MaxStringLength since TDH reports formatted sizes in it.
+func utf16Length(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
 		}
-		properties[name] = value
 	}
-	return properties, nil
+	return n
+}
+
+// ParseOption is any function that modifies ParseOptions.
+type ParseOption func(cfg *ParseOptions)
+
+// WithBestEffortParsing makes EventProperties return ParseError values for
+// properties that can't be parsed instead of failing the whole event.
+//
+// Properties are stored sequentially in the event data, so parsing could be
+// continued only if the event schema defines a fixed size for the broken
+// property. Otherwise all subsequent properties are ParseError too.
+func WithBestEffortParsing() ParseOption {
+	return func(cfg *ParseOptions) {
+		cfg.BestEffort = true
+	}
+}
+
+// WithParseLimits makes EventProperties fail on events exceeding @limits.
+// In best-effort mode properties exceeding limits are returned as ParseError.
+func WithParseLimits(limits ParseLimits) ParseOption {
+	return func(cfg *ParseOptions) {
+		cfg.Limits = limits
+	}
+}
+
+// WithSchemaCache makes EventProperties take event schemas from @cache
+// instead of querying TDH for every event.
+func WithSchemaCache(cache *SchemaCache) ParseOption {
+	return func(cfg *ParseOptions) {
+		cfg.SchemaCache = cache
+	}
+}
+
+// HideSchemaArtifacts controls whether EventProperties hides "<name>.Count"
+// properties TDH adds to schemas of variable-length arrays, e.g.
+// "stringArray.Count" holding the length of "stringArray". They are hidden
+// by default, pass HideSchemaArtifacts(false) to get them.
+func HideSchemaArtifacts(hide bool) ParseOption {
+	return func(cfg *ParseOptions) {
+		cfg.SchemaArtifacts = !hide
+	}
+}
+
+// ParseError is stored as a property value by EventProperties in best-effort
+// mode if the property can't be parsed.
+type ParseError struct {
+	Property string
+	Err      error
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("failed to parse %q value; %s", e.Property, e.Err)
+}
+
+func (e ParseError) Unwrap() error {
+	return e.Err
 }
 
 // ExtendedEventInfo contains additional information about received event. All
@@ -134,8 +475,18 @@ func (e *Event) EventProperties() (map[string]interface{}, error) {
 // documentation:
 // https://docs.microsoft.com/en-us/windows/win32/api/evntcons/ns-evntcons-event_header_extended_data_item
 type ExtendedEventInfo struct {
-	SessionID    *uint32
-	ActivityID   *windows.GUID
+	SessionID *uint32
+
+	// RelatedActivityID is an ID of the activity that caused the event
+	// activity, e.g. the parent activity of an activity start event. The
+	// event's own activity ID is EventHeader.ActivityID.
+	RelatedActivityID *windows.GUID
+
+	// ActivityID is the same as RelatedActivityID.
+	//
+	// Deprecated: the name is misleading, use RelatedActivityID.
+	ActivityID *windows.GUID
+
 	UserSID      *windows.SID
 	InstanceInfo *EventInstanceInfo
 	StackTrace   *EventStackTrace
@@ -159,14 +510,109 @@ type EventStackTrace struct {
 //
 // If no ExtendedEventInfo is available inside an event record function returns
 // the structure with all fields set to nil.
+//
+// Kernel stacks captured with WithKernelStackWalk are returned as StackTrace
+// too.
 func (e *Event) ExtendedInfo() ExtendedEventInfo {
-	if e.eventRecord == nil { // Usage outside of event callback.
+	if e.checkRecord("ExtendedInfo") != nil {
 		return ExtendedEventInfo{}
 	}
-	if e.eventRecord.EventHeader.Flags&C.EVENT_HEADER_FLAG_EXTENDED_INFO == 0 {
-		return ExtendedEventInfo{}
+	var info ExtendedEventInfo
+	if e.eventRecord.EventHeader.Flags&C.EVENT_HEADER_FLAG_EXTENDED_INFO != 0 {
+		info = e.parseExtendedInfo()
+	}
+	if e.stackTrace != nil {
+		info.StackTrace = e.stackTrace
+	}
+	return info
+}
+
+// RelatedActivityID returns the ID of the activity related to the event one
+// (see ExtendedEventInfo.RelatedActivityID) without decoding other extended
+// data items. Returns false if the event has no related activity.
+func (e *Event) RelatedActivityID() (windows.GUID, bool) {
+	if e.checkRecord("RelatedActivityID") != nil || e.eventRecord.EventHeader.Flags&C.EVENT_HEADER_FLAG_EXTENDED_INFO == 0 {
+		return windows.GUID{}, false
+	}
+	for i := 0; i < int(e.eventRecord.ExtendedDataCount); i++ {
+		if C.GetExtType(e.eventRecord.ExtendedData, C.int(i)) != C.EVENT_HEADER_EXT_TYPE_RELATED_ACTIVITYID {
+			continue
+		}
+		dataPtr := unsafe.Pointer(uintptr(C.GetDataPtr(e.eventRecord.ExtendedData, C.int(i))))
+		return windowsGUIDToGo(*(C.LPGUID)(dataPtr)), true
+	}
+	return windows.GUID{}, false
+}
+
+// IsRelatedTo returns true if @e belongs to the activity @activityID or it's
+// the activity @activityID has caused, e.g. @activityID is a parent activity
+// of @e activity.
+func (e *Event) IsRelatedTo(activityID windows.GUID) bool {
+	if activityID == (windows.GUID{}) {
+		return false
+	}
+	if e.Header.ActivityID == activityID {
+		return true
+	}
+	related, ok := e.RelatedActivityID()
+	return ok && related == activityID
+}
+
+// ExtendedDataType is a type of the event extended data item, i.e.
+// EVENT_HEADER_EXTENDED_DATA_ITEM.ExtType.
+type ExtendedDataType uint16
+
+//nolint:golint,stylecheck // We keep original names to underline that it's an external constants.
+const (
+	EVENT_HEADER_EXT_TYPE_RELATED_ACTIVITYID = ExtendedDataType(0x0001)
+	EVENT_HEADER_EXT_TYPE_SID                = ExtendedDataType(0x0002)
+	EVENT_HEADER_EXT_TYPE_TS_ID              = ExtendedDataType(0x0003)
+	EVENT_HEADER_EXT_TYPE_INSTANCE_INFO      = ExtendedDataType(0x0004)
+	EVENT_HEADER_EXT_TYPE_STACK_TRACE32      = ExtendedDataType(0x0005)
+	EVENT_HEADER_EXT_TYPE_STACK_TRACE64      = ExtendedDataType(0x0006)
+	EVENT_HEADER_EXT_TYPE_PEBS_INDEX         = ExtendedDataType(0x0007)
+	EVENT_HEADER_EXT_TYPE_PMC_COUNTERS       = ExtendedDataType(0x0008)
+	EVENT_HEADER_EXT_TYPE_PSM_KEY            = ExtendedDataType(0x0009)
+	EVENT_HEADER_EXT_TYPE_EVENT_KEY          = ExtendedDataType(0x000A)
+	EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL    = ExtendedDataType(0x000B)
+	EVENT_HEADER_EXT_TYPE_PROV_TRAITS        = ExtendedDataType(0x000C)
+	EVENT_HEADER_EXT_TYPE_PROCESS_START_KEY  = ExtendedDataType(0x000D)
+	EVENT_HEADER_EXT_TYPE_CONTROL_GUID       = ExtendedDataType(0x000E)
+	EVENT_HEADER_EXT_TYPE_QPC_DELTA          = ExtendedDataType(0x000F)
+	EVENT_HEADER_EXT_TYPE_CONTAINER_ID       = ExtendedDataType(0x0010)
+	EVENT_HEADER_EXT_TYPE_STACK_KEY32        = ExtendedDataType(0x0011)
+	EVENT_HEADER_EXT_TYPE_STACK_KEY64        = ExtendedDataType(0x0012)
+)
+
+// ExtendedDataItem is a raw extended data item of the event.
+type ExtendedDataItem struct {
+	Type ExtendedDataType
+	Data []byte
+}
+
+// ExtendedDataCount returns a number of the event extended data items.
+func (e *Event) ExtendedDataCount() int {
+	if e.checkRecord("ExtendedDataCount") != nil || e.eventRecord.EventHeader.Flags&C.EVENT_HEADER_FLAG_EXTENDED_INFO == 0 {
+		return 0
+	}
+	return int(e.eventRecord.ExtendedDataCount)
+}
+
+// RawExtendedData returns a copy of the @i-th extended data item of the
+// event. It allows to decode item types ExtendedInfo doesn't support yet.
+func (e *Event) RawExtendedData(i int) (ExtendedDataItem, error) {
+	if err := e.checkRecord("RawExtendedData"); err != nil {
+		return ExtendedDataItem{}, err
 	}
-	return e.parseExtendedInfo()
+	if i < 0 || i >= e.ExtendedDataCount() {
+		return ExtendedDataItem{}, fmt.Errorf("extended data item %d is out of %d items", i, e.ExtendedDataCount())
+	}
+	dataPtr := unsafe.Pointer(uintptr(C.GetDataPtr(e.eventRecord.ExtendedData, C.int(i))))
+	dataSize := C.GetDataSize(e.eventRecord.ExtendedData, C.int(i))
+	return ExtendedDataItem{
+		Type: ExtendedDataType(C.GetExtType(e.eventRecord.ExtendedData, C.int(i))),
+		Data: C.GoBytes(dataPtr, C.int(dataSize)),
+	}, nil
 }
 
 func (e *Event) parseExtendedInfo() ExtendedEventInfo {
@@ -178,6 +624,7 @@ func (e *Event) parseExtendedInfo() ExtendedEventInfo {
 		case C.EVENT_HEADER_EXT_TYPE_RELATED_ACTIVITYID:
 			cGUID := (C.LPGUID)(dataPtr)
 			goGUID := windowsGUIDToGo(*cGUID)
+			extendedData.RelatedActivityID = &goGUID
 			extendedData.ActivityID = &goGUID
 
 		case C.EVENT_HEADER_EXT_TYPE_SID:
@@ -241,6 +688,8 @@ func (e *Event) parseExtendedInfo() ExtendedEventInfo {
 			// EVENT_HEADER_EXT_TYPE_PSM_KEY, EVENT_HEADER_EXT_TYPE_EVENT_KEY,
 			// EVENT_HEADER_EXT_TYPE_PROCESS_START_KEY, EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL
 			// EVENT_HEADER_EXT_TYPE_PROV_TRAITS
+			//
+			// Meanwhile they are available via RawExtendedData.
 		}
 	}
 	return extendedData
@@ -250,19 +699,43 @@ func (e *Event) parseExtendedInfo() ExtendedEventInfo {
 type propertyParser struct {
 	record  C.PEVENT_RECORD
 	info    C.PTRACE_EVENT_INFO
+	plan    *parsePlan
 	data    uintptr
 	endData uintptr
 	ptrSize uintptr
+
+	// limits are ParseLimits of the parsing, depth is the current structure
+	// nesting level.
+	limits ParseLimits
+	depth  int
+
+	// mapValues makes mapped integers be parsed as MapValue.
+	mapValues bool
+
+	// binary and binaryProperties are formats of binary properties.
+	binary           BinaryFormat
+	binaryProperties map[string]BinaryFormat
+
+	// invariant makes locale-dependent values be formatted by the parser.
+	invariant bool
+
+	// schemaArtifacts makes count artifacts be stored to the output.
+	schemaArtifacts bool
 }
 
-func newPropertyParser(r C.PEVENT_RECORD) (*propertyParser, error) {
-	info, err := getEventInformation(r)
+func newPropertyParser(r C.PEVENT_RECORD, cache *SchemaCache) (*propertyParser, error) {
+	info, plan, err := cache.getEventInformation(r)
 	if err != nil {
 		if info != nil {
 			C.free(unsafe.Pointer(info))
 		}
 		return nil, fmt.Errorf("failed to get event information; %w", err)
 	}
+	if info.TopLevelPropertyCount > info.PropertyCount {
+		C.free(unsafe.Pointer(info))
+		return nil, fmt.Errorf("%w: %d top level properties are out of %d properties",
+			ErrMalformedEvent, info.TopLevelPropertyCount, info.PropertyCount)
+	}
 	ptrSize := unsafe.Sizeof(uint64(0))
 	if r.EventHeader.Flags&C.EVENT_HEADER_FLAG_32_BIT_HEADER == C.EVENT_HEADER_FLAG_32_BIT_HEADER {
 		ptrSize = unsafe.Sizeof(uint32(0))
@@ -270,6 +743,7 @@ func newPropertyParser(r C.PEVENT_RECORD) (*propertyParser, error) {
 	return &propertyParser{
 		record:  r,
 		info:    info,
+		plan:    plan,
 		ptrSize: ptrSize,
 		data:    uintptr(r.UserData),
 		endData: uintptr(r.UserData) + uintptr(r.UserDataLength),
@@ -277,10 +751,11 @@ func newPropertyParser(r C.PEVENT_RECORD) (*propertyParser, error) {
 }
 
 // getEventInformation wraps TdhGetEventInformation. It extracts some kind of
-// simplified event information used by Tdh* family of function.
+// simplified event information used by Tdh* family of function. Returns the
+// info along with its size.
 //
 // Returned info MUST be freed after use.
-func getEventInformation(pEvent C.PEVENT_RECORD) (C.PTRACE_EVENT_INFO, error) {
+func getEventInformation(pEvent C.PEVENT_RECORD) (C.PTRACE_EVENT_INFO, int, error) {
 	var (
 		pInfo      C.PTRACE_EVENT_INFO
 		bufferSize C.ulong
@@ -291,7 +766,7 @@ func getEventInformation(pEvent C.PEVENT_RECORD) (C.PTRACE_EVENT_INFO, error) {
 	if windows.Errno(ret) == windows.ERROR_INSUFFICIENT_BUFFER {
 		pInfo = C.PTRACE_EVENT_INFO(C.malloc(C.size_t(bufferSize)))
 		if pInfo == nil {
-			return nil, fmt.Errorf("malloc(%v) failed", bufferSize)
+			return nil, 0, fmt.Errorf("malloc(%v) failed", bufferSize)
 		}
 
 		// Fetch the buffer itself.
@@ -299,10 +774,10 @@ func getEventInformation(pEvent C.PEVENT_RECORD) (C.PTRACE_EVENT_INFO, error) {
 	}
 
 	if status := windows.Errno(ret); status != windows.ERROR_SUCCESS {
-		return pInfo, fmt.Errorf("TdhGetEventInformation failed; %w", status)
+		return pInfo, 0, fmt.Errorf("TdhGetEventInformation failed; %w", status)
 	}
 
-	return pInfo, nil
+	return pInfo, int(bufferSize), nil
 }
 
 // free frees associated PTRACE_EVENT_INFO if any assigned.
@@ -312,11 +787,22 @@ func (p *propertyParser) free() {
 	}
 }
 
+// isVisible returns true if the @i-th property should be stored to the
+// parsed properties.
+func (p *propertyParser) isVisible(i int) bool {
+	return p.schemaArtifacts || !p.plan.properties[i].countArtifact
+}
+
 // getPropertyName returns a name of the @i-th event property.
 func (p *propertyParser) getPropertyName(i int) string {
-	propertyName := uintptr(C.GetPropertyName(p.info, C.int(i)))
-	length := C.wcslen((C.PWCHAR)(unsafe.Pointer(propertyName)))
-	return createUTF16String(propertyName, int(length))
+	return p.plan.properties[i].name
+}
+
+// propertyName decodes a name of the @i-th property of @info.
+func propertyName(info C.PTRACE_EVENT_INFO, i int) string {
+	name := unsafe.Pointer(uintptr(C.GetPropertyName(info, C.int(i))))
+	length := C.wcslen((C.PWCHAR)(name))
+	return createUTF16String(name, int(length))
 }
 
 // getPropertyValue retrieves a value of @i-th property.
@@ -324,13 +810,30 @@ func (p *propertyParser) getPropertyName(i int) string {
 // N.B. getPropertyValue HIGHLY depends not only on @i but also on memory
 // offsets, so check twice calling with non-sequential indexes.
 func (p *propertyParser) getPropertyValue(i int) (interface{}, error) {
-	var arraySizeC C.uint
-	ret := C.GetArraySize(p.record, p.info, C.int(i), &arraySizeC)
-	if status := windows.Errno(ret); status != windows.ERROR_SUCCESS {
-		return nil, fmt.Errorf("failed to get array size; %w", status)
+	arraySize, err := p.plan.arraySize(p.record, p.info, i)
+	if err != nil {
+		return nil, err
 	}
 
-	arraySize := int(arraySizeC)
+	property := &p.plan.properties[i]
+	isArray := property.isArray
+	if err := checkLimit("MaxArrayLength", arraySize, p.limits.MaxArrayLength); err != nil {
+		return nil, err
+	}
+	if !isArray {
+		if arraySize > 1 {
+			return nil, fmt.Errorf("%w: scalar property has %d values", ErrMalformedEvent, arraySize)
+		}
+		// Schemas of MOF (classic) events report a count of 0 for scalars.
+		arraySize = 1
+	}
+	// Every array element consumes at least a byte of data (except structures
+	// which could be empty), so bigger arrays are definitely malformed.
+	if isArray && arraySize > int(p.endData-p.data) && !property.isStruct {
+		return nil, fmt.Errorf("%w: array of %d elements exceeds remaining %d bytes of data",
+			ErrMalformedEvent, arraySize, p.endData-p.data)
+	}
+	format, isBinary := p.binaryFormat(i)
 	result := make([]interface{}, arraySize)
 	for j := 0; j < arraySize; j++ {
 		var (
@@ -339,9 +842,14 @@ func (p *propertyParser) getPropertyValue(i int) (interface{}, error) {
 		)
 		// Note that we pass same idx to parse function. Actual returned values are controlled
 		// by data pointers offsets.
-		if int(C.PropertyIsStruct(p.info, C.int(i))) == 1 {
+		switch {
+		case property.isStruct:
 			value, err = p.parseStruct(i)
-		} else {
+		case isBinary:
+			value, err = p.parseBinary(i, format)
+		case p.mapValues:
+			value, err = p.parseMapValue(i)
+		default:
 			value, err = p.parseSimpleType(i)
 		}
 		if err != nil {
@@ -350,54 +858,136 @@ func (p *propertyParser) getPropertyValue(i int) (interface{}, error) {
 		result[j] = value
 	}
 
-	if int(C.PropertyIsArray(p.info, C.int(i))) == 1 {
+	if isArray {
 		return result, nil
 	}
 	return result[0], nil
 }
 
+// skipProperty moves data pointer to the end of @i-th property which data
+// starts at @start. Returns false if the property has no fixed size defined
+// by the event schema and can't be skipped.
+func (p *propertyParser) skipProperty(i int, start uintptr) bool {
+	if p.plan.properties[i].isStruct {
+		return false
+	}
+	arraySize, err := p.plan.arraySize(p.record, p.info, i)
+	if err != nil {
+		return false
+	}
+	propertyLength, err := p.plan.propertyLength(p.record, p.info, i)
+	if err != nil {
+		return false
+	}
+	if propertyLength == 0 && p.plan.properties[i].inType == tdhInTypePointer {
+		propertyLength = uint32(p.ptrSize)
+	}
+	if propertyLength == 0 {
+		return false // Variable length property.
+	}
+	size := uintptr(propertyLength) * uintptr(arraySize)
+	if start > p.endData || size > p.endData-start {
+		return false
+	}
+	p.data = start + size
+	return true
+}
+
 // parseStruct tries to extract fields of embedded structure at property @i.
 func (p *propertyParser) parseStruct(i int) (map[string]interface{}, error) {
-	startIndex := int(C.GetStructStartIndex(p.info, C.int(i)))
-	lastIndex := int(C.GetStructLastIndex(p.info, C.int(i)))
+	startIndex := p.plan.properties[i].structStart
+	lastIndex := p.plan.properties[i].structLast
+	if startIndex < 0 || startIndex > lastIndex || lastIndex > int(p.info.PropertyCount) {
+		return nil, fmt.Errorf("%w: structure fields [%d, %d) are out of %d properties",
+			ErrMalformedEvent, startIndex, lastIndex, p.info.PropertyCount)
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+	if err := checkLimit("MaxStructDepth", p.depth, p.limits.MaxStructDepth); err != nil {
+		return nil, err
+	}
 
-	structure := make(map[string]interface{}, lastIndex-startIndex)
+	size := p.plan.properties[i].visibleFields
+	if p.schemaArtifacts {
+		size = lastIndex - startIndex
+	}
+	structure := make(map[string]interface{}, size)
 	for j := startIndex; j < lastIndex; j++ {
 		name := p.getPropertyName(j)
 		value, err := p.getPropertyValue(j)
 		if err != nil {
 			return nil, fmt.Errorf("failed parse field %q of complex property type; %w", name, err)
 		}
-		structure[name] = value
+		if p.isVisible(j) {
+			structure[name] = value
+		}
 	}
 	return structure, nil
 }
 
-// For some weird reasons non of mingw versions has TdhFormatProperty defined
-// so the only possible way is to use a DLL here.
-//
-//nolint:gochecknoglobals
-var (
-	tdh               = windows.NewLazySystemDLL("Tdh.dll")
-	tdhFormatProperty = tdh.NewProc("TdhFormatProperty")
-)
-
 // parseSimpleType wraps TdhFormatProperty to get rendered to string value of
-// @i-th event property.
+// @i-th event property. Failures are counted in PropertyErrorStats.
 func (p *propertyParser) parseSimpleType(i int) (string, error) {
-	mapInfo, err := getMapInfo(p.record, p.info, i)
+	value, err := p.formatSimpleType(i)
+	if err != nil {
+		recordPropertyError(PropertyErrorKey{
+			ProviderID: windowsGUIDToGo(p.record.EventHeader.ProviderId),
+			EventID:    uint16(p.record.EventHeader.EventDescriptor.Id),
+			OpCode:     uint8(p.record.EventHeader.EventDescriptor.Opcode),
+			InType:     uint16(p.plan.properties[i].inType),
+		})
+	}
+	return value, err
+}
+
+// formatSimpleType implements parseSimpleType.
+func (p *propertyParser) formatSimpleType(i int) (string, error) {
+	property := &p.plan.properties[i]
+	mapBytes, err := p.plan.valueMap(p.record, p.info, i)
 	if err != nil {
 		return "", fmt.Errorf("failed to get map info; %w", err)
 	}
+	var mapInfo unsafe.Pointer
+	if len(mapBytes) != 0 {
+		mapInfo = unsafe.Pointer(&mapBytes[0])
+	}
 
-	var propertyLength C.uint
-	ret := C.GetPropertyLength(p.record, p.info, C.int(i), &propertyLength)
-	if status := windows.Errno(ret); status != windows.ERROR_SUCCESS {
-		return "", fmt.Errorf("failed to get property length; %w", status)
+	propertyLength, err := p.plan.propertyLength(p.record, p.info, i)
+	if err != nil {
+		return "", err
+	}
+
+	// Never let TdhFormatProperty read out of the event payload.
+	if p.data > p.endData {
+		return "", fmt.Errorf("%w: data offset is out of the payload", ErrMalformedEvent)
+	}
+	if uintptr(propertyLength) > p.endData-p.data {
+		return "", fmt.Errorf("%w: property length %d exceeds remaining %d bytes of data",
+			ErrMalformedEvent, propertyLength, p.endData-p.data)
+	}
+
+	inType, outType := property.inType, property.outType
+	if isCountedInType(inType) && !tdhSupportsCountedTypes() {
+		return p.parseCounted(inType)
+	}
+	if p.invariant {
+		if value, ok := p.parseInvariant(i); ok {
+			return value, nil
+		}
+	}
+	if mapInfo == nil {
+		if m, ok := p.customValueMap(i); ok {
+			if value, size, ok := p.readInteger(i); ok {
+				p.data += uintptr(size)
+				_, signed := integerSize(inType)
+				return m.format(value, signed), nil
+			}
+		}
 	}
 
-	inType := uintptr(C.GetInType(p.info, C.int(i)))
-	outType := uintptr(C.GetOutType(p.info, C.int(i)))
+	if tdhError != nil {
+		return "", tdhError
+	}
 
 	// We are going to guess a value size to save a DLL call, so preallocate.
 	var (
@@ -408,25 +998,30 @@ func (p *propertyParser) parseSimpleType(i int) (string, error) {
 
 retryLoop:
 	for {
-		r0, _, _ := tdhFormatProperty.Call(
-			uintptr(unsafe.Pointer(p.record)),
-			uintptr(mapInfo),
-			p.ptrSize,
-			inType,
-			outType,
-			uintptr(propertyLength),
-			p.endData-p.data,
-			p.data,
-			uintptr(unsafe.Pointer(&formattedDataSize)),
-			uintptr(unsafe.Pointer(&formattedData[0])),
-			uintptr(unsafe.Pointer(&userDataConsumed)),
-		)
-
-		switch status := windows.Errno(r0); status {
+		status := formatProperty(&formatPropertyArgs{
+			eventInfo:      unsafe.Pointer(p.record),
+			mapInfo:        mapInfo,
+			pointerSize:    p.ptrSize,
+			inType:         inType,
+			outType:        outType,
+			propertyLength: uintptr(propertyLength),
+			userDataLength: p.endData - p.data,
+			userData:       unsafe.Pointer(p.data),
+			bufferSize:     &formattedDataSize,
+			buffer:         unsafe.Pointer(&formattedData[0]),
+			consumed:       &userDataConsumed,
+		})
+
+		switch status {
 		case windows.ERROR_SUCCESS:
 			break retryLoop
 
 		case windows.ERROR_INSUFFICIENT_BUFFER:
+			// Formatted size is in bytes including the terminating null.
+			length := int(formattedDataSize)/2 - 1
+			if err := checkLimit("MaxStringLength", length, p.limits.MaxStringLength); err != nil {
+				return "", err
+			}
 			formattedData = make([]byte, int(formattedDataSize))
 			continue
 
@@ -444,15 +1039,26 @@ retryLoop:
 			return "", fmt.Errorf("TdhFormatProperty failed; %w", status)
 		}
 	}
+	if userDataConsumed < 0 || uintptr(userDataConsumed) > p.endData-p.data {
+		return "", fmt.Errorf("%w: consumed %d bytes exceeds remaining %d bytes of data",
+			ErrMalformedEvent, userDataConsumed, p.endData-p.data)
+	}
 	p.data += uintptr(userDataConsumed)
 
-	return createUTF16String(uintptr(unsafe.Pointer(&formattedData[0])), int(formattedDataSize)), nil
+	// formattedDataSize is in bytes, never read past the Go buffer.
+	value := windows.UTF16ToString(utf16Slice(unsafe.Pointer(&formattedData[0]), int(formattedDataSize)/2))
+	if p.limits.MaxStringLength > 0 {
+		if err := checkLimit("MaxStringLength", utf16Length(value), p.limits.MaxStringLength); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
 }
 
 // getMapInfo retrieve the mapping between the @i-th field and the structure it represents.
-// If that mapping exists, function extracts it and returns a pointer to the buffer with
-// extracted info. If no mapping defined, function can legitimately return `nil, nil`.
-func getMapInfo(event C.PEVENT_RECORD, info C.PTRACE_EVENT_INFO, i int) (unsafe.Pointer, error) {
+// If that mapping exists, function extracts it and returns a buffer with extracted info.
+// If no mapping defined, function can legitimately return `nil, nil`.
+func getMapInfo(event C.PEVENT_RECORD, info C.PTRACE_EVENT_INFO, i int) ([]byte, error) {
 	mapName := C.GetMapName(info, C.int(i))
 
 	// Query map info if any exists.
@@ -481,7 +1087,7 @@ func getMapInfo(event C.PEVENT_RECORD, info C.PTRACE_EVENT_INFO, i int) (unsafe.
 	if len(mapInfo) == 0 {
 		return nil, nil
 	}
-	return unsafe.Pointer(&mapInfo[0]), nil
+	return mapInfo, nil
 }
 
 func windowsGUIDToGo(guid C.GUID) windows.GUID {
@@ -506,18 +1112,11 @@ func stampToTime(quadPart C.LONGLONG) time.Time {
 	return time.Unix(0, ft.Nanoseconds())
 }
 
-// Creates UTF16 string from raw parts.
-//
-// Actually in go we have no way to make a slice from raw parts, ref:
-// - https://github.com/golang/go/issues/13656
-// - https://github.com/golang/go/issues/19367
-// So the recommended way is "a fake cast" to the array with maximal len
-// with a following slicing.
-// Ref: https://github.com/golang/go/wiki/cgo#turning-c-arrays-into-go-slices
-func createUTF16String(ptr uintptr, len int) string {
+// createUTF16String creates a string from @len UTF16 characters located at
+// @ptr. The string ends at the first null character if any.
+func createUTF16String(ptr unsafe.Pointer, len int) string {
 	if len == 0 {
 		return ""
 	}
-	bytes := (*[1 << 29]uint16)(unsafe.Pointer(ptr))[:len:len]
-	return windows.UTF16ToString(bytes)
+	return windows.UTF16ToString(utf16Slice(ptr, len))
 }
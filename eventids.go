@@ -0,0 +1,49 @@
+//+build windows
+
+package etw
+
+/*
+	#include <stdlib.h>
+	#include "session.h"
+*/
+import "C"
+import (
+	"encoding/binary"
+	"unsafe"
+)
+
+// eventFilterTypeEventID is EVENT_FILTER_TYPE_EVENT_ID of
+// EVENT_FILTER_DESCRIPTOR.
+const eventFilterTypeEventID = 0x80000200
+
+// maxEventIDFilterCount is MAX_EVENT_FILTER_EVENT_ID_COUNT.
+const maxEventIDFilterCount = 64
+
+// newEventIDFilter allocates EVENT_FILTER_DESCRIPTOR passing only events
+// with @ids. The descriptor and the EVENT_FILTER_EVENT_ID it points to are
+// allocated at once and should be freed with C.free.
+func newEventIDFilter(ids []uint16) C.PEVENT_FILTER_DESCRIPTOR {
+	// typedef struct _EVENT_FILTER_EVENT_ID {
+	//	BOOLEAN FilterIn;
+	//	UCHAR   Reserved;
+	//	USHORT  Count;
+	//	USHORT  Events[ANYSIZE_ARRAY];
+	// } EVENT_FILTER_EVENT_ID;
+	filter := make([]byte, 4+2*len(ids))
+	filter[0] = 1 // Pass the events listed.
+	binary.LittleEndian.PutUint16(filter[2:], uint16(len(ids)))
+	for i, id := range ids {
+		binary.LittleEndian.PutUint16(filter[4+2*i:], id)
+	}
+
+	descSize := unsafe.Sizeof(C.EVENT_FILTER_DESCRIPTOR{})
+	buf := C.malloc(C.size_t(descSize + uintptr(len(filter))))
+	data := unsafe.Pointer(uintptr(buf) + descSize)
+	copy((*[1 << 16]byte)(data)[:len(filter):len(filter)], filter)
+
+	desc := (C.PEVENT_FILTER_DESCRIPTOR)(buf)
+	desc.Ptr = C.ULONGLONG(uintptr(data))
+	desc.Size = C.ULONG(len(filter))
+	desc.Type = eventFilterTypeEventID
+	return desc
+}
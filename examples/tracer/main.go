@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"io/ioutil"
@@ -10,9 +11,6 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
-	"sync"
-
-	"golang.org/x/sys/windows"
 
 	"github.com/bi-zone/etw"
 )
@@ -26,15 +24,15 @@ func main() {
 	flag.Parse()
 
 	if flag.NArg() != 1 {
-		log.Fatalf("Usage: %s [opts] <providerGUID>", filepath.Base(os.Args[0]))
+		log.Fatalf("Usage: %s [opts] <providerGUID|providerName>", filepath.Base(os.Args[0]))
 	}
 	if *optSilent {
 		log.SetOutput(ioutil.Discard)
 	}
 
-	guid, err := windows.GUIDFromString(flag.Arg(0))
+	guid, err := etw.ResolveProvider(flag.Arg(0))
 	if err != nil {
-		log.Fatalf("Incorrect GUID given; %s", err)
+		log.Fatalf("Incorrect provider given; %s", err)
 	}
 	session, err := etw.NewSession(guid)
 	if err != nil {
@@ -61,37 +59,22 @@ func main() {
 		_ = enc.Encode(event)
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		log.Printf("[DBG] Starting to listen ETW events from %s", guid)
-
-		// Block until .Close().
-		if err := session.Process(cb); err != nil {
-			log.Printf("[ERR] Got error processing events: %s", err)
-		} else {
-			log.Printf("[DBG] Successfully shut down")
-		}
-
-		wg.Done()
-	}()
-
 	// Trap cancellation (the only signal values guaranteed to be present in
 	// the os package on all systems are os.Interrupt and os.Kill).
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt)
-
-	// Wait for stop and shutdown gracefully.
-	for range sigCh {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt)
+		<-sigCh
 		log.Printf("[DBG] Shutting the session down")
+		cancel()
+	}()
 
-		err = session.Close()
-		if err != nil {
-			log.Printf("[ERR] (!!!) Failed to stop session: %s\n", err)
-		} else {
-			break
-		}
+	// Block until cancellation and shutdown gracefully.
+	log.Printf("[DBG] Starting to listen ETW events from %s", guid)
+	if err := session.Run(ctx, cb); err != nil {
+		log.Printf("[ERR] Got error processing events: %s", err)
+	} else {
+		log.Printf("[DBG] Successfully shut down")
 	}
-
-	wg.Wait()
 }
@@ -0,0 +1,93 @@
+//+build windows
+
+package expr
+
+import (
+	"github.com/bi-zone/etw"
+)
+
+// EventEnv exposes an etw.Event to expressions as `Header.<field>` and
+// `Properties.<name>`. Properties are parsed lazily on the first access, so
+// expressions checking only the header are cheap. Like etw.Event it's valid
+// only inside etw.EventCallback.
+type EventEnv struct {
+	Event *etw.Event
+
+	// Options are passed to etw.Event.EventProperties.
+	Options []etw.ParseOption
+
+	props    MapEnv
+	propsErr error
+}
+
+// Lookup implements Env.
+func (e *EventEnv) Lookup(path []string) (interface{}, bool) {
+	if len(path) < 2 {
+		return nil, false
+	}
+	switch path[0] {
+	case "Header":
+		if len(path) != 2 {
+			return nil, false
+		}
+		return e.header(path[1])
+
+	case "Properties":
+		if e.props == nil && e.propsErr == nil {
+			e.props, e.propsErr = e.Event.EventProperties(e.Options...)
+		}
+		if e.propsErr != nil {
+			return nil, false
+		}
+		return e.props.Lookup(path[1:])
+	}
+	return nil, false
+}
+
+// Err returns an error occurred while parsing event properties, if any.
+func (e *EventEnv) Err() error {
+	return e.propsErr
+}
+
+func (e *EventEnv) header(name string) (interface{}, bool) {
+	h := e.Event.Header
+	switch name {
+	case "ID":
+		return h.ID, true
+	case "Version":
+		return h.Version, true
+	case "Channel":
+		return h.Channel, true
+	case "Level":
+		return h.Level, true
+	case "OpCode":
+		return h.OpCode, true
+	case "Task":
+		return h.Task, true
+	case "Keyword":
+		return h.Keyword, true
+	case "ProcessID":
+		return h.ProcessID, true
+	case "ThreadID":
+		return h.ThreadID, true
+	case "ProviderID":
+		return h.ProviderID.String(), true
+	case "ActivityID":
+		return h.ActivityID.String(), true
+	case "RelatedActivityID":
+		if related, ok := e.Event.RelatedActivityID(); ok {
+			return related.String(), true
+		}
+		return nil, false
+	case "UserDataLength":
+		return h.UserDataLength, true
+	}
+	return nil, false
+}
+
+// Match evaluates the program against @e. Evaluation errors are treated as
+// a mismatch.
+func (p *Program) Match(e *etw.Event, options ...etw.ParseOption) bool {
+	ok, err := p.Eval(&EventEnv{Event: e, Options: options})
+	return err == nil && ok
+}
@@ -0,0 +1,627 @@
+// Package expr implements a small expression language to filter events with
+// string predicates coming from configuration files, e.g.:
+//
+//	Header.ID == 11 && Properties.ImageName.endsWith("cmd.exe")
+//
+// Expressions are compiled once and evaluated against an Env that resolves
+// dotted identifiers to values. Supported syntax:
+//   - literals: integers, floats, double-quoted strings, true, false;
+//   - identifiers: dotted paths like `Properties.struct.field`;
+//   - comparisons: ==, !=, <, <=, >, >=;
+//   - logical operators: &&, ||, ! and parentheses;
+//   - string methods: contains, startsWith, endsWith, matches (regexp).
+//
+// Event properties are rendered by TDH to strings, so strings are compared
+// with numbers numerically if they could be parsed as numbers.
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Env resolves identifiers used in expressions.
+type Env interface {
+	// Lookup returns a value addressed by the dotted @path or false if the
+	// value doesn't exist.
+	Lookup(path []string) (interface{}, bool)
+}
+
+// MapEnv is an Env backed by nested maps.
+type MapEnv map[string]interface{}
+
+// Lookup implements Env.
+func (m MapEnv) Lookup(path []string) (interface{}, bool) {
+	var cur interface{} = map[string]interface{}(m)
+	for _, p := range path {
+		container, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = container[p]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// Program is a compiled expression. Program is immutable and safe for
+// concurrent use.
+type Program struct {
+	src  string
+	root node
+}
+
+// Compile parses @src into a Program.
+func Compile(src string) (*Program, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.pos)
+	}
+	return &Program{src: src, root: root}, nil
+}
+
+// MustCompile is like Compile but panics on error. Useful for expressions
+// defined in code.
+func MustCompile(src string) *Program {
+	p, err := Compile(src)
+	if err != nil {
+		panic(fmt.Sprintf("expr: Compile(%q): %s", src, err))
+	}
+	return p
+}
+
+// String returns the source of the expression.
+func (p *Program) String() string {
+	return p.src
+}
+
+// Eval evaluates the expression against @env. Expressions that don't
+// evaluate to a boolean result in an error. Missing identifiers are
+// evaluated to nil, which is not equal to any value.
+func (p *Program) Eval(env Env) (bool, error) {
+	v, err := p.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression result is %T, not bool", v)
+	}
+	return b, nil
+}
+
+//
+// Lexer.
+//
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, src[start:i], start})
+
+		case isDigit(c):
+			start := i
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.' || src[i] == 'x' || isHex(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, src[start:i], start})
+
+		case c == '"':
+			start := i
+			i++
+			for i < len(src) && src[i] != '"' {
+				if src[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			s, err := strconv.Unquote(src[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d; %w", start, err)
+			}
+			tokens = append(tokens, token{tokString, s, start})
+
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", ".", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(src)}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+//
+// Parser.
+//
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return fmt.Errorf("expected %q at %d, got %q", op, tok.pos, tok.text)
+	}
+	return nil
+}
+
+// or := and ("||" and)*
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+// and := comparison ("&&" comparison)*
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+// comparison := unary (cmpOp unary)?
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return compareNode{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+// unary := "!" unary | primary
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+// primary := literal | "(" or ")" | ident ("." ident)* ("(" args ")")?
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		v, err := parseNumber(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", tok.text, tok.pos)
+		}
+		return literalNode{value: v}, nil
+
+	case tokString:
+		return literalNode{value: tok.text}, nil
+
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return literalNode{value: true}, nil
+		case "false":
+			return literalNode{value: false}, nil
+		}
+		path := []string{tok.text}
+		for p.accept(".") {
+			part := p.next()
+			if part.kind != tokIdent {
+				return nil, fmt.Errorf("expected identifier at %d, got %q", part.pos, part.text)
+			}
+			path = append(path, part.text)
+		}
+		if !p.accept("(") {
+			return identNode{path: path}, nil
+		}
+		// The last path element is a method of the value addressed by the rest.
+		if len(path) < 2 {
+			return nil, fmt.Errorf("function %q at %d is not a method", tok.text, tok.pos)
+		}
+		return p.parseMethod(identNode{path: path[:len(path)-1]}, path[len(path)-1], tok.pos)
+
+	case tokOp:
+		if tok.text == "(" {
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.pos)
+}
+
+func (p *parser) parseMethod(receiver node, name string, pos int) (node, error) {
+	arg, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	n := methodNode{name: name, receiver: receiver, arg: arg}
+	switch name {
+	case "contains", "startsWith", "endsWith":
+	case "matches":
+		// Compile constant patterns once.
+		if lit, ok := arg.(literalNode); ok {
+			pattern, ok := lit.value.(string)
+			if !ok {
+				return nil, fmt.Errorf("matches at %d expects a string pattern", pos)
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern at %d; %w", pos, err)
+			}
+			n.re = re
+		}
+	default:
+		return nil, fmt.Errorf("unknown method %q at %d", name, pos)
+	}
+	return n, nil
+}
+
+func parseNumber(s string) (interface{}, error) {
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return i, nil
+	}
+	if u, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return u, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+//
+// Evaluation.
+//
+
+type node interface {
+	eval(env Env) (interface{}, error)
+}
+
+type literalNode struct{ value interface{} }
+
+func (n literalNode) eval(Env) (interface{}, error) { return n.value, nil }
+
+type identNode struct{ path []string }
+
+func (n identNode) eval(env Env) (interface{}, error) {
+	v, _ := env.Lookup(n.path)
+	return v, nil
+}
+
+type notNode struct{ operand node }
+
+func (n notNode) eval(env Env) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("operand of ! is %T, not bool", v)
+	}
+	return !b, nil
+}
+
+type logicalNode struct {
+	op          string
+	left, right node
+}
+
+func (n logicalNode) eval(env Env) (interface{}, error) {
+	l, err := evalBool(n.left, env)
+	if err != nil {
+		return nil, err
+	}
+	// Short-circuit evaluation.
+	if (n.op == "&&" && !l) || (n.op == "||" && l) {
+		return l, nil
+	}
+	return evalBool(n.right, env)
+}
+
+func evalBool(n node, env Env) (bool, error) {
+	v, err := n.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("logical operand is %T, not bool", v)
+	}
+	return b, nil
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n compareNode) eval(env Env) (interface{}, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil || r == nil {
+		// Missing values are not equal to anything.
+		return n.op == "!=", nil
+	}
+
+	// Integers are compared exactly: 64-bit values like keywords don't fit
+	// float64.
+	if li, ok := toInteger(l); ok {
+		if ri, ok := toInteger(r); ok {
+			return compareOrdered(n.op, li.cmp(ri)), nil
+		}
+	}
+	if lf, ok := toFloat(l); ok {
+		if rf, ok := toFloat(r); ok {
+			return compareOrdered(n.op, cmpFloat(lf, rf)), nil
+		}
+	}
+	if lb, ok := l.(bool); ok {
+		rb, ok := r.(bool)
+		if !ok || (n.op != "==" && n.op != "!=") {
+			return nil, fmt.Errorf("can't compare bool with %T using %s", r, n.op)
+		}
+		return (lb == rb) == (n.op == "=="), nil
+	}
+	return compareOrdered(n.op, strings.Compare(fmt.Sprint(l), fmt.Sprint(r))), nil
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareOrdered(op string, cmp int) bool {
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default: // ">="
+		return cmp >= 0
+	}
+}
+
+// toFloat converts numbers and numeric strings to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	case uint8:
+		return float64(x), true
+	case uint16:
+		return float64(x), true
+	case uint32:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case float64:
+		return x, true
+	case string:
+		if n, err := parseNumber(x); err == nil {
+			return toFloat(n)
+		}
+	}
+	return 0, false
+}
+
+// integer holds any int64 or uint64 value as a sign and an absolute value.
+type integer struct {
+	neg bool
+	abs uint64
+}
+
+// cmp returns -1, 0 or 1 if @a is less, equal or greater than @b.
+func (a integer) cmp(b integer) int {
+	switch {
+	case a.neg != b.neg:
+		if a.neg {
+			return -1
+		}
+		return 1
+	case a.abs == b.abs:
+		return 0
+	case (a.abs < b.abs) != a.neg:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// toInteger converts integers and integer strings to integer.
+func toInteger(v interface{}) (integer, bool) {
+	switch x := v.(type) {
+	case int64:
+		if x < 0 {
+			return integer{neg: true, abs: uint64(-x)}, true
+		}
+		return integer{abs: uint64(x)}, true
+	case int:
+		return toInteger(int64(x))
+	case uint8:
+		return integer{abs: uint64(x)}, true
+	case uint16:
+		return integer{abs: uint64(x)}, true
+	case uint32:
+		return integer{abs: uint64(x)}, true
+	case uint64:
+		return integer{abs: x}, true
+	case string:
+		if n, err := parseNumber(x); err == nil {
+			return toInteger(n)
+		}
+	}
+	return integer{}, false
+}
+
+type methodNode struct {
+	name     string
+	receiver node
+	arg      node
+	re       *regexp.Regexp
+}
+
+func (n methodNode) eval(env Env) (interface{}, error) {
+	recv, err := n.receiver.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	arg, err := n.arg.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if recv == nil {
+		return false, nil // Missing values match nothing.
+	}
+	s, a := fmt.Sprint(recv), fmt.Sprint(arg)
+
+	switch n.name {
+	case "contains":
+		return strings.Contains(s, a), nil
+	case "startsWith":
+		return strings.HasPrefix(s, a), nil
+	case "endsWith":
+		return strings.HasSuffix(s, a), nil
+	default: // "matches"
+		re := n.re
+		if re == nil {
+			if re, err = regexp.Compile(a); err != nil {
+				return nil, fmt.Errorf("invalid pattern; %w", err)
+			}
+		}
+		return re.MatchString(s), nil
+	}
+}
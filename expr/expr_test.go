@@ -0,0 +1,102 @@
+package expr_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/expr"
+)
+
+func TestEval(t *testing.T) {
+	env := expr.MapEnv{
+		"Header": map[string]interface{}{
+			"ID":      uint16(11),
+			"Level":   uint8(4),
+			"Keyword": uint64(0x8000000000000001),
+		},
+		"Properties": map[string]interface{}{
+			"ImageName": `C:\Windows\System32\cmd.exe`,
+			"ProcessID": "1234",
+			"Keyword":   "0x8000000000000001",
+			"Offset":    "-1",
+			"Nested":    map[string]interface{}{"Field": "value"},
+		},
+	}
+
+	tests := []struct {
+		src      string
+		expected bool
+	}{
+		{`Header.ID == 11 && Properties.ImageName.endsWith("cmd.exe")`, true},
+		{`Header.ID == 12 || Properties.ImageName.startsWith("C:\\Windows")`, true},
+		{`Header.ID != 11`, false},
+		{`Header.Level <= 4 && Header.Level > 2`, true},
+		{`Properties.ProcessID == 1234`, true},
+		{`Properties.ProcessID >= 0x500`, false},
+		{`Header.Keyword == 0x8000000000000001`, true},
+		{`Header.Keyword == 0x8000000000000000`, false},
+		{`Header.Keyword > 0x8000000000000000`, true},
+		{`Properties.Keyword == 0x8000000000000001`, true},
+		{`Properties.Keyword != 0x8000000000000000`, true},
+		{`Properties.Offset < Header.Keyword`, true},
+		{`Properties.Offset < 0`, true},
+		{`Properties.ProcessID < 1234.5`, true},
+		{`Properties.Nested.Field == "value"`, true},
+		{`Properties.ImageName.contains("System32")`, true},
+		{`Properties.ImageName.matches("(?i)CMD\\.EXE$")`, true},
+		{`!(Header.ID == 11)`, false},
+		{`Properties.Missing == "x"`, false},
+		{`Properties.Missing != "x"`, true},
+		{`Properties.Missing.endsWith("x")`, false},
+		{`true && !false`, true},
+	}
+	for _, tt := range tests {
+		p, err := expr.Compile(tt.src)
+		require.NoError(t, err, "Failed to compile %q", tt.src)
+		actual, err := p.Eval(env)
+		require.NoError(t, err, "Failed to evaluate %q", tt.src)
+		assert.Equal(t, tt.expected, actual, "Unexpected result of %q", tt.src)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`Header.ID ==`,
+		`(Header.ID == 1`,
+		`Header.ID == 1)`,
+		`"unterminated`,
+		`Header.ID # 1`,
+		`Properties.Name.unknown("x")`,
+		`Properties.Name.matches("[")`,
+		`endsWith("x")`,
+	} {
+		_, err := expr.Compile(src)
+		assert.Error(t, err, "Expected an error compiling %q", src)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	env := expr.MapEnv{"ID": int64(1)}
+	for _, src := range []string{
+		`ID`,
+		`ID && true`,
+		`!ID`,
+		`true < false`,
+	} {
+		p, err := expr.Compile(src)
+		require.NoError(t, err, "Failed to compile %q", src)
+		_, err = p.Eval(env)
+		assert.Error(t, err, "Expected an error evaluating %q", src)
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	// The right side would fail as non-boolean if evaluated.
+	p := expr.MustCompile(`false && ID`)
+	actual, err := p.Eval(expr.MapEnv{"ID": int64(1)})
+	require.NoError(t, err)
+	assert.False(t, actual)
+}
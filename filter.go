@@ -0,0 +1,48 @@
+//+build windows
+
+package etw
+
+// eventFilter is a Go-side filter applied to event headers before passing
+// events to the user callback. Used for the properties ETW can't filter by
+// itself.
+type eventFilter struct {
+	channels *[256]bool
+	opcodes  *[256]bool
+}
+
+// newEventFilter builds eventFilter from @cfg. Empty sets are represented
+// by nil tables matching any value.
+func newEventFilter(cfg SessionOptions) eventFilter {
+	return eventFilter{
+		channels: newByteSet(cfg.Channels),
+		opcodes:  newByteSet(cfg.Opcodes),
+	}
+}
+
+func newByteSet(values []uint8) *[256]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	var set [256]bool
+	for _, v := range values {
+		set[v] = true
+	}
+	return &set
+}
+
+// match returns true if the event described by @h should be passed to the
+// user callback.
+func (f eventFilter) match(h *EventHeader) bool {
+	// Filters are meant for the events stacks are attached to, StackWalk
+	// events themselves are consumed by the stack walk correlator.
+	if isStackWalk(h) {
+		return true
+	}
+	if f.channels != nil && !f.channels[h.Channel] {
+		return false
+	}
+	if f.opcodes != nil && !f.opcodes[h.OpCode] {
+		return false
+	}
+	return true
+}
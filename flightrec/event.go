@@ -0,0 +1,17 @@
+//+build windows
+
+package flightrec
+
+import (
+	"github.com/bi-zone/etw"
+	"github.com/bi-zone/etw/sinks/jsonl"
+)
+
+// EventCallback returns an etw.EventCallback that records every received
+// event as a jsonl.Record parsed with @options. The window is measured by
+// event timestamps, so it works for both real-time sessions and ETL files.
+func (r *Recorder) EventCallback(options ...etw.ParseOption) etw.EventCallback {
+	return func(e *etw.Event) {
+		r.Add(e.Header.TimeStamp, jsonl.NewRecord(e, options...))
+	}
+}
@@ -0,0 +1,191 @@
+// Package flightrec implements a flight recorder: it keeps the most recent
+// events in memory and dumps them to a sink on demand, e.g. when the
+// application detects an incident:
+//
+//		w, err := jsonl.New("incidents.jsonl")
+//		...
+//		rec := flightrec.New(w, flightrec.WithWindow(30*time.Second))
+//		go session.Process(rec.EventCallback())
+//		...
+//		n, err := rec.Trigger("service crashed")
+//
+// The core of the package is platform independent, the EventCallback adapter
+// is built on top of it.
+package flightrec
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultMaxEntries is a default MaxEntries limit.
+const DefaultMaxEntries = 10000
+
+// Sink receives dumped records. Both jsonl.Writer and forward.Forwarder
+// satisfy it.
+type Sink interface {
+	Write(v interface{}) error
+}
+
+// Record is a single dumped value along with the trigger it's dumped by.
+type Record struct {
+	// Reason and Triggered are the Trigger reason and time.
+	Reason    string
+	Triggered time.Time
+
+	// Recorded is the time the value was added with.
+	Recorded time.Time
+	Value    interface{}
+}
+
+// Options describes how much the Recorder keeps.
+type Options struct {
+	// Window is a maximum age of kept values relative to the newest one.
+	// Older ones are dropped. Zero means no age limit.
+	Window time.Duration
+
+	// MaxEntries is a maximum number of kept values. Having the limit reached
+	// the oldest values are dropped. It bounds memory usage on event bursts,
+	// the ring buffer of MaxEntries is allocated up front.
+	MaxEntries int
+
+	// KeepOnTrigger makes Trigger leave dumped values in the Recorder, so they
+	// are dumped again by the next Trigger if they are still in the window.
+	KeepOnTrigger bool
+}
+
+// Option is any function that modifies Options.
+type Option func(cfg *Options)
+
+// WithWindow makes the Recorder keep values for @d.
+func WithWindow(d time.Duration) Option {
+	return func(cfg *Options) {
+		cfg.Window = d
+	}
+}
+
+// WithMaxEntries makes the Recorder keep at most @n values.
+func WithMaxEntries(n int) Option {
+	return func(cfg *Options) {
+		cfg.MaxEntries = n
+	}
+}
+
+// WithKeepOnTrigger makes Trigger leave dumped values in the Recorder.
+func WithKeepOnTrigger() Option {
+	return func(cfg *Options) {
+		cfg.KeepOnTrigger = true
+	}
+}
+
+// entry is a kept value with its record time.
+type entry struct {
+	recorded time.Time
+	value    interface{}
+}
+
+// Recorder keeps recently added values in a ring buffer and dumps them to
+// the sink on Trigger.
+//
+// Recorder is safe for concurrent use.
+type Recorder struct {
+	sink Sink
+	cfg  Options
+
+	mu      sync.Mutex
+	entries []entry // Ring buffer of cfg.MaxEntries capacity.
+	head    int     // Index of the oldest entry.
+	size    int
+}
+
+// New creates a Recorder dumping values to @sink.
+func New(sink Sink, options ...Option) *Recorder {
+	cfg := Options{MaxEntries: DefaultMaxEntries}
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = DefaultMaxEntries
+	}
+	return &Recorder{
+		sink:    sink,
+		cfg:     cfg,
+		entries: make([]entry, cfg.MaxEntries),
+	}
+}
+
+// Add records @v that happened at @t, e.g. an event with its timestamp.
+// Values older than the window relative to @t are dropped, as well as the
+// oldest value if the Recorder is full.
+//
+// Values are expected to be added in the time order, a value older than the
+// newest one is kept until the newest one expires.
+func (r *Recorder) Add(t time.Time, v interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire(t)
+	if r.size == len(r.entries) {
+		r.pop()
+	}
+	r.entries[(r.head+r.size)%len(r.entries)] = entry{recorded: t, value: v}
+	r.size++
+}
+
+// Len returns a number of values kept by the Recorder.
+func (r *Recorder) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.size
+}
+
+// Trigger writes all kept values to the sink as Records with the given
+// @reason, oldest first. Returns a number of written records. Dumped values
+// are removed from the Recorder unless WithKeepOnTrigger is set.
+//
+// Values added while Trigger is running wait for it, so a slow sink delays
+// the caller of Add (e.g. EventCallback).
+func (r *Recorder) Trigger(reason string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for i := 0; i < r.size; i++ {
+		e := r.entries[(r.head+i)%len(r.entries)]
+		err := r.sink.Write(Record{
+			Reason:    reason,
+			Triggered: now,
+			Recorded:  e.recorded,
+			Value:     e.value,
+		})
+		if err != nil {
+			return i, fmt.Errorf("failed to write record %d of %d; %w", i, r.size, err)
+		}
+	}
+	n := r.size
+	if !r.cfg.KeepOnTrigger {
+		for r.size > 0 {
+			r.pop()
+		}
+	}
+	return n, nil
+}
+
+// expire drops values older than the window ending at @newest.
+func (r *Recorder) expire(newest time.Time) {
+	if r.cfg.Window <= 0 {
+		return
+	}
+	threshold := newest.Add(-r.cfg.Window)
+	for r.size > 0 && r.entries[r.head].recorded.Before(threshold) {
+		r.pop()
+	}
+}
+
+// pop drops the oldest value.
+func (r *Recorder) pop() {
+	r.entries[r.head] = entry{} // Let the value be collected.
+	r.head = (r.head + 1) % len(r.entries)
+	r.size--
+}
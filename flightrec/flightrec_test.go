@@ -0,0 +1,78 @@
+package flightrec_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/flightrec"
+)
+
+// sink collects written records.
+type sink struct {
+	records []flightrec.Record
+	err     error
+}
+
+func (s *sink) Write(v interface{}) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, v.(flightrec.Record))
+	return nil
+}
+
+// values returns values of the collected records.
+func (s *sink) values() []interface{} {
+	var values []interface{}
+	for _, r := range s.records {
+		values = append(values, r.Value)
+	}
+	return values
+}
+
+func TestWindow(t *testing.T) {
+	out := &sink{}
+	rec := flightrec.New(out, flightrec.WithWindow(10*time.Second))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		rec.Add(start.Add(time.Duration(i)*time.Second), i)
+	}
+	assert.Equal(t, 11, rec.Len(), "Values out of the window are kept")
+
+	n, err := rec.Trigger("incident")
+	require.NoError(t, err, "Failed to trigger dump")
+	assert.Equal(t, 11, n)
+	assert.Equal(t, []interface{}{9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19}, out.values())
+	assert.Equal(t, "incident", out.records[0].Reason)
+	assert.Equal(t, start.Add(9*time.Second), out.records[0].Recorded)
+	assert.Zero(t, rec.Len(), "Dumped values are kept")
+}
+
+func TestMaxEntries(t *testing.T) {
+	out := &sink{}
+	rec := flightrec.New(out, flightrec.WithMaxEntries(3), flightrec.WithKeepOnTrigger())
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		rec.Add(now, i)
+	}
+
+	_, err := rec.Trigger("first")
+	require.NoError(t, err, "Failed to trigger dump")
+	_, err = rec.Trigger("second")
+	require.NoError(t, err, "Failed to trigger dump")
+	assert.Equal(t, []interface{}{2, 3, 4, 2, 3, 4}, out.values(), "Unexpected dumped values")
+}
+
+func TestSinkError(t *testing.T) {
+	out := &sink{err: errors.New("disk is full")}
+	rec := flightrec.New(out)
+	rec.Add(time.Now(), "value")
+
+	n, err := rec.Trigger("incident")
+	assert.Zero(t, n)
+	assert.True(t, errors.Is(err, out.err), "Unexpected error %v", err)
+}
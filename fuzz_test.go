@@ -0,0 +1,40 @@
+// +build windows,go1.18
+
+package etw
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Native fuzzing needs Go 1.18, the module supports older versions, so the
+// targets are built only by newer toolchains.
+
+// FuzzParse passes fuzzed data as a payload of the event with a fixed schema
+// and ensures the parser never reads outside of it.
+func FuzzParse(f *testing.F) {
+	f.Add([]byte("a\x00b\x00\x00\x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = fuzzParse(fuzzSchema, data)
+	})
+}
+
+// FuzzSchema fuzzes both the event metadata (and so the TRACE_EVENT_INFO
+// built by TDH from it) and the event payload. Data starts with a 16-bit
+// metadata length followed by the metadata and the payload.
+func FuzzSchema(f *testing.F) {
+	seed := make([]byte, 2, 2+len(fuzzSchema))
+	binary.LittleEndian.PutUint16(seed, uint16(len(fuzzSchema)))
+	f.Add(append(seed, fuzzSchema...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) < 2 {
+			return
+		}
+		schemaLen := int(binary.LittleEndian.Uint16(data))
+		data = data[2:]
+		if schemaLen > len(data) {
+			return
+		}
+		_, _ = fuzzParse(data[:schemaLen], data[schemaLen:])
+	})
+}
@@ -6,4 +6,5 @@ require (
 	github.com/Microsoft/go-winio v0.4.14
 	github.com/stretchr/testify v1.2.2
 	golang.org/x/sys v0.0.0-20200302150141-5c8b2ff67527
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,90 @@
+//+build windows
+
+package etw
+
+/*
+	#include "session.h"
+*/
+import "C"
+import (
+	"fmt"
+)
+
+// maxGroupSize is a maximum number of traces ProcessTrace could handle at
+// once (MAXIMUM_WAIT_OBJECTS).
+const maxGroupSize = 64
+
+// TraceGroup processes events from several sessions as a single stream.
+// Events from all the sessions are delivered in the global timestamp order
+// which makes TraceGroup suitable to build a timeline across different
+// providers.
+//
+// Sessions may use different clock sources, but event timestamps are always
+// converted by ETW to the system time before delivery, so they are
+// comparable.
+type TraceGroup struct {
+	sessions []*Session
+}
+
+// GroupCallback is the same as EventCallback but also receives a name of
+// the @source session the event belongs to.
+type GroupCallback func(source string, e *Event)
+
+// NewTraceGroup creates a TraceGroup of the given @sessions. Up to 64
+// sessions could be grouped. Sessions are still owned by the caller and
+// should be closed separately.
+func NewTraceGroup(sessions ...*Session) (*TraceGroup, error) {
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("no sessions given")
+	}
+	if len(sessions) > maxGroupSize {
+		return nil, fmt.Errorf("too many sessions: %d > %d", len(sessions), maxGroupSize)
+	}
+	return &TraceGroup{sessions: sessions}, nil
+}
+
+// Process starts processing of events from all the group sessions. Events
+// will be passed to @cb synchronously and sequentially, so @cb is never
+// called concurrently.
+//
+// N.B. Process blocks until `.Close` being called on all the sessions!
+func (g *TraceGroup) Process(cb GroupCallback) error {
+	var (
+		handles = make([]C.TRACEHANDLE, 0, len(g.sessions))
+		cgoKeys = make([]uintptr, 0, len(g.sessions))
+	)
+	defer func() {
+		// Handles are opened in the sessions order. Ones already closed by
+		// the session `.Close` are skipped by closeTrace.
+		for i, handle := range handles {
+			g.sessions[i].closeTrace(handle)
+		}
+		for _, key := range cgoKeys {
+			freeCallbackKey(key)
+		}
+	}()
+
+	for _, s := range g.sessions {
+		source := s.Options().Name
+		if err := s.subscribeToProviders(); err != nil {
+			return fmt.Errorf("session %q: %w", source, err)
+		}
+
+		cgoKey := newCallbackKey(s.consumer(s.watched(func(e *Event) {
+			cb(source, e)
+		})))
+		cgoKeys = append(cgoKeys, cgoKey)
+
+		handle, err := s.openTrace(cgoKey)
+		if err != nil {
+			return fmt.Errorf("session %q: %w", source, err)
+		}
+		handles = append(handles, handle)
+	}
+
+	// Will block here until all the sessions being closed.
+	if err := processTraces(handles); err != nil {
+		return fmt.Errorf("error processing events; %w", err)
+	}
+	return nil
+}
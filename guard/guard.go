@@ -0,0 +1,320 @@
+// Package guard keeps the collector within a CPU and memory budget.
+//
+// Guard periodically measures the process resource usage with a Meter and
+// once the Budget is exceeded applies restriction Steps one by one: raises
+// level thresholds, increases sampling, pauses noisy providers, etc. Having
+// the usage dropped back it reverts the steps in reverse order:
+//
+//		sampler := guard.NewSampler()
+//		sampling, err := guard.IncreaseSampling(sampler, 10)
+//		...
+//		g, err := guard.New(guard.ProcessMeter(), guard.Budget{CPU: 0.05, RSS: 200 << 20}, []guard.Step{
+//			sampling,
+//			guard.RaiseLevel(session, providerGUID, etw.TRACE_LEVEL_WARNING),
+//			guard.PauseNoisy(session, 1),
+//		})
+//		...
+//		go g.Run(ctx)
+//		err = session.Process(sampler.EventCallback(cb))
+//
+// The core of the package is platform independent, Windows-specific meter
+// and steps are built on top of it.
+package guard
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Usage is a resource usage of the process.
+type Usage struct {
+	// CPU is a CPU time consumed per wall clock time since the previous
+	// measurement, i.e. 1.0 means a single fully loaded core.
+	CPU float64
+
+	// RSS is a resident set size (working set) in bytes.
+	RSS uint64
+}
+
+// Meter measures resource usage of the process.
+type Meter interface {
+	Usage() (Usage, error)
+}
+
+// MeterFunc is an adapter to use ordinary functions as Meter.
+type MeterFunc func() (Usage, error)
+
+// Usage calls f().
+func (f MeterFunc) Usage() (Usage, error) {
+	return f()
+}
+
+// Budget is a resource usage limit. Zero fields mean no limit.
+type Budget struct {
+	CPU float64
+	RSS uint64
+}
+
+// exceeded returns true if @u doesn't fit the budget scaled by @ratio.
+func (b Budget) exceeded(u Usage, ratio float64) bool {
+	if b.CPU > 0 && u.CPU > b.CPU*ratio {
+		return true
+	}
+	if b.RSS > 0 && float64(u.RSS) > float64(b.RSS)*ratio {
+		return true
+	}
+	return false
+}
+
+// Step is a single restriction Guard applies to reduce resource usage.
+type Step interface {
+	Apply() error
+	Revert() error
+}
+
+// FuncStep is an adapter to use a pair of ordinary functions as Step.
+type FuncStep struct {
+	ApplyFunc  func() error
+	RevertFunc func() error
+}
+
+// Apply calls s.ApplyFunc.
+func (s FuncStep) Apply() error {
+	return s.ApplyFunc()
+}
+
+// Revert calls s.RevertFunc.
+func (s FuncStep) Revert() error {
+	return s.RevertFunc()
+}
+
+// Options describes Guard reaction settings.
+type Options struct {
+	// Interval is a period between usage measurements.
+	Interval time.Duration
+
+	// Trip is a number of consecutive over-budget measurements that make
+	// Guard apply the next step. It smooths short spikes.
+	Trip int
+
+	// Recover is a number of consecutive measurements below
+	// RecoverRatio * Budget that make Guard revert the last applied step.
+	Recover      int
+	RecoverRatio float64
+
+	// OnChange is called after a step has been applied or reverted with the
+	// number of currently applied steps and the usage caused the change.
+	OnChange func(applied int, u Usage)
+
+	// OnError is called on measurement and step errors.
+	OnError func(err error)
+}
+
+// Option is any function that modifies Options.
+type Option func(cfg *Options)
+
+// WithInterval sets a period between usage measurements.
+func WithInterval(d time.Duration) Option {
+	return func(cfg *Options) {
+		cfg.Interval = d
+	}
+}
+
+// WithHysteresis makes Guard apply a step after @trip consecutive
+// over-budget measurements and revert it after @recover consecutive
+// measurements below @ratio of the budget.
+func WithHysteresis(trip, recover int, ratio float64) Option {
+	return func(cfg *Options) {
+		cfg.Trip = trip
+		cfg.Recover = recover
+		cfg.RecoverRatio = ratio
+	}
+}
+
+// WithOnChange sets a function called after every applied or reverted step.
+func WithOnChange(f func(applied int, u Usage)) Option {
+	return func(cfg *Options) {
+		cfg.OnChange = f
+	}
+}
+
+// WithOnError sets a function called on measurement and step errors.
+func WithOnError(f func(err error)) Option {
+	return func(cfg *Options) {
+		cfg.OnError = f
+	}
+}
+
+// Guard applies and reverts Steps to keep resource usage within Budget.
+// Guard is safe for concurrent use.
+type Guard struct {
+	meter  Meter
+	budget Budget
+	steps  []Step
+	cfg    Options
+
+	mu       sync.Mutex
+	applied  int
+	over     int
+	under    int
+	lastSeen Usage
+}
+
+// New creates a Guard applying @steps in the given order. By default usage is
+// measured every 5 seconds, a step is applied after 3 over-budget
+// measurements and reverted after 12 measurements below 80% of the budget.
+func New(meter Meter, budget Budget, steps []Step, options ...Option) (*Guard, error) {
+	cfg := Options{
+		Interval:     5 * time.Second,
+		Trip:         3,
+		Recover:      12,
+		RecoverRatio: 0.8,
+	}
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	switch {
+	case cfg.Interval <= 0:
+		return nil, fmt.Errorf("non-positive interval %s", cfg.Interval)
+	case cfg.Trip <= 0 || cfg.Recover <= 0:
+		return nil, fmt.Errorf("non-positive hysteresis counters")
+	case cfg.RecoverRatio <= 0 || cfg.RecoverRatio > 1:
+		return nil, fmt.Errorf("recover ratio %v is out of (0, 1]", cfg.RecoverRatio)
+	}
+	return &Guard{meter: meter, budget: budget, steps: steps, cfg: cfg}, nil
+}
+
+// Run measures usage every Options.Interval until @ctx is done. Errors are
+// passed to Options.OnError.
+func (g *Guard) Run(ctx context.Context) {
+	ticker := time.NewTicker(g.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := g.Check(); err != nil && g.cfg.OnError != nil {
+				g.cfg.OnError(err)
+			}
+		}
+	}
+}
+
+// Check performs a single measurement applying or reverting a step if
+// needed. Run calls it periodically, call it directly to drive Guard with
+// your own scheduler.
+func (g *Guard) Check() error {
+	u, err := g.meter.Usage()
+	if err != nil {
+		return fmt.Errorf("failed to measure usage; %w", err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lastSeen = u
+
+	switch {
+	case g.budget.exceeded(u, 1):
+		g.over, g.under = g.over+1, 0
+		if g.over < g.cfg.Trip || g.applied == len(g.steps) {
+			return nil
+		}
+		g.over = 0
+		if err := g.steps[g.applied].Apply(); err != nil {
+			return fmt.Errorf("failed to apply step %d; %w", g.applied, err)
+		}
+		g.applied++
+
+	case !g.budget.exceeded(u, g.cfg.RecoverRatio):
+		g.over, g.under = 0, g.under+1
+		if g.under < g.cfg.Recover || g.applied == 0 {
+			return nil
+		}
+		g.under = 0
+		if err := g.steps[g.applied-1].Revert(); err != nil {
+			return fmt.Errorf("failed to revert step %d; %w", g.applied-1, err)
+		}
+		g.applied--
+
+	default:
+		// Within the hysteresis band, keep things as is.
+		g.over, g.under = 0, 0
+		return nil
+	}
+
+	if g.cfg.OnChange != nil {
+		g.cfg.OnChange(g.applied, u)
+	}
+	return nil
+}
+
+// Applied returns a number of currently applied steps.
+func (g *Guard) Applied() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.applied
+}
+
+// LastUsage returns the latest measured usage.
+func (g *Guard) LastUsage() Usage {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.lastSeen
+}
+
+// Sampler passes every N-th event through. Zero value Sampler passes all
+// events. Sampler is safe for concurrent use.
+type Sampler struct {
+	rate    uint32
+	counter uint64
+}
+
+// NewSampler creates a Sampler passing all events.
+func NewSampler() *Sampler {
+	return &Sampler{rate: 1}
+}
+
+// Keep returns true if the next event should be processed.
+func (s *Sampler) Keep() bool {
+	rate := uint64(s.Rate())
+	return atomic.AddUint64(&s.counter, 1)%rate == 0
+}
+
+// Rate returns N of "every N-th event".
+func (s *Sampler) Rate() uint32 {
+	if rate := atomic.LoadUint32(&s.rate); rate > 1 {
+		return rate
+	}
+	return 1
+}
+
+// SetRate makes Sampler pass every @n-th event.
+func (s *Sampler) SetRate(n uint32) {
+	atomic.StoreUint32(&s.rate, n)
+}
+
+// IncreaseSampling returns a Step multiplying the @s rate by @factor. The
+// @factor should be at least 2, smaller ones would make a no-op step.
+func IncreaseSampling(s *Sampler, factor uint32) (Step, error) {
+	if factor < 2 {
+		return nil, fmt.Errorf("sampling factor %d is less than 2", factor)
+	}
+	return FuncStep{
+		ApplyFunc: func() error {
+			s.SetRate(s.Rate() * factor)
+			return nil
+		},
+		RevertFunc: func() error {
+			if rate := s.Rate() / factor; rate > 1 {
+				s.SetRate(rate)
+			} else {
+				s.SetRate(1)
+			}
+			return nil
+		},
+	}, nil
+}
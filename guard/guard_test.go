@@ -0,0 +1,119 @@
+package guard_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/guard"
+)
+
+// feed is a Meter returning preset usage values.
+type feed struct{ usage guard.Usage }
+
+func (f *feed) Usage() (guard.Usage, error) { return f.usage, nil }
+
+// recorder is a Step logging its calls.
+func recorder(name string, log *[]string) guard.Step {
+	return guard.FuncStep{
+		ApplyFunc:  func() error { *log = append(*log, "apply "+name); return nil },
+		RevertFunc: func() error { *log = append(*log, "revert "+name); return nil },
+	}
+}
+
+func TestGuard(t *testing.T) {
+	var log []string
+	meter := &feed{}
+	g, err := guard.New(meter, guard.Budget{CPU: 0.5, RSS: 1000}, []guard.Step{
+		recorder("first", &log),
+		recorder("second", &log),
+	}, guard.WithHysteresis(2, 3, 0.5))
+	require.NoError(t, err, "Failed to create guard")
+
+	check := func(times int) {
+		for i := 0; i < times; i++ {
+			require.NoError(t, g.Check())
+		}
+	}
+
+	// A single spike is ignored.
+	meter.usage = guard.Usage{CPU: 0.9}
+	check(1)
+	meter.usage = guard.Usage{CPU: 0.1}
+	check(1)
+	meter.usage = guard.Usage{CPU: 0.9}
+	check(1)
+	assert.Empty(t, log, "Unexpected reaction to a spike")
+
+	// Sustained overuse applies steps one by one, but not more than we have.
+	meter.usage = guard.Usage{CPU: 0.1, RSS: 2000}
+	check(6)
+	assert.Equal(t, []string{"apply first", "apply second"}, log)
+	assert.Equal(t, 2, g.Applied())
+
+	// Usage within the hysteresis band changes nothing.
+	meter.usage = guard.Usage{CPU: 0.4}
+	check(10)
+	assert.Equal(t, 2, g.Applied())
+
+	// Low usage reverts steps in reverse order.
+	meter.usage = guard.Usage{CPU: 0.1, RSS: 100}
+	check(3)
+	assert.Equal(t, 1, g.Applied())
+	check(10)
+	assert.Equal(t, []string{"apply first", "apply second", "revert second", "revert first"}, log)
+	assert.Equal(t, 0, g.Applied())
+	assert.Equal(t, meter.usage, g.LastUsage())
+}
+
+func TestGuardStepError(t *testing.T) {
+	failing := guard.FuncStep{
+		ApplyFunc:  func() error { return errors.New("boom") },
+		RevertFunc: func() error { return nil },
+	}
+	meter := &feed{usage: guard.Usage{CPU: 2}}
+	g, err := guard.New(meter, guard.Budget{CPU: 1}, []guard.Step{failing}, guard.WithHysteresis(1, 1, 1))
+	require.NoError(t, err, "Failed to create guard")
+
+	assert.Error(t, g.Check(), "Step error is not reported")
+	assert.Equal(t, 0, g.Applied(), "Failed step is counted as applied")
+}
+
+func TestGuardValidation(t *testing.T) {
+	_, err := guard.New(&feed{}, guard.Budget{}, nil, guard.WithInterval(0))
+	assert.Error(t, err)
+	_, err = guard.New(&feed{}, guard.Budget{}, nil, guard.WithHysteresis(1, 1, 1.5))
+	assert.Error(t, err)
+}
+
+func TestSampling(t *testing.T) {
+	s := guard.NewSampler()
+	step, err := guard.IncreaseSampling(s, 4)
+	require.NoError(t, err)
+
+	count := func() (kept int) {
+		for i := 0; i < 100; i++ {
+			if s.Keep() {
+				kept++
+			}
+		}
+		return kept
+	}
+	assert.Equal(t, 100, count())
+
+	require.NoError(t, step.Apply())
+	require.NoError(t, step.Apply())
+	assert.EqualValues(t, 16, s.Rate())
+	assert.InDelta(t, 100/16, count(), 1)
+
+	require.NoError(t, step.Revert())
+	require.NoError(t, step.Revert())
+	assert.EqualValues(t, 1, s.Rate())
+
+	for _, factor := range []uint32{0, 1} {
+		_, err := guard.IncreaseSampling(s, factor)
+		assert.Error(t, err, "Factor %d is accepted", factor)
+	}
+}
@@ -0,0 +1,172 @@
+//+build windows
+
+package guard
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw"
+)
+
+// processMemoryCounters is PROCESS_MEMORY_COUNTERS.
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/psapi/ns-psapi-process_memory_counters
+type processMemoryCounters struct {
+	Cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+var procGetProcessMemoryInfo = windows.NewLazySystemDLL("kernel32.dll").NewProc("K32GetProcessMemoryInfo")
+
+// ProcessMeter returns a Meter of the current process. CPU usage is
+// measured with GetProcessTimes, so the first measurement reports the
+// average usage since the process start. RSS is the process working set.
+func ProcessMeter() Meter {
+	var (
+		mu       sync.Mutex
+		lastCPU  time.Duration
+		lastWall time.Time
+	)
+	return MeterFunc(func() (Usage, error) {
+		process := windows.CurrentProcess()
+
+		var creation, exit, kernel, user windows.Filetime
+		if err := windows.GetProcessTimes(process, &creation, &exit, &kernel, &user); err != nil {
+			return Usage{}, fmt.Errorf("GetProcessTimes failed; %w", err)
+		}
+		counters := processMemoryCounters{Cb: uint32(unsafe.Sizeof(processMemoryCounters{}))}
+		ret, _, err := procGetProcessMemoryInfo.Call(
+			uintptr(process), uintptr(unsafe.Pointer(&counters)), uintptr(counters.Cb))
+		if ret == 0 {
+			return Usage{}, fmt.Errorf("GetProcessMemoryInfo failed; %w", err)
+		}
+
+		now := time.Now()
+		cpu := filetimeDuration(kernel) + filetimeDuration(user)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if lastWall.IsZero() {
+			lastWall = time.Unix(0, creation.Nanoseconds())
+		}
+		u := Usage{RSS: uint64(counters.WorkingSetSize)}
+		if wall := now.Sub(lastWall); wall > 0 {
+			u.CPU = float64(cpu-lastCPU) / float64(wall)
+		}
+		lastCPU, lastWall = cpu, now
+		return u, nil
+	})
+}
+
+// filetimeDuration converts FILETIME holding a time span to time.Duration.
+func filetimeDuration(ft windows.Filetime) time.Duration {
+	return time.Duration(uint64(ft.HighDateTime)<<32|uint64(ft.LowDateTime)) * 100
+}
+
+// RaiseLevel returns a Step lowering the maximum level of events received
+// from the provider @guid to @lvl. Revert restores the level the provider had
+// before Apply.
+func RaiseLevel(s *etw.Session, guid windows.GUID, lvl etw.TraceLevel) Step {
+	var prev etw.TraceLevel
+	return FuncStep{
+		ApplyFunc: func() error {
+			cfg, ok := s.Providers()[guid]
+			if !ok {
+				return fmt.Errorf("provider %s is not enabled on the session", guid)
+			}
+			prev = cfg.Level
+			if cfg.Level <= lvl {
+				return nil // Already strict enough.
+			}
+			return s.SetLevel(guid, lvl)
+		},
+		RevertFunc: func() error {
+			if cfg, ok := s.Providers()[guid]; ok && cfg.Level == prev {
+				return nil
+			}
+			return s.SetLevel(guid, prev)
+		},
+	}
+}
+
+// PauseNoisy returns a Step pausing @n session providers that delivered the
+// most events so far (according to etw.Session.ProviderStats). Revert resumes
+// the providers paused by Apply.
+func PauseNoisy(s *etw.Session, n int) Step {
+	var paused []windows.GUID
+	return FuncStep{
+		ApplyFunc: func() error {
+			type candidate struct {
+				guid   windows.GUID
+				events uint64
+			}
+			var candidates []candidate
+			for guid := range s.Providers() {
+				if s.Paused(guid) {
+					continue
+				}
+				if stats, ok := s.ProviderStats(guid); ok {
+					candidates = append(candidates, candidate{guid, stats.EventsReceived})
+				}
+			}
+			sort.Slice(candidates, func(i, j int) bool {
+				return candidates[i].events > candidates[j].events
+			})
+
+			paused = paused[:0]
+			for i := 0; i < n && i < len(candidates); i++ {
+				if err := s.PauseProvider(candidates[i].guid); err != nil {
+					// Guard doesn't revert failed steps, so roll back ourselves.
+					for _, guid := range paused {
+						_ = s.ResumeProvider(guid)
+					}
+					return err
+				}
+				paused = append(paused, candidates[i].guid)
+			}
+			return nil
+		},
+		RevertFunc: func() error {
+			for len(paused) > 0 {
+				if err := s.ResumeProvider(paused[0]); err != nil {
+					return err
+				}
+				paused = paused[1:]
+			}
+			return nil
+		},
+	}
+}
+
+// EventCallback wraps @cb passing it only events kept by the Sampler.
+func (s *Sampler) EventCallback(cb etw.EventCallback) etw.EventCallback {
+	return func(e *etw.Event) {
+		if s.Keep() {
+			cb(e)
+		}
+	}
+}
+
+// ContextEventCallback is the same as EventCallback for callbacks processing
+// events with etw.Session.ProcessContext.
+func (s *Sampler) ContextEventCallback(cb etw.ContextEventCallback) etw.ContextEventCallback {
+	return func(ctx context.Context, e *etw.Event) {
+		if s.Keep() {
+			cb(ctx, e)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+//+build windows
+
+package etw
+
+/*
+	#include "session.h"
+*/
+import "C"
+import (
+	"crypto/sha1" //nolint:gosec // SHA-1 is mandated by the algorithm.
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ParseGUID parses a GUID string in the canonical form with or without
+// braces, e.g. both `{1C95126E-7EEA-49A9-A3FE-A378B03DDB4D}` and
+// `1c95126e-7eea-49a9-a3fe-a378b03ddb4d` are accepted.
+func ParseGUID(s string) (windows.GUID, error) {
+	var guid windows.GUID
+
+	str := strings.TrimSpace(s)
+	if strings.HasPrefix(str, "{") != strings.HasSuffix(str, "}") {
+		return guid, fmt.Errorf("invalid GUID %q: unbalanced braces", s)
+	}
+	str = strings.TrimSuffix(strings.TrimPrefix(str, "{"), "}")
+
+	// xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx
+	parts := strings.Split(str, "-")
+	if len(parts) != 5 {
+		return guid, fmt.Errorf("invalid GUID %q: expected 5 dash-separated groups, got %d", s, len(parts))
+	}
+	groupLengths := [5]int{8, 4, 4, 4, 12}
+	raw := make([]byte, 0, 16)
+	for i, part := range parts {
+		if len(part) != groupLengths[i] {
+			return guid, fmt.Errorf("invalid GUID %q: group %d should have %d hex digits, got %d",
+				s, i+1, groupLengths[i], len(part))
+		}
+		b, err := hex.DecodeString(part)
+		if err != nil {
+			return guid, fmt.Errorf("invalid GUID %q: group %d is not a hex number", s, i+1)
+		}
+		raw = append(raw, b...)
+	}
+
+	guid.Data1 = uint32(raw[0])<<24 | uint32(raw[1])<<16 | uint32(raw[2])<<8 | uint32(raw[3])
+	guid.Data2 = uint16(raw[4])<<8 | uint16(raw[5])
+	guid.Data3 = uint16(raw[6])<<8 | uint16(raw[7])
+	copy(guid.Data4[:], raw[8:])
+	return guid, nil
+}
+
+// providerNamespace is the namespace EventSource and TraceLogging providers
+// derive their GUIDs in: {482C2DB2-C390-47C8-87F8-1A15BFC130FB} in the
+// big-endian form.
+//
+//nolint:gochecknoglobals
+var providerNamespace = [16]byte{
+	0x48, 0x2C, 0x2D, 0xB2, 0xC3, 0x90, 0x47, 0xC8,
+	0x87, 0xF8, 0x1A, 0x15, 0xBF, 0xC1, 0x30, 0xFB,
+}
+
+// ProviderGUIDFromName returns a GUID of the TraceLogging (or EventSource)
+// provider @name derived from the name the same way the provider does it,
+// e.g. `MyCompany.MyComponent` is {CE5FA4EA-AB00-5402-8B76-9F76AC858FB5}.
+// It allows to subscribe to such providers by name while they are not
+// registered (so LookupProvider can't find them).
+//
+// The GUID is a SHA-1 hash of the namespace and the upper-cased name in
+// UTF-16BE, that is similar to (but not the same as) UUID v5.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/traceloggingprovider/nf-traceloggingprovider-tracelogging_define_provider#provider-name-and-id
+func ProviderGUIDFromName(name string) windows.GUID {
+	hash := sha1.New() //nolint:gosec // SHA-1 is mandated by the algorithm.
+	hash.Write(providerNamespace[:])
+	_ = binary.Write(hash, binary.BigEndian, utf16.Encode([]rune(strings.ToUpper(name))))
+	sum := hash.Sum(nil)
+	sum[7] = (sum[7] & 0x0F) | 0x50 // Version 5.
+
+	// Unlike UUIDs, the hash is treated as a little-endian GUID.
+	guid := windows.GUID{
+		Data1: binary.LittleEndian.Uint32(sum[0:]),
+		Data2: binary.LittleEndian.Uint16(sum[4:]),
+		Data3: binary.LittleEndian.Uint16(sum[6:]),
+	}
+	copy(guid.Data4[:], sum[8:16])
+	return guid
+}
+
+// LookupProvider finds a GUID of the provider registered in the system by its
+// @name, e.g. `Microsoft-Windows-DNS-Client`. Names are compared case
+// insensitive.
+//
+// Only providers that have a manifest or MOF registered could be found. To
+// list available providers try:
+//     logman query providers
+func LookupProvider(name string) (windows.GUID, error) {
+	// Retrieve a buffer size.
+	var bufferSize C.ulong
+	ret := C.TdhEnumerateProviders(nil, &bufferSize)
+	var buffer []byte
+	for windows.Errno(ret) == windows.ERROR_INSUFFICIENT_BUFFER {
+		buffer = make([]byte, int(bufferSize))
+		ret = C.TdhEnumerateProviders(
+			(C.PPROVIDER_ENUMERATION_INFO)(unsafe.Pointer(&buffer[0])),
+			&bufferSize)
+	}
+	if status := windows.Errno(ret); status != windows.ERROR_SUCCESS {
+		return windows.GUID{}, fmt.Errorf("TdhEnumerateProviders failed; %w", status)
+	}
+
+	info := (C.PPROVIDER_ENUMERATION_INFO)(unsafe.Pointer(&buffer[0]))
+	for i := 0; i < int(C.GetProviderCount(info)); i++ {
+		namePtr := unsafe.Pointer(C.GetProviderName(info, C.int(i)))
+		length := C.wcslen((C.PWCHAR)(namePtr))
+		if strings.EqualFold(createUTF16String(namePtr, int(length)), name) {
+			return windowsGUIDToGo(C.GetProviderGUID(info, C.int(i))), nil
+		}
+	}
+	return windows.GUID{}, fmt.Errorf("provider %q is not registered in the system", name)
+}
+
+// ResolveProvider returns a GUID of the provider given either by its string
+// GUID (in any form accepted by ParseGUID) or by its registered name.
+func ResolveProvider(provider string) (windows.GUID, error) {
+	if guid, err := ParseGUID(provider); err == nil {
+		return guid, nil
+	}
+	guid, err := LookupProvider(provider)
+	if err != nil {
+		return windows.GUID{}, fmt.Errorf("%q is neither a valid GUID nor a known provider name; %w", provider, err)
+	}
+	return guid, nil
+}
+
+// NewSessionFromString is the same as NewSession but accepts a @provider
+// string GUID or name. Take a look at ResolveProvider for accepted values.
+func NewSessionFromString(provider string, options ...Option) (*Session, error) {
+	guid, err := ResolveProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+	return NewSession(guid, options...)
+}
+
+// AddProviderFromString is the same as AddProvider but accepts a @provider
+// string GUID or name. Take a look at ResolveProvider for accepted values.
+func (s *Session) AddProviderFromString(provider string, options ...Option) error {
+	guid, err := ResolveProvider(provider)
+	if err != nil {
+		return err
+	}
+	return s.AddProvider(guid, options...)
+}
+
+// SetLevelFromString is the same as SetLevel but accepts a @provider string
+// GUID or name. Take a look at ResolveProvider for accepted values.
+func (s *Session) SetLevelFromString(provider string, lvl TraceLevel) error {
+	guid, err := ResolveProvider(provider)
+	if err != nil {
+		return err
+	}
+	return s.SetLevel(guid, lvl)
+}
@@ -0,0 +1,51 @@
+// +build windows
+
+package etw_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw"
+)
+
+func TestParseGUID(t *testing.T) {
+	expected := windows.GUID{
+		Data1: 0x1C95126E,
+		Data2: 0x7EEA,
+		Data3: 0x49A9,
+		Data4: [8]byte{0xA3, 0xFE, 0xA3, 0x78, 0xB0, 0x3D, 0xDB, 0x4D},
+	}
+	for _, s := range []string{
+		"{1C95126E-7EEA-49A9-A3FE-A378B03DDB4D}",
+		"1C95126E-7EEA-49A9-A3FE-A378B03DDB4D",
+		"1c95126e-7eea-49a9-a3fe-a378b03ddb4d",
+		"  {1c95126e-7eea-49a9-a3fe-a378b03ddb4d} ",
+	} {
+		guid, err := etw.ParseGUID(s)
+		require.NoError(t, err, "Failed to parse %q", s)
+		assert.Equal(t, expected, guid, "Unexpected GUID parsed from %q", s)
+	}
+
+	for _, s := range []string{
+		"",
+		"{1C95126E-7EEA-49A9-A3FE-A378B03DDB4D",
+		"1C95126E-7EEA-49A9-A3FEA378B03DDB4D",
+		"1C95126E-7EEA-49A9-A3FE-A378B03DDB4",
+		"1C95126E-7EEA-49A9-A3FE-A378B03DDBZZ",
+	} {
+		_, err := etw.ParseGUID(s)
+		assert.Error(t, err, "Expected error parsing %q", s)
+	}
+}
+
+func TestProviderGUIDFromName(t *testing.T) {
+	expected, err := etw.ParseGUID("{CE5FA4EA-AB00-5402-8B76-9F76AC858FB5}")
+	require.NoError(t, err)
+	assert.Equal(t, expected, etw.ProviderGUIDFromName("MyCompany.MyComponent"))
+	assert.Equal(t, expected, etw.ProviderGUIDFromName("mycompany.mycomponent"), "Name should be case insensitive")
+	assert.NotEqual(t, expected, etw.ProviderGUIDFromName("MyCompany.OtherComponent"))
+}
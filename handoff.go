@@ -0,0 +1,144 @@
+//+build windows
+
+package etw
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// Handoff is everything another process needs to take over a running
+// session: its name and the enable state of its providers. It's produced by
+// `.ExportControl` and consumed by AdoptSession.
+//
+// Handing a session off lets an agent be upgraded without downtime: the old
+// process exports the session and releases it with `.Release`, the new one
+// adopts it. The session keeps running in between, so events logged during
+// the upgrade wait in the session buffers (until they're full).
+type Handoff struct {
+	Name  string
+	State EnableState
+}
+
+// handoffVersion is a version of the Handoff encoding.
+const handoffVersion = 1
+
+type handoffJSON struct {
+	Version int             `json:"version"`
+	Name    string          `json:"name"`
+	State   json.RawMessage `json:"state"`
+}
+
+// Marshal encodes the handoff to a JSON blob to be passed to the adopting
+// process (e.g. via a command line or a pipe) and decoded with
+// UnmarshalHandoff.
+func (h Handoff) Marshal() ([]byte, error) {
+	state, err := h.State.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(handoffJSON{Version: handoffVersion, Name: h.Name, State: state})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode handoff; %w", err)
+	}
+	return data, nil
+}
+
+// UnmarshalHandoff decodes Handoff encoded with `.Marshal`.
+func UnmarshalHandoff(data []byte) (Handoff, error) {
+	var blob handoffJSON
+	if err := json.Unmarshal(data, &blob); err != nil {
+		return Handoff{}, fmt.Errorf("failed to decode handoff; %w", err)
+	}
+	if blob.Version != handoffVersion {
+		return Handoff{}, fmt.Errorf("unsupported handoff version %d", blob.Version)
+	}
+	if blob.Name == "" {
+		return Handoff{}, fmt.Errorf("handoff has no session name")
+	}
+	state, err := UnmarshalEnableState(blob.State)
+	if err != nil {
+		return Handoff{}, err
+	}
+	return Handoff{Name: blob.Name, State: state}, nil
+}
+
+// ExportControl prepares the session to be taken over by another process
+// and returns the Handoff to pass it. If @sid is not nil the account it
+// identifies is granted the rights to query, consume and control the
+// session, e.g. when the new agent version runs under another service
+// account. Processes of the same account or administrators need no grants,
+// so pass nil for them.
+//
+// The grant is a persistent entry of the session GUID DACL as GrantLogging
+// ones are: it stays after the adoption and even after the session stops.
+// ETW has no way to revoke a single entry, EventAccessRemove drops the whole
+// DACL of the GUID. It's harmless for GUIDs ETW generates per session, but
+// with SessionOptions.SessionGUID the account keeps control over every
+// session of that GUID.
+//
+// ExportControl doesn't affect the processing, call `.Release` when the
+// adopting process is ready.
+func (s *Session) ExportControl(sid *windows.SID) (Handoff, error) {
+	if sid != nil {
+		if err := s.grantAccess(sid, wmiguidQuery|tracelogGUIDEnable|tracelogAccessRealtime); err != nil {
+			return Handoff{}, fmt.Errorf("failed to grant access to session %s; %w", s.describe(), err)
+		}
+	}
+	return Handoff{Name: s.config.Name, State: s.EnableState()}, nil
+}
+
+// Release gives up the session ownership without stopping it: consumers
+// opened by `.Process` are closed, but providers stay enabled and the
+// session keeps collecting events for the process adopting it with
+// AdoptSession.
+//
+// After Release the Session is unusable and `.Close` is a no-op.
+func (s *Session) Release() error {
+	s.restartMu.Lock()
+	defer s.restartMu.Unlock()
+	if s.closed {
+		return fmt.Errorf("session %s is closed", s.describe())
+	}
+	// Closing prevents consumers being stopped from restarting the session.
+	s.closed = true
+	s.released = true
+	s.mu.Lock()
+	s.processing = false
+	s.mu.Unlock()
+	s.closeTraces()
+	s.closeStatus()
+	return nil
+}
+
+// AdoptSession takes over the session described by @h that the previous
+// owner has released. The providers are re-enabled with the @h enable state
+// on the first `.Process` call. @options set other parameters the same way
+// as for NewSession; the name is always taken from @h.
+//
+// If the session no longer exists a new one is created, check it with
+// `.Adopted`.
+func AdoptSession(h Handoff, options ...Option) (*Session, error) {
+	if len(h.State.Providers) == 0 {
+		return nil, fmt.Errorf("handoff of session %q has no providers", h.Name)
+	}
+	primary := h.State.Providers[0]
+	options = append(append([]Option(nil), options...), WithName(h.Name), WithAdoptExisting())
+	s, err := NewSession(primary.GUID, append(options, primary.options()...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ApplyEnableState(h.State); err != nil {
+		// Don't stop the session we've failed to take over completely, so
+		// the handoff could be retried.
+		if s.Adopted() {
+			_ = s.Release()
+		} else {
+			_ = s.Close()
+		}
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,83 @@
+// +build windows
+
+package etw_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw"
+)
+
+func TestHandoffMarshal(t *testing.T) {
+	handoff := etw.Handoff{
+		Name: "go-etw-handoff",
+		State: etw.EnableState{Providers: []etw.ProviderState{{
+			GUID:            windows.GUID{Data1: 0x1},
+			Level:           etw.TRACE_LEVEL_INFORMATION,
+			MatchAnyKeyword: 0x10,
+		}}},
+	}
+	blob, err := handoff.Marshal()
+	require.NoError(t, err, "Failed to marshal handoff")
+	decoded, err := etw.UnmarshalHandoff(blob)
+	require.NoError(t, err, "Failed to unmarshal handoff")
+	assert.Equal(t, handoff, decoded, "Handoff changed after round-trip")
+
+	for _, blob := range []string{
+		`{"version":2,"name":"s","state":{"version":1,"providers":[]}}`,
+		`{"version":1,"name":"","state":{"version":1,"providers":[]}}`,
+		`{"version":1,"name":"s","state":{"version":2,"providers":[]}}`,
+	} {
+		_, err := etw.UnmarshalHandoff([]byte(blob))
+		assert.Error(t, err, "Invalid blob %s is accepted", blob)
+	}
+}
+
+//nolint:gochecknoglobals
+var procEventAccessQuery = windows.NewLazySystemDLL("advapi32.dll").NewProc("EventAccessQuery")
+
+// allowedRights returns the rights the DACL of the ETW @guid allows to @sid.
+func allowedRights(guid windows.GUID, sid *windows.SID) (uint32, error) {
+	var size uint32
+	_, _, _ = procEventAccessQuery.Call(uintptr(unsafe.Pointer(&guid)), 0, uintptr(unsafe.Pointer(&size)))
+	if size == 0 {
+		return 0, fmt.Errorf("EventAccessQuery returned no security descriptor")
+	}
+	sd := make([]byte, size)
+	ret, _, _ := procEventAccessQuery.Call(
+		uintptr(unsafe.Pointer(&guid)),
+		uintptr(unsafe.Pointer(&sd[0])),
+		uintptr(unsafe.Pointer(&size)))
+	if status := windows.Errno(ret); status != windows.ERROR_SUCCESS {
+		return 0, fmt.Errorf("EventAccessQuery failed; %w", status)
+	}
+
+	// The descriptor is a self-relative SECURITY_DESCRIPTOR, the DACL is an
+	// ACL header followed by ACEs.
+	dacl := int(binary.LittleEndian.Uint32(sd[16:]))
+	if dacl == 0 || dacl+8 > len(sd) {
+		return 0, nil
+	}
+	var rights uint32
+	count := int(binary.LittleEndian.Uint16(sd[dacl+4:]))
+	for i, ace := 0, dacl+8; i < count && ace+8 <= len(sd); i++ {
+		aceSize := int(binary.LittleEndian.Uint16(sd[ace+2:]))
+		if aceSize <= 8 || ace+aceSize > len(sd) {
+			break
+		}
+		const accessAllowedACEType = 0
+		aceSID := (*windows.SID)(unsafe.Pointer(&sd[ace+8]))
+		if sd[ace] == accessAllowedACEType && windows.EqualSid(aceSID, sid) {
+			rights |= binary.LittleEndian.Uint32(sd[ace+4:])
+		}
+		ace += aceSize
+	}
+	return rights, nil
+}
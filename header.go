@@ -0,0 +1,100 @@
+//+build windows
+
+package etw
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw/internal/logheader"
+)
+
+// EventTraceGUID identifies the trace header event ETW delivers first to
+// every consumer. The event payload is a TRACE_LOGFILE_HEADER structure,
+// it's decoded to SessionInfo and never passed to EventCallback.
+//
+//nolint:gochecknoglobals
+var EventTraceGUID = windows.GUID{
+	Data1: 0x68fdd900,
+	Data2: 0x4a3e,
+	Data3: 0x11d1,
+	Data4: [8]byte{0x84, 0xf4, 0x00, 0x00, 0xf8, 0x04, 0x64, 0xe3},
+}
+
+// SessionInfo describes the trace as it's reported by the trace header event
+// (TRACE_LOGFILE_HEADER). It's needed to interpret raw timestamps and
+// pointer-sized values of events correctly.
+//
+// For more info about fields refer to TRACE_LOGFILE_HEADER docs:
+// https://docs.microsoft.com/en-us/windows/win32/api/evntrace/ns-evntrace-trace_logfile_header
+type SessionInfo struct {
+	// OSMajorVersion, OSMinorVersion and OSBuild identify the OS the trace
+	// has been recorded on.
+	OSMajorVersion uint8
+	OSMinorVersion uint8
+	OSBuild        uint32
+
+	NumberOfProcessors uint32
+	CPUSpeedInMHz      uint32
+	PointerSize        uint32
+
+	// TimerResolution is a resolution of the hardware timer.
+	TimerResolution time.Duration
+
+	// ClockType is a session clock: 1 -- QPC, 2 -- system time, 3 -- CPU
+	// cycle counter. PerfFreq is a QPC frequency in counts per second.
+	ClockType uint32
+	PerfFreq  int64
+
+	BootTime  time.Time
+	StartTime time.Time
+	EndTime   time.Time // Zero for real-time sessions.
+
+	BufferSize      uint32 // In bytes.
+	MaximumFileSize uint32 // In megabytes.
+	LogFileMode     uint32
+	BuffersWritten  uint32
+	BuffersLost     uint32
+	EventsLost      uint32
+
+	LoggerName  string
+	LogFileName string
+}
+
+// isTraceHeader returns true if @h is a header of the trace header event.
+func isTraceHeader(h *EventHeader) bool {
+	return h.ProviderID == EventTraceGUID && h.OpCode == 0 // EVENT_TRACE_TYPE_INFO
+}
+
+// parseSessionInfo decodes TRACE_LOGFILE_HEADER from @data, the payload of
+// the trace header event recorded with @ptrSize pointers.
+func parseSessionInfo(data []byte, ptrSize int) (SessionInfo, error) {
+	h, err := logheader.Parse(data, ptrSize)
+	if err != nil {
+		return SessionInfo{}, fmt.Errorf("%w: %s", ErrMalformedEvent, err)
+	}
+	return SessionInfo{
+		OSMajorVersion:     uint8(h.Version),
+		OSMinorVersion:     uint8(h.Version >> 8),
+		OSBuild:            h.ProviderVersion,
+		NumberOfProcessors: h.NumberOfProcessors,
+		CPUSpeedInMHz:      h.CPUSpeedInMHz,
+		PointerSize:        h.PointerSize,
+		TimerResolution:    time.Duration(h.TimerResolution) * 100,
+		ClockType:          h.ClockType,
+		PerfFreq:           h.PerfFreq,
+		BootTime:           h.BootTime,
+		StartTime:          h.StartTime,
+		EndTime:            h.EndTime,
+		BufferSize:         h.BufferSize,
+		MaximumFileSize:    h.MaximumFileSize,
+		LogFileMode:        h.LogFileMode,
+		BuffersWritten:     h.BuffersWritten,
+		BuffersLost:        h.BuffersLost,
+		EventsLost:         h.EventsLost,
+		LoggerName:         h.LoggerName,
+		LogFileName:        h.LogFileName,
+	}, nil
+}
@@ -0,0 +1,59 @@
+// +build windows
+
+package etw
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseSessionInfo ensures that TRACE_LOGFILE_HEADER is decoded for both
+// pointer sizes.
+func TestParseSessionInfo(t *testing.T) {
+	start := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	toFiletime := func(t time.Time) uint64 {
+		return uint64(t.UnixNano()/100 + 116444736000000000)
+	}
+
+	for _, ptrSize := range []int{4, 8} {
+		timesOffset := (56 + 2*ptrSize + 172 + 7) &^ 7
+		data := make([]byte, timesOffset+32)
+		binary.LittleEndian.PutUint32(data[0:], 64<<10)
+		data[4], data[5] = 10, 0
+		binary.LittleEndian.PutUint32(data[8:], 19041)
+		binary.LittleEndian.PutUint32(data[12:], 8)
+		binary.LittleEndian.PutUint32(data[24:], 156250)
+		binary.LittleEndian.PutUint32(data[44:], uint32(ptrSize))
+		binary.LittleEndian.PutUint64(data[timesOffset+8:], 10000000)
+		binary.LittleEndian.PutUint64(data[timesOffset+16:], toFiletime(start))
+		binary.LittleEndian.PutUint32(data[timesOffset+24:], 1)
+		for _, s := range []string{"logger", ""} {
+			for _, c := range utf16.Encode([]rune(s + "\x00")) {
+				data = append(data, byte(c), byte(c>>8))
+			}
+		}
+
+		info, err := parseSessionInfo(data, ptrSize)
+		require.NoError(t, err, "Failed to parse header with %d-byte pointers", ptrSize)
+		assert.Equal(t, SessionInfo{
+			OSMajorVersion:     10,
+			OSBuild:            19041,
+			NumberOfProcessors: 8,
+			PointerSize:        uint32(ptrSize),
+			TimerResolution:    15625 * time.Microsecond,
+			ClockType:          1,
+			PerfFreq:           10000000,
+			StartTime:          time.Unix(0, start.UnixNano()),
+			BufferSize:         64 << 10,
+			LoggerName:         "logger",
+		}, info)
+
+		_, err = parseSessionInfo(data[:timesOffset], ptrSize)
+		assert.Error(t, err, "Truncated header is parsed")
+	}
+}
@@ -0,0 +1,106 @@
+// Package logheader decodes TRACE_LOGFILE_HEADER, the payload of the first
+// event of every trace. It's shared by the etw package decoding it from
+// real-time and file sessions and by the etlfile reader.
+package logheader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+// Header is TRACE_LOGFILE_HEADER with times converted to time.Time.
+//
+// For more info about fields refer to TRACE_LOGFILE_HEADER docs:
+// https://docs.microsoft.com/en-us/windows/win32/api/evntrace/ns-evntrace-trace_logfile_header
+type Header struct {
+	BufferSize uint32
+
+	// Version holds the OS major version in the first byte and the minor
+	// one in the second. ProviderVersion is the OS build number.
+	Version         uint32
+	ProviderVersion uint32
+
+	NumberOfProcessors uint32
+	EndTime            time.Time
+	TimerResolution    uint32 // In 100-nanosecond intervals.
+	MaximumFileSize    uint32
+	LogFileMode        uint32
+	BuffersWritten     uint32
+	PointerSize        uint32
+	EventsLost         uint32
+	CPUSpeedInMHz      uint32
+	BootTime           time.Time
+	PerfFreq           int64
+	StartTime          time.Time
+	ClockType          uint32
+	BuffersLost        uint32
+
+	LoggerName  string
+	LogFileName string
+}
+
+// Parse decodes TRACE_LOGFILE_HEADER from @data recorded with @ptrSize
+// pointers.
+func Parse(data []byte, ptrSize int) (Header, error) {
+	// Offsets of the fields following LoggerName and LogFileName pointers
+	// and TIME_ZONE_INFORMATION (172 bytes).
+	tzOffset := 56 + 2*ptrSize
+	timesOffset := (tzOffset + 172 + 7) &^ 7
+	stringsOffset := timesOffset + 32
+	if len(data) < stringsOffset {
+		return Header{}, fmt.Errorf("trace header of %d bytes is truncated", len(data))
+	}
+
+	u32 := func(off int) uint32 { return binary.LittleEndian.Uint32(data[off:]) }
+	i64 := func(off int) int64 { return int64(binary.LittleEndian.Uint64(data[off:])) }
+	h := Header{
+		BufferSize:         u32(0),
+		Version:            u32(4),
+		ProviderVersion:    u32(8),
+		NumberOfProcessors: u32(12),
+		EndTime:            FiletimeToTime(i64(16)),
+		TimerResolution:    u32(24),
+		MaximumFileSize:    u32(28),
+		LogFileMode:        u32(32),
+		BuffersWritten:     u32(36),
+		PointerSize:        u32(44),
+		EventsLost:         u32(48),
+		CPUSpeedInMHz:      u32(52),
+		BootTime:           FiletimeToTime(i64(timesOffset)),
+		PerfFreq:           i64(timesOffset + 8),
+		StartTime:          FiletimeToTime(i64(timesOffset + 16)),
+		ClockType:          u32(timesOffset + 24),
+		BuffersLost:        u32(timesOffset + 28),
+	}
+	rest := data[stringsOffset:]
+	h.LoggerName, rest = readUTF16(rest)
+	h.LogFileName, _ = readUTF16(rest)
+	return h, nil
+}
+
+// FiletimeToTime converts FILETIME (100-nanosecond intervals since January 1,
+// 1601 UTC) to time.Time. Zero FILETIME is converted to zero time.Time.
+func FiletimeToTime(ft int64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	const epochDiff = 116444736000000000 // Between 1601 and 1970 in 100ns.
+	return time.Unix(0, (ft-epochDiff)*100)
+}
+
+// readUTF16 reads a null-terminated UTF-16 string from @data and returns it
+// with the rest of data.
+func readUTF16(data []byte) (string, []byte) {
+	var chars []uint16
+	for len(data) >= 2 {
+		c := binary.LittleEndian.Uint16(data)
+		data = data[2:]
+		if c == 0 {
+			break
+		}
+		chars = append(chars, c)
+	}
+	return string(utf16.Decode(chars)), data
+}
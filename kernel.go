@@ -0,0 +1,65 @@
+//+build windows
+
+package etw
+
+import (
+	"context"
+
+	"golang.org/x/sys/windows"
+)
+
+// KernelLoggerName is the name of the NT Kernel Logger session. There is
+// only one such session in the system.
+const KernelLoggerName = "NT Kernel Logger"
+
+// SystemTraceControlGUID identifies the NT Kernel Logger session. Events of
+// the session are classic (MOF) events and come from the kernel event
+// classes (Process, Thread, Image, DiskIo, etc.) rather than from this GUID.
+//
+//nolint:gochecknoglobals
+var SystemTraceControlGUID = windows.GUID{
+	Data1: 0x9e814aad,
+	Data2: 0x3204,
+	Data3: 0x11d2,
+	Data4: [8]byte{0x9a, 0x82, 0x00, 0x60, 0x08, 0xa8, 0x69, 0x39},
+}
+
+// KernelFlag selects a kind of events the NT Kernel Logger writes.
+//
+// For more info about available flags refer to EnableFlags field docs:
+// https://docs.microsoft.com/en-us/windows/win32/api/evntrace/ns-evntrace-event_trace_properties
+type KernelFlag uint32
+
+//nolint:golint,stylecheck // We keep original names to underline that it's an external constants.
+const (
+	EVENT_TRACE_FLAG_PROCESS          = KernelFlag(0x00000001)
+	EVENT_TRACE_FLAG_THREAD           = KernelFlag(0x00000002)
+	EVENT_TRACE_FLAG_IMAGE_LOAD       = KernelFlag(0x00000004)
+	EVENT_TRACE_FLAG_PROCESS_COUNTERS = KernelFlag(0x00000008)
+	EVENT_TRACE_FLAG_DISK_IO          = KernelFlag(0x00000100)
+	EVENT_TRACE_FLAG_DISK_FILE_IO     = KernelFlag(0x00000200)
+	EVENT_TRACE_FLAG_NETWORK_TCPIP    = KernelFlag(0x00010000)
+	EVENT_TRACE_FLAG_REGISTRY         = KernelFlag(0x00020000)
+	EVENT_TRACE_FLAG_FILE_IO          = KernelFlag(0x02000000)
+	EVENT_TRACE_FLAG_FILE_IO_INIT     = KernelFlag(0x04000000)
+)
+
+// NewKernelSession creates the NT Kernel Logger session writing events
+// selected by @flags. Kernel events are classic (MOF) events, they are
+// parsed with EventProperties as any other ones.
+//
+// The session is always named KernelLoggerName, so WithName is ignored.
+// Kernel events could be refined neither with levels nor with keywords and
+// the session can't host other providers, so AddProvider fails. Go-side
+// filters (WithChannels, WithOpcodes) work as usual.
+//
+// Like any other session the kernel one MUST be closed via `.Close` after
+// use.
+func NewKernelSession(flags KernelFlag, options ...Option) (*Session, error) {
+	return newSession(context.Background(), SystemTraceControlGUID, flags, append(options, WithName(KernelLoggerName))...)
+}
+
+// isKernel returns true if the session is the NT Kernel Logger.
+func (s *Session) isKernel() bool {
+	return s.kernelFlags != 0
+}
@@ -0,0 +1,144 @@
+//+build windows
+
+package etw
+
+import (
+	"sort"
+	"sync"
+
+	"golang.org/x/sys/windows"
+)
+
+// Keyword labels are registered per provider and shared by all sessions, the
+// same way providers define keywords once for all their consumers.
+//
+//nolint:gochecknoglobals
+var keywordLabels sync.Map // windows.GUID -> []keywordLabel
+
+// keywordLabel is a label of events having all @mask bits set.
+type keywordLabel struct {
+	mask  uint64
+	label string
+}
+
+// RegisterKeywords sets labels of keyword bits of the provider identified by
+// @providerGUID replacing previously registered ones. @labels keys are
+// keyword masks, usually single bits, e.g. 0x10 for the 5th keyword bit.
+// Nil @labels unregisters the provider labels.
+//
+// Keyword labels of a manifest provider could be taken from the manifest
+// package: manifest.Provider.Keywords fits @labels as is.
+func RegisterKeywords(providerGUID windows.GUID, labels map[uint64]string) {
+	if labels == nil {
+		keywordLabels.Delete(providerGUID)
+		return
+	}
+	sorted := make([]keywordLabel, 0, len(labels))
+	for mask, label := range labels {
+		if mask != 0 {
+			sorted = append(sorted, keywordLabel{mask: mask, label: label})
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].mask < sorted[j].mask
+	})
+	keywordLabels.Store(providerGUID, sorted)
+}
+
+// Keywords returns labels of the event keyword bits registered for the event
+// provider with RegisterKeywords ordered by their masks. Bits having no
+// label are omitted, so nil is returned if the provider labels are unknown.
+func (h EventHeader) Keywords() []string {
+	labels, ok := keywordLabels.Load(h.ProviderID)
+	if !ok || h.Keyword == 0 {
+		return nil
+	}
+	var keywords []string
+	for _, l := range labels.([]keywordLabel) {
+		if h.Keyword&l.mask == l.mask {
+			keywords = append(keywords, l.label)
+		}
+	}
+	return keywords
+}
+
+// Subscription is an interest of a single consumer in the provider events:
+// the level and keywords it would enable the provider with on its own.
+//
+// A session enables a provider only once, so consumers sharing the session
+// (e.g. tenants of a single collector) are served by the merged subscription
+// (see MergeSubscriptions), and every consumer narrows the received events
+// to its own subscription on the Go side with `.Matches` or `.Filter`.
+type Subscription struct {
+	// Level is the maximum level of events. Zero Level means all levels.
+	Level TraceLevel
+
+	// MatchAnyKeyword and MatchAllKeyword are the keyword masks the way
+	// SessionOptions define them. Zero MatchAnyKeyword means all keywords.
+	MatchAnyKeyword uint64
+	MatchAllKeyword uint64
+}
+
+// MergeSubscriptions returns the least restrictive subscription receiving
+// all events any of @subs receives: the highest level and ORed
+// MatchAnyKeyword masks. MatchAllKeyword keeps only the bits required by
+// every subscription. The result could receive more events than all @subs
+// together, so narrow them with Subscription.Matches.
+//
+// No @subs result in the zero Subscription that receives all events.
+func MergeSubscriptions(subs ...Subscription) Subscription {
+	if len(subs) == 0 {
+		return Subscription{}
+	}
+	merged := subs[0]
+	for _, s := range subs[1:] {
+		if merged.Level != 0 && (s.Level == 0 || s.Level > merged.Level) {
+			merged.Level = s.Level
+		}
+		if merged.MatchAnyKeyword != 0 && s.MatchAnyKeyword != 0 {
+			merged.MatchAnyKeyword |= s.MatchAnyKeyword
+			merged.MatchAllKeyword &= s.MatchAllKeyword
+		} else {
+			merged.MatchAnyKeyword, merged.MatchAllKeyword = 0, 0
+		}
+	}
+	if merged.MatchAnyKeyword == 0 {
+		merged.MatchAllKeyword = 0 // Not used by ETW with no MatchAnyKeyword.
+	}
+	return merged
+}
+
+// Matches returns true if the event with the header @h would be delivered
+// to a session enabled the provider with the subscription. It follows ETW
+// rules: events with zero level or zero keyword pass the corresponding
+// check.
+func (s Subscription) Matches(h *EventHeader) bool {
+	if s.Level != 0 && h.Level != 0 && TraceLevel(h.Level) > s.Level {
+		return false
+	}
+	if s.MatchAnyKeyword == 0 || h.Keyword == 0 {
+		return true
+	}
+	return h.Keyword&s.MatchAnyKeyword != 0 && h.Keyword&s.MatchAllKeyword == s.MatchAllKeyword
+}
+
+// Filter returns an EventCallback passing to @cb only events of the
+// subscription provider matching it. @providerGUID identifies the provider,
+// events of other providers are passed as is.
+func (s Subscription) Filter(providerGUID windows.GUID, cb EventCallback) EventCallback {
+	return func(e *Event) {
+		if e.Header.ProviderID != providerGUID || s.Matches(&e.Header) {
+			cb(e)
+		}
+	}
+}
+
+// Option returns an Option enabling the provider with the subscription
+// level and keywords, e.g. to pass the merged subscription to AddProvider.
+func (s Subscription) Option() Option {
+	return func(cfg *SessionOptions) {
+		cfg.Level = s.Level
+		cfg.MatchAnyKeyword = s.MatchAnyKeyword
+		cfg.MatchAllKeyword = s.MatchAllKeyword
+	}
+}
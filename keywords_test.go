@@ -0,0 +1,71 @@
+// +build windows
+
+package etw_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw"
+)
+
+func TestKeywords(t *testing.T) {
+	provider := windows.GUID{Data1: 0x2}
+	var h etw.EventHeader
+	h.ProviderID = provider
+	h.Keyword = 0x8000000000000013
+	assert.Nil(t, h.Keywords(), "Got labels of unknown provider")
+
+	etw.RegisterKeywords(provider, map[uint64]string{
+		0x01: "Process",
+		0x02: "Thread",
+		0x03: "ProcessAndThread",
+		0x04: "Image",
+		0x10: "Network",
+	})
+	defer etw.RegisterKeywords(provider, nil)
+	assert.Equal(t, []string{"Process", "Thread", "ProcessAndThread", "Network"}, h.Keywords())
+
+	h.Keyword = 0
+	assert.Nil(t, h.Keywords())
+}
+
+func TestMergeSubscriptions(t *testing.T) {
+	network := etw.Subscription{Level: etw.TRACE_LEVEL_WARNING, MatchAnyKeyword: 0x10 | 0x20, MatchAllKeyword: 0x20}
+	process := etw.Subscription{Level: etw.TRACE_LEVEL_INFORMATION, MatchAnyKeyword: 0x01 | 0x20, MatchAllKeyword: 0x21}
+
+	merged := etw.MergeSubscriptions(network, process)
+	assert.Equal(t, etw.Subscription{
+		Level:           etw.TRACE_LEVEL_INFORMATION,
+		MatchAnyKeyword: 0x31,
+		MatchAllKeyword: 0x20,
+	}, merged)
+
+	all := etw.MergeSubscriptions(network, etw.Subscription{Level: etw.TRACE_LEVEL_ERROR})
+	assert.Equal(t, etw.Subscription{Level: etw.TRACE_LEVEL_WARNING}, all, "Zero keywords mean all keywords")
+	assert.Equal(t, etw.Subscription{}, etw.MergeSubscriptions(), "No subscriptions mean all events")
+
+	// Every event any subscription matches is matched by the merged one.
+	for _, h := range []etw.EventHeader{
+		{EventDescriptor: etw.EventDescriptor{Level: 3, Keyword: 0x30}},
+		{EventDescriptor: etw.EventDescriptor{Level: 4, Keyword: 0x21}},
+		{EventDescriptor: etw.EventDescriptor{Level: 4, Keyword: 0x01}},
+		{EventDescriptor: etw.EventDescriptor{Level: 5, Keyword: 0x21}},
+		{EventDescriptor: etw.EventDescriptor{Level: 0, Keyword: 0}},
+	} {
+		h := h
+		if network.Matches(&h) || process.Matches(&h) {
+			assert.True(t, merged.Matches(&h), "Merged subscription misses %+v", h.EventDescriptor)
+		}
+	}
+
+	h := etw.EventHeader{EventDescriptor: etw.EventDescriptor{Level: 4, Keyword: 0x21}}
+	assert.False(t, network.Matches(&h), "Level is not checked")
+	assert.True(t, process.Matches(&h))
+	h.Keyword = 0x01
+	assert.False(t, process.Matches(&h), "MatchAllKeyword is not checked")
+	h.Keyword = 0
+	assert.True(t, process.Matches(&h), "Events with no keywords should match")
+}
@@ -0,0 +1,57 @@
+//+build windows
+
+package etw
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// Labels returns a copy of the session Labels set by WithLabels.
+func (s *Session) Labels() map[string]string {
+	return copyLabels(s.labels)
+}
+
+// describe returns a session description for error messages: its name
+// followed by its labels if any.
+func (s *Session) describe() string {
+	return describeSession(windows.UTF16ToString(s.etwSessionName), s.labels)
+}
+
+// describeSession formats the session @name and @labels as
+// `"name" {key=value, ...}`. Labels are ordered by keys.
+func describeSession(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return fmt.Sprintf("%q", name)
+	}
+	return fmt.Sprintf("%q {%s}", name, formatLabels(labels))
+}
+
+// formatLabels formats @labels as "key=value, ..." ordered by keys.
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + labels[k]
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// copyLabels returns a copy of @labels, nil for empty ones.
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
+}
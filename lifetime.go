@@ -0,0 +1,72 @@
+//+build windows
+
+package etw
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// EventMisuse describes a call of an Event method made after the
+// EventCallback the event was passed to had returned.
+type EventMisuse struct {
+	// Method is a name of the called Event method.
+	Method string
+
+	// Header is a header of the misused event.
+	Header EventHeader
+
+	// Labels are Labels of the session the event belongs to.
+	Labels map[string]string
+
+	// Stack is a stack trace of the goroutine made the call.
+	Stack []byte
+}
+
+// misuseHandler is a type of values stored in eventMisuseHandler, nil
+// function means no handler.
+type misuseHandler func(EventMisuse)
+
+//nolint:gochecknoglobals
+var eventMisuseHandler atomic.Value // misuseHandler
+
+// SetEventMisuseHandler enables the debug mode in which every Event method
+// called outside of EventCallback not only fails (or returns zero value) but
+// also reports the call to @handler. It helps to find where *Event leaks to
+// other goroutines. Nil @handler disables the debug mode.
+//
+// Capturing stack traces is expensive, so use it in debug builds only. The
+// handler is shared by all sessions and must be safe for concurrent use.
+func SetEventMisuseHandler(handler func(EventMisuse)) {
+	eventMisuseHandler.Store(misuseHandler(handler))
+}
+
+// LogEventMisuse is an event misuse handler writing the violating call and
+// its stack trace to the standard logger. Pass it to SetEventMisuseHandler.
+func LogEventMisuse(m EventMisuse) {
+	var labels string
+	if len(m.Labels) != 0 {
+		labels = ", session {" + formatLabels(m.Labels) + "}"
+	}
+	log.Printf("etw: Event.%s called outside of EventCallback (provider %s, event %d%s)\n%s",
+		m.Method, m.Header.ProviderID, m.Header.ID, labels, m.Stack)
+}
+
+// checkRecord returns an error if the event is used outside of its
+// EventCallback and reports the @method call to the misuse handler if any.
+func (e *Event) checkRecord(method string) error {
+	if e.eventRecord != nil {
+		return nil
+	}
+	if handler, _ := eventMisuseHandler.Load().(misuseHandler); handler != nil {
+		handler(EventMisuse{
+			Method: method,
+			Header: e.Header,
+			Labels: copyLabels(e.labels),
+			Stack:  debug.Stack(),
+		})
+	}
+	return fmt.Errorf("usage of Event is invalid outside of EventCallback")
+}
@@ -0,0 +1,120 @@
+//+build windows
+
+package etw
+
+/*
+	#include "session.h"
+*/
+import "C"
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// In-types TdhFormatProperty formats according to the user locale: decimal
+// separators of floats, words of booleans and date formats differ between
+// systems.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/tdh/ne-tdh-_tdh_in_type
+const (
+	tdhInTypeFloat      = 11 // TDH_INTYPE_FLOAT
+	tdhInTypeDouble     = 12 // TDH_INTYPE_DOUBLE
+	tdhInTypeBoolean    = 13 // TDH_INTYPE_BOOLEAN
+	tdhInTypeFileTime   = 17 // TDH_INTYPE_FILETIME
+	tdhInTypeSystemTime = 18 // TDH_INTYPE_SYSTEMTIME
+)
+
+// WithInvariantFormatting makes EventProperties format locale-dependent
+// values itself instead of TDH, so the output doesn't depend on the system
+// the events are parsed on:
+//
+//   - floats are formatted by strconv.FormatFloat with the '.' separator;
+//   - booleans are "true" or "false";
+//   - FILETIME and SYSTEMTIME are RFC 3339 timestamps, e.g.
+//     "2021-02-03T04:05:06.789Z". Both are assumed to be in UTC.
+//
+// Values of other types are formatted by TDH as usual, mapped values keep
+// their map names.
+func WithInvariantFormatting() ParseOption {
+	return func(cfg *ParseOptions) {
+		cfg.InvariantFormatting = true
+	}
+}
+
+// invariantSize returns a size of the @inType value formatted by
+// formatInvariant or zero if the type isn't locale-dependent.
+func invariantSize(inType uintptr) int {
+	switch inType {
+	case tdhInTypeFloat, tdhInTypeBoolean:
+		return 4
+	case tdhInTypeDouble, tdhInTypeFileTime:
+		return 8
+	case tdhInTypeSystemTime:
+		return 16
+	default:
+		return 0
+	}
+}
+
+// parseInvariant formats the @i-th property with formatInvariant at the
+// current data offset advancing it. Returns false if the property should
+// be formatted by TDH.
+func (p *propertyParser) parseInvariant(i int) (string, bool) {
+	property := &p.plan.properties[i]
+	if mapInfo, _ := p.plan.valueMap(p.record, p.info, i); len(mapInfo) != 0 {
+		return "", false
+	}
+	size := invariantSize(property.inType)
+	if size == 0 || p.data > p.endData || uintptr(size) > p.endData-p.data {
+		return "", false
+	}
+	value, ok := formatInvariant(property.inType, C.GoBytes(unsafe.Pointer(p.data), C.int(size)))
+	if !ok {
+		return "", false
+	}
+	p.data += uintptr(size)
+	return value, true
+}
+
+// formatInvariant formats the locale-dependent @inType value @data in the
+// invariant way. Returns false for invalid timestamps.
+func formatInvariant(inType uintptr, data []byte) (string, bool) {
+	switch inType {
+	case tdhInTypeFloat:
+		v := math.Float32frombits(binary.LittleEndian.Uint32(data))
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), true
+	case tdhInTypeDouble:
+		v := math.Float64frombits(binary.LittleEndian.Uint64(data))
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case tdhInTypeBoolean:
+		return strconv.FormatBool(binary.LittleEndian.Uint32(data) != 0), true
+	case tdhInTypeFileTime:
+		ft := int64(binary.LittleEndian.Uint64(data))
+		if ft < 0 {
+			return "", false
+		}
+		// FILETIME is in 100ns intervals since 1601, time.Duration can't
+		// hold the whole range, so split it to seconds.
+		const (
+			intervalsPerSecond = 10000000
+			unixEpochDelta     = 11644473600 // Seconds from 1601 to 1970.
+		)
+		t := time.Unix(ft/intervalsPerSecond-unixEpochDelta, ft%intervalsPerSecond*100)
+		return t.UTC().Format(time.RFC3339Nano), true
+	case tdhInTypeSystemTime:
+		field := func(i int) int { return int(binary.LittleEndian.Uint16(data[2*i:])) }
+		// wYear, wMonth, wDayOfWeek, wDay, wHour, wMinute, wSecond, wMilliseconds.
+		year, month, day := field(0), field(1), field(3)
+		hour, minute, second, ms := field(4), field(5), field(6), field(7)
+		if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || minute > 59 || second > 59 || ms > 999 {
+			return "", false
+		}
+		t := time.Date(year, time.Month(month), day, hour, minute, second, ms*int(time.Millisecond), time.UTC)
+		return t.Format(time.RFC3339Nano), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,97 @@
+//+build windows
+
+package etw
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LogmanCommands renders the session configuration as `logman` command
+// lines creating an equivalent session: `logman create trace` for the
+// session itself and the primary provider followed by `logman update trace`
+// for every other enabled provider. It eases migration between sessions
+// managed by scripts and ones managed by the code.
+//
+// logman can't express everything the package does, so the following is
+// omitted from the output: MatchAllKeyword, EnableProperties, source
+// GUIDs, Go-side filters (channels and opcodes), secure and independent
+// session modes, kernel stack walk and all the consumer options. Paused
+// providers are omitted as well. Rotated log files are numbered by logman
+// instead of being timestamped. logman takes the flush timer in whole
+// seconds, so FlushTimer is rounded up to a second.
+func (s *Session) LogmanCommands() []string {
+	return logmanCommands(s.Options(), s.kernelFlags, s.EnableState())
+}
+
+// logmanCommands implements LogmanCommands for the session configured with
+// @cfg, @kernelFlags and @state.
+func logmanCommands(cfg SessionOptions, kernelFlags KernelFlag, state EnableState) []string {
+	name := logmanQuote(cfg.Name)
+	providers := make([]ProviderState, 0, len(state.Providers))
+	for _, p := range state.Providers {
+		if !p.Paused {
+			providers = append(providers, p)
+		}
+	}
+	if len(providers) > 1 {
+		// Keep the primary provider first and make the output stable.
+		others := providers[1:]
+		sort.Slice(others, func(i, j int) bool {
+			return others[i].GUID.String() < others[j].GUID.String()
+		})
+	}
+
+	create := []string{"logman", "create", "trace", name}
+	switch {
+	case kernelFlags != 0:
+		create = append(create, "-p", logmanQuote("Windows Kernel Trace"), fmt.Sprintf("0x%x", uint32(kernelFlags)))
+	case len(providers) != 0:
+		create = append(create, logmanProvider(providers[0])...)
+		providers = providers[1:]
+	}
+	create = append(create, "-rt")
+	if cfg.LogFileName != "" {
+		create = append(create, "-o", logmanQuote(cfg.LogFileName))
+		if cfg.LogFileMaxSize != 0 {
+			create = append(create, "-mode", "newfile", "-max", fmt.Sprint(cfg.LogFileMaxSize))
+		}
+	}
+	if cfg.BufferSize != 0 {
+		create = append(create, "-bs", fmt.Sprint(cfg.BufferSize))
+	}
+	if cfg.MinimumBuffers != 0 || cfg.MaximumBuffers != 0 {
+		create = append(create, "-nb", fmt.Sprint(cfg.MinimumBuffers), fmt.Sprint(cfg.MaximumBuffers))
+	}
+	if cfg.FlushTimer != 0 {
+		seconds := (cfg.FlushTimer + time.Second - 1) / time.Second
+		create = append(create, "-ft", fmt.Sprint(int64(seconds)))
+	}
+	create = append(create, "-ets")
+
+	commands := []string{strings.Join(create, " ")}
+	if kernelFlags != 0 {
+		return commands
+	}
+	for _, p := range providers {
+		update := append([]string{"logman", "update", "trace", name}, logmanProvider(p)...)
+		commands = append(commands, strings.Join(append(update, "-ets"), " "))
+	}
+	return commands
+}
+
+// logmanProvider returns `-p` arguments enabling the provider @p.
+func logmanProvider(p ProviderState) []string {
+	keywords := p.MatchAnyKeyword
+	if keywords == 0 {
+		keywords = ^uint64(0) // Zero means all keywords for EnableTraceEx2.
+	}
+	return []string{"-p", logmanQuote(p.GUID.String()), fmt.Sprintf("0x%x", keywords), fmt.Sprintf("0x%x", uint8(p.Level))}
+}
+
+// logmanQuote quotes @s as a single command line argument.
+func logmanQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
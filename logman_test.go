@@ -0,0 +1,39 @@
+// +build windows
+
+package etw
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/windows"
+)
+
+func TestLogmanCommands(t *testing.T) {
+	cfg := SessionOptions{
+		Name:           "my session",
+		LogFileName:    `C:\traces\trace.etl`,
+		LogFileMaxSize: 100,
+		BufferSize:     64,
+		MinimumBuffers: 8,
+		MaximumBuffers: 32,
+		FlushTimer:     1500 * time.Millisecond,
+	}
+	state := EnableState{Providers: []ProviderState{
+		{GUID: windows.GUID{Data1: 0x3}, Level: TRACE_LEVEL_INFORMATION, MatchAnyKeyword: 0x10},
+		{GUID: windows.GUID{Data1: 0x2}, Level: TRACE_LEVEL_VERBOSE},
+		{GUID: windows.GUID{Data1: 0x1}, Level: TRACE_LEVEL_ERROR, Paused: true},
+	}}
+	assert.Equal(t, []string{
+		`logman create trace "my session" -p "{00000003-0000-0000-0000-000000000000}" 0x10 0x4` +
+			` -rt -o "C:\traces\trace.etl" -mode newfile -max 100 -bs 64 -nb 8 32 -ft 2 -ets`,
+		`logman update trace "my session" -p "{00000002-0000-0000-0000-000000000000}" 0xffffffffffffffff 0x5 -ets`,
+	}, logmanCommands(cfg, 0, state))
+
+	kernelCfg := SessionOptions{Name: KernelLoggerName}
+	kernelState := EnableState{Providers: []ProviderState{{GUID: SystemTraceControlGUID}}}
+	assert.Equal(t, []string{
+		`logman create trace "NT Kernel Logger" -p "Windows Kernel Trace" 0x5 -rt -ets`,
+	}, logmanCommands(kernelCfg, EVENT_TRACE_FLAG_PROCESS|EVENT_TRACE_FLAG_IMAGE_LOAD, kernelState))
+}
@@ -0,0 +1,386 @@
+package manifest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+var (
+	// ErrUnknownEvent is returned (wrapped) by Decode if the manifest
+	// doesn't describe the event.
+	ErrUnknownEvent = errors.New("unknown event")
+
+	// ErrMalformedData is returned (wrapped) by Decode if the payload
+	// doesn't match the event template.
+	ErrMalformedData = errors.New("malformed event data")
+)
+
+// DecodeOptions describes how event payloads are decoded.
+type DecodeOptions struct {
+	// PointerSize is a size of pointer values in bytes: 4 for events of 32-bit
+	// processes, 8 (the default) for 64-bit ones.
+	PointerSize int
+}
+
+// DecodeOption is any function that modifies DecodeOptions.
+type DecodeOption func(cfg *DecodeOptions)
+
+// WithPointerSize sets the size of pointer values.
+func WithPointerSize(size int) DecodeOption {
+	return func(cfg *DecodeOptions) {
+		cfg.PointerSize = size
+	}
+}
+
+// Decode decodes @data payload of the event @id of @version emitted by the
+// provider @guid.
+func (m *Manifest) Decode(guid string, id uint16, version uint8, data []byte, options ...DecodeOption) (map[string]interface{}, error) {
+	p := m.Provider(guid)
+	if p == nil {
+		return nil, fmt.Errorf("%w: provider %s is not described", ErrUnknownEvent, guid)
+	}
+	e := p.Event(id, version)
+	if e == nil {
+		return nil, fmt.Errorf("%w: event %d version %d of %s is not described", ErrUnknownEvent, id, version, p.Name)
+	}
+	return p.Decode(e, data, options...)
+}
+
+// Decode decodes @data payload of the event @e of the provider.
+func (p *Provider) Decode(e *Event, data []byte, options ...DecodeOption) (map[string]interface{}, error) {
+	cfg := DecodeOptions{PointerSize: 8}
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	if cfg.PointerSize != 4 && cfg.PointerSize != 8 {
+		return nil, fmt.Errorf("unsupported pointer size %d", cfg.PointerSize)
+	}
+	if e.Template == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	d := decoder{provider: p, data: data, ptrSize: cfg.PointerSize}
+	return d.decodeFields(e.Template.Fields)
+}
+
+type decoder struct {
+	provider *Provider
+	data     []byte
+	ptrSize  int
+}
+
+func (d *decoder) decodeFields(fields []*Field) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		count, isArray, err := d.resolveSize(f.Count, values)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %q count; %w", f.Name, err)
+		}
+		if !isArray {
+			if values[f.Name], err = d.decodeField(f, values); err != nil {
+				return nil, fmt.Errorf("failed to decode %q; %w", f.Name, err)
+			}
+			continue
+		}
+
+		// Don't trust counts from the payload: every element takes at least
+		// a byte.
+		if count > len(d.data) {
+			return nil, fmt.Errorf("%w: %q count %d exceeds remaining %d bytes",
+				ErrMalformedData, f.Name, count, len(d.data))
+		}
+		arr := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			v, err := d.decodeField(f, values)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode %q[%d]; %w", f.Name, i, err)
+			}
+			arr = append(arr, v)
+		}
+		values[f.Name] = arr
+	}
+	return values, nil
+}
+
+func (d *decoder) decodeField(f *Field, values map[string]interface{}) (interface{}, error) {
+	if f.Fields != nil {
+		return d.decodeFields(f.Fields)
+	}
+	length, hasLength, err := d.resolveSize(f.Length, values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get length; %w", err)
+	}
+	return d.decodeValue(f, length, hasLength)
+}
+
+// resolveSize resolves Count or Length attribute @ref: a number or a name of
+// a previous field. Returns false if @ref is empty.
+func (d *decoder) resolveSize(ref string, values map[string]interface{}) (int, bool, error) {
+	if ref == "" {
+		return 0, false, nil
+	}
+	if n, err := strconv.ParseUint(ref, 0, 16); err == nil {
+		return int(n), true, nil
+	}
+	v, ok := values[ref]
+	if !ok {
+		return 0, false, fmt.Errorf("no field %q", ref)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return 0, false, fmt.Errorf("field %q is not a scalar", ref)
+	}
+	n, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, false, fmt.Errorf("field %q is not a number; %w", ref, err)
+	}
+	return int(n), true, nil
+}
+
+func (d *decoder) take(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data) {
+		return nil, fmt.Errorf("%w: need %d bytes, %d remaining", ErrMalformedData, n, len(d.data))
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+// fixedSize returns a size of fixed-size types or 0 for variable-size ones.
+func (d *decoder) fixedSize(f *Field) int {
+	switch f.InType {
+	case "win:Int8", "win:UInt8":
+		return 1
+	case "win:Int16", "win:UInt16":
+		return 2
+	case "win:Int32", "win:UInt32", "win:HexInt32", "win:Float", "win:Boolean":
+		return 4
+	case "win:Int64", "win:UInt64", "win:HexInt64", "win:Double", "win:FILETIME":
+		return 8
+	case "win:GUID", "win:SYSTEMTIME":
+		return 16
+	case "win:Pointer":
+		return d.ptrSize
+	}
+	return 0
+}
+
+func (d *decoder) decodeValue(f *Field, length int, hasLength bool) (interface{}, error) {
+	switch f.InType {
+	case "win:UnicodeString":
+		return d.unicodeString(length, hasLength)
+	case "win:AnsiString":
+		return d.ansiString(length, hasLength)
+	case "win:CountedUnicodeString", "win:CountedAnsiString":
+		b, err := d.take(2)
+		if err != nil {
+			return nil, err
+		}
+		n := int(binary.LittleEndian.Uint16(b))
+		if f.InType == "win:CountedUnicodeString" {
+			return d.unicodeString(n/2, true)
+		}
+		return d.ansiString(n, true)
+	case "win:Binary":
+		b, err := d.take(length)
+		if err != nil {
+			return nil, err
+		}
+		return "0x" + strings.ToUpper(fmt.Sprintf("%x", b)), nil
+	case "win:SID":
+		return d.sid()
+	}
+
+	size := d.fixedSize(f)
+	if size == 0 {
+		return nil, fmt.Errorf("unsupported inType %q", f.InType)
+	}
+	b, err := d.take(size)
+	if err != nil {
+		return nil, err
+	}
+
+	var u uint64
+	switch size {
+	case 1:
+		u = uint64(b[0])
+	case 2:
+		u = uint64(binary.LittleEndian.Uint16(b))
+	case 4:
+		u = uint64(binary.LittleEndian.Uint32(b))
+	case 8:
+		u = binary.LittleEndian.Uint64(b)
+	}
+
+	switch f.InType {
+	case "win:Int8":
+		return strconv.FormatInt(int64(int8(u)), 10), nil
+	case "win:Int16":
+		return strconv.FormatInt(int64(int16(u)), 10), nil
+	case "win:Int32":
+		return strconv.FormatInt(int64(int32(u)), 10), nil
+	case "win:Int64":
+		return strconv.FormatInt(int64(u), 10), nil
+	case "win:Float":
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(u))), 'g', -1, 32), nil
+	case "win:Double":
+		return strconv.FormatFloat(math.Float64frombits(u), 'g', -1, 64), nil
+	case "win:Boolean":
+		return strconv.FormatBool(u != 0), nil
+	case "win:HexInt32", "win:HexInt64", "win:Pointer":
+		return fmt.Sprintf("0x%X", u), nil
+	case "win:FILETIME":
+		return filetimeToString(int64(u)), nil
+	case "win:GUID":
+		return guidToString(b), nil
+	case "win:SYSTEMTIME":
+		return systemtimeToString(b), nil
+	}
+
+	// Unsigned integers.
+	if f.Map != "" {
+		if vm, ok := d.provider.maps[f.Map]; ok {
+			if s, ok := vm.render(u); ok {
+				return s, nil
+			}
+		}
+	}
+	switch f.OutType {
+	case "win:HexInt8", "win:HexInt16", "win:HexInt32", "win:HexInt64",
+		"win:Win32Error", "win:NTSTATUS", "win:HResult":
+		return fmt.Sprintf("0x%X", u), nil
+	}
+	return strconv.FormatUint(u, 10), nil
+}
+
+// unicodeString decodes UTF-16 string of @length characters or a
+// null-terminated one if @hasLength is false.
+func (d *decoder) unicodeString(length int, hasLength bool) (string, error) {
+	if !hasLength {
+		length = -1
+		for i := 0; i+1 < len(d.data); i += 2 {
+			if d.data[i] == 0 && d.data[i+1] == 0 {
+				length = i / 2
+				break
+			}
+		}
+		if length < 0 {
+			return "", fmt.Errorf("%w: unterminated string", ErrMalformedData)
+		}
+	}
+	b, err := d.take(length * 2)
+	if err != nil {
+		return "", err
+	}
+	if !hasLength {
+		d.data = d.data[2:] // Skip the terminator.
+	}
+	chars := make([]uint16, length)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return strings.TrimRight(string(utf16.Decode(chars)), "\x00"), nil
+}
+
+// ansiString decodes a string of @length bytes or a null-terminated one if
+// @hasLength is false.
+func (d *decoder) ansiString(length int, hasLength bool) (string, error) {
+	if !hasLength {
+		length = -1
+		for i, c := range d.data {
+			if c == 0 {
+				length = i
+				break
+			}
+		}
+		if length < 0 {
+			return "", fmt.Errorf("%w: unterminated string", ErrMalformedData)
+		}
+	}
+	b, err := d.take(length)
+	if err != nil {
+		return "", err
+	}
+	if !hasLength {
+		d.data = d.data[1:] // Skip the terminator.
+	}
+	return strings.TrimRight(string(b), "\x00"), nil
+}
+
+// sid decodes a security identifier to its string form (S-1-5-...).
+func (d *decoder) sid() (string, error) {
+	if len(d.data) < 8 {
+		return "", fmt.Errorf("%w: truncated SID", ErrMalformedData)
+	}
+	subAuthorities := int(d.data[1])
+	b, err := d.take(8 + 4*subAuthorities)
+	if err != nil {
+		return "", err
+	}
+	var authority uint64
+	for _, c := range b[2:8] {
+		authority = authority<<8 | uint64(c)
+	}
+	s := fmt.Sprintf("S-%d-%d", b[0], authority)
+	for i := 0; i < subAuthorities; i++ {
+		s += fmt.Sprintf("-%d", binary.LittleEndian.Uint32(b[8+4*i:]))
+	}
+	return s, nil
+}
+
+// render returns a message of the value map entry or a list of bitmap
+// entries messages separated with `|`.
+func (vm *valueMap) render(v uint64) (string, bool) {
+	if !vm.isBitMap {
+		for _, e := range vm.entries {
+			if e.value == v {
+				return e.message, true
+			}
+		}
+		return "", false
+	}
+	var parts []string
+	for _, e := range vm.entries {
+		if e.value != 0 && v&e.value == e.value {
+			parts = append(parts, e.message)
+		}
+	}
+	if len(parts) == 0 {
+		return "", false
+	}
+	return strings.Join(parts, "|"), true
+}
+
+// filetimeToString renders FILETIME (100-nanosecond intervals since
+// January 1, 1601 UTC).
+func filetimeToString(ft int64) string {
+	const epochDiff = 116444736000000000 // Between 1601 and 1970 in 100ns.
+	return time.Unix(0, (ft-epochDiff)*100).UTC().Format(time.RFC3339Nano)
+}
+
+// systemtimeToString renders SYSTEMTIME structure.
+func systemtimeToString(b []byte) string {
+	field := func(i int) int {
+		return int(binary.LittleEndian.Uint16(b[i*2:]))
+	}
+	// Fields: year, month, day of week, day, hour, minute, second, millisecond.
+	t := time.Date(field(0), time.Month(field(1)), field(3), field(4), field(5), field(6), field(7)*int(time.Millisecond), time.UTC)
+	return t.Format(time.RFC3339Nano)
+}
+
+// guidToString renders GUID in the registry format.
+func guidToString(b []byte) string {
+	return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}",
+		binary.LittleEndian.Uint32(b[0:4]),
+		binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]),
+		b[8:10],
+		b[10:16],
+	)
+}
@@ -0,0 +1,23 @@
+//+build windows
+
+package manifest
+
+import (
+	"github.com/bi-zone/etw"
+)
+
+// EventProperties decodes properties of @e using the manifest instead of TDH.
+// Like etw.Event.EventProperties it's valid only inside etw.EventCallback.
+func (m *Manifest) EventProperties(e *etw.Event) (map[string]interface{}, error) {
+	data, err := e.UserData()
+	if err != nil {
+		return nil, err
+	}
+	return m.Decode(
+		e.Header.ProviderID.String(),
+		e.Header.ID,
+		e.Header.Version,
+		data,
+		WithPointerSize(e.Header.PointerSize()),
+	)
+}
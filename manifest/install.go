@@ -0,0 +1,93 @@
+//+build windows
+
+package manifest
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// publishersKey is a registry key Windows keeps installed providers under.
+// TDH reads schemas of manifest providers from resource files listed
+// there.
+const publishersKey = `SOFTWARE\Microsoft\Windows\CurrentVersion\WINEVT\Publishers\`
+
+// InstallOptions describes where the binaries of the manifest provider are.
+//
+// ResourceFile is a binary (.exe or .dll) holding the compiled manifest as
+// the WEVT_TEMPLATE resource, it's required to decode events with TDH.
+// MessageFile and ParameterFile hold localized strings, ResourceFile is used
+// if MessageFile is empty. Relative paths are resolved against the current
+// directory.
+type InstallOptions struct {
+	ResourceFile  string
+	MessageFile   string
+	ParameterFile string
+}
+
+// Install registers providers of the manifest at @path the way
+// `wevtutil im` does, so TDH could decode their events. It's meant for test
+// environments that need schemas of custom providers registered
+// programmatically.
+//
+// Windows has no public API to install manifests, so Install runs
+// wevtutil.exe. Administrative rights are required.
+func Install(path string, opts InstallOptions) error {
+	if opts.ResourceFile == "" {
+		return fmt.Errorf("resource file is required")
+	}
+	if opts.MessageFile == "" {
+		opts.MessageFile = opts.ResourceFile
+	}
+	args := []string{"im", path}
+	for _, file := range []struct{ flag, path string }{
+		{"rf", opts.ResourceFile},
+		{"mf", opts.MessageFile},
+		{"pf", opts.ParameterFile},
+	} {
+		if file.path == "" {
+			continue
+		}
+		abs, err := filepath.Abs(file.path)
+		if err != nil {
+			return fmt.Errorf("invalid %s path; %w", file.flag, err)
+		}
+		args = append(args, fmt.Sprintf("/%s:%s", file.flag, abs))
+	}
+	return wevtutil(args...)
+}
+
+// Uninstall unregisters providers of the manifest at @path the way
+// `wevtutil um` does. Administrative rights are required.
+func Uninstall(path string) error {
+	return wevtutil("um", path)
+}
+
+// IsInstalled returns true if the provider identified by @guid (with or
+// without braces) is registered in the system, i.e. TDH knows its schema.
+func IsInstalled(guid string) (bool, error) {
+	name := "{" + strings.ToUpper(normalizeGUID(guid)) + "}"
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, publishersKey+name, registry.QUERY_VALUE)
+	switch err {
+	case nil:
+		key.Close()
+		return true, nil
+	case registry.ErrNotExist:
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to open publisher key; %w", err)
+	}
+}
+
+// wevtutil runs wevtutil.exe with @args.
+func wevtutil(args ...string) error {
+	out, err := exec.Command("wevtutil.exe", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("wevtutil %s failed: %s; %w", args[0], strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
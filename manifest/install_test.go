@@ -0,0 +1,62 @@
+// +build windows
+
+package manifest_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw/manifest"
+)
+
+// kernelProcessProvider is Microsoft-Windows-Kernel-Process, a manifest
+// provider registered on every system.
+const kernelProcessProvider = "22fb2cd6-0e7b-422b-a0c7-2fad1fd0e716"
+
+func TestIsInstalled(t *testing.T) {
+	installed, err := manifest.IsInstalled(kernelProcessProvider)
+	require.NoError(t, err, "Failed to check system provider")
+	assert.True(t, installed, "System provider is not found")
+
+	installed, err = manifest.IsInstalled("{" + kernelProcessProvider + "}")
+	require.NoError(t, err, "Failed to check system provider in braces")
+	assert.True(t, installed, "System provider in braces is not found")
+
+	installed, err = manifest.IsInstalled("{6c1e4f0a-93b2-4d7e-8a15-0f2c9b3d7e61}")
+	require.NoError(t, err, "Failed to check random provider")
+	assert.False(t, installed, "Random provider is found")
+}
+
+func TestInstall(t *testing.T) {
+	admins, err := windows.CreateWellKnownSid(windows.WinBuiltinAdministratorsSid)
+	require.NoError(t, err, "Failed to create Administrators SID")
+	// IsMember needs an impersonation token, nil is the calling thread one.
+	if isAdmin, err := windows.Token(0).IsMember(admins); err != nil || !isAdmin {
+		t.Skip("Administrative rights are required")
+	}
+	if installed, _ := manifest.IsInstalled(sampleProvider); installed {
+		t.Skip("Sample provider is already installed")
+	}
+
+	// Resources aren't loaded on install, any binary fits to register the
+	// provider.
+	self, err := os.Executable()
+	require.NoError(t, err, "Failed to get test binary path")
+	require.NoError(t, manifest.Install("testdata/sample.man", manifest.InstallOptions{ResourceFile: self}),
+		"Failed to install manifest")
+	installed, err := manifest.IsInstalled(sampleProvider)
+	assert.NoError(t, err, "Failed to check installed provider")
+	assert.True(t, installed, "Installed provider is not found")
+
+	require.NoError(t, manifest.Uninstall("testdata/sample.man"), "Failed to uninstall manifest")
+	installed, err = manifest.IsInstalled(sampleProvider)
+	require.NoError(t, err, "Failed to check uninstalled provider")
+	assert.False(t, installed, "Uninstalled provider is still found")
+
+	err = manifest.Install("testdata/sample.man", manifest.InstallOptions{})
+	assert.Error(t, err, "Install without resource file succeeded")
+}
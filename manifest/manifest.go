@@ -0,0 +1,359 @@
+// Package manifest parses ETW instrumentation manifests (.man files) and
+// decodes event payloads using them as a schema source.
+//
+// It allows to decode events on machines where the provider isn't installed
+// and TDH knows nothing about its events, e.g. on centralized analysis
+// servers. The package is pure Go and works on any platform:
+//
+//		m, err := manifest.ParseFile("provider.man")
+//		...
+//		props, err := m.Decode(providerGUID, eventID, eventVersion, payload)
+//
+// Decoded values are rendered to strings the way TDH renders them (with a
+// few exceptions, e.g. timestamps are formatted as RFC 3339), so the result
+// has the same shape as etw.Event.EventProperties one: strings for simple
+// values, []interface{} for arrays and map[string]interface{} for structs.
+package manifest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Manifest is a parsed instrumentation manifest.
+type Manifest struct {
+	Providers []*Provider
+
+	// strings holds the localized string table (of the first culture).
+	strings map[string]string
+}
+
+// Provider describes a single provider of the manifest.
+type Provider struct {
+	Name   string
+	GUID   string // Normalized: lower case without braces.
+	Symbol string
+
+	Events    []*Event
+	Tasks     map[uint16]string
+	Opcodes   map[uint8]string
+	Keywords  map[uint64]string
+	Templates map[string]*Template
+
+	maps map[string]*valueMap
+}
+
+// Event describes a single event of the provider.
+type Event struct {
+	ID       uint16
+	Version  uint8
+	Level    string
+	Task     string
+	Opcode   string
+	Keywords []string
+	Symbol   string
+	Message  string
+
+	// Template describes the event payload. Nil for events without payload.
+	Template *Template
+}
+
+// Template describes an event payload layout.
+type Template struct {
+	ID     string
+	Fields []*Field
+}
+
+// Field is a single payload field.
+type Field struct {
+	Name    string
+	InType  string
+	OutType string
+
+	// Count and Length are either numbers or names of previous fields
+	// holding the value. Empty Count means a scalar field, empty Length --
+	// the field length is defined by its type.
+	Count  string
+	Length string
+
+	// Map is a name of the value map or bitmap used to render values.
+	Map string
+
+	// Fields are set for structures.
+	Fields []*Field
+}
+
+type valueMap struct {
+	isBitMap bool
+	entries  []mapEntry
+}
+
+type mapEntry struct {
+	value   uint64
+	message string
+}
+
+// ParseFile parses a manifest from the file at @path.
+func ParseFile(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest; %w", err)
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse parses a manifest from @r.
+func Parse(r io.Reader) (*Manifest, error) {
+	var raw xmlManifest
+	if err := xml.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest XML; %w", err)
+	}
+
+	m := &Manifest{strings: make(map[string]string)}
+	if len(raw.Resources) > 0 {
+		for _, s := range raw.Resources[0].Strings {
+			m.strings[s.ID] = s.Value
+		}
+	}
+	for _, rp := range raw.Providers {
+		p, err := m.convertProvider(rp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid provider %q; %w", rp.Name, err)
+		}
+		m.Providers = append(m.Providers, p)
+	}
+	return m, nil
+}
+
+// Provider returns a provider by its @guid (braces and case are ignored) or
+// nil if the manifest doesn't describe it.
+func (m *Manifest) Provider(guid string) *Provider {
+	guid = normalizeGUID(guid)
+	for _, p := range m.Providers {
+		if p.GUID == guid {
+			return p
+		}
+	}
+	return nil
+}
+
+// Event returns an event by its @id and @version or nil if the provider
+// doesn't describe it.
+func (p *Provider) Event(id uint16, version uint8) *Event {
+	for _, e := range p.Events {
+		if e.ID == id && e.Version == version {
+			return e
+		}
+	}
+	return nil
+}
+
+// resolve substitutes `$(string.id)` references with localized strings.
+func (m *Manifest) resolve(s string) string {
+	if strings.HasPrefix(s, "$(string.") && strings.HasSuffix(s, ")") {
+		if v, ok := m.strings[s[len("$(string."):len(s)-1]]; ok {
+			return v
+		}
+	}
+	return s
+}
+
+func (m *Manifest) convertProvider(rp xmlProvider) (*Provider, error) {
+	p := &Provider{
+		Name:      rp.Name,
+		GUID:      normalizeGUID(rp.GUID),
+		Symbol:    rp.Symbol,
+		Tasks:     make(map[uint16]string),
+		Opcodes:   make(map[uint8]string),
+		Keywords:  make(map[uint64]string),
+		Templates: make(map[string]*Template),
+		maps:      make(map[string]*valueMap),
+	}
+	for _, t := range rp.Tasks {
+		v, err := strconv.ParseUint(t.Value, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid task %q value; %w", t.Name, err)
+		}
+		p.Tasks[uint16(v)] = t.Name
+	}
+	for _, o := range rp.Opcodes {
+		v, err := strconv.ParseUint(o.Value, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid opcode %q value; %w", o.Name, err)
+		}
+		p.Opcodes[uint8(v)] = o.Name
+	}
+	for _, k := range rp.Keywords {
+		v, err := strconv.ParseUint(k.Mask, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyword %q mask; %w", k.Name, err)
+		}
+		p.Keywords[v] = k.Name
+	}
+	for _, rm := range append(rp.ValueMaps, rp.BitMaps...) {
+		vm := &valueMap{isBitMap: rm.XMLName.Local == "bitMap"}
+		for _, e := range rm.Entries {
+			v, err := strconv.ParseUint(e.Value, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid map %q entry; %w", rm.Name, err)
+			}
+			vm.entries = append(vm.entries, mapEntry{value: v, message: m.resolve(e.Message)})
+		}
+		p.maps[rm.Name] = vm
+	}
+	for _, rt := range rp.Templates {
+		fields, err := convertFields(rt.Fields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template %q; %w", rt.ID, err)
+		}
+		p.Templates[rt.ID] = &Template{ID: rt.ID, Fields: fields}
+	}
+	for _, re := range rp.Events {
+		id, err := strconv.ParseUint(re.Value, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event %q value; %w", re.Value, err)
+		}
+		var version uint64
+		if re.Version != "" {
+			if version, err = strconv.ParseUint(re.Version, 0, 8); err != nil {
+				return nil, fmt.Errorf("invalid event %d version; %w", id, err)
+			}
+		}
+		e := &Event{
+			ID:       uint16(id),
+			Version:  uint8(version),
+			Level:    re.Level,
+			Task:     re.Task,
+			Opcode:   re.Opcode,
+			Keywords: strings.Fields(re.Keywords),
+			Symbol:   re.Symbol,
+			Message:  m.resolve(re.Message),
+		}
+		if re.Template != "" {
+			if e.Template = p.Templates[re.Template]; e.Template == nil {
+				return nil, fmt.Errorf("event %d refers to unknown template %q", id, re.Template)
+			}
+		}
+		p.Events = append(p.Events, e)
+	}
+	return p, nil
+}
+
+func convertFields(raw []xmlField) ([]*Field, error) {
+	fields := make([]*Field, 0, len(raw))
+	for _, rf := range raw {
+		if rf.XMLName.Local != "data" && rf.XMLName.Local != "struct" {
+			continue // E.g. <binary> describing a user data blob.
+		}
+		f := &Field{
+			Name:    rf.Name,
+			InType:  rf.InType,
+			OutType: rf.OutType,
+			Count:   rf.Count,
+			Length:  rf.Length,
+			Map:     rf.Map,
+		}
+		if rf.XMLName.Local == "struct" {
+			var err error
+			if f.Fields, err = convertFields(rf.Fields); err != nil {
+				return nil, err
+			}
+		} else if f.InType == "" {
+			return nil, fmt.Errorf("field %q has no inType", rf.Name)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func normalizeGUID(s string) string {
+	return strings.ToLower(strings.Trim(s, "{}"))
+}
+
+//
+// XML schema.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wes/eventmanifestschema-schema
+//
+
+type xmlManifest struct {
+	Providers []xmlProvider  `xml:"instrumentation>events>provider"`
+	Resources []xmlResources `xml:"localization>resources"`
+}
+
+type xmlResources struct {
+	Culture string      `xml:"culture,attr"`
+	Strings []xmlString `xml:"stringTable>string"`
+}
+
+type xmlString struct {
+	ID    string `xml:"id,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type xmlProvider struct {
+	Name      string        `xml:"name,attr"`
+	GUID      string        `xml:"guid,attr"`
+	Symbol    string        `xml:"symbol,attr"`
+	Events    []xmlEvent    `xml:"events>event"`
+	Tasks     []xmlNamed    `xml:"tasks>task"`
+	Opcodes   []xmlNamed    `xml:"opcodes>opcode"`
+	Keywords  []xmlKeyword  `xml:"keywords>keyword"`
+	ValueMaps []xmlMap      `xml:"maps>valueMap"`
+	BitMaps   []xmlMap      `xml:"maps>bitMap"`
+	Templates []xmlTemplate `xml:"templates>template"`
+}
+
+type xmlEvent struct {
+	Value    string `xml:"value,attr"`
+	Version  string `xml:"version,attr"`
+	Level    string `xml:"level,attr"`
+	Task     string `xml:"task,attr"`
+	Opcode   string `xml:"opcode,attr"`
+	Keywords string `xml:"keywords,attr"`
+	Template string `xml:"template,attr"`
+	Symbol   string `xml:"symbol,attr"`
+	Message  string `xml:"message,attr"`
+}
+
+type xmlNamed struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type xmlKeyword struct {
+	Name string `xml:"name,attr"`
+	Mask string `xml:"mask,attr"`
+}
+
+type xmlMap struct {
+	XMLName xml.Name
+	Name    string     `xml:"name,attr"`
+	Entries []xmlEntry `xml:"map"`
+}
+
+type xmlEntry struct {
+	Value   string `xml:"value,attr"`
+	Message string `xml:"message,attr"`
+}
+
+type xmlTemplate struct {
+	ID     string     `xml:"tid,attr"`
+	Fields []xmlField `xml:",any"`
+}
+
+type xmlField struct {
+	XMLName xml.Name
+	Name    string     `xml:"name,attr"`
+	InType  string     `xml:"inType,attr"`
+	OutType string     `xml:"outType,attr"`
+	Count   string     `xml:"count,attr"`
+	Length  string     `xml:"length,attr"`
+	Map     string     `xml:"map,attr"`
+	Fields  []xmlField `xml:",any"`
+}
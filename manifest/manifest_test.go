@@ -0,0 +1,128 @@
+package manifest_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/manifest"
+)
+
+const sampleProvider = "{5b3a1c7e-0d2f-4e8a-9c61-2b7d4f0a8e13}"
+
+type payload []byte
+
+func (p *payload) uint(v uint64, size int) *payload {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	*p = append(*p, b[:size]...)
+	return p
+}
+
+func (p *payload) utf16(s string) *payload {
+	for _, c := range utf16.Encode([]rune(s + "\x00")) {
+		p.uint(uint64(c), 2)
+	}
+	return p
+}
+
+func (p *payload) bytes(b ...byte) *payload {
+	*p = append(*p, b...)
+	return p
+}
+
+func TestParse(t *testing.T) {
+	m, err := manifest.ParseFile("testdata/sample.man")
+	require.NoError(t, err, "Failed to parse manifest")
+	require.Len(t, m.Providers, 1)
+
+	p := m.Provider("5B3A1C7E-0D2F-4E8A-9C61-2B7D4F0A8E13")
+	require.NotNil(t, p, "Failed to find provider by GUID")
+	assert.Equal(t, "Sample-Provider", p.Name)
+	assert.Equal(t, map[uint16]string{1: "Process"}, p.Tasks)
+	assert.Equal(t, map[uint8]string{0x20: "Custom"}, p.Opcodes)
+	assert.Equal(t, map[uint64]string{0x10: "ProcessKeyword"}, p.Keywords)
+
+	e := p.Event(1, 0)
+	require.NotNil(t, e, "Failed to find event")
+	assert.Equal(t, "Process %1 started.", e.Message)
+	assert.Equal(t, []string{"ProcessKeyword"}, e.Keywords)
+	require.NotNil(t, e.Template)
+	assert.Len(t, e.Template.Fields, 13)
+
+	assert.NotNil(t, p.Event(1, 1), "Failed to find another event version")
+	assert.Nil(t, p.Event(3, 0), "Unexpected event")
+}
+
+func TestDecode(t *testing.T) {
+	m, err := manifest.ParseFile("testdata/sample.man")
+	require.NoError(t, err, "Failed to parse manifest")
+
+	var data payload
+	data.uint(1234, 4).
+		utf16(`C:\Windows\cmd.exe`).
+		uint(1, 4).
+		uint(3, 4).
+		uint(0xC0000022, 4).
+		uint(2, 2).bytes('/', 'c', 0, 'd', 'i', 'r', 0).
+		uint(2, 1).bytes(0xAB, 0xCD).
+		uint(uint64(^uint32(0)), 4). // -1
+		bytes(0x7E, 0x1C, 0x3A, 0x5B, 0x2F, 0x0D, 0x8A, 0x4E, 0x9C, 0x61, 0x2B, 0x7D, 0x4F, 0x0A, 0x8E, 0x13).
+		uint(0xDEADBEEF, 8).
+		uint(116444736000000000, 8).                            // Unix epoch.
+		bytes(1, 2, 0, 0, 0, 0, 0, 5, 32, 0, 0, 0, 32, 2, 0, 0) // S-1-5-32-544
+
+	props, err := m.Decode(sampleProvider, 1, 0, data)
+	require.NoError(t, err, "Failed to decode event")
+	assert.Equal(t, map[string]interface{}{
+		"ProcessID":  "1234",
+		"ImageName":  `C:\Windows\cmd.exe`,
+		"State":      "Running",
+		"Flags":      "Read|Write",
+		"Status":     "0xC0000022",
+		"ArgCount":   "2",
+		"Args":       []interface{}{"/c", "dir"},
+		"HashLength": "2",
+		"Hash":       "0xABCD",
+		"Parent": map[string]interface{}{
+			"ID":   "-1",
+			"Guid": "{5B3A1C7E-0D2F-4E8A-9C61-2B7D4F0A8E13}",
+		},
+		"Address": "0xDEADBEEF",
+		"Created": "1970-01-01T00:00:00Z",
+		"User":    "S-1-5-32-544",
+	}, props)
+
+	// Every truncation should be detected.
+	for i := 0; i < len(data); i++ {
+		_, err := m.Decode(sampleProvider, 1, 0, data[:i])
+		assert.True(t, errors.Is(err, manifest.ErrMalformedData), "Unexpected error decoding %d bytes: %v", i, err)
+	}
+}
+
+func TestDecodeOptions(t *testing.T) {
+	m, err := manifest.ParseFile("testdata/sample.man")
+	require.NoError(t, err, "Failed to parse manifest")
+
+	var data payload
+	data.uint(1, 4).uint(1, 4)
+	props, err := m.Decode(sampleProvider, 1, 1, data)
+	require.NoError(t, err, "Failed to decode event")
+	assert.Equal(t, map[string]interface{}{"ProcessID": "1", "Enabled": "true"}, props)
+
+	props, err = m.Decode(sampleProvider, 2, 0, nil)
+	require.NoError(t, err, "Failed to decode event without template")
+	assert.Empty(t, props)
+
+	_, err = m.Decode(sampleProvider, 3, 0, nil)
+	assert.True(t, errors.Is(err, manifest.ErrUnknownEvent), "Unexpected error %v", err)
+	_, err = m.Decode("{00000000-0000-0000-0000-000000000000}", 1, 0, nil)
+	assert.True(t, errors.Is(err, manifest.ErrUnknownEvent), "Unexpected error %v", err)
+
+	_, err = m.Decode(sampleProvider, 1, 1, data, manifest.WithPointerSize(2))
+	assert.Error(t, err, "Expected an error for invalid pointer size")
+}
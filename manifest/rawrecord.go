@@ -0,0 +1,12 @@
+package manifest
+
+import (
+	"github.com/bi-zone/etw/rawrecord"
+)
+
+// RecordProperties decodes properties of the raw record @r captured with
+// etw.RawRecordCallback, e.g. on another machine.
+func (m *Manifest) RecordProperties(r *rawrecord.Record, options ...DecodeOption) (map[string]interface{}, error) {
+	options = append([]DecodeOption{WithPointerSize(r.Header.PointerSize())}, options...)
+	return m.Decode(r.Header.ProviderID.String(), r.Header.ID, r.Header.Version, r.UserData, options...)
+}
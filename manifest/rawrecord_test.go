@@ -0,0 +1,37 @@
+package manifest_test
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/manifest"
+	"github.com/bi-zone/etw/rawrecord"
+)
+
+func TestRecordProperties(t *testing.T) {
+	m, err := manifest.ParseFile("testdata/sample.man")
+	require.NoError(t, err, "Failed to parse manifest")
+
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint32(data[0:], 7) // ProcessID
+	binary.LittleEndian.PutUint32(data[4:], 1) // Enabled
+	r := rawrecord.Record{
+		Header: rawrecord.Header{
+			ID:      1,
+			Version: 1,
+			ProviderID: rawrecord.GUID{
+				0x7E, 0x1C, 0x3A, 0x5B, 0x2F, 0x0D, 0x8A, 0x4E, 0x9C, 0x61, 0x2B, 0x7D, 0x4F, 0x0A, 0x8E, 0x13,
+			},
+		},
+		UserData: data,
+	}
+	assert.True(t, strings.EqualFold(sampleProvider, r.Header.ProviderID.String()),
+		"Unexpected provider GUID %s", r.Header.ProviderID)
+	props, err := m.RecordProperties(&r)
+	require.NoError(t, err, "Failed to decode record")
+	assert.Equal(t, map[string]interface{}{"ProcessID": "7", "Enabled": "true"}, props)
+}
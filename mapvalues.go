@@ -0,0 +1,213 @@
+//+build windows
+
+package etw
+
+/*
+	#include "session.h"
+*/
+import "C"
+import (
+	"encoding/binary"
+	"strings"
+	"sync/atomic"
+	"unicode/utf16"
+	"unsafe"
+)
+
+// EVENT_MAP_INFO flags and layout.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/tdh/ns-tdh-event_map_info
+const (
+	eventMapInfoFlagManifestBitmap     = 0x2  // EVENTMAP_INFO_FLAG_MANIFEST_BITMAP
+	eventMapInfoFlagManifestPatternMap = 0x4  // EVENTMAP_INFO_FLAG_MANIFEST_PATTERNMAP
+	eventMapInfoFlagWBEMBitmap         = 0x10 // EVENTMAP_INFO_FLAG_WBEM_BITMAP
+	eventMapInfoFlagWBEMFlag           = 0x20 // EVENTMAP_INFO_FLAG_WBEM_FLAG
+
+	eventMapEntryValueTypeULong = 0 // EVENTMAP_ENTRY_VALUETYPE_ULONG
+
+	eventMapInfoHeaderSize = 16 // Offset of EVENT_MAP_INFO.MapEntryArray.
+	eventMapEntrySize      = 8  // sizeof(EVENT_MAP_ENTRY)
+)
+
+// Integer in-types that could be mapped.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/tdh/ne-tdh-_tdh_in_type
+const (
+	tdhInTypeInt8     = 3  // TDH_INTYPE_INT8
+	tdhInTypeUInt8    = 4  // TDH_INTYPE_UINT8
+	tdhInTypeInt16    = 5  // TDH_INTYPE_INT16
+	tdhInTypeUInt16   = 6  // TDH_INTYPE_UINT16
+	tdhInTypeInt32    = 7  // TDH_INTYPE_INT32
+	tdhInTypeUInt32   = 8  // TDH_INTYPE_UINT32
+	tdhInTypeInt64    = 9  // TDH_INTYPE_INT64
+	tdhInTypeUInt64   = 10 // TDH_INTYPE_UINT64
+	tdhInTypeHexInt32 = 20 // TDH_INTYPE_HEXINT32
+	tdhInTypeHexInt64 = 21 // TDH_INTYPE_HEXINT64
+)
+
+// MapValue is a value of an integer property having a value map or a bitmap
+// (EVENT_MAP_INFO) returned by EventProperties with WithMapValues option.
+// Analytics pipelines need the numeric value for aggregations while humans
+// prefer the names.
+type MapValue struct {
+	// Value is the raw property value. Signed values are sign-extended.
+	Value uint64
+
+	// Names are the mapped names: a single name for value maps or names of
+	// all set flags for bitmaps. Empty if the value is not mapped.
+	Names []string
+
+	// Formatted is the value as TDH formats it, i.e. what EventProperties
+	// returns without WithMapValues.
+	Formatted string
+}
+
+// WithMapValues makes EventProperties return integer properties having a
+// value map or a bitmap as MapValue instead of the mapped names only.
+func WithMapValues() ParseOption {
+	return func(cfg *ParseOptions) {
+		cfg.MapValues = true
+	}
+}
+
+// integerSize returns a size of TDH integer @inType values and whether
+// they are signed. Zero size means not an integer in-type.
+func integerSize(inType uintptr) (size int, signed bool) {
+	switch inType {
+	case tdhInTypeInt8:
+		return 1, true
+	case tdhInTypeUInt8:
+		return 1, false
+	case tdhInTypeInt16:
+		return 2, true
+	case tdhInTypeUInt16:
+		return 2, false
+	case tdhInTypeInt32:
+		return 4, true
+	case tdhInTypeUInt32, tdhInTypeHexInt32:
+		return 4, false
+	case tdhInTypeInt64:
+		return 8, true
+	case tdhInTypeUInt64, tdhInTypeHexInt64:
+		return 8, false
+	default:
+		return 0, false
+	}
+}
+
+// readInteger reads the raw value of the @i-th property without consuming
+// it. Returns false if the property is not an integer or it's truncated.
+func (p *propertyParser) readInteger(i int) (uint64, int, bool) {
+	size, signed := integerSize(p.plan.properties[i].inType)
+	if size == 0 || p.data > p.endData || uintptr(size) > p.endData-p.data {
+		return 0, 0, false
+	}
+	raw := make([]byte, 8)
+	copy(raw, C.GoBytes(unsafe.Pointer(p.data), C.int(size)))
+	value := binary.LittleEndian.Uint64(raw)
+	if signed && size < 8 && raw[size-1]&0x80 != 0 {
+		value |= ^uint64(0) << (8 * uint(size))
+	}
+	return value, size, true
+}
+
+// parseMapValue parses the @i-th property as MapValue if it's a mapped
+// integer. Otherwise the property is parsed as parseSimpleType does.
+func (p *propertyParser) parseMapValue(i int) (interface{}, error) {
+	mapInfo, _ := p.plan.valueMap(p.record, p.info, i)
+	if len(mapInfo) == 0 {
+		if m, ok := p.customValueMap(i); ok {
+			if value, size, ok := p.readInteger(i); ok {
+				p.data += uintptr(size)
+				_, signed := integerSize(p.plan.properties[i].inType)
+				return MapValue{Value: value, Names: m.names(value), Formatted: m.format(value, signed)}, nil
+			}
+		}
+		return p.parseSimpleType(i)
+	}
+	value, _, ok := p.readInteger(i)
+	if !ok || !isIntegerMap(mapInfo) {
+		return p.parseSimpleType(i)
+	}
+	formatted, err := p.parseSimpleType(i)
+	if err != nil {
+		return nil, err
+	}
+	return MapValue{
+		Value:     value,
+		Names:     decodeMapNames(mapInfo, value),
+		Formatted: formatted,
+	}, nil
+}
+
+// customValueMap returns a map registered with RegisterValueMap for the
+// @i-th property.
+func (p *propertyParser) customValueMap(i int) (ValueMap, bool) {
+	if atomic.LoadInt32(&valueMapsCount) == 0 {
+		return ValueMap{}, false // Don't decode the key needlessly.
+	}
+	return lookupValueMap(windowsGUIDToGo(p.record.EventHeader.ProviderId), p.getPropertyName(i))
+}
+
+// isIntegerMap returns true if @mapInfo maps integers to names. Pattern
+// maps and WBEM maps keyed by strings are left to TDH.
+func isIntegerMap(mapInfo []byte) bool {
+	if len(mapInfo) < eventMapInfoHeaderSize {
+		return false
+	}
+	if binary.LittleEndian.Uint32(mapInfo[4:])&eventMapInfoFlagManifestPatternMap != 0 {
+		return false
+	}
+	// MapEntryValueType shares the union with FormatStringOffset of
+	// pattern maps.
+	return binary.LittleEndian.Uint32(mapInfo[12:]) == eventMapEntryValueTypeULong
+}
+
+// decodeMapNames returns names @mapInfo maps @value to. Bitmaps map values
+// to names of all flags set, other maps to the name of the equal value.
+func decodeMapNames(mapInfo []byte, value uint64) []string {
+	if !isIntegerMap(mapInfo) {
+		return nil
+	}
+	flags := binary.LittleEndian.Uint32(mapInfo[4:])
+	count := int(binary.LittleEndian.Uint32(mapInfo[8:]))
+	bitmap := flags&(eventMapInfoFlagManifestBitmap|eventMapInfoFlagWBEMBitmap|eventMapInfoFlagWBEMFlag) != 0
+
+	var names []string
+	for j := 0; j < count; j++ {
+		entry := eventMapInfoHeaderSize + j*eventMapEntrySize
+		if entry+eventMapEntrySize > len(mapInfo) {
+			break
+		}
+		nameOffset := int(binary.LittleEndian.Uint32(mapInfo[entry:]))
+		entryValue := uint64(binary.LittleEndian.Uint32(mapInfo[entry+4:]))
+		if bitmap {
+			if entryValue == 0 || value&entryValue != entryValue {
+				continue
+			}
+		} else if entryValue != value {
+			continue
+		}
+		if name := mapString(mapInfo, nameOffset); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// mapString decodes a null-terminated UTF-16 string at @offset of
+// @mapInfo. Manifest names are padded with spaces, they are trimmed.
+func mapString(mapInfo []byte, offset int) string {
+	if offset <= 0 || offset >= len(mapInfo) {
+		return ""
+	}
+	var chars []uint16
+	for i := offset; i+1 < len(mapInfo); i += 2 {
+		c := binary.LittleEndian.Uint16(mapInfo[i:])
+		if c == 0 {
+			break
+		}
+		chars = append(chars, c)
+	}
+	return strings.TrimSpace(string(utf16.Decode(chars)))
+}
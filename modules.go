@@ -0,0 +1,216 @@
+//+build windows
+
+package etw
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Event types of the kernel Image and Process event classes ModuleMap is
+// updated with.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/etw/image-load
+const (
+	imageLoadOpcode    = 10
+	imageUnloadOpcode  = 2
+	processExitOpcode  = 2
+	kernelModulesOwner = 0 // ProcessId of drivers and the kernel image.
+)
+
+// Module is an executable image loaded into a process address space.
+type Module struct {
+	Base     uint64
+	Size     uint64
+	FileName string // Kernel device path, e.g. `\Device\HarddiskVolume2\Windows\System32\ntdll.dll`.
+}
+
+// Name returns the module file name without a path, e.g. "ntdll.dll".
+func (m Module) Name() string {
+	return m.FileName[strings.LastIndexAny(m.FileName, `\/`)+1:]
+}
+
+// contains returns true if @addr belongs to the module image.
+func (m Module) contains(addr uint64) bool {
+	return addr >= m.Base && addr-m.Base < m.Size
+}
+
+// ModuleMap tracks modules loaded into every process to resolve code
+// addresses, e.g. ones of EventStackTrace, to modules. It's fed with kernel
+// Image events of the NT Kernel Logger enabled with
+// EVENT_TRACE_FLAG_IMAGE_LOAD. The kernel logs rundown Image events for all
+// the modules already loaded at the session start, so the map becomes
+// complete soon after `.Process` starts. Processes are removed from the map
+// on Process exit events if EVENT_TRACE_FLAG_PROCESS is enabled too.
+//
+//		modules := etw.NewModuleMap()
+//		err := session.Process(modules.Callback(cb))
+//
+// Drivers and the kernel image are loaded into the process with ID 0, they
+// resolve kernel addresses of any process. ModuleMap is safe for concurrent
+// use.
+type ModuleMap struct {
+	mu        sync.RWMutex
+	processes map[uint32][]Module // Sorted by Base.
+}
+
+// NewModuleMap creates an empty ModuleMap.
+func NewModuleMap() *ModuleMap {
+	return &ModuleMap{processes: make(map[uint32][]Module)}
+}
+
+// Load adds the module @m loaded into the process @pid. A module previously
+// loaded at the same base is replaced.
+func (mm *ModuleMap) Load(pid uint32, m Module) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	modules := mm.processes[pid]
+	i := sort.Search(len(modules), func(i int) bool { return modules[i].Base >= m.Base })
+	if i < len(modules) && modules[i].Base == m.Base {
+		modules[i] = m
+		return
+	}
+	modules = append(modules, Module{})
+	copy(modules[i+1:], modules[i:])
+	modules[i] = m
+	mm.processes[pid] = modules
+}
+
+// Unload removes the module loaded at @base from the process @pid.
+func (mm *ModuleMap) Unload(pid uint32, base uint64) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	modules := mm.processes[pid]
+	i := sort.Search(len(modules), func(i int) bool { return modules[i].Base >= base })
+	if i == len(modules) || modules[i].Base != base {
+		return
+	}
+	modules = append(modules[:i], modules[i+1:]...)
+	if len(modules) == 0 {
+		delete(mm.processes, pid)
+		return
+	}
+	mm.processes[pid] = modules
+}
+
+// RemoveProcess forgets all the modules of the exited process @pid.
+func (mm *ModuleMap) RemoveProcess(pid uint32) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	delete(mm.processes, pid)
+}
+
+// Modules returns a copy of the modules loaded into the process @pid ordered
+// by base addresses.
+func (mm *ModuleMap) Modules(pid uint32) []Module {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	return append([]Module(nil), mm.processes[pid]...)
+}
+
+// Lookup returns the module of the process @pid containing @addr. Kernel
+// modules are looked up if no process module contains it.
+func (mm *ModuleMap) Lookup(pid uint32, addr uint64) (Module, bool) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	if m, ok := mm.lookup(pid, addr); ok {
+		return m, true
+	}
+	return mm.lookup(kernelModulesOwner, addr)
+}
+
+// lookup implements Lookup for a single process. mm.mu should be held.
+func (mm *ModuleMap) lookup(pid uint32, addr uint64) (Module, bool) {
+	modules := mm.processes[pid]
+	i := sort.Search(len(modules), func(i int) bool { return modules[i].Base > addr })
+	if i == 0 || !modules[i-1].contains(addr) {
+		return Module{}, false
+	}
+	return modules[i-1], true
+}
+
+// HandleEvent updates the map with the kernel Image or Process event @e.
+// Returns true if @e is one of them.
+func (mm *ModuleMap) HandleEvent(e *Event) (bool, error) {
+	h := &e.Header
+	switch {
+	case h.ProviderID == imageClassGUID:
+		if h.OpCode != imageLoadOpcode && h.OpCode != imageUnloadOpcode && !h.IsRundown() {
+			return false, nil
+		}
+	case h.ProviderID == processClassGUID && h.OpCode == processExitOpcode:
+	default:
+		return false, nil
+	}
+	properties, err := e.EventProperties()
+	if err != nil {
+		return true, fmt.Errorf("failed to parse kernel event; %w", err)
+	}
+	pid, err := uintProperty(properties, "ProcessId")
+	if err != nil {
+		return true, err
+	}
+	if h.ProviderID == processClassGUID {
+		mm.RemoveProcess(uint32(pid))
+		return true, nil
+	}
+
+	base, err := uintProperty(properties, "ImageBase")
+	if err != nil {
+		return true, err
+	}
+	if h.OpCode == imageUnloadOpcode {
+		mm.Unload(uint32(pid), base)
+		return true, nil
+	}
+	size, err := uintProperty(properties, "ImageSize")
+	if err != nil {
+		return true, err
+	}
+	fileName, _ := properties["FileName"].(string)
+	mm.Load(uint32(pid), Module{Base: base, Size: size, FileName: fileName})
+	return true, nil
+}
+
+// Callback returns an EventCallback updating the map with kernel Image and
+// Process events and passing all the events to @cb. Events failed to be
+// parsed are skipped by the map.
+func (mm *ModuleMap) Callback(cb EventCallback) EventCallback {
+	return func(e *Event) {
+		_, _ = mm.HandleEvent(e)
+		cb(e)
+	}
+}
+
+// uintProperty parses an integer property @name formatted by TDH either as
+// a decimal or as a "0x" prefixed hex number.
+func uintProperty(properties map[string]interface{}, name string) (uint64, error) {
+	str, ok := properties[name].(string)
+	if !ok {
+		return 0, fmt.Errorf("no %q property in kernel event", name)
+	}
+	value, err := strconv.ParseUint(str, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %q property of kernel event; %w", name, err)
+	}
+	return value, nil
+}
+
+// ResolveModules resolves the stack addresses to "module!0xoffset" strings
+// using modules of the process @pid (the event one) from @modules, e.g.
+// "ntdll.dll!0x9f2a4". Addresses out of known modules are returned as
+// "0xaddress".
+func (t EventStackTrace) ResolveModules(modules *ModuleMap, pid uint32) []string {
+	frames := make([]string, len(t.Addresses))
+	for i, addr := range t.Addresses {
+		if m, ok := modules.Lookup(pid, addr); ok {
+			frames[i] = fmt.Sprintf("%s!0x%x", m.Name(), addr-m.Base)
+			continue
+		}
+		frames[i] = fmt.Sprintf("0x%x", addr)
+	}
+	return frames
+}
@@ -0,0 +1,46 @@
+// +build windows
+
+package etw_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bi-zone/etw"
+)
+
+func TestModuleMap(t *testing.T) {
+	const pid = 1234
+	modules := etw.NewModuleMap()
+	ntdll := etw.Module{Base: 0x7ff800000000, Size: 0x1f0000, FileName: `\Device\HarddiskVolume2\Windows\System32\ntdll.dll`}
+	app := etw.Module{Base: 0x7ff700000000, Size: 0x10000, FileName: `\Device\HarddiskVolume2\app.exe`}
+	kernel := etw.Module{Base: 0xfffff80000000000, Size: 0x1000000, FileName: `\SystemRoot\system32\ntoskrnl.exe`}
+	modules.Load(pid, ntdll)
+	modules.Load(pid, app)
+	modules.Load(0, kernel)
+	assert.Equal(t, []etw.Module{app, ntdll}, modules.Modules(pid), "Modules are not ordered by base")
+
+	stack := etw.EventStackTrace{Addresses: []uint64{
+		0xfffff80000001234, // Kernel module.
+		0x7ff80009f2a4,     // ntdll.dll.
+		0x7ff700000010,     // app.exe.
+		0x7ff700010000,     // Right after app.exe.
+	}}
+	assert.Equal(t, []string{
+		"ntoskrnl.exe!0x1234",
+		"ntdll.dll!0x9f2a4",
+		"app.exe!0x10",
+		"0x7ff700010000",
+	}, stack.ResolveModules(modules, pid))
+
+	modules.Unload(pid, app.Base)
+	_, ok := modules.Lookup(pid, 0x7ff700000010)
+	assert.False(t, ok, "Unloaded module is resolved")
+
+	modules.RemoveProcess(pid)
+	assert.Empty(t, modules.Modules(pid), "Modules of removed process are kept")
+	m, ok := modules.Lookup(pid, 0xfffff80000001234)
+	assert.True(t, ok, "Kernel module is not resolved after process exit")
+	assert.Equal(t, "ntoskrnl.exe", m.Name())
+}
@@ -0,0 +1,49 @@
+//+build windows
+
+package etw
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// maxSessionNameLength keeps generated names readable in `logman query -ets`
+// output. ETW itself allows up to 1024 characters.
+const maxSessionNameLength = 64
+
+// SessionName derives a deterministic session name from @parts, e.g.
+// SessionName("myagent", "dns", "v1") returns "myagent-dns-v1".
+//
+// Unlike random default names, a stable name lets the application find the
+// session left by its previous (crashed) run: kill it via KillSession or
+// take it over with WithAdoptExisting.
+//
+// Characters other than ASCII letters, digits, '.' and '_' are replaced by
+// '-'. If the name had to be sanitized or shortened, a hash of the original
+// parts is appended to keep different inputs from colliding.
+func SessionName(parts ...string) string {
+	raw := strings.Join(parts, "-")
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		default:
+			return '-'
+		}
+	}, raw)
+	if name == raw && len(name) <= maxSessionNameLength && name != "" {
+		return name
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(raw)) //nolint:errcheck // Never fails.
+	suffix := fmt.Sprintf("-%08x", h.Sum32())
+	if len(name) > maxSessionNameLength-len(suffix) {
+		name = name[:maxSessionNameLength-len(suffix)]
+	}
+	if name = strings.Trim(name, "-"); name == "" {
+		name = "go-etw"
+	}
+	return name + suffix
+}
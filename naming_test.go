@@ -0,0 +1,25 @@
+// +build windows
+
+package etw_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bi-zone/etw"
+)
+
+func TestSessionName(t *testing.T) {
+	assert.Equal(t, "myagent-dns-v1", etw.SessionName("myagent", "dns", "v1"))
+	assert.Equal(t, etw.SessionName("my agent", "dns"), etw.SessionName("my agent", "dns"), "Name is not deterministic")
+
+	sanitized := etw.SessionName("my agent", "dns")
+	assert.True(t, strings.HasPrefix(sanitized, "my-agent-dns-"), "Unexpected sanitized name %q", sanitized)
+	assert.NotEqual(t, sanitized, etw.SessionName("my/agent", "dns"), "Sanitized names collide")
+
+	long := etw.SessionName(strings.Repeat("a", 100))
+	assert.Len(t, long, 64, "Long name is not shortened")
+	assert.NotEqual(t, long, etw.SessionName(strings.Repeat("a", 101)), "Shortened names collide")
+}
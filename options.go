@@ -6,6 +6,11 @@ package etw
 	#include "windows.h"
 */
 import "C"
+import (
+	"time"
+
+	"golang.org/x/sys/windows"
+)
 
 // SessionOptions describes Session subscription options.
 //
@@ -55,6 +60,200 @@ type SessionOptions struct {
 	// original API reference:
 	// https://docs.microsoft.com/en-us/windows/win32/api/evntrace/ns-evntrace-enable_trace_parameters
 	EnableProperties []EnableProperty
+
+	// EventIDs limits events the provider logs to the session with ones
+	// having the given EventDescriptor.ID (EVENT_FILTER_TYPE_EVENT_ID). Up to
+	// 64 IDs could be set. Unlike Opcodes the filter is applied by the
+	// provider itself, so filtered out events don't occupy session buffers.
+	// Empty EventIDs means no filtering.
+	//
+	// Windows 8.1 and later, check Capabilities().EventIDFilters; NewSession
+	// fails with ErrUnsupportedOSVersion on older systems.
+	EventIDs []uint16
+
+	// LogFileName is a path of the .etl file events will be persisted to
+	// in addition to the real-time delivery to the EventCallback. Empty
+	// LogFileName means real-time only session.
+	//
+	// If LogFileMaxSize is set LogFileName is used as a template: a session
+	// start timestamp and a sequence number are appended to the base name
+	// of every newly created file.
+	LogFileName string
+
+	// LogFileMaxSize is a maximum size of a single .etl file in megabytes.
+	// Having the limit reached ETW starts a new file. Zero means no rotation
+	// at all.
+	LogFileMaxSize uint32
+
+	// Channels and Opcodes limit events passed to the EventCallback with ones
+	// having EventDescriptor.Channel and EventDescriptor.OpCode in the
+	// corresponding sets. Empty set means no filtering.
+	//
+	// ETW has no kernel-side filters for channels and opcodes, so events are
+	// dropped right after being received, but before any parsing. Filters
+	// are applied to events of all session providers.
+	Channels []uint8
+	Opcodes  []uint8
+
+	// BufferSize is a size of every session buffer in kilobytes. Zero value
+	// means ETW default size that depends on the system memory.
+	//
+	// Events that don't fit a session buffer are dropped by ETW, so to
+	// receive the largest possible events (up to 64KB) set BufferSize to 64.
+	BufferSize uint32
+
+	// MinimumBuffers and MaximumBuffers limit the number of buffers ETW
+	// allocates for the session. Zero values mean ETW defaults. Events are
+	// lost once all MaximumBuffers are full, e.g. while the EventCallback
+	// is busy. Check EstimateBuffers to choose the values.
+	MinimumBuffers uint32
+	MaximumBuffers uint32
+
+	// FlushTimer is a period buffers are delivered to the consumer with even
+	// if they aren't full. It bounds the delivery latency of rare events.
+	// Zero FlushTimer means ETW default. Periods that aren't whole seconds
+	// are supported since Windows 8 and rounded up to seconds before.
+	FlushTimer time.Duration
+
+	// NoPerProcessorBuffering makes all processors log to shared session
+	// buffers (EVENT_TRACE_NO_PER_PROCESSOR_BUFFERING) instead of their own
+	// ones, so events are delivered in the strict chronological order.
+	// With per-processor buffers events are ordered within a buffer only,
+	// and buffers of different processors are delivered as they fill up.
+	//
+	// The ordering costs throughput: processors contend for the shared
+	// buffers, so providers logging heavily from many processors slow down
+	// and the session loses events sooner. Prefer ordering events by
+	// timestamps on the consumer side for high event rates.
+	//
+	// The option is applied only on session creation and ignored by
+	// `.UpdateOptions`. CallbackStrictOrder mode implies it.
+	NoPerProcessorBuffering bool
+
+	// SecureMode makes the session accept events only from processes having
+	// the TRACELOG_LOG_EVENT permission (EVENT_TRACE_SECURE_MODE), so other
+	// processes can't inject fake events. That's a hardening requirement for
+	// sessions used as security telemetry sources. The permission is granted
+	// with `.GrantLogging`, or via EventAccessControl for the session GUID
+	// set by SessionGUID or reported by `.TraceProperties`.
+	//
+	// The option is applied only on session creation and ignored by
+	// `.UpdateOptions`.
+	SecureMode bool
+
+	// SessionGUID identifies the session for EventAccessControl, so logging
+	// permissions of a SecureMode session could be granted before it's
+	// started, e.g. by an installer. ETW generates the GUID if it's not set.
+	// It's ignored for the kernel logger which always has
+	// SystemTraceControlGUID.
+	//
+	// The option is applied only on session creation and ignored by
+	// `.UpdateOptions`.
+	SessionGUID windows.GUID
+
+	// IndependentSession isolates the session from others enabling the same
+	// providers (EVENT_TRACE_INDEPENDENT_SESSION_MODE). Without it a provider
+	// failing to log to a session that is full, e.g. of a co-tenanted
+	// tracing tool with a slow consumer, could skip logging the event to
+	// other sessions, ours included. Windows 8.1 and later, check
+	// Capabilities().IndependentSessions; NewSession fails with
+	// ErrUnsupportedOSVersion on older systems.
+	//
+	// The option is applied only on session creation and ignored by
+	// `.UpdateOptions`.
+	IndependentSession bool
+
+	// CallbackTimeout is a maximum expected duration of the EventCallback
+	// call. Callbacks exceeding it are reported to OnSlowCallback. Zero
+	// CallbackTimeout disables the measurement.
+	//
+	// A single slow callback delays all subsequent events and could make
+	// the whole session lose events, so it's worth to keep an eye on them.
+	// With CallbackParallel mode every call is measured in its worker, so
+	// OnSlowCallback is called concurrently then.
+	CallbackTimeout time.Duration
+	OnSlowCallback  func(e *Event, took time.Duration)
+
+	// SourceGUID is passed to providers as a SourceId of the enable request
+	// (ENABLE_TRACE_PARAMETERS.SourceId), so providers could tell which
+	// controller has enabled them. Zero SourceGUID means the session GUID
+	// ETW uses by default.
+	SourceGUID windows.GUID
+
+	// EnableTimeout makes provider subscription synchronous: EnableTraceEx2
+	// waits up to EnableTimeout for the registered providers to process the
+	// enable callback. Zero EnableTimeout enables providers asynchronously.
+	EnableTimeout time.Duration
+
+	// AdoptExisting makes NewSession take over a running session with the
+	// same Name instead of failing with ExistsError.
+	AdoptExisting bool
+
+	// ThreadPriority and ThreadAffinity are applied to the OS thread that
+	// runs `.Process` and therefore the EventCallback. THREAD_PRIORITY_NORMAL
+	// and zero affinity mask leave the thread as is.
+	//
+	// A callback thread losing CPU to busy application threads is a common
+	// cause of real-time buffers loss, so raising its priority could help.
+	ThreadPriority ThreadPriority
+	ThreadAffinity uint64
+
+	// CallbackMode defines the way the EventCallback is invoked: one event
+	// at a time (the default), strictly in timestamp order or concurrently.
+	// CallbackWorkers is a number of goroutines of CallbackParallel mode.
+	//
+	// Both are taken from NewSession options only, they can't be changed by
+	// `.UpdateOptions`.
+	CallbackMode    CallbackMode
+	CallbackWorkers int
+
+	// SecurityContext is a token the session is created and controlled
+	// under: StartTrace, ControlTrace and EnableTraceEx2 calls are made
+	// impersonating it. Zero SecurityContext means the process token.
+	//
+	// SecurityContext is taken from NewSession options only, it can't be
+	// changed by `.UpdateOptions`. The token is used (not owned) by the
+	// session, so keep it open until the session is closed.
+	SecurityContext windows.Token
+
+	// Labels are arbitrary key-value pairs identifying the session in
+	// stats, metrics, diagnostics and error messages of the package. They
+	// help to tell apart many sessions created by a single application.
+	//
+	// Labels are taken from NewSession options only, they can't be changed
+	// by `.UpdateOptions`.
+	Labels map[string]string
+
+	// Retry makes NewSession retry session creation failed due to a
+	// temporary lack of system resources. Zero Retry means no retries.
+	//
+	// Retry is taken from NewSession options only, it's ignored by
+	// `.UpdateOptions`.
+	Retry RetryPolicy
+
+	// AutoRestart makes `.Process` recreate the session stopped by someone
+	// else and re-enable its providers instead of returning. Nil means
+	// `.Process` returns as soon as the session is stopped.
+	AutoRestart *RestartPolicy
+
+	// KernelStackWalk are kernel events the NT Kernel Logger captures call
+	// stacks for. Take a look at WithKernelStackWalk.
+	//
+	// KernelStackWalk is taken from NewKernelSession options only, it's
+	// ignored by `.UpdateOptions`.
+	KernelStackWalk []StackWalkFlag
+}
+
+// clone returns a deep copy of the options, so the copy could be modified
+// without affecting the original.
+func (o SessionOptions) clone() SessionOptions {
+	o.EnableProperties = append([]EnableProperty(nil), o.EnableProperties...)
+	o.EventIDs = append([]uint16(nil), o.EventIDs...)
+	o.Channels = append([]uint8(nil), o.Channels...)
+	o.Opcodes = append([]uint8(nil), o.Opcodes...)
+	o.Labels = copyLabels(o.Labels)
+	o.KernelStackWalk = append([]StackWalkFlag(nil), o.KernelStackWalk...)
+	return o
 }
 
 // Option is any function that modifies SessionOptions. Options will be called
@@ -62,6 +261,14 @@ type SessionOptions struct {
 // fields will override each other.
 type Option func(cfg *SessionOptions)
 
+// withConfig replaces the whole config with @config. Used to derive sessions
+// from existing ones.
+func withConfig(config SessionOptions) Option {
+	return func(cfg *SessionOptions) {
+		*cfg = config
+	}
+}
+
 // WithName specifies a provided @name for the creating session. Further that
 // session could be controlled from other processed by it's name, so it should be
 // unique.
@@ -109,6 +316,263 @@ func WithProperty(p EnableProperty) Option {
 	}
 }
 
+// WithEventIDs makes the provider log only events with the given @ids to the
+// session. See SessionOptions.EventIDs.
+func WithEventIDs(ids ...uint16) Option {
+	return func(cfg *SessionOptions) {
+		cfg.EventIDs = ids
+	}
+}
+
+// WithLogFile makes the session persist all received events to the .etl file
+// located at @path along with the real-time delivery to the EventCallback.
+// The file could be used later for forensics with any ETL-compatible tool.
+//
+// The option is applied only on session creation and ignored by
+// `.UpdateOptions`.
+func WithLogFile(path string) Option {
+	return func(cfg *SessionOptions) {
+		cfg.LogFileName = path
+	}
+}
+
+// WithLogFileRotation limits the size of the session .etl file with
+// @maxSizeMB megabytes. Having the limit reached ETW starts a new file named
+// after the one set with WithLogFile, a session start timestamp and a
+// sequence number, e.g. `trace-20200102T150405-1.etl`.
+func WithLogFileRotation(maxSizeMB uint32) Option {
+	return func(cfg *SessionOptions) {
+		cfg.LogFileMaxSize = maxSizeMB
+	}
+}
+
+// WithChannels limits events passed to the EventCallback with ones written
+// to any of @channels. Useful for manifest providers that multiplex
+// different event families on a single GUID.
+func WithChannels(channels ...uint8) Option {
+	return func(cfg *SessionOptions) {
+		cfg.Channels = channels
+	}
+}
+
+// WithOpcodes limits events passed to the EventCallback with ones having
+// any of @opcodes.
+func WithOpcodes(opcodes ...uint8) Option {
+	return func(cfg *SessionOptions) {
+		cfg.Opcodes = opcodes
+	}
+}
+
+// WithBufferSize sets the size of session buffers to @kb kilobytes. ETW
+// drops events that don't fit a single buffer, so increase the size if the
+// provider writes large events.
+//
+// The option is applied only on session creation and ignored by
+// `.UpdateOptions`.
+func WithBufferSize(kb uint32) Option {
+	return func(cfg *SessionOptions) {
+		cfg.BufferSize = kb
+	}
+}
+
+// WithBuffers sets the @min and @max number of session buffers.
+//
+// The option is applied only on session creation and ignored by
+// `.UpdateOptions`.
+func WithBuffers(min, max uint32) Option {
+	return func(cfg *SessionOptions) {
+		cfg.MinimumBuffers = min
+		cfg.MaximumBuffers = max
+	}
+}
+
+// WithNoPerProcessorBuffering makes the session deliver events in the strict
+// chronological order at the cost of throughput. See
+// SessionOptions.NoPerProcessorBuffering for the trade-off.
+//
+// The option is applied only on session creation and ignored by
+// `.UpdateOptions`.
+func WithNoPerProcessorBuffering() Option {
+	return func(cfg *SessionOptions) {
+		cfg.NoPerProcessorBuffering = true
+	}
+}
+
+// WithSecureMode makes the session accept events only from processes having
+// the TRACELOG_LOG_EVENT permission. See SessionOptions.SecureMode.
+//
+// The option is applied only on session creation and ignored by
+// `.UpdateOptions`.
+func WithSecureMode() Option {
+	return func(cfg *SessionOptions) {
+		cfg.SecureMode = true
+	}
+}
+
+// WithSessionGUID sets the GUID identifying the session for
+// EventAccessControl. See SessionOptions.SessionGUID.
+//
+// The option is applied only on session creation and ignored by
+// `.UpdateOptions`.
+func WithSessionGUID(guid windows.GUID) Option {
+	return func(cfg *SessionOptions) {
+		cfg.SessionGUID = guid
+	}
+}
+
+// WithIndependentSession isolates the session from event loss in other
+// sessions enabling the same providers. Windows 8.1 and later. See
+// SessionOptions.IndependentSession.
+//
+// The option is applied only on session creation and ignored by
+// `.UpdateOptions`.
+func WithIndependentSession() Option {
+	return func(cfg *SessionOptions) {
+		cfg.IndependentSession = true
+	}
+}
+
+// WithFlushTimer makes ETW deliver buffers to the consumer at least every
+// @period even if they aren't full.
+//
+// The option is applied only on session creation and ignored by
+// `.UpdateOptions`.
+func WithFlushTimer(period time.Duration) Option {
+	return func(cfg *SessionOptions) {
+		cfg.FlushTimer = period
+	}
+}
+
+// WithCallbackTimeout makes the session measure every EventCallback call and
+// report ones taking longer than @timeout to @onSlow. @onSlow is called right
+// after the slow callback in the same goroutine, so the Event is still valid
+// there.
+func WithCallbackTimeout(timeout time.Duration, onSlow func(e *Event, took time.Duration)) Option {
+	return func(cfg *SessionOptions) {
+		cfg.CallbackTimeout = timeout
+		cfg.OnSlowCallback = onSlow
+	}
+}
+
+// WithSourceGUID makes the session tag its enable requests with @guid, so
+// providers receive it as a SourceId in their enable callbacks. Take a look
+// at SessionOptions.SourceGUID.
+func WithSourceGUID(guid windows.GUID) Option {
+	return func(cfg *SessionOptions) {
+		cfg.SourceGUID = guid
+	}
+}
+
+// WithEnableTimeout makes the session wait up to @timeout for the provider
+// to process the enable request, so once `.Process` starts receiving events
+// (or `.UpdateOptions`, `.AddProvider` return) the provider is known to
+// apply the new options. If the provider doesn't complete its enable
+// callback in time the subscription fails with windows.ERROR_TIMEOUT.
+//
+// N.B. Providers that are not registered yet are enabled immediately, they
+// will get the options on registration.
+func WithEnableTimeout(timeout time.Duration) Option {
+	return func(cfg *SessionOptions) {
+		cfg.EnableTimeout = timeout
+	}
+}
+
+// WithAdoptExisting makes NewSession take over a running session with the
+// same name (e.g. left by a crashed previous run) instead of failing with
+// ExistsError. Use it along with a stable name from SessionName.
+//
+// The adopted session keeps its own properties (buffers, log file) and
+// providers enabled by the previous owner; the session providers are
+// re-enabled with the new options on `.Process`. The application should
+// guarantee that the previous owner is really gone, otherwise both processes
+// will consume and control the same session. Sessions released on purpose
+// are taken over with AdoptSession.
+func WithAdoptExisting() Option {
+	return func(cfg *SessionOptions) {
+		cfg.AdoptExisting = true
+	}
+}
+
+// WithThreadPriority sets a priority of the OS thread running `.Process`
+// and the EventCallback. Only the thread `.Process` is called from is
+// affected, so use it before the first `.Process` call.
+func WithThreadPriority(priority ThreadPriority) Option {
+	return func(cfg *SessionOptions) {
+		cfg.ThreadPriority = priority
+	}
+}
+
+// WithThreadAffinity limits CPUs the OS thread running `.Process` and the
+// EventCallback is allowed to run on with a bit @mask. On systems with more
+// than 64 CPUs the mask applies to the processor group of the thread.
+func WithThreadAffinity(mask uint64) Option {
+	return func(cfg *SessionOptions) {
+		cfg.ThreadAffinity = mask
+	}
+}
+
+// WithCallbackMode sets the way the EventCallback is invoked. Take a look
+// at CallbackMode values for the guarantees of every mode.
+func WithCallbackMode(mode CallbackMode) Option {
+	return func(cfg *SessionOptions) {
+		cfg.CallbackMode = mode
+	}
+}
+
+// WithParallelCallbacks makes the session invoke the EventCallback from
+// @workers goroutines concurrently (CallbackParallel mode).
+func WithParallelCallbacks(workers int) Option {
+	return func(cfg *SessionOptions) {
+		cfg.CallbackMode = CallbackParallel
+		cfg.CallbackWorkers = workers
+	}
+}
+
+// WithSecurityContext makes the session be created and controlled under the
+// @token, e.g. a token of a privileged broker process. The token should be
+// opened with TOKEN_QUERY and TOKEN_DUPLICATE (TOKEN_IMPERSONATE for
+// impersonation tokens) access rights.
+func WithSecurityContext(token windows.Token) Option {
+	return func(cfg *SessionOptions) {
+		cfg.SecurityContext = token
+	}
+}
+
+// WithLabels adds @labels to the session Labels. Labels with the same keys
+// set by previous options are overridden.
+func WithLabels(labels map[string]string) Option {
+	return func(cfg *SessionOptions) {
+		if cfg.Labels == nil {
+			cfg.Labels = make(map[string]string, len(labels))
+		}
+		for k, v := range labels {
+			cfg.Labels[k] = v
+		}
+	}
+}
+
+// WithRetry makes NewSession retry StartTrace failures caused by a temporary
+// lack of system resources (e.g. under memory pressure) according to
+// @policy, so long-running agents self-heal instead of failing startup.
+// Take a look at DefaultRetryPolicy.
+func WithRetry(policy RetryPolicy) Option {
+	return func(cfg *SessionOptions) {
+		cfg.Retry = policy
+	}
+}
+
+// WithAutoRestart makes the session survive being stopped externally, e.g.
+// by an administrator running `logman stop`. Without it `.Process` returns
+// and events silently end then. With it the session is recreated (retried
+// according to WithRetry policy) with the current options, providers are
+// re-enabled and `.Process` goes on; @policy.OnStatus is notified about
+// every restart. Events logged while the session is down are lost.
+func WithAutoRestart(policy RestartPolicy) Option {
+	return func(cfg *SessionOptions) {
+		cfg.AutoRestart = &policy
+	}
+}
+
 // TraceLevel represents provider-defined value that specifies the level of
 // detail included in the event. Higher levels imply that you get lower
 // levels as well.
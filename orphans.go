@@ -0,0 +1,119 @@
+//+build windows
+
+package etw
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// stillActive is STILL_ACTIVE exit code of a running process.
+const stillActive = 259
+
+// OwnedSessionName returns a session name starting with @prefix and
+// identifying the current process, e.g. "myagent-1234-1d6e1b0c2a4f3e5".
+// Sessions named this way are stopped by CleanupOrphanedSessions once the
+// process exits without closing them.
+//
+// The name holds both the process ID and the process creation time, so
+// sessions aren't taken for live ones if the process ID is reused.
+func OwnedSessionName(prefix string) (string, error) {
+	pid := windows.GetCurrentProcessId()
+	created, err := processCreationTime(windows.CurrentProcess())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%d-%x", prefix, pid, created), nil
+}
+
+// CleanupOrphanedSessions stops sessions named by OwnedSessionName with the
+// given @prefix whose owning process no longer exists. ETW sessions outlive
+// processes until reboot, so it's worth to be called on the application
+// startup to clean up after its crashed runs.
+//
+// Returns names of the stopped sessions. Sessions that failed to stop are
+// reported by the error, others are stopped anyway.
+func CleanupOrphanedSessions(prefix string) ([]string, error) {
+	sessions, err := ListSessions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions; %w", err)
+	}
+
+	var (
+		stopped []string
+		errs    []string
+	)
+	for _, props := range sessions {
+		pid, created, ok := parseOwnedSessionName(props.SessionName, prefix)
+		if !ok || processAlive(pid, created) {
+			continue
+		}
+		if err := KillSession(props.SessionName); err != nil {
+			errs = append(errs, fmt.Sprintf("%q: %s", props.SessionName, err))
+			continue
+		}
+		stopped = append(stopped, props.SessionName)
+	}
+	if len(errs) != 0 {
+		return stopped, fmt.Errorf("failed to stop sessions: %s", strings.Join(errs, "; "))
+	}
+	return stopped, nil
+}
+
+// parseOwnedSessionName extracts the owning process ID and its creation
+// time from the @name made by OwnedSessionName with @prefix.
+func parseOwnedSessionName(name, prefix string) (pid uint32, created uint64, ok bool) {
+	if !strings.HasPrefix(name, prefix+"-") {
+		return 0, 0, false
+	}
+	parts := strings.Split(strings.TrimPrefix(name, prefix+"-"), "-")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	created, err = strconv.ParseUint(parts[1], 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return uint32(id), created, true
+}
+
+// processAlive returns true if the process @pid created at @created (in
+// FILETIME units) is still running. Processes we can't inspect are
+// considered alive.
+func processAlive(pid uint32, created uint64) bool {
+	process, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	switch {
+	case err == windows.ERROR_INVALID_PARAMETER:
+		return false // No such process.
+	case err != nil:
+		return true
+	}
+	defer windows.CloseHandle(process) //nolint:errcheck
+
+	var code uint32
+	if err := windows.GetExitCodeProcess(process, &code); err == nil && code != stillActive {
+		return false
+	}
+	actual, err := processCreationTime(process)
+	if err != nil {
+		return true
+	}
+	return actual == created // Otherwise the process ID is reused.
+}
+
+// processCreationTime returns a creation time of the @process in FILETIME
+// units.
+func processCreationTime(process windows.Handle) (uint64, error) {
+	var creation, exit, kernel, user windows.Filetime
+	if err := windows.GetProcessTimes(process, &creation, &exit, &kernel, &user); err != nil {
+		return 0, fmt.Errorf("GetProcessTimes failed; %w", err)
+	}
+	return uint64(creation.HighDateTime)<<32 | uint64(creation.LowDateTime), nil
+}
@@ -0,0 +1,89 @@
+//+build windows
+
+package etw
+
+import (
+	"sync"
+)
+
+// ParsedEvent is a self-contained copy of an Event that is valid outside of
+// EventCallback, e.g. to be passed to other goroutines for processing.
+//
+// Copying every event is dominated by allocations on high event rates, so
+// ParsedEvent objects are pooled: call `.Release` when done with the event
+// to let its memory be reused. ParsedEvent MUST NOT be used after Release.
+type ParsedEvent struct {
+	Header       EventHeader
+	Properties   map[string]interface{}
+	ExtendedInfo ExtendedEventInfo
+
+	// Err is an error occurred while parsing event properties. Properties
+	// are empty then.
+	Err error
+}
+
+//nolint:gochecknoglobals
+var parsedEventPool = sync.Pool{
+	New: func() interface{} {
+		return &ParsedEvent{Properties: make(map[string]interface{})}
+	},
+}
+
+// NewParsedEvent copies @e into a ParsedEvent taken from the pool parsing its
+// properties according to @options. Property parsing errors are stored in
+// ParsedEvent.Err. Like other Event methods it's valid only inside
+// EventCallback.
+func NewParsedEvent(e *Event, options ...ParseOption) *ParsedEvent {
+	var cfg ParseOptions
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	pe := parsedEventPool.Get().(*ParsedEvent)
+	pe.fill(e, cfg, "NewParsedEvent")
+	return pe
+}
+
+// fill copies @e into the ParsedEvent parsing its properties according to
+// @cfg. @method names the caller for Event misuse reports.
+func (pe *ParsedEvent) fill(e *Event, cfg ParseOptions, method string) {
+	pe.Header = e.Header
+	if err := e.checkRecord(method); err != nil {
+		pe.Err = err
+		return
+	}
+	pe.ExtendedInfo = e.ExtendedInfo()
+	if _, err := e.eventProperties(cfg, pe.Properties); err != nil {
+		pe.clearProperties()
+		pe.Err = err
+	}
+}
+
+// Release returns the ParsedEvent to the pool. Properties map is reused by
+// subsequent events, so don't keep references to it either.
+func (pe *ParsedEvent) Release() {
+	pe.clearProperties()
+	pe.Header = EventHeader{}
+	pe.ExtendedInfo = ExtendedEventInfo{}
+	pe.Err = nil
+	parsedEventPool.Put(pe)
+}
+
+// clearProperties empties the Properties map keeping its memory.
+func (pe *ParsedEvent) clearProperties() {
+	for k := range pe.Properties {
+		delete(pe.Properties, k)
+	}
+}
+
+// ChannelCallback returns an EventCallback that sends every event to @ch as
+// a ParsedEvent parsed according to @options. The receiver owns the sent
+// events and should `.Release` them after use.
+//
+// The callback blocks while @ch is full, that makes ETW buffer events and
+// eventually lose them if the receiver is too slow.
+func ChannelCallback(ch chan<- *ParsedEvent, options ...ParseOption) EventCallback {
+	return func(e *Event) {
+		ch <- NewParsedEvent(e, options...)
+	}
+}
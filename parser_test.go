@@ -0,0 +1,364 @@
+// +build windows
+
+package etw
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows"
+)
+
+// TestParserBounds ensures that properties parser handles truncated payloads
+// gracefully: returns an error instead of reading outside of the event data.
+func TestParserBounds(t *testing.T) {
+	schema := buildTLSchema("BoundsEvent",
+		tlField{name: "string", inType: tlInUnicodeString},
+		tlField{name: "uint32", inType: tlInUInt32},
+		tlField{name: "array", inType: tlInUInt16 | tlVCount},
+	)
+
+	var payload []byte
+	appendUint := func(v uint32, size int) {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, v)
+		payload = append(payload, b[:size]...)
+	}
+	for _, c := range utf16.Encode([]rune("ab\x00")) {
+		appendUint(uint32(c), 2)
+	}
+	appendUint(5, 4)
+	appendUint(2, 2) // Array count.
+	appendUint(1, 2)
+	appendUint(2, 2)
+
+	properties, err := fuzzParse(schema, payload)
+	require.NoError(t, err, "Failed to parse a valid event")
+	assert.Equal(t, map[string]interface{}{
+		"string": "ab",
+		"uint32": "5",
+		"array":  []interface{}{"1", "2"},
+	}, properties, "Unexpected properties parsed")
+
+	properties, err = fuzzParse(schema, payload, HideSchemaArtifacts(false))
+	require.NoError(t, err, "Failed to parse a valid event with schema artifacts")
+	assert.Equal(t, "2", properties["array.Count"], "Count artifact is not returned")
+	properties, err = fuzzParse(schema, payload, HideSchemaArtifacts(false), HideSchemaArtifacts(true))
+	require.NoError(t, err, "Failed to parse a valid event hiding schema artifacts")
+	assert.NotContains(t, properties, "array.Count", "Count artifact is not hidden")
+
+	for i := 0; i < len(payload); i++ {
+		_, err := fuzzParse(schema, payload[:i])
+		assert.Error(t, err, "Expected an error parsing payload truncated to %d bytes", i)
+	}
+}
+
+// TestParseLimits ensures that events exceeding parse limits are rejected with LimitError.
+func TestParseLimits(t *testing.T) {
+	schema := buildTLSchema("LimitsEvent",
+		tlField{name: "string", inType: tlInUnicodeString},
+		tlField{name: "array", inType: tlInUInt16 | tlVCount},
+		tlField{name: "outer", inType: tlInStruct | tlOutFollows, outType: 1},
+		tlField{name: "inner", inType: tlInStruct | tlOutFollows, outType: 1},
+		tlField{name: "uint32", inType: tlInUInt32},
+	)
+	var payload []byte
+	for _, c := range utf16.Encode([]rune("abcd\x00")) {
+		payload = append(payload, byte(c), byte(c>>8))
+	}
+	payload = append(payload, 3, 0, 1, 0, 2, 0, 3, 0) // Array of 3 elements.
+	payload = append(payload, 7, 0, 0, 0)
+
+	_, err := fuzzParse(schema, payload, WithParseLimits(ParseLimits{
+		MaxProperties:   6, // Including "array.Count".
+		MaxArrayLength:  3,
+		MaxStringLength: 4,
+		MaxStructDepth:  2,
+	}))
+	require.NoError(t, err, "Failed to parse event within limits")
+
+	for _, tc := range []struct {
+		limits ParseLimits
+		limit  string
+	}{
+		{ParseLimits{MaxProperties: 5}, "MaxProperties"},
+		{ParseLimits{MaxArrayLength: 2}, "MaxArrayLength"},
+		{ParseLimits{MaxStringLength: 3}, "MaxStringLength"},
+		{ParseLimits{MaxStructDepth: 1}, "MaxStructDepth"},
+	} {
+		_, err := fuzzParse(schema, payload, WithParseLimits(tc.limits))
+		var limitErr LimitError
+		require.True(t, errors.As(err, &limitErr), "Expected LimitError for %s, got %v", tc.limit, err)
+		assert.Equal(t, tc.limit, limitErr.Limit)
+	}
+
+	// Strings are measured in UTF-16 code units, so a surrogate pair counts
+	// twice.
+	schema = buildTLSchema("SurrogateEvent", tlField{name: "string", inType: tlInUnicodeString})
+	payload = nil
+	for _, c := range utf16.Encode([]rune("a\U0001F600\x00")) {
+		payload = append(payload, byte(c), byte(c>>8))
+	}
+	_, err = fuzzParse(schema, payload, WithParseLimits(ParseLimits{MaxStringLength: 3}))
+	require.NoError(t, err, "Failed to parse surrogate pair within limits")
+	_, err = fuzzParse(schema, payload, WithParseLimits(ParseLimits{MaxStringLength: 2}))
+	var limitErr LimitError
+	require.True(t, errors.As(err, &limitErr), "Expected LimitError for surrogate pair, got %v", err)
+	assert.Equal(t, 3, limitErr.Value)
+}
+
+// TestParserBestEffort ensures that broken properties don't fail the whole event in best-effort mode.
+func TestParserBestEffort(t *testing.T) {
+	schema := buildTLSchema("BestEffortEvent",
+		tlField{name: "uint32", inType: tlInUInt32},
+		tlField{name: "string", inType: tlInUnicodeString},
+	)
+	payload := []byte{5, 0, 0, 0, 'a'} // Truncated string.
+
+	_, err := fuzzParse(schema, payload)
+	require.Error(t, err, "Expected an error in strict mode")
+
+	properties, err := fuzzParse(schema, payload, WithBestEffortParsing())
+	require.NoError(t, err, "Failed to parse event in best-effort mode")
+	assert.Equal(t, "5", properties["uint32"], "Failed to parse valid property")
+
+	var parseErr ParseError
+	require.IsType(t, parseErr, properties["string"], "Expected ParseError for broken property")
+	parseErr = properties["string"].(ParseError)
+	assert.Equal(t, "string", parseErr.Property)
+}
+
+// TestPropertyErrorStats ensures that formatting failures are counted.
+func TestPropertyErrorStats(t *testing.T) {
+	ResetPropertyErrorStats()
+	schema := buildTLSchema("StatsEvent", tlField{name: "string", inType: tlInUnicodeString})
+
+	_, err := fuzzParse(schema, []byte{'a'})
+	require.Error(t, err, "Expected an error parsing truncated string")
+
+	stats := PropertyErrorStats()
+	assert.Equal(t, uint64(1), stats[PropertyErrorKey{InType: tlInUnicodeString}], "Unexpected stats %v", stats)
+
+	// Failures caught before TdhFormatProperty are counted too.
+	schema = buildTLSchema("StatsEvent", tlField{name: "uint32", inType: tlInUInt32})
+	_, err = fuzzParse(schema, []byte{1, 0})
+	require.Error(t, err, "Expected an error parsing truncated integer")
+
+	stats = PropertyErrorStats()
+	assert.Equal(t, uint64(1), stats[PropertyErrorKey{InType: tlInUInt32}], "Unexpected stats %v", stats)
+}
+
+// TestHasProperty ensures that schema properties could be queried without parsing.
+func TestHasProperty(t *testing.T) {
+	schema := buildTLSchema("VersionedEvent",
+		tlField{name: "string", inType: tlInUnicodeString},
+		tlField{name: "struct", inType: tlInStruct | tlOutFollows, outType: 1},
+		tlField{name: "nested", inType: tlInUInt32},
+	)
+	withSyntheticEvent(schema, nil, func(e *Event) {
+		for name, expected := range map[string]bool{
+			"string":  true,
+			"struct":  true,
+			"nested":  false, // Not a top-level property.
+			"missing": false,
+		} {
+			has, err := e.HasProperty(name)
+			require.NoError(t, err, "Failed to query property %q", name)
+			assert.Equal(t, expected, has, "Unexpected result for property %q", name)
+		}
+	})
+}
+
+// TestDecodeCounted ensures that counted values are decoded the way TDH does it.
+func TestDecodeCounted(t *testing.T) {
+	value, consumed, err := decodeCounted(tdhInTypeCountedString, []byte{4, 0, 'h', 0, 'i', 0, 0xFF})
+	require.NoError(t, err)
+	assert.Equal(t, "hi", value)
+	assert.Equal(t, 6, consumed)
+
+	value, _, err = decodeCounted(tdhInTypeCountedAnsiString, []byte{3, 0, 'h', 'i', 0})
+	require.NoError(t, err)
+	assert.Equal(t, "hi", value)
+
+	value, _, err = decodeCounted(tdhInTypeCountedBinary, []byte{2, 0, 0xAB, 0x01})
+	require.NoError(t, err)
+	assert.Equal(t, "0xAB01", value)
+
+	_, _, err = decodeCounted(tdhInTypeCountedString, []byte{4, 0, 'h'})
+	assert.True(t, errors.Is(err, ErrMalformedEvent), "Expected malformed event error, got %v", err)
+}
+
+// TestCountedPaths ensures that TDH and Go decoding of counted values agree,
+// so events are formatted the same way on any Windows version.
+func TestCountedPaths(t *testing.T) {
+	if !tdhSupportsCountedTypes() {
+		t.Skip("TDH of the system doesn't support counted in-types")
+	}
+	for _, tc := range []struct {
+		inType uintptr
+		data   []byte
+	}{
+		{inType: tdhInTypeCountedString, data: []byte{4, 0, 'h', 0, 'i', 0, 0xFF}},
+		{inType: tdhInTypeCountedAnsiString, data: []byte{3, 0, 'h', 'i', 0}},
+		{inType: tdhInTypeCountedBinary, data: []byte{2, 0, 0xAB, 0x01}},
+	} {
+		expected, expectedConsumed, err := formatCountedTDH(tc.inType, tc.data)
+		require.NoError(t, err, "TDH failed to format in-type %d", tc.inType)
+		value, consumed, err := decodeCounted(tc.inType, tc.data)
+		require.NoError(t, err, "Failed to decode in-type %d", tc.inType)
+		assert.Equal(t, expected, value, "Value of in-type %d differs from TDH", tc.inType)
+		assert.Equal(t, expectedConsumed, consumed, "Size of in-type %d differs from TDH", tc.inType)
+	}
+}
+
+// TestBinaryFormat ensures that binary properties are encoded as requested.
+func TestBinaryFormat(t *testing.T) {
+	schema := buildTLSchema("BinaryEvent",
+		tlField{name: "blob", inType: tlInBinary},
+		tlField{name: "other", inType: tlInBinary},
+	)
+	payload := []byte{3, 0, 0xAB, 0x01, 0xFF, 2, 0, 0x01, 0x02}
+
+	properties, err := fuzzParse(schema, payload)
+	require.NoError(t, err, "Failed to parse event")
+	assert.Equal(t, "0xAB01FF", properties["blob"], "Unexpected default format")
+
+	properties, err = fuzzParse(schema, payload,
+		WithBinaryFormat(BinaryFormat{Encoding: BinaryBase64}),
+		WithPropertyBinaryFormat("other", BinaryFormat{Encoding: BinaryBytes}))
+	require.NoError(t, err, "Failed to parse event")
+	assert.Equal(t, "qwH/", properties["blob"], "Unexpected global format")
+	assert.Equal(t, []byte{0x01, 0x02}, properties["other"], "Unexpected property format")
+
+	properties, err = fuzzParse(schema, payload, WithBinaryFormat(BinaryFormat{Encoding: BinaryHex, MaxSize: 2}))
+	require.NoError(t, err, "Failed to parse event")
+	assert.Equal(t, "ab01", properties["blob"], "Value is not truncated")
+	assert.Equal(t, "0102", properties["other"], "Short value is changed")
+
+	properties, err = fuzzParse(schema, payload, WithBinaryFormat(BinaryFormat{MaxSize: 1}))
+	require.NoError(t, err, "Failed to parse event")
+	assert.Equal(t, "0xAB", properties["blob"], "Value is not truncated")
+}
+
+// TestInvariantFormatting ensures that locale-dependent values are formatted
+// the same way regardless of the system locale.
+func TestInvariantFormatting(t *testing.T) {
+	schema := buildTLSchema("LocaleEvent",
+		tlField{name: "float", inType: tdhInTypeFloat},
+		tlField{name: "double", inType: tdhInTypeDouble},
+		tlField{name: "bool", inType: tdhInTypeBoolean},
+		tlField{name: "filetime", inType: tdhInTypeFileTime},
+		tlField{name: "systemtime", inType: tdhInTypeSystemTime},
+	)
+	payload := make([]byte, 0, 40)
+	payload = appendUint32(payload, math.Float32bits(1.5))
+	payload = appendUint64(payload, math.Float64bits(-1234.25))
+	payload = appendUint32(payload, 1)
+	payload = appendUint64(payload, 132567987067890000) // 2021-02-03T04:05:06.789Z
+	for _, field := range []uint16{2021, 2, 3, 3, 4, 5, 6, 789} {
+		payload = append(payload, byte(field), byte(field>>8))
+	}
+
+	properties, err := fuzzParse(schema, payload, WithInvariantFormatting())
+	require.NoError(t, err, "Failed to parse event")
+	assert.Equal(t, map[string]interface{}{
+		"float":      "1.5",
+		"double":     "-1234.25",
+		"bool":       "true",
+		"filetime":   "2021-02-03T04:05:06.789Z",
+		"systemtime": "2021-02-03T04:05:06.789Z",
+	}, properties)
+
+	// Invalid timestamps are left to TDH.
+	_, ok := formatInvariant(tdhInTypeSystemTime, make([]byte, 16))
+	assert.False(t, ok, "Zero SYSTEMTIME is formatted")
+}
+
+// appendUint32 appends little-endian @v to @buf.
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// appendUint64 appends little-endian @v to @buf.
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v)), uint32(v>>32))
+}
+
+// buildMapInfo encodes EVENT_MAP_INFO with @flags mapping @values to @names.
+func buildMapInfo(flags uint32, values []uint32, names []string) []byte {
+	buf := make([]byte, 16+8*len(values))
+	binary.LittleEndian.PutUint32(buf[4:], flags)
+	binary.LittleEndian.PutUint32(buf[8:], uint32(len(values)))
+	for i, value := range values {
+		entry := buf[16+8*i:]
+		binary.LittleEndian.PutUint32(entry, uint32(len(buf)))
+		binary.LittleEndian.PutUint32(entry[4:], value)
+		for _, c := range utf16.Encode([]rune(names[i] + " \x00")) { // Manifest names are space padded.
+			buf = append(buf, byte(c), byte(c>>8))
+		}
+	}
+	return buf
+}
+
+// TestDecodeMapNames ensures that value maps and bitmaps are decoded.
+func TestDecodeMapNames(t *testing.T) {
+	valueMap := buildMapInfo(1, []uint32{1, 2}, []string{"Started", "Stopped"})
+	assert.Equal(t, []string{"Stopped"}, decodeMapNames(valueMap, 2))
+	assert.Empty(t, decodeMapNames(valueMap, 3), "Unknown value is mapped")
+
+	bitmap := buildMapInfo(eventMapInfoFlagManifestBitmap, []uint32{1, 2, 4}, []string{"Read", "Write", "Execute"})
+	assert.Equal(t, []string{"Read", "Execute"}, decodeMapNames(bitmap, 5))
+	assert.Empty(t, decodeMapNames(bitmap, 0))
+
+	assert.Empty(t, decodeMapNames(valueMap[:20], 1), "Truncated map is decoded")
+
+	patternMap := buildMapInfo(eventMapInfoFlagManifestPatternMap, []uint32{1}, []string{"Pattern"})
+	assert.False(t, isIntegerMap(patternMap), "Pattern map is decoded")
+	assert.Empty(t, decodeMapNames(patternMap, 1), "Pattern map is decoded")
+
+	stringMap := buildMapInfo(0x8, []uint32{1}, []string{"String"}) // EVENTMAP_INFO_FLAG_WBEM_VALUEMAP
+	binary.LittleEndian.PutUint32(stringMap[12:], 1)                // EVENTMAP_ENTRY_VALUETYPE_STRING
+	assert.False(t, isIntegerMap(stringMap), "String map is decoded")
+	assert.Empty(t, decodeMapNames(stringMap, 1), "String map is decoded")
+}
+
+// TestCustomValueMap ensures that registered value maps are used for
+// properties having no map info.
+func TestCustomValueMap(t *testing.T) {
+	provider := windows.GUID{} // Synthetic events have zero provider ID.
+	RegisterValueMap(provider, "status", ValueMap{Values: map[uint64]string{5: "Access denied"}})
+	RegisterValueMap(provider, "flags", ValueMap{Values: map[uint64]string{1: "Read", 2: "Write"}, Bitmap: true})
+	RegisterValueMap(provider, "code", ValueMap{Values: map[uint64]string{1: "One"}})
+	defer RegisterValueMap(provider, "status", ValueMap{})
+	defer RegisterValueMap(provider, "flags", ValueMap{})
+	defer RegisterValueMap(provider, "code", ValueMap{})
+
+	schema := buildTLSchema("MapEvent",
+		tlField{name: "status", inType: tlInUInt32},
+		tlField{name: "flags", inType: tlInUInt32},
+		tlField{name: "other", inType: tlInUInt32},
+		tlField{name: "code", inType: tlInInt32},
+	)
+	data := []byte{5, 0, 0, 0, 3, 0, 0, 0, 7, 0, 0, 0, 0xFE, 0xFF, 0xFF, 0xFF}
+
+	properties, err := fuzzParse(schema, data)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"status": "Access denied",
+		"flags":  "Read | Write",
+		"other":  "7",
+		"code":   "-2",
+	}, properties)
+
+	properties, err = fuzzParse(schema, data, WithMapValues())
+	require.NoError(t, err)
+	assert.Equal(t, MapValue{Value: 5, Names: []string{"Access denied"}, Formatted: "Access denied"}, properties["status"])
+	assert.Equal(t, MapValue{Value: 3, Names: []string{"Read", "Write"}, Formatted: "Read | Write"}, properties["flags"])
+	assert.Equal(t, "7", properties["other"])
+	assert.Equal(t, MapValue{Value: 0xFFFFFFFFFFFFFFFE, Formatted: "-2"}, properties["code"])
+}
@@ -0,0 +1,26 @@
+//+build windows
+
+package perfcounter
+
+import (
+	"github.com/bi-zone/etw"
+	"github.com/bi-zone/etw/expr"
+)
+
+// ObserveEvent accounts @e. Filters and fields address the event as
+// expr.EventEnv does: `Header.<name>` and `Properties.<name>`. Properties
+// are parsed only if some counter refers to them. Like
+// etw.Event.EventProperties it's valid only inside etw.EventCallback.
+func (b *Bridge) ObserveEvent(e *etw.Event, options ...etw.ParseOption) error {
+	return b.Observe(&expr.EventEnv{Event: e, Options: options})
+}
+
+// EventCallback returns an etw.EventCallback that passes every event to
+// ObserveEvent. Errors are passed to @onError if it's not nil.
+func (b *Bridge) EventCallback(onError func(err error)) etw.EventCallback {
+	return func(e *etw.Event) {
+		if err := b.ObserveEvent(e); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package perfcounter
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// ManifestOptions describes a PerfLib V2 provider and its counter set.
+type ManifestOptions struct {
+	// ProviderName and ProviderGUID identify the counters provider. GUIDs are
+	// in the registry form, e.g. "{0e1e1e1e-...}".
+	ProviderName string
+	ProviderGUID string
+
+	// ApplicationIdentity is a name of the binary publishing counters.
+	ApplicationIdentity string
+
+	// CounterSetName and CounterSetGUID identify the counter set, i.e. the
+	// counters category shown by dashboards.
+	CounterSetName string
+	CounterSetGUID string
+}
+
+// counterID returns an ID of the @i-th counter in the counter set.
+func counterID(i int) uint32 {
+	return uint32(i + 1)
+}
+
+type manifestCounter struct {
+	ID          uint32 `xml:"id,attr"`
+	URI         string `xml:"uri,attr"`
+	Name        string `xml:"name,attr"`
+	Description string `xml:"description,attr"`
+	Type        string `xml:"type,attr"`
+	DetailLevel string `xml:"detailLevel,attr"`
+}
+
+type manifestCounterSet struct {
+	GUID        string            `xml:"guid,attr"`
+	URI         string            `xml:"uri,attr"`
+	Name        string            `xml:"name,attr"`
+	Description string            `xml:"description,attr"`
+	Instances   string            `xml:"instances,attr"`
+	Counters    []manifestCounter `xml:"counter"`
+}
+
+type manifestProvider struct {
+	ApplicationIdentity string             `xml:"applicationIdentity,attr"`
+	ProviderType        string             `xml:"providerType,attr"`
+	ProviderGUID        string             `xml:"providerGuid,attr"`
+	ProviderName        string             `xml:"providerName,attr"`
+	CounterSet          manifestCounterSet `xml:"counterSet"`
+}
+
+type manifestCounters struct {
+	XMLName       xml.Name         `xml:"http://schemas.microsoft.com/win/2005/12/counters counters"`
+	SchemaVersion string           `xml:"schemaVersion,attr"`
+	Provider      manifestProvider `xml:"provider"`
+}
+
+type instrumentationManifest struct {
+	XMLName  xml.Name         `xml:"http://schemas.microsoft.com/win/2004/08/events instrumentationManifest"`
+	Counters manifestCounters `xml:"instrumentation>counters"`
+}
+
+// Manifest renders an instrumentation manifest registering the counters of
+// @specs (in the same order as they are published) for `lodctr /m`.
+func Manifest(opts ManifestOptions, specs []Spec) ([]byte, error) {
+	for i, spec := range specs {
+		if err := spec.validate(); err != nil {
+			return nil, fmt.Errorf("invalid counter %d; %w", i, err)
+		}
+	}
+	uri := func(name string) string {
+		return strings.ReplaceAll(opts.ProviderName+"."+name, " ", "")
+	}
+
+	set := manifestCounterSet{
+		GUID:        opts.CounterSetGUID,
+		URI:         uri(opts.CounterSetName),
+		Name:        opts.CounterSetName,
+		Description: opts.CounterSetName,
+		Instances:   "single",
+	}
+	for i, spec := range specs {
+		c := manifestCounter{
+			ID:          counterID(i),
+			URI:         uri(opts.CounterSetName + "." + spec.Name),
+			Name:        spec.Name,
+			Description: spec.Description,
+			Type:        "perf_counter_large_rawcount",
+			DetailLevel: "standard",
+		}
+		if c.Description == "" {
+			c.Description = spec.Name
+		}
+		if spec.Kind == Rate {
+			c.Type = "perf_counter_bulk_count"
+		}
+		set.Counters = append(set.Counters, c)
+	}
+
+	out, err := xml.MarshalIndent(instrumentationManifest{
+		Counters: manifestCounters{
+			SchemaVersion: "1.1",
+			Provider: manifestProvider{
+				ApplicationIdentity: opts.ApplicationIdentity,
+				ProviderType:        "userMode",
+				ProviderGUID:        opts.ProviderGUID,
+				ProviderName:        opts.ProviderName,
+				CounterSet:          set,
+			},
+		},
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to render manifest; %w", err)
+	}
+	return append([]byte(xml.Header), out...), nil
+}
@@ -0,0 +1,271 @@
+// Package perfcounter converts events streams into rate and gauge counters,
+// e.g. "process starts per second" or "last reported queue length", and
+// publishes them as Windows performance counters (PerfLib V2), so existing
+// perf-counter dashboards could display ETW-derived metrics:
+//
+//		b, err := perfcounter.New([]perfcounter.Spec{
+//			{Name: "Process starts", Kind: perfcounter.Rate, Filter: "Header.ID == 1"},
+//			{Name: "Queue length", Kind: perfcounter.Gauge, Field: "Properties.QueueLength"},
+//		})
+//		...
+//		p, err := perfcounter.NewPerfLibPublisher(providerGUID, counterSetGUID, b.Specs())
+//		...
+//		go b.Run(ctx, time.Second, p)
+//		err = session.Process(b.EventCallback(nil))
+//
+// PerfLib counter sets should be registered in the system from a manifest
+// (take a look at Manifest) with `lodctr /m:<manifest>` before publishing.
+//
+// The core of the package is platform independent, the events adapter and
+// the PerfLib publisher are built on top of it.
+package perfcounter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bi-zone/etw/expr"
+)
+
+// Kind is a kind of the counter.
+type Kind int
+
+const (
+	// Rate counts matching events, it's reported as events per second.
+	Rate Kind = iota + 1
+	// Gauge holds the last Field value of matching events.
+	Gauge
+)
+
+// Spec describes a single counter.
+type Spec struct {
+	// Name and Description identify the counter in dashboards.
+	Name        string
+	Description string
+
+	Kind Kind
+
+	// Filter is an expr expression selecting counted events. Empty Filter
+	// selects all events.
+	Filter string
+
+	// Field is a dotted path of the numeric value of Gauge counters, e.g.
+	// `Properties.QueueLength`. Strings are parsed as numbers.
+	Field string
+}
+
+func (s Spec) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("empty name")
+	}
+	switch s.Kind {
+	case Rate:
+	case Gauge:
+		if s.Field == "" {
+			return fmt.Errorf("gauge %q has no field", s.Name)
+		}
+	default:
+		return fmt.Errorf("counter %q has unknown kind %d", s.Name, s.Kind)
+	}
+	return nil
+}
+
+// Sample is a value of a single counter.
+type Sample struct {
+	Name string
+	Kind Kind
+
+	// Value is events per second since the previous snapshot for Rate
+	// counters and the last Field value for Gauge ones.
+	Value float64
+
+	// Total is a number of events counted by Rate counters since the
+	// Bridge creation.
+	Total uint64
+}
+
+// Publisher exports counter samples, e.g. as performance counters.
+type Publisher interface {
+	Publish(samples []Sample) error
+}
+
+// PublisherFunc is an adapter to use ordinary functions as Publisher.
+type PublisherFunc func(samples []Sample) error
+
+// Publish calls f(samples).
+func (f PublisherFunc) Publish(samples []Sample) error {
+	return f(samples)
+}
+
+// counter is a Spec with its compiled filter and split field path.
+type counter struct {
+	Spec
+	filter *expr.Program
+	field  []string
+}
+
+// Bridge computes counters over the events stream. It's safe for concurrent
+// use.
+type Bridge struct {
+	counters []counter
+
+	mu         sync.Mutex
+	totals     []uint64
+	gauges     []float64
+	lastTotals []uint64
+	lastTime   time.Time
+}
+
+// New creates a Bridge computing counters described by @specs.
+func New(specs []Spec) (*Bridge, error) {
+	counters := make([]counter, len(specs))
+	for i, spec := range specs {
+		if err := spec.validate(); err != nil {
+			return nil, fmt.Errorf("invalid counter %d; %w", i, err)
+		}
+		c := counter{Spec: spec}
+		if spec.Filter != "" {
+			filter, err := expr.Compile(spec.Filter)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter of counter %q; %w", spec.Name, err)
+			}
+			c.filter = filter
+		}
+		if spec.Field != "" {
+			c.field = strings.Split(spec.Field, ".")
+		}
+		counters[i] = c
+	}
+	return &Bridge{
+		counters:   counters,
+		totals:     make([]uint64, len(specs)),
+		gauges:     make([]float64, len(specs)),
+		lastTotals: make([]uint64, len(specs)),
+	}, nil
+}
+
+// Specs returns specs of the Bridge counters.
+func (b *Bridge) Specs() []Spec {
+	specs := make([]Spec, len(b.counters))
+	for i, c := range b.counters {
+		specs[i] = c.Spec
+	}
+	return specs
+}
+
+// Observe accounts an event exposed by @env. Counters whose filters fail to
+// evaluate are skipped, the first error is returned. Gauge fields that are
+// missing or not numeric leave gauges as is.
+func (b *Bridge) Observe(env expr.Env) error {
+	var firstErr error
+	for i, c := range b.counters {
+		if c.filter != nil {
+			ok, err := c.filter.Eval(env)
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("failed to evaluate filter of counter %q; %w", c.Name, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		switch c.Kind {
+		case Rate:
+			b.mu.Lock()
+			b.totals[i]++
+			b.mu.Unlock()
+		case Gauge:
+			raw, ok := env.Lookup(c.field)
+			if !ok {
+				continue
+			}
+			if v, ok := toFloat(raw); ok {
+				b.mu.Lock()
+				b.gauges[i] = v
+				b.mu.Unlock()
+			}
+		}
+	}
+	return firstErr
+}
+
+// Snapshot returns samples of all counters at @now. Rates are computed over
+// the time since the previous snapshot, so the first snapshot reports zero
+// rates.
+func (b *Bridge) Snapshot(now time.Time) []Sample {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	elapsed := now.Sub(b.lastTime).Seconds()
+	samples := make([]Sample, len(b.counters))
+	for i, c := range b.counters {
+		s := Sample{Name: c.Name, Kind: c.Kind}
+		switch c.Kind {
+		case Rate:
+			s.Total = b.totals[i]
+			if !b.lastTime.IsZero() && elapsed > 0 {
+				s.Value = float64(b.totals[i]-b.lastTotals[i]) / elapsed
+			}
+			b.lastTotals[i] = b.totals[i]
+		case Gauge:
+			s.Value = b.gauges[i]
+		}
+		samples[i] = s
+	}
+	b.lastTime = now
+	return samples
+}
+
+// Run publishes counter snapshots to @p every @interval until @ctx is done
+// or publishing fails.
+func (b *Bridge) Run(ctx context.Context, interval time.Duration, p Publisher) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			if err := p.Publish(b.Snapshot(now)); err != nil {
+				return fmt.Errorf("failed to publish counters; %w", err)
+			}
+		}
+	}
+}
+
+// toFloat converts numbers and numeric strings (TDH renders properties as
+// strings) to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case string:
+		if n, err := strconv.ParseInt(v, 0, 64); err == nil {
+			return float64(n), true
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
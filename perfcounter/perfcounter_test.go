@@ -0,0 +1,85 @@
+package perfcounter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/expr"
+	"github.com/bi-zone/etw/perfcounter"
+)
+
+// event makes an env of an event with @id and @properties.
+func event(id int, properties map[string]interface{}) expr.MapEnv {
+	return expr.MapEnv{
+		"Header":     map[string]interface{}{"ID": id},
+		"Properties": properties,
+	}
+}
+
+func TestBridge(t *testing.T) {
+	b, err := perfcounter.New([]perfcounter.Spec{
+		{Name: "Starts", Kind: perfcounter.Rate, Filter: "Header.ID == 1"},
+		{Name: "All", Kind: perfcounter.Rate},
+		{Name: "Queue", Kind: perfcounter.Gauge, Filter: "Header.ID == 2", Field: "Properties.Queue"},
+	})
+	require.NoError(t, err)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := b.Snapshot(start)
+	require.Len(t, samples, 3)
+	assert.Equal(t, 0.0, samples[0].Value, "first snapshot should report zero rates")
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, b.Observe(event(1, nil)))
+	}
+	require.NoError(t, b.Observe(event(2, map[string]interface{}{"Queue": "17"})))
+	require.NoError(t, b.Observe(event(2, map[string]interface{}{"Queue": "not a number"})))
+
+	samples = b.Snapshot(start.Add(2 * time.Second))
+	assert.Equal(t, []perfcounter.Sample{
+		{Name: "Starts", Kind: perfcounter.Rate, Value: 2, Total: 4},
+		{Name: "All", Kind: perfcounter.Rate, Value: 3, Total: 6},
+		{Name: "Queue", Kind: perfcounter.Gauge, Value: 17},
+	}, samples)
+
+	samples = b.Snapshot(start.Add(3 * time.Second))
+	assert.Equal(t, 0.0, samples[0].Value)
+	assert.Equal(t, uint64(4), samples[0].Total)
+	assert.Equal(t, 17.0, samples[2].Value, "gauge should keep the last value")
+}
+
+func TestInvalidSpecs(t *testing.T) {
+	for _, specs := range [][]perfcounter.Spec{
+		{{Kind: perfcounter.Rate}},
+		{{Name: "Unknown"}},
+		{{Name: "No field", Kind: perfcounter.Gauge}},
+		{{Name: "Bad filter", Kind: perfcounter.Rate, Filter: "Header.ID =="}},
+	} {
+		_, err := perfcounter.New(specs)
+		assert.Error(t, err, "specs %+v", specs)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	manifest, err := perfcounter.Manifest(perfcounter.ManifestOptions{
+		ProviderName:        "Agent",
+		ProviderGUID:        "{11111111-1111-1111-1111-111111111111}",
+		ApplicationIdentity: "agent.exe",
+		CounterSetName:      "Agent events",
+		CounterSetGUID:      "{22222222-2222-2222-2222-222222222222}",
+	}, []perfcounter.Spec{
+		{Name: "Starts", Kind: perfcounter.Rate},
+		{Name: "Queue", Kind: perfcounter.Gauge, Field: "Properties.Queue"},
+	})
+	require.NoError(t, err)
+
+	text := string(manifest)
+	assert.Contains(t, text, `applicationIdentity="agent.exe"`)
+	assert.Contains(t, text, `id="1" uri="Agent.Agentevents.Starts"`)
+	assert.Contains(t, text, `type="perf_counter_bulk_count"`)
+	assert.Contains(t, text, `id="2" uri="Agent.Agentevents.Queue"`)
+	assert.Contains(t, text, `type="perf_counter_large_rawcount"`)
+}
@@ -0,0 +1,171 @@
+//+build windows
+
+package perfcounter
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+//nolint:gochecknoglobals
+var (
+	advapi32                         = windows.NewLazySystemDLL("advapi32.dll")
+	procPerfStartProvider            = advapi32.NewProc("PerfStartProvider")
+	procPerfStopProvider             = advapi32.NewProc("PerfStopProvider")
+	procPerfSetCounterSetInfo        = advapi32.NewProc("PerfSetCounterSetInfo")
+	procPerfCreateInstance           = advapi32.NewProc("PerfCreateInstance")
+	procPerfDeleteInstance           = advapi32.NewProc("PerfDeleteInstance")
+	procPerfSetULongLongCounterValue = advapi32.NewProc("PerfSetULongLongCounterValue")
+)
+
+// Counter types of PerfLib counters. Rate counters are published as raw
+// totals and the rate is computed by the consumer.
+const (
+	perfCounterBulkCount     = 0x10410500 // PERF_COUNTER_BULK_COUNT
+	perfCounterLargeRawCount = 0x00010100 // PERF_COUNTER_LARGE_RAWCOUNT
+	perfDetailNovice         = 100        // PERF_DETAIL_NOVICE
+	perfCounterSetSingle     = 0          // PERF_COUNTERSET_SINGLE_INSTANCE
+)
+
+// perfCounterSetInfo is PERF_COUNTERSET_INFO.
+type perfCounterSetInfo struct {
+	CounterSetGUID windows.GUID
+	ProviderGUID   windows.GUID
+	NumCounters    uint32
+	InstanceType   uint32
+}
+
+// perfCounterInfo is PERF_COUNTER_INFO.
+type perfCounterInfo struct {
+	CounterID   uint32
+	Type        uint32
+	Attrib      uint64
+	Size        uint32
+	DetailLevel uint32
+	Scale       int32
+	Offset      uint32
+}
+
+// PerfLibPublisher publishes samples as PerfLib V2 counters of a single
+// instance counter set. The counter set should be registered with a
+// manifest made by Manifest for the same specs.
+type PerfLibPublisher struct {
+	provider windows.Handle
+	instance uintptr
+	specs    []Spec
+}
+
+// NewPerfLibPublisher starts the PerfLib provider @providerGUID and creates
+// an instance of the @counterSetGUID counter set holding counters of @specs.
+// Publisher should be closed via `.Close` after use.
+func NewPerfLibPublisher(providerGUID, counterSetGUID windows.GUID, specs []Spec) (*PerfLibPublisher, error) {
+	p := &PerfLibPublisher{specs: specs}
+	// ULONG PerfStartProvider(LPGUID ProviderGuid, PERFLIBREQUEST ControlCallback, HANDLE *phProvider);
+	r0, _, _ := procPerfStartProvider.Call(
+		uintptr(unsafe.Pointer(&providerGUID)),
+		0,
+		uintptr(unsafe.Pointer(&p.provider)),
+	)
+	if status := windows.Errno(r0); status != windows.ERROR_SUCCESS {
+		return nil, fmt.Errorf("PerfStartProvider failed; %w", status)
+	}
+
+	// PERF_COUNTERSET_INFO is followed by PERF_COUNTER_INFO of every counter.
+	headerSize := int(unsafe.Sizeof(perfCounterSetInfo{}))
+	counterSize := int(unsafe.Sizeof(perfCounterInfo{}))
+	template := make([]uint64, (headerSize+len(specs)*counterSize+7)/8) // 8-byte aligned.
+	*(*perfCounterSetInfo)(unsafe.Pointer(&template[0])) = perfCounterSetInfo{
+		CounterSetGUID: counterSetGUID,
+		ProviderGUID:   providerGUID,
+		NumCounters:    uint32(len(specs)),
+		InstanceType:   perfCounterSetSingle,
+	}
+	for i, spec := range specs {
+		info := perfCounterInfo{
+			CounterID:   counterID(i),
+			Type:        perfCounterLargeRawCount,
+			Size:        8,
+			DetailLevel: perfDetailNovice,
+			Offset:      uint32(8 * i),
+		}
+		if spec.Kind == Rate {
+			info.Type = perfCounterBulkCount
+		}
+		offset := uintptr(headerSize + i*counterSize)
+		*(*perfCounterInfo)(unsafe.Pointer(uintptr(unsafe.Pointer(&template[0])) + offset)) = info
+	}
+	// ULONG PerfSetCounterSetInfo(HANDLE ProviderHandle, PPERF_COUNTERSET_INFO Template, ULONG TemplateSize);
+	r0, _, _ = procPerfSetCounterSetInfo.Call(
+		uintptr(p.provider),
+		uintptr(unsafe.Pointer(&template[0])),
+		uintptr(headerSize+len(specs)*counterSize),
+	)
+	if status := windows.Errno(r0); status != windows.ERROR_SUCCESS {
+		p.stop()
+		return nil, fmt.Errorf("PerfSetCounterSetInfo failed; %w", status)
+	}
+
+	// PPERF_COUNTERSET_INSTANCE PerfCreateInstance(HANDLE ProviderHandle, LPCGUID CounterSetGuid, PCWSTR Name, ULONG Id);
+	name, _ := windows.UTF16PtrFromString("_Default")
+	instance, _, err := procPerfCreateInstance.Call(
+		uintptr(p.provider),
+		uintptr(unsafe.Pointer(&counterSetGUID)),
+		uintptr(unsafe.Pointer(name)),
+		0,
+	)
+	if instance == 0 {
+		p.stop()
+		return nil, fmt.Errorf("PerfCreateInstance failed; %w", err)
+	}
+	p.instance = instance
+	return p, nil
+}
+
+// Publish implements Publisher. Rate counters are set to totals, gauges are
+// rounded to integers (negative values are published as zero).
+func (p *PerfLibPublisher) Publish(samples []Sample) error {
+	for i, s := range samples {
+		if i >= len(p.specs) {
+			return fmt.Errorf("sample %d is out of %d counters", i, len(p.specs))
+		}
+		value := s.Total
+		if s.Kind == Gauge {
+			value = uint64(math.Max(0, math.Round(s.Value)))
+		}
+		// ULONG PerfSetULongLongCounterValue(HANDLE Provider, PPERF_COUNTERSET_INSTANCE Instance, ULONG CounterId, ULONGLONG Value);
+		args := append([]uintptr{uintptr(p.provider), p.instance, uintptr(counterID(i))}, ulonglongArgs(value)...)
+		r0, _, _ := procPerfSetULongLongCounterValue.Call(args...)
+		if status := windows.Errno(r0); status != windows.ERROR_SUCCESS {
+			return fmt.Errorf("PerfSetULongLongCounterValue of %q failed; %w", s.Name, status)
+		}
+	}
+	return nil
+}
+
+// Close deletes the counter set instance and stops the provider.
+func (p *PerfLibPublisher) Close() error {
+	// ULONG PerfDeleteInstance(HANDLE Provider, PPERF_COUNTERSET_INSTANCE InstanceBlock);
+	r0, _, _ := procPerfDeleteInstance.Call(uintptr(p.provider), p.instance)
+	p.stop()
+	if status := windows.Errno(r0); status != windows.ERROR_SUCCESS {
+		return fmt.Errorf("PerfDeleteInstance failed; %w", status)
+	}
+	return nil
+}
+
+// ulonglongArgs passes ULONGLONG @v to a syscall. It takes two argument
+// slots on 32-bit systems, the low part goes first.
+func ulonglongArgs(v uint64) []uintptr {
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		return []uintptr{uintptr(v)}
+	}
+	return []uintptr{uintptr(uint32(v)), uintptr(v >> 32)}
+}
+
+// stop stops the provider.
+func (p *PerfLibPublisher) stop() {
+	_, _, _ = procPerfStopProvider.Call(uintptr(p.provider))
+}
@@ -0,0 +1,57 @@
+//+build windows
+
+package etw
+
+// PipelineCallback receives events parsed by a Pipeline. The ParsedEvent
+// is released right after the callback returns, so don't keep references
+// to it or its Properties.
+type PipelineCallback func(pe *ParsedEvent)
+
+// Pipeline decouples parsing of event properties from the thread running
+// ProcessTrace. Its EventCallback only copies raw event records to a queue
+// and returns, while a pool of goroutines parses them with TDH and passes
+// results to the PipelineCallback. ProcessTrace spends no time on parsing
+// and drains ETW buffers faster, so sessions sustain higher event rates on
+// multicore machines:
+//
+//		p := etw.NewPipeline(handle, 0, 1024, etw.WithSchemaCache(cache))
+//		defer p.Close()
+//		err := session.Process(p.Callback())
+//
+// Events are passed to the PipelineCallback concurrently and with no
+// ordering guarantees unless the pipeline has a single worker.
+type Pipeline struct {
+	dispatcher *parallelDispatcher
+}
+
+// NewPipeline starts @workers parser goroutines (GOMAXPROCS if zero) passing
+// events parsed according to @options to @cb. Up to @queueSize copied
+// records (@workers if zero) wait for a free worker, the EventCallback
+// blocks once the queue is full making ETW buffer events.
+//
+// Pipeline should be closed via `.Close` after the processing is over.
+func NewPipeline(cb PipelineCallback, workers, queueSize int, options ...ParseOption) *Pipeline {
+	var cfg ParseOptions
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	parse := func(e *Event) {
+		pe := parsedEventPool.Get().(*ParsedEvent)
+		pe.fill(e, cfg, "Pipeline")
+		cb(pe)
+		pe.Release()
+	}
+	return &Pipeline{dispatcher: newParallelDispatcher(parse, workers, queueSize)}
+}
+
+// Callback returns an EventCallback feeding the pipeline. It could be passed
+// to Session.Process, OpenTrace or ProcessFile.
+func (p *Pipeline) Callback() EventCallback {
+	return p.dispatcher.dispatch
+}
+
+// Close waits for the queued events to be processed and stops the workers.
+// The pipeline EventCallback MUST NOT be called after Close.
+func (p *Pipeline) Close() {
+	p.dispatcher.wait()
+}
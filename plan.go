@@ -0,0 +1,201 @@
+//+build windows
+
+package etw
+
+/*
+	#include "session.h"
+*/
+import "C"
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// tdhInTypePointer is TDH_INTYPE_POINTER. Pointer properties have no length
+// in the schema, their size is defined by the event pointer size.
+const tdhInTypePointer = 16
+
+// parsePlan is a precomputed description of an event schema. It holds
+// everything the properties parser needs to know about the properties, so
+// TRACE_EVENT_INFO is walked through cgo only once per event type instead
+// of on every event.
+//
+// parsePlan is immutable once built and is shared between events of the same
+// type by SchemaCache. Plans built without a cache are lazy: they serve a
+// single event and are never shared.
+type parsePlan struct {
+	decodingSource DecodingSource
+	properties     []propertyPlan
+	topLevelCount  int
+
+	// lazy plans don't precompute static counts, lengths and value maps.
+	// Counts and lengths are queried on every use, value maps are fetched on
+	// the first one. It saves TDH calls for properties the event consumer
+	// never asks for.
+	lazy bool
+
+	// visibleTopLevel is a number of top-level properties that aren't
+	// count artifacts, i.e. a size of the parsed properties map.
+	visibleTopLevel int
+}
+
+// propertyPlan describes a single property of the schema.
+type propertyPlan struct {
+	name    string
+	inType  uintptr
+	outType uintptr
+
+	isStruct    bool
+	isArray     bool
+	structStart int
+	structLast  int
+
+	// visibleFields is a number of the structure fields that aren't count
+	// artifacts.
+	visibleFields int
+
+	// countArtifact is set for "<name>.Count" properties TDH synthesizes to
+	// hold sizes of variable-length arrays. They are parsed to move the data
+	// offset but hidden from the output unless schema artifacts are asked.
+	countArtifact bool
+
+	// count and length are taken from the schema unless they are defined
+	// by other properties. Dynamic values are read from every event.
+	count         uint32
+	dynamicCount  bool
+	length        uint32
+	dynamicLength bool
+
+	// mapInfo is an EVENT_MAP_INFO of the property if any. mapErr is set if
+	// the map info exists but can't be retrieved. mapLoaded tells whether
+	// they are already fetched, it's only unset for lazy plans.
+	hasMap    bool
+	mapLoaded bool
+	mapInfo   []byte
+	mapErr    error
+}
+
+// newParsePlan builds a parsePlan for the schema @info of the event @r.
+// Static property attributes are taken from @info, value maps are queried
+// from TDH using @r as a provider reference. If @lazy is set, the plan is
+// built only for @r and queries TDH on demand.
+func newParsePlan(r C.PEVENT_RECORD, info C.PTRACE_EVENT_INFO, lazy bool) *parsePlan {
+	plan := &parsePlan{
+		decodingSource: DecodingSource(info.DecodingSource),
+		properties:     make([]propertyPlan, int(info.PropertyCount)),
+		topLevelCount:  int(info.TopLevelPropertyCount),
+		lazy:           lazy,
+	}
+	for i := range plan.properties {
+		p := &plan.properties[i]
+		p.name = propertyName(info, i)
+		p.isStruct = int(C.PropertyIsStruct(info, C.int(i))) == 1
+		p.isArray = int(C.PropertyIsArray(info, C.int(i))) == 1
+
+		p.dynamicCount = int(C.PropertyHasParamCount(info, C.int(i))) == 1
+		if !p.dynamicCount && !lazy {
+			var count C.uint
+			C.GetArraySize(r, info, C.int(i), &count) // Never fails for static counts.
+			p.count = uint32(count)
+		}
+
+		if p.isStruct {
+			p.structStart = int(C.GetStructStartIndex(info, C.int(i)))
+			p.structLast = int(C.GetStructLastIndex(info, C.int(i)))
+			continue
+		}
+
+		p.inType = uintptr(C.GetInType(info, C.int(i)))
+		p.outType = uintptr(C.GetOutType(info, C.int(i)))
+		p.dynamicLength = int(C.PropertyHasParamLength(info, C.int(i))) == 1
+		if !p.dynamicLength && !lazy {
+			var length C.uint
+			C.GetPropertyLength(r, info, C.int(i), &length) // Never fails for static lengths.
+			p.length = uint32(length)
+		}
+		p.hasMap = int(C.PropertyHasMap(info, C.int(i))) == 1
+		if !lazy {
+			p.loadMap(r, info, i)
+		}
+	}
+	plan.markCountArtifacts(info)
+	return plan
+}
+
+// markCountArtifacts finds count artifacts of the schema @info and counts
+// visible properties of the plan.
+func (plan *parsePlan) markCountArtifacts(info C.PTRACE_EVENT_INFO) {
+	for i := range plan.properties {
+		if !plan.properties[i].dynamicCount {
+			continue
+		}
+		j := int(C.GetCountPropertyIndex(info, C.int(i)))
+		if j < len(plan.properties) && plan.properties[j].name == plan.properties[i].name+".Count" {
+			plan.properties[j].countArtifact = true
+		}
+	}
+	plan.visibleTopLevel = plan.visibleCount(0, plan.topLevelCount)
+	for i := range plan.properties {
+		if p := &plan.properties[i]; p.isStruct {
+			p.visibleFields = plan.visibleCount(p.structStart, p.structLast)
+		}
+	}
+}
+
+// visibleCount returns a number of properties in [@start, @last) that aren't
+// count artifacts. Out of range indices are ignored.
+func (plan *parsePlan) visibleCount(start, last int) int {
+	n := 0
+	for i := start; i < last && i < len(plan.properties); i++ {
+		if i >= 0 && !plan.properties[i].countArtifact {
+			n++
+		}
+	}
+	return n
+}
+
+// loadMap fetches the value map of the @i-th property of the schema @info.
+func (p *propertyPlan) loadMap(r C.PEVENT_RECORD, info C.PTRACE_EVENT_INFO, i int) {
+	if p.hasMap {
+		p.mapInfo, p.mapErr = getMapInfo(r, info, i)
+	}
+	p.mapLoaded = true
+}
+
+// valueMap returns an EVENT_MAP_INFO of the @i-th property of the event @r
+// if the property has one. Lazy plans fetch it on the first call.
+func (plan *parsePlan) valueMap(r C.PEVENT_RECORD, info C.PTRACE_EVENT_INFO, i int) ([]byte, error) {
+	p := &plan.properties[i]
+	if !p.mapLoaded {
+		p.loadMap(r, info, i)
+	}
+	return p.mapInfo, p.mapErr
+}
+
+// arraySize returns a number of the @i-th property values of the event @r.
+func (plan *parsePlan) arraySize(r C.PEVENT_RECORD, info C.PTRACE_EVENT_INFO, i int) (int, error) {
+	if !plan.lazy && !plan.properties[i].dynamicCount {
+		return int(plan.properties[i].count), nil
+	}
+	var count C.uint
+	ret := C.GetArraySize(r, info, C.int(i), &count)
+	if status := windows.Errno(ret); status != windows.ERROR_SUCCESS {
+		return 0, fmt.Errorf("failed to get array size; %w", status)
+	}
+	return int(count), nil
+}
+
+// propertyLength returns a length of the @i-th property of the event @r.
+// Zero length means the property size is defined by its type.
+func (plan *parsePlan) propertyLength(r C.PEVENT_RECORD, info C.PTRACE_EVENT_INFO, i int) (uint32, error) {
+	if !plan.lazy && !plan.properties[i].dynamicLength {
+		return plan.properties[i].length, nil
+	}
+	var length C.uint
+	ret := C.GetPropertyLength(r, info, C.int(i), &length)
+	if status := windows.Errno(ret); status != windows.ERROR_SUCCESS {
+		return 0, fmt.Errorf("failed to get property length; %w", status)
+	}
+	return uint32(length), nil
+}
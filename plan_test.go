@@ -0,0 +1,71 @@
+// +build windows
+
+package etw
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParsePlan ensures that lazy plans describe properties the same way as
+// eager ones and SchemaCache shares eager plans between events.
+func TestParsePlan(t *testing.T) {
+	data := []byte{
+		'a', 0, 0, 0, // string
+		'b', 0, // ansi
+		1, 0, 0, 0, // uint32
+		0, 0, 0, 0, 0, 0, 0xF0, 0x3F, // float64
+		2, 0, 1, 0, 2, 0, // array
+		1, 0, 0xFF, // blob
+	}
+	data = append(data, make([]byte, 16)...)                 // struct.guid
+	data = append(data, 1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0) // struct.sid
+	withSyntheticEvent(fuzzSchema, data, func(e *Event) {
+		p, err := newPropertyParser(e.eventRecord, nil)
+		require.NoError(t, err, "Failed to create parser")
+		defer p.free()
+
+		lazy := p.plan
+		require.True(t, lazy.lazy, "Uncached plan is not lazy")
+		eager := newParsePlan(p.record, p.info, false)
+		require.Len(t, lazy.properties, len(eager.properties))
+		assert.Equal(t, eager.visibleTopLevel, lazy.visibleTopLevel)
+		for i := range eager.properties {
+			want, got := eager.properties[i], lazy.properties[i]
+			assert.Equal(t, want.name, got.name)
+			assert.Equal(t, want.inType, got.inType, "Type of %q differs", want.name)
+			assert.Equal(t, want.countArtifact, got.countArtifact, "Artifact of %q differs", want.name)
+			if want.isStruct {
+				continue
+			}
+
+			wantCount, err := eager.arraySize(p.record, p.info, i)
+			require.NoError(t, err)
+			gotCount, err := lazy.arraySize(p.record, p.info, i)
+			require.NoError(t, err)
+			assert.Equal(t, wantCount, gotCount, "Count of %q differs", want.name)
+
+			wantLength, err := eager.propertyLength(p.record, p.info, i)
+			require.NoError(t, err)
+			gotLength, err := lazy.propertyLength(p.record, p.info, i)
+			require.NoError(t, err)
+			assert.Equal(t, wantLength, gotLength, "Length of %q differs", want.name)
+		}
+
+		cache := NewSchemaCache(nil)
+		key := SchemaKey{ID: 1}
+		plan := cache.parsePlan(key, p.record, p.info)
+		assert.False(t, plan.lazy, "Cached plan is lazy")
+		assert.True(t, plan == cache.parsePlan(key, p.record, p.info), "Cached plan is rebuilt")
+	})
+
+	// Cache is bypassed for TraceLogging events, but the result should be
+	// the same anyway.
+	uncached, err := fuzzParse(fuzzSchema, data)
+	require.NoError(t, err, "Failed to parse event")
+	cached, err := fuzzParse(fuzzSchema, data, WithSchemaCache(NewSchemaCache(nil)))
+	require.NoError(t, err, "Failed to parse event with cache")
+	assert.Equal(t, uncached, cached)
+}
@@ -0,0 +1,195 @@
+//+build windows
+
+package etw
+
+/*
+	#include <stdlib.h>
+	#include "session.h"
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// TraceProperties describes a running ETW session as it's seen by the OS.
+// Most of the fields are assigned by ETW itself and could differ from the
+// requested ones.
+//
+// For more info about fields refer to EVENT_TRACE_PROPERTIES docs:
+// https://docs.microsoft.com/en-us/windows/win32/api/evntrace/ns-evntrace-event_trace_properties
+type TraceProperties struct {
+	SessionName string
+	LogFileName string
+	SessionGUID windows.GUID // Passed to EventAccessControl to grant rights.
+
+	LoggerID    uint16
+	LogFileMode uint32
+
+	BufferSize      uint32 // In kilobytes.
+	MinimumBuffers  uint32
+	MaximumBuffers  uint32
+	MaximumFileSize uint32 // In megabytes.
+	FlushTimer      uint32 // In seconds.
+
+	NumberOfBuffers     uint32
+	FreeBuffers         uint32
+	EventsLost          uint32
+	BuffersWritten      uint32
+	LogBuffersLost      uint32
+	RealTimeBuffersLost uint32
+}
+
+// TraceProperties queries the OS for the current session properties.
+func (s *Session) TraceProperties() (TraceProperties, error) {
+	revert, err := s.impersonate()
+	if err != nil {
+		return TraceProperties{}, err
+	}
+	defer revert()
+	return controlTrace(s.hSession, nil, C.EVENT_TRACE_CONTROL_QUERY)
+}
+
+// QuerySession queries the OS for properties of the session with a given
+// @name. The session could be created by any process.
+func QuerySession(name string) (TraceProperties, error) {
+	nameUTF16, err := windows.UTF16FromString(name)
+	if err != nil {
+		return TraceProperties{}, fmt.Errorf("failed to convert session name to utf16; %w", err)
+	}
+	return controlTrace(0, nameUTF16, C.EVENT_TRACE_CONTROL_QUERY)
+}
+
+// controlTrace wraps ControlTraceW for the control codes that fill trace
+// properties. The session is identified either by @handle or by @name.
+func controlTrace(handle C.TRACEHANDLE, name []uint16, code C.ULONG) (TraceProperties, error) {
+	propertiesBuf, err := controlTraceRaw(handle, name, code)
+	if err != nil {
+		return TraceProperties{}, err
+	}
+	return tracePropertiesFromBuf(propertiesBuf), nil
+}
+
+// ListSessions returns properties of all the running sessions created by
+// any process.
+func ListSessions() ([]TraceProperties, error) {
+	// There could be at most 64 sessions in the system, ETW reserves some of
+	// them for private use.
+	const maxSessions = 64
+	const maxNameSize = 1024 * int(unsafe.Sizeof(uint16(0)))
+	propertiesSize := int(unsafe.Sizeof(C.EVENT_TRACE_PROPERTIES{}))
+	bufSize := propertiesSize + 2*maxNameSize
+
+	// QueryAllTracesW takes an array of pointers to the properties buffers,
+	// so allocate them in C memory to keep cgo pointer rules.
+	buffers := C.calloc(maxSessions, C.size_t(bufSize))
+	defer C.free(buffers)
+	pointers := (*[maxSessions]C.PEVENT_TRACE_PROPERTIES)(C.calloc(maxSessions, C.size_t(unsafe.Sizeof(uintptr(0)))))
+	defer C.free(unsafe.Pointer(pointers))
+	for i := range pointers {
+		pProperties := (C.PEVENT_TRACE_PROPERTIES)(unsafe.Pointer(uintptr(buffers) + uintptr(i*bufSize)))
+		pProperties.Wnode.BufferSize = C.ulong(bufSize)
+		pProperties.LoggerNameOffset = C.ulong(propertiesSize)
+		pProperties.LogFileNameOffset = C.ulong(propertiesSize + maxNameSize)
+		pointers[i] = pProperties
+	}
+
+	// ULONG WMIAPI QueryAllTracesW(
+	//  PEVENT_TRACE_PROPERTIES *PropertyArray,
+	//  ULONG                   PropertyArrayCount,
+	//  PULONG                  LoggerCount
+	// );
+	var count C.ULONG
+	ret := C.QueryAllTracesW(&pointers[0], maxSessions, &count)
+	if status := windows.Errno(ret); status != windows.ERROR_SUCCESS && status != windows.ERROR_MORE_DATA {
+		return nil, fmt.Errorf("QueryAllTracesW failed; %w", status)
+	}
+
+	sessions := make([]TraceProperties, 0, int(count))
+	for i := 0; i < int(count) && i < maxSessions; i++ {
+		buf := C.GoBytes(unsafe.Pointer(pointers[i]), C.int(bufSize))
+		sessions = append(sessions, tracePropertiesFromBuf(buf))
+	}
+	return sessions, nil
+}
+
+// tracePropertiesFromBuf converts EVENT_TRACE_PROPERTIES located in @buf
+// along with the session names to TraceProperties.
+func tracePropertiesFromBuf(propertiesBuf []byte) TraceProperties {
+	pProperties := (C.PEVENT_TRACE_PROPERTIES)(unsafe.Pointer(&propertiesBuf[0]))
+
+	return TraceProperties{
+		SessionName: propertiesString(propertiesBuf, int(pProperties.LoggerNameOffset)),
+		LogFileName: propertiesString(propertiesBuf, int(pProperties.LogFileNameOffset)),
+		SessionGUID: *(*windows.GUID)(unsafe.Pointer(&pProperties.Wnode.Guid)),
+
+		// Session handle holds the logger ID in the lower 16 bits.
+		LoggerID:    uint16(C.GetHistoricalContext(pProperties) & 0xFFFF),
+		LogFileMode: uint32(pProperties.LogFileMode),
+
+		BufferSize:      uint32(pProperties.BufferSize),
+		MinimumBuffers:  uint32(pProperties.MinimumBuffers),
+		MaximumBuffers:  uint32(pProperties.MaximumBuffers),
+		MaximumFileSize: uint32(pProperties.MaximumFileSize),
+		FlushTimer:      uint32(pProperties.FlushTimer),
+
+		NumberOfBuffers:     uint32(pProperties.NumberOfBuffers),
+		FreeBuffers:         uint32(pProperties.FreeBuffers),
+		EventsLost:          uint32(pProperties.EventsLost),
+		BuffersWritten:      uint32(pProperties.BuffersWritten),
+		LogBuffersLost:      uint32(pProperties.LogBuffersLost),
+		RealTimeBuffersLost: uint32(pProperties.RealTimeBuffersLost),
+	}
+}
+
+// controlTraceRaw calls ControlTraceW with @code for the session identified
+// either by @handle or by @name and returns the filled properties buffer.
+func controlTraceRaw(handle C.TRACEHANDLE, name []uint16, code C.ULONG) ([]byte, error) {
+	// Reserve enough space for both names to be returned by ETW.
+	const maxNameSize = 1024 * int(unsafe.Sizeof(uint16(0)))
+	propertiesSize := int(unsafe.Sizeof(C.EVENT_TRACE_PROPERTIES{}))
+	bufSize := propertiesSize + 2*maxNameSize
+	propertiesBuf := make([]byte, bufSize)
+	pProperties := (C.PEVENT_TRACE_PROPERTIES)(unsafe.Pointer(&propertiesBuf[0]))
+	pProperties.Wnode.BufferSize = C.ulong(bufSize)
+	pProperties.LoggerNameOffset = C.ulong(propertiesSize)
+	pProperties.LogFileNameOffset = C.ulong(propertiesSize + maxNameSize)
+
+	var pName *C.ushort
+	if name != nil {
+		pName = (*C.ushort)(unsafe.Pointer(&name[0]))
+	}
+
+	// ULONG WMIAPI ControlTraceW(
+	//  TRACEHANDLE             TraceHandle,
+	//  LPCWSTR                 InstanceName,
+	//  PEVENT_TRACE_PROPERTIES Properties,
+	//  ULONG                   ControlCode
+	// );
+	ret := C.ControlTraceW(handle, pName, pProperties, code)
+	switch status := windows.Errno(ret); status {
+	case windows.ERROR_MORE_DATA, windows.ERROR_SUCCESS:
+		return propertiesBuf, nil
+	default:
+		return nil, fmt.Errorf("ControlTraceW failed; %w", status)
+	}
+}
+
+// propertiesString extracts a NULL-terminated UTF16 string located at
+// @offset of the properties buffer @buf.
+func propertiesString(buf []byte, offset int) string {
+	if offset == 0 || offset >= len(buf) {
+		return ""
+	}
+	str := make([]uint16, 0, (len(buf)-offset)/2)
+	for i := offset; i+1 < len(buf); i += 2 {
+		c := uint16(buf[i]) | uint16(buf[i+1])<<8
+		if c == 0 {
+			break
+		}
+		str = append(str, c)
+	}
+	return windows.UTF16ToString(str)
+}
@@ -0,0 +1,201 @@
+//+build windows
+
+package etw
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// ErrPropertyNotFound is returned (wrapped) by Properties getters if the
+// property path addresses nothing.
+var ErrPropertyNotFound = errors.New("property not found")
+
+// Properties is a property tree returned by EventProperties with getters
+// addressing values by paths. A path is a dot-separated list of property
+// names of nested structures, every name could be followed by array
+// indices, e.g. "Process.Modules[2].Name".
+//
+// Getters convert values formatted by TDH to the requested types, so
+// callbacks don't need to repeat type assertions:
+//
+//		props, err := e.EventProperties()
+//		...
+//		pid, err := etw.Properties(props).GetInt("ProcessID")
+type Properties map[string]interface{}
+
+// Get returns a raw value addressed by @path.
+func (p Properties) Get(path string) (interface{}, error) {
+	var value interface{} = map[string]interface{}(p)
+	for _, segment := range strings.Split(path, ".") {
+		name, indices, err := parsePathSegment(segment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid property path %q; %w", path, err)
+		}
+		structure, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %q, %T is not a structure", ErrPropertyNotFound, path, value)
+		}
+		if value, ok = structure[name]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrPropertyNotFound, path)
+		}
+		for _, i := range indices {
+			if value, ok = arrayElement(value, i); !ok {
+				return nil, fmt.Errorf("%w: %q, no element %d", ErrPropertyNotFound, path, i)
+			}
+		}
+	}
+	if parseErr, ok := value.(ParseError); ok {
+		return nil, parseErr
+	}
+	return value, nil
+}
+
+// GetString returns a string value addressed by @path. Mapped values are
+// returned as TDH formats them.
+func (p Properties) GetString(path string) (string, error) {
+	value, err := p.Get(path)
+	if err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case MapValue:
+		return v.Formatted, nil
+	default:
+		return "", fmt.Errorf("property %q is %T, not a string", path, value)
+	}
+}
+
+// GetInt returns an integer value addressed by @path. Decimal and "0x"
+// prefixed hex values are accepted.
+func (p Properties) GetInt(path string) (int64, error) {
+	value, err := p.Get(path)
+	if err != nil {
+		return 0, err
+	}
+	switch v := value.(type) {
+	case string:
+		n, err := strconv.ParseInt(v, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("property %q is not an integer; %w", path, err)
+		}
+		return n, nil
+	case MapValue:
+		return int64(v.Value), nil
+	default:
+		return 0, fmt.Errorf("property %q is %T, not an integer", path, value)
+	}
+}
+
+// GetUint is the same as GetInt but for unsigned values, e.g. pointers.
+func (p Properties) GetUint(path string) (uint64, error) {
+	value, err := p.Get(path)
+	if err != nil {
+		return 0, err
+	}
+	switch v := value.(type) {
+	case string:
+		n, err := strconv.ParseUint(v, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("property %q is not an unsigned integer; %w", path, err)
+		}
+		return n, nil
+	case MapValue:
+		return v.Value, nil
+	default:
+		return 0, fmt.Errorf("property %q is %T, not an unsigned integer", path, value)
+	}
+}
+
+// GetGUID returns a GUID value addressed by @path.
+func (p Properties) GetGUID(path string) (windows.GUID, error) {
+	str, err := p.GetString(path)
+	if err != nil {
+		return windows.GUID{}, err
+	}
+	guid, err := ParseGUID(str)
+	if err != nil {
+		return windows.GUID{}, fmt.Errorf("property %q is not a GUID; %w", path, err)
+	}
+	return guid, nil
+}
+
+// parsePathSegment splits a path @segment like "Modules[2][0]" to the
+// property name and array indices.
+func parsePathSegment(segment string) (string, []int, error) {
+	name := segment
+	var indices []int
+	if open := strings.IndexByte(segment, '['); open >= 0 {
+		name = segment[:open]
+		for rest := segment[open:]; rest != ""; {
+			end := strings.IndexByte(rest, ']')
+			if rest[0] != '[' || end < 0 {
+				return "", nil, fmt.Errorf("malformed index in %q", segment)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return "", nil, fmt.Errorf("malformed index in %q", segment)
+			}
+			indices = append(indices, i)
+			rest = rest[end+1:]
+		}
+	}
+	if name == "" {
+		return "", nil, fmt.Errorf("empty property name")
+	}
+	return name, indices, nil
+}
+
+// arrayElement returns the @i-th element of the array @value.
+func arrayElement(value interface{}, i int) (interface{}, bool) {
+	switch array := value.(type) {
+	case []interface{}:
+		if i < len(array) {
+			return array[i], true
+		}
+	case []string:
+		if i < len(array) {
+			return array[i], true
+		}
+	case []map[string]interface{}:
+		if i < len(array) {
+			return array[i], true
+		}
+	}
+	return nil, false
+}
+
+// GetString parses the event properties with @options and returns a string
+// value addressed by @path (take a look at Properties for the syntax). To
+// get several values parse properties once and use Properties getters.
+func (e *Event) GetString(path string, options ...ParseOption) (string, error) {
+	props, err := e.EventProperties(options...)
+	if err != nil {
+		return "", err
+	}
+	return Properties(props).GetString(path)
+}
+
+// GetInt is the same as GetString but for integer values.
+func (e *Event) GetInt(path string, options ...ParseOption) (int64, error) {
+	props, err := e.EventProperties(options...)
+	if err != nil {
+		return 0, err
+	}
+	return Properties(props).GetInt(path)
+}
+
+// GetGUID is the same as GetString but for GUID values.
+func (e *Event) GetGUID(path string, options ...ParseOption) (windows.GUID, error) {
+	props, err := e.EventProperties(options...)
+	if err != nil {
+		return windows.GUID{}, err
+	}
+	return Properties(props).GetGUID(path)
+}
@@ -0,0 +1,79 @@
+// +build windows
+
+package etw_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw"
+)
+
+func TestPropertiesGetters(t *testing.T) {
+	props := etw.Properties{
+		"ProcessID": "1234",
+		"Address":   "0xFFFFF80000001234",
+		"Status":    etw.MapValue{Value: 2, Names: []string{"Stopped"}, Formatted: "Stopped"},
+		"Process": map[string]interface{}{
+			"ActivityID": "{1C95126E-7EEA-49A9-A3FE-A378B03DDB4D}",
+			"Modules": []interface{}{
+				map[string]interface{}{"Name": "ntdll.dll"},
+				map[string]interface{}{"Name": "app.exe", "Ports": []interface{}{"80", "443"}},
+			},
+		},
+		"Broken": etw.ParseError{Property: "Broken", Err: errors.New("bad data")},
+	}
+
+	pid, err := props.GetInt("ProcessID")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1234), pid)
+
+	address, err := props.GetUint("Address")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0xFFFFF80000001234), address)
+
+	status, err := props.GetString("Status")
+	require.NoError(t, err)
+	assert.Equal(t, "Stopped", status)
+	code, err := props.GetInt("Status")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), code)
+
+	name, err := props.GetString("Process.Modules[1].Name")
+	require.NoError(t, err)
+	assert.Equal(t, "app.exe", name)
+	port, err := props.GetInt("Process.Modules[1].Ports[1]")
+	require.NoError(t, err)
+	assert.Equal(t, int64(443), port)
+
+	guid, err := props.GetGUID("Process.ActivityID")
+	require.NoError(t, err)
+	assert.Equal(t, windows.GUID{
+		Data1: 0x1c95126e,
+		Data2: 0x7eea,
+		Data3: 0x49a9,
+		Data4: [8]byte{0xa3, 0xfe, 0xa3, 0x78, 0xb0, 0x3d, 0xdb, 0x4d},
+	}, guid)
+
+	for _, path := range []string{"Missing", "Process.Missing", "Process.Modules[2].Name", "ProcessID.Name"} {
+		_, err := props.Get(path)
+		assert.True(t, errors.Is(err, etw.ErrPropertyNotFound), "Unexpected error of %q: %v", path, err)
+	}
+	for _, path := range []string{"Process.Modules[x]", "Process..Name", "Process.Modules[1"} {
+		_, err := props.Get(path)
+		assert.Error(t, err, "Malformed path %q is accepted", path)
+		assert.False(t, errors.Is(err, etw.ErrPropertyNotFound), "Malformed path %q is not found", path)
+	}
+
+	_, err = props.GetString("Process.Modules")
+	assert.Error(t, err, "Array is returned as a string")
+	_, err = props.GetInt("Process.ActivityID")
+	assert.Error(t, err, "GUID is returned as an integer")
+	var parseErr etw.ParseError
+	_, err = props.GetString("Broken")
+	assert.True(t, errors.As(err, &parseErr), "ParseError is not returned")
+}
@@ -0,0 +1,79 @@
+//+build windows
+
+package etw
+
+/*
+	#include <stdlib.h>
+	#include "session.h"
+*/
+import "C"
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw/rawrecord"
+)
+
+// ParseRawRecord passes the record @r to @cb as an Event, so its properties
+// could be parsed as if it has just been received: TraceLogging events carry
+// their schemas in the extended data, schemas of manifest providers are
+// looked up on the parsing machine (use the manifest package if they aren't
+// registered there). Like in EventCallback the Event is valid only inside
+// @cb.
+//
+// Event.Header is a copy of the record header. The EVENT_RECORD passed to
+// TDH has the fields TDH relies on, CPU times are not restored there.
+func ParseRawRecord(r *rawrecord.Record, cb EventCallback) {
+	// Everything referenced by the record is allocated in C memory to be
+	// safely passed to TDH.
+	record := (C.PEVENT_RECORD)(C.calloc(1, C.size_t(unsafe.Sizeof(C.EVENT_RECORD{}))))
+	defer C.free(unsafe.Pointer(record))
+
+	h := &record.EventHeader
+	h.Size = C.USHORT(unsafe.Sizeof(C.EVENT_HEADER{}))
+	h.Flags = C.USHORT(r.Header.Flags)
+	h.ThreadId = C.ULONG(r.Header.ThreadID)
+	h.ProcessId = C.ULONG(r.Header.ProcessID)
+	ft := windows.NsecToFiletime(r.Header.TimeStamp.UnixNano())
+	*(*C.LONGLONG)(unsafe.Pointer(&h.TimeStamp)) = C.LONGLONG(int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime))
+	h.ProviderId = *(*C.GUID)(unsafe.Pointer(&r.Header.ProviderID)) // Both are in Windows layout.
+	h.ActivityId = *(*C.GUID)(unsafe.Pointer(&r.Header.ActivityID))
+	h.EventDescriptor.Id = C.USHORT(r.Header.ID)
+	h.EventDescriptor.Version = C.UCHAR(r.Header.Version)
+	h.EventDescriptor.Channel = C.UCHAR(r.Header.Channel)
+	h.EventDescriptor.Level = C.UCHAR(r.Header.Level)
+	h.EventDescriptor.Opcode = C.UCHAR(r.Header.OpCode)
+	h.EventDescriptor.Task = C.USHORT(r.Header.Task)
+	h.EventDescriptor.Keyword = C.ULONGLONG(r.Header.Keyword)
+
+	if len(r.UserData) != 0 {
+		pData := C.CBytes(r.UserData)
+		defer C.free(pData)
+		record.UserData = pData
+		record.UserDataLength = C.USHORT(len(r.UserData))
+	}
+
+	if len(r.Extended) != 0 {
+		itemSize := unsafe.Sizeof(C.EVENT_HEADER_EXTENDED_DATA_ITEM{})
+		items := C.calloc(C.size_t(len(r.Extended)), C.size_t(itemSize))
+		defer C.free(items)
+		for i, ext := range r.Extended {
+			item := (*C.EVENT_HEADER_EXTENDED_DATA_ITEM)(unsafe.Pointer(uintptr(items) + uintptr(i)*itemSize))
+			item.ExtType = C.USHORT(ext.Type)
+			item.DataSize = C.USHORT(len(ext.Data))
+			if len(ext.Data) != 0 {
+				pData := C.CBytes(ext.Data)
+				defer C.free(pData)
+				item.DataPtr = C.ULONGLONG(uintptr(pData))
+			}
+		}
+		h.Flags |= C.EVENT_HEADER_FLAG_EXTENDED_INFO
+		record.ExtendedData = (C.PEVENT_HEADER_EXTENDED_DATA_ITEM)(items)
+		record.ExtendedDataCount = C.USHORT(len(r.Extended))
+	}
+
+	e := &Event{Header: newEventHeader(r.Header), eventRecord: record}
+	cb(e)
+	e.eventRecord = nil
+}
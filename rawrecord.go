@@ -0,0 +1,129 @@
+//+build windows
+
+package etw
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw/rawrecord"
+)
+
+// NewRawRecord copies the event @e to a self-contained rawrecord.Record.
+// Unlike Event it's valid anywhere. Like other Event methods NewRawRecord
+// is valid only inside EventCallback.
+//
+// Copying raw records is much cheaper than parsing properties, so they
+// could be captured on busy hosts and parsed later with ParseRawRecord or,
+// possibly on another platform, with the manifest package. Take a look at
+// RawRecordCallback.
+func NewRawRecord(e *Event) (*rawrecord.Record, error) {
+	userData, err := e.UserData()
+	if err != nil {
+		return nil, err
+	}
+	r := &rawrecord.Record{Header: newRawHeader(e.Header), UserData: userData}
+	for i := 0; i < e.ExtendedDataCount(); i++ {
+		item, err := e.RawExtendedData(i)
+		if err != nil {
+			return nil, err
+		}
+		r.Extended = append(r.Extended, rawrecord.ExtendedItem{Type: uint16(item.Type), Data: item.Data})
+	}
+	return r, nil
+}
+
+// RawRecordCallback returns an EventCallback writing every event to @w. It's
+// a capture mode trading CPU at capture time for storage: events are only
+// copied, they are parsed later by rawrecord.Reader consumers. The first
+// failure stops the capture: subsequent events are dropped and the error is
+// returned by `w.Flush`.
+//
+// rawrecord.Writer is safe for concurrent use, so the callback fits the
+// parallel callback mode as well. The stream keeps the order records are
+// written in, which is the events order only in the sequential mode.
+func RawRecordCallback(w *rawrecord.Writer) EventCallback {
+	return func(e *Event) {
+		if w.Err() != nil {
+			return
+		}
+		r, err := NewRawRecord(e)
+		if err != nil {
+			w.Abort(err)
+			return
+		}
+		_ = w.Write(r) // Kept by the writer.
+	}
+}
+
+// newRawHeader converts @h to rawrecord.Header.
+func newRawHeader(h EventHeader) rawrecord.Header {
+	return rawrecord.Header{
+		ID:             h.ID,
+		Version:        h.Version,
+		Channel:        h.Channel,
+		Level:          h.Level,
+		OpCode:         h.OpCode,
+		Task:           h.Task,
+		Keyword:        h.Keyword,
+		ThreadID:       h.ThreadID,
+		ProcessID:      h.ProcessID,
+		TimeStamp:      h.TimeStamp,
+		ProviderID:     guidToRaw(h.ProviderID),
+		ActivityID:     guidToRaw(h.ActivityID),
+		Flags:          h.Flags,
+		KernelTime:     h.KernelTime,
+		UserTime:       h.UserTime,
+		ProcessorTime:  h.ProcessorTime,
+		HeaderSize:     h.HeaderSize,
+		UserDataLength: h.UserDataLength,
+	}
+}
+
+// newEventHeader converts @h back to EventHeader.
+func newEventHeader(h rawrecord.Header) EventHeader {
+	return EventHeader{
+		EventDescriptor: EventDescriptor{
+			ID:      h.ID,
+			Version: h.Version,
+			Channel: h.Channel,
+			Level:   h.Level,
+			OpCode:  h.OpCode,
+			Task:    h.Task,
+			Keyword: h.Keyword,
+		},
+		ThreadID:       h.ThreadID,
+		ProcessID:      h.ProcessID,
+		TimeStamp:      h.TimeStamp,
+		ProviderID:     guidFromRaw(h.ProviderID),
+		ActivityID:     guidFromRaw(h.ActivityID),
+		Flags:          h.Flags,
+		KernelTime:     h.KernelTime,
+		UserTime:       h.UserTime,
+		ProcessorTime:  h.ProcessorTime,
+		HeaderSize:     h.HeaderSize,
+		UserDataLength: h.UserDataLength,
+	}
+}
+
+// guidToRaw encodes @g in the Windows binary layout.
+func guidToRaw(g windows.GUID) rawrecord.GUID {
+	var b rawrecord.GUID
+	binary.LittleEndian.PutUint32(b[0:], g.Data1)
+	binary.LittleEndian.PutUint16(b[4:], g.Data2)
+	binary.LittleEndian.PutUint16(b[6:], g.Data3)
+	copy(b[8:], g.Data4[:])
+	return b
+}
+
+// guidFromRaw decodes @b encoded by guidToRaw.
+func guidFromRaw(b rawrecord.GUID) windows.GUID {
+	g := windows.GUID{
+		Data1: binary.LittleEndian.Uint32(b[0:]),
+		Data2: binary.LittleEndian.Uint16(b[4:]),
+		Data3: binary.LittleEndian.Uint16(b[6:]),
+	}
+	copy(g.Data4[:], b[8:])
+	return g
+}
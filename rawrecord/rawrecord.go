@@ -0,0 +1,399 @@
+// Package rawrecord implements a compact binary format of raw event records:
+// the header, the raw payload and extended data items. Copying raw records
+// is much cheaper than parsing properties, so they could be captured on busy
+// hosts (take a look at etw.RawRecordCallback) and parsed later, possibly on
+// another machine.
+//
+// The package is pure Go, so captured streams could be read on any platform
+// and decoded with provider manifests (see Manifest.RecordProperties of the
+// manifest package):
+//
+//		r := rawrecord.NewReader(f)
+//		for {
+//			record, err := r.Next()
+//			if err == io.EOF {
+//				break
+//			}
+//			...
+//			props, err := m.RecordProperties(record)
+//		}
+//
+// On Windows records could also be parsed with TDH by etw.ParseRawRecord.
+package rawrecord
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// streamMagic starts every stream written by Writer, the last byte is a
+// version of the format.
+var streamMagic = []byte("ETWRAW\x00\x01") //nolint:gochecknoglobals
+
+// headerSize is a size of the encoded Header.
+const headerSize = 2 + 1 + 1 + 1 + 1 + 2 + 8 + // Event descriptor
+	4 + 4 + 8 + 16 + 16 + // ThreadID, ProcessID, TimeStamp, ProviderID, ActivityID
+	2 + 4 + 4 + 8 + 2 + 2 // Flags, KernelTime, UserTime, ProcessorTime, HeaderSize, UserDataLength
+
+// maxRecordSize limits the size of a record Reader agrees to read. Event
+// payloads are limited with 64KB, so larger records are broken.
+const maxRecordSize = 1 << 20
+
+// eventHeaderFlag32BitHeader is EVENT_HEADER_FLAG_32_BIT_HEADER.
+const eventHeaderFlag32BitHeader = 0x0020
+
+// ErrMalformed is returned (wrapped) by Reader and Record.UnmarshalBinary if
+// the encoded record is broken.
+var ErrMalformed = errors.New("malformed raw record")
+
+// GUID is a binary GUID in Windows layout.
+type GUID [16]byte
+
+// String renders @g in the registry format, the same way as windows.GUID.
+func (g GUID) String() string {
+	return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}",
+		binary.LittleEndian.Uint32(g[0:4]),
+		binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]),
+		g[8:10],
+		g[10:16],
+	)
+}
+
+// Header is a copy of the event header. Fields are the same as ones of
+// etw.EventHeader.
+type Header struct {
+	ID      uint16
+	Version uint8
+	Channel uint8
+	Level   uint8
+	OpCode  uint8
+	Task    uint16
+	Keyword uint64
+
+	ThreadID  uint32
+	ProcessID uint32
+	TimeStamp time.Time
+
+	ProviderID GUID
+	ActivityID GUID
+
+	Flags          uint16
+	KernelTime     uint32
+	UserTime       uint32
+	ProcessorTime  uint64
+	HeaderSize     uint16
+	UserDataLength uint16
+}
+
+// PointerSize returns a size of pointers in the event payload.
+func (h Header) PointerSize() int {
+	if h.Flags&eventHeaderFlag32BitHeader != 0 {
+		return 4
+	}
+	return 8
+}
+
+// ExtendedItem is a single item of the event extended data.
+type ExtendedItem struct {
+	Type uint16
+	Data []byte
+}
+
+// Record is a self-contained copy of an event record.
+type Record struct {
+	Header   Header
+	UserData []byte
+	Extended []ExtendedItem
+}
+
+// MarshalBinary encodes the record to a compact binary form.
+func (r *Record) MarshalBinary() ([]byte, error) {
+	if len(r.UserData) > math.MaxUint16 {
+		return nil, fmt.Errorf("payload of %d bytes is too large", len(r.UserData))
+	}
+	if len(r.Extended) > math.MaxUint16 {
+		return nil, fmt.Errorf("too many extended data items: %d", len(r.Extended))
+	}
+	size := headerSize + 2 + len(r.UserData) + 2
+	for _, item := range r.Extended {
+		if len(item.Data) > math.MaxUint16 {
+			return nil, fmt.Errorf("extended data item of %d bytes is too large", len(item.Data))
+		}
+		size += 4 + len(item.Data)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	h := &r.Header
+	le := binary.LittleEndian
+	put := func(v interface{}) { _ = binary.Write(buf, le, v) } // Never fails for bytes.Buffer.
+	put(h.ID)
+	put(h.Version)
+	put(h.Channel)
+	put(h.Level)
+	put(h.OpCode)
+	put(h.Task)
+	put(h.Keyword)
+	put(h.ThreadID)
+	put(h.ProcessID)
+	put(h.TimeStamp.UnixNano())
+	put(h.ProviderID)
+	put(h.ActivityID)
+	put(h.Flags)
+	put(h.KernelTime)
+	put(h.UserTime)
+	put(h.ProcessorTime)
+	put(h.HeaderSize)
+	put(h.UserDataLength)
+
+	put(uint16(len(r.UserData)))
+	buf.Write(r.UserData)
+	put(uint16(len(r.Extended)))
+	for _, item := range r.Extended {
+		put(item.Type)
+		put(uint16(len(item.Data)))
+		buf.Write(item.Data)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes the record encoded with `.MarshalBinary`.
+func (r *Record) UnmarshalBinary(data []byte) error {
+	d := decoder{data: data}
+	h := Header{}
+	h.ID = d.u16()
+	h.Version = d.u8()
+	h.Channel = d.u8()
+	h.Level = d.u8()
+	h.OpCode = d.u8()
+	h.Task = d.u16()
+	h.Keyword = d.u64()
+	h.ThreadID = d.u32()
+	h.ProcessID = d.u32()
+	h.TimeStamp = time.Unix(0, int64(d.u64()))
+	h.ProviderID = d.guid()
+	h.ActivityID = d.guid()
+	h.Flags = d.u16()
+	h.KernelTime = d.u32()
+	h.UserTime = d.u32()
+	h.ProcessorTime = d.u64()
+	h.HeaderSize = d.u16()
+	h.UserDataLength = d.u16()
+
+	userData := d.bytes(int(d.u16()))
+	count := int(d.u16())
+	var extended []ExtendedItem
+	for i := 0; i < count && d.err == nil; i++ {
+		itemType := d.u16()
+		extended = append(extended, ExtendedItem{Type: itemType, Data: d.bytes(int(d.u16()))})
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.data) != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrMalformed, len(d.data))
+	}
+	*r = Record{Header: h, UserData: userData, Extended: extended}
+	return nil
+}
+
+// decoder reads little-endian values from data. The first failure is stored
+// in err, subsequent reads return zeros.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+// next returns the next @n bytes of data without copying.
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.data) {
+		d.err = fmt.Errorf("%w: %d bytes are out of %d remaining", ErrMalformed, n, len(d.data))
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+// bytes returns a copy of the next @n bytes of data. Empty slices are
+// returned as nil.
+func (d *decoder) bytes(n int) []byte {
+	if b := d.next(n); len(b) != 0 {
+		return append([]byte(nil), b...)
+	}
+	return nil
+}
+
+func (d *decoder) u8() uint8 {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) u16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) u32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) u64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) guid() GUID {
+	var g GUID
+	copy(g[:], d.next(16))
+	return g
+}
+
+// Writer writes a stream of length-prefixed Records. Writer is safe for
+// concurrent use. The stream keeps the order records are written in.
+type Writer struct {
+	mu            sync.Mutex
+	w             *bufio.Writer
+	headerWritten bool
+	err           error
+}
+
+// NewWriter creates a Writer writing to @w. Call `.Flush` after the last
+// record.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Write appends the record @r to the stream. The first write error stops
+// the writer: subsequent writes return it.
+func (w *Writer) Write(r *Record) error {
+	data, err := r.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = w.writeHeader(); w.err != nil {
+		return w.err
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(data)))
+	if _, w.err = w.w.Write(size[:]); w.err != nil {
+		return w.err
+	}
+	_, w.err = w.w.Write(data)
+	return w.err
+}
+
+// Abort stops the writer with @err unless it has already failed, e.g. when
+// a record to be written can't be captured. Subsequent writes and `.Flush`
+// return the error.
+func (w *Writer) Abort(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// Err returns the error the writer has failed with, if any.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Flush writes buffered records to the underlying writer. It returns the
+// first error occurred during writing, if any.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = w.writeHeader(); w.err != nil {
+		return w.err
+	}
+	w.err = w.w.Flush()
+	return w.err
+}
+
+// writeHeader writes the stream header unless it's already written, so
+// even an empty stream is readable.
+func (w *Writer) writeHeader() error {
+	if w.headerWritten {
+		return nil
+	}
+	w.headerWritten = true
+	_, err := w.w.Write(streamMagic)
+	return err
+}
+
+// Reader reads a stream written by Writer.
+type Reader struct {
+	r          *bufio.Reader
+	headerRead bool
+}
+
+// NewReader creates a Reader reading from @r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next record or io.EOF at the end of the stream.
+func (r *Reader) Next() (*Record, error) {
+	if !r.headerRead {
+		magic := make([]byte, len(streamMagic))
+		if _, err := io.ReadFull(r.r, magic); err != nil {
+			return nil, fmt.Errorf("%w: failed to read stream header; %s", ErrMalformed, err)
+		}
+		if !bytes.Equal(magic, streamMagic) {
+			return nil, fmt.Errorf("%w: unknown stream header %q", ErrMalformed, magic)
+		}
+		r.headerRead = true
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(r.r, size[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("%w: failed to read record size; %s", ErrMalformed, err)
+	}
+	recordSize := binary.LittleEndian.Uint32(size[:])
+	if recordSize > maxRecordSize {
+		return nil, fmt.Errorf("%w: record of %d bytes is too large", ErrMalformed, recordSize)
+	}
+	data := make([]byte, recordSize)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, fmt.Errorf("%w: failed to read record; %s", ErrMalformed, err)
+	}
+	var record Record
+	if err := record.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
@@ -0,0 +1,119 @@
+package rawrecord_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/rawrecord"
+)
+
+func TestStream(t *testing.T) {
+	records := []*rawrecord.Record{
+		{
+			Header: rawrecord.Header{
+				ID:             1,
+				Version:        2,
+				Level:          4,
+				Keyword:        0x8000000000000010,
+				ThreadID:       11,
+				ProcessID:      22,
+				TimeStamp:      time.Unix(0, 1600000000123456700),
+				ProviderID:     rawrecord.GUID{0x6E, 0x12, 0x95, 0x1C, 0xEA, 0x7E, 0xA9, 0x49, 0xA3, 0xFE},
+				Flags:          0x40,
+				HeaderSize:     80,
+				UserDataLength: 3,
+			},
+			UserData: []byte{1, 2, 3},
+			Extended: []rawrecord.ExtendedItem{{Type: 5, Data: []byte{5, 0, 0, 0}}},
+		},
+		{
+			Header: rawrecord.Header{TimeStamp: time.Unix(0, 1600000000223456700)},
+		},
+	}
+
+	var buf bytes.Buffer
+	w := rawrecord.NewWriter(&buf)
+	for _, r := range records {
+		require.NoError(t, w.Write(r), "Failed to write record")
+	}
+	require.NoError(t, w.Flush(), "Failed to flush records")
+	stream := buf.Bytes()
+
+	r := rawrecord.NewReader(bytes.NewReader(stream))
+	for i, expected := range records {
+		record, err := r.Next()
+		require.NoError(t, err, "Failed to read record %d", i)
+		assert.Equal(t, expected, record, "Record %d changed after round-trip", i)
+	}
+	_, err := r.Next()
+	assert.Equal(t, io.EOF, err, "Expected the end of the stream")
+
+	for i := 1; i < len(stream); i++ {
+		r := rawrecord.NewReader(bytes.NewReader(stream[:i]))
+		var err error
+		for err == nil {
+			_, err = r.Next()
+		}
+		if err != io.EOF {
+			assert.True(t, errors.Is(err, rawrecord.ErrMalformed), "Unexpected error for stream truncated to %d bytes: %v", i, err)
+		}
+	}
+}
+
+func TestWriterConcurrent(t *testing.T) {
+	const (
+		writers = 8
+		records = 100
+	)
+	var buf bytes.Buffer
+	w := rawrecord.NewWriter(&buf)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(thread uint32) {
+			defer wg.Done()
+			for j := 0; j < records; j++ {
+				r := &rawrecord.Record{Header: rawrecord.Header{ThreadID: thread}, UserData: []byte{byte(j)}}
+				assert.NoError(t, w.Write(r), "Failed to write record")
+			}
+		}(uint32(i))
+	}
+	wg.Wait()
+	require.NoError(t, w.Flush(), "Failed to flush records")
+
+	r := rawrecord.NewReader(&buf)
+	next := make(map[uint32]byte, writers)
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err, "Failed to read record")
+		thread := record.Header.ThreadID
+		assert.Equal(t, []byte{next[thread]}, record.UserData, "Records of thread %d are reordered", thread)
+		next[thread]++
+	}
+	for i := uint32(0); i < writers; i++ {
+		assert.Equal(t, byte(records), next[i], "Records of thread %d are lost", i)
+	}
+}
+
+func TestWriterAbort(t *testing.T) {
+	var buf bytes.Buffer
+	w := rawrecord.NewWriter(&buf)
+	require.NoError(t, w.Write(&rawrecord.Record{}), "Failed to write record")
+
+	abortErr := errors.New("capture failed")
+	w.Abort(abortErr)
+	w.Abort(errors.New("another failure"))
+	assert.Equal(t, abortErr, w.Err(), "The first failure is not kept")
+	assert.Equal(t, abortErr, w.Write(&rawrecord.Record{}), "Aborted writer accepts records")
+	assert.Equal(t, abortErr, w.Flush(), "Aborted writer is flushed")
+}
@@ -0,0 +1,75 @@
+// +build windows
+
+package etw
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw/rawrecord"
+)
+
+// TestRawRecordHeader ensures that EventHeader survives the conversion to
+// rawrecord.Header and back.
+func TestRawRecordHeader(t *testing.T) {
+	h := EventHeader{
+		EventDescriptor: EventDescriptor{ID: 1, Version: 2, Channel: 3, Level: 4, OpCode: 5, Task: 6, Keyword: 0x8000000000000010},
+		ThreadID:        11,
+		ProcessID:       22,
+		TimeStamp:       time.Unix(0, 1600000000123456700),
+		ProviderID:      windows.GUID{Data1: 0x1C95126E, Data2: 0x7EEA, Data3: 0x49A9, Data4: [8]byte{0xA3, 0xFE}},
+		ActivityID:      windows.GUID{Data1: 0x1, Data4: [8]byte{7: 0x2}},
+		Flags:           0x40,
+		KernelTime:      33,
+		UserTime:        44,
+		ProcessorTime:   55,
+		HeaderSize:      80,
+		UserDataLength:  3,
+	}
+	raw := newRawHeader(h)
+	assert.Equal(t, h.ProviderID.String(), raw.ProviderID.String(), "Provider GUID changed")
+	assert.Equal(t, h, newEventHeader(raw), "Header changed after round-trip")
+}
+
+// TestRawRecordCallback ensures that events captured by RawRecordCallback
+// are parsed the same way as the original ones.
+func TestRawRecordCallback(t *testing.T) {
+	schema := buildTLSchema("RawEvent",
+		tlField{name: "string", inType: tlInUnicodeString},
+		tlField{name: "uint32", inType: tlInUInt32},
+	)
+	data := []byte{'a', 0, 0, 0, 42, 0, 0, 0}
+	var expected map[string]interface{}
+	var buf bytes.Buffer
+	w := rawrecord.NewWriter(&buf)
+	capture := RawRecordCallback(w)
+	withSyntheticEvent(schema, data, func(e *Event) {
+		var err error
+		expected, err = e.EventProperties()
+		require.NoError(t, err, "Failed to parse original event")
+		capture(e)
+	})
+	require.NoError(t, w.Flush(), "Failed to flush captured records")
+
+	r := rawrecord.NewReader(&buf)
+	record, err := r.Next()
+	require.NoError(t, err, "Failed to read captured record")
+	assert.Equal(t, data, record.UserData, "Payload changed after capture")
+	assert.Equal(t, []rawrecord.ExtendedItem{{Type: uint16(EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL), Data: schema}},
+		record.Extended, "Schema is not captured")
+	var props map[string]interface{}
+	ParseRawRecord(record, func(e *Event) {
+		props, err = e.EventProperties()
+	})
+	require.NoError(t, err, "Failed to parse captured record")
+	assert.Equal(t, expected, props, "Captured record is parsed differently")
+
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err, "Expected the end of the stream")
+}
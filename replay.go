@@ -0,0 +1,366 @@
+//+build windows
+
+package etw
+
+/*
+	#include "session.h"
+*/
+import "C"
+import (
+	"context"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ReplayOptions describes options of .etl files processing.
+type ReplayOptions struct {
+	// Checkpoint is a position in the file to resume processing from. Events
+	// preceding the Checkpoint are skipped. Zero Checkpoint means processing
+	// from the very beginning of the file.
+	Checkpoint Checkpoint
+
+	// CheckpointInterval is a number of events processed between subsequent
+	// OnCheckpoint calls.
+	CheckpointInterval uint64
+
+	// OnCheckpoint is called every CheckpointInterval events and once more at
+	// the end of the file to persist the processing position. Returned error
+	// stops the processing.
+	OnCheckpoint func(c Checkpoint) error
+
+	// Speed is a pace events are delivered at relative to their timestamps:
+	// 1 replays them with the original timing, 10 is ten times faster, 0.5
+	// is twice slower. Zero (the default) delivers events as fast as
+	// possible. Detection logic depending on time windows could be tested
+	// with realistic temporal patterns this way.
+	Speed float64
+
+	// StartTime and EndTime select a time window of events to process,
+	// zero values leave the window open. ETW skips whole buffers outside
+	// the window without reading their events, so extracting a short slice
+	// of a multi-gigabyte trace is cheap.
+	StartTime time.Time
+	EndTime   time.Time
+
+	// Providers selects events to process by providers and event IDs: only
+	// events of the providers present are processed, and only the listed
+	// IDs of them if any are listed. Nil Providers selects all the events.
+	// Other events are skipped by their headers before any parsing.
+	Providers map[windows.GUID][]uint16
+}
+
+// ReplayOption is any function that modifies ReplayOptions.
+type ReplayOption func(cfg *ReplayOptions)
+
+// Checkpoint describes a position in the .etl file. Checkpoint values are
+// safe to be persisted and reused after the process restart.
+type Checkpoint struct {
+	// TimeStamp of the last processed event.
+	TimeStamp time.Time
+
+	// SameTimeStamp is a number of already processed events having exactly
+	// the TimeStamp. ETW timestamps have limited resolution, so several
+	// events could share the same one.
+	SameTimeStamp uint64
+
+	// Processed is a total number of processed events.
+	Processed uint64
+}
+
+// WithCheckpoint makes ProcessFile resume processing from the given
+// position @c skipping already processed events.
+func WithCheckpoint(c Checkpoint) ReplayOption {
+	return func(cfg *ReplayOptions) {
+		cfg.Checkpoint = c
+	}
+}
+
+// WithCheckpointCallback makes ProcessFile call @save with a current
+// processing position every @interval events. Returned error stops the
+// processing.
+func WithCheckpointCallback(interval uint64, save func(c Checkpoint) error) ReplayOption {
+	return func(cfg *ReplayOptions) {
+		cfg.CheckpointInterval = interval
+		cfg.OnCheckpoint = save
+	}
+}
+
+// WithReplaySpeed makes ProcessFile deliver events at the @speed pace
+// relative to their original timing, take a look at ReplayOptions.Speed.
+func WithReplaySpeed(speed float64) ReplayOption {
+	return func(cfg *ReplayOptions) {
+		cfg.Speed = speed
+	}
+}
+
+// WithTimeWindow makes ProcessFile process only events logged in the
+// [@start, @end] window. Zero @start or @end leaves the window open on that
+// side.
+func WithTimeWindow(start, end time.Time) ReplayOption {
+	return func(cfg *ReplayOptions) {
+		cfg.StartTime = start
+		cfg.EndTime = end
+	}
+}
+
+// WithReplayProvider makes ProcessFile process events of the provider
+// @guid: only ones with @ids if given, or all of them. Events of providers
+// not added with WithReplayProvider are skipped.
+func WithReplayProvider(guid windows.GUID, ids ...uint16) ReplayOption {
+	return func(cfg *ReplayOptions) {
+		if cfg.Providers == nil {
+			cfg.Providers = make(map[windows.GUID][]uint16)
+		}
+		if len(ids) == 0 {
+			cfg.Providers[guid] = nil
+			return
+		}
+		if selected, ok := cfg.Providers[guid]; !ok || selected != nil {
+			cfg.Providers[guid] = append(selected, ids...)
+		}
+	}
+}
+
+// ProcessFile processes events from the .etl file located at @path. Events
+// will be passed to @cb synchronously and sequentially exactly as in case of
+// Session.Process.
+//
+// ProcessFile blocks until the whole file is processed.
+func ProcessFile(path string, cb EventCallback, options ...ReplayOption) error {
+	return ProcessFileContext(context.Background(), path, func(_ context.Context, e *Event) {
+		cb(e)
+	}, options...)
+}
+
+// ProcessFileContext is the same as ProcessFile, but it stops processing as
+// soon as @ctx is done, even while waiting for the next event with
+// WithReplaySpeed. @ctx is passed to every @cb call.
+//
+// If stopped by @ctx ProcessFileContext saves the checkpoint (if requested)
+// of the last processed event and returns @ctx error, so the replay could be
+// resumed later.
+func ProcessFileContext(ctx context.Context, path string, cb ContextEventCallback, options ...ReplayOption) error {
+	var cfg ReplayOptions
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	logFile, err := windows.UTF16FromString(path)
+	if err != nil {
+		return fmt.Errorf("incorrect file name; %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r := replayer{
+		ctx:        ctx,
+		cfg:        cfg,
+		callback:   cb,
+		checkpoint: cfg.Checkpoint,
+	}
+	defer r.stopTimer()
+	cgoKey := newCallbackKey(r.handleEvent)
+	defer freeCallbackKey(cgoKey)
+
+	// Ref: https://docs.microsoft.com/en-us/windows/win32/api/evntrace/nf-evntrace-opentracew
+	r.traceHandle = C.OpenTraceFileHelper(
+		(C.LPWSTR)(unsafe.Pointer(&logFile[0])),
+		C.ULONG_PTR(cgoKey),
+	)
+	if C.INVALID_PROCESSTRACE_HANDLE == r.traceHandle {
+		return fmt.Errorf("OpenTraceW failed; %w", windows.GetLastError())
+	}
+	defer C.CloseTrace(r.traceHandle)
+
+	// Let ETW skip the most of already processed events and events outside
+	// the time window for us.
+	start := cfg.StartTime
+	if cfg.Checkpoint.TimeStamp.After(start) {
+		start = cfg.Checkpoint.TimeStamp
+	}
+	var startTime, endTime *C.FILETIME
+	if !start.IsZero() {
+		ft := windows.NsecToFiletime(start.UnixNano())
+		startTime = (*C.FILETIME)(unsafe.Pointer(&ft))
+	}
+	if !cfg.EndTime.IsZero() {
+		ft := windows.NsecToFiletime(cfg.EndTime.UnixNano())
+		endTime = (*C.FILETIME)(unsafe.Pointer(&ft))
+	}
+
+	// Ref: https://docs.microsoft.com/en-us/windows/win32/api/evntrace/nf-evntrace-processtrace
+	ret := C.ProcessTrace(
+		C.PTRACEHANDLE(&r.traceHandle),
+		1,
+		startTime,
+		endTime,
+	)
+	if r.err != nil && r.err != ctx.Err() {
+		return r.err
+	}
+	switch status := windows.Errno(ret); status {
+	case windows.ERROR_SUCCESS, windows.ERROR_CANCELLED:
+	default:
+		return fmt.Errorf("ProcessTrace failed; %w", status)
+	}
+
+	if cfg.OnCheckpoint != nil {
+		if err := cfg.OnCheckpoint(r.checkpoint); err != nil {
+			return fmt.Errorf("failed to save checkpoint; %w", err)
+		}
+	}
+	return r.err
+}
+
+// replayer tracks the processing position of the .etl file.
+type replayer struct {
+	ctx         context.Context
+	cfg         ReplayOptions
+	callback    ContextEventCallback
+	traceHandle C.TRACEHANDLE
+
+	checkpoint      Checkpoint
+	sinceCheckpoint uint64
+	err             error
+
+	// started is when the first event has been delivered and base is its
+	// timestamp. Later events are delivered according to ReplayOptions.Speed
+	// relative to them.
+	started time.Time
+	base    time.Time
+	timer   *time.Timer
+}
+
+// handleEvent skips events not selected and preceding the resume point,
+// passes others to the user callback and saves checkpoints if requested.
+func (r *replayer) handleEvent(e *Event) {
+	if r.err != nil {
+		return
+	}
+	if err := r.ctx.Err(); err != nil {
+		r.stop(err)
+		return
+	}
+	// Skipped events aren't counted in checkpoints, so resuming with the
+	// same selection skips the right events sharing the checkpoint
+	// timestamp.
+	if !r.selected(&e.Header) {
+		return
+	}
+
+	// ETW passes events starting from the checkpoint timestamp, so the only
+	// thing to skip is events sharing it.
+	if resume := r.cfg.Checkpoint; !resume.TimeStamp.IsZero() {
+		switch {
+		case e.Header.TimeStamp.Before(resume.TimeStamp):
+			return
+		case e.Header.TimeStamp.Equal(resume.TimeStamp) && resume.SameTimeStamp > 0:
+			r.cfg.Checkpoint.SameTimeStamp--
+			return
+		}
+	}
+
+	if !r.pace(e.Header.TimeStamp) {
+		r.stop(r.ctx.Err())
+		return
+	}
+	r.callback(r.ctx, e)
+
+	if e.Header.TimeStamp.Equal(r.checkpoint.TimeStamp) {
+		r.checkpoint.SameTimeStamp++
+	} else {
+		r.checkpoint.TimeStamp = e.Header.TimeStamp
+		r.checkpoint.SameTimeStamp = 1
+	}
+	r.checkpoint.Processed++
+
+	if r.cfg.OnCheckpoint == nil || r.cfg.CheckpointInterval == 0 {
+		return
+	}
+	r.sinceCheckpoint++
+	if r.sinceCheckpoint < r.cfg.CheckpointInterval {
+		return
+	}
+	r.sinceCheckpoint = 0
+	if err := r.cfg.OnCheckpoint(r.checkpoint); err != nil {
+		r.stop(fmt.Errorf("failed to save checkpoint; %w", err))
+	}
+}
+
+// selected returns true if the event with the header @h is in the time
+// window and is of the selected providers and IDs. ETW filters the window by
+// buffers, so events at the window edges are checked here.
+func (r *replayer) selected(h *EventHeader) bool {
+	if !r.cfg.StartTime.IsZero() && h.TimeStamp.Before(r.cfg.StartTime) {
+		return false
+	}
+	if !r.cfg.EndTime.IsZero() && h.TimeStamp.After(r.cfg.EndTime) {
+		return false
+	}
+	if r.cfg.Providers == nil {
+		return true
+	}
+	ids, ok := r.cfg.Providers[h.ProviderID]
+	if !ok {
+		return false
+	}
+	if len(ids) == 0 {
+		return true
+	}
+	for _, id := range ids {
+		if id == h.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// stop makes ProcessTrace return with @err.
+func (r *replayer) stop(err error) {
+	r.err = err
+	// Closing the trace from the callback makes ProcessTrace return.
+	C.CloseTrace(r.traceHandle)
+}
+
+// pace blocks until the event with the timestamp @ts is due according to
+// ReplayOptions.Speed. Events that are late (or out of order) are due
+// immediately. Returns false if ctx is done while waiting.
+func (r *replayer) pace(ts time.Time) bool {
+	if r.cfg.Speed <= 0 {
+		return true
+	}
+	if r.started.IsZero() {
+		r.started, r.base = time.Now(), ts
+		return true
+	}
+	offset := time.Duration(float64(ts.Sub(r.base)) / r.cfg.Speed)
+	wait := time.Until(r.started.Add(offset))
+	if wait <= 0 {
+		return true
+	}
+	if r.timer == nil {
+		r.timer = time.NewTimer(wait)
+	} else {
+		r.timer.Reset(wait)
+	}
+	select {
+	case <-r.timer.C:
+		return true
+	case <-r.ctx.Done():
+		if !r.timer.Stop() {
+			<-r.timer.C
+		}
+		return false
+	}
+}
+
+// stopTimer releases the pacing timer.
+func (r *replayer) stopTimer() {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+}
@@ -0,0 +1,72 @@
+// +build windows
+
+package etw
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/windows"
+)
+
+func TestReplayPace(t *testing.T) {
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := replayer{ctx: context.Background(), cfg: ReplayOptions{Speed: 10}}
+	defer r.stopTimer()
+	started := time.Now()
+	assert.True(t, r.pace(base), "First event is not delivered")
+	assert.True(t, r.pace(base.Add(time.Second)), "Second event is not delivered")
+	assert.True(t, r.pace(base.Add(-time.Hour)), "Out of order event is not delivered")
+	elapsed := time.Since(started)
+	assert.True(t, elapsed >= 100*time.Millisecond, "Events are delivered too early: %s", elapsed)
+	assert.True(t, elapsed < time.Second, "Events are not accelerated: %s", elapsed)
+
+	fast := replayer{ctx: context.Background()}
+	started = time.Now()
+	assert.True(t, fast.pace(base), "First event is not delivered")
+	assert.True(t, fast.pace(base.Add(time.Hour)), "Second event is not delivered")
+	assert.True(t, time.Since(started) < time.Second, "Events are paced without Speed")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cancelled := replayer{ctx: ctx, cfg: ReplayOptions{Speed: 1}}
+	defer cancelled.stopTimer()
+	assert.True(t, cancelled.pace(base), "First event is not delivered")
+	assert.False(t, cancelled.pace(base.Add(time.Hour)), "Pacing ignores context cancellation")
+}
+
+func TestReplaySelection(t *testing.T) {
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := windows.GUID{Data1: 0x1}
+	second := windows.GUID{Data1: 0x2}
+
+	var cfg ReplayOptions
+	for _, opt := range []ReplayOption{
+		WithTimeWindow(base, base.Add(time.Minute)),
+		WithReplayProvider(first),
+		WithReplayProvider(first, 5), // Doesn't narrow "all events" down.
+		WithReplayProvider(second, 1, 2),
+	} {
+		opt(&cfg)
+	}
+	r := replayer{cfg: cfg}
+
+	header := func(provider windows.GUID, id uint16, offset time.Duration) *EventHeader {
+		h := &EventHeader{ProviderID: provider, TimeStamp: base.Add(offset)}
+		h.ID = id
+		return h
+	}
+	assert.True(t, r.selected(header(first, 7, 0)), "Window start is not selected")
+	assert.True(t, r.selected(header(first, 7, time.Minute)), "Window end is not selected")
+	assert.False(t, r.selected(header(first, 7, -time.Nanosecond)), "Event before window is selected")
+	assert.False(t, r.selected(header(first, 7, time.Minute+time.Nanosecond)), "Event after window is selected")
+	assert.True(t, r.selected(header(second, 2, time.Second)), "Selected ID is not selected")
+	assert.False(t, r.selected(header(second, 3, time.Second)), "Unselected ID is selected")
+	assert.False(t, r.selected(header(windows.GUID{Data1: 0x3}, 1, time.Second)), "Unselected provider is selected")
+
+	all := replayer{}
+	assert.True(t, all.selected(header(second, 3, -time.Hour)), "Event is not selected without selection")
+}
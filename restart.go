@@ -0,0 +1,111 @@
+//+build windows
+
+package etw
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RestartPolicy describes how a session stopped by someone else, e.g. by
+// `logman stop` or KillSession, is recreated. Take a look at WithAutoRestart.
+type RestartPolicy struct {
+	// MaxRestarts limits the number of restarts over the session lifetime.
+	// Zero means no limit.
+	MaxRestarts int
+
+	// OnStatus is called on every detected external stop with the restart
+	// result. It's called from a `.Process` goroutine after the session has
+	// been recreated (or failed to), so it may call Session methods.
+	OnStatus func(RestartStatus)
+}
+
+// RestartStatus is a notification passed to RestartPolicy.OnStatus.
+type RestartStatus struct {
+	// Restart is a number of the restart starting from 1.
+	Restart int
+
+	// Stopped is when the external stop was detected.
+	Stopped time.Time
+
+	// Err is nil if the session has been recreated and its providers
+	// re-enabled. Otherwise `.Process` returns Err.
+	Err error
+}
+
+// restartGeneration returns a number of successful session restarts. It
+// tells consumers whether the session has been recreated while they were
+// processing events of the stopped one.
+func (s *Session) restartGeneration() int {
+	s.restartMu.Lock()
+	defer s.restartMu.Unlock()
+	return s.restarts
+}
+
+// restartStopped recreates the session if its processing ended because of
+// an external stop. @generation is a restartGeneration value taken before
+// the processing started. Returns true if the caller should resume the
+// processing. Retries of the session creation are cancelled by @ctx of the
+// consumer.
+//
+// Concurrent consumers detect the same stop, but only the first of them
+// recreates the session, others just resume the processing.
+func (s *Session) restartStopped(ctx context.Context, generation int) (bool, error) {
+	resume, status, err := s.restartSession(ctx, generation)
+	if status != nil {
+		s.sendStatus(StatusEvent{Kind: StatusRestarted, Restart: status.Restart, Err: status.Err})
+		if policy := s.Options().AutoRestart; policy != nil && policy.OnStatus != nil {
+			policy.OnStatus(*status)
+		}
+	}
+	return resume, err
+}
+
+// restartSession implements restartStopped. Also returns a status to
+// notify about if the session has been restarted (or failed to) by the call.
+func (s *Session) restartSession(ctx context.Context, generation int) (bool, *RestartStatus, error) {
+	s.restartMu.Lock()
+	defer s.restartMu.Unlock()
+	switch {
+	case s.closed:
+		return false, nil, nil
+	case s.restarts != generation:
+		return true, nil, nil // Restarted by another consumer.
+	case s.restartErr != nil:
+		return false, nil, s.restartErr // Another consumer failed to restart.
+	case s.stopped:
+		return false, nil, nil // Stopped for good, reported by another consumer.
+	}
+	s.stopped = true
+	s.sendStatus(StatusEvent{Kind: StatusStopped})
+	policy := s.Options().AutoRestart
+	if policy == nil {
+		return false, nil, nil
+	}
+
+	status := &RestartStatus{Restart: s.restarts + 1, Stopped: time.Now()}
+	if policy.MaxRestarts > 0 && s.restarts >= policy.MaxRestarts {
+		status.Err = fmt.Errorf("session %s stopped externally; restart limit %d is exceeded",
+			s.describe(), policy.MaxRestarts)
+		s.restartErr = status.Err
+		return false, status, status.Err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.createETWSessionWithRetry(ctx); err != nil {
+		status.Err = fmt.Errorf("failed to recreate session %s; %w", s.describe(), err)
+	} else if err := s.setKernelStackWalk(); err != nil {
+		status.Err = fmt.Errorf("failed to set up session %s; %w", s.describe(), err)
+	} else if err := s.enableProviders(); err != nil {
+		status.Err = fmt.Errorf("failed to re-enable providers of session %s; %w", s.describe(), err)
+	}
+	if status.Err != nil {
+		s.restartErr = status.Err
+		return false, status, status.Err
+	}
+	s.restarts++
+	s.stopped = false
+	return true, status, nil
+}
@@ -0,0 +1,95 @@
+//+build windows
+
+package etw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// minRetryBufferSize is the smallest buffer size in kilobytes RetryPolicy
+// reduces BufferSize to.
+const minRetryBufferSize = 4
+
+// RetryPolicy describes how NewSession retries session creation failed due
+// to a temporary lack of system resources (ERROR_NO_SYSTEM_RESOURCES and
+// out of memory errors). Other errors are returned immediately. Use
+// NewSessionContext to be able to cancel a long retry.
+type RetryPolicy struct {
+	// MaxAttempts is a maximum number of StartTrace calls including the
+	// first one. Values less than 2 disable retries.
+	MaxAttempts int
+
+	// InitialBackoff is a delay before the first retry. Every next delay is
+	// twice longer, but no longer than MaxBackoff if it's set.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// ReduceBufferSize halves BufferSize on every retry (down to 4KB), so
+	// the session needs less non-paged memory. Sessions with the ETW
+	// default buffer size are retried as is.
+	ReduceBufferSize bool
+}
+
+// DefaultRetryPolicy retries session creation for about half a minute.
+//
+//nolint:gochecknoglobals
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:      5,
+	InitialBackoff:   time.Second,
+	MaxBackoff:       15 * time.Second,
+	ReduceBufferSize: true,
+}
+
+// Backoff returns a delay before the @attempt-th retry (starting from 1).
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+// isTransientStartError returns true if StartTrace failed with @err due to a
+// temporary lack of resources.
+func isTransientStartError(err error) bool {
+	return errors.Is(err, windows.ERROR_NO_SYSTEM_RESOURCES) ||
+		errors.Is(err, windows.ERROR_NOT_ENOUGH_MEMORY) ||
+		errors.Is(err, windows.ERROR_OUTOFMEMORY)
+}
+
+// createETWSessionWithRetry calls createETWSession retrying transient
+// failures according to the session RetryPolicy. Waiting for the next retry
+// is interrupted by @ctx, the error wraps ctx.Err() then.
+func (s *Session) createETWSessionWithRetry(ctx context.Context) error {
+	policy := s.config.Retry
+	for attempt := 1; ; attempt++ {
+		err := s.createETWSession()
+		if err == nil || attempt >= policy.MaxAttempts || !isTransientStartError(err) {
+			return err
+		}
+		timer := time.NewTimer(policy.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("retry after %v is cancelled; %w", err, ctx.Err())
+		case <-timer.C:
+		}
+		if policy.ReduceBufferSize && s.config.BufferSize > minRetryBufferSize {
+			s.config.BufferSize /= 2
+			if s.config.BufferSize < minRetryBufferSize {
+				s.config.BufferSize = minRetryBufferSize
+			}
+		}
+	}
+}
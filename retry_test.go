@@ -0,0 +1,49 @@
+// +build windows
+
+package etw_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := etw.RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Second,
+	}
+	for attempt, expected := range []time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		if expected == 0 {
+			continue
+		}
+		assert.Equal(t, expected, policy.Backoff(attempt), "Unexpected backoff of attempt %d", attempt)
+	}
+
+	policy.MaxBackoff = 0
+	assert.Equal(t, 8*time.Second, policy.Backoff(4), "Unlimited backoff is capped")
+}
+
+func TestNewSessionContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The context cancels waiting for retries only, the first StartTrace
+	// attempt is made anyway.
+	session, err := etw.NewSessionContext(ctx, windows.GUID{Data1: 0x1448}, etw.WithRetry(etw.DefaultRetryPolicy))
+	require.NoError(t, err, "Failed to create session with cancelled context")
+	assert.NoError(t, session.Close(), "Failed to close session")
+}
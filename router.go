@@ -0,0 +1,105 @@
+//+build windows
+
+package etw
+
+import (
+	"sort"
+	"sync"
+
+	"golang.org/x/sys/windows"
+)
+
+// EventRouter dispatches events to handlers registered per provider event
+// ID and version. Providers bump event versions keeping IDs when they add
+// properties, so a handler is registered with the minimum event version it
+// understands: an event is passed to the handler with the greatest minimum
+// version not exceeding the event version.
+//
+// E.g. having handlers for versions 0 and 2 of the same event, events of
+// versions 0 and 1 go to the first handler, while versions 2, 3 and later
+// go to the second one. Events with no suitable handler go to the default
+// handler if any.
+//
+// EventRouter is safe for concurrent use, so handlers could be registered
+// while the session is processing events.
+type EventRouter struct {
+	mu       sync.RWMutex
+	routes   map[routeKey][]versionHandler
+	fallback EventCallback
+}
+
+// routeKey identifies an event type regardless of its version.
+type routeKey struct {
+	providerID windows.GUID
+	id         uint16
+}
+
+// versionHandler is a handler of event versions starting from minVersion.
+type versionHandler struct {
+	minVersion uint8
+	cb         EventCallback
+}
+
+// NewEventRouter creates an EventRouter with no handlers.
+func NewEventRouter() *EventRouter {
+	return &EventRouter{routes: make(map[routeKey][]versionHandler)}
+}
+
+// Handle registers @cb for events of the provider @providerGUID with event
+// ID @id and version @minVersion or higher. Registering a handler for the
+// same version again replaces the previous one.
+//
+// Classic (MOF) events have zero ID, their types are told by opcodes, so
+// EventRouter isn't suitable for them.
+func (r *EventRouter) Handle(providerGUID windows.GUID, id uint16, minVersion uint8, cb EventCallback) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := routeKey{providerID: providerGUID, id: id}
+	handlers := r.routes[key]
+	i := sort.Search(len(handlers), func(i int) bool {
+		return handlers[i].minVersion >= minVersion
+	})
+	if i < len(handlers) && handlers[i].minVersion == minVersion {
+		handlers[i].cb = cb
+		return
+	}
+	// Never modify the slice in place, it could be used by EventCallback.
+	updated := make([]versionHandler, 0, len(handlers)+1)
+	updated = append(updated, handlers[:i]...)
+	updated = append(updated, versionHandler{minVersion: minVersion, cb: cb})
+	updated = append(updated, handlers[i:]...)
+	r.routes[key] = updated
+}
+
+// HandleDefault registers @cb for events having no other handler. Nil @cb
+// drops such events.
+func (r *EventRouter) HandleDefault(cb EventCallback) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = cb
+}
+
+// Lookup returns a handler of events of the provider @providerGUID with
+// event ID @id and version @version. Returns nil if there is no one.
+func (r *EventRouter) Lookup(providerGUID windows.GUID, id uint16, version uint8) EventCallback {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	handlers := r.routes[routeKey{providerID: providerGUID, id: id}]
+	i := sort.Search(len(handlers), func(i int) bool {
+		return handlers[i].minVersion > version
+	})
+	if i == 0 {
+		return r.fallback
+	}
+	return handlers[i-1].cb
+}
+
+// EventCallback is an EventCallback passing events to the registered
+// handlers. Pass it to Session.Process.
+func (r *EventRouter) EventCallback(e *Event) {
+	if cb := r.Lookup(e.Header.ProviderID, e.Header.ID, e.Header.Version); cb != nil {
+		cb(e)
+	}
+}
@@ -0,0 +1,47 @@
+// +build windows
+
+package etw_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw"
+)
+
+func TestEventRouter(t *testing.T) {
+	provider := windows.GUID{Data1: 0x1}
+	var got []string
+	handler := func(name string) etw.EventCallback {
+		return func(_ *etw.Event) { got = append(got, name) }
+	}
+	event := func(id uint16, version uint8) *etw.Event {
+		e := &etw.Event{}
+		e.Header.ProviderID = provider
+		e.Header.ID = id
+		e.Header.Version = version
+		return e
+	}
+
+	r := etw.NewEventRouter()
+	r.Handle(provider, 1, 2, handler("v2"))
+	r.Handle(provider, 1, 0, handler("v0"))
+	r.Handle(provider, 2, 1, handler("other"))
+
+	r.EventCallback(event(1, 0))
+	r.EventCallback(event(1, 1))
+	r.EventCallback(event(1, 2))
+	r.EventCallback(event(1, 5))
+	r.EventCallback(event(2, 0)) // No handler for older versions.
+	r.EventCallback(event(3, 0)) // No handler at all.
+	assert.Equal(t, []string{"v0", "v0", "v2", "v2"}, got)
+
+	got = nil
+	r.HandleDefault(handler("default"))
+	r.Handle(provider, 1, 2, handler("v2 replaced"))
+	r.EventCallback(event(1, 3))
+	r.EventCallback(event(2, 0))
+	assert.Equal(t, []string{"v2 replaced", "default"}, got)
+}
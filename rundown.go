@@ -0,0 +1,235 @@
+//+build windows
+
+package etw
+
+/*
+	#include "session.h"
+*/
+import "C"
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Opcodes of rundown events providers log in response to a capture state
+// request or on the session start and stop.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wes/eventmanifestschema-opcodetype-complextype
+//
+//nolint:golint,stylecheck // We keep original names to underline that it's an external constants.
+const (
+	EVENT_TRACE_TYPE_DC_START = 3 // win:DC_Start
+	EVENT_TRACE_TYPE_DC_END   = 4 // win:DC_Stop
+)
+
+// defaultRundownQuietPeriod is a gap between rundown events of a provider
+// that ends a RundownSnapshot by default.
+const defaultRundownQuietPeriod = time.Second
+
+// IsRundown returns true if @h is a header of a rundown event, i.e. the
+// one logged by the provider to report its current state rather than
+// something happened.
+func (h EventHeader) IsRundown() bool {
+	return h.OpCode == EVENT_TRACE_TYPE_DC_START || h.OpCode == EVENT_TRACE_TYPE_DC_END
+}
+
+// CaptureState requests the provider identified by @providerGUID to log its
+// current state, e.g. a list of processes or loaded modules. Providers
+// answer with a storm of rundown events that could be collapsed with
+// RundownBatcher. The provider should be enabled on the processed session.
+func (s *Session) CaptureState(providerGUID windows.GUID) error {
+	revert, err := s.impersonate()
+	if err != nil {
+		return err
+	}
+	defer revert()
+
+	// ULONG WMIAPI EnableTraceEx2(
+	//	TRACEHANDLE              TraceHandle,
+	//	LPCGUID                  ProviderId,
+	//	ULONG                    ControlCode,
+	//	UCHAR                    Level,
+	//	ULONGLONG                MatchAnyKeyword,
+	//	ULONGLONG                MatchAllKeyword,
+	//	ULONG                    Timeout,
+	//	PENABLE_TRACE_PARAMETERS EnableParameters
+	// );
+	ret := C.EnableTraceEx2(
+		s.hSession,
+		(*C.GUID)(unsafe.Pointer(&providerGUID)),
+		C.EVENT_CONTROL_CODE_CAPTURE_STATE,
+		0,
+		0,
+		0,
+		0,
+		nil)
+	if status := windows.Errno(ret); status != windows.ERROR_SUCCESS {
+		return fmt.Errorf("EVENT_CONTROL_CODE_CAPTURE_STATE failed; %w", status)
+	}
+	return nil
+}
+
+// RundownSnapshot is a burst of rundown events of a single provider
+// collapsed into a single object.
+type RundownSnapshot struct {
+	ProviderID windows.GUID
+
+	// Start and End are timestamps of the first and the last rundown events
+	// of the snapshot.
+	Start time.Time
+	End   time.Time
+
+	// Events are the parsed rundown events in the order of arrival. Unlike
+	// ChannelCallback ones they are not pooled and need no `.Release`.
+	Events []ParsedEvent
+
+	// Truncated is a number of rundown events dropped from the snapshot
+	// over RundownOptions.MaxEvents. They are counted in Start and End.
+	Truncated int
+}
+
+// RundownOptions describes a RundownBatcher.
+type RundownOptions struct {
+	// QuietPeriod is a gap between rundown events of a provider that ends
+	// the current snapshot. A second by default.
+	QuietPeriod time.Duration
+
+	// MaxEvents limits the number of events kept in a snapshot, the rest
+	// are only counted. Zero means no limit.
+	MaxEvents int
+
+	// ParseOptions are options rundown events are parsed with.
+	ParseOptions []ParseOption
+}
+
+// RundownOption is any function that modifies RundownOptions.
+type RundownOption func(cfg *RundownOptions)
+
+// WithRundownQuietPeriod ends a snapshot once the provider logs no rundown
+// events for @d.
+func WithRundownQuietPeriod(d time.Duration) RundownOption {
+	return func(cfg *RundownOptions) {
+		cfg.QuietPeriod = d
+	}
+}
+
+// WithRundownMaxEvents keeps at most @n events in a snapshot.
+func WithRundownMaxEvents(n int) RundownOption {
+	return func(cfg *RundownOptions) {
+		cfg.MaxEvents = n
+	}
+}
+
+// WithRundownParseOptions sets @options rundown events are parsed with.
+func WithRundownParseOptions(options ...ParseOption) RundownOption {
+	return func(cfg *RundownOptions) {
+		cfg.ParseOptions = append(cfg.ParseOptions, options...)
+	}
+}
+
+// RundownBatcher collapses storms of rundown events into RundownSnapshot
+// objects, so downstream consumers get a single object per capture state
+// instead of thousands of events.
+//
+// A snapshot of a provider is complete once the provider logs a regular
+// event, its rundown events pause for RundownOptions.QuietPeriod or
+// `.Flush` is called. The quiet period is measured by event timestamps,
+// so the snapshot of an idle session stays pending until `.Flush`.
+type RundownBatcher struct {
+	cfg        RundownOptions
+	parseCfg   ParseOptions
+	onSnapshot func(RundownSnapshot)
+
+	mu      sync.Mutex
+	pending map[windows.GUID]*RundownSnapshot
+}
+
+// NewRundownBatcher creates a RundownBatcher passing complete snapshots to
+// @onSnapshot.
+func NewRundownBatcher(onSnapshot func(RundownSnapshot), options ...RundownOption) *RundownBatcher {
+	cfg := RundownOptions{QuietPeriod: defaultRundownQuietPeriod}
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	var parseCfg ParseOptions
+	for _, opt := range cfg.ParseOptions {
+		opt(&parseCfg)
+	}
+	return &RundownBatcher{
+		cfg:        cfg,
+		parseCfg:   parseCfg,
+		onSnapshot: onSnapshot,
+		pending:    make(map[windows.GUID]*RundownSnapshot),
+	}
+}
+
+// Callback returns an EventCallback that collects rundown events into
+// snapshots and passes all other events to @cb.
+func (b *RundownBatcher) Callback(cb EventCallback) EventCallback {
+	return func(e *Event) {
+		if !e.Header.IsRundown() {
+			b.complete(e.Header.ProviderID, e.Header.TimeStamp, true)
+			cb(e)
+			return
+		}
+		b.add(e)
+	}
+}
+
+// Flush passes all pending snapshots to the snapshot callback. Call it
+// after the processing is done not to lose the last snapshots.
+func (b *RundownBatcher) Flush() {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = make(map[windows.GUID]*RundownSnapshot)
+	b.mu.Unlock()
+
+	for _, snapshot := range pending {
+		b.onSnapshot(*snapshot)
+	}
+}
+
+// add appends the rundown event @e to the pending snapshot of its provider.
+func (b *RundownBatcher) add(e *Event) {
+	guid, ts := e.Header.ProviderID, e.Header.TimeStamp
+	b.complete(guid, ts, false)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	snapshot, ok := b.pending[guid]
+	if !ok {
+		snapshot = &RundownSnapshot{ProviderID: guid, Start: ts}
+		b.pending[guid] = snapshot
+	}
+	snapshot.End = ts
+	if b.cfg.MaxEvents > 0 && len(snapshot.Events) >= b.cfg.MaxEvents {
+		snapshot.Truncated++
+		return
+	}
+	pe := ParsedEvent{Properties: make(map[string]interface{})}
+	pe.fill(e, b.parseCfg, "RundownBatcher")
+	snapshot.Events = append(snapshot.Events, pe)
+}
+
+// complete passes to the snapshot callback pending snapshots ended by an
+// event of the provider @guid logged at @ts: ones that were quiet long
+// enough and, if @regular, the one of the provider itself.
+func (b *RundownBatcher) complete(guid windows.GUID, ts time.Time, regular bool) {
+	var completed []*RundownSnapshot
+	b.mu.Lock()
+	for id, snapshot := range b.pending {
+		if (regular && id == guid) || ts.Sub(snapshot.End) >= b.cfg.QuietPeriod {
+			completed = append(completed, snapshot)
+			delete(b.pending, id)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, snapshot := range completed {
+		b.onSnapshot(*snapshot)
+	}
+}
@@ -0,0 +1,194 @@
+//+build windows
+
+package etw
+
+/*
+	#include <stdlib.h>
+	#include "session.h"
+*/
+import "C"
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// SchemaKey identifies an event schema. Manifest and MOF providers define a
+// single schema for every combination of the fields below.
+type SchemaKey struct {
+	ProviderID windows.GUID
+	ID         uint16
+	Version    uint8
+	OpCode     uint8
+	Task       uint16
+}
+
+// SchemaStorage is a pluggable storage for raw event schemas used by
+// SchemaCache. Implementations should be safe for concurrent use.
+type SchemaStorage interface {
+	// Load returns a schema stored for @key if any.
+	Load(key SchemaKey) ([]byte, bool)
+	// Store saves @schema for @key. Passed @schema is owned by the storage.
+	Store(key SchemaKey, schema []byte)
+}
+
+// SchemaCache caches event schemas (TRACE_EVENT_INFO structures) built by TDH
+// to save TDH calls for already seen event types. Schemas are self-contained
+// binary blobs, so they are safe to be persisted across process restarts.
+//
+// TraceLogging events carry their schemas inside the event itself and are
+// never cached.
+//
+// Along with schemas SchemaCache keeps decoding plans built from them: all
+// static property attributes (names, types, lengths, value maps) are
+// extracted once per event type, so EventProperties maps of the same event
+// type even share key strings.
+//
+// SchemaCache is passed to the parser using WithSchemaCache option.
+type SchemaCache struct {
+	storage SchemaStorage
+
+	// plans holds a *parsePlan of every cached schema.
+	plans sync.Map
+}
+
+// NewSchemaCache creates a SchemaCache backed by the given @storage. Nil
+// @storage means MemorySchemaStorage.
+func NewSchemaCache(storage SchemaStorage) *SchemaCache {
+	if storage == nil {
+		storage = NewMemorySchemaStorage()
+	}
+	return &SchemaCache{storage: storage}
+}
+
+// getEventInformation returns event info along with its parse plan from the
+// cache or queries TDH for it caching the result. Nil SchemaCache is a valid
+// cache that caches nothing.
+//
+// Returned info MUST be freed after use.
+func (c *SchemaCache) getEventInformation(r C.PEVENT_RECORD) (C.PTRACE_EVENT_INFO, *parsePlan, error) {
+	if c == nil || hasTraceLoggingSchema(r) {
+		info, _, err := getEventInformation(r)
+		if err != nil {
+			return info, nil, err
+		}
+		return info, newParsePlan(r, info, true), nil
+	}
+
+	descriptor := r.EventHeader.EventDescriptor
+	key := SchemaKey{
+		ProviderID: windowsGUIDToGo(r.EventHeader.ProviderId),
+		ID:         uint16(descriptor.Id),
+		Version:    uint8(descriptor.Version),
+		OpCode:     uint8(descriptor.Opcode),
+		Task:       uint16(descriptor.Task),
+	}
+	if schema, ok := c.storage.Load(key); ok && len(schema) != 0 {
+		info := C.PTRACE_EVENT_INFO(C.CBytes(schema))
+		return info, c.parsePlan(key, r, info), nil
+	}
+
+	info, size, err := getEventInformation(r)
+	if err != nil {
+		return info, nil, err
+	}
+	c.storage.Store(key, C.GoBytes(unsafe.Pointer(info), C.int(size)))
+	return info, c.parsePlan(key, r, info), nil
+}
+
+// parsePlan returns a parse plan of the schema @info building it on the first
+// call for the given @key.
+func (c *SchemaCache) parsePlan(key SchemaKey, r C.PEVENT_RECORD, info C.PTRACE_EVENT_INFO) *parsePlan {
+	if plan, ok := c.plans.Load(key); ok {
+		// Stored schema could be replaced since then (e.g. by LoadFile),
+		// so make sure the plan still fits it.
+		if plan := plan.(*parsePlan); len(plan.properties) == int(info.PropertyCount) {
+			return plan
+		}
+	}
+	plan := newParsePlan(r, info, false)
+	c.plans.Store(key, plan)
+	return plan
+}
+
+// hasTraceLoggingSchema returns true if the event carries its own schema.
+func hasTraceLoggingSchema(r C.PEVENT_RECORD) bool {
+	if r.EventHeader.Flags&C.EVENT_HEADER_FLAG_EXTENDED_INFO == 0 {
+		return false
+	}
+	for i := 0; i < int(r.ExtendedDataCount); i++ {
+		if C.GetExtType(r.ExtendedData, C.int(i)) == C.EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL {
+			return true
+		}
+	}
+	return false
+}
+
+// MemorySchemaStorage is an in-memory SchemaStorage that could be saved to
+// and loaded from a file to persist schemas across restarts.
+type MemorySchemaStorage struct {
+	mu      sync.RWMutex
+	schemas map[SchemaKey][]byte
+}
+
+// NewMemorySchemaStorage creates an empty MemorySchemaStorage.
+func NewMemorySchemaStorage() *MemorySchemaStorage {
+	return &MemorySchemaStorage{schemas: make(map[SchemaKey][]byte)}
+}
+
+// Load implements SchemaStorage.
+func (m *MemorySchemaStorage) Load(key SchemaKey) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	schema, ok := m.schemas[key]
+	return schema, ok
+}
+
+// Store implements SchemaStorage.
+func (m *MemorySchemaStorage) Store(key SchemaKey, schema []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.schemas[key] = schema
+}
+
+// SaveFile writes all stored schemas to the file located at @path.
+func (m *MemorySchemaStorage) SaveFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create schemas file; %w", err)
+	}
+	defer f.Close()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := gob.NewEncoder(f).Encode(m.schemas); err != nil {
+		return fmt.Errorf("failed to encode schemas; %w", err)
+	}
+	return f.Close()
+}
+
+// LoadFile adds schemas from the file located at @path (previously written
+// by SaveFile) to the storage.
+func (m *MemorySchemaStorage) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open schemas file; %w", err)
+	}
+	defer f.Close()
+
+	var schemas map[SchemaKey][]byte
+	if err := gob.NewDecoder(f).Decode(&schemas); err != nil {
+		return fmt.Errorf("failed to decode schemas; %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, v := range schemas {
+		m.schemas[k] = v
+	}
+	return nil
+}
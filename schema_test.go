@@ -0,0 +1,36 @@
+// +build windows
+
+package etw_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw"
+)
+
+func TestMemorySchemaStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etw-schemas")
+	require.NoError(t, err, "Failed to create temp dir")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "schemas.gob")
+
+	key := etw.SchemaKey{ID: 42, Version: 1}
+	storage := etw.NewMemorySchemaStorage()
+	storage.Store(key, []byte{1, 2, 3})
+	require.NoError(t, storage.SaveFile(path), "Failed to save schemas")
+
+	restored := etw.NewMemorySchemaStorage()
+	require.NoError(t, restored.LoadFile(path), "Failed to load schemas")
+	schema, ok := restored.Load(key)
+	require.True(t, ok, "Schema is lost after restore")
+	assert.Equal(t, []byte{1, 2, 3}, schema)
+
+	_, ok = restored.Load(etw.SchemaKey{ID: 43})
+	assert.False(t, ok, "Got unexpected schema")
+}
@@ -0,0 +1,40 @@
+//+build windows
+
+package seal
+
+import (
+	"context"
+
+	"github.com/bi-zone/etw"
+	"github.com/bi-zone/etw/sinks/jsonl"
+)
+
+// EventCallback returns an etw.EventCallback that seals every received event
+// as a jsonl.Record and passes the entry to @write. Sealing and @write errors
+// are passed to @onError if it's not nil.
+func (c *Chain) EventCallback(write func(e Entry) error, onError func(err error)) etw.EventCallback {
+	return func(e *etw.Event) {
+		entry, err := c.Seal(jsonl.NewRecord(e))
+		if err == nil {
+			err = write(entry)
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// ContextEventCallback is the same as EventCallback, but @write gets the
+// context passed to etw.Session.ProcessContext, e.g. to give up a blocked
+// forward.Forwarder.WriteContext as soon as it's done.
+func (c *Chain) ContextEventCallback(write func(ctx context.Context, e Entry) error, onError func(err error)) etw.ContextEventCallback {
+	return func(ctx context.Context, e *etw.Event) {
+		entry, err := c.Seal(jsonl.NewRecord(e))
+		if err == nil {
+			err = write(ctx, entry)
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
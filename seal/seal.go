@@ -0,0 +1,287 @@
+// Package seal implements a tamper-evident envelope for exported events.
+//
+// Every sealed event becomes an Entry holding a SHA-256 hash of the previous
+// entry hash, the entry sequence number and the serialized event. Any change,
+// removal or reordering of entries breaks the chain. To protect the chain
+// itself Chain periodically signs its head with a caller-provided Signer and
+// attaches the signed Checkpoint to the stream, so the whole trace could be
+// verified later with the matching public key:
+//
+//		c := seal.New(signer, seal.WithCheckpointEvery(1000))
+//		w, err := jsonl.New("events.jsonl")
+//		...
+//		err = session.Process(c.EventCallback(func(e seal.Entry) error { return w.Write(e) }, nil))
+//		...
+//		cp, err := c.Checkpoint() // Sign the tail on shutdown.
+//		err = w.Write(cp)
+//
+// Entries not covered by a checkpoint are chained but not signed, so an
+// attacker could still truncate them. Emit a final checkpoint before closing
+// the output.
+package seal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrBrokenChain is returned by Checker if an entry hash doesn't match
+	// its content or the previous entry.
+	ErrBrokenChain = errors.New("hash chain is broken")
+
+	// ErrBadSignature is returned by Checker if a checkpoint signature is
+	// invalid.
+	ErrBadSignature = errors.New("invalid checkpoint signature")
+)
+
+// Signer signs checkpoint digests. Implementations usually wrap a private
+// key, e.g. ed25519.PrivateKey or a HSM client.
+type Signer interface {
+	Sign(digest []byte) ([]byte, error)
+}
+
+// SignerFunc is an adapter to use ordinary functions as Signer.
+type SignerFunc func(digest []byte) ([]byte, error)
+
+// Sign calls f(digest).
+func (f SignerFunc) Sign(digest []byte) ([]byte, error) {
+	return f(digest)
+}
+
+// Verifier checks checkpoint signatures made by the matching Signer.
+type Verifier interface {
+	Verify(digest, signature []byte) error
+}
+
+// VerifierFunc is an adapter to use ordinary functions as Verifier.
+type VerifierFunc func(digest, signature []byte) error
+
+// Verify calls f(digest, signature).
+func (f VerifierFunc) Verify(digest, signature []byte) error {
+	return f(digest, signature)
+}
+
+// Entry is a single element of the sealed stream. It's either an event
+// (Payload is set) or a standalone checkpoint (Payload is empty) that signs
+// the head of the chain.
+type Entry struct {
+	// Seq is a sequence number of the entry starting from 1. Standalone
+	// checkpoints have Seq of the entry they sign.
+	Seq uint64
+
+	// Payload is the serialized event.
+	Payload json.RawMessage `json:",omitempty"`
+
+	// Hash is SHA-256 of the previous entry Hash, big-endian Seq and Payload.
+	// Standalone checkpoints have Hash of the entry they sign.
+	Hash []byte
+
+	// Checkpoint is set if the chain head (including this entry) is signed.
+	Checkpoint *Checkpoint `json:",omitempty"`
+}
+
+// Checkpoint is a signature of the chain head.
+type Checkpoint struct {
+	Time      time.Time
+	Signature []byte
+}
+
+// Options describes how often Chain emits checkpoints.
+type Options struct {
+	// CheckpointEvery makes Chain sign every N-th entry. Zero disables
+	// count-based checkpoints.
+	CheckpointEvery uint64
+
+	// CheckpointInterval makes Chain sign the first entry sealed after the
+	// interval since the last checkpoint has passed. Zero disables
+	// time-based checkpoints.
+	CheckpointInterval time.Duration
+}
+
+// Option is any function that modifies Options.
+type Option func(cfg *Options)
+
+// WithCheckpointEvery makes Chain sign every @n-th entry.
+func WithCheckpointEvery(n uint64) Option {
+	return func(cfg *Options) {
+		cfg.CheckpointEvery = n
+	}
+}
+
+// WithCheckpointInterval makes Chain sign an entry at least once per @d
+// while entries keep coming.
+func WithCheckpointInterval(d time.Duration) Option {
+	return func(cfg *Options) {
+		cfg.CheckpointInterval = d
+	}
+}
+
+// Chain seals events into a hash chain. Chain is safe for concurrent use,
+// entries are numbered in the order Seal calls acquire the internal lock.
+type Chain struct {
+	signer Signer
+	cfg    Options
+
+	mu             sync.Mutex
+	seq            uint64
+	head           []byte
+	lastCheckpoint time.Time
+}
+
+// New creates a Chain signing checkpoints with @signer. Without options
+// checkpoints are emitted only by explicit Checkpoint calls.
+func New(signer Signer, options ...Option) *Chain {
+	var cfg Options
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	return &Chain{
+		signer: signer,
+		cfg:    cfg,
+		head:   make([]byte, sha256.Size),
+	}
+}
+
+// Seal serializes @v to JSON and appends it to the chain. The resulting entry
+// carries a Checkpoint if one is due.
+func (c *Chain) Seal(v interface{}) (Entry, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to encode value; %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.lastCheckpoint.IsZero() {
+		c.lastCheckpoint = now
+	}
+	e := Entry{
+		Seq:     c.seq + 1,
+		Payload: payload,
+		Hash:    chainHash(c.head, c.seq+1, payload),
+	}
+	if c.checkpointDue(e.Seq, now) {
+		if e.Checkpoint, err = c.sign(e.Seq, e.Hash, now); err != nil {
+			return Entry{}, err
+		}
+	}
+	c.seq, c.head = e.Seq, e.Hash
+	return e, nil
+}
+
+// Checkpoint signs the current chain head and returns a standalone
+// checkpoint entry. Call it before closing the output to protect the tail.
+func (c *Chain) Checkpoint() (Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cp, err := c.sign(c.seq, c.head, time.Now())
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{Seq: c.seq, Hash: append([]byte(nil), c.head...), Checkpoint: cp}, nil
+}
+
+func (c *Chain) checkpointDue(seq uint64, now time.Time) bool {
+	if c.cfg.CheckpointEvery > 0 && seq%c.cfg.CheckpointEvery == 0 {
+		return true
+	}
+	if c.cfg.CheckpointInterval > 0 && now.Sub(c.lastCheckpoint) >= c.cfg.CheckpointInterval {
+		return true
+	}
+	return false
+}
+
+func (c *Chain) sign(seq uint64, hash []byte, now time.Time) (*Checkpoint, error) {
+	sig, err := c.signer.Sign(CheckpointDigest(seq, hash, now))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign checkpoint; %w", err)
+	}
+	c.lastCheckpoint = now
+	return &Checkpoint{Time: now, Signature: sig}, nil
+}
+
+// CheckpointDigest returns a digest that is signed for the chain head with
+// @seq and @hash at time @t.
+func CheckpointDigest(seq uint64, hash []byte, t time.Time) []byte {
+	h := sha256.New()
+	h.Write([]byte("etw-seal-checkpoint"))
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], seq)
+	binary.BigEndian.PutUint64(buf[8:], uint64(t.UnixNano()))
+	h.Write(buf[:])
+	h.Write(hash)
+	return h.Sum(nil)
+}
+
+func chainHash(prev []byte, seq uint64, payload []byte) []byte {
+	h := sha256.New()
+	h.Write(prev)
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], seq)
+	h.Write(buf[:])
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// Checker verifies a sealed stream entry by entry.
+type Checker struct {
+	verifier Verifier
+
+	seq      uint64
+	head     []byte
+	verified uint64
+}
+
+// NewChecker creates a Checker verifying checkpoints with @verifier.
+func NewChecker(verifier Verifier) *Checker {
+	return &Checker{verifier: verifier, head: make([]byte, sha256.Size)}
+}
+
+// Check verifies the next entry of the stream. Entries must be passed in
+// the order they were sealed starting from the first one. After an error
+// the Checker state is undefined.
+func (c *Checker) Check(e Entry) error {
+	if len(e.Payload) != 0 {
+		if e.Seq != c.seq+1 {
+			return fmt.Errorf("%w: expected entry %d, got %d", ErrBrokenChain, c.seq+1, e.Seq)
+		}
+		if hash := chainHash(c.head, e.Seq, e.Payload); !bytes.Equal(hash, e.Hash) {
+			return fmt.Errorf("%w: entry %d hash mismatch", ErrBrokenChain, e.Seq)
+		}
+		c.seq, c.head = e.Seq, e.Hash
+	} else if e.Seq != c.seq || !bytes.Equal(e.Hash, c.head) {
+		return fmt.Errorf("%w: checkpoint for %d doesn't match the chain head", ErrBrokenChain, e.Seq)
+	} else if e.Checkpoint == nil {
+		return fmt.Errorf("%w: entry %d has neither payload nor checkpoint", ErrBrokenChain, e.Seq)
+	}
+
+	if e.Checkpoint != nil {
+		digest := CheckpointDigest(e.Seq, e.Hash, e.Checkpoint.Time)
+		if err := c.verifier.Verify(digest, e.Checkpoint.Signature); err != nil {
+			return fmt.Errorf("%w: checkpoint for %d; %v", ErrBadSignature, e.Seq, err)
+		}
+		c.verified = e.Seq
+	}
+	return nil
+}
+
+// Seq returns a sequence number of the last checked entry.
+func (c *Checker) Seq() uint64 {
+	return c.seq
+}
+
+// Verified returns a sequence number of the last entry covered by a valid
+// checkpoint. Entries after it are chained but could have been truncated.
+func (c *Checker) Verified() uint64 {
+	return c.verified
+}
@@ -0,0 +1,151 @@
+package seal_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/seal"
+)
+
+func keys(t *testing.T) (seal.Signer, seal.Verifier) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err, "Failed to generate key")
+
+	signer := seal.SignerFunc(func(digest []byte) ([]byte, error) {
+		return ed25519.Sign(priv, digest), nil
+	})
+	verifier := seal.VerifierFunc(func(digest, signature []byte) error {
+		if !ed25519.Verify(pub, digest, signature) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	})
+	return signer, verifier
+}
+
+// sealed returns a stream of @n events with the final standalone checkpoint
+// after a round trip through JSON.
+func sealed(t *testing.T, c *seal.Chain, n int) []seal.Entry {
+	var entries []seal.Entry
+	for i := 0; i < n; i++ {
+		e, err := c.Seal(map[string]interface{}{"ID": i, "Image": "<cmd.exe>"})
+		require.NoError(t, err, "Failed to seal event")
+		entries = append(entries, e)
+	}
+	cp, err := c.Checkpoint()
+	require.NoError(t, err, "Failed to make checkpoint")
+	entries = append(entries, cp)
+
+	raw, err := json.Marshal(entries)
+	require.NoError(t, err, "Failed to encode entries")
+	var decoded []seal.Entry
+	require.NoError(t, json.Unmarshal(raw, &decoded), "Failed to decode entries")
+	return decoded
+}
+
+func check(verifier seal.Verifier, entries []seal.Entry) (*seal.Checker, error) {
+	c := seal.NewChecker(verifier)
+	for _, e := range entries {
+		if err := c.Check(e); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func TestChain(t *testing.T) {
+	signer, verifier := keys(t)
+	entries := sealed(t, seal.New(signer, seal.WithCheckpointEvery(3)), 7)
+	require.Len(t, entries, 8)
+
+	var checkpoints []uint64
+	for _, e := range entries {
+		if e.Checkpoint != nil {
+			checkpoints = append(checkpoints, e.Seq)
+		}
+	}
+	assert.Equal(t, []uint64{3, 6, 7}, checkpoints, "Unexpected checkpoints")
+
+	c, err := check(verifier, entries)
+	require.NoError(t, err, "Failed to verify untouched stream")
+	assert.EqualValues(t, 7, c.Seq())
+	assert.EqualValues(t, 7, c.Verified())
+
+	// Without the final checkpoint the tail is chained but not signed.
+	c, err = check(verifier, entries[:5])
+	require.NoError(t, err, "Failed to verify truncated stream")
+	assert.EqualValues(t, 5, c.Seq())
+	assert.EqualValues(t, 3, c.Verified())
+}
+
+func TestCheckpointInterval(t *testing.T) {
+	signer, verifier := keys(t)
+	c := seal.New(signer, seal.WithCheckpointInterval(time.Millisecond))
+
+	first, err := c.Seal("first")
+	require.NoError(t, err)
+	assert.Nil(t, first.Checkpoint, "Unexpected checkpoint of the first entry")
+
+	time.Sleep(5 * time.Millisecond)
+	second, err := c.Seal("second")
+	require.NoError(t, err)
+	assert.NotNil(t, second.Checkpoint, "No checkpoint after interval")
+
+	_, err = check(verifier, []seal.Entry{first, second})
+	assert.NoError(t, err)
+}
+
+func TestTampering(t *testing.T) {
+	signer, verifier := keys(t)
+	_, otherVerifier := keys(t)
+	original := sealed(t, seal.New(signer, seal.WithCheckpointEvery(2)), 4)
+
+	clone := func() []seal.Entry {
+		entries := make([]seal.Entry, len(original))
+		copy(entries, original)
+		return entries
+	}
+
+	tests := []struct {
+		name   string
+		modify func(entries []seal.Entry) []seal.Entry
+		want   error
+	}{
+		{"changed payload", func(entries []seal.Entry) []seal.Entry {
+			entries[1].Payload = json.RawMessage(`{"ID":100}`)
+			return entries
+		}, seal.ErrBrokenChain},
+		{"removed entry", func(entries []seal.Entry) []seal.Entry {
+			return append(entries[:1], entries[2:]...)
+		}, seal.ErrBrokenChain},
+		{"reordered entries", func(entries []seal.Entry) []seal.Entry {
+			entries[0], entries[1] = entries[1], entries[0]
+			return entries
+		}, seal.ErrBrokenChain},
+		{"forged checkpoint time", func(entries []seal.Entry) []seal.Entry {
+			cp := *entries[1].Checkpoint
+			cp.Time = cp.Time.Add(time.Hour)
+			entries[1].Checkpoint = &cp
+			return entries
+		}, seal.ErrBadSignature},
+		{"dangling checkpoint", func(entries []seal.Entry) []seal.Entry {
+			return append(entries[:2], entries[4])
+		}, seal.ErrBrokenChain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := check(verifier, tt.modify(clone()))
+			assert.True(t, errors.Is(err, tt.want), "Unexpected error %v", err)
+		})
+	}
+
+	_, err := check(otherVerifier, original)
+	assert.True(t, errors.Is(err, seal.ErrBadSignature), "Foreign key accepted, error %v", err)
+}
@@ -0,0 +1,109 @@
+//+build windows
+
+package etw
+
+/*
+	#include "session.h"
+*/
+import "C"
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// eventTraceSecureMode restricts logging to the session with processes
+// having TRACELOG_LOG_EVENT permission (EVENT_TRACE_SECURE_MODE).
+const eventTraceSecureMode = 0x00000080
+
+//nolint:gochecknoglobals
+var procImpersonateLoggedOnUser = windows.NewLazySystemDLL("advapi32.dll").NewProc("ImpersonateLoggedOnUser")
+
+// Session GUID access rights granted by EventAccessControl (wmistr.h).
+const (
+	wmiguidQuery           = 0x0001
+	tracelogGUIDEnable     = 0x0080
+	tracelogLogEvent       = 0x0200
+	tracelogAccessRealtime = 0x0400
+)
+
+// eventSecurityAddDACL is EventSecurityAddDACL of EVENTSECURITYOPERATION.
+const eventSecurityAddDACL = 2
+
+//nolint:gochecknoglobals
+var procEventAccessControl = windows.NewLazySystemDLL("advapi32.dll").NewProc("EventAccessControl")
+
+// GrantLogging allows processes of the account identified by @sid to log
+// events to the SecureMode session (TRACELOG_LOG_EVENT). The grant is a
+// persistent entry of the session GUID DACL, so it outlives the session;
+// use SessionOptions.SessionGUID to keep the GUID stable across restarts.
+func (s *Session) GrantLogging(sid *windows.SID) error {
+	if err := s.grantAccess(sid, tracelogLogEvent); err != nil {
+		return fmt.Errorf("failed to grant logging to session %s; %w", s.describe(), err)
+	}
+	return nil
+}
+
+// grantAccess adds the @rights for @sid to the session GUID DACL.
+func (s *Session) grantAccess(sid *windows.SID, rights uint32) error {
+	revert, err := s.impersonate()
+	if err != nil {
+		return err
+	}
+	defer revert()
+
+	// The session GUID is generated by ETW unless it's the kernel logger or
+	// set with SessionGUID, so ask ETW for it.
+	propertiesBuf, err := controlTraceRaw(s.hSession, nil, C.EVENT_TRACE_CONTROL_QUERY)
+	if err != nil {
+		return fmt.Errorf("failed to query session; %w", err)
+	}
+	sessionGUID := tracePropertiesFromBuf(propertiesBuf).SessionGUID
+
+	// ULONG EventAccessControl(
+	//  LPGUID  Guid,
+	//  ULONG   Operation,
+	//  PSID    Sid,
+	//  ULONG   Rights,
+	//  BOOLEAN AllowOrDeny
+	// );
+	ret, _, _ := procEventAccessControl.Call(
+		uintptr(unsafe.Pointer(&sessionGUID)),
+		eventSecurityAddDACL,
+		uintptr(unsafe.Pointer(sid)),
+		uintptr(rights),
+		1)
+	if status := windows.Errno(ret); status != windows.ERROR_SUCCESS {
+		return fmt.Errorf("EventAccessControl failed; %w", status)
+	}
+	return nil
+}
+
+// impersonate makes the calling goroutine act under the session
+// SecurityContext token. Impersonation is a property of the OS thread, so
+// the goroutine is locked to its thread until returned @revert is called.
+// Without SecurityContext set impersonate does nothing.
+//
+// N.B. Impersonations can't be nested: the inner revert drops the outer
+// impersonation too.
+func (s *Session) impersonate() (revert func(), err error) {
+	if s.token == 0 {
+		return func() {}, nil
+	}
+
+	runtime.LockOSThread()
+	if ok, _, err := procImpersonateLoggedOnUser.Call(uintptr(s.token)); ok == 0 {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("ImpersonateLoggedOnUser failed; %w", err)
+	}
+	return func() {
+		// Failing to revert leaves the thread with a foreign token, so never
+		// give such thread back to the scheduler: a thread locked by an
+		// exited goroutine is terminated by the runtime.
+		if err := windows.RevertToSelf(); err == nil {
+			runtime.UnlockOSThread()
+		}
+	}, nil
+}
@@ -1,5 +1,7 @@
 #include "session.h"
 #include <in6addr.h>
+#include <stdlib.h>
+#include <string.h>
 
 // handleEvent is exported from Go to CGO. Unfortunately CGO can't vary calling
 // convention of exported functions (or we don't know da way), so wrap the Go's
@@ -10,18 +12,61 @@ void WINAPI stdcallHandleEvent(PEVENT_RECORD e) {
     handleEvent(e);
 }
 
+// handleBuffer is exported from Go to CGO and wrapped the same way as
+// handleEvent.
+extern ULONG handleBuffer(PEVENT_TRACE_LOGFILEW logFile);
+
+ULONG WINAPI stdcallHandleBuffer(PEVENT_TRACE_LOGFILEW logFile) {
+    return handleBuffer(logFile);
+}
+
 // OpenTraceHelper helps to access EVENT_TRACE_LOGFILEW union fields and pass
 // pointer to C not warning CGO checker.
-TRACEHANDLE OpenTraceHelper(LPWSTR name, PVOID ctx) {
+TRACEHANDLE OpenTraceHelper(LPWSTR name, ULONG_PTR ctx) {
     EVENT_TRACE_LOGFILEW trace = {0};
     trace.LoggerName = name;
-    trace.Context = ctx;
+    trace.Context = (PVOID)ctx;
     trace.ProcessTraceMode = PROCESS_TRACE_MODE_REAL_TIME | PROCESS_TRACE_MODE_EVENT_RECORD;
     trace.EventRecordCallback = stdcallHandleEvent;
 
     return OpenTraceW(&trace);
 }
 
+// OpenTraceFileHelper is the same as OpenTraceHelper but opens an .etl file
+// located at @logFile for events processing.
+TRACEHANDLE OpenTraceFileHelper(LPWSTR logFile, ULONG_PTR ctx) {
+    EVENT_TRACE_LOGFILEW trace = {0};
+    trace.LogFileName = logFile;
+    trace.Context = (PVOID)ctx;
+    trace.ProcessTraceMode = PROCESS_TRACE_MODE_EVENT_RECORD;
+    trace.EventRecordCallback = stdcallHandleEvent;
+
+    return OpenTraceW(&trace);
+}
+
+// OpenTraceExHelper opens either a real-time session named @loggerName or an
+// @logFile with custom @processTraceMode flags. If @withBufferCallback is set
+// buffer statistics are passed to the Go side. @isKernelTrace and @header
+// receive the values of the same names set by OpenTraceW.
+TRACEHANDLE OpenTraceExHelper(LPWSTR loggerName, LPWSTR logFile, ULONG processTraceMode,
+                              BOOL withBufferCallback, ULONG_PTR ctx, BOOL* isKernelTrace,
+                              PTRACE_LOGFILE_HEADER header) {
+    EVENT_TRACE_LOGFILEW trace = {0};
+    trace.LoggerName = loggerName;
+    trace.LogFileName = logFile;
+    trace.Context = (PVOID)ctx;
+    trace.ProcessTraceMode = processTraceMode | PROCESS_TRACE_MODE_EVENT_RECORD;
+    trace.EventRecordCallback = stdcallHandleEvent;
+    if (withBufferCallback) {
+        trace.BufferCallback = stdcallHandleBuffer;
+    }
+
+    TRACEHANDLE handle = OpenTraceW(&trace);
+    *isKernelTrace = trace.IsKernelTrace;
+    *header = trace.LogfileHeader;
+    return handle;
+}
+
 int getLengthFromProperty(PEVENT_RECORD event, PROPERTY_DATA_DESCRIPTOR* dataDescriptor, UINT32* length) {
     DWORD propertySize = 0;
     ULONG status = ERROR_SUCCESS;
@@ -29,6 +74,12 @@ int getLengthFromProperty(PEVENT_RECORD event, PROPERTY_DATA_DESCRIPTOR* dataDes
     if (status != ERROR_SUCCESS) {
         return status;
     }
+    // Length properties are integers of up to 32 bits, anything bigger would
+    // overflow @length.
+    if (propertySize > sizeof(*length)) {
+        return ERROR_EVT_INVALID_EVENT_DATA;
+    }
+    *length = 0;
     status = TdhGetProperty(event, 0, NULL, 1, dataDescriptor, propertySize, (PBYTE)length);
     return status;
 }
@@ -36,10 +87,13 @@ int getLengthFromProperty(PEVENT_RECORD event, PROPERTY_DATA_DESCRIPTOR* dataDes
 // https://docs.microsoft.com/ru-ru/windows/win32/etw/using-tdhformatproperty-to-consume-event-data
 ULONG GetArraySize(PEVENT_RECORD event, PTRACE_EVENT_INFO info, int i, UINT32* count) {
     ULONG status = ERROR_SUCCESS;
-    PROPERTY_DATA_DESCRIPTOR dataDescriptor;
+    PROPERTY_DATA_DESCRIPTOR dataDescriptor = {0};
 
     if ((info->EventPropertyInfoArray[i].Flags & PropertyParamCount) == PropertyParamCount) {
-        PROPERTY_DATA_DESCRIPTOR DataDescriptor = {0};
+        // Never trust indexes from the event schema.
+        if (info->EventPropertyInfoArray[i].countPropertyIndex >= info->PropertyCount) {
+            return ERROR_EVT_INVALID_EVENT_DATA;
+        }
         // Use the countPropertyIndex member of the EVENT_PROPERTY_INFO structure
         // to locate the property that contains the size of the array.
         dataDescriptor.PropertyName = GetPropertyName(info, info->EventPropertyInfoArray[i].countPropertyIndex);
@@ -61,6 +115,10 @@ ULONG GetPropertyLength(PEVENT_RECORD event, PTRACE_EVENT_INFO info, int i, UINT
     // If the property is a binary blob it can point to another property that defines the
     // blob's size. The PropertyParamLength flag tells you where the blob's size is defined.
     if ((info->EventPropertyInfoArray[i].Flags & PropertyParamLength) == PropertyParamLength) {
+        // Never trust indexes from the event schema.
+        if (info->EventPropertyInfoArray[i].lengthPropertyIndex >= info->PropertyCount) {
+            return ERROR_EVT_INVALID_EVENT_DATA;
+        }
         ULONG status = ERROR_SUCCESS;
         PROPERTY_DATA_DESCRIPTOR dataDescriptor = {0};
         dataDescriptor.PropertyName = GetPropertyName(info, info->EventPropertyInfoArray[i].lengthPropertyIndex);
@@ -128,6 +186,28 @@ BOOL PropertyIsArray(PTRACE_EVENT_INFO info, int i) {
     (info->EventPropertyInfoArray[i].count > 1);
 }
 
+// Determine whether the property count or length is defined by another
+// property, i.e. should be read from every event.
+BOOL PropertyHasParamCount(PTRACE_EVENT_INFO info, int i) {
+    return (info->EventPropertyInfoArray[i].Flags & PropertyParamCount) == PropertyParamCount;
+}
+
+BOOL PropertyHasParamLength(PTRACE_EVENT_INFO info, int i) {
+    return (info->EventPropertyInfoArray[i].Flags & PropertyParamLength) == PropertyParamLength;
+}
+
+// Valid only if PropertyHasParamCount is true.
+USHORT GetCountPropertyIndex(PTRACE_EVENT_INFO info, int i) {
+    return info->EventPropertyInfoArray[i].countPropertyIndex;
+}
+
+// Determine whether the property has a value map. Zero MapNameOffset means
+// no map at all, that is common for MOF classes.
+BOOL PropertyHasMap(PTRACE_EVENT_INFO info, int i) {
+    return (info->EventPropertyInfoArray[i].Flags & PropertyStruct) != PropertyStruct &&
+        info->EventPropertyInfoArray[i].nonStructType.MapNameOffset != 0;
+}
+
 int GetStructStartIndex(PTRACE_EVENT_INFO info, int i) {
     return info->EventPropertyInfoArray[i].structType.StructStartIndex;
 }
@@ -172,3 +252,56 @@ ULONG GetAddress32(PEVENT_EXTENDED_ITEM_STACK_TRACE32 trace32, int j) {
 ULONGLONG GetAddress64(PEVENT_EXTENDED_ITEM_STACK_TRACE64 trace64, int j) {
    return trace64->Address[j];
 }
+
+ULONG GetProviderCount(PPROVIDER_ENUMERATION_INFO info) {
+    return info->NumberOfProviders;
+}
+
+LPWSTR GetProviderName(PPROVIDER_ENUMERATION_INFO info, int i) {
+    return (LPWSTR)((PBYTE)(info) + info->TraceProviderInfoArray[i].ProviderNameOffset);
+}
+
+GUID GetProviderGUID(PPROVIDER_ENUMERATION_INFO info, int i) {
+    return info->TraceProviderInfoArray[i].ProviderGuid;
+}
+
+ULONG64 GetHistoricalContext(PEVENT_TRACE_PROPERTIES properties) {
+    return properties->Wnode.HistoricalContext;
+}
+
+// align8 rounds @size up to a multiple of 8.
+static size_t align8(size_t size) {
+    return (size + 7) & ~(size_t)7;
+}
+
+PEVENT_RECORD CopyEventRecord(PEVENT_RECORD src) {
+    size_t itemsSize = src->ExtendedDataCount * sizeof(EVENT_HEADER_EXTENDED_DATA_ITEM);
+    size_t size = align8(sizeof(EVENT_RECORD)) + align8(itemsSize) + align8(src->UserDataLength);
+    for (int i = 0; i < src->ExtendedDataCount; i++) {
+        size += align8(src->ExtendedData[i].DataSize);
+    }
+
+    PBYTE buf = (PBYTE)malloc(size);
+    if (buf == NULL) {
+        return NULL;
+    }
+    PEVENT_RECORD dst = (PEVENT_RECORD)buf;
+    *dst = *src;
+    PBYTE next = buf + align8(sizeof(EVENT_RECORD));
+
+    dst->ExtendedData = NULL;
+    if (src->ExtendedDataCount != 0) {
+        dst->ExtendedData = (PEVENT_HEADER_EXTENDED_DATA_ITEM)next;
+        memcpy(next, src->ExtendedData, itemsSize);
+        next += align8(itemsSize);
+        for (int i = 0; i < src->ExtendedDataCount; i++) {
+            memcpy(next, (PVOID)src->ExtendedData[i].DataPtr, src->ExtendedData[i].DataSize);
+            dst->ExtendedData[i].DataPtr = (ULONGLONG)next;
+            next += align8(src->ExtendedData[i].DataSize);
+        }
+    }
+
+    dst->UserData = next;
+    memcpy(next, src->UserData, src->UserDataLength);
+    return dst;
+}
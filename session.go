@@ -17,8 +17,12 @@ package etw
 */
 import "C"
 import (
+	"context"
 	"fmt"
+	"math"
 	"math/rand"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -51,18 +55,79 @@ func (e ExistsError) Error() string {
 // Session should be closed via `.Close` call to free obtained OS resources
 // even if `.Process` has never been called.
 type Session struct {
-	guid     windows.GUID
-	config   SessionOptions
-	callback EventCallback
+	guid   windows.GUID
+	config SessionOptions
 
 	etwSessionName []uint16
 	hSession       C.TRACEHANDLE
 	propertiesBuf  []byte
+	adopted        bool
+	released       bool
+
+	// token is SecurityContext the session is controlled under.
+	token windows.Token
+
+	// labels are Labels the session was created with. Never modified.
+	labels map[string]string
+
+	// kernelFlags are EnableFlags of the NT Kernel Logger session. Zero
+	// for regular sessions.
+	kernelFlags KernelFlag
+
+	// mu guards providers and processing state. Providers added via
+	// AddProvider are stored along with its own subscription options, so
+	// each of them could be updated independently. Providers disabled with
+	// PauseProvider are kept in paused.
+	mu         sync.Mutex
+	providers  map[windows.GUID]SessionOptions
+	paused     map[windows.GUID]bool
+	processing bool
+
+	// filter holds an eventFilter built from the current session options.
+	filter atomic.Value
+
+	// watch holds a callbackWatch built from the current session options.
+	watch atomic.Value
+
+	// userContext holds a userContext set by SetContext.
+	userContext atomic.Value
+
+	// info holds a SessionInfo decoded from the trace header event.
+	info atomic.Value
+
+	// finalStats holds TraceProperties reported by ETW on session stop.
+	finalStats atomic.Value
+
+	// stats holds *providerCounters of every provider events were received
+	// from.
+	stats sync.Map
+
+	// traces are handles of the opened real-time consumers. They are closed
+	// by `.Close` explicitly, so processing stops even if the session has
+	// been stopped by someone else and ETW doesn't unblock ProcessTrace.
+	tracesMu sync.Mutex
+	traces   map[C.TRACEHANDLE]struct{}
+
+	// restartMu guards the state of session restarts made according to
+	// SessionOptions.AutoRestart: the number of successful restarts, the
+	// error of the failed one and whether `.Close` has been called.
+	restartMu  sync.Mutex
+	restarts   int
+	restartErr error
+	stopped    bool
+	closed     bool
+
+	// status is a channel returned by `.Status`. statusMu guards sending to
+	// it after it's closed by `.Close`.
+	statusMu     sync.Mutex
+	status       chan StatusEvent
+	statusClosed bool
 }
 
 // EventCallback is any function that could handle an ETW event. EventCallback
 // is called synchronously and sequentially on every event received by Session
-// one by one.
+// one by one, unless the session is created with CallbackParallel mode (take
+// a look at CallbackMode for ordering guarantees).
 //
 // If EventCallback can't handle all ETW events produced, OS will handle a
 // tricky file-based cache for you, however, it's recommended not to perform
@@ -81,6 +146,18 @@ type EventCallback func(e *Event)
 // You MUST call `.Close` on session after use to clear associated resources,
 // otherwise it will leak in OS internals until system reboot.
 func NewSession(providerGUID windows.GUID, options ...Option) (*Session, error) {
+	return newSession(context.Background(), providerGUID, 0, options...)
+}
+
+// NewSessionContext is the same as NewSession, but waiting for session
+// creation retries (take a look at WithRetry) stops as soon as @ctx is done.
+func NewSessionContext(ctx context.Context, providerGUID windows.GUID, options ...Option) (*Session, error) {
+	return newSession(ctx, providerGUID, 0, options...)
+}
+
+// newSession implements NewSession and NewKernelSession. Non-zero
+// @kernelFlags make the session the NT Kernel Logger.
+func newSession(ctx context.Context, guid windows.GUID, kernelFlags KernelFlag, options ...Option) (*Session, error) {
 	defaultConfig := SessionOptions{
 		Name:  "go-etw-" + randomName(),
 		Level: TRACE_LEVEL_VERBOSE,
@@ -88,9 +165,19 @@ func NewSession(providerGUID windows.GUID, options ...Option) (*Session, error)
 	for _, opt := range options {
 		opt(&defaultConfig)
 	}
+	if err := defaultConfig.validate(); err != nil {
+		return nil, err
+	}
 	s := Session{
-		guid:   providerGUID,
-		config: defaultConfig,
+		guid:        guid,
+		config:      defaultConfig,
+		kernelFlags: kernelFlags,
+		token:       defaultConfig.SecurityContext,
+		labels:      copyLabels(defaultConfig.Labels),
+		providers:   make(map[windows.GUID]SessionOptions),
+		paused:      make(map[windows.GUID]bool),
+		traces:      make(map[C.TRACEHANDLE]struct{}),
+		status:      make(chan StatusEvent, statusBufferSize+1),
 	}
 
 	utf16Name, err := windows.UTF16FromString(s.config.Name)
@@ -98,9 +185,15 @@ func NewSession(providerGUID windows.GUID, options ...Option) (*Session, error)
 		return nil, fmt.Errorf("incorrect session name; %w", err) // unlikely
 	}
 	s.etwSessionName = utf16Name
+	s.filter.Store(newEventFilter(s.config))
+	s.watch.Store(newCallbackWatch(s.config))
 
-	if err := s.createETWSession(); err != nil {
-		return nil, fmt.Errorf("failed to create session; %w", err)
+	if err := s.createETWSessionWithRetry(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create session %s; %w", s.describe(), err)
+	}
+	if err := s.setKernelStackWalk(); err != nil {
+		_ = s.stopSession()
+		return nil, fmt.Errorf("failed to set up session %s; %w", s.describe(), err)
 	}
 	// TODO: consider setting a finalizer with .Close
 
@@ -111,47 +204,377 @@ func NewSession(providerGUID windows.GUID, options ...Option) (*Session, error)
 // synchronously and sequentially. Take a look to EventCallback documentation
 // for more info about events processing.
 //
+// Process could be called from several goroutines with distinct callbacks.
+// Every call opens its own real-time consumer of the session, so every
+// callback receives all the session events at its own pace; a slow
+// consumer doesn't delay others (but could make ETW lose its events).
+// Providers are enabled by the first call.
+//
+// If WithThreadPriority or WithThreadAffinity are set the calling goroutine
+// is locked to its OS thread for the processing time.
+//
+// Process also returns if the session is stopped by someone else unless
+// WithAutoRestart is set.
+//
 // N.B. Process blocks until `.Close` being called!
 func (s *Session) Process(cb EventCallback) error {
-	s.callback = cb
+	return s.process(context.Background(), cb)
+}
+
+// ContextEventCallback is an EventCallback that receives the context passed
+// to `.ProcessContext`. Callbacks doing I/O (e.g. sending events over the
+// network) should honor its cancellation and deadline.
+type ContextEventCallback func(ctx context.Context, e *Event)
+
+// ProcessContext is the same as `.Process`, but it stops processing (of
+// this consumer only, the session keeps running) as soon as @ctx is done.
+// @ctx is passed to every @cb call.
+//
+// ProcessContext returns nil if stopped by either @ctx or `.Close`.
+func (s *Session) ProcessContext(ctx context.Context, cb ContextEventCallback) error {
+	return s.process(ctx, func(e *Event) {
+		cb(ctx, e)
+	})
+}
+
+// Run processes the session events passing them to @cb until @ctx is done,
+// then closes the session and waits for the processing to finish. It saves
+// the boilerplate of running `.Process` in a goroutine and closing the
+// session on shutdown:
+//
+//		ctx, cancel := context.WithCancel(context.Background())
+//		defer cancel() // E.g. call cancel() on SIGINT.
+//		err := session.Run(ctx, cb)
+//
+// The session is closed when Run returns in any case. Run returns the first
+// error occurred: processing or closing one; stopping by @ctx is not an
+// error.
+func (s *Session) Run(ctx context.Context, cb EventCallback) error {
+	processed := make(chan error, 1)
+	go func() {
+		processed <- s.Process(cb)
+	}()
+
+	select {
+	case err := <-processed:
+		if closeErr := s.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	case <-ctx.Done():
+	}
+	// Close releases trace handles even if it fails, so processing stops
+	// anyway and it's safe to wait for it.
+	closeErr := s.Close()
+	if err := <-processed; closeErr == nil {
+		return err
+	}
+	return closeErr
+}
 
-	if err := s.subscribeToProvider(); err != nil {
-		return fmt.Errorf("failed to subscribe to provider; %w", err)
+// process implements Process and ProcessContext.
+func (s *Session) process(ctx context.Context, cb EventCallback) error {
+	if err := s.subscribeToProviders(); err != nil {
+		return err
 	}
 
-	cgoKey := newCallbackKey(s)
+	cb = s.watched(cb)
+	if cfg := s.Options(); cfg.CallbackMode == CallbackParallel {
+		dispatcher := newParallelDispatcher(cb, cfg.CallbackWorkers, 0)
+		defer dispatcher.wait()
+		cb = dispatcher.dispatch
+	}
+	if cfg := s.Options(); len(cfg.KernelStackWalk) != 0 {
+		correlator := newStackWalkCorrelator(cb, cfg.KernelStackWalk)
+		defer correlator.flush()
+		cb = correlator.handle
+	}
+	cgoKey := newCallbackKey(s.consumer(cb))
 	defer freeCallbackKey(cgoKey)
 
-	// Will block here until being closed.
-	if err := s.processEvents(cgoKey); err != nil {
-		return fmt.Errorf("error processing events; %w", err)
+	restoreThread, err := lockProcessingThread(s.Options())
+	if err != nil {
+		return err
+	}
+	defer restoreThread()
+
+	for {
+		generation := s.restartGeneration()
+		// Will block here until being closed.
+		if err := s.processEvents(ctx, cgoKey); err != nil {
+			return fmt.Errorf("error processing events of session %s; %w", s.describe(), err)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		// Neither @ctx nor `.Close` stopped the processing, so the session
+		// has been stopped by someone else.
+		resume, err := s.restartStopped(ctx, generation)
+		if !resume {
+			if ctx.Err() != nil {
+				return nil // The restart has been cancelled.
+			}
+			return err
+		}
 	}
-	return nil
 }
 
 // UpdateOptions changes subscription parameters in runtime. The only option
 // that can't be updated is session name. To change session name -- stop and
 // recreate a session with new desired name.
 func (s *Session) UpdateOptions(options ...Option) error {
+	s.mu.Lock()
+	cfg := s.config.clone()
 	for _, opt := range options {
-		opt(&s.config)
+		opt(&cfg)
 	}
-	if err := s.subscribeToProvider(); err != nil {
+	if err := cfg.validate(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.config = cfg
+	paused := s.paused[s.guid]
+	s.mu.Unlock()
+
+	s.filter.Store(newEventFilter(cfg))
+	s.watch.Store(newCallbackWatch(cfg))
+	if paused {
+		return nil // Will be applied on `.ResumeProvider`.
+	}
+	if err := s.subscribeToProvider(s.guid, cfg); err != nil {
 		return err
 	}
 	return nil
 }
 
+// Options returns a copy of the effective session options of the primary
+// session provider, i.e. ones passed to NewSession with all subsequent
+// `.UpdateOptions` applied.
+func (s *Session) Options() SessionOptions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config.clone()
+}
+
+// Providers returns a copy of the effective options of all session
+// providers including the primary one.
+func (s *Session) Providers() map[windows.GUID]SessionOptions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	providers := make(map[windows.GUID]SessionOptions, len(s.providers)+1)
+	providers[s.guid] = s.config.clone()
+	for guid, cfg := range s.providers {
+		providers[guid] = cfg.clone()
+	}
+	return providers
+}
+
+// CloneWithOptions creates a new session subscribed to the same providers
+// with the same options as the current one, but modified with @options.
+// Useful to compare volume of events produced by alternative filter
+// configurations side-by-side.
+//
+// The cloned session gets a new random name and no log file unless they are
+// set with @options explicitly. Like any other session the clone should be
+// closed via `.Close` after use.
+func (s *Session) CloneWithOptions(options ...Option) (*Session, error) {
+	if s.isKernel() {
+		return nil, fmt.Errorf("NT Kernel Logger session can't be cloned")
+	}
+	cfg := s.Options()
+	cfg.Name = "go-etw-" + randomName()
+	cfg.LogFileName = ""
+	clone, err := NewSession(s.guid, append([]Option{withConfig(cfg)}, options...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	providers := make(map[windows.GUID]SessionOptions, len(s.providers))
+	for guid, providerCfg := range s.providers {
+		providerCfg.Name = clone.config.Name
+		providers[guid] = providerCfg.clone()
+	}
+	s.mu.Unlock()
+
+	clone.providers = providers
+	return clone, nil
+}
+
+// AddProvider subscribes the session to one more provider identified by
+// @providerGUID. Options of the session primary provider are not inherited:
+// @options are applied to fresh SessionOptions having TRACE_LEVEL_VERBOSE
+// level only and affect the added provider only; session name can't be
+// changed this way.
+//
+// If the session is already processing events the provider is enabled
+// immediately, otherwise it will be enabled on `.Process` call.
+func (s *Session) AddProvider(providerGUID windows.GUID, options ...Option) error {
+	if providerGUID == s.guid {
+		return s.UpdateOptions(options...)
+	}
+	if s.isKernel() {
+		return fmt.Errorf("NT Kernel Logger session can't host other providers")
+	}
+	cfg := SessionOptions{
+		Name:  s.config.Name,
+		Level: TRACE_LEVEL_VERBOSE,
+	}
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	cfg.Name = s.config.Name
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.processing && !s.paused[providerGUID] {
+		if err := s.subscribeToProvider(providerGUID, cfg); err != nil {
+			return fmt.Errorf("failed to subscribe to provider %s; %w", providerGUID, err)
+		}
+	}
+	s.providers[providerGUID] = cfg
+	return nil
+}
+
+// RemoveProvider disables the provider identified by @providerGUID added
+// with `.AddProvider` and forgets its options, so it's not re-enabled on
+// restarts and isn't reported by `.Providers` anymore. The primary session
+// provider can't be removed.
+func (s *Session) RemoveProvider(providerGUID windows.GUID) error {
+	if providerGUID == s.guid {
+		return fmt.Errorf("primary provider %s can't be removed from the session", providerGUID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.providers[providerGUID]; !ok {
+		return fmt.Errorf("provider %s is not enabled on the session", providerGUID)
+	}
+	if s.processing && !s.paused[providerGUID] {
+		if err := s.unsubscribeFromProvider(providerGUID); err != nil {
+			return fmt.Errorf("failed to disable provider %s; %w", providerGUID, err)
+		}
+	}
+	delete(s.providers, providerGUID)
+	delete(s.paused, providerGUID)
+	return nil
+}
+
+// SetLevel changes the maximum level of events received from the provider
+// identified by @providerGUID. Only the given provider is re-enabled with
+// a new level, subscriptions to other session providers stay untouched.
+func (s *Session) SetLevel(providerGUID windows.GUID, lvl TraceLevel) error {
+	if providerGUID == s.guid {
+		return s.UpdateOptions(WithLevel(lvl))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg, ok := s.providers[providerGUID]
+	if !ok {
+		return fmt.Errorf("provider %s is not enabled on the session", providerGUID)
+	}
+	cfg.Level = lvl
+	if s.processing && !s.paused[providerGUID] {
+		if err := s.subscribeToProvider(providerGUID, cfg); err != nil {
+			return fmt.Errorf("failed to update provider %s; %w", providerGUID, err)
+		}
+	}
+	s.providers[providerGUID] = cfg
+	return nil
+}
+
+// PauseProvider temporarily disables the provider identified by
+// @providerGUID keeping its subscription options, so it could be enabled
+// back with `.ResumeProvider`. Options of a paused provider could still be
+// updated, they are applied on resume.
+//
+// Unlike filtering in EventCallback pausing stops the provider from writing
+// events to the session at all, so it's the cheapest way to shed load.
+func (s *Session) PauseProvider(providerGUID windows.GUID) error {
+	if s.isKernel() {
+		return fmt.Errorf("NT Kernel Logger session providers can't be paused")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.providers[providerGUID]; !ok && providerGUID != s.guid {
+		return fmt.Errorf("provider %s is not enabled on the session", providerGUID)
+	}
+	if s.paused[providerGUID] {
+		return nil
+	}
+	if err := s.unsubscribeFromProvider(providerGUID); err != nil {
+		return fmt.Errorf("failed to disable provider %s; %w", providerGUID, err)
+	}
+	s.paused[providerGUID] = true
+	return nil
+}
+
+// ResumeProvider enables the provider paused with `.PauseProvider` using its
+// current subscription options. Resuming a provider that isn't paused is
+// a no-op.
+func (s *Session) ResumeProvider(providerGUID windows.GUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.paused[providerGUID] {
+		return nil
+	}
+	cfg := s.config
+	if providerGUID != s.guid {
+		cfg = s.providers[providerGUID]
+	}
+	if s.processing {
+		if err := s.subscribeToProvider(providerGUID, cfg); err != nil {
+			return fmt.Errorf("failed to enable provider %s; %w", providerGUID, err)
+		}
+	}
+	delete(s.paused, providerGUID)
+	return nil
+}
+
+// Paused returns true if the provider identified by @providerGUID is paused
+// with `.PauseProvider`.
+func (s *Session) Paused(providerGUID windows.GUID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused[providerGUID]
+}
+
 // Close stops trace session and frees associated resources.
+//
+// Consumers opened by `.Process` calls are closed in any case, so Process
+// returns even if Close fails, e.g. the session has been killed externally.
+// Close does nothing for a session given up with `.Release`.
 func (s *Session) Close() error {
+	// Wait for a restart in progress to stop the recreated session.
+	s.restartMu.Lock()
+	defer s.restartMu.Unlock()
+	if s.released {
+		return nil
+	}
+	s.closed = true
+	defer s.closeStatus()
+	defer s.closeTraces()
+
 	// "Be sure to disable all providers before stopping the session."
 	// https://docs.microsoft.com/en-us/windows/win32/etw/configuring-and-starting-an-event-tracing-session
-	if err := s.unsubscribeFromProvider(); err != nil {
+	if err := s.unsubscribeFromProvider(s.guid); err != nil {
 		return fmt.Errorf("failed to disable provider; %w", err)
 	}
+	s.mu.Lock()
+	for guid := range s.providers {
+		if err := s.unsubscribeFromProvider(guid); err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("failed to disable provider %s; %w", guid, err)
+		}
+	}
+	s.processing = false
+	s.mu.Unlock()
 
 	if err := s.stopSession(); err != nil {
-		return fmt.Errorf("failed to stop session; %w", err)
+		return fmt.Errorf("failed to stop session %s; %w", s.describe(), err)
 	}
 	return nil
 }
@@ -206,16 +629,95 @@ func KillSession(name string) error {
 	}
 }
 
+// userContext wraps a value set by SetContext to store it in atomic.Value
+// regardless of its type.
+type userContext struct{ value interface{} }
+
+// SetContext attaches an arbitrary user value @v to the session. The value
+// is available inside EventCallback via Event.Context, so the same callback
+// could serve several sessions without global variables.
+func (s *Session) SetContext(v interface{}) {
+	s.userContext.Store(userContext{value: v})
+}
+
+// Info returns the session description reported by ETW in the trace header
+// event. The event is delivered first after `.Process` starts, so Info
+// returns false before that.
+func (s *Session) Info() (SessionInfo, bool) {
+	info, ok := s.info.Load().(SessionInfo)
+	return info, ok
+}
+
+// FinalStats returns the session properties reported by ETW when the
+// session is stopped by `.Close`. Counters like EventsLost, BuffersWritten
+// and RealTimeBuffersLost make a loss report of the whole session lifetime.
+// FinalStats returns false until the session is closed.
+func (s *Session) FinalStats() (TraceProperties, bool) {
+	stats, ok := s.finalStats.Load().(TraceProperties)
+	return stats, ok
+}
+
+// consumer returns an EventCallback that passes session events to @cb.
+func (s *Session) consumer(cb EventCallback) EventCallback {
+	return func(e *Event) {
+		s.handleEvent(e, cb)
+	}
+}
+
+// handleEvent updates provider stats, drops events not matching session
+// filters and passes others to @cb. The trace header event is consumed to
+// fill SessionInfo.
+func (s *Session) handleEvent(e *Event, cb EventCallback) {
+	if isTraceHeader(&e.Header) {
+		if data, err := e.UserData(); err == nil {
+			if info, err := parseSessionInfo(data, e.Header.PointerSize()); err == nil {
+				s.info.Store(info)
+			}
+		}
+		return
+	}
+
+	s.reportLoss(&e.Header)
+
+	stats := s.providerCounters(e.Header.ProviderID)
+	stats.recordEvent(&e.Header, e.PayloadSize())
+
+	filter := s.filter.Load().(eventFilter)
+	if !filter.match(&e.Header) {
+		atomic.AddUint64(&stats.dropped, 1)
+		return
+	}
+	e.stats = stats
+	e.labels = s.labels
+	if ctx, ok := s.userContext.Load().(userContext); ok {
+		e.userContext = ctx.value
+	}
+	cb(e)
+}
+
 // createETWSession wraps StartTraceW.
 func (s *Session) createETWSession() error {
-	// We need to allocate a sequential buffer for a structure and a session name
-	// which will be placed there by an API call (for the future calls).
+	revert, err := s.impersonate()
+	if err != nil {
+		return err
+	}
+	defer revert()
+
+	// We need to allocate a sequential buffer for a structure, a session name
+	// and an optional log file name which will be placed there by an API call
+	// (for the future calls).
 	//
 	// (Ref: https://docs.microsoft.com/en-us/windows/win32/etw/wnode-header#members)
 	//
 	// The only way to do it in go -- unsafe cast of the allocated memory.
+	logFileName, err := s.logFileName()
+	if err != nil {
+		return fmt.Errorf("incorrect log file name; %w", err)
+	}
+	propertiesSize := int(unsafe.Sizeof(C.EVENT_TRACE_PROPERTIES{}))
 	sessionNameSize := len(s.etwSessionName) * int(unsafe.Sizeof(s.etwSessionName[0]))
-	bufSize := int(unsafe.Sizeof(C.EVENT_TRACE_PROPERTIES{})) + sessionNameSize
+	logFileNameSize := len(logFileName) * int(unsafe.Sizeof(uint16(0)))
+	bufSize := propertiesSize + sessionNameSize + logFileNameSize
 	propertiesBuf := make([]byte, bufSize)
 
 	// We will use Query Performance Counter for timestamp cos it gives us higher
@@ -227,9 +729,51 @@ func (s *Session) createETWSession() error {
 	pProperties.Wnode.BufferSize = C.ulong(bufSize)
 	pProperties.Wnode.ClientContext = 1 // QPC for event Timestamp
 	pProperties.Wnode.Flags = C.WNODE_FLAG_TRACED_GUID
+	pProperties.LoggerNameOffset = C.ulong(propertiesSize)
+	pProperties.BufferSize = C.ulong(s.config.BufferSize)
+	pProperties.MinimumBuffers = C.ulong(s.config.MinimumBuffers)
+	pProperties.MaximumBuffers = C.ulong(s.config.MaximumBuffers)
+	flushTimer, msFlushTimer := flushTimerValue(s.config.FlushTimer)
+	pProperties.FlushTimer = C.ulong(flushTimer)
+
+	// Kernel events are selected by session flags instead of providers
+	// subscription.
+	if s.isKernel() {
+		pProperties.Wnode.Guid = *(*C.GUID)(unsafe.Pointer(&SystemTraceControlGUID))
+		pProperties.EnableFlags = C.ulong(s.kernelFlags)
+	} else if s.config.SessionGUID != (windows.GUID{}) {
+		pProperties.Wnode.Guid = *(*C.GUID)(unsafe.Pointer(&s.config.SessionGUID))
+	}
 
 	// Mark that we are going to process events in real time using a callback.
 	pProperties.LogFileMode = C.EVENT_TRACE_REAL_TIME_MODE
+	if s.config.NoPerProcessorBuffering || s.config.CallbackMode == CallbackStrictOrder {
+		// Per-processor buffers are merged by buffers, not events.
+		pProperties.LogFileMode |= C.EVENT_TRACE_NO_PER_PROCESSOR_BUFFERING
+	}
+	if msFlushTimer {
+		pProperties.LogFileMode |= eventTraceUseMSFlushTimer
+	}
+	if s.config.SecureMode {
+		pProperties.LogFileMode |= eventTraceSecureMode
+	}
+	if s.config.IndependentSession {
+		pProperties.LogFileMode |= eventTraceIndependentSessionMode
+	}
+
+	// Having a log file set events are written both to the real-time consumer
+	// and to the file (hybrid mode).
+	if logFileName != nil {
+		pProperties.LogFileNameOffset = C.ulong(propertiesSize + sessionNameSize)
+		copy(propertiesBuf[propertiesSize+sessionNameSize:], utf16ToBytes(logFileName))
+
+		if s.config.LogFileMaxSize != 0 {
+			pProperties.LogFileMode |= C.EVENT_TRACE_FILE_MODE_NEWFILE
+			pProperties.MaximumFileSize = C.ulong(s.config.LogFileMaxSize)
+		} else {
+			pProperties.LogFileMode |= C.EVENT_TRACE_FILE_MODE_SEQUENTIAL
+		}
+	}
 
 	ret := C.StartTraceW(
 		&s.hSession,
@@ -238,6 +782,9 @@ func (s *Session) createETWSession() error {
 	)
 	switch err := windows.Errno(ret); err {
 	case windows.ERROR_ALREADY_EXISTS:
+		if s.config.AdoptExisting {
+			return s.adoptETWSession()
+		}
 		return ExistsError{SessionName: s.config.Name}
 	case windows.ERROR_SUCCESS:
 		s.propertiesBuf = propertiesBuf
@@ -247,15 +794,117 @@ func (s *Session) createETWSession() error {
 	}
 }
 
+// adoptETWSession takes over a running session with the same name, e.g.
+// left by a crashed previous run of the application.
+func (s *Session) adoptETWSession() error {
+	propertiesBuf, err := controlTraceRaw(0, s.etwSessionName, C.EVENT_TRACE_CONTROL_QUERY)
+	if err != nil {
+		return fmt.Errorf("failed to query existing session; %w", err)
+	}
+	pProperties := (C.PEVENT_TRACE_PROPERTIES)(unsafe.Pointer(&propertiesBuf[0]))
+	s.hSession = C.TRACEHANDLE(C.GetHistoricalContext(pProperties))
+	s.propertiesBuf = propertiesBuf
+	s.adopted = true
+	return nil
+}
+
+// Adopted returns true if the session took over an existing one instead of
+// creating a new session. Take a look at WithAdoptExisting.
+func (s *Session) Adopted() bool {
+	return s.adopted
+}
+
+// logFileName returns UTF16 encoded name of the session log file or nil if
+// the session is a real-time only one.
+//
+// For rotated log files the name is extended with a timestamp and `%d`
+// placeholder which is replaced by ETW with a file sequence number.
+func (s *Session) logFileName() ([]uint16, error) {
+	name := s.config.LogFileName
+	if name == "" {
+		return nil, nil
+	}
+	if s.config.LogFileMaxSize != 0 {
+		ext := filepath.Ext(name)
+		timestamp := time.Now().Format("20060102T150405")
+		name = fmt.Sprintf("%s-%s-%%d%s", strings.TrimSuffix(name, ext), timestamp, ext)
+	}
+	return windows.UTF16FromString(name)
+}
+
+// utf16ToBytes returns a byte representation of the given UTF16 string
+// suitable to be copied into WinAPI structures.
+func utf16ToBytes(str []uint16) []byte {
+	if len(str) == 0 {
+		return nil
+	}
+	return byteSlice(unsafe.Pointer(&str[0]), len(str)*int(unsafe.Sizeof(str[0])))
+}
+
+// subscribeToProviders enables all the session providers except paused ones
+// marking the session as processing one. Providers of already processing
+// session are left as is.
+func (s *Session) subscribeToProviders() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.processing {
+		return nil
+	}
+	if err := s.enableProviders(); err != nil {
+		return err
+	}
+	s.sendStatus(StatusEvent{Kind: StatusStarted})
+	return nil
+}
+
+// enableProviders enables the primary and all the added providers of the
+// session except paused ones. s.mu should be held.
+func (s *Session) enableProviders() error {
+	if !s.paused[s.guid] {
+		if err := s.subscribeToProvider(s.guid, s.config); err != nil {
+			return fmt.Errorf("failed to subscribe to provider; %w", err)
+		}
+	}
+
+	s.processing = true
+	for guid, cfg := range s.providers {
+		if s.paused[guid] {
+			continue
+		}
+		if err := s.subscribeToProvider(guid, cfg); err != nil {
+			return fmt.Errorf("failed to subscribe to provider %s; %w", guid, err)
+		}
+	}
+	return nil
+}
+
 // subscribeToProvider wraps EnableTraceEx2 with EVENT_CONTROL_CODE_ENABLE_PROVIDER.
-func (s *Session) subscribeToProvider() error {
+func (s *Session) subscribeToProvider(guid windows.GUID, cfg SessionOptions) error {
+	if s.isKernel() && guid == s.guid {
+		return nil // Kernel events are enabled by the session itself.
+	}
+	revert, err := s.impersonate()
+	if err != nil {
+		return err
+	}
+	defer revert()
+
 	// https://docs.microsoft.com/en-us/windows/win32/etw/configuring-and-starting-an-event-tracing-session
 	params := C.ENABLE_TRACE_PARAMETERS{
 		Version: 2, // ENABLE_TRACE_PARAMETERS_VERSION_2
 	}
-	for _, p := range s.config.EnableProperties {
+	for _, p := range cfg.EnableProperties {
 		params.EnableProperty |= C.ULONG(p)
 	}
+	if cfg.SourceGUID != (windows.GUID{}) {
+		params.SourceId = *(*C.GUID)(unsafe.Pointer(&cfg.SourceGUID))
+	}
+	if len(cfg.EventIDs) != 0 {
+		filter := newEventIDFilter(cfg.EventIDs)
+		defer C.free(unsafe.Pointer(filter))
+		params.EnableFilterDesc = filter
+		params.FilterDescCount = 1
+	}
 
 	// ULONG WMIAPI EnableTraceEx2(
 	//	TRACEHANDLE              TraceHandle,
@@ -271,23 +920,52 @@ func (s *Session) subscribeToProvider() error {
 	// Ref: https://docs.microsoft.com/en-us/windows/win32/api/evntrace/nf-evntrace-enabletraceex2
 	ret := C.EnableTraceEx2(
 		s.hSession,
-		(*C.GUID)(unsafe.Pointer(&s.guid)),
+		(*C.GUID)(unsafe.Pointer(&guid)),
 		C.EVENT_CONTROL_CODE_ENABLE_PROVIDER,
-		C.UCHAR(s.config.Level),
-		C.ULONGLONG(s.config.MatchAnyKeyword),
-		C.ULONGLONG(s.config.MatchAllKeyword),
-		0,       // Timeout set to zero to enable the trace asynchronously
+		C.UCHAR(cfg.Level),
+		C.ULONGLONG(cfg.MatchAnyKeyword),
+		C.ULONGLONG(cfg.MatchAllKeyword),
+		enableTimeout(cfg.EnableTimeout),
 		&params, //nolint:gocritic // TODO: dupSubExpr?? gocritic bug?
 	)
 
-	if status := windows.Errno(ret); status != windows.ERROR_SUCCESS {
+	switch status := windows.Errno(ret); status {
+	case windows.ERROR_SUCCESS:
+		s.sendStatus(StatusEvent{Kind: StatusProviderEnabled, ProviderID: guid})
+		return nil
+	case windows.ERROR_TIMEOUT:
+		return fmt.Errorf("provider hasn't processed the enable request in %s; %w", cfg.EnableTimeout, status)
+	default:
 		return fmt.Errorf("EVENT_CONTROL_CODE_ENABLE_PROVIDER failed; %w", status)
 	}
-	return nil
+}
+
+// enableTimeout converts @d to EnableTraceEx2 Timeout milliseconds. Zero
+// timeout enables the trace asynchronously.
+func enableTimeout(d time.Duration) C.ULONG {
+	switch ms := d / time.Millisecond; {
+	case d <= 0:
+		return 0
+	case ms == 0:
+		return 1 // Don't turn sub-millisecond timeouts into the async mode.
+	case ms > math.MaxUint32:
+		return math.MaxUint32
+	default:
+		return C.ULONG(ms)
+	}
 }
 
 // unsubscribeFromProvider wraps EnableTraceEx2 with EVENT_CONTROL_CODE_DISABLE_PROVIDER.
-func (s *Session) unsubscribeFromProvider() error {
+func (s *Session) unsubscribeFromProvider(guid windows.GUID) error {
+	if s.isKernel() && guid == s.guid {
+		return nil
+	}
+	revert, err := s.impersonate()
+	if err != nil {
+		return err
+	}
+	defer revert()
+
 	// ULONG WMIAPI EnableTraceEx2(
 	//	TRACEHANDLE              TraceHandle,
 	//	LPCGUID                  ProviderId,
@@ -300,7 +978,7 @@ func (s *Session) unsubscribeFromProvider() error {
 	// );
 	ret := C.EnableTraceEx2(
 		s.hSession,
-		(*C.GUID)(unsafe.Pointer(&s.guid)),
+		(*C.GUID)(unsafe.Pointer(&guid)),
 		C.EVENT_CONTROL_CODE_DISABLE_PROVIDER,
 		0,
 		0,
@@ -315,17 +993,77 @@ func (s *Session) unsubscribeFromProvider() error {
 	return status
 }
 
-// processEvents subscribes to the actual provider events and starts its processing.
-func (s *Session) processEvents(callbackContextKey uintptr) error {
+// processEvents subscribes to the actual provider events and starts its
+// processing. The trace is closed as soon as @ctx is done.
+func (s *Session) processEvents(ctx context.Context, callbackContextKey uintptr) error {
+	traceHandle, err := s.openTrace(callbackContextKey)
+	if err != nil {
+		return err
+	}
+	s.tracesMu.Lock()
+	s.traces[traceHandle] = struct{}{}
+	s.tracesMu.Unlock()
+	defer s.closeTrace(traceHandle)
+
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				// ProcessTrace returns ERROR_CANCELLED then.
+				s.closeTrace(traceHandle)
+			case <-stop:
+			}
+		}()
+	}
+	return processTraces([]C.TRACEHANDLE{traceHandle})
+}
+
+// closeTrace wraps CloseTrace making sure the opened @traceHandle is closed
+// exactly once. Closing the trace being processed makes ProcessTrace return.
+func (s *Session) closeTrace(traceHandle C.TRACEHANDLE) {
+	s.tracesMu.Lock()
+	defer s.tracesMu.Unlock()
+	if _, ok := s.traces[traceHandle]; !ok {
+		return
+	}
+	delete(s.traces, traceHandle)
+	// ERROR_CTX_CLOSE_PENDING is returned if the trace is being processed,
+	// it's closed after ProcessTrace returns then.
+	C.CloseTrace(traceHandle)
+}
+
+// closeTraces closes all the opened real-time consumers of the session.
+func (s *Session) closeTraces() {
+	s.tracesMu.Lock()
+	handles := make([]C.TRACEHANDLE, 0, len(s.traces))
+	for handle := range s.traces {
+		handles = append(handles, handle)
+	}
+	s.tracesMu.Unlock()
+	for _, handle := range handles {
+		s.closeTrace(handle)
+	}
+}
+
+// openTrace opens the session real-time events stream. Events will be passed
+// to the callback identified by @callbackContextKey.
+func (s *Session) openTrace(callbackContextKey uintptr) (C.TRACEHANDLE, error) {
 	// Ref: https://docs.microsoft.com/en-us/windows/win32/api/evntrace/nf-evntrace-opentracew
 	traceHandle := C.OpenTraceHelper(
 		(C.LPWSTR)(unsafe.Pointer(&s.etwSessionName[0])),
-		(C.PVOID)(callbackContextKey),
+		C.ULONG_PTR(callbackContextKey),
 	)
 	if C.INVALID_PROCESSTRACE_HANDLE == traceHandle {
-		return fmt.Errorf("OpenTraceW failed; %w", windows.GetLastError())
+		return traceHandle, fmt.Errorf("OpenTraceW failed; %w", windows.GetLastError())
 	}
+	return traceHandle, nil
+}
 
+// processTraces processes events from all the opened @traceHandles. Events
+// from different traces are delivered in the timestamp order.
+func processTraces(traceHandles []C.TRACEHANDLE) error {
 	// BLOCKS UNTIL CLOSED!
 	//
 	// Ref: https://docs.microsoft.com/en-us/windows/win32/api/evntrace/nf-evntrace-processtrace
@@ -336,8 +1074,8 @@ func (s *Session) processEvents(callbackContextKey uintptr) error {
 	// 	LPFILETIME   EndTime
 	// );
 	ret := C.ProcessTrace(
-		C.PTRACEHANDLE(&traceHandle),
-		1,   // ^ Imagine we pass an array with 1 element here.
+		C.PTRACEHANDLE(&traceHandles[0]),
+		C.ULONG(len(traceHandles)),
 		nil, // Do not want to limit StartTime (default is from now).
 		nil, // Do not want to limit EndTime.
 	)
@@ -351,6 +1089,12 @@ func (s *Session) processEvents(callbackContextKey uintptr) error {
 
 // stopSession wraps ControlTraceW with EVENT_TRACE_CONTROL_STOP.
 func (s *Session) stopSession() error {
+	revert, err := s.impersonate()
+	if err != nil {
+		return err
+	}
+	defer revert()
+
 	// ULONG WMIAPI ControlTraceW(
 	//  TRACEHANDLE             TraceHandle,
 	//  LPCWSTR                 InstanceName,
@@ -368,6 +1112,8 @@ func (s *Session) stopSession() error {
 	// https://docs.microsoft.com/en-us/windows/win32/api/evntrace/nf-evntrace-controltracew
 	switch status := windows.Errno(ret); status {
 	case windows.ERROR_MORE_DATA, windows.ERROR_SUCCESS:
+		// The properties buffer is filled with the final session stats.
+		s.finalStats.Store(tracePropertiesFromBuf(s.propertiesBuf))
 		return nil
 	default:
 		return status
@@ -395,21 +1141,21 @@ func randomName() string {
 //
 //nolint:gochecknoglobals
 var (
-	sessions       sync.Map
-	sessionCounter uintptr
+	callbacks       sync.Map
+	callbackCounter uintptr
 )
 
-// newCallbackKey stores a @ptr inside a global storage returning its' key.
+// newCallbackKey stores a @cb inside a global storage returning its' key.
 // After use the key should be freed using `freeCallbackKey`.
-func newCallbackKey(ptr *Session) uintptr {
-	key := atomic.AddUintptr(&sessionCounter, 1)
-	sessions.Store(key, ptr)
+func newCallbackKey(cb EventCallback) uintptr {
+	key := atomic.AddUintptr(&callbackCounter, 1)
+	callbacks.Store(key, cb)
 
 	return key
 }
 
 func freeCallbackKey(key uintptr) {
-	sessions.Delete(key)
+	callbacks.Delete(key)
 }
 
 // handleEvent is exported to guarantee C calling convention (cdecl).
@@ -420,20 +1166,21 @@ func freeCallbackKey(key uintptr) {
 //export handleEvent
 func handleEvent(eventRecord C.PEVENT_RECORD) {
 	key := uintptr(eventRecord.UserContext)
-	targetSession, ok := sessions.Load(key)
+	targetCallback, ok := callbacks.Load(key)
 	if !ok {
 		return
 	}
 
 	evt := &Event{
-		Header:      eventHeaderToGo(eventRecord.EventHeader),
+		Header:      eventHeaderToGo(eventRecord),
 		eventRecord: eventRecord,
 	}
-	targetSession.(*Session).callback(evt)
+	targetCallback.(EventCallback)(evt)
 	evt.eventRecord = nil
 }
 
-func eventHeaderToGo(header C.EVENT_HEADER) EventHeader {
+func eventHeaderToGo(record C.PEVENT_RECORD) EventHeader {
+	header := record.EventHeader
 	return EventHeader{
 		EventDescriptor: eventDescriptorToGo(header.EventDescriptor),
 		ThreadID:        uint32(header.ThreadId),
@@ -446,6 +1193,9 @@ func eventHeaderToGo(header C.EVENT_HEADER) EventHeader {
 		KernelTime:    uint32(C.GetKernelTime(header)),
 		UserTime:      uint32(C.GetUserTime(header)),
 		ProcessorTime: uint64(C.GetProcessorTime(header)),
+
+		HeaderSize:     uint16(header.Size),
+		UserDataLength: uint16(record.UserDataLength),
 	}
 }
 
@@ -8,8 +8,21 @@
 #include <tdh.h>
 
 // OpenTraceHelper helps to access EVENT_TRACE_LOGFILEW union fields and pass
-// pointer to C not warning CGO checker.
-TRACEHANDLE OpenTraceHelper(LPWSTR name, PVOID ctx);
+// pointer to C not warning CGO checker. @ctx is a callback key rather than
+// a pointer, so it's passed as an integer not to trip checkptr.
+TRACEHANDLE OpenTraceHelper(LPWSTR name, ULONG_PTR ctx);
+
+// OpenTraceFileHelper is the same as OpenTraceHelper but opens an .etl file
+// located at @logFile for events processing.
+TRACEHANDLE OpenTraceFileHelper(LPWSTR logFile, ULONG_PTR ctx);
+
+// OpenTraceExHelper opens either a real-time session named @loggerName or an
+// @logFile with custom @processTraceMode flags. If @withBufferCallback is set
+// buffer statistics are passed to the Go side. @isKernelTrace and @header
+// receive the values of the same names set by OpenTraceW.
+TRACEHANDLE OpenTraceExHelper(LPWSTR loggerName, LPWSTR logFile, ULONG processTraceMode,
+                              BOOL withBufferCallback, ULONG_PTR ctx, BOOL* isKernelTrace,
+                              PTRACE_LOGFILE_HEADER header);
 
 // GetArraySize extracts a size of array located at property @i.
 ULONG GetArraySize(PEVENT_RECORD event, PTRACE_EVENT_INFO info, int idx, UINT32* count);
@@ -33,6 +46,10 @@ int GetStructStartIndex(PTRACE_EVENT_INFO info, int idx);
 int GetStructLastIndex(PTRACE_EVENT_INFO info, int idx);
 BOOL PropertyIsStruct(PTRACE_EVENT_INFO info, int idx);
 BOOL PropertyIsArray(PTRACE_EVENT_INFO info, int idx);
+BOOL PropertyHasParamCount(PTRACE_EVENT_INFO info, int idx);
+BOOL PropertyHasParamLength(PTRACE_EVENT_INFO info, int idx);
+USHORT GetCountPropertyIndex(PTRACE_EVENT_INFO info, int idx);
+BOOL PropertyHasMap(PTRACE_EVENT_INFO info, int idx);
 
 // Event header unions getters.
 LONGLONG GetTimeStamp(EVENT_HEADER header);
@@ -46,3 +63,16 @@ ULONGLONG GetDataPtr(PEVENT_HEADER_EXTENDED_DATA_ITEM extData, int idx);
 USHORT GetDataSize(PEVENT_HEADER_EXTENDED_DATA_ITEM extData, int idx);
 ULONG GetAddress32(PEVENT_EXTENDED_ITEM_STACK_TRACE32 trace32, int idx);
 ULONGLONG GetAddress64(PEVENT_EXTENDED_ITEM_STACK_TRACE64 trace64, int idx);
+
+// Helpers for providers enumeration.
+ULONG GetProviderCount(PPROVIDER_ENUMERATION_INFO info);
+LPWSTR GetProviderName(PPROVIDER_ENUMERATION_INFO info, int idx);
+GUID GetProviderGUID(PPROVIDER_ENUMERATION_INFO info, int idx);
+
+// Helpers for trace properties parsing.
+ULONG64 GetHistoricalContext(PEVENT_TRACE_PROPERTIES properties);
+
+// CopyEventRecord makes a self-contained copy of @src including its payload
+// and extended data items in a single malloc-ed block. Returns NULL if out
+// of memory. The copy should be freed with free().
+PEVENT_RECORD CopyEventRecord(PEVENT_RECORD src);
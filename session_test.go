@@ -6,14 +6,25 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unsafe"
 
 	msetw "github.com/Microsoft/go-winio/pkg/etw"
+	"github.com/Microsoft/go-winio/pkg/guid"
 	"github.com/stretchr/testify/suite"
 	"golang.org/x/sys/windows"
 
 	"github.com/bi-zone/etw"
+	"github.com/bi-zone/etw/testutil"
 )
 
 func TestSession(t *testing.T) {
@@ -78,6 +89,122 @@ func (s *sessionSuite) TestSmoke() {
 	s.waitForSignal(done, deadline, "Failed to stop event processing")
 }
 
+// TestMultipleConsumers ensures that several callbacks could consume the same session independently.
+func (s *sessionSuite) TestMultipleConsumers() {
+	const (
+		deadline  = 10 * time.Second
+		consumers = 3
+	)
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+
+	var (
+		gotEvents = make([]chan struct{}, consumers)
+		done      = make(chan struct{}, consumers)
+	)
+	for i := range gotEvents {
+		gotEvent := make(chan struct{}, 1)
+		gotEvents[i] = gotEvent
+		go func() {
+			cb := func(_ *etw.Event) {
+				s.trySignal(gotEvent)
+			}
+			s.Require().NoError(session.Process(cb), "Error processing events")
+			done <- struct{}{}
+		}()
+	}
+
+	// Every consumer gets its own copy of the events stream.
+	for i, gotEvent := range gotEvents {
+		s.waitForSignal(gotEvent, deadline, fmt.Sprintf("Consumer %d failed to receive event", i))
+	}
+
+	// Closing the session stops all the consumers.
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	for i := 0; i < consumers; i++ {
+		s.waitForSignal(done, deadline, "Failed to stop event processing")
+	}
+}
+
+// TestTraceGroup ensures that events of the grouped sessions are delivered
+// with their source and closing the sessions stops the group processing.
+func (s *sessionSuite) TestTraceGroup() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	first, err := etw.NewSession(s.guid, etw.WithName("etw-group-test-1"))
+	s.Require().NoError(err, "Failed to create session")
+	second, err := etw.NewSession(s.guid, etw.WithName("etw-group-test-2"))
+	s.Require().NoError(err, "Failed to create session")
+
+	group, err := etw.NewTraceGroup(first, second)
+	s.Require().NoError(err, "Failed to create trace group")
+
+	gotEvents := map[string]chan struct{}{
+		"etw-group-test-1": make(chan struct{}, 1),
+		"etw-group-test-2": make(chan struct{}, 1),
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(group.Process(func(source string, _ *etw.Event) {
+			gotEvent, ok := gotEvents[source]
+			s.True(ok, "Unexpected event source %q", source)
+			if ok {
+				s.trySignal(gotEvent)
+			}
+		}), "Error processing events")
+		close(done)
+	}()
+
+	for source, gotEvent := range gotEvents {
+		s.waitForSignal(gotEvent, deadline, fmt.Sprintf("Failed to receive event from %s", source))
+	}
+
+	// The group keeps processing until all the sessions are closed.
+	s.Require().NoError(first.Close(), "Failed to close session properly")
+	select {
+	case <-done:
+		s.Fail("Group processing stopped with a session still running")
+	default:
+	}
+	s.Require().NoError(second.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop group processing")
+}
+
+// TestProcessContext ensures that the context is passed to the callback and its cancellation
+// stops the consumer without closing the session.
+func (s *sessionSuite) TestProcessContext() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+	defer func() { s.Require().NoError(session.Close(), "Failed to close session properly") }()
+
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+	defer cancel()
+
+	gotEvent := make(chan struct{})
+	cb := func(ctx context.Context, _ *etw.Event) {
+		s.Equal("value", ctx.Value(key{}), "Unexpected callback context")
+		s.trySignal(gotEvent)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.ProcessContext(ctx, cb), "Error processing events")
+		close(done)
+	}()
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+
+	cancel()
+	s.waitForSignal(done, deadline, "Failed to stop event processing on cancel")
+	_, err = session.TraceProperties()
+	s.NoError(err, "Session is stopped on cancel")
+}
+
 // TestUpdating ensures that etw.Session is able to update its properties in runtime.
 func (s *sessionSuite) TestUpdating() {
 	const deadline = 10 * time.Second
@@ -130,6 +257,290 @@ func (s *sessionSuite) TestUpdating() {
 	s.waitForSignal(done, deadline, "Failed to stop event processing")
 }
 
+// TestProviderLevel ensures that a level of an additional session provider could be
+// changed without touching the primary one.
+func (s *sessionSuite) TestProviderLevel() {
+	const deadline = 10 * time.Second
+
+	extra, err := msetw.NewProvider("TestProviderExtra", nil)
+	s.Require().NoError(err, "Failed to initialize extra test provider.")
+	defer func() { s.Require().NoError(extra.Close(), "Failed to close extra test provider.") }()
+	extraGUID := windows.GUID(extra.ID)
+
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+	go func() {
+		for s.ctx.Err() == nil {
+			_ = extra.WriteEvent("TestEvent", msetw.WithEventOpts(msetw.WithLevel(msetw.LevelInfo)), nil)
+		}
+	}()
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+	s.Require().NoError(session.AddProvider(extraGUID, etw.WithLevel(etw.TRACE_LEVEL_CRITICAL)))
+
+	var (
+		gotPrimaryEvent = make(chan struct{}, 1)
+		gotExtraEvent   = make(chan struct{}, 1)
+	)
+	cb := func(e *etw.Event) {
+		switch e.Header.ProviderID {
+		case s.guid:
+			s.trySignal(gotPrimaryEvent)
+		case extraGUID:
+			s.trySignal(gotExtraEvent)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+
+	// Extra provider is subscribed for CRITICAL events only, so expect nothing from it.
+	s.waitForSignal(gotPrimaryEvent, deadline, "Failed to get event from primary provider")
+	select {
+	case <-time.After(deadline): // pass
+	case <-gotExtraEvent:
+		s.Fail("Received event with unexpected level")
+	}
+
+	s.Require().NoError(session.SetLevel(extraGUID, etw.TRACE_LEVEL_INFORMATION))
+	s.waitForSignal(gotExtraEvent, deadline, "Failed to get event from extra provider after level update")
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestRemoveProvider ensures that a removed provider is disabled and forgotten.
+func (s *sessionSuite) TestRemoveProvider() {
+	const deadline = 10 * time.Second
+
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	primary, err := guid.NewV4()
+	s.Require().NoError(err, "Failed to generate primary provider GUID")
+	session, err := etw.NewSession(windows.GUID(primary))
+	s.Require().NoError(err, "Failed to create session")
+	s.Require().NoError(session.AddProvider(s.guid), "Failed to add provider")
+
+	gotEvent := make(chan struct{}, 1)
+	cb := func(e *etw.Event) {
+		if e.Header.ProviderID == s.guid {
+			s.trySignal(gotEvent)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+	s.waitForSignal(gotEvent, deadline, "Failed to get event from added provider")
+
+	s.Require().NoError(session.RemoveProvider(s.guid), "Failed to remove provider")
+	s.NotContains(session.Providers(), s.guid, "Removed provider is still reported")
+	s.Error(session.RemoveProvider(s.guid), "Removed provider is removed once more")
+	s.Error(session.RemoveProvider(windows.GUID(primary)), "Primary provider is removed")
+
+	// Let events already logged be flushed, then expect nothing.
+	time.Sleep(3 * time.Second)
+	select {
+	case <-gotEvent:
+	default:
+	}
+	select {
+	case <-time.After(deadline): // pass
+	case <-gotEvent:
+		s.Fail("Received event from removed provider")
+	}
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestSourceGUID ensures that providers receive the source GUID in their
+// enable requests.
+func (s *sessionSuite) TestSourceGUID() {
+	const deadline = 10 * time.Second
+
+	sources := make(chan guid.GUID, 1)
+	provider, err := msetw.NewProvider("TestProviderSource",
+		func(source guid.GUID, state msetw.ProviderState, _ msetw.Level, _, _ uint64, _ uintptr) {
+			if state == msetw.ProviderStateEnable {
+				select {
+				case sources <- source:
+				default:
+				}
+			}
+		})
+	s.Require().NoError(err, "Failed to initialize source test provider.")
+	defer func() { s.Require().NoError(provider.Close(), "Failed to close source test provider.") }()
+
+	sourceGUID, err := windows.GenerateGUID()
+	s.Require().NoError(err, "Failed to generate source GUID")
+	session, err := etw.NewSession(windows.GUID(provider.ID), etw.WithSourceGUID(sourceGUID))
+	s.Require().NoError(err, "Failed to create session")
+
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(func(e *etw.Event) {}), "Error processing events")
+		close(done)
+	}()
+
+	select {
+	case source := <-sources:
+		s.Equal(sourceGUID, windows.GUID(source), "Provider got unexpected source GUID")
+	case <-time.After(deadline):
+		s.Fail("Provider is not enabled")
+	}
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestCallbackTimeout ensures that slow callbacks are reported and the
+// timeout could be changed with UpdateOptions.
+func (s *sessionSuite) TestCallbackTimeout() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	slow := make(chan struct{}, 1)
+	onSlow := func(e *etw.Event, took time.Duration) {
+		s.Equal(s.guid, e.Header.ProviderID, "Unexpected event reported")
+		s.trySignal(slow)
+	}
+	session, err := etw.NewSession(s.guid, etw.WithCallbackTimeout(time.Hour, onSlow))
+	s.Require().NoError(err, "Failed to create session")
+
+	gotEvent := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(func(e *etw.Event) {
+			time.Sleep(time.Millisecond)
+			s.trySignal(gotEvent)
+		}), "Error processing events")
+		close(done)
+	}()
+
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+	select {
+	case <-slow:
+		s.Fail("Callback within the timeout is reported")
+	default:
+	}
+
+	s.Require().NoError(session.UpdateOptions(etw.WithCallbackTimeout(time.Nanosecond, onSlow)))
+	s.waitForSignal(slow, deadline, "Slow callback is not reported")
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestCallbackTimeoutParallel ensures that the callback itself is measured
+// in CallbackParallel mode rather than dispatching of events to workers.
+func (s *sessionSuite) TestCallbackTimeoutParallel() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	var handled sync.Map // Events passed to the callback.
+	slow := make(chan struct{}, 1)
+	onSlow := func(e *etw.Event, took time.Duration) {
+		_, ok := handled.Load(e)
+		s.True(ok, "Reported event isn't the one passed to the callback")
+		s.True(took >= 10*time.Millisecond, "Unexpected slow callback duration %s", took)
+		s.trySignal(slow)
+	}
+	session, err := etw.NewSession(s.guid,
+		etw.WithParallelCallbacks(2),
+		etw.WithCallbackTimeout(time.Nanosecond, onSlow))
+	s.Require().NoError(err, "Failed to create session")
+
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(func(e *etw.Event) {
+			handled.Store(e, struct{}{})
+			time.Sleep(10 * time.Millisecond)
+		}), "Error processing events")
+		close(done)
+	}()
+	s.waitForSignal(slow, deadline, "Slow callback is not reported")
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestUserContext ensures that events carry the value attached to their
+// session and it could be replaced during the processing.
+func (s *sessionSuite) TestUserContext() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+	session.SetContext("first")
+
+	var (
+		gotFirst  = make(chan struct{}, 1)
+		gotSecond = make(chan struct{}, 1)
+	)
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(func(e *etw.Event) {
+			switch e.Context() {
+			case "first":
+				s.trySignal(gotFirst)
+			case "second":
+				s.trySignal(gotSecond)
+			default:
+				s.Failf("Unexpected event context", "%v", e.Context())
+			}
+		}), "Error processing events")
+		close(done)
+	}()
+
+	s.waitForSignal(gotFirst, deadline, "Failed to receive event with the initial context")
+	session.SetContext("second")
+	s.waitForSignal(gotSecond, deadline, "Failed to receive event with the replaced context")
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestPauseProvider ensures that a paused provider stops delivering events until resumed.
+func (s *sessionSuite) TestPauseProvider() {
+	const deadline = 10 * time.Second
+
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+	s.Require().NoError(session.PauseProvider(s.guid), "Failed to pause provider")
+	s.True(session.Paused(s.guid), "Provider is not reported as paused")
+
+	gotEvent := make(chan struct{}, 1)
+	cb := func(_ *etw.Event) {
+		s.trySignal(gotEvent)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+
+	// Paused provider isn't enabled on `.Process`, so expect nothing.
+	select {
+	case <-time.After(deadline): // pass
+	case <-gotEvent:
+		s.Fail("Received event from paused provider")
+	}
+
+	s.Require().NoError(session.ResumeProvider(s.guid), "Failed to resume provider")
+	s.False(session.Paused(s.guid), "Provider is still reported as paused")
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event after resuming provider")
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
 // TestParsing ensures that etw.Session is able to parse events with all common field types.
 func (s *sessionSuite) TestParsing() {
 	const deadline = 20 * time.Second
@@ -150,10 +561,9 @@ func (s *sessionSuite) TestParsing() {
 		msetw.StringArray("anotherArray", []string{"3", "4"}),
 	)
 	expectedMap := map[string]interface{}{
-		"string":            "string value",
-		"stringArray.Count": "3", // OS artifacts
-		"stringArray":       []interface{}{"1", "2", "3"},
-		"float64":           "45.700000",
+		"string":      "string value",
+		"stringArray": []interface{}{"1", "2", "3"},
+		"float64":     "45.700000",
 		"struct": map[string]interface{}{
 			"string": "string value",
 
@@ -162,8 +572,7 @@ func (s *sessionSuite) TestParsing() {
 				"string": "string value",
 			},
 		},
-		"anotherArray.Count": "2", // OS artifacts
-		"anotherArray":       []interface{}{"3", "4"},
+		"anotherArray": []interface{}{"3", "4"},
 	}
 
 	session, err := etw.NewSession(s.guid, etw.WithLevel(etw.TRACE_LEVEL_VERBOSE))
@@ -192,111 +601,1771 @@ func (s *sessionSuite) TestParsing() {
 	s.waitForSignal(done, deadline, "Failed to stop event processing")
 }
 
-// TestKillSession ensures that we are able to force kill the lost session using only
-// its name.
-func (s *sessionSuite) TestKillSession() {
-	sessionName := fmt.Sprintf("go-etw-suicide-%d", time.Now().UnixNano())
+// TestKernelSession ensures that we are able to receive and parse classic (MOF) events of the
+// NT Kernel Logger.
+func (s *sessionSuite) TestKernelSession() {
+	const deadline = 20 * time.Second
+	processClass := windows.GUID{ // Process MOF class of kernel events.
+		Data1: 0x3d6fa8d0,
+		Data2: 0xfe05,
+		Data3: 0x11d0,
+		Data4: [8]byte{0x9d, 0xda, 0x00, 0xc0, 0x4f, 0xd7, 0xba, 0x7c},
+	}
+	const processStart = 1 // EVENT_TRACE_TYPE_START
 
-	// Ensure we can create a session with a given name.
-	_, err := etw.NewSession(s.guid, etw.WithName(sessionName))
-	s.Require().NoError(err, "Failed to create session with name %s", sessionName)
+	session, err := etw.NewKernelSession(etw.EVENT_TRACE_FLAG_PROCESS, etw.WithOpcodes(processStart))
+	var exists etw.ExistsError
+	if errors.As(err, &exists) {
+		s.T().Skip("NT Kernel Logger is used by someone else")
+	}
+	s.Require().NoError(err, "Failed to create kernel session")
+	s.Error(session.AddProvider(s.guid), "Kernel session accepted a provider")
 
-	// Ensure we've got ExistsError creating a session with the same name.
-	_, err = etw.NewSession(s.guid, etw.WithName(sessionName))
-	s.Require().Error(err)
+	var (
+		properties map[string]interface{}
+		source     etw.DecodingSource
+		classic    bool
+		gotProps   = make(chan struct{}, 1)
+	)
+	cb := func(e *etw.Event) {
+		if e.Header.ProviderID != processClass {
+			return
+		}
+		props, err := e.EventProperties()
+		s.Require().NoError(err, "Got error parsing kernel event properties")
+		if name, _ := props["ImageFileName"].(string); !strings.EqualFold(name, "cmd.exe") {
+			return
+		}
+		properties = props
+		source, err = e.DecodingSource()
+		s.Require().NoError(err, "Failed to get event decoding source")
+		classic = e.Header.IsClassic()
+		s.trySignal(gotProps)
+	}
 
-	var exists etw.ExistsError
-	s.Require().True(errors.As(err, &exists), "Got unexpected error starting session with a same name")
-	s.Equal(exists.SessionName, sessionName, "Got unexpected name in etw.ExistsError")
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
 
-	// Try to force-kill the session by name.
-	s.Require().NoError(etw.KillSession(sessionName), "Failed to force stop session")
+	// Spawn processes until the session catches one of them.
+	go func() {
+		for s.ctx.Err() == nil {
+			_ = exec.CommandContext(s.ctx, "cmd.exe", "/c", "exit").Run()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
 
-	// Ensure that fresh session could normally started and stopped.
+	s.waitForSignal(gotProps, deadline, "Failed to get process start event")
+	s.Equal(etw.DecodingSourceWbem, source, "Unexpected kernel event decoding source")
+	s.True(classic, "Kernel event is not classic")
+	s.Contains(properties, "ProcessId")
+	s.Contains(properties, "CommandLine")
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestLargeEvent ensures that etw.Session is able to receive and parse events close to the
+// maximum ETW event size.
+func (s *sessionSuite) TestLargeEvent() {
+	const deadline = 20 * time.Second
+
+	// The whole event (with metadata) should fit 64KB, so leave some space for it.
+	// go-winio writes strings as null-terminated UTF-8, so the value takes
+	// 30KB + 1 byte of the payload.
+	largeValue := strings.Repeat("x", 30*1024)
+	go s.generateEvents(
+		s.ctx,
+		[]msetw.Level{msetw.LevelInfo},
+		msetw.StringField("large", largeValue),
+	)
+
+	session, err := etw.NewSession(s.guid, etw.WithBufferSize(64))
+	s.Require().NoError(err, "Failed to create a session")
+
+	var (
+		properties map[string]interface{}
+		gotProps   = make(chan struct{}, 1)
+	)
+	cb := func(e *etw.Event) {
+		properties, err = e.EventProperties()
+		s.Require().NoError(err, "Got error parsing event properties")
+		s.trySignal(gotProps)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+
+	s.waitForSignal(gotProps, deadline, "Failed to get large event")
+	s.Equal(largeValue, properties["large"], "Received unexpected large property")
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestLogFile ensures that events are persisted to rotated log files along
+// with the real-time delivery.
+func (s *sessionSuite) TestLogFile() {
+	const deadline = 10 * time.Second
+
+	dir, err := ioutil.TempDir("", "go-etw-logfile")
+	s.Require().NoError(err, "Failed to create temp dir")
+	defer os.RemoveAll(dir)
+
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+	session, err := etw.NewSession(s.guid,
+		etw.WithLogFile(filepath.Join(dir, "trace.etl")),
+		etw.WithLogFileRotation(1))
+	s.Require().NoError(err, "Failed to create session")
+
+	props, err := session.TraceProperties()
+	s.Require().NoError(err, "Failed to query session properties")
+	s.Equal(uint32(1), props.MaximumFileSize, "Rotation size is not applied")
+	s.Equal(dir, filepath.Dir(props.LogFileName), "Unexpected log file directory")
+
+	gotEvent := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(func(e *etw.Event) {
+			s.trySignal(gotEvent)
+		}), "Error processing events")
+		close(done)
+	}()
+
+	s.waitForSignal(gotEvent, deadline, "Failed to receive real-time event")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+
+	// Rotated files are named after the template with a timestamp and a
+	// sequence number.
+	files, err := filepath.Glob(filepath.Join(dir, "trace-*-*.etl"))
+	s.Require().NoError(err, "Failed to list log files")
+	s.Require().NotEmpty(files, "Log file is not created")
+	info, err := os.Stat(files[0])
+	s.Require().NoError(err, "Failed to stat log file")
+	s.NotZero(info.Size(), "Log file is empty")
+}
+
+// TestOpenTrace ensures that a log file written by the session could be
+// consumed with OpenTrace.
+func (s *sessionSuite) TestOpenTrace() {
+	const deadline = 10 * time.Second
+
+	dir, err := ioutil.TempDir("", "go-etw-trace")
+	s.Require().NoError(err, "Failed to create temp dir")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trace.etl")
+
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+	session, err := etw.NewSession(s.guid, etw.WithLogFile(path))
+	s.Require().NoError(err, "Failed to create session")
+	gotEvent := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(func(e *etw.Event) {
+			s.trySignal(gotEvent)
+		}), "Error processing events")
+		close(done)
+	}()
+	s.waitForSignal(gotEvent, deadline, "Failed to receive real-time event")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+
+	_, err = etw.OpenTrace(etw.TraceOptions{}, func(*etw.Event) {})
+	s.Error(err, "Trace without a source is opened")
+
+	var events, buffers int
+	trace, err := etw.OpenTrace(etw.TraceOptions{
+		LogFileName: path,
+		BufferCallback: func(etw.BufferStats) bool {
+			buffers++
+			return true
+		},
+	}, func(e *etw.Event) {
+		if e.Header.ProviderID == s.guid {
+			events++
+		}
+	})
+	s.Require().NoError(err, "Failed to open log file")
+	s.False(trace.IsKernelTrace(), "Log file is reported as a kernel trace")
+	s.Equal(path, trace.Header().LogFileName, "Unexpected header log file name")
+	s.NotZero(trace.Header().NumberOfProcessors, "Header is not filled")
+	s.Require().NoError(trace.Process(), "Failed to process log file")
+	s.Require().NoError(trace.Close(), "Failed to close trace")
+	s.NotZero(events, "No provider events are read from log file")
+	s.NotZero(buffers, "Buffer callback is not called")
+}
+
+// TestKillSession ensures that we are able to force kill the lost session using only
+// its name.
+func (s *sessionSuite) TestKillSession() {
+	sessionName := fmt.Sprintf("go-etw-suicide-%d", time.Now().UnixNano())
+
+	// Ensure we can create a session with a given name.
+	_, err := etw.NewSession(s.guid, etw.WithName(sessionName))
+	s.Require().NoError(err, "Failed to create session with name %s", sessionName)
+
+	// Ensure we've got ExistsError creating a session with the same name.
+	_, err = etw.NewSession(s.guid, etw.WithName(sessionName))
+	s.Require().Error(err)
+
+	var exists etw.ExistsError
+	s.Require().True(errors.As(err, &exists), "Got unexpected error starting session with a same name")
+	s.Equal(exists.SessionName, sessionName, "Got unexpected name in etw.ExistsError")
+
+	// Try to force-kill the session by name.
+	s.Require().NoError(etw.KillSession(sessionName), "Failed to force stop session")
+
+	// Ensure that fresh session could normally started and stopped.
 	session, err := etw.NewSession(s.guid, etw.WithName(sessionName))
 	s.Require().NoError(err, "Failed to create session after a successful kill")
 	s.Require().NoError(session.Close(), "Failed to close session properly")
 }
 
-// TestEventOutsideCallback ensures *etw.Event can't be used outside EventCallback.
-func (s *sessionSuite) TestEventOutsideCallback() {
+// TestAdoptExisting ensures that a session left by a previous run could be taken over.
+func (s *sessionSuite) TestAdoptExisting() {
+	sessionName := etw.SessionName("go-etw-test", "adopt", fmt.Sprint(time.Now().UnixNano()))
+
+	// Emulate a crashed run: the session is never closed by its owner.
+	orphan, err := etw.NewSession(s.guid, etw.WithName(sessionName))
+	s.Require().NoError(err, "Failed to create session")
+	s.False(orphan.Adopted(), "Fresh session is reported as adopted")
+
+	session, err := etw.NewSession(s.guid, etw.WithName(sessionName), etw.WithAdoptExisting())
+	s.Require().NoError(err, "Failed to adopt existing session")
+	s.True(session.Adopted(), "Session is not reported as adopted")
+
+	props, err := session.TraceProperties()
+	s.Require().NoError(err, "Failed to query adopted session properties")
+	s.Equal(sessionName, props.SessionName, "Unexpected adopted session name")
+
+	s.Require().NoError(session.Close(), "Failed to close adopted session")
+	_, err = etw.QuerySession(sessionName)
+	s.Error(err, "Adopted session is still running")
+}
+
+// TestHandoff ensures that a released session keeps running and could be adopted.
+func (s *sessionSuite) TestHandoff() {
+	sessionName := etw.SessionName("go-etw-test", "handoff", fmt.Sprint(time.Now().UnixNano()))
+	old, err := etw.NewSession(s.guid, etw.WithName(sessionName), etw.WithLevel(etw.TRACE_LEVEL_WARNING))
+	s.Require().NoError(err, "Failed to create session")
+
+	handoff, err := old.ExportControl(nil)
+	s.Require().NoError(err, "Failed to export session control")
+	blob, err := handoff.Marshal()
+	s.Require().NoError(err, "Failed to marshal handoff")
+	s.Require().NoError(old.Release(), "Failed to release session")
+	s.Require().NoError(old.Close(), "Failed to close released session")
+	_, err = etw.QuerySession(sessionName)
+	s.Require().NoError(err, "Released session is stopped")
+
+	handoff, err = etw.UnmarshalHandoff(blob)
+	s.Require().NoError(err, "Failed to unmarshal handoff")
+	session, err := etw.AdoptSession(handoff)
+	s.Require().NoError(err, "Failed to adopt session")
+	s.True(session.Adopted(), "Session is not reported as adopted")
+	s.Equal(etw.TRACE_LEVEL_WARNING, session.Options().Level, "Enable state is not adopted")
+
+	s.Require().NoError(session.Close(), "Failed to close adopted session")
+	_, err = etw.QuerySession(sessionName)
+	s.Error(err, "Adopted session is still running")
+}
+
+// TestHandoffGrant ensures that ExportControl grants the adopting account
+// the rights to query, consume and control the session.
+func (s *sessionSuite) TestHandoffGrant() {
+	const rights = 0x0001 | 0x0080 | 0x0400 // WMIGUID_QUERY | TRACELOG_GUID_ENABLE | TRACELOG_ACCESS_REALTIME
+
+	sessionGUID, err := windows.GenerateGUID()
+	s.Require().NoError(err, "Failed to generate session GUID")
+	session, err := etw.NewSession(s.guid, etw.WithSessionGUID(sessionGUID))
+	s.Require().NoError(err, "Failed to create session")
+	defer func() { s.Require().NoError(session.Close(), "Failed to close session properly") }()
+
+	sid, err := windows.CreateWellKnownSid(windows.WinNetworkServiceSid)
+	s.Require().NoError(err, "Failed to create NETWORK SERVICE SID")
+	granted, err := allowedRights(sessionGUID, sid)
+	s.Require().NoError(err, "Failed to query session DACL")
+	s.Zero(granted&rights, "Rights are granted before export")
+
+	handoff, err := session.ExportControl(sid)
+	s.Require().NoError(err, "Failed to export session control")
+	s.Equal(session.Options().Name, handoff.Name, "Unexpected handoff session name")
+	granted, err = allowedRights(sessionGUID, sid)
+	s.Require().NoError(err, "Failed to query session DACL")
+	s.Equal(uint32(rights), granted&rights, "Rights are not granted")
+}
+
+// TestCleanupOrphanedSessions ensures that only sessions of exited processes are cleaned up.
+func (s *sessionSuite) TestCleanupOrphanedSessions() {
+	prefix := fmt.Sprintf("go-etw-orphans-%d", time.Now().UnixNano())
+
+	liveName, err := etw.OwnedSessionName(prefix)
+	s.Require().NoError(err, "Failed to build session name")
+	live, err := etw.NewSession(s.guid, etw.WithName(liveName))
+	s.Require().NoError(err, "Failed to create session")
+	defer func() { s.Require().NoError(live.Close(), "Failed to close session properly") }()
+
+	// Same process ID, but another creation time -- the ID is reused.
+	orphanName := fmt.Sprintf("%s-%d-1", prefix, windows.GetCurrentProcessId())
+	_, err = etw.NewSession(s.guid, etw.WithName(orphanName)) // Leaked on purpose.
+	s.Require().NoError(err, "Failed to create session")
+
+	sessions, err := etw.ListSessions()
+	s.Require().NoError(err, "Failed to list sessions")
+	var names []string
+	for _, props := range sessions {
+		names = append(names, props.SessionName)
+	}
+	s.Contains(names, liveName)
+	s.Contains(names, orphanName)
+
+	stopped, err := etw.CleanupOrphanedSessions(prefix)
+	s.Require().NoError(err, "Failed to clean up sessions")
+	s.Equal([]string{orphanName}, stopped)
+
+	_, err = etw.QuerySession(orphanName)
+	s.Error(err, "Orphaned session is still running")
+	_, err = etw.QuerySession(liveName)
+	s.NoError(err, "Live session is stopped")
+}
+
+// TestSecurityContext ensures that the session could be controlled under a given token.
+func (s *sessionSuite) TestSecurityContext() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	var processToken windows.Token
+	err := windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_QUERY|windows.TOKEN_DUPLICATE, &processToken)
+	s.Require().NoError(err, "Failed to open process token")
+	defer processToken.Close()
+
+	var token windows.Token
+	err = windows.DuplicateTokenEx(processToken, windows.TOKEN_QUERY|windows.TOKEN_DUPLICATE|windows.TOKEN_IMPERSONATE,
+		nil, windows.SecurityImpersonation, windows.TokenImpersonation, &token)
+	s.Require().NoError(err, "Failed to duplicate process token")
+	defer token.Close()
+
+	session, err := etw.NewSession(s.guid, etw.WithSecurityContext(token))
+	s.Require().NoError(err, "Failed to create session")
+
+	gotEvent := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(func(_ *etw.Event) {
+			s.trySignal(gotEvent)
+		}), "Error processing events")
+		close(done)
+	}()
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+
+	_, err = session.TraceProperties()
+	s.NoError(err, "Failed to query session properties")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+
+	// Sessions are created under the given token indeed: a token without
+	// the groups allowed to control sessions is denied.
+	user, err := processToken.GetTokenUser()
+	s.Require().NoError(err, "Failed to get process user")
+	system, err := windows.CreateWellKnownSid(windows.WinLocalSystemSid)
+	s.Require().NoError(err, "Failed to create LocalSystem SID")
+	if windows.EqualSid(user.User.Sid, system) {
+		return // LocalSystem controls sessions regardless of the groups.
+	}
+	restricted, err := restrictedToken(processToken,
+		windows.WinBuiltinAdministratorsSid, windows.WinBuiltinPerfLoggingUsersSid)
+	s.Require().NoError(err, "Failed to create restricted token")
+	defer restricted.Close()
+	_, err = etw.NewSession(s.guid, etw.WithSecurityContext(restricted))
+	s.True(errors.Is(err, windows.ERROR_ACCESS_DENIED), "Unexpected error creating session with restricted token: %v", err)
+}
+
+// TestTraceProperties ensures that we are able to query OS-assigned session properties.
+func (s *sessionSuite) TestTraceProperties() {
+	sessionName := fmt.Sprintf("go-etw-properties-%d", time.Now().UnixNano())
+	session, err := etw.NewSession(s.guid, etw.WithName(sessionName))
+	s.Require().NoError(err, "Failed to create session")
+
+	props, err := session.TraceProperties()
+	s.Require().NoError(err, "Failed to query session properties")
+	s.Equal(sessionName, props.SessionName, "Unexpected session name")
+	s.NotZero(props.LoggerID, "Unexpected logger ID")
+	s.NotZero(props.BufferSize, "Unexpected buffer size")
+
+	byName, err := etw.QuerySession(sessionName)
+	s.Require().NoError(err, "Failed to query session properties by name")
+	s.Equal(props.LoggerID, byName.LoggerID, "Got different sessions by handle and name")
+
+	// Final stats are reported on close only.
+	_, ok := session.FinalStats()
+	s.False(ok, "Got final stats of running session")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	final, ok := session.FinalStats()
+	s.Require().True(ok, "No final stats of closed session")
+	s.Equal(props.LoggerID, final.LoggerID, "Got final stats of another session")
+}
+
+// TestSessionInfo ensures that the trace header event is decoded and isn't passed to the callback.
+func (s *sessionSuite) TestSessionInfo() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+	_, ok := session.Info()
+	s.False(ok, "Got session info before processing")
+
+	gotEvent := make(chan struct{}, 1)
+	cb := func(e *etw.Event) {
+		s.NotEqual(etw.EventTraceGUID, e.Header.ProviderID, "Trace header is passed to the callback")
+		s.trySignal(gotEvent)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+
+	info, ok := session.Info()
+	s.Require().True(ok, "No session info after processing started")
+	s.EqualValues(unsafe.Sizeof(uintptr(0)), info.PointerSize, "Unexpected pointer size")
+	s.NotZero(info.NumberOfProcessors, "Unexpected number of processors")
+	s.NotZero(info.OSMajorVersion, "Unexpected OS version")
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestRawExtendedData ensures that raw extended data items are accessible.
+func (s *sessionSuite) TestRawExtendedData() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+
+	items := make(chan []etw.ExtendedDataItem, 1)
+	cb := func(e *etw.Event) {
+		var got []etw.ExtendedDataItem
+		for i := 0; i < e.ExtendedDataCount(); i++ {
+			item, err := e.RawExtendedData(i)
+			s.Require().NoError(err, "Failed to get extended data item %d", i)
+			got = append(got, item)
+		}
+		_, err := e.RawExtendedData(e.ExtendedDataCount())
+		s.Error(err, "Got extended data item out of range")
+		select {
+		case items <- got:
+		default:
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+
+	// TraceLogging events carry their schemas as extended data.
+	select {
+	case got := <-items:
+		var types []etw.ExtendedDataType
+		for _, item := range got {
+			types = append(types, item.Type)
+			s.NotEmpty(item.Data, "Empty extended data item")
+		}
+		s.Contains(types, etw.EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL)
+	case <-time.After(deadline):
+		s.Fail("Failed to receive event from provider")
+	}
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestEnableTimeout ensures that providers could be enabled synchronously.
+func (s *sessionSuite) TestEnableTimeout() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid, etw.WithEnableTimeout(deadline))
+	s.Require().NoError(err, "Failed to create session")
+
+	gotEvent := make(chan struct{}, 1)
+	cb := func(_ *etw.Event) {
+		s.trySignal(gotEvent)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+
+	err = session.UpdateOptions(etw.WithLevel(etw.TRACE_LEVEL_VERBOSE))
+	s.Require().NoError(err, "Failed to update options synchronously")
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestThreadPriority ensures that the EventCallback runs on a thread with the requested priority.
+func (s *sessionSuite) TestThreadPriority() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid, etw.WithThreadPriority(etw.THREAD_PRIORITY_HIGHEST))
+	s.Require().NoError(err, "Failed to create session")
+
+	getThreadPriority := windows.NewLazySystemDLL("kernel32.dll").NewProc("GetThreadPriority")
+	priorities := make(chan etw.ThreadPriority, 1)
+	cb := func(_ *etw.Event) {
+		thread, _ := windows.GetCurrentThread()
+		priority, _, _ := getThreadPriority.Call(uintptr(thread))
+		select {
+		case priorities <- etw.ThreadPriority(int32(priority)):
+		default:
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+
+	select {
+	case priority := <-priorities:
+		s.Equal(etw.THREAD_PRIORITY_HIGHEST, priority, "Unexpected callback thread priority")
+	case <-time.After(deadline):
+		s.Fail("Failed to receive event from provider")
+	}
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestProviderStats ensures that per-provider health counters are collected.
+func (s *sessionSuite) TestProviderStats() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+
+	gotEvent := make(chan struct{}, 1)
+	cb := func(e *etw.Event) {
+		_, err := e.EventProperties()
+		s.Require().NoError(err, "Got error parsing event properties")
+		s.trySignal(gotEvent)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+
+	stats, ok := session.ProviderStats(s.guid)
+	s.Require().True(ok, "No stats for the test provider")
+	s.NotZero(stats.EventsReceived, "Unexpected events count")
+	s.NotZero(stats.ParseAttempts, "Unexpected parse attempts count")
+	s.Zero(stats.ParseFailures, "Unexpected parse failures count")
+	s.NotZero(stats.AveragePayloadSize(), "Unexpected average payload size")
+	s.False(stats.LastEventTime.IsZero(), "Unexpected last event time")
+	s.Contains(session.StatsVar().String(), "EventsReceived", "Unexpected expvar value")
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestEventOutsideCallback ensures *etw.Event can't be used outside EventCallback.
+func (s *sessionSuite) TestEventOutsideCallback() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+
+	// Grab event pointer from the callback. We expect that outdated pointer
+	// will protect user from calling Windows API on freed memory.
+	var evt *etw.Event
+	gotEvent := make(chan struct{})
+	cb := func(e *etw.Event) {
+		// Signal on second event only to guarantee that callback with stored event will finish.
+		if evt != nil {
+			s.trySignal(gotEvent)
+		} else {
+			evt = e
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+
+	// Wait for event arrived and try to access event data.
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+	s.Assert().Zero(evt.ExtendedInfo(), "Got non-nil ExtendedInfo for freed event")
+	s.Assert().Zero(evt.ExtendedDataCount(), "Got extended data items for freed event")
+	_, err = evt.RawExtendedData(0)
+	s.Assert().Error(err, "Don't get an error using freed event")
+	_, err = evt.EventProperties()
+	s.Assert().Error(err, "Don't get an error using freed event")
+	s.Assert().Contains(err.Error(), "EventCallback", "Got unexpected error: %s", err)
+
+	// In the debug mode misuses are reported with the violating call stack.
+	var misuses []etw.EventMisuse
+	etw.SetEventMisuseHandler(func(m etw.EventMisuse) {
+		misuses = append(misuses, m)
+	})
+	defer etw.SetEventMisuseHandler(nil)
+	_, err = evt.UserData()
+	s.Assert().Error(err, "Don't get an error using freed event")
+	s.Require().Len(misuses, 1, "Misuse is not reported")
+	s.Equal("UserData", misuses[0].Method)
+	s.Equal(evt.Header, misuses[0].Header)
+	s.Contains(string(misuses[0].Stack), "TestEventOutsideCallback", "Stack has no violating call")
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestPayloadSize ensures that payload size is translated with the header.
+func (s *sessionSuite) TestPayloadSize() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo}, msetw.StringField("string", "value"))
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+
+	var (
+		header  etw.EventHeader
+		payload []byte
+	)
+	gotEvent := make(chan struct{})
+	cb := func(e *etw.Event) {
+		if payload == nil {
+			header = e.Header
+			payload, err = e.UserData()
+			s.Require().NoError(err, "Failed to get event payload")
+		}
+		s.trySignal(gotEvent)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+
+	s.NotZero(header.HeaderSize, "Header size is not translated")
+	s.Equal(len(payload), int(header.UserDataLength), "Unexpected payload size")
+}
+
+// TestChannelCallback ensures that events could be processed outside of the callback as ParsedEvent.
+func (s *sessionSuite) TestChannelCallback() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo}, msetw.StringField("string", "value"))
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+
+	events := make(chan *etw.ParsedEvent, 10)
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(etw.ChannelCallback(events)), "Error processing events")
+		close(done)
+	}()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case e := <-events:
+			s.Require().NoError(e.Err, "Failed to parse event")
+			s.Equal(s.guid, e.Header.ProviderID, "Unexpected event provider")
+			s.Equal("value", e.Properties["string"], "Unexpected event properties")
+			e.Release()
+		case <-time.After(deadline):
+			s.Fail("Failed to receive event from provider")
+		}
+	}
+
+	// Drain the channel to never block the callback on close.
+	go func() {
+		for e := range events {
+			e.Release()
+		}
+	}()
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+	close(events)
+}
+
+// TestCapture ensures that events could be captured with a temporary session.
+func (s *sessionSuite) TestCapture() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo}, msetw.StringField("string", "value"))
+
+	// Limited by the events count.
+	start := time.Now()
+	events, err := etw.Capture(s.ctx, s.guid, deadline, etw.WithMaxEvents(3))
+	s.Require().NoError(err, "Failed to capture events")
+	s.True(time.Since(start) < deadline, "Capture isn't stopped by the events limit")
+	s.Require().Len(events, 3, "Unexpected number of captured events")
+	for _, e := range events {
+		s.Require().NoError(e.Err, "Failed to parse event")
+		s.Equal(s.guid, e.Header.ProviderID, "Unexpected event provider")
+		s.Equal("value", e.Properties["string"], "Unexpected event properties")
+	}
+
+	// Limited by the duration.
+	events, err = etw.Capture(s.ctx, s.guid, time.Second,
+		etw.WithCaptureSessionOptions(etw.WithLevel(etw.TRACE_LEVEL_INFORMATION)))
+	s.Require().NoError(err, "Failed to capture events")
+	s.NotEmpty(events, "No events are captured")
+}
+
+// TestSharedSession ensures that a single process owns a shared session while others consume it.
+func (s *sessionSuite) TestSharedSession() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+	name := "go-etw-shared-" + s.guid.String()
+
+	owner, err := etw.JoinSharedSession(s.guid, etw.WithName(name))
+	s.Require().NoError(err, "Failed to join shared session")
+	s.Require().True(owner.IsOwner(), "First participant doesn't own the session")
+	s.NotNil(owner.Session())
+
+	consumer, err := etw.JoinSharedSession(s.guid, etw.WithName(name))
+	s.Require().NoError(err, "Failed to join shared session")
+	s.Require().False(consumer.IsOwner(), "Second participant owns the session")
+	s.Nil(consumer.Session())
+
+	ownerDone, consumerDone := make(chan struct{}), make(chan struct{})
+	ownerEvent, consumerEvent := make(chan struct{}, 1), make(chan struct{}, 1)
+	go func() {
+		s.Require().NoError(owner.Process(func(_ *etw.Event) { s.trySignal(ownerEvent) }))
+		close(ownerDone)
+	}()
+	s.waitForSignal(ownerEvent, deadline, "Owner failed to receive event")
+	go func() {
+		s.Require().NoError(consumer.Process(func(_ *etw.Event) { s.trySignal(consumerEvent) }))
+		close(consumerDone)
+	}()
+	s.waitForSignal(consumerEvent, deadline, "Consumer failed to receive event")
+
+	// Stopping the session by the owner stops consumers, then one of them
+	// could take the session over.
+	s.Require().NoError(owner.Close(), "Failed to close shared session")
+	s.waitForSignal(ownerDone, deadline, "Failed to stop owner processing")
+	s.waitForSignal(consumerDone, deadline, "Failed to stop consumer processing")
+
+	acquired, err := consumer.TryAcquire()
+	s.Require().NoError(err, "Failed to acquire shared session")
+	s.True(acquired, "Released session is not acquired")
+	s.True(consumer.IsOwner())
+	s.Require().NoError(consumer.Close(), "Failed to close shared session")
+}
+
+// TestCapabilities ensures that options unsupported by the running system
+// fail fast with ErrUnsupportedOSVersion and supported ones are accepted.
+func (s *sessionSuite) TestCapabilities() {
+	caps := etw.Capabilities()
+	s.NotZero(caps.OSVersion.Major, "OS version is not detected")
+	s.True(caps.OSVersion.AtLeast(etw.OSVersion{Major: 6, Minor: 1}), "OS is older than Windows 7")
+	s.Contains(caps.EnableProperties, etw.EVENT_ENABLE_PROPERTY_SID)
+
+	supported := false
+	for _, p := range caps.EnableProperties {
+		supported = supported || p == etw.EVENT_ENABLE_PROPERTY_EXCLUDE_INPRIVATE
+	}
+	session, err := etw.NewSession(s.guid, etw.WithProperty(etw.EVENT_ENABLE_PROPERTY_EXCLUDE_INPRIVATE))
+	if supported {
+		s.Require().NoError(err, "Failed to create session with a supported property")
+		s.NoError(session.Close(), "Failed to close session properly")
+	} else {
+		s.True(errors.Is(err, etw.ErrUnsupportedOSVersion), "Unexpected error %v", err)
+	}
+
+	session, err = etw.NewSession(s.guid, etw.WithEventIDs(1, 2))
+	if caps.EventIDFilters {
+		s.Require().NoError(err, "Failed to create session with event ID filter")
+		s.NoError(session.Close(), "Failed to close session properly")
+	} else {
+		s.True(errors.Is(err, etw.ErrUnsupportedOSVersion), "Unexpected error %v", err)
+	}
+}
+
+// TestLabels ensures that session labels are returned as a copy, merged on
+// cloning and mentioned in session errors.
+func (s *sessionSuite) TestLabels() {
+	labels := map[string]string{"component": "edr", "tenant": "42"}
+	session, err := etw.NewSession(s.guid, etw.WithLabels(labels))
+	s.Require().NoError(err, "Failed to create session")
+	defer session.Close() //nolint:errcheck
+
+	s.Equal(labels, session.Labels(), "Unexpected session labels")
+	session.Labels()["component"] = "changed"
+	s.Equal(labels, session.Labels(), "Labels are changed through a copy")
+
+	clone, err := session.CloneWithOptions(etw.WithLabels(map[string]string{"tenant": "43"}))
+	s.Require().NoError(err, "Failed to clone session")
+	s.Equal(map[string]string{"component": "edr", "tenant": "43"}, clone.Labels())
+	s.Require().NoError(clone.Close(), "Failed to close session properly")
+
+	// Errors tell which session failed.
+	_, err = etw.NewSession(s.guid, etw.WithName(session.Options().Name), etw.WithLabels(labels))
+	s.Require().Error(err, "Created a session with a duplicate name")
+	s.Contains(err.Error(), "component=edr, tenant=42", "Error doesn't mention labels")
+}
+
+// trySignal tries to send a signal to @done if it's ready to receive.
+// @done expected to be a buffered channel.
+func (s sessionSuite) trySignal(done chan<- struct{}) {
+	select {
+	case done <- struct{}{}:
+	default:
+	}
+}
+
+// waitForSignal waits for anything on @done no longer than @deadline.
+// Fails test run if deadline exceeds.
+func (s sessionSuite) waitForSignal(done <-chan struct{}, deadline time.Duration, failMsg string) {
+	select {
+	case <-done:
+		// pass.
+	case <-time.After(deadline):
+		s.Fail(failMsg, "deadline %s exceeded", deadline)
+	}
+}
+
+// We have no easy way to ensure that etw session is started and ready to process events,
+// so it seems easier to just flood an events and catch some of them than try to catch
+// the actual session readiness and sent the only one.
+func (s sessionSuite) generateEvents(ctx context.Context, levels []msetw.Level, fields ...msetw.FieldOpt) {
+	// If nothing provided, receiver doesn't care about the event content -- send anything.
+	if fields == nil {
+		fields = msetw.WithFields(msetw.StringField("TestField", "Foo"))
+	}
+	s.Require().NotEmpty(levels, "Incorrect generateEvents usage")
+
+	testutil.Generate(ctx, func() {
+		for _, l := range levels {
+			_ = s.provider.WriteEvent(
+				"TestEvent",
+				msetw.WithEventOpts(msetw.WithLevel(l)),
+				fields,
+			)
+		}
+	})
+}
+
+//nolint:gochecknoglobals
+var procCreateRestrictedToken = windows.NewLazySystemDLL("advapi32.dll").NewProc("CreateRestrictedToken")
+
+// restrictedToken makes a copy of @token with @groups turned into deny-only
+// ones and all the privileges except SeChangeNotifyPrivilege removed.
+func restrictedToken(token windows.Token, groups ...windows.WELL_KNOWN_SID_TYPE) (windows.Token, error) {
+	const disableMaxPrivilege = 0x1 // DISABLE_MAX_PRIVILEGE
+
+	disabled := make([]windows.SIDAndAttributes, len(groups))
+	for i, group := range groups {
+		sid, err := windows.CreateWellKnownSid(group)
+		if err != nil {
+			return 0, err
+		}
+		disabled[i].Sid = sid
+	}
+	var restricted windows.Token
+	// BOOL CreateRestrictedToken(
+	//  HANDLE ExistingTokenHandle, DWORD Flags,
+	//  DWORD DisableSidCount, PSID_AND_ATTRIBUTES SidsToDisable,
+	//  DWORD DeletePrivilegeCount, PLUID_AND_ATTRIBUTES PrivilegesToDelete,
+	//  DWORD RestrictedSidCount, PSID_AND_ATTRIBUTES SidsToRestrict,
+	//  PHANDLE NewTokenHandle
+	// );
+	ok, _, err := procCreateRestrictedToken.Call(
+		uintptr(token),
+		disableMaxPrivilege,
+		uintptr(len(disabled)),
+		uintptr(unsafe.Pointer(&disabled[0])),
+		0, 0, 0, 0,
+		uintptr(unsafe.Pointer(&restricted)))
+	if ok == 0 {
+		return 0, fmt.Errorf("CreateRestrictedToken failed; %w", err)
+	}
+	return restricted, nil
+}
+
+// TestWatchProviders ensures that changes of the session providers state are reported.
+func (s *sessionSuite) TestWatchProviders() {
+	const deadline = 20 * time.Second
+	session, err := etw.NewSession(s.guid, etw.WithLevel(etw.TRACE_LEVEL_INFORMATION))
+	s.Require().NoError(err, "Failed to create session")
+
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(func(_ *etw.Event) {}), "Error processing events")
+		close(done)
+	}()
+
+	notifications := make(chan etw.ProviderNotification, 16)
+	watchCtx, stopWatch := context.WithCancel(s.ctx)
+	watchDone := make(chan struct{})
+	go func() {
+		err := session.WatchProviders(watchCtx, 100*time.Millisecond, func(n etw.ProviderNotification) {
+			notifications <- n
+		})
+		s.Require().NoError(err, "Error watching providers")
+		close(watchDone)
+	}()
+	waitFor := func(typ etw.ProviderNotificationType) etw.ProviderNotification {
+		timeout := time.After(deadline)
+		for {
+			select {
+			case n := <-notifications:
+				if n.Type == typ {
+					s.Equal(s.guid, n.ProviderID)
+					return n
+				}
+			case <-timeout:
+				s.FailNow("Notification timeout", "No %s notification", typ)
+			}
+		}
+	}
+	time.Sleep(500 * time.Millisecond) // Let the baseline be taken.
+
+	// One more instance of the test provider.
+	provider, err := msetw.NewProvider("TestProvider", nil)
+	s.Require().NoError(err, "Failed to register provider")
+	n := waitFor(etw.ProviderRegistered)
+	s.Equal(windows.GetCurrentProcessId(), n.ProcessID)
+	s.Require().NoError(provider.Close(), "Failed to close provider")
+	waitFor(etw.ProviderUnregistered)
+
+	s.Require().NoError(session.SetLevel(s.guid, etw.TRACE_LEVEL_VERBOSE))
+	n = waitFor(etw.ProviderEnableChanged)
+	s.True(n.Enable.IsEnabled, "Provider is reported disabled")
+	s.Equal(etw.TRACE_LEVEL_VERBOSE, n.Enable.Level)
+
+	stopWatch()
+	s.waitForSignal(watchDone, deadline, "Failed to stop watching providers")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestRelatedActivityID ensures that activity and related activity IDs are
+// exposed separately.
+func (s *sessionSuite) TestRelatedActivityID() {
+	const deadline = 10 * time.Second
+	activity, err := guid.NewV4()
+	s.Require().NoError(err, "Failed to generate activity ID")
+	related, err := guid.NewV4()
+	s.Require().NoError(err, "Failed to generate related activity ID")
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			_ = s.provider.WriteEvent(
+				"TestEvent",
+				msetw.WithEventOpts(
+					msetw.WithLevel(msetw.LevelInfo),
+					msetw.WithActivityID(activity),
+					msetw.WithRelatedActivityID(related),
+				),
+				nil,
+			)
+		}
+	}()
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+
+	var (
+		header        etw.EventHeader
+		info          etw.ExtendedEventInfo
+		relatedID     windows.GUID
+		isRelated     bool
+		hasRelatedID  bool
+		isNotRelated  bool
+		gotEvent      = make(chan struct{})
+		receivedEvent bool
+	)
+	cb := func(e *etw.Event) {
+		if !receivedEvent {
+			receivedEvent = true
+			header = e.Header
+			info = e.ExtendedInfo()
+			relatedID, hasRelatedID = e.RelatedActivityID()
+			isRelated = e.IsRelatedTo(windows.GUID(related))
+			isNotRelated = e.IsRelatedTo(windows.GUID{})
+		}
+		s.trySignal(gotEvent)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+
+	s.True(header.HasActivityID(), "Event has no activity ID")
+	s.Equal(windows.GUID(activity), header.ActivityID)
+	s.Require().NotNil(info.RelatedActivityID, "Event has no related activity ID")
+	s.Equal(windows.GUID(related), *info.RelatedActivityID)
+	s.Equal(info.RelatedActivityID, info.ActivityID, "Compatibility alias differs")
+	s.True(hasRelatedID, "Related activity ID is not found")
+	s.Equal(windows.GUID(related), relatedID)
+	s.True(isRelated, "Event is not related to its related activity")
+	s.False(isNotRelated, "Event is related to zero activity")
+}
+
+// TestFieldRoundTrip ensures that fields of various TDH in-types are parsed
+// as written.
+func (s *sessionSuite) TestFieldRoundTrip() {
+	const deadline = 10 * time.Second
+	provider, err := testutil.NewProvider("TestRoundTripProvider")
+	s.Require().NoError(err, "Failed to register test provider")
+	defer func() {
+		s.NoError(provider.Close(), "Failed to close test provider")
+	}()
+
+	sid, err := windows.StringToSid("S-1-5-18")
+	s.Require().NoError(err, "Failed to create SID")
+	guid := windows.GUID{Data1: 0x1C95126E, Data2: 0x7EEA, Data3: 0x49A9, Data4: [8]byte{0xA3, 0xFE, 0xA3, 0x78, 0xB0, 0x3D, 0xDB, 0x4D}}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	go provider.Generate(ctx, "RoundTrip", etw.TRACE_LEVEL_INFORMATION,
+		testutil.String("string", "value"),
+		testutil.AnsiString("ansi", "ansi value"),
+		testutil.Uint32("uint32", 42),
+		testutil.Int64("int64", -42),
+		testutil.HexInt32("hexint32", 0x2A),
+		testutil.Binary("binary", []byte{1, 2}),
+		testutil.GUID("guid", guid),
+		testutil.SID("sid", sid),
+		testutil.FileTime("filetime", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)),
+		testutil.IPv6("ipv6", net.ParseIP("::1")),
+	)
+
+	session, err := etw.NewSession(provider.GUID())
+	s.Require().NoError(err, "Failed to create session")
+
+	var properties map[string]interface{}
+	gotEvent := make(chan struct{})
+	cb := func(e *etw.Event) {
+		if properties == nil {
+			properties, err = e.EventProperties()
+			s.Require().NoError(err, "Failed to parse event properties")
+		}
+		s.trySignal(gotEvent)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+
+	s.Equal("value", properties["string"])
+	s.Equal("ansi value", properties["ansi"])
+	s.Equal("42", properties["uint32"])
+	s.Equal("-42", properties["int64"])
+	s.Equal("0x2A", properties["hexint32"])
+	s.Equal("0x0102", properties["binary"])
+	s.Equal(guid.String(), properties["guid"])
+	s.Equal("S-1-5-18", properties["sid"])
+	s.NotEmpty(properties["filetime"], "FILETIME is not parsed")
+	s.Equal("::1", properties["ipv6"])
+}
+
+// TestRun ensures that Session.Run processes events until the context is done
+// and closes the session.
+func (s *sessionSuite) TestRun() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	gotEvent := make(chan struct{})
+	cb := func(e *etw.Event) {
+		s.trySignal(gotEvent)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.NoError(session.Run(ctx, cb), "Error running session")
+		close(done)
+	}()
+
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+	cancel()
+	s.waitForSignal(done, deadline, "Failed to stop session on context cancellation")
+}
+
+// TestStrictOrderCallbacks ensures that events are delivered in the timestamp
+// order in CallbackStrictOrder mode.
+func (s *sessionSuite) TestStrictOrderCallbacks() {
+	const (
+		deadline = 10 * time.Second
+		expected = 1000
+	)
+	// Write events from several goroutines to make them logged on
+	// different processors.
+	for i := 0; i < 4; i++ {
+		go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+	}
+
+	session, err := etw.NewSession(s.guid, etw.WithCallbackMode(etw.CallbackStrictOrder))
+	s.Require().NoError(err, "Failed to create session")
+
+	var (
+		last      time.Time
+		received  int
+		reordered int
+	)
+	gotEvents := make(chan struct{})
+	cb := func(e *etw.Event) {
+		if e.Header.TimeStamp.Before(last) {
+			reordered++
+		}
+		last = e.Header.TimeStamp
+		if received++; received >= expected {
+			s.trySignal(gotEvents)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+
+	s.waitForSignal(gotEvents, deadline, "Failed to receive events from provider")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+	s.Zero(reordered, "Events are delivered out of order")
+}
+
+// TestParallelCallbacks ensures that events are valid in concurrent
+// callbacks of CallbackParallel mode.
+func (s *sessionSuite) TestParallelCallbacks() {
+	const (
+		deadline = 10 * time.Second
+		expected = 100
+	)
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo}, msetw.StringField("string", "value"))
+
+	session, err := etw.NewSession(s.guid, etw.WithParallelCallbacks(4))
+	s.Require().NoError(err, "Failed to create session")
+
+	var (
+		parsed     int64
+		concurrent int64
+		maxSeen    int64
+	)
+	gotEvents := make(chan struct{})
+	cb := func(e *etw.Event) {
+		current := atomic.AddInt64(&concurrent, 1)
+		defer atomic.AddInt64(&concurrent, -1)
+		for {
+			seen := atomic.LoadInt64(&maxSeen)
+			if current <= seen || atomic.CompareAndSwapInt64(&maxSeen, seen, current) {
+				break
+			}
+		}
+
+		properties, err := e.EventProperties()
+		if err == nil && properties["string"] == "value" {
+			if atomic.AddInt64(&parsed, 1) >= expected {
+				s.trySignal(gotEvents)
+			}
+		}
+		time.Sleep(time.Millisecond) // Let callbacks overlap.
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+
+	s.waitForSignal(gotEvents, deadline, "Failed to parse events in parallel callbacks")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+	s.True(atomic.LoadInt64(&maxSeen) > 1, "Callbacks are not called concurrently")
+}
+
+// TestPipeline ensures that events are parsed by Pipeline workers off the
+// ProcessTrace thread.
+func (s *sessionSuite) TestPipeline() {
+	const (
+		deadline = 10 * time.Second
+		expected = 100
+	)
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo}, msetw.StringField("string", "value"))
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+
+	var parsed int64
+	gotEvents := make(chan struct{})
+	pipeline := etw.NewPipeline(func(pe *etw.ParsedEvent) {
+		if pe.Err == nil && pe.Properties["string"] == "value" {
+			if atomic.AddInt64(&parsed, 1) >= expected {
+				s.trySignal(gotEvents)
+			}
+		}
+	}, 4, 64)
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(pipeline.Callback()), "Error processing events")
+		pipeline.Close()
+		close(done)
+	}()
+
+	s.waitForSignal(gotEvents, deadline, "Failed to parse events in the pipeline")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestRundownBatcher ensures that rundown events are collapsed into snapshots
+// and regular ones are passed through.
+func (s *sessionSuite) TestRundownBatcher() {
+	const (
+		deadline  = 10 * time.Second
+		stormSize = 50
+	)
+	go func() {
+		for s.ctx.Err() == nil {
+			for i := 0; i < stormSize; i++ {
+				_ = s.provider.WriteEvent(
+					"Rundown",
+					msetw.WithEventOpts(msetw.WithOpcode(msetw.OpcodeDCStart)),
+					msetw.WithFields(msetw.Uint32Field("index", uint32(i))))
+			}
+			_ = s.provider.WriteEvent("Regular", msetw.WithEventOpts(msetw.WithLevel(msetw.LevelInfo)), nil)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+
+	gotSnapshot := make(chan struct{})
+	var (
+		snapshot   etw.RundownSnapshot
+		rundowns   int
+		regulars   int
+		captureErr error
+	)
+	batcher := etw.NewRundownBatcher(func(rs etw.RundownSnapshot) {
+		if rs.Truncated > 0 && snapshot.Events == nil {
+			snapshot = rs
+			s.trySignal(gotSnapshot)
+		}
+	}, etw.WithRundownMaxEvents(stormSize/2))
+	cb := batcher.Callback(func(e *etw.Event) {
+		if e.Header.IsRundown() {
+			rundowns++
+		}
+		if regulars++; regulars == 1 {
+			captureErr = session.CaptureState(s.guid)
+		}
+	})
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+
+	s.waitForSignal(gotSnapshot, deadline, "Failed to receive rundown snapshot")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+	batcher.Flush()
+
+	s.NoError(captureErr, "Failed to request provider state")
+	s.Zero(rundowns, "Rundown events are passed through")
+	s.Equal(s.guid, snapshot.ProviderID, "Unexpected snapshot provider")
+	s.Len(snapshot.Events, stormSize/2, "Snapshot is not limited")
+	s.False(snapshot.End.Before(snapshot.Start), "Snapshot ends before it starts")
+	for _, e := range snapshot.Events {
+		s.True(e.Header.IsRundown(), "Regular event is in snapshot")
+	}
+}
+
+// TestNoPerProcessorBuffering ensures that the session is created with shared
+// buffers on request.
+func (s *sessionSuite) TestNoPerProcessorBuffering() {
+	const eventTraceNoPerProcessorBuffering = 0x10000000 // EVENT_TRACE_NO_PER_PROCESSOR_BUFFERING
+
+	session, err := etw.NewSession(s.guid, etw.WithNoPerProcessorBuffering())
+	s.Require().NoError(err, "Failed to create session")
+	defer func() {
+		s.Require().NoError(session.Close(), "Failed to close session properly")
+	}()
+
+	props, err := session.TraceProperties()
+	s.Require().NoError(err, "Failed to query session properties")
+	s.NotZero(props.LogFileMode&eventTraceNoPerProcessorBuffering, "Session has per-processor buffers")
+}
+
+// TestSecureMode ensures that the session is created in the secure mode with
+// the requested GUID and receives events of the process granted logging.
+func (s *sessionSuite) TestSecureMode() {
+	const (
+		deadline             = 10 * time.Second
+		eventTraceSecureMode = 0x00000080 // EVENT_TRACE_SECURE_MODE
+	)
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	sessionGUID, err := windows.GenerateGUID()
+	s.Require().NoError(err, "Failed to generate session GUID")
+	session, err := etw.NewSession(s.guid, etw.WithSecureMode(), etw.WithSessionGUID(sessionGUID))
+	s.Require().NoError(err, "Failed to create session")
+
+	props, err := session.TraceProperties()
+	s.Require().NoError(err, "Failed to query session properties")
+	s.NotZero(props.LogFileMode&eventTraceSecureMode, "Session is not in secure mode")
+	s.Equal(sessionGUID, props.SessionGUID, "Session GUID is not applied")
+
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	s.Require().NoError(err, "Failed to get process user")
+	s.Require().NoError(session.GrantLogging(user.User.Sid), "Failed to grant logging")
+
+	gotEvent := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(func(e *etw.Event) {
+			s.trySignal(gotEvent)
+		}), "Error processing events")
+		close(done)
+	}()
+
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestIndependentSession ensures that the session is created in the
+// independent mode on systems supporting it.
+func (s *sessionSuite) TestIndependentSession() {
+	const eventTraceIndependentSessionMode = 0x08000000 // EVENT_TRACE_INDEPENDENT_SESSION_MODE
+
+	session, err := etw.NewSession(s.guid, etw.WithIndependentSession())
+	if !etw.Capabilities().IndependentSessions {
+		s.True(errors.Is(err, etw.ErrUnsupportedOSVersion), "Unexpected error %v", err)
+		return
+	}
+	s.Require().NoError(err, "Failed to create session")
+	defer func() {
+		s.Require().NoError(session.Close(), "Failed to close session properly")
+	}()
+
+	props, err := session.TraceProperties()
+	s.Require().NoError(err, "Failed to query session properties")
+	s.NotZero(props.LogFileMode&eventTraceIndependentSessionMode, "Session is not independent")
+}
+
+// TestProviderSessions ensures that sessions enabled the provider are listed
+// with their enable parameters.
+func (s *sessionSuite) TestProviderSessions() {
 	const deadline = 10 * time.Second
 	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
 
-	session, err := etw.NewSession(s.guid)
+	session, err := etw.NewSession(s.guid, etw.WithLevel(etw.TRACE_LEVEL_WARNING), etw.WithMatchKeywords(0x10, 0))
 	s.Require().NoError(err, "Failed to create session")
 
-	// Grab event pointer from the callback. We expect that outdated pointer
-	// will protect user from calling Windows API on freed memory.
-	var evt *etw.Event
 	gotEvent := make(chan struct{})
-	cb := func(e *etw.Event) {
-		// Signal on second event only to guarantee that callback with stored event will finish.
-		if evt != nil {
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(func(e *etw.Event) {
 			s.trySignal(gotEvent)
-		} else {
-			evt = e
+		}), "Error processing events")
+		close(done)
+	}()
+	defer func() {
+		s.Require().NoError(session.Close(), "Failed to close session properly")
+		s.waitForSignal(done, deadline, "Failed to stop event processing")
+	}()
+
+	// Wait for the provider being enabled.
+	var found *etw.ProviderSession
+	for start := time.Now(); found == nil && time.Since(start) < deadline; time.Sleep(100 * time.Millisecond) {
+		sessions, err := etw.ProviderSessions(s.guid)
+		s.Require().NoError(err, "Failed to query provider sessions")
+		for i := range sessions {
+			if sessions[i].SessionName == session.Options().Name {
+				found = &sessions[i]
+			}
 		}
 	}
+	s.Require().NotNil(found, "Session is not listed")
+	s.Equal(etw.TRACE_LEVEL_WARNING, found.Enable.Level, "Unexpected level")
+	s.Equal(uint64(0x10), found.Enable.MatchAnyKeyword, "Unexpected keywords")
+	s.Contains(found.ProcessIDs, windows.GetCurrentProcessId(), "Provider process is not listed")
+}
+
+// TestCloseKilledSession ensures that `.Close` stops processing of the session
+// killed externally.
+func (s *sessionSuite) TestCloseKilledSession() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+
+	gotEvent := make(chan struct{})
 	done := make(chan struct{})
 	go func() {
-		s.Require().NoError(session.Process(cb), "Error processing events")
+		s.NoError(session.Process(func(e *etw.Event) {
+			s.trySignal(gotEvent)
+		}), "Error processing events")
 		close(done)
 	}()
-
-	// Wait for event arrived and try to access event data.
 	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
-	s.Assert().Zero(evt.ExtendedInfo(), "Got non-nil ExtendedInfo for freed event")
-	_, err = evt.EventProperties()
-	s.Assert().Error(err, "Don't get an error using freed event")
-	s.Assert().Contains(err.Error(), "EventCallback", "Got unexpected error: %s", err)
 
+	s.Require().NoError(etw.KillSession(session.Options().Name), "Failed to kill session")
+	_ = session.Close() // The session is gone, so failing to stop it is ok.
+	s.waitForSignal(done, deadline, "Failed to stop processing of killed session")
+}
+
+// TestCloseStopsAllConsumers ensures that `.Close` stops all the consumers
+// opened by concurrent `.Process` calls.
+func (s *sessionSuite) TestCloseStopsAllConsumers() {
+	const (
+		deadline  = 10 * time.Second
+		consumers = 3
+	)
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+
+	gotEvents := make(chan struct{}, consumers)
+	done := make(chan struct{}, consumers)
+	for i := 0; i < consumers; i++ {
+		go func() {
+			var once sync.Once
+			s.NoError(session.Process(func(e *etw.Event) {
+				once.Do(func() { gotEvents <- struct{}{} })
+			}), "Error processing events")
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < consumers; i++ {
+		s.waitForSignal(gotEvents, deadline, "Failed to receive events by all consumers")
+	}
 	s.Require().NoError(session.Close(), "Failed to close session properly")
-	s.waitForSignal(done, deadline, "Failed to stop event processing")
+	for i := 0; i < consumers; i++ {
+		s.waitForSignal(done, deadline, "Failed to stop all consumers")
+	}
 }
 
-// trySignal tries to send a signal to @done if it's ready to receive.
-// @done expected to be a buffered channel.
-func (s sessionSuite) trySignal(done chan<- struct{}) {
+// TestAutoRestart ensures that a session killed by someone else is recreated
+// and keeps delivering events to the same `.Process` call.
+func (s *sessionSuite) TestAutoRestart() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	restarted := make(chan etw.RestartStatus, 1)
+	session, err := etw.NewSession(s.guid, etw.WithAutoRestart(etw.RestartPolicy{
+		MaxRestarts: 1,
+		OnStatus: func(status etw.RestartStatus) {
+			restarted <- status
+		},
+	}))
+	s.Require().NoError(err, "Failed to create session")
+
+	gotEvent := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		s.NoError(session.Process(func(e *etw.Event) {
+			s.trySignal(gotEvent)
+		}), "Error processing events")
+		close(done)
+	}()
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+
+	s.Require().NoError(etw.KillSession(session.Options().Name), "Failed to kill session")
 	select {
-	case done <- struct{}{}:
+	case status := <-restarted:
+		s.NoError(status.Err, "Failed to restart session")
+		s.Equal(1, status.Restart, "Unexpected restart number")
+	case <-time.After(deadline):
+		s.Fail("Failed to restart killed session")
+	}
+
+	// Drain events received before the kill.
+	select {
+	case <-gotEvent:
 	default:
 	}
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from restarted session")
+
+	s.Require().NoError(session.Close(), "Failed to close restarted session")
+	s.waitForSignal(done, deadline, "Failed to stop processing of restarted session")
 }
 
-// waitForSignal waits for anything on @done no longer than @deadline.
-// Fails test run if deadline exceeds.
-func (s sessionSuite) waitForSignal(done <-chan struct{}, deadline time.Duration, failMsg string) {
+// TestWatchRestarted ensures that provider changes are reported after the
+// session is restarted.
+func (s *sessionSuite) TestWatchRestarted() {
+	const deadline = 10 * time.Second
+
+	restarted := make(chan etw.RestartStatus, 1)
+	session, err := etw.NewSession(s.guid,
+		etw.WithLevel(etw.TRACE_LEVEL_INFORMATION),
+		etw.WithAutoRestart(etw.RestartPolicy{
+			MaxRestarts: 1,
+			OnStatus: func(status etw.RestartStatus) {
+				restarted <- status
+			},
+		}))
+	s.Require().NoError(err, "Failed to create session")
+
+	done := make(chan struct{})
+	go func() {
+		s.NoError(session.Process(func(_ *etw.Event) {}), "Error processing events")
+		close(done)
+	}()
+
+	notifications := make(chan etw.ProviderNotification, 16)
+	watchCtx, stopWatch := context.WithCancel(s.ctx)
+	watchDone := make(chan struct{})
+	go func() {
+		err := session.WatchProviders(watchCtx, 100*time.Millisecond, func(n etw.ProviderNotification) {
+			if n.Type == etw.ProviderEnableChanged {
+				notifications <- n
+			}
+		})
+		s.NoError(err, "Error watching providers")
+		close(watchDone)
+	}()
+	time.Sleep(500 * time.Millisecond) // Let the baseline be taken.
+
+	s.Require().NoError(etw.KillSession(session.Options().Name), "Failed to kill session")
 	select {
-	case <-done:
-		// pass.
+	case status := <-restarted:
+		s.Require().NoError(status.Err, "Failed to restart session")
 	case <-time.After(deadline):
-		s.Fail(failMsg, "deadline %s exceeded", deadline)
+		s.FailNow("Failed to restart killed session")
+	}
+	time.Sleep(500 * time.Millisecond) // Let the new baseline be taken.
+	for len(notifications) != 0 {
+		<-notifications // Changes caught in between of the kill and restart.
+	}
+
+	s.Require().NoError(session.SetLevel(s.guid, etw.TRACE_LEVEL_VERBOSE))
+	select {
+	case n := <-notifications:
+		s.True(n.Enable.IsEnabled, "Provider of restarted session is reported disabled")
+		s.Equal(etw.TRACE_LEVEL_VERBOSE, n.Enable.Level)
+	case <-time.After(deadline):
+		s.Fail("Enable change of restarted session is not reported")
 	}
+
+	stopWatch()
+	s.waitForSignal(watchDone, deadline, "Failed to stop watching providers")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
 }
 
-// We have no easy way to ensure that etw session is started and ready to process events,
-// so it seems easier to just flood an events and catch some of them than try to catch
-// the actual session readiness and sent the only one.
-func (s sessionSuite) generateEvents(ctx context.Context, levels []msetw.Level, fields ...msetw.FieldOpt) {
-	// If nothing provided, receiver doesn't care about the event content -- send anything.
-	if fields == nil {
-		fields = msetw.WithFields(msetw.StringField("TestField", "Foo"))
+// TestStatus ensures that session lifecycle events are sent to the status
+// channel in order.
+func (s *sessionSuite) TestStatus() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid, etw.WithAutoRestart(etw.RestartPolicy{MaxRestarts: 1}))
+	s.Require().NoError(err, "Failed to create session")
+
+	restarted := make(chan struct{}, 1)
+	statusDone := make(chan struct{})
+	var kinds []etw.StatusKind
+	go func() {
+		for e := range session.Status() {
+			if e.Kind == etw.StatusProviderEnabled {
+				s.Equal(s.guid, e.ProviderID, "Unexpected enabled provider")
+			}
+			if e.Kind == etw.StatusRestarted {
+				s.NoError(e.Err, "Failed to restart session")
+				s.trySignal(restarted)
+			}
+			kinds = append(kinds, e.Kind)
+		}
+		close(statusDone)
+	}()
+
+	gotEvent := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		s.NoError(session.Process(func(e *etw.Event) {
+			s.trySignal(gotEvent)
+		}), "Error processing events")
+		close(done)
+	}()
+	s.waitForSignal(gotEvent, deadline, "Failed to receive event from provider")
+
+	s.Require().NoError(etw.KillSession(session.Options().Name), "Failed to kill session")
+	s.waitForSignal(restarted, deadline, "Failed to restart killed session")
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop processing")
+	s.waitForSignal(statusDone, deadline, "Status channel is not closed")
+
+	s.Equal([]etw.StatusKind{
+		etw.StatusProviderEnabled,
+		etw.StatusStarted,
+		etw.StatusStopped,
+		etw.StatusProviderEnabled,
+		etw.StatusRestarted,
+		etw.StatusClosed,
+	}, kinds, "Unexpected status events")
+}
+
+// TestStatusOverflow ensures that StatusClosed is delivered even if nobody
+// has read the status channel.
+func (s *sessionSuite) TestStatusOverflow() {
+	const updates = 100
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+	for i := 0; i < updates; i++ {
+		s.Require().NoError(session.UpdateOptions(etw.WithLevel(etw.TRACE_LEVEL_INFORMATION)),
+			"Failed to update session")
 	}
-	s.Require().NotEmpty(levels, "Incorrect generateEvents usage")
+	s.Require().NoError(session.Close(), "Failed to close session properly")
 
-	for {
-		select {
-		case <-ctx.Done():
+	var last etw.StatusEvent
+	count := 0
+	for e := range session.Status() {
+		last = e
+		count++
+	}
+	s.True(count < updates, "Status events are not limited: %d", count)
+	s.Equal(etw.StatusClosed, last.Kind, "StatusClosed is not delivered")
+}
+
+// TestKernelStackWalk ensures that stacks of the selected kernel events are
+// attached to them.
+func (s *sessionSuite) TestKernelStackWalk() {
+	const deadline = 20 * time.Second
+
+	_, err := etw.NewSession(s.guid, etw.WithKernelStackWalk(etw.StackWalkProcessCreate))
+	s.Error(err, "Regular session accepted kernel stack walk")
+
+	session, err := etw.NewKernelSession(etw.EVENT_TRACE_FLAG_PROCESS,
+		etw.WithKernelStackWalk(etw.StackWalkProcessCreate))
+	var exists etw.ExistsError
+	if errors.As(err, &exists) {
+		s.T().Skip("NT Kernel Logger is used by someone else")
+	}
+	s.Require().NoError(err, "Failed to create kernel session")
+
+	gotStack := make(chan *etw.EventStackTrace, 1)
+	cb := func(e *etw.Event) {
+		s.NotEqual(etw.StackWalkGUID, e.Header.ProviderID, "Got StackWalk event")
+		if e.Header.ProviderID != etw.StackWalkProcessCreate.EventGUID ||
+			e.Header.OpCode != etw.StackWalkProcessCreate.Type {
 			return
-		default:
-			for _, l := range levels {
-				_ = s.provider.WriteEvent(
-					"TestEvent",
-					msetw.WithEventOpts(msetw.WithLevel(l)),
-					fields,
-				)
+		}
+		if stack := e.ExtendedInfo().StackTrace; stack != nil {
+			select {
+			case gotStack <- stack:
+			default:
 			}
 		}
 	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Require().NoError(session.Process(cb), "Error processing events")
+		close(done)
+	}()
+
+	// Spawn processes until the session catches one of them.
+	go func() {
+		for s.ctx.Err() == nil {
+			_ = exec.CommandContext(s.ctx, "cmd.exe", "/c", "exit").Run()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+
+	select {
+	case stack := <-gotStack:
+		s.NotEmpty(stack.Addresses, "Got empty kernel stack")
+	case <-time.After(deadline):
+		s.Fail("Failed to get process start event with stack")
+	}
+
+	s.Require().NoError(session.Close(), "Failed to close session properly")
+	s.waitForSignal(done, deadline, "Failed to stop event processing")
+}
+
+// TestBroker ensures that Broker subscribers receive events matching their
+// filters and a stuck subscriber doesn't affect others.
+func (s *sessionSuite) TestBroker() {
+	const deadline = 10 * time.Second
+	go s.generateEvents(s.ctx, []msetw.Level{msetw.LevelInfo})
+
+	session, err := etw.NewSession(s.guid)
+	s.Require().NoError(err, "Failed to create session")
+	broker := etw.NewBroker(session)
+
+	gotEvent := make(chan struct{}, 1)
+	fast, err := broker.Subscribe(func(e *etw.ParsedEvent) {
+		s.NoError(e.Err, "Failed to parse event")
+		s.trySignal(gotEvent)
+	}, etw.WithSubscriberFilter(func(h *etw.EventHeader) bool {
+		return h.ProviderID == s.guid
+	}))
+	s.Require().NoError(err, "Failed to subscribe")
+
+	none, err := broker.Subscribe(func(e *etw.ParsedEvent) {
+		s.Fail("Filtered out event is delivered")
+	}, etw.WithSubscriberFilter(func(h *etw.EventHeader) bool { return false }))
+	s.Require().NoError(err, "Failed to subscribe")
+
+	unblock := make(chan struct{})
+	stuck, err := broker.Subscribe(func(e *etw.ParsedEvent) {
+		<-unblock
+	}, etw.WithQueueSize(1))
+	s.Require().NoError(err, "Failed to subscribe")
+
+	done := make(chan struct{})
+	go func() {
+		s.NoError(broker.Process(), "Error processing events")
+		close(done)
+	}()
+
+	// The fast subscriber keeps receiving events while the stuck one drops them.
+	for i := 0; i < 10; i++ {
+		s.waitForSignal(gotEvent, deadline, "Failed to receive event by subscriber")
+	}
+	s.NotZero(stuck.Stats().Dropped, "Stuck subscriber dropped no events")
+	s.Zero(none.Stats().Matched, "Filtered out events are matched")
+	s.NotZero(fast.Stats().Delivered, "Delivered events are not counted")
+
+	close(unblock)
+	s.Require().NoError(broker.Close(), "Failed to close broker")
+	s.waitForSignal(done, deadline, "Failed to stop processing")
+	_, err = broker.Subscribe(func(e *etw.ParsedEvent) {})
+	s.Error(err, "Closed broker accepted subscriber")
 }
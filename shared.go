@@ -0,0 +1,232 @@
+//+build windows
+
+package etw
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"golang.org/x/sys/windows"
+)
+
+// SharedSession is a session shared by several processes, e.g. instances of
+// the same agent running as a system service and per user. Only one of them
+// owns the session: creates, controls and eventually stops it. Others attach
+// to it as consumers and receive the same events.
+//
+// Ownership is elected with a named mutex, so there is no ExistsError race
+// between processes starting simultaneously. If the owner exits without
+// closing the session, a consumer could take it over with `.TryAcquire`.
+type SharedSession struct {
+	guid    windows.GUID
+	options []Option
+	name    string
+
+	mu      sync.Mutex
+	lock    *mutexLock
+	session *Session
+	trace   *Trace
+	closed  bool
+}
+
+// JoinSharedSession joins the session named by WithName in @options (that is
+// required) to consume events of the provider @providerGUID. If no other
+// process owns the session the calling one becomes the owner and creates the
+// session with @options. A session left by a crashed owner is adopted then.
+//
+// All participating processes should pass the same options, as the owner is
+// not known in advance.
+func JoinSharedSession(providerGUID windows.GUID, options ...Option) (*SharedSession, error) {
+	var cfg SessionOptions
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("shared session should be named with WithName")
+	}
+
+	s := &SharedSession{
+		guid:    providerGUID,
+		options: options,
+		name:    cfg.Name,
+	}
+	if _, err := s.TryAcquire(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// IsOwner returns true if the calling process owns the session.
+func (s *SharedSession) IsOwner() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.session != nil
+}
+
+// Session returns the owned Session to control it, e.g. to add providers.
+// Returns nil if the calling process is a consumer.
+func (s *SharedSession) Session() *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.session
+}
+
+// TryAcquire tries to take over the session ownership if the previous owner
+// has released it. Returns true if the calling process owns the session.
+//
+// Consumers whose `.Process` returned because the owner stopped the session
+// could call TryAcquire to recreate it.
+func (s *SharedSession) TryAcquire() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false, fmt.Errorf("shared session %q is closed", s.name)
+	}
+	if s.session != nil {
+		return true, nil
+	}
+
+	lock, acquired, err := tryLockMutex(`Global\etw-session-` + s.name)
+	if err != nil || !acquired {
+		return false, err
+	}
+	options := append(append([]Option(nil), s.options...), WithAdoptExisting())
+	session, err := NewSession(s.guid, options...)
+	if err != nil {
+		lock.unlock()
+		return false, fmt.Errorf("failed to create shared session; %w", err)
+	}
+	s.lock, s.session = lock, session
+	return true, nil
+}
+
+// Process processes the session events passing them to @cb. The owner
+// processes them as Session.Process does, consumers open the session
+// real-time events stream and fail if the session doesn't exist (yet).
+//
+// Process blocks until `.Close` being called or, for consumers, until the
+// owner stops the session.
+func (s *SharedSession) Process(cb EventCallback) error {
+	s.mu.Lock()
+	if s.session != nil {
+		session := s.session
+		s.mu.Unlock()
+		return session.Process(cb)
+	}
+	if s.closed {
+		s.mu.Unlock()
+		return fmt.Errorf("shared session %q is closed", s.name)
+	}
+	trace, err := OpenTrace(TraceOptions{LoggerName: s.name}, cb)
+	if err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("failed to attach to shared session %q; %w", s.name, err)
+	}
+	s.trace = trace
+	s.mu.Unlock()
+
+	err = trace.Process()
+
+	s.mu.Lock()
+	if s.trace == trace {
+		s.trace = nil
+		_ = trace.Close()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// Close stops the session if the calling process owns it and releases the
+// ownership. Consumers just stop their processing.
+func (s *SharedSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+
+	if s.trace != nil {
+		trace := s.trace
+		s.trace = nil
+		if err := trace.Close(); err != nil {
+			return fmt.Errorf("failed to detach from shared session; %w", err)
+		}
+	}
+	if s.session == nil {
+		return nil
+	}
+	err := s.session.Close()
+	s.lock.unlock()
+	s.session, s.lock = nil, nil
+	if err != nil {
+		return fmt.Errorf("failed to close shared session; %w", err)
+	}
+	return nil
+}
+
+// mutexLock is an acquired named mutex.
+//
+// Mutexes are owned by OS threads, so the mutex is acquired and released by
+// a dedicated goroutine locked to its thread. Otherwise the runtime could
+// terminate the owning thread making the mutex abandoned.
+type mutexLock struct {
+	release chan struct{}
+	done    chan struct{}
+}
+
+// tryLockMutex tries to acquire the mutex @name without waiting. A mutex
+// abandoned by a crashed owner is acquired too. Mutexes created by other
+// users we have no access to are considered owned by them.
+func tryLockMutex(name string) (*mutexLock, bool, error) {
+	nameUTF16, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, false, fmt.Errorf("incorrect mutex name; %w", err)
+	}
+
+	type result struct {
+		acquired bool
+		err      error
+	}
+	l := &mutexLock{release: make(chan struct{}), done: make(chan struct{})}
+	results := make(chan result)
+	go func() {
+		defer close(l.done)
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		mutex, err := windows.CreateMutex(nil, false, nameUTF16)
+		switch {
+		case err == windows.ERROR_ACCESS_DENIED:
+			results <- result{acquired: false}
+			return
+		case err != nil:
+			results <- result{err: fmt.Errorf("CreateMutex failed; %w", err)}
+			return
+		}
+		defer windows.CloseHandle(mutex) //nolint:errcheck
+
+		event, err := windows.WaitForSingleObject(mutex, 0)
+		switch {
+		case err != nil:
+			results <- result{err: fmt.Errorf("WaitForSingleObject failed; %w", err)}
+			return
+		case event != windows.WAIT_OBJECT_0 && event != windows.WAIT_ABANDONED:
+			results <- result{acquired: false}
+			return
+		}
+		results <- result{acquired: true}
+		<-l.release
+		_ = windows.ReleaseMutex(mutex)
+	}()
+
+	r := <-results
+	if !r.acquired {
+		return nil, false, r.err
+	}
+	return l, true, nil
+}
+
+// unlock releases the mutex and waits for it to be released.
+func (l *mutexLock) unlock() {
+	close(l.release)
+	<-l.done
+}
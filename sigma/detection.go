@@ -0,0 +1,391 @@
+package sigma
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// fieldGetter returns values of the event field named by Sigma @field.
+// Arrays are returned as multiple values.
+type fieldGetter func(field string) []interface{}
+
+// matcher matches an event represented by fieldGetter and the list of all
+// string values (for keyword searches).
+type matcher interface {
+	match(get fieldGetter, values []string) bool
+}
+
+//
+// Selections.
+//
+
+// fieldMatcher matches a single `field|modifiers: values` selection item.
+type fieldMatcher struct {
+	field    string
+	all      bool // Every pattern should match rather than any.
+	patterns []*regexp.Regexp
+	isNull   bool // Field should be absent.
+}
+
+func (f fieldMatcher) match(get fieldGetter, _ []string) bool {
+	values := get(f.field)
+	if f.isNull {
+		return len(values) == 0
+	}
+	for _, p := range f.patterns {
+		found := false
+		for _, v := range values {
+			if p.MatchString(fmt.Sprint(v)) {
+				found = true
+				break
+			}
+		}
+		if f.all && !found {
+			return false
+		}
+		if !f.all && found {
+			return true
+		}
+	}
+	return f.all
+}
+
+// andMatcher matches if every child matches (map selections).
+type andMatcher []matcher
+
+func (a andMatcher) match(get fieldGetter, values []string) bool {
+	for _, m := range a {
+		if !m.match(get, values) {
+			return false
+		}
+	}
+	return true
+}
+
+// orMatcher matches if any child matches (list selections).
+type orMatcher []matcher
+
+func (o orMatcher) match(get fieldGetter, values []string) bool {
+	for _, m := range o {
+		if m.match(get, values) {
+			return true
+		}
+	}
+	return false
+}
+
+// keywordMatcher matches if any string value of the event matches the
+// pattern.
+type keywordMatcher struct {
+	pattern *regexp.Regexp
+}
+
+func (k keywordMatcher) match(_ fieldGetter, values []string) bool {
+	for _, v := range values {
+		if k.pattern.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+type notMatcher struct{ m matcher }
+
+func (n notMatcher) match(get fieldGetter, values []string) bool {
+	return !n.m.match(get, values)
+}
+
+// compileSelection compiles a single named selection of the detection
+// section.
+func compileSelection(v interface{}) (matcher, error) {
+	switch s := v.(type) {
+	case map[interface{}]interface{}:
+		var and andMatcher
+		// Sort keys for deterministic evaluation order.
+		keys := make([]string, 0, len(s))
+		for k := range s {
+			keys = append(keys, fmt.Sprint(k))
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			f, err := compileField(k, s[k])
+			if err != nil {
+				return nil, err
+			}
+			and = append(and, f)
+		}
+		return and, nil
+
+	case []interface{}:
+		var or orMatcher
+		for _, item := range s {
+			if _, ok := item.(map[interface{}]interface{}); ok {
+				m, err := compileSelection(item)
+				if err != nil {
+					return nil, err
+				}
+				or = append(or, m)
+				continue
+			}
+			p, err := globPattern("*" + fmt.Sprint(item) + "*")
+			if err != nil {
+				return nil, err
+			}
+			or = append(or, keywordMatcher{pattern: p})
+		}
+		return or, nil
+
+	case string:
+		p, err := globPattern("*" + s + "*")
+		if err != nil {
+			return nil, err
+		}
+		return keywordMatcher{pattern: p}, nil
+	}
+	return nil, fmt.Errorf("unsupported selection type %T", v)
+}
+
+// compileField compiles `field|modifier|...: value(s)` item.
+func compileField(key string, value interface{}) (matcher, error) {
+	parts := strings.Split(key, "|")
+	f := fieldMatcher{field: parts[0]}
+
+	var values []interface{}
+	switch v := value.(type) {
+	case nil:
+		f.isNull = true
+		return f, nil
+	case []interface{}:
+		values = v
+	default:
+		values = []interface{}{v}
+	}
+
+	var prefix, suffix string
+	isRegexp := false
+	for _, modifier := range parts[1:] {
+		switch modifier {
+		case "contains":
+			prefix, suffix = "*", "*"
+		case "startswith":
+			suffix = "*"
+		case "endswith":
+			prefix = "*"
+		case "all":
+			f.all = true
+		case "re":
+			isRegexp = true
+		default:
+			return nil, fmt.Errorf("unsupported modifier %q of field %q", modifier, parts[0])
+		}
+	}
+
+	for _, v := range values {
+		var (
+			p   *regexp.Regexp
+			err error
+		)
+		if isRegexp {
+			p, err = regexp.Compile(fmt.Sprint(v))
+		} else {
+			p, err = globPattern(prefix + fmt.Sprint(v) + suffix)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of field %q; %w", parts[0], err)
+		}
+		f.patterns = append(f.patterns, p)
+	}
+	return f, nil
+}
+
+// globPattern converts Sigma value with `*` and `?` wildcards to a
+// case-insensitive regexp. Wildcards could be escaped with `\`.
+func globPattern(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '\\':
+			if i+1 < len(glob) && (glob[i+1] == '*' || glob[i+1] == '?' || glob[i+1] == '\\') {
+				i++
+				c = glob[i]
+			}
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+//
+// Conditions.
+//
+
+// compileCondition compiles the condition @src referencing @selections.
+func compileCondition(src string, selections map[string]matcher) (matcher, error) {
+	if strings.Contains(src, "|") {
+		return nil, fmt.Errorf("aggregation expressions are not supported: %q", src)
+	}
+	p := conditionParser{
+		tokens:     strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(src)),
+		selections: selections,
+	}
+	m, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition %q; %w", src, err)
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("invalid condition %q; unexpected %q", src, p.tokens[p.pos])
+	}
+	return m, nil
+}
+
+type conditionParser struct {
+	tokens     []string
+	pos        int
+	selections map[string]matcher
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) next() string {
+	tok := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *conditionParser) parseOr() (matcher, error) {
+	m, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	or := orMatcher{m}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		m, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, m)
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (p *conditionParser) parseAnd() (matcher, error) {
+	m, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	and := andMatcher{m}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		m, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, m)
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (p *conditionParser) parseNot() (matcher, error) {
+	if strings.EqualFold(p.peek(), "not") {
+		p.next()
+		m, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notMatcher{m}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *conditionParser) parsePrimary() (matcher, error) {
+	tok := p.next()
+	switch strings.ToLower(tok) {
+	case "":
+		return nil, fmt.Errorf("unexpected end of condition")
+
+	case "(":
+		m, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return m, nil
+
+	case "1", "any", "all":
+		if !strings.EqualFold(p.next(), "of") {
+			return nil, fmt.Errorf("expected `of` after %q", tok)
+		}
+		ms, err := p.selectionsByPattern(p.next())
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(tok, "all") {
+			return andMatcher(ms), nil
+		}
+		return orMatcher(ms), nil
+	}
+
+	m, ok := p.selections[tok]
+	if !ok {
+		return nil, fmt.Errorf("unknown selection %q", tok)
+	}
+	return m, nil
+}
+
+// selectionsByPattern returns selections matching a glob @pattern, `them`
+// stands for all selections except ones prefixed with `_` as Sigma
+// specifies.
+func (p *conditionParser) selectionsByPattern(pattern string) ([]matcher, error) {
+	them := strings.EqualFold(pattern, "them")
+	if them {
+		pattern = "*"
+	}
+	names := make([]string, 0, len(p.selections))
+	for name := range p.selections {
+		if them && strings.HasPrefix(name, "_") {
+			continue
+		}
+		if ok, err := path.Match(pattern, name); err != nil {
+			return nil, fmt.Errorf("invalid selection pattern %q; %w", pattern, err)
+		} else if ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no selections match %q", pattern)
+	}
+	sort.Strings(names)
+
+	ms := make([]matcher, 0, len(names))
+	for _, name := range names {
+		ms = append(ms, p.selections[name])
+	}
+	return ms, nil
+}
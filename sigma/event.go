@@ -0,0 +1,35 @@
+//+build windows
+
+package sigma
+
+import (
+	"github.com/bi-zone/etw"
+)
+
+// MatchEvent parses properties of @e and evaluates the rules against them.
+// Like etw.Event.EventProperties it's valid only inside etw.EventCallback.
+func (m *Matcher) MatchEvent(e *etw.Event, options ...etw.ParseOption) ([]Detection, error) {
+	props, err := e.EventProperties(options...)
+	if err != nil {
+		return nil, err
+	}
+	return m.Match(e.Header.ProviderID.String(), e.Header.ID, props), nil
+}
+
+// EventCallback returns an etw.EventCallback that passes every detection to
+// @onDetection. Event properties parsing errors are passed to @onError if
+// it's not nil.
+func (m *Matcher) EventCallback(onDetection func(e *etw.Event, d Detection), onError func(err error)) etw.EventCallback {
+	return func(e *etw.Event) {
+		detections, err := m.MatchEvent(e)
+		if err != nil {
+			if onError != nil {
+				onError(err)
+			}
+			return
+		}
+		for _, d := range detections {
+			onDetection(e, d)
+		}
+	}
+}
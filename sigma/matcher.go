@@ -0,0 +1,217 @@
+package sigma
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Mapping binds a Sigma log source to ETW events.
+type Mapping struct {
+	// LogSource is matched against rule log sources. Rule applies to the
+	// mapping if every log source field set in the rule is equal to the
+	// mapping one.
+	LogSource LogSource
+
+	// Provider is a GUID string of the events provider. Braces and case are
+	// ignored. Empty Provider matches any provider.
+	Provider string
+
+	// EventIDs limits mapped events. Empty EventIDs matches any event.
+	EventIDs []uint16
+
+	// Fields translates Sigma field names to event property names. Fields of
+	// nested structures are addressed with dots, e.g. `struct.field`.
+	// Fields absent in the map are looked up by their Sigma names.
+	Fields map[string]string
+}
+
+func (m Mapping) matches(provider string, eventID uint16) bool {
+	if m.Provider != "" && normalizeGUID(m.Provider) != provider {
+		return false
+	}
+	if len(m.EventIDs) == 0 {
+		return true
+	}
+	for _, id := range m.EventIDs {
+		if id == eventID {
+			return true
+		}
+	}
+	return false
+}
+
+// Detection is a single rule match.
+type Detection struct {
+	Rule *Rule
+}
+
+// Matcher evaluates a set of compiled rules. Matcher is immutable and safe
+// for concurrent use.
+type Matcher struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	rule      *Rule
+	condition matcher
+	mappings  []Mapping
+}
+
+// NewMatcher compiles @rules. Every rule is bound to the @mappings its log
+// source covers, rules not covered by any mapping are skipped. If no
+// @mappings are given all rules are applied to all events with original
+// field names.
+func NewMatcher(rules []*Rule, mappings ...Mapping) (*Matcher, error) {
+	m := &Matcher{}
+	for _, r := range rules {
+		cr := compiledRule{rule: r}
+		if len(mappings) == 0 {
+			cr.mappings = []Mapping{{}}
+		}
+		for _, mapping := range mappings {
+			if r.LogSource.covers(mapping.LogSource) {
+				cr.mappings = append(cr.mappings, mapping)
+			}
+		}
+		if len(cr.mappings) == 0 {
+			continue
+		}
+
+		var err error
+		if cr.condition, err = compileDetection(r.Detection); err != nil {
+			return nil, fmt.Errorf("failed to compile rule %q; %w", r.Title, err)
+		}
+		m.rules = append(m.rules, cr)
+	}
+	return m, nil
+}
+
+// Len returns a number of rules bound to at least one mapping.
+func (m *Matcher) Len() int {
+	return len(m.rules)
+}
+
+// Match evaluates the rules against the event of @provider (GUID string)
+// with @eventID and parsed properties @props. Returns detections in the
+// order of rules passed to NewMatcher.
+func (m *Matcher) Match(provider string, eventID uint16, props map[string]interface{}) []Detection {
+	provider = normalizeGUID(provider)
+
+	var (
+		detections []Detection
+		values     []string // Lazily collected for keyword searches.
+	)
+	for _, cr := range m.rules {
+		for _, mapping := range cr.mappings {
+			if !mapping.matches(provider, eventID) {
+				continue
+			}
+			if values == nil {
+				values = collectValues(props, nil)
+			}
+			get := func(field string) []interface{} {
+				if name, ok := mapping.Fields[field]; ok {
+					field = name
+				}
+				return lookup(props, field)
+			}
+			if cr.condition.match(get, values) {
+				detections = append(detections, Detection{Rule: cr.rule})
+				break
+			}
+		}
+	}
+	return detections
+}
+
+// compileDetection compiles the detection section: named selections and the
+// condition (or a list of conditions which are OR-ed).
+func compileDetection(detection map[string]interface{}) (matcher, error) {
+	selections := make(map[string]matcher, len(detection))
+	var conditions []string
+	for name, v := range detection {
+		switch name {
+		case "condition":
+			switch c := v.(type) {
+			case string:
+				conditions = append(conditions, c)
+			case []interface{}:
+				for _, item := range c {
+					conditions = append(conditions, fmt.Sprint(item))
+				}
+			default:
+				return nil, fmt.Errorf("unsupported condition type %T", v)
+			}
+		case "timeframe":
+			// Used by aggregations only.
+		default:
+			s, err := compileSelection(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid selection %q; %w", name, err)
+			}
+			selections[name] = s
+		}
+	}
+	if len(conditions) == 0 {
+		return nil, fmt.Errorf("no condition")
+	}
+
+	var or orMatcher
+	for _, c := range conditions {
+		m, err := compileCondition(c, selections)
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, m)
+	}
+	return or, nil
+}
+
+// lookup returns values of the (possibly nested) field @name of @props.
+// Arrays are flattened.
+func lookup(props map[string]interface{}, name string) []interface{} {
+	var cur interface{} = props
+	for _, part := range strings.Split(name, ".") {
+		container, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if cur, ok = container[part]; !ok {
+			return nil
+		}
+	}
+	if arr, ok := cur.([]interface{}); ok {
+		return arr
+	}
+	return []interface{}{cur}
+}
+
+// collectValues returns all string representations of @v leaves.
+func collectValues(v interface{}, acc []string) []string {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			acc = collectValues(x[k], acc)
+		}
+	case []interface{}:
+		for _, item := range x {
+			acc = collectValues(item, acc)
+		}
+	default:
+		acc = append(acc, fmt.Sprint(x))
+	}
+	if acc == nil {
+		acc = []string{}
+	}
+	return acc
+}
+
+func normalizeGUID(s string) string {
+	return strings.ToLower(strings.Trim(s, "{}"))
+}
@@ -0,0 +1,119 @@
+// Package sigma evaluates Sigma detection rules (https://github.com/SigmaHQ/sigma)
+// against parsed ETW events.
+//
+// Sigma rules describe log sources in abstract terms (product, category,
+// service) and reference fields by generic names. A Mapping binds a log
+// source to ETW provider and event IDs and translates field names to the
+// event property names:
+//
+//		rules, err := sigma.LoadDir("rules/windows/process_creation")
+//		...
+//		m, err := sigma.NewMatcher(rules, sigma.Mapping{
+//			LogSource: sigma.LogSource{Product: "windows", Category: "process_creation"},
+//			Provider:  "{22fb2cd6-0e7b-422b-a0c7-2fad1fd0e716}", // Microsoft-Windows-Kernel-Process
+//			EventIDs:  []uint16{1},
+//			Fields:    map[string]string{"Image": "ImageName"},
+//		})
+//		...
+//		for _, d := range m.Match(provider, eventID, props) {
+//			log.Printf("%s: %s", d.Rule.Level, d.Rule.Title)
+//		}
+//
+// Supported are selections (maps and lists of maps), keyword lists, field
+// modifiers `contains`, `startswith`, `endswith`, `all` and `re`, wildcards
+// and conditions with `and`, `or`, `not`, parentheses, `1 of` and `all of`.
+// Deprecated aggregation expressions (`| count() > N`) are not supported.
+package sigma
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Rule is a parsed Sigma rule. Metadata fields are kept as is to be reported
+// with detections.
+type Rule struct {
+	ID          string    `yaml:"id"`
+	Title       string    `yaml:"title"`
+	Description string    `yaml:"description"`
+	Status      string    `yaml:"status"`
+	Level       string    `yaml:"level"`
+	Author      string    `yaml:"author"`
+	References  []string  `yaml:"references"`
+	Tags        []string  `yaml:"tags"`
+	LogSource   LogSource `yaml:"logsource"`
+
+	// Detection is a raw detection section: named selections and the
+	// condition. It's compiled by NewMatcher.
+	Detection map[string]interface{} `yaml:"detection"`
+}
+
+// LogSource describes events the rule is applicable to.
+type LogSource struct {
+	Product  string `yaml:"product"`
+	Category string `yaml:"category"`
+	Service  string `yaml:"service"`
+}
+
+// covers returns true if every field set in @s is equal to the same field
+// of @other.
+func (s LogSource) covers(other LogSource) bool {
+	eq := func(a, b string) bool {
+		return a == "" || strings.EqualFold(a, b)
+	}
+	return eq(s.Product, other.Product) && eq(s.Category, other.Category) && eq(s.Service, other.Service)
+}
+
+// ParseRule parses a single Sigma rule from YAML @data.
+func ParseRule(data []byte) (*Rule, error) {
+	var r Rule
+	if err := yaml.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse rule; %w", err)
+	}
+	if r.Detection == nil {
+		return nil, fmt.Errorf("rule %q has no detection section", r.Title)
+	}
+	return &r, nil
+}
+
+// LoadFile reads a Sigma rule from the file at @path.
+func LoadFile(path string) (*Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule; %w", err)
+	}
+	r, err := ParseRule(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// LoadDir reads all *.yml and *.yaml Sigma rules from @dir recursively.
+func LoadDir(dir string) ([]*Rule, error) {
+	var rules []*Rule
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if info.IsDir() || (ext != ".yml" && ext != ".yaml") {
+			return nil
+		}
+		r, err := LoadFile(path)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
@@ -0,0 +1,217 @@
+package sigma_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/sigma"
+)
+
+const (
+	kernelProcess = "{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}"
+
+	whoamiRule = `
+title: Whoami Execution
+id: e28a5a99-da44-436d-b7a0-2afc20a5f413
+level: medium
+tags:
+    - attack.discovery
+logsource:
+    category: process_creation
+    product: windows
+detection:
+    selection:
+        Image|endswith: '\whoami.exe'
+    filter:
+        ParentImage|contains:
+            - 'explorer'
+            - 'Trusted*Installer'
+    condition: selection and not filter
+`
+	keywordsRule = `
+title: Suspicious Keywords
+logsource:
+    product: windows
+detection:
+    keywords:
+        - 'mimikatz'
+        - 'sekurlsa::*'
+    condition: keywords
+`
+	ofRule = `
+title: Encoded PowerShell
+logsource:
+    category: process_creation
+    product: windows
+detection:
+    selection_image:
+        Image|endswith: '\powershell.exe'
+    selection_args:
+        - CommandLine|contains|all:
+            - ' -enc'
+            - ' -nop'
+        - CommandLine|re: '(?i)FromBase64String'
+    condition: all of selection_*
+`
+)
+
+func newMatcher(t *testing.T) *sigma.Matcher {
+	var rules []*sigma.Rule
+	for _, src := range []string{whoamiRule, keywordsRule, ofRule} {
+		r, err := sigma.ParseRule([]byte(src))
+		require.NoError(t, err, "Failed to parse rule")
+		rules = append(rules, r)
+	}
+
+	m, err := sigma.NewMatcher(rules, sigma.Mapping{
+		LogSource: sigma.LogSource{Product: "windows", Category: "process_creation"},
+		Provider:  kernelProcess,
+		EventIDs:  []uint16{1},
+		Fields: map[string]string{
+			"Image":       "ImageName",
+			"ParentImage": "Parent.ImageName",
+		},
+	})
+	require.NoError(t, err, "Failed to compile rules")
+	require.Equal(t, 3, m.Len(), "Unexpected number of rules bound to the mapping")
+	return m
+}
+
+func titles(detections []sigma.Detection) []string {
+	var res []string
+	for _, d := range detections {
+		res = append(res, d.Rule.Title)
+	}
+	return res
+}
+
+func TestMatch(t *testing.T) {
+	m := newMatcher(t)
+
+	tests := []struct {
+		name     string
+		eventID  uint16
+		props    map[string]interface{}
+		expected []string
+	}{
+		{
+			name:    "whoami",
+			eventID: 1,
+			props: map[string]interface{}{
+				"ImageName": `C:\Windows\System32\WHOAMI.EXE`,
+				"Parent":    map[string]interface{}{"ImageName": `C:\Windows\System32\cmd.exe`},
+			},
+			expected: []string{"Whoami Execution"},
+		},
+		{
+			name:    "whoami filtered",
+			eventID: 1,
+			props: map[string]interface{}{
+				"ImageName": `C:\Windows\System32\whoami.exe`,
+				"Parent":    map[string]interface{}{"ImageName": `C:\Windows\Servicing\TrustedInstaller.exe`},
+			},
+		},
+		{
+			name:    "other event ID",
+			eventID: 2,
+			props: map[string]interface{}{
+				"ImageName": `C:\Windows\System32\whoami.exe`,
+			},
+		},
+		{
+			name:    "keywords and all of",
+			eventID: 1,
+			props: map[string]interface{}{
+				"ImageName":   `C:\Windows\powershell.exe`,
+				"CommandLine": `powershell.exe -nop -w hidden -enc AAAA; mimikatz`,
+			},
+			expected: []string{"Suspicious Keywords", "Encoded PowerShell"},
+		},
+		{
+			name:    "all modifier",
+			eventID: 1,
+			props: map[string]interface{}{
+				"ImageName":   `C:\Windows\powershell.exe`,
+				"CommandLine": `powershell.exe -enc AAAA`,
+			},
+		},
+		{
+			name:    "regexp",
+			eventID: 1,
+			props: map[string]interface{}{
+				"ImageName":   `C:\Windows\powershell.exe`,
+				"CommandLine": `[Convert]::frombase64string("AAAA")`,
+			},
+			expected: []string{"Encoded PowerShell"},
+		},
+	}
+	for _, tt := range tests {
+		detections := m.Match("22fb2cd6-0e7b-422b-a0c7-2fad1fd0e716", tt.eventID, tt.props)
+		assert.Equal(t, tt.expected, titles(detections), "Unexpected detections for %q", tt.name)
+	}
+}
+
+func TestThem(t *testing.T) {
+	r, err := sigma.ParseRule([]byte(`
+title: Them
+detection:
+    selection:
+        Image|endswith: '\whoami.exe'
+    _helper:
+        Image|contains: 'System32'
+    condition: all of them
+`))
+	require.NoError(t, err, "Failed to parse rule")
+	m, err := sigma.NewMatcher([]*sigma.Rule{r})
+	require.NoError(t, err, "Failed to compile rule")
+
+	// `them` skips `_`-prefixed selections, so the helper one doesn't have
+	// to match.
+	detections := m.Match(kernelProcess, 1, map[string]interface{}{"Image": `C:\Tools\whoami.exe`})
+	assert.Equal(t, []string{"Them"}, titles(detections), "Helper selection is matched by them")
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, detection := range []string{
+		"selection: {a: b}",
+		"selection: {a: b}\n    condition: selection and",
+		"selection: {a: b}\n    condition: unknown",
+		"selection: {a: b}\n    condition: (selection",
+		"selection: {a: b}\n    condition: selection | count() > 5",
+		"selection: {a|base64offset: b}\n    condition: selection",
+		"selection: {a|re: '['}\n    condition: selection",
+		"selection: {a: b}\n    condition: 1 of filter*",
+	} {
+		r, err := sigma.ParseRule([]byte("title: test\ndetection:\n    " + detection))
+		require.NoError(t, err, "Failed to parse rule %q", detection)
+		_, err = sigma.NewMatcher([]*sigma.Rule{r})
+		assert.Error(t, err, "Expected an error compiling %q", detection)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sigma")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "nested"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "whoami.yml"), []byte(whoamiRule), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "nested", "keywords.yaml"), []byte(keywordsRule), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("# rules"), 0644))
+
+	rules, err := sigma.LoadDir(dir)
+	require.NoError(t, err, "Failed to load rules")
+	require.Len(t, rules, 2)
+	assert.Equal(t, "Suspicious Keywords", rules[0].Title)
+	assert.Equal(t, "Whoami Execution", rules[1].Title)
+	assert.Equal(t, []string{"attack.discovery"}, rules[1].Tags)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.yml"), []byte("title: [broken"), 0644))
+	_, err = sigma.LoadDir(dir)
+	assert.Error(t, err, "Expected an error loading a broken rule")
+}
@@ -0,0 +1,31 @@
+//+build windows
+
+package forward
+
+import (
+	"context"
+
+	"github.com/bi-zone/etw"
+	"github.com/bi-zone/etw/sinks/jsonl"
+)
+
+// EventCallback returns an etw.EventCallback that sends every received event
+// as a jsonl.Record. Write errors are passed to @onError if it's not nil.
+func (f *Forwarder) EventCallback(onError func(err error)) etw.EventCallback {
+	return func(e *etw.Event) {
+		if err := f.Write(jsonl.NewRecord(e)); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// ContextEventCallback is the same as EventCallback, but a blocked write
+// gives up as soon as the context passed to etw.Session.ProcessContext is
+// done.
+func (f *Forwarder) ContextEventCallback(onError func(err error)) etw.ContextEventCallback {
+	return func(ctx context.Context, e *etw.Event) {
+		if err := f.WriteContext(ctx, jsonl.NewRecord(e)); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+// Package forward implements a sink that ships events to a remote collector
+// as newline-delimited JSON over TCP or TLS.
+//
+// Forwarder buffers events in memory while the collector is unreachable and
+// reconnects automatically. Having the buffer full Forwarder either blocks
+// the writer (backpressure, default) or drops events.
+//
+//		f := forward.New("collector:6514", forward.WithTLS(&tls.Config{ServerName: "collector"}))
+//		defer f.Close(10 * time.Second)
+//		err = session.Process(f.EventCallback(nil))
+//
+package forward
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned by Write after Close has been called.
+var ErrClosed = errors.New("forwarder is closed")
+
+// Options describes Forwarder connection and buffering settings.
+type Options struct {
+	// TLSConfig enables TLS for the collector connection. Nil TLSConfig means
+	// plain TCP.
+	TLSConfig *tls.Config
+
+	// BufferSize is a maximum number of events waiting to be sent.
+	BufferSize int
+
+	// DropOnFull makes Write drop events instead of blocking when the buffer
+	// is full.
+	DropOnFull bool
+
+	// DialTimeout limits a single connection attempt.
+	DialTimeout time.Duration
+
+	// WriteTimeout limits a single write to the collector. A stalled
+	// collector is reconnected to after it.
+	WriteTimeout time.Duration
+
+	// ReconnectMin and ReconnectMax bound exponential delays between
+	// connection attempts.
+	ReconnectMin time.Duration
+	ReconnectMax time.Duration
+}
+
+// Option is any function that modifies Options.
+type Option func(cfg *Options)
+
+// WithTLS makes Forwarder connect to the collector using TLS with @cfg.
+func WithTLS(cfg *tls.Config) Option {
+	return func(opts *Options) {
+		opts.TLSConfig = cfg
+	}
+}
+
+// WithBufferSize sets the maximum number of events waiting to be sent.
+func WithBufferSize(size int) Option {
+	return func(opts *Options) {
+		opts.BufferSize = size
+	}
+}
+
+// WithDropOnFull makes Write drop events instead of blocking when the buffer
+// is full. Number of dropped events is available via Dropped.
+func WithDropOnFull() Option {
+	return func(opts *Options) {
+		opts.DropOnFull = true
+	}
+}
+
+// WithWriteTimeout limits a single write to the collector with @timeout.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.WriteTimeout = timeout
+	}
+}
+
+// WithReconnect sets bounds of exponential delays between connection
+// attempts.
+func WithReconnect(min, max time.Duration) Option {
+	return func(opts *Options) {
+		opts.ReconnectMin = min
+		opts.ReconnectMax = max
+	}
+}
+
+// Forwarder sends JSON lines to the remote collector. Forwarder is safe for
+// concurrent use.
+type Forwarder struct {
+	addr string
+	cfg  Options
+
+	queue   chan []byte
+	closing chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	dropped uint64
+
+	// conn is the current collector connection. It's closed by `.Close` on
+	// timeout (and aborted is set, abort is closed) to unblock a write to a
+	// stalled collector or stop reconnecting to an unreachable one.
+	connMu  sync.Mutex
+	conn    net.Conn
+	aborted bool
+	abort   chan struct{}
+
+	// unsent is a number of lines left by the sender, it's set before done
+	// is closed.
+	unsent int
+}
+
+// New creates a Forwarder sending events to the collector at @addr. The
+// connection is established in background, so New never fails.
+func New(addr string, options ...Option) *Forwarder {
+	cfg := Options{
+		BufferSize:   10000,
+		DialTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		ReconnectMin: 100 * time.Millisecond,
+		ReconnectMax: 30 * time.Second,
+	}
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	f := &Forwarder{
+		addr:    addr,
+		cfg:     cfg,
+		queue:   make(chan []byte, cfg.BufferSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		abort:   make(chan struct{}),
+	}
+	go f.run()
+	return f
+}
+
+// Write encodes @v as a JSON line and queues it for sending.
+func (f *Forwarder) Write(v interface{}) error {
+	return f.WriteContext(context.Background(), v)
+}
+
+// WriteContext is the same as Write, but it gives up waiting for the queue
+// space as soon as @ctx is done returning its error.
+func (f *Forwarder) WriteContext(ctx context.Context, v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode value; %w", err)
+	}
+	line = append(line, '\n')
+
+	select {
+	case <-f.closing:
+		return ErrClosed
+	default:
+	}
+
+	if f.cfg.DropOnFull {
+		select {
+		case f.queue <- line:
+		default:
+			atomic.AddUint64(&f.dropped, 1)
+		}
+		return nil
+	}
+	select {
+	case f.queue <- line:
+		return nil
+	case <-f.closing:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Dropped returns a number of events dropped due to the full buffer.
+func (f *Forwarder) Dropped() uint64 {
+	return atomic.LoadUint64(&f.dropped)
+}
+
+// Close stops accepting new events and waits until already queued ones are
+// sent or @timeout expires. An unreachable collector is reconnected to until
+// then. On timeout the connection is closed and events left are dropped,
+// Close returns an error if any are.
+func (f *Forwarder) Close(timeout time.Duration) error {
+	f.once.Do(func() { close(f.closing) })
+	select {
+	case <-f.done:
+	case <-time.After(timeout):
+		f.connMu.Lock()
+		if !f.aborted {
+			f.aborted = true
+			close(f.abort)
+		}
+		if f.conn != nil {
+			_ = f.conn.Close()
+		}
+		f.connMu.Unlock()
+		// The sender returns as soon as the pending write or dial fails.
+		<-f.done
+	}
+	if f.unsent != 0 {
+		return fmt.Errorf("%d events are not sent in %s", f.unsent, timeout)
+	}
+	return nil
+}
+
+// setConn makes @conn the current connection. Returns false if Close has
+// given up waiting meanwhile.
+func (f *Forwarder) setConn(conn net.Conn) bool {
+	f.connMu.Lock()
+	defer f.connMu.Unlock()
+	f.conn = conn
+	return !f.aborted
+}
+
+// isAborted returns true if Close has given up waiting for the queue to
+// drain.
+func (f *Forwarder) isAborted() bool {
+	f.connMu.Lock()
+	defer f.connMu.Unlock()
+	return f.aborted
+}
+
+// run sends queued lines reconnecting on failures until the Forwarder is
+// closed and the queue is drained or `.Close` gives up waiting for it.
+func (f *Forwarder) run() {
+	defer close(f.done)
+
+	var (
+		conn    net.Conn
+		w       *bufio.Writer
+		pending []byte
+	)
+	defer func() {
+		if pending != nil {
+			f.unsent = len(f.queue) + 1
+		}
+	}()
+	defer func() {
+		if conn != nil {
+			_ = conn.SetWriteDeadline(time.Now().Add(f.cfg.WriteTimeout))
+			_ = w.Flush()
+			_ = conn.Close()
+		}
+	}()
+
+	for {
+		if pending == nil {
+			select {
+			case pending = <-f.queue:
+			case <-f.closing:
+				select {
+				case pending = <-f.queue:
+				default:
+					return // Closed and drained.
+				}
+			}
+		}
+
+		if conn == nil {
+			var ok bool
+			if conn, ok = f.connect(); !ok {
+				return // Aborted while the collector is unreachable.
+			}
+			if !f.setConn(conn) {
+				_ = conn.Close()
+				conn = nil
+				return
+			}
+			w = bufio.NewWriter(conn)
+		}
+
+		// The buffered writer writes to the connection either when it's
+		// full or on Flush, so the deadline covers both.
+		err := conn.SetWriteDeadline(time.Now().Add(f.cfg.WriteTimeout))
+		if err == nil {
+			_, err = w.Write(pending)
+		}
+		if err == nil && len(f.queue) == 0 {
+			err = w.Flush() // Flush only when there is nothing more to batch.
+		}
+		if err != nil {
+			// Resend the line using a fresh connection. Lines buffered in the
+			// broken one could be lost, collectors should tolerate that.
+			_ = conn.Close()
+			conn = nil
+			if f.isAborted() {
+				return
+			}
+			continue
+		}
+		pending = nil
+	}
+}
+
+// connect dials the collector until success. Returns false if `.Close` gives
+// up waiting meanwhile.
+func (f *Forwarder) connect() (net.Conn, bool) {
+	delay := f.cfg.ReconnectMin
+	for {
+		dialer := &net.Dialer{Timeout: f.cfg.DialTimeout}
+		var (
+			conn net.Conn
+			err  error
+		)
+		if f.cfg.TLSConfig != nil {
+			conn, err = tls.DialWithDialer(dialer, "tcp", f.addr, f.cfg.TLSConfig)
+		} else {
+			conn, err = dialer.Dial("tcp", f.addr)
+		}
+		if err == nil {
+			return conn, true
+		}
+
+		select {
+		case <-f.abort:
+			return nil, false
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > f.cfg.ReconnectMax {
+			delay = f.cfg.ReconnectMax
+		}
+	}
+}
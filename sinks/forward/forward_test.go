@@ -0,0 +1,141 @@
+package forward_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/sinks/forward"
+)
+
+// TestReconnect ensures that events written while the collector is down are
+// delivered after it comes up.
+func TestReconnect(t *testing.T) {
+	// Reserve an address and free it to emulate the collector being down.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen")
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	f := forward.New(addr, forward.WithReconnect(10*time.Millisecond, 50*time.Millisecond))
+	const lines = 100
+	for i := 0; i < lines; i++ {
+		require.NoError(t, f.Write(map[string]int{"sequence": i}), "Failed to queue event")
+	}
+
+	l, err = net.Listen("tcp", addr)
+	require.NoError(t, err, "Failed to listen again")
+	defer l.Close()
+
+	received := make(chan int)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		count := 0
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			count++
+		}
+		received <- count
+	}()
+
+	require.NoError(t, f.Close(5*time.Second), "Failed to flush events")
+	select {
+	case count := <-received:
+		assert.Equal(t, lines, count, "Unexpected number of delivered events")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Collector got nothing")
+	}
+
+	assert.Equal(t, forward.ErrClosed, f.Write("late"), "Write should fail after Close")
+}
+
+// TestWriteContext ensures that a blocked write gives up on the context cancellation.
+func TestWriteContext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen")
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	f := forward.New(addr, forward.WithBufferSize(1), forward.WithReconnect(time.Hour, time.Hour))
+	defer f.Close(0) //nolint:errcheck
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var errs []error
+	for i := 0; i < 3; i++ {
+		errs = append(errs, f.WriteContext(ctx, i))
+	}
+	assert.Contains(t, errs, context.DeadlineExceeded, "Blocked write doesn't honor the context")
+}
+
+// TestStalledCollector ensures that Close doesn't leave the sender blocked
+// on a collector that stopped reading.
+func TestStalledCollector(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen")
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			accepted <- conn // Never read to fill the socket buffers.
+		}
+	}()
+
+	f := forward.New(l.Addr().String(), forward.WithWriteTimeout(time.Hour))
+	line := strings.Repeat("x", 64<<10)
+	for i := 0; i < 256; i++ {
+		require.NoError(t, f.Write(line), "Failed to queue event")
+	}
+	var conn net.Conn
+	select {
+	case conn = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Forwarder didn't connect")
+	}
+	defer conn.Close()
+
+	started := time.Now()
+	assert.Error(t, f.Close(100*time.Millisecond), "Unsent events are not reported")
+	assert.True(t, time.Since(started) < 5*time.Second, "Close is blocked by the stalled collector")
+
+	// The forwarder closed its side, so draining the data ends with EOF.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = io.Copy(ioutil.Discard, conn)
+	assert.NoError(t, err, "Connection is not closed by the forwarder")
+}
+
+// TestUnreachableCollector ensures that Close keeps reconnecting until the
+// timeout and reports events left unsent.
+func TestUnreachableCollector(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen")
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	f := forward.New(addr, forward.WithReconnect(10*time.Millisecond, 10*time.Millisecond))
+	const lines = 5
+	for i := 0; i < lines; i++ {
+		require.NoError(t, f.Write(map[string]int{"sequence": i}), "Failed to queue event")
+	}
+
+	const timeout = 200 * time.Millisecond
+	started := time.Now()
+	err = f.Close(timeout)
+	require.Error(t, err, "Unsent events are not reported")
+	assert.Contains(t, err.Error(), "5 events", "Unexpected number of unsent events")
+	assert.True(t, time.Since(started) >= timeout, "Close gave up reconnecting before the timeout")
+}
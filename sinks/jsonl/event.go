@@ -0,0 +1,60 @@
+//+build windows
+
+package jsonl
+
+import (
+	"context"
+
+	"github.com/bi-zone/etw"
+)
+
+// Record is a JSON representation of an event written by EventCallback.
+//
+// Keywords holds labels of the event keyword bits registered with
+// etw.RegisterKeywords, so consumers could query events by keyword names.
+type Record struct {
+	Header     etw.EventHeader
+	Keywords   []string               `json:",omitempty"`
+	Properties map[string]interface{} `json:",omitempty"`
+	Error      string                 `json:",omitempty"`
+}
+
+// EventCallback returns an etw.EventCallback that writes every received event
+// as a Record. Write errors are passed to @onError if it's not nil.
+//
+// Event properties parsing errors are not reported to @onError, but stored
+// in the Record instead.
+func (w *Writer) EventCallback(onError func(err error)) etw.EventCallback {
+	return func(e *etw.Event) {
+		if err := w.Write(NewRecord(e)); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// ContextEventCallback is the same as EventCallback, but events received
+// after the context passed to etw.Session.ProcessContext is done are not
+// written, the context error is passed to @onError instead.
+func (w *Writer) ContextEventCallback(onError func(err error)) etw.ContextEventCallback {
+	return func(ctx context.Context, e *etw.Event) {
+		err := ctx.Err()
+		if err == nil {
+			err = w.Write(NewRecord(e))
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// NewRecord converts @e to Record parsing its properties with @options.
+// Parsing errors are stored in the Record.
+func NewRecord(e *etw.Event, options ...etw.ParseOption) Record {
+	r := Record{Header: e.Header, Keywords: e.Header.Keywords()}
+	if props, err := e.EventProperties(options...); err == nil {
+		r.Properties = props
+	} else {
+		r.Error = err.Error()
+	}
+	return r
+}
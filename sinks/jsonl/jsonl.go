@@ -0,0 +1,245 @@
+// Package jsonl implements a sink that writes events as JSON lines to a file
+// with size and time based rotation and optional gzip compression of rotated
+// files.
+//
+// The simplest usage is to pass a Writer-produced callback to the session:
+//
+//		w, err := jsonl.New("events.jsonl", jsonl.WithMaxSize(100<<20), jsonl.WithCompression())
+//		...
+//		err = session.Process(w.EventCallback(nil))
+//
+package jsonl
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options describes rotation and compression settings of the Writer.
+type Options struct {
+	// MaxSize is a maximum size of a single file in bytes. Having the limit
+	// reached Writer rotates the file. Zero means no size limit.
+	MaxSize int64
+
+	// MaxAge is a maximum time a single file is written. Having the limit
+	// reached Writer rotates the file. Zero means no time limit.
+	MaxAge time.Duration
+
+	// Compress makes Writer gzip rotated files.
+	Compress bool
+}
+
+// Option is any function that modifies Options.
+type Option func(cfg *Options)
+
+// WithMaxSize makes Writer rotate the file after @bytes written.
+func WithMaxSize(bytes int64) Option {
+	return func(cfg *Options) {
+		cfg.MaxSize = bytes
+	}
+}
+
+// WithMaxAge makes Writer rotate the file every @d.
+func WithMaxAge(d time.Duration) Option {
+	return func(cfg *Options) {
+		cfg.MaxAge = d
+	}
+}
+
+// WithCompression makes Writer gzip rotated files.
+func WithCompression() Option {
+	return func(cfg *Options) {
+		cfg.Compress = true
+	}
+}
+
+// Writer writes values as JSON lines to the file. Rotated files are renamed
+// to `<name>-<timestamp><ext>` (with `.gz` suffix if compressed) next to the
+// original file.
+//
+// Writer is safe for concurrent use.
+type Writer struct {
+	path string
+	cfg  Options
+
+	mu      sync.Mutex
+	file    *os.File
+	buf     *bufio.Writer
+	size    int64
+	created time.Time
+
+	// rename renames rotated files, it's replaced by tests.
+	rename func(oldpath, newpath string) error
+}
+
+// New creates a Writer appending to the file located at @path.
+func New(path string, options ...Option) (*Writer, error) {
+	var cfg Options
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	w := &Writer{path: path, cfg: cfg, rename: os.Rename}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write encodes @v as a single JSON line rotating the file if needed. If
+// the rotation fails the line is still written, to the original file or a
+// fresh one, and the rotation error is returned; the rotation is retried on
+// the next Write.
+func (w *Writer) Write(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode value; %w", err)
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return fmt.Errorf("writer is closed")
+	}
+	var rotateErr error
+	if w.needRotation(int64(len(line))) {
+		rotateErr = w.rotate()
+		if w.file == nil {
+			return rotateErr
+		}
+	}
+	n, err := w.buf.Write(line)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write line; %w", err)
+	}
+	return rotateErr
+}
+
+// Flush writes buffered lines to the file.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	return w.buf.Flush()
+}
+
+// Close flushes buffered lines and closes the file. The current file is not
+// rotated.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.close()
+}
+
+// needRotation returns true if writing @n more bytes exceeds the limits.
+// Empty files are never rotated.
+func (w *Writer) needRotation(n int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.cfg.MaxSize > 0 && w.size+n > w.cfg.MaxSize {
+		return true
+	}
+	if w.cfg.MaxAge > 0 && time.Since(w.created) >= w.cfg.MaxAge {
+		return true
+	}
+	return false
+}
+
+// rotate renames the current file, compresses it if requested and opens a
+// fresh one. The Writer is reopened even if the rotation fails: the
+// original file if it hasn't been renamed, a fresh one otherwise. So it
+// stays closed only if reopening fails too.
+func (w *Writer) rotate() error {
+	err := w.close()
+	if err == nil {
+		ext := filepath.Ext(w.path)
+		rotated := fmt.Sprintf("%s-%s%s",
+			strings.TrimSuffix(w.path, ext), time.Now().Format("20060102T150405.000000000"), ext)
+		if renameErr := w.rename(w.path, rotated); renameErr != nil {
+			err = fmt.Errorf("failed to rename rotated file; %w", renameErr)
+		} else if w.cfg.Compress {
+			err = compress(rotated)
+		}
+	}
+	if openErr := w.open(); openErr != nil {
+		if err != nil {
+			return fmt.Errorf("%s; failed to reopen file; %w", err, openErr)
+		}
+		return openErr
+	}
+	return err
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open file; %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to stat file; %w", err)
+	}
+	w.file = f
+	w.buf = bufio.NewWriter(f)
+	w.size = info.Size()
+	w.created = time.Now()
+	return nil
+}
+
+func (w *Writer) close() error {
+	if w.file == nil {
+		return nil
+	}
+	flushErr := w.buf.Flush()
+	closeErr := w.file.Close()
+	w.file, w.buf = nil, nil
+	if flushErr != nil {
+		return fmt.Errorf("failed to flush file; %w", flushErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to close file; %w", closeErr)
+	}
+	return nil
+}
+
+// compress gzips the file located at @path to `@path.gz` removing the
+// original.
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open rotated file; %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		return fmt.Errorf("failed to create compressed file; %w", err)
+	}
+	defer dst.Close()
+
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		return fmt.Errorf("failed to compress rotated file; %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress rotated file; %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("failed to close compressed file; %w", err)
+	}
+	_ = src.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,66 @@
+package jsonl_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/sinks/jsonl"
+)
+
+func TestRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etw-jsonl")
+	require.NoError(t, err, "Failed to create temp dir")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.jsonl")
+	w, err := jsonl.New(path, jsonl.WithMaxSize(64), jsonl.WithCompression())
+	require.NoError(t, err, "Failed to create writer")
+
+	const lines = 10
+	for i := 0; i < lines; i++ {
+		require.NoError(t, w.Write(map[string]int{"sequence": i}), "Failed to write line")
+	}
+	require.NoError(t, w.Close(), "Failed to close writer")
+
+	rotated, err := filepath.Glob(filepath.Join(dir, "events-*.jsonl.gz"))
+	require.NoError(t, err)
+	require.NotEmpty(t, rotated, "No rotated files found")
+
+	// Ensure no lines are lost during rotation.
+	total := countLines(t, path, false)
+	for _, f := range rotated {
+		total += countLines(t, f, true)
+	}
+	assert.Equal(t, lines, total, "Unexpected number of lines written")
+}
+
+func countLines(t *testing.T, path string, compressed bool) int {
+	f, err := os.Open(path)
+	require.NoError(t, err, "Failed to open %s", path)
+	defer f.Close()
+
+	var r = bufio.NewReader(f)
+	if compressed {
+		zr, err := gzip.NewReader(f)
+		require.NoError(t, err, "Failed to decompress %s", path)
+		r = bufio.NewReader(zr)
+	}
+
+	count := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var v map[string]int
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &v), "Invalid line in %s", path)
+		count++
+	}
+	require.NoError(t, scanner.Err())
+	return count
+}
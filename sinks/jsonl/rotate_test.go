@@ -0,0 +1,51 @@
+package jsonl
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotationFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etw-jsonl")
+	require.NoError(t, err, "Failed to create temp dir")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.jsonl")
+	w, err := New(path, WithMaxSize(16))
+	require.NoError(t, err, "Failed to create writer")
+	renameErr := errors.New("file is locked")
+	w.rename = func(string, string) error { return renameErr }
+
+	require.NoError(t, w.Write("first line"), "Failed to write line")
+	err = w.Write("second line")
+	assert.True(t, errors.Is(err, renameErr), "Rotation error is not returned: %v", err)
+
+	// The writer keeps writing to the original file and rotates it as soon
+	// as renaming succeeds.
+	w.rename = os.Rename
+	require.NoError(t, w.Write("third line"), "Writer is not usable after failed rotation")
+	require.NoError(t, w.Close(), "Failed to close writer")
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err, "Failed to list files")
+	require.Len(t, files, 2, "Unexpected files after rotation")
+	var rotated string
+	for _, f := range files {
+		if f.Name() != "events.jsonl" {
+			rotated = filepath.Join(dir, f.Name())
+		}
+	}
+	data, err := ioutil.ReadFile(rotated)
+	require.NoError(t, err, "Failed to read rotated file")
+	assert.Equal(t, 2, strings.Count(string(data), "\n"), "Lines are lost on failed rotation")
+	data, err = ioutil.ReadFile(path)
+	require.NoError(t, err, "Failed to read file")
+	assert.Equal(t, "\"third line\"\n", string(data), "Unexpected fresh file contents")
+}
@@ -0,0 +1,43 @@
+//+build windows
+
+package etw
+
+import (
+	"time"
+)
+
+// callbackWatch measures user callback calls reporting slow ones, see
+// WithCallbackTimeout.
+type callbackWatch struct {
+	timeout time.Duration
+	onSlow  func(e *Event, took time.Duration)
+}
+
+// newCallbackWatch builds callbackWatch from @cfg.
+func newCallbackWatch(cfg SessionOptions) callbackWatch {
+	return callbackWatch{timeout: cfg.CallbackTimeout, onSlow: cfg.OnSlowCallback}
+}
+
+// watched wraps the user callback @cb with the session callbackWatch. It's
+// the innermost wrapper, so only @cb itself is measured, e.g. not waiting for
+// a free worker in CallbackParallel mode.
+func (s *Session) watched(cb EventCallback) EventCallback {
+	return func(e *Event) {
+		s.watch.Load().(callbackWatch).call(cb, e)
+	}
+}
+
+// call passes @e to @cb. If the call takes longer than the timeout it's
+// reported to onSlow while @e is still valid.
+func (w callbackWatch) call(cb EventCallback, e *Event) {
+	if w.timeout == 0 || w.onSlow == nil {
+		cb(e)
+		return
+	}
+
+	start := time.Now()
+	cb(e)
+	if took := time.Since(start); took > w.timeout {
+		w.onSlow(e, took)
+	}
+}
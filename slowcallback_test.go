@@ -0,0 +1,47 @@
+// +build windows
+
+package etw
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCallbackWatch ensures that only callbacks exceeding the timeout are
+// reported and the report gets the same event.
+func TestCallbackWatch(t *testing.T) {
+	var (
+		reported []*Event
+		took     time.Duration
+	)
+	w := newCallbackWatch(SessionOptions{
+		CallbackTimeout: 20 * time.Millisecond,
+		OnSlowCallback: func(e *Event, d time.Duration) {
+			reported = append(reported, e)
+			took = d
+		},
+	})
+
+	fast, slow := &Event{}, &Event{}
+	var called []*Event
+	w.call(func(e *Event) { called = append(called, e) }, fast)
+	w.call(func(e *Event) {
+		called = append(called, e)
+		time.Sleep(50 * time.Millisecond)
+	}, slow)
+	assert.Equal(t, []*Event{fast, slow}, called, "Callback is not called")
+	assert.Equal(t, []*Event{slow}, reported, "Unexpected slow callbacks reported")
+	assert.True(t, took >= 50*time.Millisecond, "Unexpected slow callback duration %s", took)
+
+	// Calls aren't measured without a timeout or a handler.
+	reported = nil
+	for _, cfg := range []SessionOptions{
+		{OnSlowCallback: func(e *Event, _ time.Duration) { reported = append(reported, e) }},
+		{CallbackTimeout: time.Nanosecond},
+	} {
+		newCallbackWatch(cfg).call(func(*Event) { time.Sleep(time.Millisecond) }, slow)
+	}
+	assert.Empty(t, reported, "Unmeasured callback is reported")
+}
@@ -0,0 +1,360 @@
+//+build windows
+
+package etw
+
+/*
+	#include <stdlib.h>
+	#include "session.h"
+*/
+import "C"
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// maxStackWalkEvents is the maximum number of kernel events TraceSetInformation
+// accepts for stack tracing.
+const maxStackWalkEvents = 256
+
+// stackWalkOpcode is an opcode of StackWalk_Event the kernel logs right after
+// the event its stack is captured for.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/etw/stackwalk-event
+const stackWalkOpcode = 32
+
+// StackWalkGUID identifies the StackWalk kernel event class. Its events carry
+// call stacks requested with WithKernelStackWalk. Such events are consumed
+// by the session and never passed to EventCallback.
+//
+//nolint:gochecknoglobals
+var StackWalkGUID = windows.GUID{
+	Data1: 0xdef2fe46,
+	Data2: 0x7bd6,
+	Data3: 0x4b80,
+	Data4: [8]byte{0xbd, 0x94, 0xf5, 0x7f, 0xe2, 0x0d, 0x0c, 0xe3},
+}
+
+// StackWalkFlag selects a kernel event to capture call stacks for: the
+// kernel event class (i.e. the event EventHeader.ProviderID) and the event
+// type (i.e. EventHeader.OpCode).
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/evntrace/ns-evntrace-classic_event_id
+type StackWalkFlag struct {
+	EventGUID windows.GUID
+	Type      uint8
+}
+
+// Kernel events commonly inspected with call stacks, e.g. to find out which
+// driver or module has started a process or loaded an image.
+//
+//nolint:gochecknoglobals
+var (
+	StackWalkProcessCreate = StackWalkFlag{EventGUID: processClassGUID, Type: 1}
+	StackWalkProcessExit   = StackWalkFlag{EventGUID: processClassGUID, Type: 2}
+	StackWalkThreadCreate  = StackWalkFlag{EventGUID: threadClassGUID, Type: 1}
+	StackWalkImageLoad     = StackWalkFlag{EventGUID: imageClassGUID, Type: 10}
+	StackWalkFileCreate    = StackWalkFlag{EventGUID: fileIoClassGUID, Type: 64}
+	StackWalkRegCreateKey  = StackWalkFlag{EventGUID: registryClassGUID, Type: 10}
+	StackWalkTcpConnect    = StackWalkFlag{EventGUID: tcpIPClassGUID, Type: 12}
+)
+
+// Kernel event classes.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/etw/nt-kernel-logger-constants
+//
+//nolint:gochecknoglobals
+var (
+	processClassGUID = windows.GUID{
+		Data1: 0x3d6fa8d0,
+		Data2: 0xfe05,
+		Data3: 0x11d0,
+		Data4: [8]byte{0x9d, 0xda, 0x00, 0xc0, 0x4f, 0xd7, 0xba, 0x7c},
+	}
+	threadClassGUID = windows.GUID{
+		Data1: 0x3d6fa8d1,
+		Data2: 0xfe05,
+		Data3: 0x11d0,
+		Data4: [8]byte{0x9d, 0xda, 0x00, 0xc0, 0x4f, 0xd7, 0xba, 0x7c},
+	}
+	imageClassGUID = windows.GUID{
+		Data1: 0x2cb15d1d,
+		Data2: 0x5fc1,
+		Data3: 0x11d2,
+		Data4: [8]byte{0xab, 0xe1, 0x00, 0xa0, 0xc9, 0x11, 0xf5, 0x18},
+	}
+	fileIoClassGUID = windows.GUID{
+		Data1: 0x90cbdc39,
+		Data2: 0x4a3e,
+		Data3: 0x11d1,
+		Data4: [8]byte{0x84, 0xf4, 0x00, 0x00, 0xf8, 0x04, 0x64, 0xe3},
+	}
+	registryClassGUID = windows.GUID{
+		Data1: 0xae53722e,
+		Data2: 0xc863,
+		Data3: 0x11d2,
+		Data4: [8]byte{0x86, 0x59, 0x00, 0xc0, 0x4f, 0xa3, 0x21, 0xa1},
+	}
+	tcpIPClassGUID = windows.GUID{
+		Data1: 0x9a280ac0,
+		Data2: 0xc8e0,
+		Data3: 0x11d1,
+		Data4: [8]byte{0x84, 0xe2, 0x00, 0xc0, 0x4f, 0xb9, 0x98, 0xa2},
+	}
+)
+
+// WithKernelStackWalk makes the NT Kernel Logger capture call stacks of the
+// kernel events selected by @flags, e.g. StackWalkProcessCreate. The stack
+// is available as ExtendedEventInfo.StackTrace of the event, its addresses
+// include kernel mode ones, which helps to analyse drivers and rootkits.
+// Events themselves should be enabled with the session kernel flags.
+//
+// The kernel logs a stack in a separate event following the selected one,
+// so the selected events are delayed until their stacks arrive (or any other
+// event of the same thread does, or a couple of seconds pass). StackWalk
+// events bypass the session Go-side filters (WithChannels, WithOpcodes).
+// Up to 256 events could be selected.
+//
+// The option is valid for NewKernelSession only, it's taken from NewSession
+// options and ignored by `.UpdateOptions`.
+func WithKernelStackWalk(flags ...StackWalkFlag) Option {
+	return func(cfg *SessionOptions) {
+		cfg.KernelStackWalk = append(cfg.KernelStackWalk, flags...)
+	}
+}
+
+// setKernelStackWalk wraps TraceSetInformation with TraceStackTracingInfo
+// to enable stack tracing of SessionOptions.KernelStackWalk events.
+func (s *Session) setKernelStackWalk() error {
+	flags := s.config.KernelStackWalk
+	if len(flags) == 0 {
+		return nil
+	}
+	if !s.isKernel() {
+		return fmt.Errorf("kernel stack walk is supported by NT Kernel Logger session only")
+	}
+	if len(flags) > maxStackWalkEvents {
+		return fmt.Errorf("too many kernel stack walk events %d, at most %d are allowed",
+			len(flags), maxStackWalkEvents)
+	}
+	revert, err := s.impersonate()
+	if err != nil {
+		return err
+	}
+	defer revert()
+
+	events := make([]C.CLASSIC_EVENT_ID, len(flags))
+	for i, f := range flags {
+		events[i].EventGuid = *(*C.GUID)(unsafe.Pointer(&f.EventGUID))
+		events[i].Type = C.UCHAR(f.Type)
+	}
+	// ULONG WMIAPI TraceSetInformation(
+	//	TRACEHANDLE      SessionHandle,
+	//	TRACE_INFO_CLASS InformationClass,
+	//	PVOID            TraceInformation,
+	//	ULONG            InformationLength
+	// );
+	//
+	// Ref: https://docs.microsoft.com/en-us/windows/win32/api/evntrace/nf-evntrace-tracesetinformation
+	ret := C.TraceSetInformation(
+		s.hSession,
+		C.TraceStackTracingInfo,
+		C.PVOID(unsafe.Pointer(&events[0])),
+		C.ULONG(len(events)*int(unsafe.Sizeof(events[0]))))
+	if status := windows.Errno(ret); status != windows.ERROR_SUCCESS {
+		return fmt.Errorf("TraceSetInformation(TraceStackTracingInfo) failed; %w", status)
+	}
+	return nil
+}
+
+// stackWalkHoldTimeout limits the time the correlator holds an event for
+// its stack. Stacks are logged right after their events, but buffers of
+// different processors are delivered with up to a flush timer delay, so
+// the timeout is counted by timestamps of the delivered events.
+const stackWalkHoldTimeout = 2 * time.Second
+
+// stackWalkMaxSkew is the maximum difference between the timestamp of a
+// held event and the EventTimeStamp of a StackWalk event converted to the
+// system time to consider the stack captured for the event.
+const stackWalkMaxSkew = 16 * time.Millisecond
+
+//nolint:gochecknoglobals
+var (
+	procQueryPerformanceCounter   = kernel32.NewProc("QueryPerformanceCounter")
+	procQueryPerformanceFrequency = kernel32.NewProc("QueryPerformanceFrequency")
+)
+
+// stampClock converts raw QPC timestamps StackWalk events refer their
+// events with to the system time ETW converts event timestamps to. It's
+// synchronized with the local clocks initially and with ETW timestamps on
+// every matched stack then, so the clocks drift doesn't accumulate.
+type stampClock struct {
+	freq      int64 // Zero if QPC is unavailable.
+	base      time.Time
+	baseStamp int64
+}
+
+// newStampClock synchronizes a stampClock with the local clocks.
+func newStampClock() stampClock {
+	var freq, stamp int64
+	if ok, _, _ := procQueryPerformanceFrequency.Call(uintptr(unsafe.Pointer(&freq))); ok == 0 {
+		return stampClock{}
+	}
+	if ok, _, _ := procQueryPerformanceCounter.Call(uintptr(unsafe.Pointer(&stamp))); ok == 0 {
+		return stampClock{}
+	}
+	return stampClock{freq: freq, base: time.Now(), baseStamp: stamp}
+}
+
+// matches returns true if the raw @stamp denotes the same moment as @t. A
+// clock without QPC frequency matches anything.
+func (c *stampClock) matches(stamp int64, t time.Time) bool {
+	if c.freq <= 0 {
+		return true
+	}
+	delta := stamp - c.baseStamp
+	sec, rem := delta/c.freq, delta%c.freq
+	converted := c.base.Add(time.Duration(sec)*time.Second + time.Duration(rem*int64(time.Second)/c.freq))
+	skew := t.Sub(converted)
+	return -stackWalkMaxSkew <= skew && skew <= stackWalkMaxSkew
+}
+
+// sync makes the clock convert the raw @stamp to exactly @t.
+func (c *stampClock) sync(stamp int64, t time.Time) {
+	c.base, c.baseStamp = t, stamp
+}
+
+// stackWalkCorrelator attaches stacks from StackWalk events to the kernel
+// events they were captured for. The kernel logs a stack right after the
+// event on the same thread, so the last selected event of every thread is
+// held (copied) until a StackWalk event with its timestamp, the next event
+// of the thread or stackWalkHoldTimeout.
+//
+// The correlator is called sequentially from the processing goroutine.
+type stackWalkCorrelator struct {
+	cb       EventCallback
+	selected map[StackWalkFlag]bool
+	held     map[uint32]*Event // By thread ID.
+	clock    stampClock
+
+	// lastExpire is the timestamp of the event held ones were last expired
+	// on.
+	lastExpire time.Time
+}
+
+// newStackWalkCorrelator returns a correlator passing events selected by
+// @flags with stacks attached and all the other ones to @cb.
+func newStackWalkCorrelator(cb EventCallback, flags []StackWalkFlag) *stackWalkCorrelator {
+	c := &stackWalkCorrelator{
+		cb:       cb,
+		selected: make(map[StackWalkFlag]bool, len(flags)),
+		held:     make(map[uint32]*Event),
+		clock:    newStampClock(),
+	}
+	for _, f := range flags {
+		c.selected[f] = true
+	}
+	return c
+}
+
+// isStackWalk returns true if @h is a header of the StackWalk event.
+func isStackWalk(h *EventHeader) bool {
+	return h.ProviderID == StackWalkGUID && h.OpCode == stackWalkOpcode
+}
+
+// handle is an EventCallback of the correlator.
+func (c *stackWalkCorrelator) handle(e *Event) {
+	c.expire(e.Header.TimeStamp)
+	if isStackWalk(&e.Header) {
+		data, err := e.UserData()
+		if err != nil {
+			return
+		}
+		stamp, thread, stack, ok := parseStackWalk(data, e.Header.PointerSize())
+		if !ok {
+			return
+		}
+		// Stacks of events we don't hold, e.g. lost ones, are dropped.
+		if held := c.held[thread]; held != nil && c.clock.matches(stamp, held.Header.TimeStamp) {
+			c.clock.sync(stamp, held.Header.TimeStamp)
+			held.stackTrace = stack
+			c.release(thread)
+		}
+		return
+	}
+
+	c.release(e.Header.ThreadID)
+	if !c.selected[StackWalkFlag{EventGUID: e.Header.ProviderID, Type: e.Header.OpCode}] {
+		c.cb(e)
+		return
+	}
+	record := C.CopyEventRecord(e.eventRecord)
+	if record == nil {
+		c.cb(e)
+		return
+	}
+	held := *e
+	held.eventRecord = record
+	c.held[e.Header.ThreadID] = &held
+}
+
+// expire releases events held for longer than stackWalkHoldTimeout by the
+// time of the event delivered at @now. It's cheap to call on every event as
+// the held ones are checked once in half of the timeout only.
+func (c *stackWalkCorrelator) expire(now time.Time) {
+	if now.Sub(c.lastExpire) < stackWalkHoldTimeout/2 {
+		return
+	}
+	c.lastExpire = now
+	for thread, e := range c.held {
+		if now.Sub(e.Header.TimeStamp) >= stackWalkHoldTimeout {
+			c.release(thread)
+		}
+	}
+}
+
+// release passes the held event of the @thread to the callback.
+func (c *stackWalkCorrelator) release(thread uint32) {
+	e, ok := c.held[thread]
+	if !ok {
+		return
+	}
+	delete(c.held, thread)
+	c.cb(e)
+	C.free(unsafe.Pointer(e.eventRecord))
+	e.eventRecord = nil
+}
+
+// flush passes all the held events to the callback. Their stacks will never
+// arrive once the processing is over.
+func (c *stackWalkCorrelator) flush() {
+	for thread := range c.held {
+		c.release(thread)
+	}
+}
+
+// parseStackWalk decodes StackWalk_Event payload @data with @ptrSize
+// addresses: the raw timestamp of the event, the process and the thread IDs
+// followed by the stack addresses.
+func parseStackWalk(data []byte, ptrSize int) (int64, uint32, *EventStackTrace, bool) {
+	const headerSize = 16
+	if len(data) < headerSize || (ptrSize != 4 && ptrSize != 8) {
+		return 0, 0, nil, false
+	}
+	stamp := int64(binary.LittleEndian.Uint64(data))
+	thread := binary.LittleEndian.Uint32(data[12:])
+	stack := &EventStackTrace{
+		Addresses: make([]uint64, 0, (len(data)-headerSize)/ptrSize),
+	}
+	for offset := headerSize; offset+ptrSize <= len(data); offset += ptrSize {
+		if ptrSize == 4 {
+			stack.Addresses = append(stack.Addresses, uint64(binary.LittleEndian.Uint32(data[offset:])))
+		} else {
+			stack.Addresses = append(stack.Addresses, binary.LittleEndian.Uint64(data[offset:]))
+		}
+	}
+	return stamp, thread, stack, true
+}
@@ -0,0 +1,102 @@
+// +build windows
+
+package etw
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/rawrecord"
+)
+
+// TestStackWalkCorrelator ensures that stacks are attached to the events
+// with the matching timestamp and held events are released on timeout.
+func TestStackWalkCorrelator(t *testing.T) {
+	const (
+		freq      = 10000000 // 100ns ticks.
+		baseStamp = 1000
+	)
+	base := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	stampOf := func(t time.Time) int64 {
+		return baseStamp + int64(t.Sub(base)/100)
+	}
+
+	type delivered struct {
+		thread uint32
+		stack  []uint64
+	}
+	var got []delivered
+	c := newStackWalkCorrelator(func(e *Event) {
+		d := delivered{thread: e.Header.ThreadID}
+		if e.stackTrace != nil {
+			d.stack = e.stackTrace.Addresses
+		}
+		got = append(got, d)
+	}, []StackWalkFlag{StackWalkProcessCreate})
+	c.clock = stampClock{freq: freq, base: base, baseStamp: baseStamp}
+
+	feed := func(header EventHeader, data []byte) {
+		ParseRawRecord(&rawrecord.Record{Header: newRawHeader(header), UserData: data}, c.handle)
+	}
+	selected := func(thread uint32, ts time.Time) {
+		feed(EventHeader{
+			EventDescriptor: EventDescriptor{OpCode: StackWalkProcessCreate.Type},
+			ProviderID:      StackWalkProcessCreate.EventGUID,
+			ThreadID:        thread,
+			TimeStamp:       ts,
+		}, []byte{1, 2, 3, 4})
+	}
+	stackWalk := func(thread uint32, stamp int64, ts time.Time, addresses ...uint64) {
+		data := make([]byte, 16+8*len(addresses))
+		binary.LittleEndian.PutUint64(data, uint64(stamp))
+		binary.LittleEndian.PutUint32(data[12:], thread)
+		for i, a := range addresses {
+			binary.LittleEndian.PutUint64(data[16+8*i:], a)
+		}
+		feed(EventHeader{
+			EventDescriptor: EventDescriptor{OpCode: stackWalkOpcode},
+			ProviderID:      StackWalkGUID,
+			ThreadID:        thread,
+			TimeStamp:       ts,
+		}, data)
+	}
+
+	// The stack of another event of the thread (e.g. a lost one) is dropped.
+	eventTime := base.Add(time.Millisecond)
+	selected(7, eventTime)
+	stackWalk(7, stampOf(eventTime.Add(time.Second)), eventTime.Add(time.Second), 0xBAD)
+	require.Empty(t, got, "Event is released on a stack of another event")
+	stackWalk(7, stampOf(eventTime), eventTime, 0x1000, 0x2000)
+	require.Len(t, got, 1, "Event is not released on its stack")
+	assert.Equal(t, delivered{thread: 7, stack: []uint64{0x1000, 0x2000}}, got[0])
+
+	// Events without stacks are released once the hold timeout passes.
+	selected(8, base.Add(10*time.Millisecond))
+	feed(EventHeader{ThreadID: 9, TimeStamp: base.Add(10*time.Millisecond + stackWalkHoldTimeout)}, nil)
+	require.Len(t, got, 3, "Held event is not released on timeout")
+	assert.Equal(t, delivered{thread: 8}, got[1])
+	assert.Equal(t, delivered{thread: 9}, got[2])
+
+	// The rest is released on flush.
+	selected(10, base.Add(3*time.Second))
+	require.Len(t, got, 3)
+	c.flush()
+	require.Len(t, got, 4, "Held event is not released on flush")
+	assert.Equal(t, delivered{thread: 10}, got[3])
+}
+
+// TestStackWalkFilter ensures that StackWalk events bypass Go-side filters.
+func TestStackWalkFilter(t *testing.T) {
+	filter := newEventFilter(SessionOptions{Opcodes: []uint8{1}, Channels: []uint8{16}})
+	h := EventHeader{
+		EventDescriptor: EventDescriptor{OpCode: stackWalkOpcode},
+		ProviderID:      StackWalkGUID,
+	}
+	assert.True(t, filter.match(&h), "StackWalk event is filtered")
+	h.ProviderID = processClassGUID
+	assert.False(t, filter.match(&h), "Other event is not filtered")
+}
@@ -0,0 +1,141 @@
+//+build windows
+
+package etw
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// ProviderStats holds health counters of a single provider subscription. It
+// lets detect silent providers and decoding problems.
+//
+// Counters are kept for the whole session lifetime and survive provider
+// options updates and re-subscriptions.
+type ProviderStats struct {
+	// EventsReceived is a number of events delivered by ETW. Having several
+	// consumers (concurrent `.Process` calls) every delivery is counted.
+	EventsReceived uint64
+
+	// EventsDropped is a number of events dropped by the session filters
+	// (WithChannels, WithOpcodes) before reaching the EventCallback. Events
+	// lost by ETW itself are not attributed to providers, take a look at
+	// TraceProperties.EventsLost for them.
+	EventsDropped uint64
+
+	// ParseAttempts and ParseFailures count Event.EventProperties calls and
+	// the ones that failed (including partial failures in best-effort mode).
+	ParseAttempts uint64
+	ParseFailures uint64
+
+	// PayloadBytes is a total size of received events payload.
+	PayloadBytes uint64
+
+	// LastEventTime is a timestamp of the last received event. Zero if
+	// nothing has been received yet.
+	LastEventTime time.Time
+}
+
+// ParseFailureRate returns a share of failed EventProperties calls.
+func (s ProviderStats) ParseFailureRate() float64 {
+	if s.ParseAttempts == 0 {
+		return 0
+	}
+	return float64(s.ParseFailures) / float64(s.ParseAttempts)
+}
+
+// AveragePayloadSize returns an average size of received events payload.
+func (s ProviderStats) AveragePayloadSize() float64 {
+	if s.EventsReceived == 0 {
+		return 0
+	}
+	return float64(s.PayloadBytes) / float64(s.EventsReceived)
+}
+
+// providerCounters is a concurrently updated storage for ProviderStats.
+type providerCounters struct {
+	received      uint64
+	dropped       uint64
+	parseAttempts uint64
+	parseFailures uint64
+	payloadBytes  uint64
+	lastEventTime int64 // UnixNano.
+}
+
+func (c *providerCounters) recordEvent(h *EventHeader, payloadSize int) {
+	atomic.AddUint64(&c.received, 1)
+	atomic.AddUint64(&c.payloadBytes, uint64(payloadSize))
+	atomic.StoreInt64(&c.lastEventTime, h.TimeStamp.UnixNano())
+}
+
+func (c *providerCounters) recordParse(failed bool) {
+	atomic.AddUint64(&c.parseAttempts, 1)
+	if failed {
+		atomic.AddUint64(&c.parseFailures, 1)
+	}
+}
+
+func (c *providerCounters) snapshot() ProviderStats {
+	stats := ProviderStats{
+		EventsReceived: atomic.LoadUint64(&c.received),
+		EventsDropped:  atomic.LoadUint64(&c.dropped),
+		ParseAttempts:  atomic.LoadUint64(&c.parseAttempts),
+		ParseFailures:  atomic.LoadUint64(&c.parseFailures),
+		PayloadBytes:   atomic.LoadUint64(&c.payloadBytes),
+	}
+	if ts := atomic.LoadInt64(&c.lastEventTime); ts != 0 {
+		stats.LastEventTime = time.Unix(0, ts)
+	}
+	return stats
+}
+
+// providerCounters returns counters of the provider @guid creating them if
+// necessary.
+func (s *Session) providerCounters(guid windows.GUID) *providerCounters {
+	if c, ok := s.stats.Load(guid); ok {
+		return c.(*providerCounters)
+	}
+	c, _ := s.stats.LoadOrStore(guid, &providerCounters{})
+	return c.(*providerCounters)
+}
+
+// ProviderStats returns health counters of the provider @guid or false if
+// no events of the provider have been received yet.
+func (s *Session) ProviderStats(guid windows.GUID) (ProviderStats, bool) {
+	c, ok := s.stats.Load(guid)
+	if !ok {
+		return ProviderStats{}, false
+	}
+	return c.(*providerCounters).snapshot(), true
+}
+
+// StatsVar returns an expvar.Var that renders stats of all session providers
+// as a JSON object keyed by provider GUID strings. Stats of a labeled
+// session carry its Labels. Publish it to expose the stats via /debug/vars:
+//
+//		expvar.Publish("etw_providers", session.StatsVar())
+func (s *Session) StatsVar() expvar.Var {
+	type providerVar struct {
+		ProviderStats
+		ParseFailureRate   float64
+		AveragePayloadSize float64
+		Labels             map[string]string `json:",omitempty"`
+	}
+	return expvar.Func(func() interface{} {
+		vars := make(map[string]providerVar)
+		s.stats.Range(func(key, value interface{}) bool {
+			stats := value.(*providerCounters).snapshot()
+			vars[key.(windows.GUID).String()] = providerVar{
+				ProviderStats:      stats,
+				ParseFailureRate:   stats.ParseFailureRate(),
+				AveragePayloadSize: stats.AveragePayloadSize(),
+				Labels:             s.labels,
+			}
+			return true
+		})
+		return vars
+	})
+}
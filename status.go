@@ -0,0 +1,155 @@
+//+build windows
+
+package etw
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// statusBufferSize is a number of events the channel returned by
+// Session.Status buffers. The channel has one more slot reserved for
+// StatusClosed, so it's always delivered.
+const statusBufferSize = 64
+
+// RTLostEventGUID identifies events ETW delivers to real-time consumers
+// when the session loses events, buffers or its log file. Opcodes of the
+// events are LossKind values.
+//
+//nolint:gochecknoglobals
+var RTLostEventGUID = windows.GUID{
+	Data1: 0x6a399ae0,
+	Data2: 0x4bc6,
+	Data3: 0x4de9,
+	Data4: [8]byte{0x87, 0x0b, 0x36, 0x57, 0xf8, 0x94, 0x7e, 0x7e},
+}
+
+// LossKind tells what a real-time session has lost.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/etw/rt-lostevent
+type LossKind uint8
+
+const (
+	// LossEvents means events were lost by the session (RT_LostEvent), e.g.
+	// because all its buffers were full.
+	LossEvents LossKind = 32
+
+	// LossBuffer means a whole buffer was lost in transit to the real-time
+	// consumer (RT_LostBuffer).
+	LossBuffer LossKind = 33
+
+	// LossFile means the backing file of the real-time session is corrupted
+	// (RT_LostFile), so events buffered there are lost.
+	LossFile LossKind = 34
+)
+
+// StatusKind is a type of session lifecycle StatusEvent.
+type StatusKind int
+
+const (
+	// StatusStarted is sent when the first `.Process` call has enabled the
+	// session providers.
+	StatusStarted StatusKind = iota + 1
+
+	// StatusProviderEnabled is sent every time a provider is enabled or its
+	// subscription is updated. StatusEvent.ProviderID is set.
+	StatusProviderEnabled
+
+	// StatusEventsLost is sent on every loss reported by ETW to real-time
+	// consumers. StatusEvent.Loss is set. The loss is reported once per
+	// consumer, so several `.Process` calls report it several times.
+	StatusEventsLost
+
+	// StatusStopped is sent when the session has been stopped by someone
+	// else, e.g. by `logman stop` or KillSession.
+	StatusStopped
+
+	// StatusRestarted is sent after an attempt to recreate the stopped
+	// session according to WithAutoRestart. StatusEvent.Restart is set and
+	// StatusEvent.Err is non-nil if the attempt has failed.
+	StatusRestarted
+
+	// StatusClosed is the last event sent when `.Close` is called.
+	StatusClosed
+)
+
+// String returns a name of the status kind.
+func (k StatusKind) String() string {
+	switch k {
+	case StatusStarted:
+		return "started"
+	case StatusProviderEnabled:
+		return "provider enabled"
+	case StatusEventsLost:
+		return "events lost"
+	case StatusStopped:
+		return "externally stopped"
+	case StatusRestarted:
+		return "restarted"
+	case StatusClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("StatusKind(%d)", int(k))
+	}
+}
+
+// StatusEvent is a session lifecycle notification sent to the channel
+// returned by Session.Status.
+type StatusEvent struct {
+	Kind StatusKind
+	Time time.Time
+
+	ProviderID windows.GUID // StatusProviderEnabled only.
+	Loss       LossKind     // StatusEventsLost only.
+	Restart    int          // StatusRestarted only, starting from 1.
+	Err        error        // StatusRestarted only.
+}
+
+// Status returns a channel delivering the session lifecycle events, so
+// supervising code could react on them without scraping logs. The channel
+// is closed after StatusClosed is sent by `.Close`.
+//
+// The session never blocks on the channel: events that don't fit the
+// channel buffer (64 events) are dropped, so keep reading it. StatusClosed
+// is never dropped. All the calls return the same channel, so there should
+// be a single reader.
+func (s *Session) Status() <-chan StatusEvent {
+	return s.status
+}
+
+// sendStatus sends the event @e to the status channel unless it's full or
+// closed. The event time is set here.
+func (s *Session) sendStatus(e StatusEvent) {
+	e.Time = time.Now()
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	// The reader only frees the slots, so the length check is safe while
+	// senders are serialized.
+	if s.statusClosed || len(s.status) >= statusBufferSize {
+		return
+	}
+	s.status <- e
+}
+
+// closeStatus sends StatusClosed to the reserved slot and closes the status
+// channel.
+func (s *Session) closeStatus() {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if s.statusClosed {
+		return
+	}
+	s.statusClosed = true
+	s.status <- StatusEvent{Kind: StatusClosed, Time: time.Now()}
+	close(s.status)
+}
+
+// reportLoss sends StatusEventsLost if @h is a header of RT_LostEvent.
+func (s *Session) reportLoss(h *EventHeader) {
+	if h.ProviderID != RTLostEventGUID {
+		return
+	}
+	s.sendStatus(StatusEvent{Kind: StatusEventsLost, Loss: LossKind(h.OpCode)})
+}
@@ -0,0 +1,258 @@
+//+build windows
+
+package symbolize
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+//nolint:gochecknoglobals
+var (
+	dbghelp                 = windows.NewLazySystemDLL("dbghelp.dll")
+	procSymInitializeW      = dbghelp.NewProc("SymInitializeW")
+	procSymCleanup          = dbghelp.NewProc("SymCleanup")
+	procSymSetOptions       = dbghelp.NewProc("SymSetOptions")
+	procSymLoadModuleExW    = dbghelp.NewProc("SymLoadModuleExW")
+	procSymFromAddrW        = dbghelp.NewProc("SymFromAddrW")
+	procSymGetLineFromAddrW = dbghelp.NewProc("SymGetLineFromAddrW64")
+
+	// dbghelpSessions makes unique fake process handles of DbgHelp
+	// resolvers: dbghelp identifies its sessions by them.
+	dbghelpSessions uint32
+
+	// dbghelpMu serializes all the dbghelp calls: dbghelp is single
+	// threaded across all its sessions, not within one.
+	dbghelpMu sync.Mutex
+)
+
+// Options of dbghelp.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/dbghelp/nf-dbghelp-symsetoptions
+const (
+	symoptUndname            = 0x00000002 // SYMOPT_UNDNAME
+	symoptDeferredLoads      = 0x00000004 // SYMOPT_DEFERRED_LOADS
+	symoptLoadLines          = 0x00000010 // SYMOPT_LOAD_LINES
+	symoptFailCriticalErrors = 0x00000200 // SYMOPT_FAIL_CRITICAL_ERRORS
+	symoptNoPrompts          = 0x00080000 // SYMOPT_NO_PROMPTS
+)
+
+// maxSymbolName is a maximum length of a symbol name in characters.
+const maxSymbolName = 1024
+
+// symbolInfo is SYMBOL_INFOW followed by the name buffer.
+type symbolInfo struct {
+	SizeOfStruct uint32
+	TypeIndex    uint32
+	Reserved     [2]uint64
+	Index        uint32
+	Size         uint32
+	ModBase      uint64
+	Flags        uint32
+	_            uint32 // Explicit padding to keep the C layout on 386.
+	Value        uint64
+	Address      uint64
+	Register     uint32
+	Scope        uint32
+	Tag          uint32
+	NameLen      uint32
+	MaxNameLen   uint32
+	Name         [maxSymbolName]uint16
+}
+
+// symbolInfoSize is sizeof(SYMBOL_INFOW): the structure up to and including
+// the first name character padded to 8 bytes. dbghelp rejects other sizes.
+const symbolInfoSize = 88
+
+// imagehlpLine is IMAGEHLP_LINEW64.
+type imagehlpLine struct {
+	SizeOfStruct uint32
+	Key          uintptr
+	LineNumber   uint32
+	FileName     *uint16
+	Address      uint64
+}
+
+// DbgHelpOptions describes a DbgHelp resolver.
+type DbgHelpOptions struct {
+	// SearchPath is a dbghelp symbol search path, e.g. a local PDBs
+	// directory or `srv*C:\Symbols*https://msdl.microsoft.com/download/symbols`
+	// to download PDBs from the Microsoft symbol server (symsrv.dll should
+	// be next to dbghelp.dll then). Empty SearchPath means the dbghelp
+	// default one: the current directory and _NT_SYMBOL_PATH.
+	SearchPath string
+
+	// NoLines skips source files and lines lookup.
+	NoLines bool
+}
+
+// DbgHelp is a Resolver using dbghelp.dll. Modules are loaded on the first
+// lookup of their code, PDBs are loaded lazily.
+//
+// dbghelp is single threaded, so all the DbgHelp resolvers of the process
+// serialize their calls with a single lock. Since
+// dbghelp options are process-wide, all DbgHelp resolvers of the process
+// share them. DbgHelp should be closed via `.Close` after use.
+type DbgHelp struct {
+	process windows.Handle
+	noLines bool
+
+	// Guarded by dbghelpMu.
+	loaded map[string]uint64 // Module path -> the base it's loaded at.
+	closed bool
+}
+
+// NewDbgHelp initializes a dbghelp symbol handler.
+func NewDbgHelp(cfg DbgHelpOptions) (*DbgHelp, error) {
+	if err := dbghelp.Load(); err != nil {
+		return nil, fmt.Errorf("failed to load dbghelp.dll; %w", err)
+	}
+	dbghelpMu.Lock()
+	defer dbghelpMu.Unlock()
+
+	options := uintptr(symoptUndname | symoptDeferredLoads | symoptFailCriticalErrors | symoptNoPrompts)
+	if !cfg.NoLines {
+		options |= symoptLoadLines
+	}
+	// DWORD SymSetOptions(DWORD SymOptions);
+	_, _, _ = procSymSetOptions.Call(options)
+
+	var searchPath *uint16
+	if cfg.SearchPath != "" {
+		var err error
+		if searchPath, err = windows.UTF16PtrFromString(cfg.SearchPath); err != nil {
+			return nil, fmt.Errorf("incorrect search path; %w", err)
+		}
+	}
+	// Any unique value works as a process handle if modules are loaded
+	// explicitly.
+	process := windows.Handle(atomic.AddUint32(&dbghelpSessions, 1))
+	// BOOL SymInitializeW(HANDLE hProcess, PCWSTR UserSearchPath, BOOL fInvadeProcess);
+	r0, _, err := procSymInitializeW.Call(uintptr(process), uintptr(unsafe.Pointer(searchPath)), 0)
+	if r0 == 0 {
+		return nil, fmt.Errorf("SymInitializeW failed; %w", err)
+	}
+	return &DbgHelp{
+		process: process,
+		noLines: cfg.NoLines,
+		loaded:  make(map[string]uint64),
+	}, nil
+}
+
+// Resolve implements Resolver.
+func (d *DbgHelp) Resolve(module Module, offset uint64) (Symbol, error) {
+	dbghelpMu.Lock()
+	defer dbghelpMu.Unlock()
+	if d.closed {
+		return Symbol{}, fmt.Errorf("dbghelp resolver is closed")
+	}
+	base, err := d.load(module)
+	if err != nil {
+		return Symbol{}, err
+	}
+	address := base + offset
+
+	info := &symbolInfo{SizeOfStruct: uint32(symbolInfoSize), MaxNameLen: maxSymbolName}
+	var displacement uint64
+	// BOOL SymFromAddrW(HANDLE hProcess, DWORD64 Address, PDWORD64 Displacement, PSYMBOL_INFOW Symbol);
+	r0, _, err := procSymFromAddrW.Call(
+		uintptr(d.process),
+		uintptr(address),
+		uintptr(unsafe.Pointer(&displacement)),
+		uintptr(unsafe.Pointer(info)))
+	if r0 == 0 {
+		return Symbol{}, fmt.Errorf("SymFromAddrW failed; %w", err)
+	}
+	nameLen := info.NameLen
+	if nameLen > maxSymbolName {
+		nameLen = maxSymbolName
+	}
+	symbol := Symbol{
+		Function:     windows.UTF16ToString(info.Name[:nameLen]),
+		Displacement: displacement,
+	}
+	if d.noLines {
+		return symbol, nil
+	}
+
+	line := &imagehlpLine{SizeOfStruct: uint32(unsafe.Sizeof(imagehlpLine{}))}
+	var lineDisplacement uint32
+	// BOOL SymGetLineFromAddrW64(HANDLE hProcess, DWORD64 dwAddr, PDWORD pdwDisplacement, PIMAGEHLP_LINEW64 Line);
+	r0, _, _ = procSymGetLineFromAddrW.Call(
+		uintptr(d.process),
+		uintptr(address),
+		uintptr(unsafe.Pointer(&lineDisplacement)),
+		uintptr(unsafe.Pointer(line)))
+	if r0 != 0 {
+		symbol.File = utf16PtrToString(line.FileName)
+		symbol.Line = int(line.LineNumber)
+	}
+	return symbol, nil
+}
+
+// load loads @module into the dbghelp session once. Modules of different
+// processes could overlap, so every module gets its own fake base.
+// dbghelpMu should be held.
+func (d *DbgHelp) load(module Module) (uint64, error) {
+	if base, ok := d.loaded[module.Path]; ok {
+		return base, nil
+	}
+	path, err := windows.UTF16PtrFromString(module.Path)
+	if err != nil {
+		return 0, fmt.Errorf("incorrect module path; %w", err)
+	}
+	// There's no real process behind the session, so bases only have to
+	// keep modules apart: 4GB steps fit any image.
+	const (
+		firstBase = 0x100000000000
+		baseStep  = 0x100000000
+	)
+	base := firstBase + uint64(len(d.loaded))*baseStep
+	// DWORD64 SymLoadModuleExW(HANDLE hProcess, HANDLE hFile, PCWSTR ImageName, PCWSTR ModuleName,
+	//	DWORD64 BaseOfDll, DWORD DllSize, PMODLOAD_DATA Data, DWORD Flags);
+	r0, _, err := procSymLoadModuleExW.Call(
+		uintptr(d.process),
+		0,
+		uintptr(unsafe.Pointer(path)),
+		0,
+		uintptr(base),
+		uintptr(module.Size),
+		0,
+		0)
+	if r0 == 0 {
+		return 0, fmt.Errorf("SymLoadModuleExW failed for %q; %w", module.Path, err)
+	}
+	d.loaded[module.Path] = base
+	return base, nil
+}
+
+// utf16PtrToString converts a NUL terminated UTF16 string @p to string.
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	var chars []uint16
+	for ptr := unsafe.Pointer(p); *(*uint16)(ptr) != 0; ptr = unsafe.Pointer(uintptr(ptr) + 2) {
+		chars = append(chars, *(*uint16)(ptr))
+	}
+	return windows.UTF16ToString(chars)
+}
+
+// Close frees the dbghelp symbol handler.
+func (d *DbgHelp) Close() error {
+	dbghelpMu.Lock()
+	defer dbghelpMu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	// BOOL SymCleanup(HANDLE hProcess);
+	if r0, _, err := procSymCleanup.Call(uintptr(d.process)); r0 == 0 {
+		return fmt.Errorf("SymCleanup failed; %w", err)
+	}
+	return nil
+}
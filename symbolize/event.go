@@ -0,0 +1,43 @@
+//+build windows
+
+package symbolize
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bi-zone/etw"
+	"github.com/bi-zone/etw/enrich"
+)
+
+// systemRootPrefix prefixes paths of kernel modules loaded at boot.
+const systemRootPrefix = `\SystemRoot\`
+
+// Stack symbolizes @stack of an event logged by the process @pid using
+// modules from @modules: frames are formatted by Symbolizer.Frame and
+// addresses out of known modules as "0xaddress".
+func Stack(s *Symbolizer, stack etw.EventStackTrace, modules *etw.ModuleMap, pid uint32) []string {
+	frames := make([]string, len(stack.Addresses))
+	for i, addr := range stack.Addresses {
+		m, ok := modules.Lookup(pid, addr)
+		if !ok {
+			frames[i] = fmt.Sprintf("0x%x", addr)
+			continue
+		}
+		frames[i] = s.Frame(FromETW(m), addr-m.Base)
+	}
+	return frames
+}
+
+// FromETW converts a module of etw.ModuleMap to Module. Kernel device paths
+// are converted to DOS ones dbghelp is able to open.
+func FromETW(m etw.Module) Module {
+	path := m.FileName
+	if strings.HasPrefix(path, systemRootPrefix) {
+		path = os.Getenv("SystemRoot") + `\` + path[len(systemRootPrefix):]
+	} else if resolved, err := enrich.ResolveDevicePath(path); err == nil {
+		path = resolved
+	}
+	return Module{Name: m.Name(), Path: path, Base: m.Base, Size: m.Size}
+}
@@ -0,0 +1,148 @@
+// Package symbolize resolves code addresses of stack traces, e.g. ones
+// captured with etw.WithKernelStackWalk, to function names using PDBs.
+// It's kept apart from the etw package as symbolization is heavy: it loads
+// dbghelp, downloads and parses PDBs.
+//
+// The package is built around a pluggable Resolver. DbgHelp one (Windows
+// only) uses dbghelp.dll with local or symbol server PDBs:
+//
+//		resolver, err := symbolize.NewDbgHelp(symbolize.DbgHelpOptions{
+//			SearchPath: `srv*C:\Symbols*https://msdl.microsoft.com/download/symbols`,
+//		})
+//		...
+//		symbolizer := symbolize.New(resolver)
+//		frames := symbolize.Stack(symbolizer, stack, modules, e.Header.ProcessID)
+package symbolize
+
+import (
+	"fmt"
+	"sync"
+)
+
+// defaultCacheSize is a default maximum number of cached symbols.
+const defaultCacheSize = 65536
+
+// Module is an executable image loaded into a process.
+type Module struct {
+	Name string // File name, e.g. "ntdll.dll".
+	Path string // Full path PDBs are looked up for.
+	Base uint64
+	Size uint64
+}
+
+// Symbol is a resolved function of a code address.
+type Symbol struct {
+	Function string
+
+	// Displacement is an offset of the address from the function start.
+	Displacement uint64
+
+	// File and Line are a source location of the address if PDB has them.
+	File string
+	Line int
+}
+
+// Resolver resolves the code located at @offset from the @module image base.
+type Resolver interface {
+	Resolve(module Module, offset uint64) (Symbol, error)
+}
+
+// ResolverFunc is an adapter to use ordinary functions as Resolver.
+type ResolverFunc func(module Module, offset uint64) (Symbol, error)
+
+// Resolve calls f(module, offset).
+func (f ResolverFunc) Resolve(module Module, offset uint64) (Symbol, error) {
+	return f(module, offset)
+}
+
+// Options describes a Symbolizer.
+type Options struct {
+	// CacheSize is a maximum number of cached symbols. 65536 by default.
+	CacheSize int
+}
+
+// Option is any function that modifies Options.
+type Option func(cfg *Options)
+
+// WithCacheSize caches at most @n symbols.
+func WithCacheSize(n int) Option {
+	return func(cfg *Options) {
+		cfg.CacheSize = n
+	}
+}
+
+// cacheKey identifies a code address regardless of the module base, so the
+// same code loaded at different addresses in several processes is resolved
+// once.
+type cacheKey struct {
+	path   string
+	offset uint64
+}
+
+// cacheEntry is a cached resolution result.
+type cacheEntry struct {
+	symbol Symbol
+	err    error
+}
+
+// Symbolizer formats stack frames resolving them with a Resolver. Results,
+// including failures, are cached. Symbolizer is safe for concurrent use if
+// its Resolver is.
+type Symbolizer struct {
+	resolver Resolver
+	cfg      Options
+
+	mu    sync.Mutex
+	cache map[cacheKey]cacheEntry
+}
+
+// New creates a Symbolizer using @resolver.
+func New(resolver Resolver, options ...Option) *Symbolizer {
+	cfg := Options{CacheSize: defaultCacheSize}
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	return &Symbolizer{
+		resolver: resolver,
+		cfg:      cfg,
+		cache:    make(map[cacheKey]cacheEntry),
+	}
+}
+
+// Resolve returns the symbol of the code at @offset from the @module base.
+func (s *Symbolizer) Resolve(module Module, offset uint64) (Symbol, error) {
+	key := cacheKey{path: module.Path, offset: offset}
+	s.mu.Lock()
+	entry, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok {
+		return entry.symbol, entry.err
+	}
+
+	symbol, err := s.resolver.Resolve(module, offset)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cache[key]; !ok && len(s.cache) >= s.cfg.CacheSize {
+		for k := range s.cache {
+			delete(s.cache, k)
+			break
+		}
+	}
+	s.cache[key] = cacheEntry{symbol: symbol, err: err}
+	return symbol, err
+}
+
+// Frame formats the code at @offset from the @module base as
+// "module!function+0xdisplacement", e.g. "ntdll.dll!RtlUserThreadStart+0x21".
+// Addresses that can't be resolved are formatted as "module!0xoffset".
+func (s *Symbolizer) Frame(module Module, offset uint64) string {
+	symbol, err := s.Resolve(module, offset)
+	if err != nil || symbol.Function == "" {
+		return fmt.Sprintf("%s!0x%x", module.Name, offset)
+	}
+	if symbol.Displacement == 0 {
+		return module.Name + "!" + symbol.Function
+	}
+	return fmt.Sprintf("%s!%s+0x%x", module.Name, symbol.Function, symbol.Displacement)
+}
@@ -0,0 +1,44 @@
+package symbolize_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bi-zone/etw/symbolize"
+)
+
+func TestSymbolizer(t *testing.T) {
+	var calls int
+	resolver := symbolize.ResolverFunc(func(module symbolize.Module, offset uint64) (symbolize.Symbol, error) {
+		calls++
+		switch offset {
+		case 0x1000:
+			return symbolize.Symbol{Function: "RtlUserThreadStart"}, nil
+		case 0x1021:
+			return symbolize.Symbol{Function: "RtlUserThreadStart", Displacement: 0x21}, nil
+		default:
+			return symbolize.Symbol{}, errors.New("no symbol")
+		}
+	})
+	symbolizer := symbolize.New(resolver, symbolize.WithCacheSize(2))
+	ntdll := symbolize.Module{Name: "ntdll.dll", Path: `C:\Windows\System32\ntdll.dll`, Base: 0x7ff800000000}
+
+	assert.Equal(t, "ntdll.dll!RtlUserThreadStart", symbolizer.Frame(ntdll, 0x1000))
+	assert.Equal(t, "ntdll.dll!RtlUserThreadStart+0x21", symbolizer.Frame(ntdll, 0x1021))
+	assert.Equal(t, 2, calls, "Unexpected number of resolutions")
+
+	// The same module loaded at another base in another process is cached.
+	other := ntdll
+	other.Base = 0x7ff900000000
+	assert.Equal(t, "ntdll.dll!RtlUserThreadStart+0x21", symbolizer.Frame(other, 0x1021))
+	assert.Equal(t, 2, calls, "Cached symbol is resolved again")
+
+	// Failures are cached as well, the cache size is limited.
+	assert.Equal(t, "ntdll.dll!0x5000", symbolizer.Frame(ntdll, 0x5000))
+	assert.Equal(t, "ntdll.dll!0x5000", symbolizer.Frame(ntdll, 0x5000))
+	assert.Equal(t, 3, calls, "Failure is not cached")
+	_, err := symbolizer.Resolve(ntdll, 0x5000)
+	assert.Error(t, err, "Cached failure is lost")
+}
@@ -0,0 +1,96 @@
+// +build windows
+
+package etw
+
+import (
+	"github.com/bi-zone/etw/rawrecord"
+)
+
+// Helpers below build synthetic TraceLogging events to check the properties
+// parser without a real provider. They are used by parser tests and fuzz
+// targets in `fuzz_test.go`.
+
+// fuzzSchema is a TraceLogging metadata of the event used to check the
+// parser against corrupted payloads. It covers the most of property kinds:
+// strings, integers, counted arrays and structures.
+//
+// Format reference: TraceLoggingProvider.h, "Event metadata" section.
+//
+//nolint:gochecknoglobals
+var fuzzSchema = buildTLSchema("FuzzEvent",
+	tlField{name: "string", inType: tlInUnicodeString},
+	tlField{name: "ansi", inType: tlInAnsiString},
+	tlField{name: "uint32", inType: tlInUInt32},
+	tlField{name: "float64", inType: tlInDouble},
+	tlField{name: "array", inType: tlInUInt16 | tlVCount},
+	tlField{name: "blob", inType: tlInBinary},
+	tlField{name: "struct", inType: tlInStruct | tlOutFollows, outType: 2}, // 2 fields
+	tlField{name: "guid", inType: tlInGUID},
+	tlField{name: "sid", inType: tlInSID},
+)
+
+// fuzzParse builds a synthetic TraceLogging event having @schema as
+// metadata and @data as a payload and runs the properties parser on it.
+func fuzzParse(schema, data []byte, options ...ParseOption) (properties map[string]interface{}, err error) {
+	withSyntheticEvent(schema, data, func(e *Event) {
+		properties, err = e.EventProperties(options...)
+	})
+	return properties, err
+}
+
+// withSyntheticEvent builds a synthetic TraceLogging event having @schema as
+// metadata and @data as a payload and passes it to @fn. The event is valid
+// only inside @fn.
+func withSyntheticEvent(schema, data []byte, fn func(e *Event)) {
+	r := rawrecord.Record{
+		UserData: data,
+		Extended: []rawrecord.ExtendedItem{{Type: uint16(EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL), Data: schema}},
+	}
+	r.Header.Channel = 11 // WINEVENT_CHANNEL_TRACELOGGING
+	r.Header.Level = uint8(TRACE_LEVEL_INFORMATION)
+	ParseRawRecord(&r, fn)
+}
+
+// TraceLogging InType values and flags.
+//
+// Ref: TraceLoggingProvider.h
+const (
+	tlInUnicodeString = 1
+	tlInAnsiString    = 2
+	tlInUInt16        = 6
+	tlInInt32         = 7
+	tlInUInt32        = 8
+	tlInDouble        = 12
+	tlInBinary        = 14
+	tlInGUID          = 15
+	tlInSID           = 19
+	tlInStruct        = 22
+
+	tlVCount     = 0x40 // Variable length array with a count before the data.
+	tlOutFollows = 0x80 // OutType (or a field count for structures) follows.
+)
+
+// tlField describes a single field of TraceLogging event. For structures
+// outType holds a number of the structure fields following it.
+type tlField struct {
+	name    string
+	inType  byte
+	outType byte
+}
+
+// buildTLSchema encodes TraceLogging event metadata.
+func buildTLSchema(name string, fields ...tlField) []byte {
+	buf := []byte{0, 0, 0} // Size placeholder and empty tags.
+	buf = append(buf, name...)
+	buf = append(buf, 0)
+	for _, f := range fields {
+		buf = append(buf, f.name...)
+		buf = append(buf, 0, f.inType)
+		if f.inType&tlOutFollows != 0 {
+			buf = append(buf, f.outType)
+		}
+	}
+	buf[0] = byte(len(buf))
+	buf[1] = byte(len(buf) >> 8)
+	return buf
+}
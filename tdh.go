@@ -0,0 +1,41 @@
+//+build windows
+
+package etw
+
+/*
+	#include "session.h"
+*/
+import "C"
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// formatPropertyArgs are arguments of TdhFormatProperty.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/tdh/nf-tdh-tdhformatproperty
+type formatPropertyArgs struct {
+	eventInfo      unsafe.Pointer
+	mapInfo        unsafe.Pointer
+	pointerSize    uintptr
+	inType         uintptr
+	outType        uintptr
+	propertyLength uintptr
+	userDataLength uintptr
+	userData       unsafe.Pointer
+	bufferSize     *C.int
+	buffer         unsafe.Pointer
+	consumed       *C.int
+}
+
+// formatProperty calls TdhFormatProperty with @args. TdhFormatProperty is
+// either linked directly (with `tdh_linked` build tag) or resolved once on
+// the package initialization; if it's unavailable formatProperty returns
+// ERROR_PROC_NOT_FOUND, tdhError explains why.
+func formatProperty(args *formatPropertyArgs) windows.Errno {
+	if tdhError != nil {
+		return windows.ERROR_PROC_NOT_FOUND
+	}
+	return tdhFormatProperty(args)
+}
@@ -0,0 +1,46 @@
+//+build windows,!tdh_linked
+
+package etw
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Most mingw versions don't declare TdhFormatProperty, so by default it's
+// resolved dynamically. It's done once on initialization to keep the
+// lookup out of the per-property path and to fail with a clear error.
+//
+//nolint:gochecknoglobals
+var tdhFormatPropertyAddr, tdhError = resolveTdhFormatProperty()
+
+// resolveTdhFormatProperty returns an address of TdhFormatProperty.
+func resolveTdhFormatProperty() (uintptr, error) {
+	proc := windows.NewLazySystemDLL("tdh.dll").NewProc("TdhFormatProperty")
+	if err := proc.Find(); err != nil {
+		return 0, fmt.Errorf("TdhFormatProperty is unavailable, events can't be parsed; %w", err)
+	}
+	return proc.Addr(), nil
+}
+
+// tdhFormatProperty calls the resolved TdhFormatProperty.
+func tdhFormatProperty(args *formatPropertyArgs) windows.Errno {
+	r0, _, _ := syscall.Syscall12(tdhFormatPropertyAddr, 11,
+		uintptr(args.eventInfo),
+		uintptr(args.mapInfo),
+		args.pointerSize,
+		args.inType,
+		args.outType,
+		args.propertyLength,
+		args.userDataLength,
+		uintptr(args.userData),
+		uintptr(unsafe.Pointer(args.bufferSize)),
+		uintptr(args.buffer),
+		uintptr(unsafe.Pointer(args.consumed)),
+		0,
+	)
+	return windows.Errno(r0)
+}
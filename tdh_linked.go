@@ -0,0 +1,44 @@
+//+build windows,tdh_linked
+
+package etw
+
+/*
+	#include "session.h"
+
+	static ULONG formatPropertyLinked(PVOID eventInfo, PVOID mapInfo, ULONG pointerSize,
+		USHORT inType, USHORT outType, USHORT propertyLength, USHORT userDataLength,
+		PVOID userData, PULONG bufferSize, PVOID buffer, PUSHORT consumed) {
+		return TdhFormatProperty((PTRACE_EVENT_INFO)eventInfo, (PEVENT_MAP_INFO)mapInfo, pointerSize,
+			inType, outType, propertyLength, userDataLength,
+			(PBYTE)userData, bufferSize, (PWCHAR)buffer, consumed);
+	}
+*/
+import "C"
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// TdhFormatProperty is linked directly with `tdh_linked` build tag. It
+// requires a toolchain declaring it in tdh.h, e.g. a recent mingw-w64.
+//
+//nolint:gochecknoglobals
+var tdhError error
+
+// tdhFormatProperty calls the linked TdhFormatProperty.
+func tdhFormatProperty(args *formatPropertyArgs) windows.Errno {
+	return windows.Errno(C.formatPropertyLinked(
+		args.eventInfo,
+		args.mapInfo,
+		C.ULONG(args.pointerSize),
+		C.USHORT(args.inType),
+		C.USHORT(args.outType),
+		C.USHORT(args.propertyLength),
+		C.USHORT(args.userDataLength),
+		args.userData,
+		(*C.ULONG)(unsafe.Pointer(args.bufferSize)),
+		args.buffer,
+		(*C.USHORT)(unsafe.Pointer(args.consumed)),
+	))
+}
@@ -0,0 +1,58 @@
+//+build windows
+
+package etw
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/windows"
+)
+
+// PropertyErrorKey identifies a kind of property the parser failed to
+// format: the event it belongs to and its TDH input type. Classic (MOF)
+// events have zero EventID, their type is told by OpCode.
+//
+// For TDH input types reference check _TDH_IN_TYPE docs:
+// https://docs.microsoft.com/en-us/windows/win32/api/tdh/ne-tdh-_tdh_in_type
+type PropertyErrorKey struct {
+	ProviderID windows.GUID
+	EventID    uint16
+	OpCode     uint8
+	InType     uint16
+}
+
+// We want to collect property errors across all sessions to let users
+// prioritize decoders coverage based on a real world data.
+//
+//nolint:gochecknoglobals
+var propertyErrors sync.Map // PropertyErrorKey -> *uint64
+
+// PropertyErrorStats returns a snapshot of counters of property formatting
+// failures collected since the process start (or the last
+// ResetPropertyErrorStats call) aggregated by PropertyErrorKey.
+func PropertyErrorStats() map[PropertyErrorKey]uint64 {
+	stats := make(map[PropertyErrorKey]uint64)
+	propertyErrors.Range(func(key, value interface{}) bool {
+		stats[key.(PropertyErrorKey)] = atomic.LoadUint64(value.(*uint64))
+		return true
+	})
+	return stats
+}
+
+// ResetPropertyErrorStats clears all collected property error counters.
+func ResetPropertyErrorStats() {
+	propertyErrors.Range(func(key, _ interface{}) bool {
+		propertyErrors.Delete(key)
+		return true
+	})
+}
+
+// recordPropertyError increments a counter of formatting failures for @key.
+func recordPropertyError(key PropertyErrorKey) {
+	counter, ok := propertyErrors.Load(key)
+	if !ok {
+		counter, _ = propertyErrors.LoadOrStore(key, new(uint64))
+	}
+	atomic.AddUint64(counter.(*uint64), 1)
+}
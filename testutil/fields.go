@@ -0,0 +1,190 @@
+//+build windows
+
+package testutil
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// TraceLogging in-types and out-types.
+//
+// Ref: TraceLoggingProvider.h
+const (
+	inUnicodeString = 1
+	inAnsiString    = 2
+	inUInt16        = 6
+	inInt32         = 7
+	inUInt32        = 8
+	inInt64         = 9
+	inUInt64        = 10
+	inDouble        = 12
+	inBool32        = 13
+	inBinary        = 14
+	inGUID          = 15
+	inFileTime      = 17
+	inSID           = 19
+	inHexInt32      = 20
+	inHexInt64      = 21
+
+	outPort = 7
+	outIPv4 = 8
+	outIPv6 = 9
+
+	outTypeFollows = 0x80 // InType flag: OutType follows.
+)
+
+// Field is a single TraceLogging event field: its name, TraceLogging in-type
+// and out-type (zero means the in-type default) and the encoded value.
+type Field struct {
+	Name    string
+	InType  uint8
+	OutType uint8
+	Value   []byte
+}
+
+// String returns a field holding @value as a null-terminated UTF-16 string.
+func String(name, value string) Field {
+	chars := utf16.Encode([]rune(value + "\x00"))
+	buf := make([]byte, 2*len(chars))
+	for i, c := range chars {
+		binary.LittleEndian.PutUint16(buf[2*i:], c)
+	}
+	return Field{Name: name, InType: inUnicodeString, Value: buf}
+}
+
+// AnsiString returns a field holding @value as a null-terminated ANSI
+// string.
+func AnsiString(name, value string) Field {
+	return Field{Name: name, InType: inAnsiString, Value: append([]byte(value), 0)}
+}
+
+// Int32 returns a field holding a signed 32-bit integer.
+func Int32(name string, value int32) Field {
+	return Field{Name: name, InType: inInt32, Value: le32(uint32(value))}
+}
+
+// Uint32 returns a field holding an unsigned 32-bit integer.
+func Uint32(name string, value uint32) Field {
+	return Field{Name: name, InType: inUInt32, Value: le32(value)}
+}
+
+// Int64 returns a field holding a signed 64-bit integer.
+func Int64(name string, value int64) Field {
+	return Field{Name: name, InType: inInt64, Value: le64(uint64(value))}
+}
+
+// Uint64 returns a field holding an unsigned 64-bit integer.
+func Uint64(name string, value uint64) Field {
+	return Field{Name: name, InType: inUInt64, Value: le64(value)}
+}
+
+// HexInt32 returns a field holding a 32-bit integer formatted as hex.
+func HexInt32(name string, value uint32) Field {
+	return Field{Name: name, InType: inHexInt32, Value: le32(value)}
+}
+
+// HexInt64 returns a field holding a 64-bit integer formatted as hex.
+func HexInt64(name string, value uint64) Field {
+	return Field{Name: name, InType: inHexInt64, Value: le64(value)}
+}
+
+// Double returns a field holding a 64-bit floating point number.
+func Double(name string, value float64) Field {
+	return Field{Name: name, InType: inDouble, Value: le64(math.Float64bits(value))}
+}
+
+// Bool returns a field holding a 32-bit boolean.
+func Bool(name string, value bool) Field {
+	var v uint32
+	if value {
+		v = 1
+	}
+	return Field{Name: name, InType: inBool32, Value: le32(v)}
+}
+
+// Binary returns a field holding @value as a binary blob. TraceLogging
+// binaries are prefixed with their 16-bit size.
+func Binary(name string, value []byte) Field {
+	return Field{Name: name, InType: inBinary, Value: counted(value)}
+}
+
+// GUID returns a field holding a GUID.
+func GUID(name string, value windows.GUID) Field {
+	buf := make([]byte, 16)
+	binary.LittleEndian.PutUint32(buf, value.Data1)
+	binary.LittleEndian.PutUint16(buf[4:], value.Data2)
+	binary.LittleEndian.PutUint16(buf[6:], value.Data3)
+	copy(buf[8:], value.Data4[:])
+	return Field{Name: name, InType: inGUID, Value: buf}
+}
+
+// FileTime returns a field holding @value as a FILETIME.
+func FileTime(name string, value time.Time) Field {
+	ft := windows.NsecToFiletime(value.UnixNano())
+	return Field{Name: name, InType: inFileTime, Value: le64(uint64(ft.HighDateTime)<<32 | uint64(ft.LowDateTime))}
+}
+
+// SID returns a field holding a security identifier.
+func SID(name string, value *windows.SID) Field {
+	// SIDs are variable-sized Go allocations, so copy them with CopySid
+	// rather than via a fake array cast that trips checkptr.
+	buf := make([]byte, value.Len())
+	_ = windows.CopySid(uint32(len(buf)), (*windows.SID)(unsafe.Pointer(&buf[0])), value) // The buffer always fits.
+	return Field{Name: name, InType: inSID, Value: buf}
+}
+
+// IPv4 returns a field holding an IPv4 address. Non-IPv4 addresses are
+// written as 0.0.0.0.
+func IPv4(name string, ip net.IP) Field {
+	buf := make([]byte, 4)
+	copy(buf, ip.To4())
+	return Field{Name: name, InType: inUInt32, OutType: outIPv4, Value: buf}
+}
+
+// IPv6 returns a field holding an IPv6 address.
+func IPv6(name string, ip net.IP) Field {
+	return Field{Name: name, InType: inBinary, OutType: outIPv6, Value: counted(ip.To16())}
+}
+
+// Port returns a field holding a TCP/UDP port in the network byte order.
+func Port(name string, port uint16) Field {
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, port)
+	return Field{Name: name, InType: inUInt16, OutType: outPort, Value: buf}
+}
+
+// metadata appends the field TraceLogging metadata to @buf.
+func (f Field) metadata(buf []byte) []byte {
+	buf = append(buf, f.Name...)
+	buf = append(buf, 0)
+	if f.OutType == 0 {
+		return append(buf, f.InType)
+	}
+	return append(buf, f.InType|outTypeFollows, f.OutType)
+}
+
+func le32(v uint32) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, v)
+	return buf
+}
+
+func le64(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, v)
+	return buf
+}
+
+// counted prefixes @value with its 16-bit size.
+func counted(value []byte) []byte {
+	buf := make([]byte, 2, 2+len(value))
+	binary.LittleEndian.PutUint16(buf, uint16(len(value)))
+	return append(buf, value...)
+}
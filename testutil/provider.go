@@ -0,0 +1,173 @@
+//+build windows
+
+package testutil
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/bi-zone/etw"
+)
+
+//nolint:gochecknoglobals
+var (
+	advapi32               = windows.NewLazySystemDLL("advapi32.dll")
+	procEventRegister      = advapi32.NewProc("EventRegister")
+	procEventUnregister    = advapi32.NewProc("EventUnregister")
+	procEventSetInfo       = advapi32.NewProc("EventSetInformation")
+	procEventWriteTransfer = advapi32.NewProc("EventWriteTransfer")
+)
+
+const (
+	eventProviderSetTraits = 2  // EventProviderSetTraits of EVENT_INFO_CLASS.
+	channelTraceLogging    = 11 // WINEVENT_CHANNEL_TRACELOGGING
+
+	dataDescriptorProviderMetadata = 1 // EVENT_DATA_DESCRIPTOR_TYPE_PROVIDER_METADATA
+	dataDescriptorEventMetadata    = 2 // EVENT_DATA_DESCRIPTOR_TYPE_EVENT_METADATA
+)
+
+// eventDescriptor is EVENT_DESCRIPTOR.
+type eventDescriptor struct {
+	ID      uint16
+	Version uint8
+	Channel uint8
+	Level   uint8
+	Opcode  uint8
+	Task    uint16
+	Keyword uint64
+}
+
+// dataDescriptor is EVENT_DATA_DESCRIPTOR.
+type dataDescriptor struct {
+	Ptr      uint64
+	Size     uint32
+	Type     uint8
+	reserved [3]uint8
+}
+
+// Provider is a TraceLogging provider writing events of arbitrary Fields.
+type Provider struct {
+	name   string
+	guid   windows.GUID
+	handle uint64
+	traits []byte
+}
+
+// NewProvider registers a TraceLogging provider @name. Its GUID is derived
+// from the name, see etw.ProviderGUIDFromName. Provider should be closed via
+// `.Close` after use.
+func NewProvider(name string) (*Provider, error) {
+	p := &Provider{
+		name: name,
+		guid: etw.ProviderGUIDFromName(name),
+	}
+	// ULONG EventRegister(LPCGUID ProviderId, PENABLECALLBACK EnableCallback, PVOID CallbackContext, PREGHANDLE RegHandle);
+	r0, _, _ := procEventRegister.Call(
+		uintptr(unsafe.Pointer(&p.guid)),
+		0,
+		0,
+		uintptr(unsafe.Pointer(&p.handle)),
+	)
+	if status := windows.Errno(r0); status != windows.ERROR_SUCCESS {
+		return nil, fmt.Errorf("EventRegister failed; %w", status)
+	}
+
+	// Provider traits are the provider name prefixed with the traits size.
+	p.traits = make([]byte, 2, 2+len(name)+1)
+	p.traits = append(append(p.traits, name...), 0)
+	binary.LittleEndian.PutUint16(p.traits, uint16(len(p.traits)))
+	// ULONG EventSetInformation(REGHANDLE RegHandle, EVENT_INFO_CLASS InformationClass, PVOID EventInformation, ULONG InformationLength);
+	r0, _, _ = procEventSetInfo.Call(
+		uintptr(p.handle),
+		eventProviderSetTraits,
+		uintptr(unsafe.Pointer(&p.traits[0])),
+		uintptr(len(p.traits)),
+	)
+	if status := windows.Errno(r0); status != windows.ERROR_SUCCESS {
+		_ = p.Close()
+		return nil, fmt.Errorf("EventSetInformation failed; %w", status)
+	}
+	return p, nil
+}
+
+// GUID returns the provider GUID.
+func (p *Provider) GUID() windows.GUID {
+	return p.guid
+}
+
+// WriteEvent writes an event @name with @level having @fields.
+func (p *Provider) WriteEvent(name string, level etw.TraceLevel, fields ...Field) error {
+	// Event metadata: size, tags, the event name and fields.
+	metadata := []byte{0, 0, 0}
+	metadata = append(append(metadata, name...), 0)
+	for _, f := range fields {
+		metadata = f.metadata(metadata)
+	}
+	binary.LittleEndian.PutUint16(metadata, uint16(len(metadata)))
+
+	descriptors := make([]dataDescriptor, 0, 2+len(fields))
+	descriptors = append(descriptors,
+		newDataDescriptor(p.traits, dataDescriptorProviderMetadata),
+		newDataDescriptor(metadata, dataDescriptorEventMetadata),
+	)
+	for _, f := range fields {
+		if len(f.Value) != 0 {
+			descriptors = append(descriptors, newDataDescriptor(f.Value, 0))
+		}
+	}
+
+	descriptor := eventDescriptor{
+		Channel: channelTraceLogging,
+		Level:   uint8(level),
+	}
+	// ULONG EventWriteTransfer(REGHANDLE RegHandle, PCEVENT_DESCRIPTOR EventDescriptor, LPCGUID ActivityId,
+	//                          LPCGUID RelatedActivityId, ULONG UserDataCount, PEVENT_DATA_DESCRIPTOR UserData);
+	r0, _, _ := procEventWriteTransfer.Call(
+		uintptr(p.handle),
+		uintptr(unsafe.Pointer(&descriptor)),
+		0,
+		0,
+		uintptr(len(descriptors)),
+		uintptr(unsafe.Pointer(&descriptors[0])),
+	)
+	// Descriptors refer to the data by integers, so keep it alive explicitly.
+	runtime.KeepAlive(metadata)
+	runtime.KeepAlive(fields)
+	if status := windows.Errno(r0); status != windows.ERROR_SUCCESS {
+		return fmt.Errorf("EventWriteTransfer failed; %w", status)
+	}
+	return nil
+}
+
+// Generate floods events written as WriteEvent does until @ctx is done.
+// Write errors are ignored.
+func (p *Provider) Generate(ctx context.Context, name string, level etw.TraceLevel, fields ...Field) {
+	Generate(ctx, func() {
+		_ = p.WriteEvent(name, level, fields...)
+	})
+}
+
+// Close unregisters the provider.
+func (p *Provider) Close() error {
+	// ULONG EventUnregister(REGHANDLE RegHandle);
+	r0, _, _ := procEventUnregister.Call(uintptr(p.handle))
+	if status := windows.Errno(r0); status != windows.ERROR_SUCCESS {
+		return fmt.Errorf("EventUnregister failed; %w", status)
+	}
+	return nil
+}
+
+// newDataDescriptor describes @data. @data must be kept alive until the
+// descriptor is used.
+func newDataDescriptor(data []byte, kind uint8) dataDescriptor {
+	return dataDescriptor{
+		Ptr:  uint64(uintptr(unsafe.Pointer(&data[0]))),
+		Size: uint32(len(data)),
+		Type: kind,
+	}
+}
@@ -0,0 +1,41 @@
+//+build windows
+
+// Package testutil helps to test ETW consumers with real events: it provides
+// a TraceLogging provider able to emit fields of every TDH in-type (SIDs,
+// binaries, GUIDs, FILETIMEs, IP addresses, hex integers, etc.), so parsing
+// round-trips could be validated end to end.
+//
+// There is no easy way to know that a session is ready to receive events,
+// so tests usually flood events with Generate and catch some of them:
+//
+//		p, err := testutil.NewProvider("MyTestProvider")
+//		...
+//		defer p.Close()
+//		go p.Generate(ctx, "TestEvent", etw.TRACE_LEVEL_INFORMATION,
+//			testutil.String("string", "value"),
+//			testutil.SID("sid", sid),
+//		)
+//		session, err := etw.NewSession(p.GUID())
+//		...
+package testutil
+
+import (
+	"context"
+)
+
+// Generate calls @write repeatedly until @ctx is done. It's a generator loop
+// for any provider, e.g. go-winio ones:
+//
+//		go testutil.Generate(ctx, func() {
+//			_ = provider.WriteEvent("TestEvent", nil, nil)
+//		})
+func Generate(ctx context.Context, write func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			write()
+		}
+	}
+}
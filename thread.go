@@ -0,0 +1,91 @@
+//+build windows
+
+package etw
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/windows"
+)
+
+// ThreadPriority is a priority of the OS thread relative to the process
+// priority class.
+//
+// For more info refer to SetThreadPriority docs:
+// https://docs.microsoft.com/en-us/windows/win32/api/processthreadsapi/nf-processthreadsapi-setthreadpriority
+type ThreadPriority int32
+
+//nolint:golint,stylecheck // We keep original names to underline that it's an external constants.
+const (
+	THREAD_PRIORITY_IDLE          = ThreadPriority(-15)
+	THREAD_PRIORITY_LOWEST        = ThreadPriority(-2)
+	THREAD_PRIORITY_BELOW_NORMAL  = ThreadPriority(-1)
+	THREAD_PRIORITY_NORMAL        = ThreadPriority(0)
+	THREAD_PRIORITY_ABOVE_NORMAL  = ThreadPriority(1)
+	THREAD_PRIORITY_HIGHEST       = ThreadPriority(2)
+	THREAD_PRIORITY_TIME_CRITICAL = ThreadPriority(15)
+)
+
+// threadPriorityErrorReturn is THREAD_PRIORITY_ERROR_RETURN (MAXLONG).
+const threadPriorityErrorReturn = 0x7fffffff
+
+//nolint:gochecknoglobals
+var (
+	kernel32                  = windows.NewLazySystemDLL("kernel32.dll")
+	procGetThreadPriority     = kernel32.NewProc("GetThreadPriority")
+	procSetThreadPriority     = kernel32.NewProc("SetThreadPriority")
+	procSetThreadAffinityMask = kernel32.NewProc("SetThreadAffinityMask")
+)
+
+// lockProcessingThread locks the calling goroutine to its OS thread (ETW
+// calls the EventCallback on the thread that calls ProcessTrace) and applies
+// thread priority and affinity from @cfg to it. Returned @restore function
+// reverts the thread settings and unlocks the thread.
+func lockProcessingThread(cfg SessionOptions) (restore func(), err error) {
+	if cfg.ThreadPriority == THREAD_PRIORITY_NORMAL && cfg.ThreadAffinity == 0 {
+		return func() {}, nil
+	}
+
+	runtime.LockOSThread()
+	var undo []func()
+	restore = func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+		runtime.UnlockOSThread()
+	}
+	// GetCurrentThread returns a pseudo handle that is always valid.
+	thread, _ := windows.GetCurrentThread()
+
+	if cfg.ThreadPriority != THREAD_PRIORITY_NORMAL {
+		prev, _, err := procGetThreadPriority.Call(uintptr(thread))
+		if int32(prev) == threadPriorityErrorReturn {
+			restore()
+			return nil, fmt.Errorf("GetThreadPriority failed; %w", err)
+		}
+		if ok, _, err := procSetThreadPriority.Call(uintptr(thread), uintptr(cfg.ThreadPriority)); ok == 0 {
+			restore()
+			return nil, fmt.Errorf("failed to set thread priority %d; %w", cfg.ThreadPriority, err)
+		}
+		undo = append(undo, func() {
+			_, _, _ = procSetThreadPriority.Call(uintptr(thread), prev)
+		})
+	}
+
+	if cfg.ThreadAffinity != 0 {
+		if uint64(uintptr(cfg.ThreadAffinity)) != cfg.ThreadAffinity {
+			restore()
+			return nil, fmt.Errorf("thread affinity %#x exceeds the pointer size", cfg.ThreadAffinity)
+		}
+		prev, _, err := procSetThreadAffinityMask.Call(uintptr(thread), uintptr(cfg.ThreadAffinity))
+		if prev == 0 {
+			restore()
+			return nil, fmt.Errorf("failed to set thread affinity %#x; %w", cfg.ThreadAffinity, err)
+		}
+		undo = append(undo, func() {
+			_, _, _ = procSetThreadAffinityMask.Call(uintptr(thread), prev)
+		})
+	}
+	return restore, nil
+}
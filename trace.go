@@ -0,0 +1,243 @@
+//+build windows
+
+package etw
+
+/*
+	#include "session.h"
+*/
+import "C"
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// TraceOptions describes an events source opened by OpenTrace. Exactly one of
+// LoggerName and LogFileName should be set.
+//
+// For more info about fields refer to EVENT_TRACE_LOGFILE docs:
+// https://docs.microsoft.com/en-us/windows/win32/api/evntrace/ns-evntrace-event_trace_logfilew
+type TraceOptions struct {
+	// LoggerName is a name of the real-time session to consume events from.
+	LoggerName string
+
+	// LogFileName is a path of the .etl file to consume events from.
+	LogFileName string
+
+	// ProcessTraceMode is a set of additional PROCESS_TRACE_MODE_* flags.
+	// PROCESS_TRACE_MODE_EVENT_RECORD is always set, PROCESS_TRACE_MODE_REAL_TIME
+	// is set implicitly for real-time sessions.
+	ProcessTraceMode uint32
+
+	// BufferCallback is called by ETW after all events of a buffer are
+	// delivered. Return false to stop the processing.
+	BufferCallback func(stats BufferStats) bool
+
+	// StartTime and EndTime limit the time range of the processed events.
+	// Zero values mean no limits.
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+//nolint:golint,stylecheck // We keep original names to underline that it's an external constants.
+const (
+	PROCESS_TRACE_MODE_REAL_TIME     = uint32(0x00000100)
+	PROCESS_TRACE_MODE_RAW_TIMESTAMP = uint32(0x00001000)
+)
+
+// errorCtxClosePending is returned by CloseTrace if the trace is still being
+// processed. ProcessTrace returns after the last buffer is processed then.
+const errorCtxClosePending = windows.Errno(7007) // ERROR_CTX_CLOSE_PENDING
+
+// BufferStats describes the processing progress reported to the
+// TraceOptions.BufferCallback.
+type BufferStats struct {
+	BuffersRead uint32
+	BufferSize  uint32
+	Filled      uint32
+	EventsLost  uint32
+}
+
+// Trace is a low-level events source opened with custom options. Prefer
+// Session and ProcessFile if they cover your needs.
+type Trace struct {
+	cfg           TraceOptions
+	handle        C.TRACEHANDLE
+	cgoKey        uintptr
+	isKernelTrace bool
+
+	// header is decoded from TRACE_LOGFILE_HEADER filled by OpenTraceW.
+	header SessionInfo
+}
+
+// OpenTrace opens an events source described by @opts. Events will be
+// passed to @cb synchronously and sequentially on `.Process` call.
+//
+// Trace should be closed via `.Close` call to free obtained OS resources.
+func OpenTrace(opts TraceOptions, cb EventCallback) (*Trace, error) {
+	if (opts.LoggerName == "") == (opts.LogFileName == "") {
+		return nil, fmt.Errorf("exactly one of LoggerName and LogFileName should be set")
+	}
+
+	var (
+		loggerName, logFileName *uint16
+		err                     error
+	)
+	mode := C.ULONG(opts.ProcessTraceMode)
+	if opts.LoggerName != "" {
+		loggerName, err = windows.UTF16PtrFromString(opts.LoggerName)
+		mode |= C.ULONG(PROCESS_TRACE_MODE_REAL_TIME)
+	} else {
+		logFileName, err = windows.UTF16PtrFromString(opts.LogFileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("incorrect trace name; %w", err)
+	}
+
+	t := &Trace{cfg: opts}
+	t.cgoKey = newCallbackKey(cb)
+	if opts.BufferCallback != nil {
+		bufferCallbacks.Store(t.cgoKey, opts.BufferCallback)
+	}
+
+	var (
+		isKernelTrace C.BOOL
+		header        C.TRACE_LOGFILE_HEADER
+	)
+	t.handle = C.OpenTraceExHelper(
+		(C.LPWSTR)(unsafe.Pointer(loggerName)),
+		(C.LPWSTR)(unsafe.Pointer(logFileName)),
+		mode,
+		boolToC(opts.BufferCallback != nil),
+		C.ULONG_PTR(t.cgoKey),
+		&isKernelTrace,
+		&header,
+	)
+	if C.INVALID_PROCESSTRACE_HANDLE == t.handle {
+		err := windows.GetLastError()
+		t.freeKeys()
+		return nil, fmt.Errorf("OpenTraceW failed; %w", err)
+	}
+	t.isKernelTrace = isKernelTrace != 0
+
+	// LoggerName and LogFileName pointers of the header are not valid, so
+	// names are taken from the options.
+	data := C.GoBytes(unsafe.Pointer(&header), C.int(unsafe.Sizeof(header)))
+	t.header, _ = parseSessionInfo(data, int(unsafe.Sizeof(uintptr(0)))) // Never fails for the whole header.
+	t.header.LoggerName = opts.LoggerName
+	t.header.LogFileName = opts.LogFileName
+	return t, nil
+}
+
+// ReadFileHeader returns the header of the .etl file located at @path
+// without processing its events.
+func ReadFileHeader(path string) (SessionInfo, error) {
+	t, err := OpenTrace(TraceOptions{LogFileName: path}, func(*Event) {})
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	header := t.Header()
+	if err := t.Close(); err != nil {
+		return SessionInfo{}, err
+	}
+	return header, nil
+}
+
+// IsKernelTrace returns true if the trace contains events from the kernel
+// logger.
+func (t *Trace) IsKernelTrace() bool {
+	return t.isKernelTrace
+}
+
+// Header returns the trace information ETW reports on opening the trace:
+// OS version, number of processors, pointer size, boot time, timer
+// resolution, etc. It's available right after OpenTrace, so consumers of
+// .etl files could prepare to interpret timestamps and pointer-sized
+// properties before `.Process` is called.
+//
+// Only a part of the fields is set for real-time sessions, take a look at
+// Session.Info to get the full information.
+func (t *Trace) Header() SessionInfo {
+	return t.header
+}
+
+// Process starts processing of the trace events.
+//
+// N.B. Process blocks until the end of the .etl file or `.Close` call!
+func (t *Trace) Process() error {
+	var startTime, endTime *C.FILETIME
+	if !t.cfg.StartTime.IsZero() {
+		ft := windows.NsecToFiletime(t.cfg.StartTime.UnixNano())
+		startTime = (*C.FILETIME)(unsafe.Pointer(&ft))
+	}
+	if !t.cfg.EndTime.IsZero() {
+		ft := windows.NsecToFiletime(t.cfg.EndTime.UnixNano())
+		endTime = (*C.FILETIME)(unsafe.Pointer(&ft))
+	}
+
+	// Ref: https://docs.microsoft.com/en-us/windows/win32/api/evntrace/nf-evntrace-processtrace
+	ret := C.ProcessTrace(C.PTRACEHANDLE(&t.handle), 1, startTime, endTime)
+	switch status := windows.Errno(ret); status {
+	case windows.ERROR_SUCCESS, windows.ERROR_CANCELLED:
+		return nil
+	default:
+		return fmt.Errorf("ProcessTrace failed; %w", status)
+	}
+}
+
+// Close closes the trace making `.Process` return. Real-time session the
+// trace is attached to is not affected.
+func (t *Trace) Close() error {
+	defer t.freeKeys()
+
+	// Ref: https://docs.microsoft.com/en-us/windows/win32/api/evntrace/nf-evntrace-closetrace
+	switch status := windows.Errno(C.CloseTrace(t.handle)); status {
+	case windows.ERROR_SUCCESS, errorCtxClosePending:
+		return nil
+	default:
+		return fmt.Errorf("CloseTrace failed; %w", status)
+	}
+}
+
+func (t *Trace) freeKeys() {
+	freeCallbackKey(t.cgoKey)
+	bufferCallbacks.Delete(t.cgoKey)
+}
+
+// Buffer callbacks are stored under the same keys as event callbacks.
+//
+//nolint:gochecknoglobals
+var bufferCallbacks sync.Map
+
+// handleBuffer is exported to guarantee C calling convention (cdecl).
+//
+// The function should be defined here but would be linked and used inside
+// C code in `session.c`.
+//
+//export handleBuffer
+func handleBuffer(logFile C.PEVENT_TRACE_LOGFILEW) C.ULONG {
+	cb, ok := bufferCallbacks.Load(uintptr(logFile.Context))
+	if !ok {
+		return 1 // TRUE to continue processing.
+	}
+	stats := BufferStats{
+		BuffersRead: uint32(logFile.BuffersRead),
+		BufferSize:  uint32(logFile.BufferSize),
+		Filled:      uint32(logFile.Filled),
+		EventsLost:  uint32(logFile.EventsLost),
+	}
+	if cb.(func(BufferStats) bool)(stats) {
+		return 1
+	}
+	return 0
+}
+
+func boolToC(b bool) C.BOOL {
+	if b {
+		return 1
+	}
+	return 0
+}
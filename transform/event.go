@@ -0,0 +1,18 @@
+//+build windows
+
+package transform
+
+import (
+	"github.com/bi-zone/etw"
+)
+
+// EventProperties parses properties of @e and applies the rules to them.
+// Like etw.Event.EventProperties it's valid only inside etw.EventCallback.
+func (t *Transformer) EventProperties(e *etw.Event, options ...etw.ParseOption) (map[string]interface{}, error) {
+	props, err := e.EventProperties(options...)
+	if err != nil {
+		return nil, err
+	}
+	t.Apply(e.Header.ProviderID.String(), e.Header.ID, props)
+	return props, nil
+}
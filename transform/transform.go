@@ -0,0 +1,173 @@
+// Package transform implements redaction and renaming of parsed event
+// properties. It's intended to drop or anonymize sensitive fields (command
+// lines, user names, etc.) before events leave the host.
+//
+// Rules are matched by provider and event ID and applied to the property map
+// returned by etw.Event.EventProperties:
+//
+//		t, err := transform.New(
+//			transform.Rule{Provider: "{22fb2cd6-0e7b-422b-a0c7-2fad1fd0e716}", Field: "CommandLine", Action: transform.Hash},
+//			transform.Rule{Field: "UserName", Action: transform.Drop},
+//		)
+//		...
+//		props, err := t.EventProperties(e)
+//
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Action defines what to do with a matched field.
+type Action int
+
+const (
+	// Drop removes the field.
+	Drop Action = iota + 1
+	// Hash replaces the field value with a hex-encoded salted SHA-256 hash of
+	// its string representation.
+	Hash
+	// Truncate cuts string values to Rule.Length characters.
+	Truncate
+	// Rename moves the field value under Rule.NewName.
+	Rename
+)
+
+// Rule describes a single transformation.
+type Rule struct {
+	// Provider is a GUID string of the provider whose events are affected.
+	// Braces and case are ignored. Empty Provider matches any provider.
+	Provider string
+
+	// EventIDs limits affected events. Empty EventIDs matches any event.
+	EventIDs []uint16
+
+	// Field is a name of the affected property. Fields of nested structures
+	// are addressed with dots, e.g. `struct.field`.
+	Field string
+
+	Action Action
+
+	// Length is a maximum number of characters left by Truncate.
+	Length int
+
+	// NewName is a new field name for Rename. It's a name inside the same
+	// structure, not a path.
+	NewName string
+}
+
+// Transformer applies the set of rules to event properties. Transformer is
+// immutable and safe for concurrent use.
+type Transformer struct {
+	rules []Rule
+	salt  []byte
+}
+
+// New creates a Transformer applying @rules in the given order.
+func New(rules ...Rule) (*Transformer, error) {
+	t := &Transformer{rules: make([]Rule, 0, len(rules))}
+	for i, r := range rules {
+		if r.Field == "" {
+			return nil, fmt.Errorf("rule %d: empty field name", i)
+		}
+		switch r.Action {
+		case Drop, Hash:
+		case Truncate:
+			if r.Length < 0 {
+				return nil, fmt.Errorf("rule %d: negative length", i)
+			}
+		case Rename:
+			if r.NewName == "" {
+				return nil, fmt.Errorf("rule %d: empty new name", i)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action %d", i, r.Action)
+		}
+		r.Provider = normalizeGUID(r.Provider)
+		t.rules = append(t.rules, r)
+	}
+	return t, nil
+}
+
+// WithSalt returns a copy of the Transformer that salts hashed values with
+// @salt, so hashes can't be reversed using precomputed tables.
+func (t *Transformer) WithSalt(salt []byte) *Transformer {
+	c := *t
+	c.salt = append([]byte(nil), salt...)
+	return &c
+}
+
+// Apply transforms @props of the event @eventID produced by @provider (a GUID
+// string) in place.
+func (t *Transformer) Apply(provider string, eventID uint16, props map[string]interface{}) {
+	provider = normalizeGUID(provider)
+	for _, r := range t.rules {
+		if !r.matches(provider, eventID) {
+			continue
+		}
+		container, name := lookup(props, r.Field)
+		if container == nil {
+			continue
+		}
+		value, ok := container[name]
+		if !ok {
+			continue
+		}
+
+		switch r.Action {
+		case Drop:
+			delete(container, name)
+		case Hash:
+			h := sha256.New()
+			h.Write(t.salt)
+			fmt.Fprint(h, value)
+			container[name] = hex.EncodeToString(h.Sum(nil))
+		case Truncate:
+			if s, ok := value.(string); ok {
+				if runes := []rune(s); len(runes) > r.Length {
+					container[name] = string(runes[:r.Length])
+				}
+			}
+		case Rename:
+			delete(container, name)
+			container[r.NewName] = value
+		}
+	}
+}
+
+func (r Rule) matches(provider string, eventID uint16) bool {
+	if r.Provider != "" && r.Provider != provider {
+		return false
+	}
+	if len(r.EventIDs) == 0 {
+		return true
+	}
+	for _, id := range r.EventIDs {
+		if id == eventID {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns a map holding the field addressed by @path and the field
+// name inside it. Returns nil if any of intermediate structures is missing.
+func lookup(props map[string]interface{}, path string) (map[string]interface{}, string) {
+	parts := strings.Split(path, ".")
+	container := props
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := container[p].(map[string]interface{})
+		if !ok {
+			return nil, ""
+		}
+		container = next
+	}
+	return container, parts[len(parts)-1]
+}
+
+func normalizeGUID(guid string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(guid), "{}"))
+}
@@ -0,0 +1,51 @@
+package transform_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bi-zone/etw/transform"
+)
+
+func TestApply(t *testing.T) {
+	const provider = "{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}"
+	tr, err := transform.New(
+		transform.Rule{Provider: provider, EventIDs: []uint16{1}, Field: "CommandLine", Action: transform.Truncate, Length: 3},
+		transform.Rule{Field: "UserName", Action: transform.Drop},
+		transform.Rule{Field: "struct.Secret", Action: transform.Hash},
+		transform.Rule{Field: "Image", Action: transform.Rename, NewName: "ImageName"},
+	)
+	require.NoError(t, err, "Failed to create transformer")
+
+	props := map[string]interface{}{
+		"CommandLine": "cmd.exe /c whoami",
+		"UserName":    "admin",
+		"Image":       "cmd.exe",
+		"struct": map[string]interface{}{
+			"Secret": "password",
+		},
+	}
+	tr.Apply("22fb2cd6-0e7b-422b-a0c7-2fad1fd0e716", 1, props)
+
+	assert.Equal(t, "cmd", props["CommandLine"], "Field is not truncated")
+	assert.NotContains(t, props, "UserName", "Field is not dropped")
+	assert.NotContains(t, props, "Image", "Field is not renamed")
+	assert.Equal(t, "cmd.exe", props["ImageName"], "Field is not renamed")
+	hashed := props["struct"].(map[string]interface{})["Secret"]
+	assert.Len(t, hashed, 64, "Nested field is not hashed")
+	assert.NotEqual(t, "password", hashed, "Nested field is not hashed")
+
+	// Rules limited with provider and event ID should not affect others.
+	other := map[string]interface{}{"CommandLine": "cmd.exe /c whoami"}
+	tr.Apply(provider, 2, other)
+	assert.Equal(t, "cmd.exe /c whoami", other["CommandLine"], "Unexpected transformation")
+}
+
+func TestNewValidation(t *testing.T) {
+	_, err := transform.New(transform.Rule{Field: "x", Action: transform.Rename})
+	assert.Error(t, err, "Rename without a new name should be rejected")
+	_, err = transform.New(transform.Rule{Action: transform.Drop})
+	assert.Error(t, err, "Rule without a field should be rejected")
+}
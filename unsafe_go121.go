@@ -0,0 +1,23 @@
+//go:build windows && go1.21
+// +build windows,go1.21
+
+package etw
+
+import (
+	"unsafe"
+)
+
+// Slices over C and TDH buffers are made with unsafe.Slice, the only way
+// to get them that is safe for checkptr (enabled by -race). The module
+// declares go 1.13, so only Go 1.21+ lets this file use unsafe.Slice: it
+// takes the language version from the build constraint.
+
+// utf16Slice returns a slice of @n UTF16 characters starting at @ptr.
+func utf16Slice(ptr unsafe.Pointer, n int) []uint16 {
+	return unsafe.Slice((*uint16)(ptr), n)
+}
+
+// byteSlice returns a slice of @n bytes starting at @ptr.
+func byteSlice(ptr unsafe.Pointer, n int) []byte {
+	return unsafe.Slice((*byte)(ptr), n)
+}
@@ -0,0 +1,30 @@
+//go:build windows && !go1.21
+// +build windows,!go1.21
+
+package etw
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// Older Go has no unsafe.Slice. The usual "fake cast" to a huge array, ref:
+// https://github.com/golang/go/wiki/cgo#turning-c-arrays-into-go-slices
+// makes checkptr report straddling allocations on Go buffers, so slices
+// are built from their headers instead.
+
+// utf16Slice returns a slice of @n UTF16 characters starting at @ptr.
+func utf16Slice(ptr unsafe.Pointer, n int) []uint16 {
+	var s []uint16
+	h := (*reflect.SliceHeader)(unsafe.Pointer(&s))
+	h.Data, h.Len, h.Cap = uintptr(ptr), n, n
+	return s
+}
+
+// byteSlice returns a slice of @n bytes starting at @ptr.
+func byteSlice(ptr unsafe.Pointer, n int) []byte {
+	var s []byte
+	h := (*reflect.SliceHeader)(unsafe.Pointer(&s))
+	h.Data, h.Len, h.Cap = uintptr(ptr), n, n
+	return s
+}
@@ -0,0 +1,119 @@
+//+build windows
+
+package etw
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/windows"
+)
+
+// Value maps are registered per provider property and shared by all
+// sessions, like keyword labels.
+//
+//nolint:gochecknoglobals
+var (
+	valueMapsMu    sync.Mutex // Serializes registrations.
+	valueMaps      sync.Map   // valueMapKey -> ValueMap
+	valueMapsCount int32      // Number of registered maps to skip lookups if none.
+)
+
+// valueMapKey identifies a property of the provider.
+type valueMapKey struct {
+	provider windows.GUID
+	property string
+}
+
+// ValueMap maps integer property values to friendly names, e.g. status
+// codes to their names.
+type ValueMap struct {
+	// Values are names of the values. For bitmaps keys are flag masks.
+	Values map[uint64]string
+
+	// Bitmap makes the value be mapped to names of all flags it has set
+	// instead of the name of the equal value.
+	Bitmap bool
+}
+
+// RegisterValueMap sets the value map of the @property of the provider
+// identified by @providerGUID replacing the previously registered one. Nil
+// @m.Values unregisters the map.
+//
+// Registered maps are used for integer properties the provider has no map
+// for (TdhGetEventMapInformation returns ERROR_NOT_FOUND), e.g. providers
+// shipping incomplete manifests. Properties are matched by name at any
+// structure nesting level.
+func RegisterValueMap(providerGUID windows.GUID, property string, m ValueMap) {
+	key := valueMapKey{provider: providerGUID, property: property}
+	valueMapsMu.Lock()
+	defer valueMapsMu.Unlock()
+	_, registered := valueMaps.Load(key)
+	if m.Values == nil {
+		if registered {
+			valueMaps.Delete(key)
+			atomic.AddInt32(&valueMapsCount, -1)
+		}
+		return
+	}
+	values := make(map[uint64]string, len(m.Values))
+	for v, name := range m.Values {
+		values[v] = name
+	}
+	valueMaps.Store(key, ValueMap{Values: values, Bitmap: m.Bitmap})
+	if !registered {
+		atomic.AddInt32(&valueMapsCount, 1)
+	}
+}
+
+// lookupValueMap returns a value map registered for the @property of the
+// @provider.
+func lookupValueMap(provider windows.GUID, property string) (ValueMap, bool) {
+	if atomic.LoadInt32(&valueMapsCount) == 0 {
+		return ValueMap{}, false
+	}
+	m, ok := valueMaps.Load(valueMapKey{provider: provider, property: property})
+	if !ok {
+		return ValueMap{}, false
+	}
+	return m.(ValueMap), true
+}
+
+// names returns names @value is mapped to. Bitmap flags are ordered by
+// their masks.
+func (m ValueMap) names(value uint64) []string {
+	if !m.Bitmap {
+		if name, ok := m.Values[value]; ok {
+			return []string{name}
+		}
+		return nil
+	}
+	masks := make([]uint64, 0, len(m.Values))
+	for mask := range m.Values {
+		if mask != 0 && value&mask == mask {
+			masks = append(masks, mask)
+		}
+	}
+	sort.Slice(masks, func(i, j int) bool { return masks[i] < masks[j] })
+	names := make([]string, len(masks))
+	for i, mask := range masks {
+		names[i] = m.Values[mask]
+	}
+	return names
+}
+
+// format renders @value as TDH renders mapped values: names of bitmaps are
+// joined with " | ", unmapped values are rendered as numbers. @signed
+// values are sign-extended to uint64, they are rendered as signed numbers.
+func (m ValueMap) format(value uint64, signed bool) string {
+	if names := m.names(value); len(names) != 0 {
+		return strings.Join(names, " | ")
+	}
+	if signed {
+		return strconv.FormatInt(int64(value), 10)
+	}
+	return strconv.FormatUint(value, 10)
+}
@@ -0,0 +1,283 @@
+//+build windows
+
+package etw
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+//nolint:gochecknoglobals
+var procEnumerateTraceGuidsEx = windows.NewLazySystemDLL("advapi32.dll").NewProc("EnumerateTraceGuidsEx")
+
+const (
+	traceGUIDQueryInfo        = 2    // TraceGuidQueryInfo of TRACE_QUERY_INFO_CLASS.
+	errorWMIGUIDNotFound      = 4200 // ERROR_WMI_GUID_NOT_FOUND
+	traceGUIDInfoSize         = 8    // sizeof(TRACE_GUID_INFO)
+	traceProviderInstanceSize = 16   // sizeof(TRACE_PROVIDER_INSTANCE_INFO)
+	traceEnableInfoSize       = 32   // sizeof(TRACE_ENABLE_INFO)
+)
+
+// defaultWatchInterval is a poll interval of WatchProviders.
+const defaultWatchInterval = time.Second
+
+// ProviderNotificationType is a kind of change of a provider state.
+type ProviderNotificationType int
+
+const (
+	// ProviderRegistered means that a process registered one more instance
+	// of the provider.
+	ProviderRegistered ProviderNotificationType = iota + 1
+
+	// ProviderUnregistered means that a provider instance was unregistered,
+	// e.g. the process hosting it exited.
+	ProviderUnregistered
+
+	// ProviderEnableChanged means that the way the provider is enabled on
+	// the session has changed, e.g. another controller modified it or the
+	// provider was disabled.
+	ProviderEnableChanged
+)
+
+// String returns a name of the notification type.
+func (t ProviderNotificationType) String() string {
+	switch t {
+	case ProviderRegistered:
+		return "ProviderRegistered"
+	case ProviderUnregistered:
+		return "ProviderUnregistered"
+	case ProviderEnableChanged:
+		return "ProviderEnableChanged"
+	default:
+		return fmt.Sprintf("ProviderNotificationType(%d)", int(t))
+	}
+}
+
+// ProviderEnableInfo describes how a provider is enabled on a session as
+// it's seen by the OS.
+//
+// For more info about fields refer to TRACE_ENABLE_INFO docs:
+// https://docs.microsoft.com/en-us/windows/win32/api/evntrace/ns-evntrace-trace_enable_info
+//
+// The OS doesn't report SourceId of enable requests. Source GUIDs set with
+// WithSourceGUID by the own sessions are available via Session.Providers.
+type ProviderEnableInfo struct {
+	IsEnabled       bool
+	Level           TraceLevel
+	LoggerID        uint16
+	EnableProperty  uint32
+	MatchAnyKeyword uint64
+	MatchAllKeyword uint64
+}
+
+// ProviderNotification describes a change of a session provider state.
+type ProviderNotification struct {
+	Type       ProviderNotificationType
+	ProviderID windows.GUID
+
+	// ProcessID is an ID of the process hosting the (un)registered provider
+	// instance. Zero for ProviderEnableChanged.
+	ProcessID uint32
+
+	// Enable is a new enable state of the provider on the session. It's
+	// zero if the provider is not enabled on the session anymore. Set for
+	// ProviderEnableChanged only.
+	Enable ProviderEnableInfo
+}
+
+// providerInstance is TRACE_PROVIDER_INSTANCE_INFO with its enable infos.
+type providerInstance struct {
+	pid    uint32
+	enable []ProviderEnableInfo
+}
+
+// providerState is a state of a provider observed by WatchProviders.
+type providerState struct {
+	instances map[uint32]int // Process ID -> number of registrations.
+	enable    ProviderEnableInfo
+}
+
+// WatchProviders polls the OS every @interval (a second if zero) for the
+// state of the session providers and reports changes to @cb: provider
+// instances (un)registrations and changes of the way providers are enabled
+// on the session. It lets agents react, e.g. re-assert desired levels with
+// `.SetLevel` if another controller has changed them.
+//
+// The state observed by the first poll is the baseline, it's not reported.
+// The baseline is taken anew after the session is restarted (take a look at
+// WithAutoRestart).
+// Providers added after WatchProviders call are watched as well, removed
+// ones are not watched anymore.
+//
+// WatchProviders blocks until @ctx is done, then it returns nil.
+func (s *Session) WatchProviders(ctx context.Context, interval time.Duration, cb func(ProviderNotification)) error {
+	if interval == 0 {
+		interval = defaultWatchInterval
+	}
+	var (
+		states     map[windows.GUID]*providerState
+		generation = -1
+	)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// A restarted session may get another logger ID and the providers
+		// are re-enabled on it, so the state is observed from scratch.
+		loggerID, restarts := s.loggerID()
+		if restarts != generation {
+			states = make(map[windows.GUID]*providerState)
+			generation = restarts
+		}
+		providers := s.Providers()
+		for guid := range providers {
+			instances, err := queryProviderInstances(guid)
+			if err != nil {
+				return fmt.Errorf("failed to query provider %s; %w", guid, err)
+			}
+			current := newProviderState(instances, loggerID)
+			if previous, ok := states[guid]; ok {
+				previous.diff(current, guid, cb)
+			}
+			states[guid] = current
+		}
+		// Forget providers removed with `.RemoveProvider`, so being added
+		// again they start with a new baseline.
+		for guid := range states {
+			if _, ok := providers[guid]; !ok {
+				delete(states, guid)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// loggerID returns the logger ID of the session along with the restart
+// generation it belongs to.
+func (s *Session) loggerID() (uint16, int) {
+	s.restartMu.Lock()
+	defer s.restartMu.Unlock()
+	return uint16(s.hSession & 0xFFFF), s.restarts // Session handle holds the logger ID.
+}
+
+// newProviderState builds a providerState of the provider @instances as
+// seen by the session @loggerID.
+func newProviderState(instances []providerInstance, loggerID uint16) *providerState {
+	state := &providerState{instances: make(map[uint32]int, len(instances))}
+	for _, instance := range instances {
+		state.instances[instance.pid]++
+		for _, info := range instance.enable {
+			if info.LoggerID == loggerID && info.IsEnabled {
+				state.enable = info
+			}
+		}
+	}
+	return state
+}
+
+// diff reports to @cb the changes between @p and the @current state of the
+// provider @guid.
+func (p *providerState) diff(current *providerState, guid windows.GUID, cb func(ProviderNotification)) {
+	for pid, count := range current.instances {
+		for i := p.instances[pid]; i < count; i++ {
+			cb(ProviderNotification{Type: ProviderRegistered, ProviderID: guid, ProcessID: pid})
+		}
+	}
+	for pid, count := range p.instances {
+		for i := current.instances[pid]; i < count; i++ {
+			cb(ProviderNotification{Type: ProviderUnregistered, ProviderID: guid, ProcessID: pid})
+		}
+	}
+	if p.enable != current.enable {
+		cb(ProviderNotification{Type: ProviderEnableChanged, ProviderID: guid, Enable: current.enable})
+	}
+}
+
+// queryProviderInstances returns registered instances of the provider @guid
+// along with the sessions enabled them. Unknown provider has no instances.
+func queryProviderInstances(guid windows.GUID) ([]providerInstance, error) {
+	buf := make([]byte, 1024)
+	for {
+		var returned uint32
+		// ULONG WMIAPI EnumerateTraceGuidsEx(
+		//  TRACE_QUERY_INFO_CLASS TraceQueryInfoClass,
+		//  PVOID                  InBuffer,
+		//  ULONG                  InBufferSize,
+		//  PVOID                  OutBuffer,
+		//  ULONG                  OutBufferSize,
+		//  PULONG                 ReturnLength
+		// );
+		r0, _, _ := procEnumerateTraceGuidsEx.Call(
+			traceGUIDQueryInfo,
+			uintptr(unsafe.Pointer(&guid)),
+			unsafe.Sizeof(guid),
+			uintptr(unsafe.Pointer(&buf[0])),
+			uintptr(len(buf)),
+			uintptr(unsafe.Pointer(&returned)),
+		)
+		switch status := windows.Errno(r0); status {
+		case windows.ERROR_SUCCESS:
+			return parseTraceGUIDInfo(buf[:returned])
+		case windows.ERROR_INSUFFICIENT_BUFFER:
+			if int(returned) <= len(buf) {
+				returned = uint32(2 * len(buf))
+			}
+			buf = make([]byte, returned)
+		case errorWMIGUIDNotFound:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("EnumerateTraceGuidsEx failed; %w", status)
+		}
+	}
+}
+
+// parseTraceGUIDInfo decodes TRACE_GUID_INFO followed by its provider
+// instances and their TRACE_ENABLE_INFO entries.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/evntrace/ns-evntrace-trace_guid_info
+func parseTraceGUIDInfo(buf []byte) ([]providerInstance, error) {
+	if len(buf) < traceGUIDInfoSize {
+		return nil, fmt.Errorf("TRACE_GUID_INFO is truncated to %d bytes", len(buf))
+	}
+	count := int(binary.LittleEndian.Uint32(buf))
+	instances := make([]providerInstance, 0, count)
+	offset := traceGUIDInfoSize
+	for i := 0; i < count; i++ {
+		if offset+traceProviderInstanceSize > len(buf) {
+			return nil, fmt.Errorf("provider instance %d is out of %d bytes", i, len(buf))
+		}
+		next := int(binary.LittleEndian.Uint32(buf[offset:]))
+		enableCount := int(binary.LittleEndian.Uint32(buf[offset+4:]))
+		instance := providerInstance{pid: binary.LittleEndian.Uint32(buf[offset+8:])}
+		for j := 0; j < enableCount; j++ {
+			start := offset + traceProviderInstanceSize + j*traceEnableInfoSize
+			if start+traceEnableInfoSize > len(buf) {
+				return nil, fmt.Errorf("enable info %d of provider instance %d is out of %d bytes", j, i, len(buf))
+			}
+			info := buf[start : start+traceEnableInfoSize]
+			instance.enable = append(instance.enable, ProviderEnableInfo{
+				IsEnabled:       binary.LittleEndian.Uint32(info) != 0,
+				Level:           TraceLevel(info[4]),
+				LoggerID:        binary.LittleEndian.Uint16(info[6:]),
+				EnableProperty:  binary.LittleEndian.Uint32(info[8:]),
+				MatchAnyKeyword: binary.LittleEndian.Uint64(info[16:]),
+				MatchAllKeyword: binary.LittleEndian.Uint64(info[24:]),
+			})
+		}
+		instances = append(instances, instance)
+		if next == 0 {
+			break
+		}
+		offset += next
+	}
+	return instances, nil
+}